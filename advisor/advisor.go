@@ -12,7 +12,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/choria-io/stream-replicator/backoff"
 	"github.com/choria-io/stream-replicator/config"
 	"github.com/choria-io/stream-replicator/idtrack"
 	"github.com/nats-io/jsm.go"
@@ -144,7 +143,7 @@ func (a *Advisor) publisher(ctx context.Context, wg *sync.WaitGroup) {
 			tries = 10
 		}
 
-		return backoff.FiveSec.For(ctx, func(try int) error {
+		return a.cfg.Backoff.RetryPolicy().For(ctx, func(try int) error {
 			if try > tries {
 				a.log.Warnf("Giving up on advisory after %d tries", try-1)
 				return nil
@@ -0,0 +1,195 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package audit records administrative actions taken using the admin API
+// or CLI to a local log and a NATS subject
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/events"
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/ksuid"
+	"github.com/sirupsen/logrus"
+)
+
+// Action is the kind of administrative action taken
+type Action string
+
+var (
+	LogLevelSetAction    Action = "log_level_set"
+	LogLevelResetAction  Action = "log_level_reset"
+	ConsumerResetAction  Action = "consumer_reset"
+	ConsumerRemoveAction Action = "consumer_remove"
+	FailoverAction       Action = "failover"
+	QuiesceAction        Action = "quiesce"
+	ResumeAction         Action = "resume"
+)
+
+// Caller describes who performed an action
+type Caller struct {
+	// Identity is the user performing the action, when known
+	Identity string
+	// Address is the network address or host the action originated from
+	Address string
+	// Via is how the action was performed like api, cli or signal
+	Via string
+}
+
+// Auditor records actions, all methods are safe to call on a nil Auditor
+type Auditor struct {
+	replicator string
+	logFile    string
+	publisher  *events.Publisher
+	nc         *nats.Conn
+	subject    string
+	mu         sync.Mutex
+	log        *logrus.Entry
+}
+
+// New creates an auditor for the replicator, records are published once Run is called
+func New(cfg *config.Audit, replicator string, log *logrus.Entry) (*Auditor, error) {
+	a := &Auditor{
+		replicator: replicator,
+		logFile:    cfg.LogFile,
+		subject:    cfg.Subject,
+		log:        log.WithField("audit", cfg.Subject),
+	}
+
+	if cfg.URL != "" {
+		var err error
+		a.publisher, err = events.New(&cfg.Events, replicator, log)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+// NewWithConnection creates an auditor that publishes records using an existing connection, used by the CLI
+func NewWithConnection(nc *nats.Conn, subject string, logFile string, log *logrus.Entry) *Auditor {
+	return &Auditor{
+		nc:      nc,
+		subject: subject,
+		logFile: logFile,
+		log:     log.WithField("audit", subject),
+	}
+}
+
+// Run connects the publisher when publishing is configured
+func (a *Auditor) Run(ctx context.Context, wg *sync.WaitGroup) error {
+	if a == nil || a.publisher == nil {
+		return nil
+	}
+
+	return a.publisher.Run(ctx, wg)
+}
+
+// Record records an action on stream, which may be empty, that failed if err is not nil
+func (a *Auditor) Record(action Action, caller Caller, stream string, message string, details map[string]string, err error) {
+	if a == nil {
+		return
+	}
+
+	id, _ := ksuid.NewRandom()
+
+	data := map[string]string{
+		"action": string(action),
+		"via":    caller.Via,
+	}
+	if caller.Identity != "" {
+		data["caller"] = caller.Identity
+	}
+	if caller.Address != "" {
+		data["address"] = caller.Address
+	}
+	for k, v := range details {
+		data[k] = v
+	}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+
+	event := &events.Event{
+		Protocol:   events.EventProtocol,
+		EventID:    id.String(),
+		Event:      events.AdminActionEvent,
+		Replicator: a.replicator,
+		Stream:     stream,
+		Timestamp:  time.Now().Unix(),
+		Message:    message,
+		Data:       data,
+	}
+
+	auditRecords.WithLabelValues(string(action), a.replicator).Inc()
+
+	werr := a.write(event)
+	if werr != nil {
+		auditErrors.WithLabelValues(string(action), a.replicator).Inc()
+		a.log.Errorf("Could not write audit record %s: %v", event.EventID, werr)
+	}
+
+	switch {
+	case a.publisher != nil:
+		a.publisher.Publish(event)
+
+	case a.nc != nil && a.subject != "":
+		perr := a.publishDirect(event)
+		if perr != nil {
+			auditErrors.WithLabelValues(string(action), a.replicator).Inc()
+			a.log.Errorf("Could not publish audit record %s: %v", event.EventID, perr)
+		}
+	}
+}
+
+func (a *Auditor) publishDirect(event *events.Event) error {
+	d, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	err = a.nc.Publish(strings.ReplaceAll(a.subject, "%s", string(event.Event)), d)
+	if err != nil {
+		return err
+	}
+
+	return a.nc.Flush()
+}
+
+// write appends the event to the audit log file
+func (a *Auditor) write(event *events.Event) error {
+	if a.logFile == "" {
+		return nil
+	}
+
+	d, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	f, err := os.OpenFile(a.logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(f, string(d))
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
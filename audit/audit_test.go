@@ -0,0 +1,135 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/events"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+func TestAudit(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Audit")
+}
+
+var _ = Describe("Audit", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+		caller = Caller{Identity: "bob", Address: "192.0.2.1:1234", Via: "api"}
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	Describe("Record", func() {
+		It("Should be safe on a nil auditor", func() {
+			var a *Auditor
+			a.Record(LogLevelSetAction, caller, "", "test", nil, nil)
+		})
+
+		It("Should append records to the log file", func() {
+			logFile := filepath.Join(GinkgoT().TempDir(), "audit.log")
+
+			a, err := New(&config.Audit{LogFile: logFile}, "GINKGO", log)
+			Expect(err).ToNot(HaveOccurred())
+
+			a.Record(LogLevelSetAction, caller, "", "Log level set", map[string]string{"level": "debug"}, nil)
+			a.Record(LogLevelResetAction, caller, "ORDERS", "Log level reset", nil, errors.New("unknown stream"))
+
+			f, err := os.Open(logFile)
+			Expect(err).ToNot(HaveOccurred())
+			defer f.Close()
+
+			var records []*events.Event
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				event := &events.Event{}
+				Expect(json.Unmarshal(scanner.Bytes(), event)).To(Succeed())
+				records = append(records, event)
+			}
+
+			Expect(records).To(HaveLen(2))
+			Expect(records[0].Event).To(Equal(events.AdminActionEvent))
+			Expect(records[0].Replicator).To(Equal("GINKGO"))
+			Expect(records[0].EventID).To(HaveLen(27))
+			Expect(records[0].Data).To(Equal(map[string]string{"action": "log_level_set", "caller": "bob", "address": "192.0.2.1:1234", "via": "api", "level": "debug"}))
+			Expect(records[1].Stream).To(Equal("ORDERS"))
+			Expect(records[1].Data["error"]).To(Equal("unknown stream"))
+
+			stat, err := os.Stat(logFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stat.Mode().Perm()).To(Equal(os.FileMode(0600)))
+		})
+
+		It("Should publish records", func() {
+			testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, _ *jsm.Manager) {
+				sub, err := nc.SubscribeSync("audit.>")
+				Expect(err).ToNot(HaveOccurred())
+
+				a, err := New(&config.Audit{Events: config.Events{Subject: "audit.%s", URL: nc.ConnectedUrl()}}, "GINKGO", log)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(a.Run(ctx, &wg)).To(Succeed())
+
+				a.Record(LogLevelSetAction, caller, "", "Log level set", nil, nil)
+
+				msg, err := sub.NextMsg(5 * time.Second)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(msg.Subject).To(Equal("audit.admin_action"))
+
+				event := &events.Event{}
+				Expect(json.Unmarshal(msg.Data, event)).To(Succeed())
+				Expect(event.Data["action"]).To(Equal("log_level_set"))
+				Expect(event.Data["caller"]).To(Equal("bob"))
+			})
+		})
+
+		It("Should publish records using an existing connection", func() {
+			testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, _ *jsm.Manager) {
+				sub, err := nc.SubscribeSync(config.DefaultAuditSubject)
+				Expect(err).ToNot(HaveOccurred())
+
+				a := NewWithConnection(nc, config.DefaultAuditSubject, "", log)
+				a.Record(ConsumerRemoveAction, Caller{Identity: "bob", Via: "cli"}, "ORDERS", "Consumer removed", map[string]string{"consumer": "C1"}, nil)
+
+				msg, err := sub.NextMsg(5 * time.Second)
+				Expect(err).ToNot(HaveOccurred())
+
+				event := &events.Event{}
+				Expect(json.Unmarshal(msg.Data, event)).To(Succeed())
+				Expect(event.Stream).To(Equal("ORDERS"))
+				Expect(event.Data).To(Equal(map[string]string{"action": "consumer_remove", "caller": "bob", "via": "cli", "consumer": "C1"}))
+			})
+		})
+	})
+})
@@ -0,0 +1,26 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package audit
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	auditRecords = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "audit", "total_records"),
+		Help: "The total number of administrative actions recorded",
+	}, []string{"action", "replicator"})
+
+	auditErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "audit", "errors"),
+		Help: "The number of times writing or publishing audit records failed",
+	}, []string{"action", "replicator"})
+)
+
+func init() {
+	prometheus.MustRegister(auditRecords)
+	prometheus.MustRegister(auditErrors)
+}
@@ -16,6 +16,8 @@ import (
 // and saturating at the final value in Millis.
 type Policy struct {
 	Millis []int
+	// Jitter is the fraction delays are randomized by, 0 uses the default of 0.5
+	Jitter float64
 }
 
 // FiveSecStartGrace Like FiveSec but allows for a few fairly rapid initial tries
@@ -49,6 +51,52 @@ var TwoMinutesSlowStart = Policy{
 // Default is the default backoff policy to use
 var Default = FiveSec
 
+// Linear creates a policy that increases evenly from min to max over steps intervals
+func Linear(min time.Duration, max time.Duration, steps int, jitter float64) Policy {
+	if steps < 2 {
+		steps = 2
+	}
+
+	p := Policy{Jitter: jitter}
+
+	for i := 0; i < steps; i++ {
+		d := min + (max-min)*time.Duration(i)/time.Duration(steps-1)
+		p.Millis = append(p.Millis, int(d.Milliseconds()))
+	}
+
+	return p
+}
+
+// Exponential creates a policy starting at initial and growing by multiplier every interval until it reaches max, a
+// multiplier of 1 or less keeps the delay at initial
+func Exponential(initial time.Duration, max time.Duration, multiplier float64, jitter float64) Policy {
+	p := Policy{Jitter: jitter}
+
+	if initial <= 0 {
+		initial = time.Millisecond
+	}
+	if max < initial {
+		max = initial
+	}
+
+	d := float64(initial)
+	for i := 0; i < 100; i++ {
+		if d >= float64(max) {
+			p.Millis = append(p.Millis, int(max.Milliseconds()))
+			break
+		}
+
+		p.Millis = append(p.Millis, int(time.Duration(d).Milliseconds()))
+
+		if multiplier <= 1 {
+			break
+		}
+		d *= multiplier
+	}
+
+	return p
+}
+
 // Duration returns the time duration of the n'th wait cycle in a
 // backoff policy. This is b.Millis[n], randomized to avoid thundering
 // herds.
@@ -57,7 +105,7 @@ func (b Policy) Duration(n int) time.Duration {
 		n = len(b.Millis) - 1
 	}
 
-	return time.Duration(jitter(b.Millis[n])) * time.Millisecond
+	return time.Duration(b.jitter(b.Millis[n])) * time.Millisecond
 }
 
 // TrySleep sleeps for the duration of the n'th try cycle
@@ -111,11 +159,21 @@ func (b Policy) For(ctx context.Context, cb func(try int) error) error {
 }
 
 // jitter returns a random integer uniformly distributed in the range
-// [0.5 * millis .. 1.5 * millis]
-func jitter(millis int) int {
+// [(1 - Jitter) * millis .. (1 + Jitter) * millis]
+func (b Policy) jitter(millis int) int {
 	if millis == 0 {
 		return 0
 	}
 
-	return millis/2 + rand.Intn(millis)
+	j := b.Jitter
+	if j <= 0 {
+		j = 0.5
+	}
+
+	spread := int(float64(millis) * j)
+	if spread == 0 {
+		return millis
+	}
+
+	return millis - spread + rand.Intn(2*spread)
 }
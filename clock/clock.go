@@ -0,0 +1,101 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package clock provides the time functions used by the heartbeat, limiter, tracker and election systems so tests
+// can replace real time with a Mock that is advanced explicitly
+package clock
+
+import (
+	"context"
+	"time"
+)
+
+// Clock tells the time and creates timers and tickers
+type Clock interface {
+	// Now is the current time
+	Now() time.Time
+	// Since is the time elapsed since t
+	Since(t time.Time) time.Duration
+	// NewTicker creates a ticker that ticks every d, d has to be more than 0
+	NewTicker(d time.Duration) Ticker
+	// NewTimer creates a timer that fires once after d
+	NewTimer(d time.Duration) Timer
+	// AfterFunc calls f once d elapsed
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Ticker delivers ticks at intervals like time.Ticker
+type Ticker interface {
+	// C is the channel ticks are delivered on
+	C() <-chan time.Time
+	// Reset stops the ticker and resets its interval to d
+	Reset(d time.Duration)
+	// Stop stops the ticker, no more ticks will be delivered
+	Stop()
+}
+
+// Timer fires once like time.Timer
+type Timer interface {
+	// C is the channel the time is delivered on when the timer fires, nil for timers made using AfterFunc
+	C() <-chan time.Time
+	// Reset changes the timer to fire after d, true when the timer was active
+	Reset(d time.Duration) bool
+	// Stop prevents the timer from firing, true when the timer was active
+	Stop() bool
+}
+
+// New creates a clock using real time
+func New() Clock {
+	return realClock{}
+}
+
+// Sleep sleeps for d using c, the error from ctx is returned if it is done before d elapsed
+func Sleep(ctx context.Context, c Clock, d time.Duration) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	timer := c.NewTimer(d)
+
+	select {
+	case <-timer.C():
+		return nil
+	case <-ctx.Done():
+		timer.Stop()
+		return ctx.Err()
+	}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                  { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration { return time.Since(t) }
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return &realTicker{t: time.NewTicker(d)}
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return &realTimer{t: time.NewTimer(d)}
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return &realTimer{t: time.AfterFunc(d, f)}
+}
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (r *realTicker) C() <-chan time.Time   { return r.t.C }
+func (r *realTicker) Reset(d time.Duration) { r.t.Reset(d) }
+func (r *realTicker) Stop()                 { r.t.Stop() }
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (r *realTimer) C() <-chan time.Time        { return r.t.C }
+func (r *realTimer) Reset(d time.Duration) bool { return r.t.Reset(d) }
+func (r *realTimer) Stop() bool                 { return r.t.Stop() }
@@ -0,0 +1,130 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package clock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestClock(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Clock")
+}
+
+var _ = Describe("Clock", func() {
+	var (
+		start = time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)
+		mock  *Mock
+	)
+
+	BeforeEach(func() {
+		mock = NewMock(start)
+	})
+
+	Describe("Mock", func() {
+		It("Should only move when told to", func() {
+			Expect(mock.Now()).To(Equal(start))
+			mock.Add(time.Minute)
+			Expect(mock.Now()).To(Equal(start.Add(time.Minute)))
+			Expect(mock.Since(start)).To(Equal(time.Minute))
+
+			mock.Set(start)
+			Expect(mock.Now()).To(Equal(start.Add(time.Minute)))
+		})
+
+		It("Should fire timers once they are due", func() {
+			timer := mock.NewTimer(time.Second)
+			Expect(mock.Timers()).To(Equal(1))
+
+			mock.Add(999 * time.Millisecond)
+			Expect(timer.C()).ToNot(Receive())
+
+			mock.Add(time.Millisecond)
+			Expect(timer.C()).To(Receive(Equal(start.Add(time.Second))))
+			Expect(mock.Timers()).To(Equal(0))
+
+			expired := mock.NewTimer(0)
+			Expect(expired.C()).To(Receive(Equal(mock.Now())))
+
+			Expect(timer.Reset(time.Second)).To(BeFalse())
+			Expect(timer.Stop()).To(BeTrue())
+			mock.Add(time.Hour)
+			Expect(timer.C()).ToNot(Receive())
+		})
+
+		It("Should tick at every interval", func() {
+			ticker := mock.NewTicker(time.Second)
+
+			for i := 1; i <= 3; i++ {
+				mock.Add(time.Second)
+				Expect(ticker.C()).To(Receive(Equal(start.Add(time.Duration(i) * time.Second))))
+			}
+
+			// ticks are dropped while the previous one was not received
+			mock.Add(5 * time.Second)
+			Expect(ticker.C()).To(Receive(Equal(start.Add(4 * time.Second))))
+			Expect(ticker.C()).ToNot(Receive())
+
+			ticker.Reset(time.Minute)
+			mock.Add(time.Second)
+			Expect(ticker.C()).ToNot(Receive())
+			mock.Add(time.Minute)
+			Expect(ticker.C()).To(Receive())
+
+			ticker.Stop()
+			mock.Add(time.Hour)
+			Expect(ticker.C()).ToNot(Receive())
+			Expect(mock.Timers()).To(Equal(0))
+		})
+
+		It("Should call functions in order of their deadlines", func() {
+			var called []time.Time
+
+			mock.AfterFunc(2*time.Second, func() { called = append(called, mock.Now()) })
+			mock.AfterFunc(time.Second, func() { called = append(called, mock.Now()) })
+			stopped := mock.AfterFunc(time.Second, func() { Fail("stopped function called") })
+			Expect(stopped.Stop()).To(BeTrue())
+
+			mock.Add(time.Minute)
+			Expect(called).To(Equal([]time.Time{start.Add(time.Second), start.Add(2 * time.Second)}))
+			Expect(mock.Now()).To(Equal(start.Add(time.Minute)))
+		})
+	})
+
+	Describe("Sleep", func() {
+		It("Should sleep until time moved", func() {
+			done := make(chan error, 1)
+			go func() { done <- Sleep(context.Background(), mock, time.Hour) }()
+
+			Eventually(mock.Timers).Should(Equal(1))
+			Consistently(done, "50ms").ShouldNot(Receive())
+
+			mock.Add(time.Hour)
+			Eventually(done).Should(Receive(BeNil()))
+		})
+
+		It("Should stop sleeping when the context is done", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() { done <- Sleep(ctx, mock, time.Hour) }()
+
+			Eventually(mock.Timers).Should(Equal(1))
+			cancel()
+			Eventually(done).Should(Receive(MatchError(context.Canceled)))
+			Expect(mock.Timers()).To(Equal(0))
+		})
+
+		It("Should sleep using real time", func() {
+			started := time.Now()
+			Expect(Sleep(context.Background(), New(), 20*time.Millisecond)).To(Succeed())
+			Expect(time.Since(started)).To(BeNumerically(">=", 20*time.Millisecond))
+		})
+	})
+})
@@ -0,0 +1,219 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Mock is a Clock that only moves when Add or Set is called, timers and tickers that become due fire in order of
+// their deadlines while time is moved
+type Mock struct {
+	now    time.Time
+	timers map[*mockTimer]struct{}
+	mu     sync.Mutex
+}
+
+type mockTimer struct {
+	m      *Mock
+	next   time.Time
+	period time.Duration
+	c      chan time.Time
+	f      func()
+}
+
+// NewMock creates a mock clock starting at start
+func NewMock(start time.Time) *Mock {
+	return &Mock{
+		now:    start,
+		timers: make(map[*mockTimer]struct{}),
+	}
+}
+
+// Now is the current mock time
+func (m *Mock) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.now
+}
+
+// Since is the mock time elapsed since t
+func (m *Mock) Since(t time.Time) time.Duration {
+	return m.Now().Sub(t)
+}
+
+// Timers is how many timers and tickers are active, tests can wait for them to be created before moving time
+func (m *Mock) Timers() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.timers)
+}
+
+// Add moves time forward by d firing all timers and tickers that become due
+func (m *Mock) Add(d time.Duration) {
+	m.Set(m.Now().Add(d))
+}
+
+// Set moves time to t firing all timers and tickers that become due, time does not move backwards
+func (m *Mock) Set(t time.Time) {
+	for {
+		m.mu.Lock()
+		if !t.After(m.now) {
+			m.mu.Unlock()
+			return
+		}
+
+		due := m.due(t)
+		if due == nil {
+			m.now = t
+			m.mu.Unlock()
+			return
+		}
+
+		m.now = due.next
+		f := due.fire()
+		m.mu.Unlock()
+
+		if f != nil {
+			f()
+		}
+	}
+}
+
+// NewTicker creates a ticker that ticks every d of mock time
+func (m *Mock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+
+	return &mockTicker{m.add(d, d, nil)}
+}
+
+// NewTimer creates a timer that fires once d of mock time elapsed
+func (m *Mock) NewTimer(d time.Duration) Timer {
+	return m.add(d, 0, nil)
+}
+
+// AfterFunc calls f once d of mock time elapsed, f is called by Add or Set
+func (m *Mock) AfterFunc(d time.Duration, f func()) Timer {
+	return m.add(d, 0, f)
+}
+
+func (m *Mock) add(d time.Duration, period time.Duration, f func()) *mockTimer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := &mockTimer{m: m, next: m.now.Add(d), period: period, f: f}
+	if f == nil {
+		t.c = make(chan time.Time, 1)
+	}
+
+	// like real timers ones that are already due fire immediately
+	if d <= 0 && period == 0 {
+		if f != nil {
+			go f()
+		} else {
+			t.c <- m.now
+		}
+
+		return t
+	}
+
+	m.timers[t] = struct{}{}
+
+	return t
+}
+
+// due finds the timer with the earliest deadline not after t
+func (m *Mock) due(t time.Time) *mockTimer {
+	var timers []*mockTimer
+	for timer := range m.timers {
+		if !timer.next.After(t) {
+			timers = append(timers, timer)
+		}
+	}
+
+	if len(timers) == 0 {
+		return nil
+	}
+
+	sort.Slice(timers, func(i, j int) bool { return timers[i].next.Before(timers[j].next) })
+
+	return timers[0]
+}
+
+// fire delivers the tick or returns the function to call, tickers are rescheduled, the mock lock has to be held
+func (t *mockTimer) fire() func() {
+	if t.period > 0 {
+		t.next = t.next.Add(t.period)
+	} else {
+		delete(t.m.timers, t)
+	}
+
+	if t.f != nil {
+		return t.f
+	}
+
+	// like time.Ticker ticks are dropped for slow receivers
+	select {
+	case t.c <- t.m.now:
+	default:
+	}
+
+	return nil
+}
+
+// mockTicker adapts a periodic mockTimer to the Ticker interface
+type mockTicker struct {
+	t *mockTimer
+}
+
+func (t *mockTicker) C() <-chan time.Time { return t.t.C() }
+func (t *mockTicker) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("non-positive interval for Ticker.Reset")
+	}
+
+	t.t.Reset(d)
+}
+func (t *mockTicker) Stop() { t.t.Stop() }
+
+func (t *mockTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *mockTimer) Reset(d time.Duration) bool {
+	t.m.mu.Lock()
+	defer t.m.mu.Unlock()
+
+	_, active := t.m.timers[t]
+	t.next = t.m.now.Add(d)
+	if t.period > 0 {
+		t.period = d
+	}
+	t.m.timers[t] = struct{}{}
+
+	if d <= 0 && t.period == 0 {
+		if f := t.fire(); f != nil {
+			go f()
+		}
+	}
+
+	return active
+}
+
+func (t *mockTimer) Stop() bool {
+	t.m.mu.Lock()
+	defer t.m.mu.Unlock()
+
+	_, active := t.m.timers[t]
+	delete(t.m.timers, t)
+
+	return active
+}
@@ -0,0 +1,235 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/choria-io/fisk"
+	"github.com/choria-io/stream-replicator/audit"
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/apiauth"
+	"github.com/choria-io/stream-replicator/internal/util"
+	"github.com/sirupsen/logrus"
+)
+
+type logLevelRequest struct {
+	Level  string `json:"level"`
+	Stream string `json:"stream,omitempty"`
+	Reset  bool   `json:"reset,omitempty"`
+}
+
+type logLevelResponse struct {
+	Level   string            `json:"level"`
+	Streams map[string]string `json:"streams"`
+}
+
+type adminError struct {
+	Error string `json:"error"`
+}
+
+func (c *cmd) setupAdminAPI(mux *http.ServeMux, auth *config.AdminAPIAuth) {
+	c.log.Warnf("Enabling the admin API on /api/v1/")
+	mux.HandleFunc("/api/v1/loglevel", c.apiAuthenticated(auth, c.apiLogLevel))
+	mux.HandleFunc("/api/v1/report", c.apiAuthenticated(auth, c.apiReport))
+	mux.HandleFunc("/api/v1/failover", c.apiAuthenticated(auth, c.apiFailover))
+	mux.HandleFunc("/api/v1/quiesce", c.apiAuthenticated(auth, c.apiQuiesce))
+	mux.HandleFunc("/api/v1/subjects", c.apiAuthenticated(auth, c.apiSubjects))
+}
+
+// apiAuthenticated rejects requests to the admin API that do not present the credentials configured in auth
+func (c *cmd) apiAuthenticated(auth *config.AdminAPIAuth, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		identity, err := apiauth.Authenticate(auth, r)
+		if err != nil {
+			c.log.Warnf("Rejecting admin API request to %s from %s: %v", r.URL.Path, r.RemoteAddr, err)
+			if auth != nil && auth.Username != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="stream-replicator"`)
+			}
+			c.apiError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), apiCallerKey{}, identity)))
+	}
+}
+
+// configureAdminAPIClientFlags adds the flags used to authenticate to the admin API of a running replicator
+func (c *cmd) configureAdminAPIClientFlags(cmd *fisk.CmdClause) {
+	cmd.Flag("api-user", "The user to authenticate to the admin API as").StringVar(&c.apiUser)
+	cmd.Flag("api-password", "The password to authenticate to the admin API with").Envar("STREAM_REPLICATOR_API_PASSWORD").StringVar(&c.apiPassword)
+	cmd.Flag("api-token", "The bearer token to authenticate to the admin API with").Envar("STREAM_REPLICATOR_API_TOKEN").StringVar(&c.apiToken)
+}
+
+// configureAdminAPIClient determines the url of the admin API, using the monitor port of the configuration when u is empty,
+// and the credentials to use from the flags or the configuration, requirement is the error returned without an admin API
+func (c *cmd) configureAdminAPIClient(u *string, requirement string) error {
+	if c.cfgile == "" {
+		if *u == "" {
+			return fmt.Errorf("either --url or --config is required")
+		}
+
+		return nil
+	}
+
+	cfg, err := config.Load(c.cfgile)
+	if err != nil {
+		return err
+	}
+
+	if cfg.MonitorPort == 0 || !cfg.AdminAPI {
+		if *u == "" {
+			return fmt.Errorf("%s", requirement)
+		}
+
+		return nil
+	}
+
+	if *u == "" {
+		*u = fmt.Sprintf("http://localhost:%d", cfg.MonitorPort)
+	}
+	c.apiAuth = cfg.AdminAPIAuth
+
+	return nil
+}
+
+// adminAPIRequest performs a request against the admin API authenticating using the flags, or else the configuration
+func (c *cmd) adminAPIRequest(client *http.Client, method string, u string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	switch {
+	case c.apiUser != "":
+		req.SetBasicAuth(c.apiUser, c.apiPassword)
+
+	case c.apiToken != "":
+		req.Header.Set("Authorization", "Bearer "+c.apiToken)
+
+	case c.apiAuth != nil:
+		err = apiauth.Authorize(req, c.apiAuth)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return client.Do(req)
+}
+
+// apiCallerKey stores the identity of authenticated callers in the request context
+type apiCallerKey struct{}
+
+// apiCaller identifies the caller of an admin API request using the identity established by apiAuthenticated
+func apiCaller(r *http.Request) audit.Caller {
+	identity, _ := r.Context().Value(apiCallerKey{}).(string)
+
+	return audit.Caller{Via: "api", Address: r.RemoteAddr, Identity: identity}
+}
+
+func (c *cmd) apiRespond(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (c *cmd) apiError(w http.ResponseWriter, code int, format string, a ...any) {
+	c.apiRespond(w, code, adminError{Error: fmt.Sprintf(format, a...)})
+}
+
+func (c *cmd) apiLogLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		req := logLevelRequest{}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			c.apiError(w, http.StatusBadRequest, "invalid request: %v", err)
+			return
+		}
+
+		switch {
+		case req.Reset:
+			if req.Stream == "" {
+				c.apiError(w, http.StatusBadRequest, "stream is required when resetting a log level")
+				return
+			}
+
+			err = c.levels.ResetStreamLevel(req.Stream)
+			c.audit.Record(audit.LogLevelResetAction, apiCaller(r), req.Stream, "Log level reset via the admin API", nil, err)
+			if err != nil {
+				c.apiError(w, http.StatusNotFound, "%v", err)
+				return
+			}
+
+			c.log.Warnf("Log level for stream %s reset via the admin API", req.Stream)
+
+		default:
+			level, err := logrus.ParseLevel(req.Level)
+			if err != nil {
+				c.apiError(w, http.StatusBadRequest, "%v", err)
+				return
+			}
+
+			details := map[string]string{"level": level.String()}
+
+			if req.Stream == "" {
+				c.levels.SetLevel(level)
+				c.audit.Record(audit.LogLevelSetAction, apiCaller(r), "", "Log level set via the admin API", details, nil)
+				c.log.Warnf("Log level set to %s via the admin API", level)
+				break
+			}
+
+			err = c.levels.SetStreamLevel(req.Stream, level)
+			c.audit.Record(audit.LogLevelSetAction, apiCaller(r), req.Stream, "Log level set via the admin API", details, err)
+			if err != nil {
+				c.apiError(w, http.StatusNotFound, "%v", err)
+				return
+			}
+
+			c.log.Warnf("Log level for stream %s set to %s via the admin API", req.Stream, level)
+		}
+
+	default:
+		c.apiError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+		return
+	}
+
+	level, streams := c.levels.Levels()
+	c.apiRespond(w, http.StatusOK, logLevelResponse{Level: level, Streams: streams})
+}
+
+func (c *cmd) apiReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		c.apiError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+		return
+	}
+
+	window := time.Hour
+	if since := r.URL.Query().Get("since"); since != "" {
+		var err error
+		window, err = util.ParseDurationString(since)
+		if err != nil {
+			c.apiError(w, http.StatusBadRequest, "invalid since: %v", err)
+			return
+		}
+	}
+
+	report, err := c.history.Report(window)
+	if err != nil {
+		c.apiError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	c.apiRespond(w, http.StatusOK, report)
+}
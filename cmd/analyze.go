@@ -0,0 +1,256 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/choria-io/fisk"
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/analyze"
+	"github.com/choria-io/stream-replicator/internal/util"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+type analyzeOptions struct {
+	stream  string
+	count   int
+	since   time.Duration
+	top     int
+	timeout time.Duration
+}
+
+func (c *cmd) configureAnalyzeCommand(app *fisk.Application) {
+	c.analyze = &analyzeOptions{}
+
+	analyze := app.Command("analyze", "Samples the source stream and reports subject cardinality, message sizes and rates").Action(c.analyzeAction)
+	analyze.Flag("config", "Configuration file").Required().ExistingFileVar(&c.cfgile)
+	analyze.Flag("stream", "The stream configuration to analyze, by name or source stream").StringVar(&c.analyze.stream)
+	analyze.Flag("count", "The most recent messages to sample").Default("10000").IntVar(&c.analyze.count)
+	analyze.Flag("since", "Samples messages stored since a certain age expressed as a duration like 1h").DurationVar(&c.analyze.since)
+	analyze.Flag("top", "How many of the most active subjects to show").Default("20").IntVar(&c.analyze.top)
+	analyze.Flag("timeout", "How long to wait for messages from the stream before giving up").Default("10s").DurationVar(&c.analyze.timeout)
+	analyze.Flag("json", "Render JSON values").BoolVar(&c.json)
+}
+
+func (c *cmd) selectAnalyzeStream(cfg *config.Config) (*config.Stream, error) {
+	streams := cfg.AllStreams()
+
+	if c.analyze.stream == "" {
+		if len(streams) != 1 {
+			return nil, fmt.Errorf("configuration has %d streams, select one using --stream", len(streams))
+		}
+
+		return streams[0], nil
+	}
+
+	for _, s := range streams {
+		if s.Name == c.analyze.stream || s.Stream == c.analyze.stream {
+			return s, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no stream %q found in the configuration", c.analyze.stream)
+}
+
+// analyzeStream samples messages in the stream using a headers only consumer, payload sizes are taken from the
+// Nats-Msg-Size header so the payloads are not transferred
+func (c *cmd) analyzeStream(ctx context.Context, nc *nats.Conn, scfg *config.Stream) (*analyze.Result, error) {
+	mgr, err := jsm.New(nc)
+	if err != nil {
+		return nil, err
+	}
+
+	str, err := mgr.LoadStream(scfg.Stream)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := str.State()
+	if err != nil {
+		return nil, err
+	}
+
+	name := scfg.Name
+	if name == "" {
+		name = scfg.Stream
+	}
+
+	res := analyze.NewResult(name, scfg.Stream, scfg.FilterSubject)
+	defer res.Finish(c.analyze.top)
+
+	if state.Msgs == 0 {
+		return res, nil
+	}
+
+	start := nats.DeliverAll()
+	switch {
+	case c.analyze.since > 0:
+		start = nats.StartTime(time.Now().Add(-c.analyze.since))
+	case state.LastSeq-state.FirstSeq >= uint64(c.analyze.count):
+		start = nats.StartSequence(state.LastSeq - uint64(c.analyze.count) + 1)
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := js.SubscribeSync(scfg.FilterSubject, nats.BindStream(scfg.Stream), nats.OrderedConsumer(), nats.HeadersOnly(), start)
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsubscribe()
+
+	for res.Messages < c.analyze.count {
+		tctx, cancel := context.WithTimeout(ctx, c.analyze.timeout)
+		msg, err := sub.NextMsgWithContext(tctx)
+		cancel()
+		switch {
+		case errors.Is(err, context.DeadlineExceeded) && res.Messages == 0:
+			// nothing was stored in the sampled window or matched the filter
+			return res, nil
+		case err != nil:
+			return nil, fmt.Errorf("reading %s failed after %d messages: %v", scfg.Stream, res.Messages, err)
+		}
+
+		meta, err := msg.Metadata()
+		if err != nil {
+			return nil, err
+		}
+
+		if meta.Sequence.Stream > state.LastSeq {
+			return res, nil
+		}
+
+		size, err := strconv.Atoi(msg.Header.Get(nats.MsgSize))
+		if err != nil {
+			size = len(msg.Data)
+		}
+
+		res.Add(msg.Subject, size, meta.Timestamp, meta.Sequence.Stream)
+
+		if meta.NumPending == 0 || meta.Sequence.Stream == state.LastSeq {
+			return res, nil
+		}
+	}
+
+	return res, nil
+}
+
+func (c *cmd) analyzeAction(_ *fisk.ParseContext) error {
+	if c.analyze.count <= 0 {
+		return fmt.Errorf("count should be more than 0")
+	}
+
+	cfg, err := config.Load(c.cfgile)
+	if err != nil {
+		return err
+	}
+
+	scfg, err := c.selectAnalyzeStream(cfg)
+	if err != nil {
+		return err
+	}
+	if scfg.SourceServers() == "" {
+		return fmt.Errorf("analyzing is only supported for streams with a NATS Stream source")
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	if c.debug {
+		logger.SetLevel(logrus.DebugLevel)
+	}
+	c.log = logrus.NewEntry(logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.interruptHandler(ctx, cancel)
+
+	source, err := util.ConnectNats(ctx, "analyze-source", scfg.SourceServers(), scfg.SourceTLS, scfg.SourceChoriaConn, true, scfg.SourceProcess, c.log.WithField("connection", "source"), append(scfg.SourceContextOptions(), util.WithProxy(scfg.SourceProxy))...)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	res, err := c.analyzeStream(ctx, source, scfg)
+	if err != nil {
+		return fmt.Errorf("analyzing %s failed: %v", scfg.Stream, err)
+	}
+
+	if c.json {
+		j, err := json.MarshalIndent(res, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(j))
+
+		return nil
+	}
+
+	c.showAnalyzeResult(res)
+
+	return nil
+}
+
+func (c *cmd) showAnalyzeResult(res *analyze.Result) {
+	if res.Filter != "" {
+		fmt.Printf("%s: %s filtered by %s\n", res.Name, res.Stream, res.Filter)
+	} else {
+		fmt.Printf("%s: %s\n", res.Name, res.Stream)
+	}
+	fmt.Println()
+
+	if res.Messages == 0 {
+		fmt.Println("    No messages were sampled")
+		return
+	}
+
+	fmt.Printf("         Sampled: %d messages, %d bytes, sequences %d to %d\n", res.Messages, res.Bytes, res.FirstSeq, res.LastSeq)
+	fmt.Printf("          Period: %s to %s (%v)\n", res.Start.Format(time.RFC3339), res.End.Format(time.RFC3339), res.End.Sub(res.Start).Round(time.Millisecond))
+	fmt.Printf("            Rate: %.2f msg/sec\n", res.Rate)
+	fmt.Printf("        Subjects: %d distinct\n", res.Distinct)
+
+	fmt.Println()
+	fmt.Println("Message sizes:")
+	fmt.Println()
+	fmt.Printf("             Min: %d\n", res.Sizes.Min)
+	fmt.Printf("         Average: %.0f\n", res.Sizes.Average)
+	fmt.Printf("             50%%: %d\n", res.Sizes.P50)
+	fmt.Printf("             90%%: %d\n", res.Sizes.P90)
+	fmt.Printf("             99%%: %d\n", res.Sizes.P99)
+	fmt.Printf("             Max: %d\n", res.Sizes.Max)
+
+	fmt.Println()
+	fmt.Println("Subject tokens:")
+	fmt.Println()
+	for _, t := range res.Tokens {
+		fmt.Printf("         Token %d: %d distinct value(s) like %s\n", t.Position, t.Values, strings.Join(t.Examples, ", "))
+	}
+
+	width := len("Subject")
+	for _, s := range res.Subjects {
+		if len(s.Subject) > width {
+			width = len(s.Subject)
+		}
+	}
+
+	fmt.Println()
+	fmt.Printf("Top %d subjects:\n", len(res.Subjects))
+	fmt.Println()
+	fmt.Printf("  %-*s  %10s  %7s  %14s  %12s  %12s\n", width, "Subject", "Messages", "Share", "Bytes", "Average Size", "msg/sec")
+	for _, s := range res.Subjects {
+		share := float64(s.Messages) / float64(res.Messages) * 100
+		fmt.Printf("  %-*s  %10d  %6.1f%%  %14d  %12.0f  %12.2f\n", width, s.Subject, s.Messages, share, s.Bytes, s.Average, s.Rate)
+	}
+}
@@ -0,0 +1,285 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/choria-io/fisk"
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/bench"
+	"github.com/choria-io/stream-replicator/internal/util"
+	"github.com/choria-io/stream-replicator/replicator"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/ksuid"
+	"github.com/sirupsen/logrus"
+)
+
+type benchMessage struct {
+	Bench   string `json:"bench"`
+	Seq     int    `json:"seq"`
+	Sent    int64  `json:"sent"`
+	Padding string `json:"padding,omitempty"`
+}
+
+type benchOptions struct {
+	stream   string
+	subject  string
+	count    int
+	size     int
+	timeout  time.Duration
+	external bool
+}
+
+func (c *cmd) configureBenchCommand(app *fisk.Application) {
+	c.bench = &benchOptions{}
+
+	bench := app.Command("bench", "Measures replication throughput and latency using synthetic messages").Action(c.benchAction)
+	bench.Flag("config", "Configuration file").Required().ExistingFileVar(&c.cfgile)
+	bench.Flag("stream", "The stream configuration to benchmark, by name or source stream").StringVar(&c.bench.stream)
+	bench.Flag("subject", "The subject to publish messages to in the source stream").StringVar(&c.bench.subject)
+	bench.Flag("count", "The number of messages to publish").Default("1000").IntVar(&c.bench.count)
+	bench.Flag("size", "The size of messages to publish").Default("128").IntVar(&c.bench.size)
+	bench.Flag("timeout", "How long to wait for all messages to be replicated").Default("1m").DurationVar(&c.bench.timeout)
+	bench.Flag("external", "Measure an already running replicator rather than starting one").UnNegatableBoolVar(&c.bench.external)
+	bench.Flag("force", "Publish to the source stream without prompting").Short('f').UnNegatableBoolVar(&c.force)
+}
+
+func (c *cmd) selectBenchStream(cfg *config.Config) (*config.Stream, error) {
+	streams := cfg.AllStreams()
+
+	if c.bench.stream == "" {
+		if len(streams) != 1 {
+			return nil, fmt.Errorf("configuration has %d streams, select one using --stream", len(streams))
+		}
+
+		return streams[0], nil
+	}
+
+	for _, s := range streams {
+		if s.Name == c.bench.stream || s.Stream == c.bench.stream {
+			return s, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no stream %q found in the configuration", c.bench.stream)
+}
+
+func (c *cmd) benchSubject(scfg *config.Stream, mgr *jsm.Manager) (string, error) {
+	if c.bench.subject != "" {
+		return c.bench.subject, nil
+	}
+
+	if scfg.FilterSubject != "" && !strings.ContainsAny(scfg.FilterSubject, "*>") {
+		return scfg.FilterSubject, nil
+	}
+
+	stream, err := mgr.LoadStream(scfg.Stream)
+	if err != nil {
+		return "", err
+	}
+
+	for _, subj := range stream.Subjects() {
+		if !strings.ContainsAny(subj, "*>") {
+			return subj, nil
+		}
+	}
+
+	return "", fmt.Errorf("could not determine a subject to publish to, set one using --subject")
+}
+
+func (c *cmd) benchAction(_ *fisk.ParseContext) error {
+	if c.bench.count <= 0 {
+		return fmt.Errorf("count should be more than 0")
+	}
+
+	cfg, err := config.Load(c.cfgile)
+	if err != nil {
+		return err
+	}
+
+	scfg, err := c.selectBenchStream(cfg)
+	if err != nil {
+		return err
+	}
+	if scfg.SourceServers() == "" || scfg.TargetServers() == "" || scfg.TargetCore {
+		return fmt.Errorf("benchmarking is only supported for streams replicating between NATS Streams")
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	if c.debug {
+		logger.SetLevel(logrus.DebugLevel)
+	}
+	c.log = logrus.NewEntry(logger)
+
+	if !c.force {
+		ok, err := askConfirmation(fmt.Sprintf("Really publish %d synthetic messages into %s", c.bench.count, scfg.Stream))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.interruptHandler(ctx, cancel)
+
+	stream, err := replicator.NewStream(scfg, cfg.ProfileFor(scfg), c.log)
+	if err != nil {
+		return err
+	}
+
+	wg := &sync.WaitGroup{}
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	if !c.bench.external {
+		fmt.Printf("Starting replicator for %s\n", scfg.Stream)
+
+		wg.Add(1)
+		go func() {
+			err := stream.Run(ctx, wg)
+			if err != nil {
+				c.log.Errorf("Replicator failed: %v", err)
+				cancel()
+			}
+		}()
+
+		select {
+		case <-stream.Ready():
+		case <-ctx.Done():
+			return fmt.Errorf("replicator did not start")
+		}
+	}
+
+	source, err := util.ConnectNats(ctx, "bench-source", scfg.SourceServers(), scfg.SourceTLS, scfg.SourceChoriaConn, true, scfg.SourceProcess, c.log.WithField("connection", "source"), append(scfg.SourceContextOptions(), util.WithProxy(scfg.SourceProxy))...)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	target, err := util.ConnectNats(ctx, "bench-target", scfg.TargetServers(), scfg.TargetTLS, scfg.TargetChoriaConn, true, scfg.TargetProcess, c.log.WithField("connection", "target"), append(scfg.TargetContextOptions(), util.WithProxy(scfg.TargetProxy))...)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+
+	smgr, err := jsm.New(source)
+	if err != nil {
+		return err
+	}
+	tmgr, err := jsm.New(target)
+	if err != nil {
+		return err
+	}
+
+	subject, err := c.benchSubject(scfg, smgr)
+	if err != nil {
+		return err
+	}
+
+	id := ksuid.New().String()
+	results := bench.NewResults(c.bench.count)
+
+	sub, err := target.Subscribe(target.NewRespInbox(), func(msg *nats.Msg) {
+		bm := &benchMessage{}
+		err := json.Unmarshal(msg.Data, bm)
+		if err != nil || bm.Bench != id {
+			return
+		}
+
+		results.Add(bm.Seq, time.Since(time.Unix(0, bm.Sent)))
+	})
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+
+	consumer, err := tmgr.NewConsumer(scfg.TargetStream, jsm.DeliverySubject(sub.Subject), jsm.StartWithNextReceived(), jsm.AcknowledgeNone(), jsm.FilterStreamBySubject(stream.TargetForSubject(subject)))
+	if err != nil {
+		return fmt.Errorf("could not create consumer on target stream %s: %v", scfg.TargetStream, err)
+	}
+	defer consumer.Delete()
+
+	js, err := source.JetStream(nats.PublishAsyncMaxPending(256))
+	if err != nil {
+		return err
+	}
+
+	padding := ""
+	if c.bench.size > 0 {
+		padding = strings.Repeat("x", c.bench.size)
+	}
+
+	fmt.Printf("Publishing %d messages of %d bytes to %s\n", c.bench.count, c.bench.size, subject)
+
+	start := time.Now()
+	for i := 1; i <= c.bench.count; i++ {
+		body, err := json.Marshal(&benchMessage{Bench: id, Seq: i, Sent: time.Now().UnixNano(), Padding: padding})
+		if err != nil {
+			return err
+		}
+
+		_, err = js.PublishAsync(subject, body)
+		if err != nil {
+			return fmt.Errorf("publish failed: %v", err)
+		}
+	}
+
+	select {
+	case <-js.PublishAsyncComplete():
+	case <-time.After(c.bench.timeout):
+		return fmt.Errorf("publishing did not complete in %v", c.bench.timeout)
+	}
+	published := time.Since(start)
+
+	select {
+	case <-results.Done():
+	case <-time.After(c.bench.timeout):
+		summary := results.Summary()
+		fmt.Printf("Timeout after %v, received %d of %d messages\n", c.bench.timeout, summary.Count, c.bench.count)
+		c.benchReport(summary, published, time.Since(start))
+		return fmt.Errorf("not all messages were replicated")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	c.benchReport(results.Summary(), published, time.Since(start))
+
+	return nil
+}
+
+func (c *cmd) benchReport(summary bench.Summary, published time.Duration, total time.Duration) {
+	fmt.Println()
+	fmt.Printf("       Published: %d messages in %v (%.0f msg/sec)\n", c.bench.count, published.Round(time.Millisecond), float64(c.bench.count)/published.Seconds())
+
+	if summary.Count == 0 {
+		return
+	}
+
+	rate := float64(summary.Count) / total.Seconds()
+	fmt.Printf("      Replicated: %d messages in %v (%.0f msg/sec, %.0f bytes/sec)\n", summary.Count, total.Round(time.Millisecond), rate, rate*float64(c.bench.size))
+
+	fmt.Println()
+	fmt.Println("Replication latency:")
+	fmt.Println()
+	fmt.Printf("             Min: %v\n", summary.Min.Round(time.Microsecond))
+	fmt.Printf("         Average: %v\n", summary.Average.Round(time.Microsecond))
+	fmt.Printf("             50%%: %v\n", summary.P50.Round(time.Microsecond))
+	fmt.Printf("             90%%: %v\n", summary.P90.Round(time.Microsecond))
+	fmt.Printf("             99%%: %v\n", summary.P99.Round(time.Microsecond))
+	fmt.Printf("             Max: %v\n", summary.Max.Round(time.Microsecond))
+}
@@ -24,16 +24,22 @@ import (
 	"time"
 
 	"github.com/choria-io/stream-replicator/advisor"
+	"github.com/choria-io/stream-replicator/events"
 	"github.com/choria-io/stream-replicator/heartbeat"
 	"github.com/choria-io/stream-replicator/idtrack"
+	"github.com/choria-io/stream-replicator/internal/report"
+	"github.com/choria-io/stream-replicator/metrics"
 	"github.com/choria-io/tokens"
 	"github.com/nats-io/jsm.go"
 	"github.com/nats-io/jsm.go/natscontext"
 	"github.com/nats-io/nats.go"
 
 	"github.com/choria-io/fisk"
+	"github.com/choria-io/stream-replicator/audit"
 	"github.com/choria-io/stream-replicator/config"
 	"github.com/choria-io/stream-replicator/replicator"
+	"github.com/choria-io/stream-replicator/topology"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 )
@@ -57,10 +63,51 @@ type cmd struct {
 	stateValuesOnly  bool
 	stateAdvised     bool
 	stateSince       time.Duration
+	stateKeyEnv      string
+	stateKeyFile     string
+	auditSubject     string
+	auditLog         string
 	json             bool
 	choriaToken      string
 	choriaSeed       string
 	choriaCollective string
+	consumerStream   string
+	consumerName     string
+	consumerSeq      uint64
+	consumerSince    string
+	force            bool
+	svcName          string
+	bench            *benchOptions
+	verify           *verifyOptions
+	analyze          *analyzeOptions
+	levels           *logLevels
+	history          *report.History
+	audit            *audit.Auditor
+	metrics          *metrics.Forwarder
+	scheduler        *replicator.Scheduler
+	reportURL        string
+	reportSince      string
+	reportOutput     string
+	failoverStream   string
+	failoverReason   string
+	failoverURL      string
+	quiesceStream    string
+	quiesceTimeout   string
+	quiesceResume    bool
+	quiesceURL       string
+	subjectsStream   string
+	subjectsTop      int
+	subjectsURL      string
+	apiUser          string
+	apiPassword      string
+	apiToken         string
+	apiAuth          *config.AdminAPIAuth
+	topologyBucket   string
+	topologyDot      bool
+	heartbeatBucket  string
+	once             bool
+	onceTimeout      time.Duration
+	running          []*runningStream
 
 	mu  sync.Mutex
 	log *logrus.Entry
@@ -81,6 +128,9 @@ func Run() {
 
 	repl := app.Command("replicate", "Starts the Stream Replicator process").Default().Action(c.replicateAction)
 	repl.Flag("config", "Configuration file").Required().ExistingFileVar(&c.cfgile)
+	repl.Flag("once", "Copies messages up to the end of the source streams and exits, for example when run from cron").UnNegatableBoolVar(&c.once)
+	repl.Flag("once-timeout", "How long copying with --once can take").Default("1h").DurationVar(&c.onceTimeout)
+	repl.Flag("json", "Render the --once summary as JSON").UnNegatableBoolVar(&c.json)
 
 	admin := app.Command("admin", "Interact with stream advisories and tracking state")
 	admFind := admin.Command("advisories", "Audit advisories for a specific node").Alias("adv").Action(c.findAction)
@@ -100,6 +150,8 @@ func Run() {
 	admState.Flag("value", "A regular expression value to search for").RegexpVar(&c.stateValue)
 	admState.Flag("values", "List only values rather than full entries").BoolVar(&c.stateValuesOnly)
 	admState.Flag("json", "Render JSON values").BoolVar(&c.json)
+	admState.Flag("key-env", "Environment variable holding the key to decrypt encrypted state files").PlaceHolder("VAR").StringVar(&c.stateKeyEnv)
+	admState.Flag("key-file", "File holding the key to decrypt encrypted state files").PlaceHolder("FILE").ExistingFileVar(&c.stateKeyFile)
 
 	admGossip := admin.Commandf("gossip", "View the synchronization traffic").Action(c.gossipAction)
 	admGossip.Flag("json", "Render JSON values").BoolVar(&c.json)
@@ -108,6 +160,19 @@ func Run() {
 	admGossip.Flag("choria-token", "The JWT token file to connect to Choria Brokers with").ExistingFileVar(&c.choriaToken)
 	admGossip.Flag("choria-collective", "The Choria collective you will be connecting to").Default("choria").StringVar(&c.choriaCollective)
 
+	c.configureConsumersCommand(admin)
+	c.configureFailoverCommand(admin)
+	c.configureQuiesceCommand(admin)
+	c.configureSubjectsCommand(admin)
+	c.configureTopologyCommand(admin)
+	c.configureHeartbeatsCommand(admin)
+	c.configureInitCommand(app)
+	c.configureServiceCommand(app)
+	c.configureBenchCommand(app)
+	c.configureVerifyCommand(app)
+	c.configureAnalyzeCommand(app)
+	c.configureReportCommand(app)
+
 	app.MustParseWithUsage(os.Args[1:])
 }
 
@@ -152,11 +217,21 @@ func (c *cmd) findState(_ *fisk.ParseContext) error {
 		}
 	}
 
+	var key []byte
+	if c.stateKeyEnv != "" || c.stateKeyFile != "" {
+		enc := &config.StateEncryption{KeyEnv: c.stateKeyEnv, KeyFile: c.stateKeyFile}
+		err = enc.LoadKey()
+		if err != nil {
+			return err
+		}
+		key = enc.Key
+	}
+
 	var selected []*idtrack.Item
 
 	for _, path := range paths {
 		items := idtrack.Tracker{}
-		sb, err := os.ReadFile(path)
+		sb, err := idtrack.ReadState(path, key)
 		if err != nil {
 			return err
 		}
@@ -413,6 +488,14 @@ func (c *cmd) findAction(_ *fisk.ParseContext) error {
 }
 
 func (c *cmd) replicateAction(_ *fisk.ParseContext) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	return c.replicate(ctx, cancel)
+}
+
+// replicate runs the replicator until ctx is canceled
+func (c *cmd) replicate(ctx context.Context, cancel context.CancelFunc) error {
 	cfg, err := config.Load(c.cfgile)
 	if err != nil {
 		return err
@@ -422,52 +505,193 @@ func (c *cmd) replicateAction(_ *fisk.ParseContext) error {
 	if err != nil {
 		return err
 	}
+	c.levels = newLogLevels(c.log.Logger)
+
+	if c.once {
+		return c.replicateOnce(ctx, cancel, cfg)
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
 	wg := &sync.WaitGroup{}
 
 	go c.interruptHandler(ctx, cancel)
 
-	go c.setupPrometheus(cfg.MonitorPort, cfg.Profiling)
+	if cfg.AdminAPI {
+		c.history = report.NewHistory(cfg.ReplicatorName, nil, time.Minute, 24*time.Hour)
+		go c.history.Run(ctx)
+	}
 
-	for _, s := range cfg.Streams {
-		c.log.Debugf("Configuring stream %s", s.Name)
-		stream, err := replicator.NewStream(s, cfg, c.log)
+	if cfg.Audit != nil {
+		c.audit, err = audit.New(cfg.Audit, cfg.ReplicatorName, c.log)
 		if err != nil {
 			return err
 		}
 
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := c.audit.Run(ctx, wg)
+			if err != nil {
+				c.log.Errorf("Could not start audit publisher: %v", err)
+			}
+		}()
+	}
+
+	// started after the auditor is created and given it directly so the handler never reads c.audit while it is being set
+	go c.logLevelSignalHandler(ctx, c.audit)
+
+	if cfg.Metrics != nil {
+		c.metrics, err = metrics.New(cfg.Metrics, cfg.ReplicatorName, c.log)
+		if err != nil {
+			return err
+		}
+
+		err = c.metrics.Run(ctx, wg)
+		if err != nil {
+			c.log.Errorf("Could not start metrics forwarding: %v", err)
+			c.metrics = nil
+		}
+	}
+
+	if cfg.Scheduler != nil {
+		c.log.Infof("Scheduling streams within %d bytes per second", cfg.Scheduler.BytesPerSecond)
+		c.scheduler = replicator.NewScheduler(cfg.Scheduler)
+		wg.Add(1)
+		go c.scheduler.Run(ctx, wg)
+	}
+
+	var adminAuth *config.AdminAPIAuth
+	if cfg.AdminAPI {
+		adminAuth = cfg.AdminAPIAuth
+	}
+	go c.setupPrometheus(cfg.MonitorPort, cfg.Profiling, adminAuth)
+
+	var running []*runningStream
+
+	for _, p := range cfg.AllProfiles() {
+		streams, err := c.startProfile(ctx, wg, p, p != cfg)
+		if err != nil {
+			return err
+		}
+		running = append(running, streams...)
+	}
+
+	c.mu.Lock()
+	c.running = running
+	c.mu.Unlock()
+
+	if cfg.Topology != nil {
+		registry := topology.New(cfg.Topology, c.topologyRegistrations, c.log)
+		err = registry.Run(ctx, wg)
+		if err != nil {
+			c.log.Errorf("Could not start topology registry: %v", err)
+		}
+	}
+
+	go c.systemdNotify(ctx, running)
+
+	wg.Wait()
+
+	return nil
+}
+
+// startProfile starts the events, streams and heartbeats of a profile, named adds the profile name to logs
+func (c *cmd) startProfile(ctx context.Context, wg *sync.WaitGroup, cfg *config.Config, named bool) ([]*runningStream, error) {
+	log := c.log
+	if named {
+		log = log.WithField("profile", cfg.ReplicatorName)
+		log.Infof("Starting profile %s with %d streams", cfg.ReplicatorName, len(cfg.Streams))
+	}
+
+	opts, err := c.startEvents(ctx, wg, cfg, log)
+	if err != nil {
+		return nil, err
+	}
+	if c.scheduler != nil {
+		opts = append(opts, replicator.WithScheduler(c.scheduler))
+	}
+
+	var running []*runningStream
+
+	for _, s := range cfg.Streams {
+		log.Debugf("Configuring stream %s", s.Name)
+
+		slog, err := c.levels.forStream(s)
+		if err != nil {
+			return nil, err
+		}
+		if named {
+			slog = slog.WithField("profile", cfg.ReplicatorName)
+		}
+
+		var stream replicatedStream
+		if s.Failover != nil {
+			stream, err = replicator.NewFailover(s, cfg, slog, opts...)
+		} else {
+			stream, err = replicator.NewStream(s, cfg, slog, opts...)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		rs := &runningStream{name: fmt.Sprintf("%s/%s", s.Stream, s.Name), replicator: cfg.ReplicatorName, cfg: s, stream: stream, exited: make(chan struct{})}
+		running = append(running, rs)
+
 		wg.Add(1)
 		go func(s *config.Stream) {
 			defer wg.Done()
+			defer close(rs.exited)
 
 			wg.Add(1)
-			err = stream.Run(ctx, wg)
+			err := stream.Run(ctx, wg)
 			if err != nil {
-				c.log.Errorf("Could not start replicator for %s: %v", s.Name, err)
+				log.Errorf("Could not start replicator for %s: %v", s.Name, err)
 			}
 		}(s)
 	}
 
 	if cfg.HeartBeat != nil {
-		hb, err := heartbeat.New(cfg.HeartBeat, cfg.ReplicatorName, c.log)
+		hb, err := heartbeat.New(cfg.HeartBeat, cfg.ReplicatorName, log)
 		if err != nil {
-			c.log.Errorf("Could not initialize heartbeat: %v", err)
+			log.Errorf("Could not initialize heartbeat: %v", err)
 		} else {
 			err = hb.Run(ctx, wg)
 			if err != nil {
-				c.log.Errorf("Could not start heartbeat: %v", err)
+				log.Errorf("Could not start heartbeat: %v", err)
 			}
 		}
 	}
 
-	wg.Wait()
+	return running, nil
+}
 
-	return nil
+// startEvents starts the events publisher of a profile when configured and returns the options streams use it with
+func (c *cmd) startEvents(ctx context.Context, wg *sync.WaitGroup, cfg *config.Config, log *logrus.Entry) ([]replicator.Option, error) {
+	if cfg.Events == nil {
+		return nil, nil
+	}
+
+	publisher, err := events.New(cfg.Events, cfg.ReplicatorName, log)
+	if err != nil {
+		return nil, err
+	}
+
+	// events are queued while connecting so this should not delay starting the streams
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		err := publisher.Run(ctx, wg)
+		if err != nil {
+			log.Errorf("Could not start events publisher: %v", err)
+		}
+	}()
+
+	return []replicator.Option{replicator.WithEvents(publisher)}, nil
 }
 
-func (c *cmd) setupPrometheus(port int, profiling bool) {
+// setupPrometheus serves metrics and health checks on port, admin enables the admin API using its credentials
+func (c *cmd) setupPrometheus(port int, profiling bool, admin *config.AdminAPIAuth) {
 	if port == 0 {
 		c.log.Infof("Skipping Prometheus setup")
 		return
@@ -475,7 +699,14 @@ func (c *cmd) setupPrometheus(port int, profiling bool) {
 
 	c.log.Infof("Listening for /metrics on %d", port)
 	mux := http.NewServeMux()
-	mux.Handle("/metrics", promhttp.Handler())
+	if c.metrics != nil && c.metrics.Aggregating() {
+		c.log.Infof("Exposing metrics forwarded by other replicators on /metrics")
+		gatherers := prometheus.Gatherers{prometheus.DefaultGatherer, c.metrics}
+		mux.Handle("/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError})))
+	} else {
+		mux.Handle("/metrics", promhttp.Handler())
+	}
+	c.setupHealthChecks(mux)
 
 	if profiling {
 		c.log.Warnf("Enabling live profiling on /debug/pprof")
@@ -486,6 +717,10 @@ func (c *cmd) setupPrometheus(port int, profiling bool) {
 		mux.HandleFunc("/debug/pprof/trace", pphttp.Trace)
 	}
 
+	if admin != nil {
+		c.setupAdminAPI(mux, admin)
+	}
+
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%d", port),
 		Handler: mux,
@@ -508,14 +743,7 @@ func (c *cmd) configureLogging(cfg *config.Config) (*logrus.Entry, error) {
 		logger.SetOutput(file)
 	}
 
-	switch cfg.LogLevel {
-	case "debug":
-		logger.SetLevel(logrus.DebugLevel)
-	case "warn":
-		logger.SetLevel(logrus.WarnLevel)
-	default:
-		logger.SetLevel(logrus.InfoLevel)
-	}
+	logger.SetLevel(parseLogLevel(cfg.LogLevel))
 
 	if c.debug {
 		logger.SetLevel(logrus.DebugLevel)
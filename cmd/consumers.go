@@ -0,0 +1,342 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/choria-io/fisk"
+	"github.com/choria-io/stream-replicator/audit"
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/util"
+	"github.com/choria-io/stream-replicator/replicator"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/jsm.go/api"
+	"github.com/nats-io/jsm.go/natscontext"
+	"github.com/sirupsen/logrus"
+)
+
+func (c *cmd) configureConsumersCommand(admin *fisk.CmdClause) {
+	cons := admin.Command("consumers", "Manage the consumers created by the replicator on source streams").Alias("consumer").Alias("c")
+
+	ls := cons.Command("ls", "List consumers created by the replicator").Alias("list").Action(c.consumersListAction)
+	ls.Arg("stream", "The name of the source stream").Required().StringVar(&c.consumerStream)
+	ls.Flag("json", "Render JSON values").BoolVar(&c.json)
+	c.addConnectionFlags(ls)
+
+	info := cons.Command("info", "Show information about a consumer").Alias("i").Action(c.consumersInfoAction)
+	info.Arg("stream", "The name of the source stream").Required().StringVar(&c.consumerStream)
+	info.Arg("consumer", "The consumer to inspect").Required().StringVar(&c.consumerName)
+	info.Flag("json", "Render JSON values").BoolVar(&c.json)
+	c.addConnectionFlags(info)
+
+	reset := cons.Command("reset", "Recreates a durable consumer starting at a new location").Action(c.consumersResetAction)
+	reset.Arg("stream", "The name of the source stream").Required().StringVar(&c.consumerStream)
+	reset.Arg("consumer", "The consumer to reset").Required().StringVar(&c.consumerName)
+	reset.Flag("sequence", "Start at a specific stream sequence").Uint64Var(&c.consumerSeq)
+	reset.Flag("since", "Start at a time expressed as a duration like 1h or 1d").StringVar(&c.consumerSince)
+	reset.Flag("force", "Reset without prompting").Short('f').UnNegatableBoolVar(&c.force)
+	c.addConnectionFlags(reset)
+	c.addAuditFlags(reset)
+
+	rm := cons.Command("rm", "Removes a consumer").Alias("delete").Action(c.consumersRmAction)
+	rm.Arg("stream", "The name of the source stream").Required().StringVar(&c.consumerStream)
+	rm.Arg("consumer", "The consumer to remove").Required().StringVar(&c.consumerName)
+	rm.Flag("force", "Remove without prompting").Short('f').UnNegatableBoolVar(&c.force)
+	c.addConnectionFlags(rm)
+	c.addAuditFlags(rm)
+}
+
+func (c *cmd) addAuditFlags(cmd *fisk.CmdClause) {
+	cmd.Flag("audit-subject", "The subject to publish an audit record of the action to").Default(config.DefaultAuditSubject).StringVar(&c.auditSubject)
+	cmd.Flag("audit-log", "A file to append an audit record of the action to").PlaceHolder("FILE").StringVar(&c.auditLog)
+}
+
+// cliAuditor records actions taken by CLI commands using their connection
+func (c *cmd) cliAuditor(mgr *jsm.Manager) *audit.Auditor {
+	return audit.NewWithConnection(mgr.NatsConn(), c.auditSubject, c.auditLog, logrus.NewEntry(logrus.New()))
+}
+
+// cliCaller identifies the user running CLI commands
+func cliCaller() audit.Caller {
+	caller := audit.Caller{Via: "cli"}
+
+	if u, err := user.Current(); err == nil {
+		caller.Identity = u.Username
+	}
+	caller.Address, _ = os.Hostname()
+
+	return caller
+}
+
+func (c *cmd) addConnectionFlags(cmd *fisk.CmdClause) {
+	cmd.Flag("context", "The NATS context to use for the connection").StringVar(&c.nCtx)
+	cmd.Flag("choria-seed", "The seed file to connect to Choria Brokers with").ExistingFileVar(&c.choriaSeed)
+	cmd.Flag("choria-token", "The JWT token file to connect to Choria Brokers with").ExistingFileVar(&c.choriaToken)
+	cmd.Flag("choria-collective", "The Choria collective you will be connecting to").Default("choria").StringVar(&c.choriaCollective)
+}
+
+func (c *cmd) connectManager() (*jsm.Manager, error) {
+	if c.nCtx == "" && natscontext.SelectedContext() == "" {
+		return nil, fmt.Errorf("a NATS context is required when a default context is not selected")
+	}
+
+	nc, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	return jsm.New(nc)
+}
+
+func (c *cmd) loadReplicatorConsumer(mgr *jsm.Manager) (*jsm.Consumer, error) {
+	consumer, err := mgr.LoadConsumer(c.consumerStream, c.consumerName)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isReplicatorConsumer(consumer.Description()) {
+		return nil, fmt.Errorf("consumer %s > %s was not created by the Stream Replicator", c.consumerStream, c.consumerName)
+	}
+
+	return consumer, nil
+}
+
+func isReplicatorConsumer(description string) bool {
+	return strings.HasPrefix(description, replicator.ConsumerDescriptionPrefix)
+}
+
+func (c *cmd) consumersListAction(_ *fisk.ParseContext) error {
+	mgr, err := c.connectManager()
+	if err != nil {
+		return err
+	}
+
+	consumers, err := mgr.Consumers(c.consumerStream)
+	if err != nil {
+		return err
+	}
+
+	var found []api.ConsumerInfo
+	for _, consumer := range consumers {
+		if !isReplicatorConsumer(consumer.Description()) {
+			continue
+		}
+
+		nfo, err := consumer.LatestState()
+		if err != nil {
+			return err
+		}
+
+		found = append(found, nfo)
+	}
+
+	if c.json {
+		if found == nil {
+			found = []api.ConsumerInfo{}
+		}
+
+		j, err := json.MarshalIndent(found, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(j))
+		return nil
+	}
+
+	if len(found) == 0 {
+		fmt.Printf("No Stream Replicator consumers found on %s\n", c.consumerStream)
+		return nil
+	}
+
+	for _, nfo := range found {
+		kind := "durable"
+		if nfo.Config.Durable == "" {
+			kind = "ephemeral"
+		}
+
+		lastActive := "never"
+		if nfo.Delivered.Last != nil {
+			lastActive = time.Since(*nfo.Delivered.Last).Round(time.Second).String()
+		}
+
+		fmt.Printf("%s (%s) description: %q pending: %d ack floor: %d last delivery: %s\n", nfo.Name, kind, nfo.Config.Description, nfo.NumPending, nfo.AckFloor.Stream, lastActive)
+	}
+
+	return nil
+}
+
+func (c *cmd) consumersInfoAction(_ *fisk.ParseContext) error {
+	mgr, err := c.connectManager()
+	if err != nil {
+		return err
+	}
+
+	consumer, err := c.loadReplicatorConsumer(mgr)
+	if err != nil {
+		return err
+	}
+
+	nfo, err := consumer.State()
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		j, err := json.MarshalIndent(nfo, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(j))
+		return nil
+	}
+
+	fmt.Printf("              Name: %s\n", nfo.Name)
+	fmt.Printf("            Stream: %s\n", nfo.Stream)
+	fmt.Printf("       Description: %s\n", nfo.Config.Description)
+	fmt.Printf("           Durable: %t\n", nfo.Config.Durable != "")
+	fmt.Printf("           Created: %v (%v)\n", nfo.Created, time.Since(nfo.Created).Round(time.Second))
+	if nfo.Config.FilterSubject != "" {
+		fmt.Printf("    Filter Subject: %s\n", nfo.Config.FilterSubject)
+	}
+	fmt.Printf("    Deliver Policy: %s\n", nfo.Config.DeliverPolicy)
+	fmt.Printf("    Last Delivered: %d\n", nfo.Delivered.Stream)
+	fmt.Printf("         Ack Floor: %d\n", nfo.AckFloor.Stream)
+	fmt.Printf("       Ack Pending: %d\n", nfo.NumAckPending)
+	fmt.Printf("       Redelivered: %d\n", nfo.NumRedelivered)
+	fmt.Printf("  Pending Messages: %d\n", nfo.NumPending)
+	if nfo.Cluster != nil && nfo.Cluster.Leader != "" {
+		fmt.Printf("            Leader: %s\n", nfo.Cluster.Leader)
+	}
+
+	return nil
+}
+
+func (c *cmd) consumersResetAction(_ *fisk.ParseContext) error {
+	if c.consumerSeq == 0 && c.consumerSince == "" {
+		return fmt.Errorf("either --sequence or --since is required")
+	}
+	if c.consumerSeq > 0 && c.consumerSince != "" {
+		return fmt.Errorf("only one of --sequence or --since can be set")
+	}
+
+	var since time.Duration
+	if c.consumerSince != "" {
+		var err error
+		since, err = util.ParseDurationString(c.consumerSince)
+		if err != nil {
+			return fmt.Errorf("invalid since: %v", err)
+		}
+	}
+
+	mgr, err := c.connectManager()
+	if err != nil {
+		return err
+	}
+
+	consumer, err := c.loadReplicatorConsumer(mgr)
+	if err != nil {
+		return err
+	}
+
+	if !consumer.IsDurable() {
+		return fmt.Errorf("only durable consumers can be reset, ephemeral consumers are managed by the replicator")
+	}
+
+	if !c.force {
+		ok, err := askConfirmation(fmt.Sprintf("Really reset consumer %s > %s", c.consumerStream, c.consumerName))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+	}
+
+	cfg := consumer.Configuration()
+	cfg.OptStartSeq = 0
+	cfg.OptStartTime = nil
+
+	var opts []jsm.ConsumerOption
+	if c.consumerSeq > 0 {
+		opts = append(opts, jsm.StartAtSequence(c.consumerSeq))
+	} else {
+		opts = append(opts, jsm.StartAtTimeDelta(since))
+	}
+
+	details := map[string]string{"consumer": c.consumerName}
+	if c.consumerSeq > 0 {
+		details["sequence"] = strconv.FormatUint(c.consumerSeq, 10)
+	} else {
+		details["since"] = since.String()
+	}
+
+	consumer, err = c.recreateConsumer(mgr, consumer, cfg, opts)
+	c.cliAuditor(mgr).Record(audit.ConsumerResetAction, cliCaller(), c.consumerStream, "Consumer reset using the CLI", details, err)
+	if err != nil {
+		return err
+	}
+
+	nfo, err := consumer.State()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Consumer %s > %s was reset, %d messages pending\n", c.consumerStream, c.consumerName, nfo.NumPending)
+
+	return nil
+}
+
+func (c *cmd) recreateConsumer(mgr *jsm.Manager, consumer *jsm.Consumer, cfg api.ConsumerConfig, opts []jsm.ConsumerOption) (*jsm.Consumer, error) {
+	err := consumer.Delete()
+	if err != nil {
+		return nil, fmt.Errorf("could not remove consumer: %v", err)
+	}
+
+	consumer, err = mgr.NewConsumerFromDefault(c.consumerStream, cfg, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("could not recreate consumer: %v", err)
+	}
+
+	return consumer, nil
+}
+
+func (c *cmd) consumersRmAction(_ *fisk.ParseContext) error {
+	mgr, err := c.connectManager()
+	if err != nil {
+		return err
+	}
+
+	consumer, err := c.loadReplicatorConsumer(mgr)
+	if err != nil {
+		return err
+	}
+
+	if !c.force {
+		ok, err := askConfirmation(fmt.Sprintf("Really remove consumer %s > %s", c.consumerStream, c.consumerName))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+	}
+
+	err = consumer.Delete()
+	c.cliAuditor(mgr).Record(audit.ConsumerRemoveAction, cliCaller(), c.consumerStream, "Consumer removed using the CLI", map[string]string{"consumer": c.consumerName}, err)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Removed consumer %s > %s\n", c.consumerStream, c.consumerName)
+
+	return nil
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/choria-io/fisk"
+	"github.com/choria-io/stream-replicator/audit"
+	"github.com/choria-io/stream-replicator/replicator"
+)
+
+type failoverRequest struct {
+	Stream string `json:"stream"`
+	Reason string `json:"reason"`
+}
+
+type failoverResponse struct {
+	Streams []string `json:"streams"`
+}
+
+func (c *cmd) configureFailoverCommand(admin *fisk.CmdClause) {
+	fo := admin.Command("failover", "Fails a stream over, reversing the replication direction, on a running replicator").Action(c.failoverAction)
+	fo.Arg("stream", "The stream name or source stream to fail over").Required().StringVar(&c.failoverStream)
+	fo.Flag("reason", "The reason for failing over").Default("failover requested by operator").StringVar(&c.failoverReason)
+	fo.Flag("config", "Configuration file used to determine the monitor port").ExistingFileVar(&c.cfgile)
+	fo.Flag("url", "The URL of the replicator monitor port").StringVar(&c.failoverURL)
+	c.configureAdminAPIClientFlags(fo)
+	fo.Flag("force", "Fail over without prompting").Short('f').UnNegatableBoolVar(&c.force)
+}
+
+func (c *cmd) failoverAction(_ *fisk.ParseContext) error {
+	err := c.configureAdminAPIClient(&c.failoverURL, "failing over requires monitor_port and admin_api to be set")
+	if err != nil {
+		return err
+	}
+
+	if !c.force {
+		ok, err := askConfirmation(fmt.Sprintf("Really fail over %s, replication will only resume in the original direction once the fencing marker is removed", c.failoverStream))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+	}
+
+	body, err := json.Marshal(failoverRequest{Stream: c.failoverStream, Reason: c.failoverReason})
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := c.adminAPIRequest(&client, http.MethodPost, strings.TrimSuffix(c.failoverURL, "/")+"/api/v1/failover", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("could not fail over: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := adminError{}
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("could not fail over: %s", apiErr.Error)
+		}
+
+		return fmt.Errorf("could not fail over: %s", resp.Status)
+	}
+
+	res := failoverResponse{}
+	err = json.Unmarshal(body, &res)
+	if err != nil {
+		return fmt.Errorf("invalid response received: %v", err)
+	}
+
+	fmt.Printf("Failing over %s\n", strings.Join(res.Streams, ", "))
+
+	return nil
+}
+
+func (c *cmd) apiFailover(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		c.apiError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+		return
+	}
+
+	req := failoverRequest{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.apiError(w, http.StatusBadRequest, "invalid request: %v", err)
+		return
+	}
+	if req.Stream == "" {
+		c.apiError(w, http.StatusBadRequest, "stream is required")
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = "failover requested via the admin API"
+	}
+
+	var triggered []string
+	for _, s := range c.runningStreams() {
+		if s.cfg.Name != req.Stream && s.cfg.Stream != req.Stream {
+			continue
+		}
+
+		f, ok := s.stream.(*replicator.Failover)
+		if !ok {
+			continue
+		}
+
+		err = f.Trigger(req.Reason)
+		if err != nil {
+			break
+		}
+		triggered = append(triggered, s.name)
+	}
+
+	if err == nil && len(triggered) == 0 {
+		err = fmt.Errorf("no stream %s with failover configured", req.Stream)
+	}
+
+	c.audit.Record(audit.FailoverAction, apiCaller(r), req.Stream, "Failover requested via the admin API", map[string]string{"reason": req.Reason}, err)
+	if err != nil {
+		c.apiError(w, http.StatusConflict, "%v", err)
+		return
+	}
+
+	c.log.Warnf("Failover of stream %s requested via the admin API: %s", req.Stream, req.Reason)
+
+	c.apiRespond(w, http.StatusOK, failoverResponse{Streams: triggered})
+}
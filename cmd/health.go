@@ -0,0 +1,78 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// healthTimeout is how long a copier can be inactive before the replicator is considered unhealthy
+const healthTimeout = time.Minute
+
+func (c *cmd) setupHealthChecks(mux *http.ServeMux) {
+	c.log.Infof("Listening for health checks on /healthz and /readyz")
+	mux.HandleFunc("/healthz", c.healthz)
+	mux.HandleFunc("/readyz", c.readyz)
+}
+
+func (c *cmd) runningStreams() []*runningStream {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.running
+}
+
+// healthz is a liveness probe that fails while any ready copier has not been active for healthTimeout
+func (c *cmd) healthz(w http.ResponseWriter, _ *http.Request) {
+	var problems []string
+
+	for _, s := range c.runningStreams() {
+		if s.hasExited() || !s.isReady() {
+			continue
+		}
+
+		if since := time.Since(s.stream.LastActive()); since > healthTimeout {
+			problems = append(problems, fmt.Sprintf("%s: copier has not been active for %v", s.name, since.Round(time.Second)))
+		}
+	}
+
+	healthResponse(w, problems)
+}
+
+// readyz is a readiness probe that fails until all streams are connected and have set up their elections
+func (c *cmd) readyz(w http.ResponseWriter, _ *http.Request) {
+	running := c.runningStreams()
+
+	var problems []string
+	if running == nil {
+		problems = append(problems, "streams are not started")
+	}
+
+	for _, s := range running {
+		switch {
+		case s.hasExited():
+			problems = append(problems, fmt.Sprintf("%s: stopped", s.name))
+		case !s.isReady():
+			problems = append(problems, fmt.Sprintf("%s: not ready", s.name))
+		}
+	}
+
+	healthResponse(w, problems)
+}
+
+func healthResponse(w http.ResponseWriter, problems []string) {
+	w.Header().Set("Content-Type", "text/plain")
+
+	if len(problems) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, strings.Join(problems, "\n"))
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/choria-io/fisk"
+	"github.com/choria-io/stream-replicator/heartbeat"
+)
+
+func (c *cmd) configureHeartbeatsCommand(admin *fisk.CmdClause) {
+	hb := admin.Command("heartbeats", "Shows the last heartbeat of every originator recorded in the heartbeat bucket").Alias("hb").Action(c.heartbeatsAction)
+	hb.Flag("bucket", "The Key-Value bucket heartbeats are recorded in").Default(heartbeat.DefaultBucket).StringVar(&c.heartbeatBucket)
+	hb.Flag("json", "Render JSON values").UnNegatableBoolVar(&c.json)
+	c.addConnectionFlags(hb)
+}
+
+func (c *cmd) heartbeatsAction(_ *fisk.ParseContext) error {
+	mgr, err := c.connectManager()
+	if err != nil {
+		return err
+	}
+
+	js, err := mgr.NatsConn().JetStream()
+	if err != nil {
+		return err
+	}
+
+	kv, err := js.KeyValue(c.heartbeatBucket)
+	if err != nil {
+		return fmt.Errorf("could not access heartbeat bucket %s: %v", c.heartbeatBucket, err)
+	}
+
+	seen, err := heartbeat.LoadLastSeen(kv)
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		j, err := json.MarshalIndent(seen, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(j))
+
+		return nil
+	}
+
+	if len(seen) == 0 {
+		fmt.Println("No heartbeats are recorded")
+		return nil
+	}
+
+	for _, s := range seen {
+		fmt.Printf("%s (%s): last heartbeat on %s %v ago at %v\n", s.Originator, s.Replicator, s.Subject, time.Since(s.Time).Round(time.Second), s.Time.Local())
+	}
+
+	return nil
+}
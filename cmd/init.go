@@ -0,0 +1,270 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/choria-io/fisk"
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/util"
+	"github.com/ghodss/yaml"
+	"github.com/nats-io/jsm.go"
+	"github.com/sirupsen/logrus"
+)
+
+func (c *cmd) configureInitCommand(app *fisk.Application) {
+	initCmd := app.Command("init", "Interactively creates a starter configuration file").Action(c.initAction)
+	initCmd.Arg("file", "The configuration file to write").Default("sr.yaml").StringVar(&c.cfgile)
+	initCmd.Flag("force", "Overwrite existing files without prompting").Short('f').UnNegatableBoolVar(&c.force)
+}
+
+// urlWithCredentials adds a credentials file to a url using the format ConnectNats supports
+func urlWithCredentials(u string, creds string) (string, error) {
+	if creds == "" {
+		return u, nil
+	}
+
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+
+	q := parsed.Query()
+	q.Set("credentials", creds)
+	// keeps paths readable in the configuration file
+	parsed.RawQuery = strings.ReplaceAll(q.Encode(), "%2F", "/")
+
+	return parsed.String(), nil
+}
+
+func (c *cmd) askConnection(kind string) (string, error) {
+	u, err := askString(fmt.Sprintf("%s NATS Server URL", kind), "", true)
+	if err != nil {
+		return "", err
+	}
+
+	creds, err := askString(fmt.Sprintf("%s credentials file (optional)", kind), "", false)
+	if err != nil {
+		return "", err
+	}
+
+	if creds != "" {
+		_, err := os.Stat(creds)
+		if err != nil {
+			fmt.Printf("WARNING: could not access credentials %s: %v\n", creds, err)
+		}
+	}
+
+	return urlWithCredentials(u, creds)
+}
+
+func (c *cmd) discoverStreams(srv string) ([]string, error) {
+	log := logrus.New()
+	log.SetLevel(logrus.FatalLevel)
+	if c.debug {
+		log.SetLevel(logrus.DebugLevel)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	nc, err := util.ConnectNats(ctx, "init", srv, nil, nil, false, nil, logrus.NewEntry(log))
+	if err != nil {
+		return nil, err
+	}
+	defer nc.Close()
+
+	mgr, err := jsm.New(nc)
+	if err != nil {
+		return nil, err
+	}
+
+	return mgr.StreamNames(nil)
+}
+
+func (c *cmd) askStreams(known []string) ([]string, error) {
+	if len(known) > 0 {
+		fmt.Println()
+		fmt.Println("Streams found on the Source:")
+		fmt.Println()
+		for i, s := range known {
+			fmt.Printf("  %3d) %s\n", i+1, s)
+		}
+		fmt.Println()
+	}
+
+	for {
+		ans, err := askString("Streams to replicate, comma separated names or numbers", "", true)
+		if err != nil {
+			return nil, err
+		}
+
+		var selected []string
+		var invalid bool
+
+		for _, s := range strings.Split(ans, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				continue
+			}
+
+			idx, err := strconv.Atoi(s)
+			if err == nil {
+				if idx < 1 || idx > len(known) {
+					fmt.Printf("Invalid stream number %d\n", idx)
+					invalid = true
+					break
+				}
+				s = known[idx-1]
+			}
+
+			selected = append(selected, s)
+		}
+
+		if invalid || len(selected) == 0 {
+			continue
+		}
+
+		return selected, nil
+	}
+}
+
+func (c *cmd) initAction(_ *fisk.ParseContext) error {
+	if _, err := os.Stat(c.cfgile); err == nil && !c.force {
+		ok, err := askConfirmation(fmt.Sprintf("%s already exist, overwrite", c.cfgile))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+	}
+
+	fmt.Println("Choria Stream Replicator configuration")
+	fmt.Println()
+
+	hostname, _ := os.Hostname()
+	name, err := askString("Replicator name, unique per location", hostname, true)
+	if err != nil {
+		return err
+	}
+
+	stateDir, err := askString("State directory", "/var/lib/stream-replicator", false)
+	if err != nil {
+		return err
+	}
+
+	port, err := askString("Prometheus monitor port, 0 to disable", "8080", true)
+	if err != nil {
+		return err
+	}
+	monitorPort, err := strconv.Atoi(port)
+	if err != nil {
+		return fmt.Errorf("invalid port: %v", err)
+	}
+
+	fmt.Println()
+	sourceURL, err := c.askConnection("Source")
+	if err != nil {
+		return err
+	}
+
+	known, err := c.discoverStreams(sourceURL)
+	if err != nil {
+		fmt.Printf("WARNING: could not list streams on the Source: %v\n", err)
+	}
+
+	fmt.Println()
+	targetURL, err := c.askConnection("Target")
+	if err != nil {
+		return err
+	}
+
+	streams, err := c.askStreams(known)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println()
+	targetInitiated, err := askBool("Is the replicator running nearest to the Target", true)
+	if err != nil {
+		return err
+	}
+
+	var streamsCfg []map[string]any
+	for _, s := range streams {
+		scfg := map[string]any{
+			"stream":     s,
+			"source_url": sourceURL,
+			"target_url": targetURL,
+		}
+
+		if targetInitiated {
+			fmt.Println()
+			filter, err := askString(fmt.Sprintf("Filter subject for %s, required when running nearest to the Target", s), ">", true)
+			if err != nil {
+				return err
+			}
+
+			scfg["target_initiated"] = true
+			scfg["filter_subject"] = filter
+		}
+
+		streamsCfg = append(streamsCfg, scfg)
+	}
+
+	cfg := map[string]any{
+		"name":     name,
+		"loglevel": "info",
+		"streams":  streamsCfg,
+	}
+	if stateDir != "" {
+		cfg["state_store"] = stateDir
+	}
+	if monitorPort > 0 {
+		cfg["monitor_port"] = monitorPort
+	}
+
+	// round trip the configuration through the parser to ensure it's valid, we skip
+	// the state directory as that would create it on this machine
+	j, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	parsed := &config.Config{}
+	err = json.Unmarshal(j, parsed)
+	if err != nil {
+		return err
+	}
+	parsed.StateDirectory = ""
+	err = parsed.Validate()
+	if err != nil {
+		return fmt.Errorf("generated configuration is invalid: %v", err)
+	}
+
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(c.cfgile, out, 0600)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println()
+	fmt.Printf("Wrote configuration to %s, start the replicator with:\n\n", c.cfgile)
+	fmt.Printf("   stream-replicator replicate --config %s\n", c.cfgile)
+
+	return nil
+}
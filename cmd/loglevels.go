@@ -0,0 +1,186 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/sirupsen/logrus"
+)
+
+// logLevels manages the log levels of the main logger and those of every stream
+// so that they can be adjusted at runtime, streams follow the main level unless
+// a level was set for them specifically in the configuration or at runtime
+type logLevels struct {
+	root    *logrus.Logger
+	streams []*streamLogger
+	files   map[string]io.Writer
+	mu      sync.Mutex
+}
+
+type streamLogger struct {
+	stream   string
+	name     string
+	logger   *logrus.Logger
+	override bool
+	// configured is the level set in the stream configuration, runtime resets return to it
+	configured *logrus.Level
+}
+
+func newLogLevels(root *logrus.Logger) *logLevels {
+	return &logLevels{root: root, files: make(map[string]io.Writer)}
+}
+
+// parseLogLevel parses the levels supported in configuration files, defaults to info
+func parseLogLevel(level string) logrus.Level {
+	switch level {
+	case "debug":
+		return logrus.DebugLevel
+	case "warn":
+		return logrus.WarnLevel
+	default:
+		return logrus.InfoLevel
+	}
+}
+
+// forStream creates a logger for a stream that shares output, format and hooks with the main logger unless the
+// stream sets its own log file, the stream level follows the main level unless set in its configuration
+func (l *logLevels) forStream(s *config.Stream) (*logrus.Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	logger := &logrus.Logger{
+		Out:          l.root.Out,
+		Formatter:    l.root.Formatter,
+		Hooks:        l.root.Hooks,
+		ReportCaller: l.root.ReportCaller,
+		ExitFunc:     l.root.ExitFunc,
+		Level:        l.root.GetLevel(),
+	}
+
+	if s.LogFile != "" {
+		out, ok := l.files[s.LogFile]
+		if !ok {
+			file, err := os.OpenFile(s.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+			if err != nil {
+				return nil, fmt.Errorf("could not open log file for stream %s: %v", s.Stream, err)
+			}
+			out = file
+			l.files[s.LogFile] = out
+		}
+
+		logger.Out = out
+		logger.Formatter = &logrus.JSONFormatter{}
+		logger.Hooks = make(logrus.LevelHooks)
+	}
+
+	sl := &streamLogger{stream: s.Stream, name: s.Name, logger: logger}
+	if s.LogLevel != "" {
+		level := parseLogLevel(s.LogLevel)
+		sl.configured = &level
+		sl.override = true
+		logger.SetLevel(level)
+	}
+
+	l.streams = append(l.streams, sl)
+
+	return logrus.NewEntry(logger), nil
+}
+
+// SetLevel sets the level of the main logger and all streams without a specific level
+func (l *logLevels) SetLevel(level logrus.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.root.SetLevel(level)
+	for _, s := range l.streams {
+		if !s.override {
+			s.logger.SetLevel(level)
+		}
+	}
+}
+
+// SetStreamLevel sets the level for streams matching stream by name or source stream
+func (l *logLevels) SetStreamLevel(stream string, level logrus.Level) error {
+	return l.updateStreams(stream, func(s *streamLogger) {
+		s.logger.SetLevel(level)
+		s.override = true
+	})
+}
+
+// ResetStreamLevel makes streams matching stream use their configured level or follow the main level again
+func (l *logLevels) ResetStreamLevel(stream string) error {
+	return l.updateStreams(stream, func(s *streamLogger) {
+		if s.configured != nil {
+			s.logger.SetLevel(*s.configured)
+			return
+		}
+
+		s.logger.SetLevel(l.root.GetLevel())
+		s.override = false
+	})
+}
+
+func (l *logLevels) updateStreams(stream string, cb func(s *streamLogger)) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	found := false
+	for _, s := range l.streams {
+		if s.name == stream || s.stream == stream {
+			cb(s)
+			found = true
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("unknown stream %s", stream)
+	}
+
+	return nil
+}
+
+// Increase makes the main logger more verbose
+func (l *logLevels) Increase() logrus.Level {
+	level := l.root.GetLevel()
+	if level < logrus.TraceLevel {
+		level++
+	}
+
+	l.SetLevel(level)
+
+	return level
+}
+
+// Decrease makes the main logger less verbose
+func (l *logLevels) Decrease() logrus.Level {
+	level := l.root.GetLevel()
+	if level > logrus.ErrorLevel {
+		level--
+	}
+
+	l.SetLevel(level)
+
+	return level
+}
+
+// Levels reports the main level and that of streams with specific levels
+func (l *logLevels) Levels() (string, map[string]string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	streams := make(map[string]string)
+	for _, s := range l.streams {
+		if s.override {
+			streams[fmt.Sprintf("%s_%s", s.stream, s.name)] = s.logger.GetLevel().String()
+		}
+	}
+
+	return l.root.GetLevel().String(), streams
+}
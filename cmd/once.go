@@ -0,0 +1,114 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/replicator"
+)
+
+// replicateOnce copies every stream up to the end of its source, reports on each and fails when any stream failed
+func (c *cmd) replicateOnce(ctx context.Context, cancel context.CancelFunc, cfg *config.Config) error {
+	go c.interruptHandler(ctx, cancel)
+
+	ctx, cancel = context.WithTimeout(ctx, c.onceTimeout)
+	wg := &sync.WaitGroup{}
+
+	// stops the events publishers once done
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	var streams []*replicator.Stream
+
+	for _, p := range cfg.AllProfiles() {
+		log := c.log
+		if p != cfg {
+			log = log.WithField("profile", p.ReplicatorName)
+		}
+
+		opts, err := c.startEvents(ctx, wg, p, log)
+		if err != nil {
+			return err
+		}
+
+		for _, s := range p.Streams {
+			if s.Failover != nil {
+				return fmt.Errorf("stream %s uses failover and can not be copied once", s.Stream)
+			}
+
+			slog, err := c.levels.forStream(s)
+			if err != nil {
+				return err
+			}
+			if p != cfg {
+				slog = slog.WithField("profile", p.ReplicatorName)
+			}
+
+			stream, err := replicator.NewStream(s, p, slog, opts...)
+			if err != nil {
+				return err
+			}
+			streams = append(streams, stream)
+		}
+	}
+
+	summaries := make([]*replicator.OnceSummary, len(streams))
+	swg := &sync.WaitGroup{}
+
+	for i, stream := range streams {
+		swg.Add(1)
+		go func(i int, stream *replicator.Stream) {
+			defer swg.Done()
+
+			summaries[i], _ = stream.RunOnce(ctx)
+		}(i, stream)
+	}
+
+	swg.Wait()
+
+	failed := 0
+	for _, s := range summaries {
+		if s.Error != "" || s.Failed > 0 {
+			failed++
+		}
+	}
+
+	if c.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(summaries)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, s := range summaries {
+			took := s.Duration.Round(time.Millisecond)
+
+			switch {
+			case s.Error != "":
+				fmt.Printf("%s (%s): failed after copying %d and skipping %d message(s) in %v: %s\n", s.Stream, s.Name, s.Copied, s.Skipped, took, s.Error)
+			case s.Failed > 0:
+				fmt.Printf("%s (%s): copied %d and skipped %d message(s) up to source sequence %d in %v, %d failed attempt(s)\n", s.Stream, s.Name, s.Copied, s.Skipped, s.SourceSequence, took, s.Failed)
+			default:
+				fmt.Printf("%s (%s): copied %d and skipped %d message(s) up to source sequence %d in %v\n", s.Stream, s.Name, s.Copied, s.Skipped, s.SourceSequence, took)
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d stream(s) failed", failed, len(summaries))
+	}
+
+	return nil
+}
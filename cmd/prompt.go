@@ -0,0 +1,83 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var stdin = bufio.NewReader(os.Stdin)
+
+func askConfirmation(prompt string) (bool, error) {
+	fmt.Printf("%s (y/N) ", prompt)
+
+	ans, err := stdin.ReadString('\n')
+	if err != nil {
+		return false, err
+	}
+
+	ans = strings.ToLower(strings.TrimSpace(ans))
+
+	return ans == "y" || ans == "yes", nil
+}
+
+// askString prompts for a value, returning dflt when nothing was entered
+func askString(prompt string, dflt string, required bool) (string, error) {
+	for {
+		if dflt != "" {
+			fmt.Printf("%s [%s]: ", prompt, dflt)
+		} else {
+			fmt.Printf("%s: ", prompt)
+		}
+
+		ans, err := stdin.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+
+		ans = strings.TrimSpace(ans)
+		if ans == "" {
+			ans = dflt
+		}
+
+		if ans == "" && required {
+			fmt.Println("A value is required")
+			continue
+		}
+
+		return ans, nil
+	}
+}
+
+// askBool prompts for a yes or no answer, returning dflt when nothing was entered
+func askBool(prompt string, dflt bool) (bool, error) {
+	hint := "y/N"
+	if dflt {
+		hint = "Y/n"
+	}
+
+	for {
+		fmt.Printf("%s (%s) ", prompt, hint)
+
+		ans, err := stdin.ReadString('\n')
+		if err != nil {
+			return false, err
+		}
+
+		switch strings.ToLower(strings.TrimSpace(ans)) {
+		case "":
+			return dflt, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		default:
+			fmt.Println("Please answer yes or no")
+		}
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/choria-io/fisk"
+	"github.com/choria-io/stream-replicator/audit"
+	"github.com/choria-io/stream-replicator/internal/util"
+	"github.com/choria-io/stream-replicator/replicator"
+)
+
+type quiesceRequest struct {
+	Stream  string `json:"stream,omitempty"`
+	Timeout string `json:"timeout,omitempty"`
+	Resume  bool   `json:"resume,omitempty"`
+}
+
+type quiesceResponse struct {
+	Streams []*replicator.QuiesceState `json:"streams,omitempty"`
+	Resumed []string                   `json:"resumed,omitempty"`
+}
+
+// quiescer is a running stream that can be quiesced
+type quiescer interface {
+	Quiesce(context.Context) (*replicator.QuiesceState, error)
+	Resume() bool
+}
+
+func (c *cmd) configureQuiesceCommand(admin *fisk.CmdClause) {
+	q := admin.Command("quiesce", "Pauses copying on a running replicator at a consistent point, for example for backups").Action(c.quiesceAction)
+	q.Arg("stream", "The stream name or source stream to quiesce, all streams when not set").StringVar(&c.quiesceStream)
+	q.Flag("resume", "Resumes copying of quiesced streams").UnNegatableBoolVar(&c.quiesceResume)
+	q.Flag("timeout", "How long to wait for in-flight messages to be copied").Default("1m").StringVar(&c.quiesceTimeout)
+	q.Flag("config", "Configuration file used to determine the monitor port").ExistingFileVar(&c.cfgile)
+	q.Flag("url", "The URL of the replicator monitor port").StringVar(&c.quiesceURL)
+	c.configureAdminAPIClientFlags(q)
+	q.Flag("json", "Render JSON output").UnNegatableBoolVar(&c.json)
+}
+
+func (c *cmd) quiesceAction(_ *fisk.ParseContext) error {
+	err := c.configureAdminAPIClient(&c.quiesceURL, "quiescing requires monitor_port and admin_api to be set")
+	if err != nil {
+		return err
+	}
+
+	timeout, err := util.ParseDurationString(c.quiesceTimeout)
+	if err != nil {
+		return fmt.Errorf("invalid timeout: %v", err)
+	}
+
+	body, err := json.Marshal(quiesceRequest{Stream: c.quiesceStream, Timeout: c.quiesceTimeout, Resume: c.quiesceResume})
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{Timeout: timeout + 10*time.Second}
+	resp, err := c.adminAPIRequest(&client, http.MethodPost, strings.TrimSuffix(c.quiesceURL, "/")+"/api/v1/quiesce", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("could not quiesce: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := adminError{}
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("could not quiesce: %s", apiErr.Error)
+		}
+
+		return fmt.Errorf("could not quiesce: %s", resp.Status)
+	}
+
+	res := quiesceResponse{}
+	err = json.Unmarshal(body, &res)
+	if err != nil {
+		return fmt.Errorf("invalid response received: %v", err)
+	}
+
+	if c.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(res)
+	}
+
+	if c.quiesceResume {
+		fmt.Printf("Resumed %s\n", strings.Join(res.Resumed, ", "))
+		return nil
+	}
+
+	for _, s := range res.Streams {
+		if !s.Leader {
+			fmt.Printf("%s (%s): quiesced on a standby replicator\n", s.Stream, s.Name)
+			continue
+		}
+
+		fmt.Printf("%s (%s): quiesced at source sequence %d, %s at target sequence %d\n", s.Stream, s.Name, s.SourceSequence, s.TargetStream, s.TargetSequence)
+	}
+
+	return nil
+}
+
+func (c *cmd) apiQuiesce(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		c.apiError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+		return
+	}
+
+	req := quiesceRequest{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.apiError(w, http.StatusBadRequest, "invalid request: %v", err)
+		return
+	}
+
+	timeout := time.Minute
+	if req.Timeout != "" {
+		timeout, err = util.ParseDurationString(req.Timeout)
+		if err != nil {
+			c.apiError(w, http.StatusBadRequest, "invalid timeout: %v", err)
+			return
+		}
+	}
+
+	streams := map[string]quiescer{}
+	for _, s := range c.runningStreams() {
+		if req.Stream != "" && s.cfg.Name != req.Stream && s.cfg.Stream != req.Stream {
+			continue
+		}
+
+		q, ok := s.stream.(quiescer)
+		if !ok || s.hasExited() {
+			continue
+		}
+		streams[s.name] = q
+	}
+
+	if len(streams) == 0 {
+		err = fmt.Errorf("no running stream %s", req.Stream)
+		c.audit.Record(audit.QuiesceAction, apiCaller(r), req.Stream, "Quiesce requested via the admin API", nil, err)
+		c.apiError(w, http.StatusNotFound, "%v", err)
+		return
+	}
+
+	if req.Resume {
+		res := quiesceResponse{}
+		for name, q := range streams {
+			if q.Resume() {
+				res.Resumed = append(res.Resumed, name)
+			}
+		}
+
+		c.audit.Record(audit.ResumeAction, apiCaller(r), req.Stream, "Resume after quiescing requested via the admin API", nil, nil)
+		c.log.Warnf("Resumed %d quiesced stream(s) via the admin API", len(res.Resumed))
+		c.apiRespond(w, http.StatusOK, res)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	// all streams are paused together so they stop as close to the same moment as possible
+	res := quiesceResponse{}
+	var errs []string
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for name, q := range streams {
+		wg.Add(1)
+		go func(name string, q quiescer) {
+			defer wg.Done()
+
+			state, err := q.Quiesce(ctx)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+				return
+			}
+			res.Streams = append(res.Streams, state)
+		}(name, q)
+	}
+	wg.Wait()
+
+	// a partial cut-off is not consistent so every stream resumes when any fails
+	if len(errs) > 0 {
+		for _, q := range streams {
+			q.Resume()
+		}
+
+		err = fmt.Errorf("%s", strings.Join(errs, ", "))
+		c.audit.Record(audit.QuiesceAction, apiCaller(r), req.Stream, "Quiesce requested via the admin API", nil, err)
+		c.apiError(w, http.StatusConflict, "%v", err)
+		return
+	}
+
+	c.audit.Record(audit.QuiesceAction, apiCaller(r), req.Stream, "Quiesce requested via the admin API", map[string]string{"streams": fmt.Sprintf("%d", len(res.Streams))}, nil)
+	c.log.Warnf("Quiesced %d stream(s) via the admin API", len(res.Streams))
+
+	c.apiRespond(w, http.StatusOK, res)
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/choria-io/fisk"
+	"github.com/choria-io/stream-replicator/internal/report"
+)
+
+func (c *cmd) configureReportCommand(app *fisk.Application) {
+	rep := app.Command("report", "Produce a JSON summary of replication activity from a running replicator").Action(c.reportAction)
+	rep.Flag("config", "Configuration file used to determine the monitor port").ExistingFileVar(&c.cfgile)
+	rep.Flag("url", "The URL of the replicator monitor port").StringVar(&c.reportURL)
+	c.configureAdminAPIClientFlags(rep)
+	rep.Flag("since", "The time window to report on, expressed as a duration like 1h").Default("1h").StringVar(&c.reportSince)
+	rep.Flag("output", "Write the report to a file rather than STDOUT").Short('o').StringVar(&c.reportOutput)
+}
+
+func (c *cmd) reportAction(_ *fisk.ParseContext) error {
+	err := c.configureAdminAPIClient(&c.reportURL, "reports require monitor_port and admin_api to be set")
+	if err != nil {
+		return err
+	}
+
+	u, err := url.Parse(strings.TrimSuffix(c.reportURL, "/") + "/api/v1/report")
+	if err != nil {
+		return err
+	}
+	u.RawQuery = url.Values{"since": []string{c.reportSince}}.Encode()
+
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := c.adminAPIRequest(&client, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("could not retrieve report: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := adminError{}
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("could not retrieve report: %s", apiErr.Error)
+		}
+
+		return fmt.Errorf("could not retrieve report: %s", resp.Status)
+	}
+
+	rep := report.Summary{}
+	err = json.Unmarshal(body, &rep)
+	if err != nil {
+		return fmt.Errorf("invalid report received: %v", err)
+	}
+
+	j, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if c.reportOutput != "" {
+		return os.WriteFile(c.reportOutput, append(j, '\n'), 0644)
+	}
+
+	fmt.Println(string(j))
+
+	return nil
+}
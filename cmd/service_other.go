@@ -0,0 +1,14 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+
+package cmd
+
+import (
+	"github.com/choria-io/fisk"
+)
+
+// configureServiceCommand is only supported on windows, elsewhere use systemd or similar
+func (c *cmd) configureServiceCommand(_ *fisk.Application) {}
@@ -0,0 +1,184 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/choria-io/fisk"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+func (c *cmd) configureServiceCommand(app *fisk.Application) {
+	service := app.Command("service", "Manages the Windows service")
+
+	install := service.Command("install", "Installs the Windows service").Action(c.serviceInstallAction)
+	install.Flag("config", "Configuration file").Required().ExistingFileVar(&c.cfgile)
+	install.Flag("name", "The name of the service").Default("stream-replicator").StringVar(&c.svcName)
+
+	uninstall := service.Command("uninstall", "Removes the Windows service").Action(c.serviceUninstallAction)
+	uninstall.Flag("name", "The name of the service").Default("stream-replicator").StringVar(&c.svcName)
+
+	run := service.Command("run", "Runs the replicator under the Windows Service Control Manager").Hidden().Action(c.serviceRunAction)
+	run.Flag("config", "Configuration file").Required().ExistingFileVar(&c.cfgile)
+	run.Flag("name", "The name of the service").Default("stream-replicator").StringVar(&c.svcName)
+}
+
+func (c *cmd) serviceInstallAction(_ *fisk.ParseContext) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := filepath.Abs(c.cfgile)
+	if err != nil {
+		return err
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("could not connect to the service manager: %v", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(c.svcName)
+	if err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exist", c.svcName)
+	}
+
+	s, err = m.CreateService(c.svcName, exe, mgr.Config{
+		DisplayName: "Choria Stream Replicator",
+		Description: "The Choria NATS JetStream Stream Replicator",
+		StartType:   mgr.StartAutomatic,
+	}, "service", "run", "--config", cfg, "--name", c.svcName)
+	if err != nil {
+		return fmt.Errorf("could not create service: %v", err)
+	}
+	defer s.Close()
+
+	err = eventlog.InstallAsEventCreate(c.svcName, eventlog.Error|eventlog.Warning|eventlog.Info)
+	if err != nil {
+		s.Delete()
+		return fmt.Errorf("could not set up event log: %v", err)
+	}
+
+	fmt.Printf("Installed service %s using configuration %s\n", c.svcName, cfg)
+
+	return nil
+}
+
+func (c *cmd) serviceUninstallAction(_ *fisk.ParseContext) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("could not connect to the service manager: %v", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(c.svcName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", c.svcName)
+	}
+	defer s.Close()
+
+	status, err := s.Query()
+	if err == nil && status.State != svc.Stopped {
+		_, err = s.Control(svc.Stop)
+		if err != nil {
+			return fmt.Errorf("could not stop service: %v", err)
+		}
+
+		timeout := time.Now().Add(30 * time.Second)
+		for status.State != svc.Stopped && time.Now().Before(timeout) {
+			time.Sleep(500 * time.Millisecond)
+			status, err = s.Query()
+			if err != nil {
+				return fmt.Errorf("could not query service status: %v", err)
+			}
+		}
+	}
+
+	err = s.Delete()
+	if err != nil {
+		return fmt.Errorf("could not remove service: %v", err)
+	}
+
+	err = eventlog.Remove(c.svcName)
+	if err != nil {
+		return fmt.Errorf("could not remove event log: %v", err)
+	}
+
+	fmt.Printf("Removed service %s\n", c.svcName)
+
+	return nil
+}
+
+func (c *cmd) serviceRunAction(_ *fisk.ParseContext) error {
+	return svc.Run(c.svcName, &windowsService{c: c})
+}
+
+type windowsService struct {
+	c *cmd
+}
+
+// Execute implements svc.Handler
+func (w *windowsService) Execute(_ []string, reqs <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	elog, err := eventlog.Open(w.c.svcName)
+	if err == nil {
+		defer elog.Close()
+	}
+
+	status <- svc.Status{State: svc.StartPending}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- w.c.replicate(ctx, cancel)
+	}()
+
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case req := <-reqs:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				cancel()
+
+				select {
+				case <-errs:
+				case <-time.After(20 * time.Second):
+				}
+
+				return false, 0
+			}
+
+		case err := <-errs:
+			if err != nil {
+				if elog != nil {
+					elog.Error(1, fmt.Sprintf("Stream Replicator failed: %v", err))
+				}
+
+				return true, 1
+			}
+
+			return false, 0
+		}
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/choria-io/stream-replicator/audit"
+	"github.com/sirupsen/logrus"
+)
+
+// logLevelSignalHandler increases verbosity on SIGUSR1 and decreases it on SIGUSR2, recording changes using auditor
+func (c *cmd) logLevelSignalHandler(ctx context.Context, auditor *audit.Auditor) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(sigs)
+
+	for {
+		select {
+		case sig := <-sigs:
+			var level logrus.Level
+			if sig == syscall.SIGUSR1 {
+				level = c.levels.Increase()
+			} else {
+				level = c.levels.Decrease()
+			}
+
+			c.log.Warnf("Log level set to %s on signal %s", level, sig)
+			auditor.Record(audit.LogLevelSetAction, audit.Caller{Via: "signal"}, "", fmt.Sprintf("Log level set on signal %s", sig), map[string]string{"level": level.String(), "signal": sig.String()}, nil)
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,16 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+
+package cmd
+
+import (
+	"context"
+
+	"github.com/choria-io/stream-replicator/audit"
+)
+
+// logLevelSignalHandler is a no-op as Windows has no SIGUSR1 or SIGUSR2, use the admin API instead
+func (c *cmd) logLevelSignalHandler(_ context.Context, _ *audit.Auditor) {}
@@ -0,0 +1,159 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/choria-io/fisk"
+	"github.com/choria-io/stream-replicator/replicator"
+)
+
+type subjectsResponse struct {
+	Streams []*replicator.SubjectStats `json:"streams"`
+}
+
+// subjectReporter is a running stream that tracks per subject statistics
+type subjectReporter interface {
+	SubjectStats(top int) *replicator.SubjectStats
+}
+
+func (c *cmd) configureSubjectsCommand(admin *fisk.CmdClause) {
+	s := admin.Command("subjects", "Shows the subjects that dominate the replication volume of streams on a running replicator").Action(c.subjectsAction)
+	s.Arg("stream", "The stream name or source stream to show, all streams when not set").StringVar(&c.subjectsStream)
+	s.Flag("top", "How many of the most active subjects to show per stream").Default("10").IntVar(&c.subjectsTop)
+	s.Flag("config", "Configuration file used to determine the monitor port").ExistingFileVar(&c.cfgile)
+	s.Flag("url", "The URL of the replicator monitor port").StringVar(&c.subjectsURL)
+	c.configureAdminAPIClientFlags(s)
+	s.Flag("json", "Render JSON output").UnNegatableBoolVar(&c.json)
+}
+
+func (c *cmd) subjectsAction(_ *fisk.ParseContext) error {
+	err := c.configureAdminAPIClient(&c.subjectsURL, "subject statistics require monitor_port and admin_api to be set")
+	if err != nil {
+		return err
+	}
+
+	u, err := url.Parse(strings.TrimSuffix(c.subjectsURL, "/") + "/api/v1/subjects")
+	if err != nil {
+		return err
+	}
+	q := url.Values{"top": []string{strconv.Itoa(c.subjectsTop)}}
+	if c.subjectsStream != "" {
+		q.Set("stream", c.subjectsStream)
+	}
+	u.RawQuery = q.Encode()
+
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := c.adminAPIRequest(&client, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("could not retrieve subject statistics: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := adminError{}
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("could not retrieve subject statistics: %s", apiErr.Error)
+		}
+
+		return fmt.Errorf("could not retrieve subject statistics: %s", resp.Status)
+	}
+
+	res := subjectsResponse{}
+	err = json.Unmarshal(body, &res)
+	if err != nil {
+		return fmt.Errorf("invalid response received: %v", err)
+	}
+
+	if c.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(res)
+	}
+
+	for i, s := range res.Streams {
+		if i > 0 {
+			fmt.Println()
+		}
+
+		fmt.Printf("%s (%s): tracking %d of up to %d subjects\n\n", s.Stream, s.Name, s.Tracked, s.Limit)
+
+		width := len("Subject")
+		for _, subj := range s.Subjects {
+			if len(subj.Subject) > width {
+				width = len(subj.Subject)
+			}
+		}
+
+		fmt.Printf("  %-*s  %12s  %14s  %s\n", width, "Subject", "Messages", "Bytes", "Last Copied")
+		for _, subj := range s.Subjects {
+			messages := strconv.FormatUint(subj.Messages, 10)
+			if subj.Overcount > 0 {
+				messages = "~" + messages
+			}
+
+			fmt.Printf("  %-*s  %12s  %14d  %s\n", width, subj.Subject, messages, subj.Bytes, subj.LastCopied.Format(time.RFC3339))
+		}
+	}
+
+	return nil
+}
+
+func (c *cmd) apiSubjects(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		c.apiError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+		return
+	}
+
+	top := 0
+	if v := r.URL.Query().Get("top"); v != "" {
+		var err error
+		top, err = strconv.Atoi(v)
+		if err != nil || top < 0 {
+			c.apiError(w, http.StatusBadRequest, "invalid top: %q", v)
+			return
+		}
+	}
+
+	stream := r.URL.Query().Get("stream")
+	res := subjectsResponse{Streams: []*replicator.SubjectStats{}}
+
+	for _, s := range c.runningStreams() {
+		if stream != "" && s.cfg.Name != stream && s.cfg.Stream != stream {
+			continue
+		}
+
+		sr, ok := s.stream.(subjectReporter)
+		if !ok {
+			continue
+		}
+
+		stats := sr.SubjectStats(top)
+		if stats != nil {
+			res.Streams = append(res.Streams, stats)
+		}
+	}
+
+	if len(res.Streams) == 0 {
+		c.apiError(w, http.StatusNotFound, "no running stream %s with subject_stats enabled", stream)
+		return
+	}
+
+	c.apiRespond(w, http.StatusOK, res)
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/sdnotify"
+	"github.com/choria-io/stream-replicator/replicator"
+)
+
+// replicatedStream is a stream replicating in its configured direction or one that can fail over
+type replicatedStream interface {
+	Run(ctx context.Context, wg *sync.WaitGroup) error
+	Ready() <-chan struct{}
+	LastActive() time.Time
+	Leading() bool
+}
+
+var (
+	_ replicatedStream = (*replicator.Stream)(nil)
+	_ replicatedStream = (*replicator.Failover)(nil)
+)
+
+type runningStream struct {
+	name       string
+	replicator string
+	cfg        *config.Stream
+	stream     replicatedStream
+	exited     chan struct{}
+}
+
+func (r *runningStream) isReady() bool {
+	select {
+	case <-r.stream.Ready():
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *runningStream) hasExited() bool {
+	select {
+	case <-r.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+// systemdNotify notifies systemd once all streams are ready and then pets the watchdog
+// for as long as all running copiers are active and at least one is still running
+func (c *cmd) systemdNotify(ctx context.Context, streams []*runningStream) {
+	if !sdnotify.Enabled() {
+		return
+	}
+
+	for _, s := range streams {
+		select {
+		case <-s.stream.Ready():
+		case <-s.exited:
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	c.log.Infof("Notifying systemd of readiness")
+	err := sdnotify.Ready()
+	if err != nil {
+		c.log.Errorf("Could not notify systemd: %v", err)
+	}
+	sdnotify.Status("Replicating %d streams", len(streams))
+
+	interval, err := sdnotify.WatchdogInterval()
+	if err != nil {
+		c.log.Errorf("Could not determine systemd watchdog interval: %v", err)
+	}
+
+	if interval == 0 {
+		<-ctx.Done()
+		sdnotify.Stopping()
+		return
+	}
+
+	c.log.Infof("Petting the systemd watchdog every %v", interval/2)
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			healthy := true
+			running := 0
+			for _, s := range streams {
+				if s.hasExited() {
+					continue
+				}
+				running++
+
+				if since := time.Since(s.stream.LastActive()); since > interval {
+					c.log.Errorf("Copier has not been active for %v, not petting the systemd watchdog", since.Round(time.Second))
+					healthy = false
+				}
+			}
+
+			if running == 0 {
+				c.log.Errorf("No copiers are running, not petting the systemd watchdog")
+				healthy = false
+			}
+
+			if healthy {
+				err = sdnotify.Watchdog()
+				if err != nil {
+					c.log.Errorf("Could not pet the systemd watchdog: %v", err)
+				}
+			}
+
+		case <-ctx.Done():
+			sdnotify.Stopping()
+			return
+		}
+	}
+}
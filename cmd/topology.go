@@ -0,0 +1,101 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/choria-io/fisk"
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/replicator"
+	"github.com/choria-io/stream-replicator/topology"
+)
+
+func (c *cmd) configureTopologyCommand(admin *fisk.CmdClause) {
+	topo := admin.Command("topology", "Renders the replication topology of all replicators in the topology registry").Alias("topo").Action(c.topologyAction)
+	topo.Flag("bucket", "The Key-Value bucket replicators register in").Default(config.DefaultTopologyBucket).StringVar(&c.topologyBucket)
+	topo.Flag("json", "Render JSON values").BoolVar(&c.json)
+	topo.Flag("dot", "Render a Graphviz digraph").BoolVar(&c.topologyDot)
+	c.addConnectionFlags(topo)
+}
+
+func (c *cmd) topologyAction(_ *fisk.ParseContext) error {
+	mgr, err := c.connectManager()
+	if err != nil {
+		return err
+	}
+
+	js, err := mgr.NatsConn().JetStream()
+	if err != nil {
+		return err
+	}
+
+	kv, err := js.KeyValue(c.topologyBucket)
+	if err != nil {
+		return fmt.Errorf("could not access topology registry %s: %v", c.topologyBucket, err)
+	}
+
+	regs, err := topology.Load(kv)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case c.json:
+		j, err := json.MarshalIndent(regs, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(j))
+
+	case c.topologyDot:
+		topology.RenderDot(os.Stdout, regs)
+
+	default:
+		topology.Render(os.Stdout, regs)
+	}
+
+	return nil
+}
+
+// topologyRegistrations registers all running streams with their current state and health
+func (c *cmd) topologyRegistrations() []*topology.Registration {
+	host, _ := os.Hostname()
+
+	var regs []*topology.Registration
+	for _, s := range c.runningStreams() {
+		var reg *topology.Registration
+
+		// failed over streams are registered in the direction they replicate in
+		if f, ok := s.stream.(*replicator.Failover); ok && f.FailedOver() {
+			reg = topology.NewRegistration(s.replicator, host, s.cfg.Reversed(time.Time{}))
+			reg.FailedOver = true
+		} else {
+			reg = topology.NewRegistration(s.replicator, host, s.cfg)
+		}
+
+		reg.Version = version
+
+		switch {
+		case s.hasExited():
+			reg.State = topology.StoppedState
+		case !s.isReady():
+			reg.State = topology.StartingState
+		case !s.stream.Leading():
+			reg.State = topology.StandbyState
+		default:
+			reg.State = topology.ReplicatingState
+			reg.LastActive = s.stream.LastActive()
+			reg.Healthy = time.Since(reg.LastActive) <= healthTimeout
+		}
+
+		regs = append(regs, reg)
+	}
+
+	return regs
+}
@@ -0,0 +1,263 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/choria-io/fisk"
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/util"
+	"github.com/choria-io/stream-replicator/internal/verify"
+	"github.com/choria-io/stream-replicator/replicator"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+type verifyOptions struct {
+	stream     string
+	perSubject bool
+	timeout    time.Duration
+}
+
+func (c *cmd) configureVerifyCommand(app *fisk.Application) {
+	c.verify = &verifyOptions{}
+
+	verify := app.Command("verify", "Verifies the consistency of replicated streams")
+
+	sums := verify.Command("checksums", "Compares checksums of message payloads in the source and target streams").Alias("sums").Action(c.verifyChecksumsAction)
+	sums.Flag("config", "Configuration file").Required().ExistingFileVar(&c.cfgile)
+	sums.Flag("stream", "Verify a single stream configuration, by name or source stream").StringVar(&c.verify.stream)
+	sums.Flag("per-subject", "Calculate checksums per subject to locate divergence").UnNegatableBoolVar(&c.verify.perSubject)
+	sums.Flag("timeout", "How long to wait for messages from a stream before giving up").Default("10s").DurationVar(&c.verify.timeout)
+	sums.Flag("json", "Render JSON values").BoolVar(&c.json)
+}
+
+func (c *cmd) selectVerifyStreams(cfg *config.Config) ([]*config.Stream, error) {
+	if c.verify.stream == "" {
+		return cfg.AllStreams(), nil
+	}
+
+	for _, s := range cfg.AllStreams() {
+		if s.Name == c.verify.stream || s.Stream == c.verify.stream {
+			return []*config.Stream{s}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no stream %q found in the configuration", c.verify.stream)
+}
+
+// digestStream walks all messages in a stream matching filter up to the last message present when it started
+func (c *cmd) digestStream(ctx context.Context, nc *nats.Conn, stream string, filter string, subjectKey func(string) string) (*verify.StreamSum, error) {
+	mgr, err := jsm.New(nc)
+	if err != nil {
+		return nil, err
+	}
+
+	str, err := mgr.LoadStream(stream)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := str.State()
+	if err != nil {
+		return nil, err
+	}
+
+	res := verify.NewStreamSum(stream, state.LastSeq, c.verify.perSubject)
+	defer res.Finish()
+
+	if state.Msgs == 0 {
+		return res, nil
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := js.SubscribeSync(filter, nats.BindStream(stream), nats.OrderedConsumer(), nats.DeliverAll())
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsubscribe()
+
+	for {
+		tctx, cancel := context.WithTimeout(ctx, c.verify.timeout)
+		msg, err := sub.NextMsgWithContext(tctx)
+		cancel()
+		switch {
+		case errors.Is(err, context.DeadlineExceeded) && res.Messages == 0:
+			// the filter matched no messages
+			return res, nil
+		case err != nil:
+			return nil, fmt.Errorf("reading %s failed after %d messages: %v", stream, res.Messages, err)
+		}
+
+		meta, err := msg.Metadata()
+		if err != nil {
+			return nil, err
+		}
+
+		if meta.Sequence.Stream > state.LastSeq {
+			return res, nil
+		}
+
+		res.Add(subjectKey(msg.Subject), msg.Data)
+
+		if meta.NumPending == 0 || meta.Sequence.Stream == state.LastSeq {
+			return res, nil
+		}
+	}
+}
+
+func (c *cmd) verifyStream(ctx context.Context, cfg *config.Config, scfg *config.Stream) (*verify.Result, error) {
+	if scfg.SourceServers() == "" || scfg.TargetServers() == "" || scfg.TargetCore {
+		return nil, fmt.Errorf("verifying is only supported for streams replicating between NATS Streams")
+	}
+
+	stream, err := replicator.NewStream(scfg, cfg.ProfileFor(scfg), c.log)
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := util.ConnectNats(ctx, "verify-source", scfg.SourceServers(), scfg.SourceTLS, scfg.SourceChoriaConn, true, scfg.SourceProcess, c.log.WithField("connection", "source"), append(scfg.SourceContextOptions(), util.WithProxy(scfg.SourceProxy))...)
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+
+	target, err := util.ConnectNats(ctx, "verify-target", scfg.TargetServers(), scfg.TargetTLS, scfg.TargetChoriaConn, true, scfg.TargetProcess, c.log.WithField("connection", "target"), append(scfg.TargetContextOptions(), util.WithProxy(scfg.TargetProxy))...)
+	if err != nil {
+		return nil, err
+	}
+	defer target.Close()
+
+	// subjects are reported as they are in the source stream, this maps the
+	// target subjects back to those seen in the source
+	subjects := make(map[string]string)
+
+	src, err := c.digestStream(ctx, source, scfg.Stream, scfg.FilterSubject, func(subj string) string {
+		subjects[stream.TargetForSubject(subj)] = subj
+		return subj
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	targetFilter := ""
+	if scfg.FilterSubject != "" {
+		targetFilter = stream.TargetForSubject(scfg.FilterSubject)
+	}
+
+	tgt, err := c.digestStream(ctx, target, scfg.TargetStream, targetFilter, func(subj string) string {
+		if orig, ok := subjects[subj]; ok {
+			return orig
+		}
+		return subj
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	name := scfg.Name
+	if name == "" {
+		name = scfg.Stream
+	}
+
+	return verify.Compare(name, src, tgt), nil
+}
+
+func (c *cmd) verifyChecksumsAction(_ *fisk.ParseContext) error {
+	cfg, err := config.Load(c.cfgile)
+	if err != nil {
+		return err
+	}
+
+	streams, err := c.selectVerifyStreams(cfg)
+	if err != nil {
+		return err
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	if c.debug {
+		logger.SetLevel(logrus.DebugLevel)
+	}
+	c.log = logrus.NewEntry(logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.interruptHandler(ctx, cancel)
+
+	var results []*verify.Result
+	var diverged int
+
+	for _, scfg := range streams {
+		res, err := c.verifyStream(ctx, cfg, scfg)
+		if err != nil {
+			return fmt.Errorf("verifying %s failed: %v", scfg.Stream, err)
+		}
+
+		if !res.Match {
+			diverged++
+		}
+
+		results = append(results, res)
+	}
+
+	if c.json {
+		j, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(j))
+	} else {
+		for _, res := range results {
+			c.showVerifyResult(res)
+		}
+	}
+
+	if diverged > 0 {
+		return fmt.Errorf("%d of %d streams diverged", diverged, len(results))
+	}
+
+	return nil
+}
+
+func (c *cmd) showVerifyResult(res *verify.Result) {
+	status := "OK"
+	if !res.Match {
+		status = "DIVERGED"
+	}
+
+	fmt.Printf("%s: %s > %s: %s\n", res.Name, res.Source.Stream, res.Target.Stream, status)
+	fmt.Println()
+	fmt.Printf("    Source: %d messages up to sequence %d digest %s\n", res.Source.Messages, res.Source.LastSeq, res.Source.Digest)
+	fmt.Printf("    Target: %d messages up to sequence %d digest %s\n", res.Target.Messages, res.Target.LastSeq, res.Target.Digest)
+
+	if len(res.Diverged) > 0 {
+		fmt.Println()
+		fmt.Println("    Diverged subjects:")
+		fmt.Println()
+		for _, subj := range res.Diverged {
+			var s, t uint64
+			if d, ok := res.Source.Subjects[subj]; ok {
+				s = d.Messages
+			}
+			if d, ok := res.Target.Subjects[subj]; ok {
+				t = d.Messages
+			}
+			fmt.Printf("      %s: source %d messages target %d messages\n", subj, s, t)
+		}
+	}
+
+	fmt.Println()
+}
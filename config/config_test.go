@@ -11,6 +11,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/choria-io/stream-replicator/backoff"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 )
@@ -52,41 +53,1567 @@ var _ = Describe("Config", func() {
 		It("Should require a stream", func() {
 			cfg.Streams = []*Stream{{}}
 			Expect(cfg.Validate()).To(MatchError("stream not specified"))
+
+			cfg.Streams = []*Stream{nil}
+			Expect(cfg.Validate()).To(MatchError("stream not specified"))
+		})
+
+		It("Should support inheriting TLS from the replicator", func() {
+			cfg.TLS = &TLS{}
+			cfg.Streams = []*Stream{
+				{Stream: "GINKGO"},
+			}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].TLS).To(BeIdenticalTo(cfg.TLS))
+			Expect(cfg.Streams[0].SourceTLS).To(BeIdenticalTo(cfg.TLS))
+			Expect(cfg.Streams[0].TargetTLS).To(BeIdenticalTo(cfg.TLS))
+		})
+
+		It("Should support source specific TLS", func() {
+			stls := &TLS{}
+			cfg.TLS = &TLS{}
+			cfg.Streams = []*Stream{
+				{Stream: "GINKGO", SourceTLS: stls},
+			}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].TLS).To(BeIdenticalTo(cfg.TLS))
+			Expect(cfg.Streams[0].SourceTLS).To(BeIdenticalTo(stls))
+			Expect(cfg.Streams[0].TargetTLS).To(BeIdenticalTo(cfg.TLS))
+		})
+
+		It("Should support target specific TLS", func() {
+			ttls := &TLS{}
+			cfg.TLS = &TLS{}
+			cfg.Streams = []*Stream{
+				{Stream: "GINKGO", TargetTLS: ttls},
+			}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].TLS).To(BeIdenticalTo(cfg.TLS))
+			Expect(cfg.Streams[0].TargetTLS).To(BeIdenticalTo(ttls))
+			Expect(cfg.Streams[0].SourceTLS).To(BeIdenticalTo(cfg.TLS))
+		})
+
+		It("Should support per connection client identities sharing a CA", func() {
+			cfg.TLS = &TLS{CA: "ca.pem"}
+			cfg.Streams = []*Stream{
+				{Stream: "GINKGO", TargetTLS: &TLS{Cert: "orders.pem", Key: "orders.key"}},
+				{Stream: "OTHER", TLS: &TLS{Cert: "other.pem", Key: "other.key"}, SourceTLS: &TLS{CA: "source.pem", Cert: "src.pem", Key: "src.key"}},
+			}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].SourceTLS).To(BeIdenticalTo(cfg.TLS))
+			Expect(cfg.Streams[0].TargetTLS).To(Equal(&TLS{CA: "ca.pem", Cert: "orders.pem", Key: "orders.key"}))
+			Expect(cfg.Streams[1].TLS).To(Equal(&TLS{CA: "ca.pem", Cert: "other.pem", Key: "other.key"}))
+			Expect(cfg.Streams[1].TargetTLS).To(BeIdenticalTo(cfg.Streams[1].TLS))
+			Expect(cfg.Streams[1].SourceTLS).To(Equal(&TLS{CA: "source.pem", Cert: "src.pem", Key: "src.key"}))
+			Expect(cfg.TLS).To(Equal(&TLS{CA: "ca.pem"}))
+		})
+
+		It("Should require both a certificate and key", func() {
+			cfg.Streams = []*Stream{
+				{Stream: "GINKGO", TargetTLS: &TLS{Cert: "orders.pem"}},
+			}
+			Expect(cfg.Validate()).To(MatchError("target_tls requires both cert and key for stream GINKGO"))
+		})
+
+		It("Should validate SPIFFE settings", func() {
+			cfg.TLS = &TLS{CA: "ca.pem"}
+			cfg.Streams = []*Stream{
+				{Stream: "GINKGO", TargetTLS: &TLS{SPIFFE: &SPIFFE{Socket: "unix:///run/spire/agent.sock"}}},
+			}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].TargetTLS.CA).To(BeEmpty())
+			Expect(cfg.Streams[0].SourceTLS.CA).To(Equal("ca.pem"))
+
+			cfg.Streams[0].TargetTLS = &TLS{Cert: "orders.pem", Key: "orders.key", SPIFFE: &SPIFFE{Socket: "unix:///run/spire/agent.sock"}}
+			Expect(cfg.Validate()).To(MatchError("target_tls cert and key can not be combined with spiffe for stream GINKGO"))
+
+			cfg.Streams[0].TargetTLS = &TLS{CA: "ca.pem", SPIFFE: &SPIFFE{Socket: "unix:///run/spire/agent.sock", ServerIDs: []string{"spiffe://example.org/nats"}}}
+			Expect(cfg.Validate()).To(MatchError("target_tls spiffe server_ids can not be combined with ca for stream GINKGO"))
+
+			cfg.Streams[0].TargetTLS = &TLS{SPIFFE: &SPIFFE{Socket: "unix:///run/spire/agent.sock", ServerIDs: []string{"nats"}}}
+			Expect(cfg.Validate()).To(MatchError(`target_tls invalid spiffe server id "nats" for stream GINKGO`))
+		})
+
+		It("Should join url lists", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURLs: []string{"nats://s1:4222", "nats://s2:4222"}, TargetURLs: []string{"nats://t1:4222", "nats://t2:4222"}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].SourceServers()).To(Equal("nats://s1:4222,nats://s2:4222"))
+			Expect(cfg.Streams[0].TargetServers()).To(Equal("nats://t1:4222,nats://t2:4222"))
+			Expect(cfg.Streams[0].SourceURL).To(BeEmpty())
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", SourceURLs: []string{"nats://s2:4222"}}}
+			Expect(cfg.Validate()).To(MatchError("only one of source_url and source_urls can be set for stream GINKGO"))
+		})
+
+		It("Should validate core NATS targets", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", TargetCore: true}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetCore: true}}
+			Expect(cfg.Validate()).To(MatchError("target_core requires a target_url for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://t1:4222", TargetCore: true, SourceMQTT: &MQTT{URL: "tcp://mqtt:1883", Topics: []string{"sensors/#"}}}}
+			Expect(cfg.Validate()).To(MatchError("target_core requires a source_url for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", TargetCore: true, TargetInitiated: true}}
+			Expect(cfg.Validate()).To(MatchError("target_core can not be used with target_initiated for stream GINKGO"))
+		})
+
+		It("Should validate target connections", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", TargetConnections: 4}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", TargetConnections: -1}}
+			Expect(cfg.Validate()).To(MatchError("target_connections can not be negative for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetHTTP: &HTTP{URL: "https://example.net"}, TargetConnections: 4}}
+			Expect(cfg.Validate()).To(MatchError("target_connections requires a target_url for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", TargetCore: true, TargetConnections: 4}}
+			Expect(cfg.Validate()).To(MatchError("target_connections can not be used with target_core for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", FilterSubject: "x", TargetInitiated: true, TargetConnections: 4}}
+			Expect(cfg.Validate()).To(MatchError("target_connections can not be used with target_initiated for stream GINKGO"))
+		})
+
+		It("Should validate shards", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", Shard: &Shard{Count: 3, Index: 2}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", Shard: &Shard{Count: 1}}}
+			Expect(cfg.Validate()).To(MatchError("invalid shard for stream GINKGO: count must be at least 2"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", Shard: &Shard{Count: 3, Index: 3}}}
+			Expect(cfg.Validate()).To(MatchError("invalid shard for stream GINKGO: index must be between 0 and 2"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://t1:4222", SourceMQTT: &MQTT{URL: "tcp://mqtt:1883", Topics: []string{"sensors/#"}}, Shard: &Shard{Count: 2}}}
+			Expect(cfg.Validate()).To(MatchError("shard requires a source_url for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", FilterSubject: "x", TargetInitiated: true, Shard: &Shard{Count: 2}}}
+			Expect(cfg.Validate()).To(MatchError("shard can not be used with target_initiated for stream GINKGO"))
+		})
+
+		It("Should validate memory budgets", func() {
+			cfg.MemoryBudget = 1024
+			cfg.Streams = []*Stream{
+				{Stream: "ONE", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222"},
+				{Stream: "TWO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", MemoryBudget: 2048},
+				{Stream: "THREE", TargetURL: "nats://t1:4222", SourceMQTT: &MQTT{URL: "tcp://mqtt:1883", Topics: []string{"sensors/#"}}},
+			}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].MemoryBudget).To(Equal(int64(1024)))
+			Expect(cfg.Streams[1].MemoryBudget).To(Equal(int64(2048)))
+			Expect(cfg.Streams[2].MemoryBudget).To(Equal(int64(0)))
+
+			cfg.MemoryBudget = -1
+			Expect(cfg.Validate()).To(MatchError("memory_budget can not be negative"))
+			cfg.MemoryBudget = 0
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", MemoryBudget: -1}}
+			Expect(cfg.Validate()).To(MatchError("memory_budget can not be negative for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", FilterSubject: "x", TargetInitiated: true, MemoryBudget: 1024}}
+			Expect(cfg.Validate()).To(MatchError("memory_budget requires a source_url and can not be used with target_initiated or target_archive for stream GINKGO"))
+		})
+
+		It("Should validate the scheduler", func() {
+			cfg.Scheduler = &Scheduler{}
+			Expect(cfg.Validate()).To(MatchError("invalid scheduler: bytes_per_second must be positive"))
+
+			cfg.Scheduler = &Scheduler{BytesPerSecond: 1024, Mode: "random"}
+			Expect(cfg.Validate()).To(MatchError("invalid scheduler: mode must be fair or priority"))
+
+			cfg.Scheduler = &Scheduler{BytesPerSecond: 1024}
+			cfg.Streams = []*Stream{
+				{Stream: "ONE", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222"},
+				{Stream: "TWO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", Weight: 4, Priority: 2},
+			}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Scheduler.Mode).To(Equal(SchedulerFair))
+			Expect(cfg.Streams[0].Weight).To(Equal(1))
+			Expect(cfg.Streams[1].Weight).To(Equal(4))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", Weight: -1}}
+			Expect(cfg.Validate()).To(MatchError("weight can not be negative for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", Priority: -1}}
+			Expect(cfg.Validate()).To(MatchError("priority can not be negative for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", FilterSubject: "x", TargetInitiated: true, Priority: 1}}
+			Expect(cfg.Validate()).To(MatchError("weight and priority require a source_url and can not be used with target_initiated or target_archive for stream GINKGO"))
+
+			cfg.Streams = nil
+			cfg.Profiles = []*Config{{ReplicatorName: "SITE1", Scheduler: &Scheduler{BytesPerSecond: 1024}}}
+			Expect(cfg.Validate()).To(MatchError("scheduler can only be set at the top level, not in profile SITE1"))
+		})
+
+		It("Should validate catch up settings", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", CatchUp: &CatchUp{Pending: 10000}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].CatchUp.Batch).To(Equal(1000))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", TargetConnections: 4, CatchUp: &CatchUp{Pending: 10000, Batch: 500, Parallel: true}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", CatchUp: &CatchUp{}}}
+			Expect(cfg.Validate()).To(MatchError("invalid catch_up for stream GINKGO: pending must be at least 1"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", CatchUp: &CatchUp{Pending: 10, Batch: -1}}}
+			Expect(cfg.Validate()).To(MatchError("invalid catch_up for stream GINKGO: batch can not be negative"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", CatchUp: &CatchUp{Pending: 10, Parallel: true}}}
+			Expect(cfg.Validate()).To(MatchError("catch_up parallel requires target_connections for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetHTTP: &HTTP{URL: "https://example.net"}, CatchUp: &CatchUp{Pending: 10}}}
+			Expect(cfg.Validate()).To(MatchError("catch_up can not be used with target_http for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", FilterSubject: "x", TargetInitiated: true, CatchUp: &CatchUp{Pending: 10}}}
+			Expect(cfg.Validate()).To(MatchError("catch_up can not be used with target_initiated for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://t1:4222", SourceMQTT: &MQTT{URL: "tcp://mqtt:1883", Topics: []string{"sensors/#"}}, CatchUp: &CatchUp{Pending: 10}}}
+			Expect(cfg.Validate()).To(MatchError("catch_up requires a source_url for stream GINKGO"))
+		})
+
+		It("Should validate fetch settings", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", Fetch: &Fetch{MaxBytes: 1024}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].Fetch.Batch).To(Equal(1))
+			Expect(cfg.Streams[0].Fetch.Expires).To(Equal(10 * time.Second))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", Fetch: &Fetch{Batch: 500, ExpiresString: "30s"}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].Fetch.Expires).To(Equal(30 * time.Second))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", Fetch: &Fetch{Batch: -1}}}
+			Expect(cfg.Validate()).To(MatchError("invalid fetch for stream GINKGO: batch, max_batch and max_bytes can not be negative"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", Fetch: &Fetch{Batch: 10, MaxBatch: 1000}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", Fetch: &Fetch{Batch: 10, MaxBatch: 5}}}
+			Expect(cfg.Validate()).To(MatchError("invalid fetch for stream GINKGO: max_batch can not be less than batch"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", Fetch: &Fetch{ExpiresString: "100ms"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid fetch for stream GINKGO: expires must be at least 1s"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", FilterSubject: "x", TargetInitiated: true, Fetch: &Fetch{Batch: 10}}}
+			Expect(cfg.Validate()).To(MatchError("fetch can not be used with target_initiated for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://t1:4222", SourceMQTT: &MQTT{URL: "tcp://mqtt:1883", Topics: []string{"sensors/#"}}, Fetch: &Fetch{Batch: 10}}}
+			Expect(cfg.Validate()).To(MatchError("fetch requires a source_url for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetHTTP: &HTTP{URL: "https://example.net"}, Fetch: &Fetch{MaxBytes: 1024}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetHTTP: &HTTP{URL: "https://example.net"}, Fetch: &Fetch{Batch: 10}}}
+			Expect(cfg.Validate()).To(MatchError("fetch batch, max_batch and expires can not be used with target_http, only max_bytes is supported for stream GINKGO"))
+		})
+
+		It("Should validate leader preferences", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", LeaderElectionName: "n1", LeaderPreference: &LeaderPreference{Name: "n1"}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", LeaderPreference: &LeaderPreference{Name: "n1"}}}
+			Expect(cfg.Validate()).To(MatchError("leader_preference requires leader_election_name for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", LeaderElectionName: "n1", LeaderPreference: &LeaderPreference{}}}
+			Expect(cfg.Validate()).To(MatchError("invalid leader_preference for stream GINKGO: name or labels are required"))
+		})
+
+		It("Should validate leader groups", func() {
+			cfg.Streams = []*Stream{
+				{Stream: "ORDERS", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", LeaderElectionName: "n1", LeaderGroup: "billing"},
+				{Stream: "INVOICES", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", LeaderElectionName: "n1", LeaderGroup: "billing"},
+			}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+
+			cfg.Streams[1].SourceURL = "nats://s2:4222"
+			Expect(cfg.Validate()).To(MatchError("streams in leader_group billing must share source_url and leader_election_name for stream INVOICES"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", LeaderGroup: "billing"}}
+			Expect(cfg.Validate()).To(MatchError("leader_group requires leader_election_name for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", LeaderElectionName: "n1", LeaderGroup: "billing", LeaderPreference: &LeaderPreference{Name: "n1"}}}
+			Expect(cfg.Validate()).To(MatchError("leader_group can not be used with leader_preference for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", LeaderElectionName: "n1", LeaderGroup: "billing.eu"}}
+			Expect(cfg.Validate()).To(MatchError("invalid leader_group billing.eu for stream GINKGO: only letters, numbers, _ and - are allowed"))
+		})
+
+		It("Should validate stale leader timeouts", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", LeaderElectionName: "n1", StaleLeaderString: "2m"}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].StaleLeaderTimeout).To(Equal(2 * time.Minute))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", StaleLeaderString: "2m"}}
+			Expect(cfg.Validate()).To(MatchError("stale_leader_timeout requires leader_election_name for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", LeaderElectionName: "n1", LeaderGroup: "billing", StaleLeaderString: "2m"}}
+			Expect(cfg.Validate()).To(MatchError("stale_leader_timeout can not be used with leader_group for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", LeaderElectionName: "n1", StaleLeaderString: "10s"}}
+			Expect(cfg.Validate()).To(MatchError("stale_leader_timeout must be at least 30s for stream GINKGO"))
+
+			cfg.Streams[0].StaleLeaderString = "soon"
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring("invalid stale_leader_timeout for stream GINKGO")))
+		})
+
+		It("Should validate processing deadlines", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", ProcessingDeadline: &ProcessingDeadline{TimeoutString: "10s"}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].ProcessingDeadline.Timeout).To(Equal(10 * time.Second))
+			Expect(cfg.Streams[0].ProcessingDeadline.Action).To(Equal(DeadlineRetry))
+
+			cfg.Streams[0].ProcessingDeadline = &ProcessingDeadline{TimeoutString: "10s", Action: DeadlineDeadLetter, DeadLetterSubject: "dlq.orders"}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+
+			cfg.Streams[0].ProcessingDeadline = &ProcessingDeadline{TimeoutString: "10s", Action: DeadlineDeadLetter}
+			Expect(cfg.Validate()).To(MatchError("invalid processing_deadline for stream GINKGO: dead_letter_subject is required with the dead_letter action"))
+
+			cfg.Streams[0].ProcessingDeadline = &ProcessingDeadline{TimeoutString: "10s", DeadLetterSubject: "dlq.orders"}
+			Expect(cfg.Validate()).To(MatchError("invalid processing_deadline for stream GINKGO: dead_letter_subject requires the dead_letter action"))
+
+			cfg.Streams[0].ProcessingDeadline = &ProcessingDeadline{TimeoutString: "10s", Action: DeadlineDeadLetter, DeadLetterSubject: "dlq.>"}
+			Expect(cfg.Validate()).To(MatchError("invalid processing_deadline for stream GINKGO: dead_letter_subject can not have wildcards or spaces"))
+
+			cfg.Streams[0].ProcessingDeadline = &ProcessingDeadline{TimeoutString: "10s", Action: "drop"}
+			Expect(cfg.Validate()).To(MatchError("invalid processing_deadline for stream GINKGO: action must be retry or dead_letter"))
+
+			cfg.Streams[0].ProcessingDeadline = &ProcessingDeadline{}
+			Expect(cfg.Validate()).To(MatchError("invalid processing_deadline for stream GINKGO: timeout is required"))
+
+			cfg.Streams[0].ProcessingDeadline = &ProcessingDeadline{TimeoutString: "10s"}
+			cfg.Streams[0].MonitorOnly = true
+			Expect(cfg.Validate()).To(MatchError("processing_deadline can not be used with monitor_only for stream GINKGO"))
+		})
+
+		It("Should validate payload limits", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", MaxPayload: &MaxPayload{Bytes: 1024}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].MaxPayload.Action).To(Equal(PayloadDrop))
+
+			cfg.Streams[0].MaxPayload = &MaxPayload{Bytes: 1024, Action: PayloadTruncate}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+
+			cfg.Streams[0].MaxPayload = &MaxPayload{Bytes: 1024, Action: PayloadDeadLetter, DeadLetterSubject: "dlq.orders"}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+
+			cfg.Streams[0].MaxPayload = &MaxPayload{}
+			Expect(cfg.Validate()).To(MatchError("invalid max_payload for stream GINKGO: bytes must be positive"))
+
+			cfg.Streams[0].MaxPayload = &MaxPayload{Bytes: 1024, Action: "split"}
+			Expect(cfg.Validate()).To(MatchError("invalid max_payload for stream GINKGO: action must be drop, dead_letter or truncate"))
+
+			cfg.Streams[0].MaxPayload = &MaxPayload{Bytes: 1024, Action: PayloadDeadLetter}
+			Expect(cfg.Validate()).To(MatchError("invalid max_payload for stream GINKGO: dead_letter_subject is required with the dead_letter action"))
+
+			cfg.Streams[0].MaxPayload = &MaxPayload{Bytes: 1024, Action: PayloadTruncate, DeadLetterSubject: "dlq.orders"}
+			Expect(cfg.Validate()).To(MatchError("invalid max_payload for stream GINKGO: dead_letter_subject requires the dead_letter action"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", MonitorOnly: true, MaxPayload: &MaxPayload{Bytes: 1024}}}
+			Expect(cfg.Validate()).To(MatchError("max_payload can not be used with monitor_only for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", InspectJSONField: "sender", Delta: &Delta{}, MaxPayload: &MaxPayload{Bytes: 1024}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+
+			cfg.Streams[0].MaxPayload = &MaxPayload{Bytes: 1024, Action: PayloadTruncate}
+			Expect(cfg.Validate()).To(MatchError("max_payload truncate can not be used with delta for stream GINKGO"))
+		})
+
+		It("Should validate spools", func() {
+			cfg.StateDirectory = GinkgoT().TempDir()
+			cfg.Streams = []*Stream{{Name: "EDGE", Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", Spool: &Spool{}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].Spool.Directory).To(Equal(filepath.Join(cfg.StateDirectory, "spool", "GINKGO_EDGE")))
+			Expect(cfg.Streams[0].Spool.MaxBytes).To(Equal(int64(DefaultSpoolMaxBytes)))
+
+			cfg.StateDirectory = ""
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", Spool: &Spool{}}}
+			Expect(cfg.Validate()).To(MatchError("invalid spool for stream GINKGO: directory is required without a state_store"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", Spool: &Spool{Directory: "/tmp/spool", MaxBytes: -1}}}
+			Expect(cfg.Validate()).To(MatchError("invalid spool for stream GINKGO: max_bytes can not be negative"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetFile: &File{Directory: "/tmp/files"}, Spool: &Spool{Directory: "/tmp/spool"}}}
+			Expect(cfg.Validate()).To(MatchError("spool requires a NATS target for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", TargetConnections: 2, Spool: &Spool{Directory: "/tmp/spool"}}}
+			Expect(cfg.Validate()).To(MatchError("spool can not be used with target_connections for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", TargetCore: true, Spool: &Spool{Directory: "/tmp/spool"}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+		})
+
+		It("Should determine preferred leaders", func() {
+			p := &LeaderPreference{Name: "n1"}
+			Expect(p.Preferred("n1", nil)).To(BeTrue())
+			Expect(p.Preferred("n2", map[string]string{"dc": "east"})).To(BeFalse())
+
+			p = &LeaderPreference{Labels: map[string]string{"dc": "east", "tier": "primary"}}
+			Expect(p.Preferred("n1", map[string]string{"dc": "east", "tier": "primary", "rack": "1"})).To(BeTrue())
+			Expect(p.Preferred("n1", map[string]string{"dc": "east"})).To(BeFalse())
+			Expect(p.Preferred("n1", nil)).To(BeFalse())
+
+			p = &LeaderPreference{Name: "n2", Labels: map[string]string{"dc": "east"}}
+			Expect(p.Preferred("n2", nil)).To(BeTrue())
+			Expect(p.Preferred("n1", map[string]string{"dc": "east"})).To(BeTrue())
+			Expect(p.Preferred("n1", map[string]string{"dc": "west"})).To(BeFalse())
+		})
+
+		It("Should validate stream log levels", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", LogLevel: "debug", LogFile: "/var/log/ginkgo.log"}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", LogLevel: "trace"}}
+			Expect(cfg.Validate()).To(MatchError("invalid loglevel trace for stream GINKGO, valid levels are debug, info and warn"))
+		})
+
+		It("Should validate profiles", func() {
+			cfg.Profiles = []*Config{{}}
+			Expect(cfg.Validate()).To(MatchError("name is required for profile 0"))
+
+			cfg.Profiles = []*Config{{ReplicatorName: "GINKGO"}}
+			Expect(cfg.Validate()).To(MatchError("duplicate profile name GINKGO"))
+
+			cfg.Profiles = []*Config{{ReplicatorName: "SITE1", MonitorPort: 8080}}
+			Expect(cfg.Validate()).To(MatchError("monitor_port can only be set at the top level, not in profile SITE1"))
+
+			cfg.Profiles = []*Config{{ReplicatorName: "SITE1", Profiles: []*Config{{ReplicatorName: "SITE2"}}}}
+			Expect(cfg.Validate()).To(MatchError("profiles can not be nested in profile SITE1"))
+
+			cfg.Profiles = []*Config{{ReplicatorName: "SITE1", Streams: []*Stream{{}}}}
+			Expect(cfg.Validate()).To(MatchError("invalid profile SITE1: stream not specified"))
+		})
+
+		It("Should inherit settings into profiles", func() {
+			td := GinkgoT().TempDir()
+
+			site1TLS := &TLS{}
+			cfg.TLS = &TLS{}
+			cfg.Proxy = "socks5://proxy.example.net:1080"
+			cfg.StateDirectory = td
+			cfg.Streams = []*Stream{{Stream: "ORDERS"}}
+			cfg.Profiles = []*Config{
+				{ReplicatorName: "SITE1", TLS: site1TLS, Streams: []*Stream{{Stream: "ORDERS"}}},
+				{ReplicatorName: "SITE2", StateDirectory: filepath.Join(td, "other"), Streams: []*Stream{{Stream: "ORDERS"}}},
+			}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+
+			site1, site2 := cfg.Profiles[0], cfg.Profiles[1]
+			Expect(site1.StateDirectory).To(Equal(filepath.Join(td, "SITE1")))
+			Expect(filepath.Join(td, "SITE1")).To(BeADirectory())
+			Expect(site1.Streams[0].SourceTLS).To(BeIdenticalTo(site1TLS))
+			Expect(site1.Streams[0].Name).To(Equal("SITE1"))
+			Expect(site1.Proxy).To(Equal(cfg.Proxy))
+			Expect(site2.StateDirectory).To(Equal(filepath.Join(td, "other")))
+			Expect(site2.Streams[0].SourceTLS).To(BeIdenticalTo(cfg.TLS))
+
+			Expect(cfg.AllProfiles()).To(Equal([]*Config{cfg, site1, site2}))
+			Expect(cfg.AllStreams()).To(Equal([]*Stream{cfg.Streams[0], site1.Streams[0], site2.Streams[0]}))
+			Expect(cfg.ProfileFor(site2.Streams[0])).To(BeIdenticalTo(site2))
+			Expect(cfg.ProfileFor(cfg.Streams[0])).To(BeIdenticalTo(cfg))
+
+			cfg.Streams = nil
+			Expect(cfg.AllProfiles()).To(Equal([]*Config{site1, site2}))
+		})
+
+		It("Should validate kubernetes settings", func() {
+			cfg.Kubernetes = &Kubernetes{Identity: "pod-1"}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Kubernetes.LeaseDuration).To(Equal(15 * time.Second))
+
+			cfg.Kubernetes = &Kubernetes{Identity: "pod-1", LeaseDurationString: "1s"}
+			Expect(cfg.Validate()).To(MatchError("invalid kubernetes configuration: lease_duration must be at least 3s"))
+
+			os.Setenv("POD_NAME", "pod-2")
+			defer os.Unsetenv("POD_NAME")
+			cfg.Kubernetes = &Kubernetes{LeaseDurationString: "30s"}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Kubernetes.Identity).To(Equal("pod-2"))
+			Expect(cfg.Kubernetes.LeaseDuration).To(Equal(30 * time.Second))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", LeaderElectionName: "n1", LeaderPreference: &LeaderPreference{Name: "n1"}}}
+			Expect(cfg.Validate()).To(MatchError("leader_preference can not be used with kubernetes for stream GINKGO"))
+		})
+
+		It("Should validate maintenance windows", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", Maintenance: []*Maintenance{{Schedule: "0 2 * * *", DurationString: "1h", Timezone: "UTC"}}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].Maintenance[0].Duration).To(Equal(time.Hour))
+			Expect(cfg.Streams[0].Maintenance[0].Location).To(Equal(time.UTC))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", Maintenance: []*Maintenance{{Schedule: "0 2 * *", DurationString: "1h"}}}}
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring("invalid maintenance window 0 for stream GINKGO: invalid schedule: expected 5 fields")))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", Maintenance: []*Maintenance{{Schedule: "0 2 * * *"}}}}
+			Expect(cfg.Validate()).To(MatchError("invalid maintenance window 0 for stream GINKGO: duration is required"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", Maintenance: []*Maintenance{{Schedule: "0 2 * * *", DurationString: "10s"}}}}
+			Expect(cfg.Validate()).To(MatchError("invalid maintenance window 0 for stream GINKGO: duration must be at least 1m"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", Maintenance: []*Maintenance{{Schedule: "0 2 * * *", DurationString: "1h", Timezone: "Nowhere/Special"}}}}
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring("invalid maintenance window 0 for stream GINKGO: invalid timezone")))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", Maintenance: []*Maintenance{nil}}}
+			Expect(cfg.Validate()).To(MatchError("maintenance window 0 is empty for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://t1:4222", SourceMQTT: &MQTT{URL: "tcp://mqtt:1883", Topics: []string{"sensors/#"}}, Maintenance: []*Maintenance{{Schedule: "0 2 * * *", DurationString: "1h"}}}}
+			Expect(cfg.Validate()).To(MatchError("maintenance requires a source_url for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetHTTP: &HTTP{URL: "https://example.net"}, Maintenance: []*Maintenance{{Schedule: "0 2 * * *", DurationString: "1h"}}}}
+			Expect(cfg.Validate()).To(MatchError("maintenance can not be used with target_http for stream GINKGO"))
+		})
+
+		It("Should determine when maintenance windows are active", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", Maintenance: []*Maintenance{{Schedule: "0 2 * * *", DurationString: "1h", Timezone: "UTC"}}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+
+			m := cfg.Streams[0].Maintenance[0]
+			day := time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)
+
+			active, _ := m.Active(day.Add(time.Hour + 59*time.Minute))
+			Expect(active).To(BeFalse())
+			Expect(m.Next(day.Add(time.Hour)).Equal(day.Add(2 * time.Hour))).To(BeTrue())
+
+			for _, t := range []time.Time{day.Add(2 * time.Hour), day.Add(2*time.Hour + 30*time.Minute + 10*time.Second)} {
+				active, end := m.Active(t)
+				Expect(active).To(BeTrue())
+				Expect(end.Equal(day.Add(3 * time.Hour))).To(BeTrue())
+			}
+
+			active, _ = m.Active(day.Add(3 * time.Hour))
+			Expect(active).To(BeFalse())
+			Expect(m.Next(day.Add(3 * time.Hour)).Equal(day.Add(26 * time.Hour))).To(BeTrue())
+		})
+
+		It("Should validate Kafka targets", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetKafka: &Kafka{Brokers: []string{"k1:9092"}}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].TargetKafka.ClientID).To(Equal("stream-replicator-GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://t1:4222", TargetKafka: &Kafka{Brokers: []string{"k1:9092"}}}}
+			Expect(cfg.Validate()).To(MatchError("only one of target_url and target_kafka can be set for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetInitiated: true, TargetKafka: &Kafka{Brokers: []string{"k1:9092"}}}}
+			Expect(cfg.Validate()).To(MatchError("target_kafka can not be used with target_initiated for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetKafka: &Kafka{}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_kafka for stream GINKGO: brokers are required"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetKafka: &Kafka{Brokers: []string{"k1:9092"}, SASL: &KafkaSASL{Mechanism: "GSSAPI"}}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_kafka for stream GINKGO: sasl mechanism must be PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetKafka: &Kafka{Brokers: []string{"k1:9092"}, SASL: &KafkaSASL{Mechanism: KafkaSASLScram512}}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_kafka for stream GINKGO: sasl requires a username"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetKafka: &Kafka{Brokers: []string{"k1:9092"}, TLS: &TLS{SPIFFE: &SPIFFE{Socket: "unix:///run/spire/agent.sock"}}}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_kafka for stream GINKGO: spiffe is not supported for kafka"))
+		})
+
+		It("Should validate Kafka sources", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://t1:4222", SourceKafka: &Kafka{Brokers: []string{"k1:9092"}, Topics: []string{"orders"}}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].SourceKafka.ClientID).To(Equal("stream-replicator-GINKGO"))
+			Expect(cfg.Streams[0].SourceKafka.Group).To(Equal("stream-replicator-GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", SourceKafka: &Kafka{Brokers: []string{"k1:9092"}, Topics: []string{"orders"}}}}
+			Expect(cfg.Validate()).To(MatchError("only one of source_url and source_kafka can be set for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceKafka: &Kafka{Brokers: []string{"k1:9092"}, Topics: []string{"orders"}}, TargetKafka: &Kafka{Brokers: []string{"k1:9092"}}}}
+			Expect(cfg.Validate()).To(MatchError("source_kafka can not be combined with target_kafka for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", LeaderElectionName: "x", SourceKafka: &Kafka{Brokers: []string{"k1:9092"}, Topics: []string{"orders"}}}}
+			Expect(cfg.Validate()).To(MatchError("leader_election_name can not be used with source_kafka, share a consumer group instead for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceKafka: &Kafka{Brokers: []string{"k1:9092"}}}}
+			Expect(cfg.Validate()).To(MatchError("invalid source_kafka for stream GINKGO: topics are required"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceKafka: &Kafka{Brokers: []string{"k1:9092"}, Topics: []string{"orders"}, SubjectFromKey: true}}}
+			Expect(cfg.Validate()).To(MatchError("source_kafka subject_from_key requires no_target_create for stream GINKGO"))
+		})
+
+		It("Should validate MQTT sources", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://t1:4222", SourceMQTT: &MQTT{URL: "tcp://mqtt:1883", Topics: []string{"sensors/#"}}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].SourceMQTT.ClientID).To(Equal("stream-replicator-GINKGO"))
+			Expect(cfg.Streams[0].SourceMQTT.QoS).To(Equal(1))
+			Expect(cfg.Streams[0].SourceMQTT.Version).To(Equal(MQTTVersion311))
+			Expect(cfg.Streams[0].SourceMQTT.SessionExpiry).To(Equal(24 * time.Hour))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", SourceMQTT: &MQTT{URL: "tcp://mqtt:1883", Topics: []string{"sensors/#"}}}}
+			Expect(cfg.Validate()).To(MatchError("only one of source_url, source_kafka and source_mqtt can be set for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceMQTT: &MQTT{URL: "ws://mqtt:80", Topics: []string{"sensors/#"}}}}
+			Expect(cfg.Validate()).To(MatchError(`invalid source_mqtt for stream GINKGO: unsupported url scheme "ws"`))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceMQTT: &MQTT{URL: "tcp://mqtt:1883"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid source_mqtt for stream GINKGO: topics are required"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceMQTT: &MQTT{URL: "tcp://mqtt:1883", Topics: []string{"sensors/#"}, QoS: 3}}}
+			Expect(cfg.Validate()).To(MatchError("invalid source_mqtt for stream GINKGO: qos must be 1 or 2"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceMQTT: &MQTT{URL: "tcp://mqtt:1883", Topics: []string{"sensors/#"}, Version: "3.1"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid source_mqtt for stream GINKGO: version must be 3.1.1 or 5"))
+		})
+
+		It("Should validate archive targets", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetArchive: &Archive{Endpoint: "s3.example.net", Bucket: "backups"}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].TargetArchive.MaxMessages).To(Equal(10000))
+			Expect(cfg.Streams[0].TargetArchive.MaxBytes).To(Equal(int64(64 * 1024 * 1024)))
+			Expect(cfg.Streams[0].TargetArchive.FlushInterval).To(Equal(5 * time.Minute))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", TargetArchive: &Archive{Directory: "/srv/archive"}}}
+			Expect(cfg.Validate()).To(MatchError("only one of target_url, target_kafka and target_archive can be set for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetPrefix: "archived", TargetArchive: &Archive{Directory: "/srv/archive"}}}
+			Expect(cfg.Validate()).To(MatchError("target subjects can not be changed with target_archive, archives keep the original subjects for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetArchive: &Archive{}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_archive for stream GINKGO: endpoint or directory is required"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetArchive: &Archive{Endpoint: "s3.example.net"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_archive for stream GINKGO: bucket is required"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetArchive: &Archive{Directory: "/srv/archive", FlushIntervalString: "soon"}}}
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring("invalid target_archive for stream GINKGO: invalid flush_interval")))
+		})
+
+		It("Should validate archive sources", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://t1:4222", SourceArchive: &Archive{Directory: "/srv/archive"}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", SourceArchive: &Archive{Directory: "/srv/archive"}}}
+			Expect(cfg.Validate()).To(MatchError("only one of source_url, source_kafka, source_mqtt and source_archive can be set for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetArchive: &Archive{Directory: "/srv/copy"}, SourceArchive: &Archive{Directory: "/srv/archive"}}}
+			Expect(cfg.Validate()).To(MatchError("source_archive requires a target_url for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://t1:4222", FilterSubject: "ORDERS.new", SourceArchive: &Archive{Directory: "/srv/archive"}}}
+			Expect(cfg.Validate()).To(MatchError("filter_subject can not be used with source_archive for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://t1:4222", SourceArchive: &Archive{Endpoint: "s3.example.net"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid source_archive for stream GINKGO: bucket is required"))
+		})
+
+		It("Should validate file targets and sources", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetFile: &File{Directory: "/srv/outbox"}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].TargetFile.MaxBytes).To(Equal(int64(64 * 1024 * 1024)))
+			Expect(cfg.Streams[0].TargetFile.RotateInterval).To(Equal(time.Hour))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", TargetFile: &File{Directory: "/srv/outbox"}}}
+			Expect(cfg.Validate()).To(MatchError("only one of target_url, target_kafka, target_archive and target_file can be set for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetFile: &File{}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_file for stream GINKGO: directory is required"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetFile: &File{Directory: "/srv/outbox", RotateIntervalString: "-1h"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_file for stream GINKGO: rotate_interval must be positive"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://t1:4222", SourceFile: &File{Directory: "/srv/inbox", Subjects: []string{"ORDERS.>"}}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://t1:4222", SourceFile: &File{Directory: "/srv/inbox"}}}
+			Expect(cfg.Validate()).To(MatchError("source_file requires subjects to create the target stream with unless no_target_create is set for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://t1:4222", NoTargetCreate: true, SourceFile: &File{Directory: "/srv/inbox"}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetFile: &File{Directory: "/srv/outbox"}, SourceFile: &File{Directory: "/srv/inbox", Subjects: []string{"ORDERS.>"}}}}
+			Expect(cfg.Validate()).To(MatchError("source_file requires a target_url for stream GINKGO"))
+		})
+
+		It("Should validate http targets", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetHTTP: &HTTP{URL: "https://hooks.example.net/orders"}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].TargetHTTP.Batch).To(Equal(1))
+			Expect(cfg.Streams[0].TargetHTTP.Concurrency).To(Equal(1))
+			Expect(cfg.Streams[0].TargetHTTP.FlushInterval).To(Equal(time.Second))
+			Expect(cfg.Streams[0].TargetHTTP.Timeout).To(Equal(10 * time.Second))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", TargetHTTP: &HTTP{URL: "https://hooks.example.net/orders"}}}
+			Expect(cfg.Validate()).To(MatchError("only one of target_url, target_kafka, target_archive, target_file and target_http can be set for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetHTTP: &HTTP{URL: "ftp://hooks.example.net/orders"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_http for stream GINKGO: url must be a http or https url"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetHTTP: &HTTP{URL: "https://hooks.example.net/orders", Username: "bob", Token: "s3cret"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_http for stream GINKGO: only one of username, token and token_file can be set"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetHTTP: &HTTP{URL: "https://hooks.example.net/orders", Delivery: Delivery{Concurrency: -1}}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_http for stream GINKGO: batch, max_batch, concurrency and retries can not be negative"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetHTTP: &HTTP{URL: "https://hooks.example.net/orders", Delivery: Delivery{Batch: 100, MaxBatch: 10}}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_http for stream GINKGO: max_batch can not be less than batch"))
+		})
+
+		It("Should validate cloud messaging targets", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetPubSub: &PubSub{Project: "ginkgo", Topic: "orders"}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].TargetPubSub.Batch).To(Equal(1))
+			Expect(cfg.Streams[0].TargetPubSub.FlushInterval).To(Equal(time.Second))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetPubSub: &PubSub{Topic: "orders"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_pubsub for stream GINKGO: project is required"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetHTTP: &HTTP{URL: "https://hooks.example.net/orders"}, TargetSNS: &SNS{TopicARN: "arn:aws:sns:us-east-1:123456789012:orders"}}}
+			Expect(cfg.Validate()).To(MatchError("only one of target_url, target_kafka, target_archive, target_file, target_http, target_pubsub, target_sns, target_sqs, target_elasticsearch, target_clickhouse, target_syslog, target_redis, target_postgres and target_remote_write can be set for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetSNS: &SNS{TopicARN: "arn:aws:sns:us-east-1:123456789012:orders", Delivery: Delivery{Batch: 20}}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_sns for stream GINKGO: batch can not be more than 10"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetSQS: &SQS{QueueURL: "https://sqs.us-east-1.amazonaws.com/123456789012/orders", AWS: AWS{AccessKey: "AKIA"}}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_sqs for stream GINKGO: access_key requires secret_key"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", LeaderElectionName: "ginkgo", TargetSQS: &SQS{QueueURL: "https://sqs.us-east-1.amazonaws.com/123456789012/orders"}}}
+			Expect(cfg.Validate()).To(MatchError("leader_election_name can not be used with target_sqs for stream GINKGO"))
+		})
+
+		It("Should validate elasticsearch targets", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetElasticsearch: &Elasticsearch{URLs: []string{"https://es1.example.net:9200"}, Index: "{stream}-{subject:2}-{time:2006.01.02}"}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].TargetElasticsearch.Batch).To(Equal(1))
+			Expect(cfg.Streams[0].TargetElasticsearch.Timeout).To(Equal(30 * time.Second))
+			Expect(cfg.Streams[0].TargetElasticsearch.IndexName("ORDERS", "ORDERS.EU.1", time.Date(2023, 7, 4, 12, 0, 0, 0, time.UTC))).To(Equal("orders-eu-2023.07.04"))
+			Expect(cfg.Streams[0].TargetElasticsearch.IndexName("ORDERS", "ORDERS", time.Date(2023, 7, 4, 12, 0, 0, 0, time.UTC))).To(Equal("orders--2023.07.04"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetElasticsearch: &Elasticsearch{URLs: []string{"https://es1.example.net:9200"}}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_elasticsearch for stream GINKGO: index is required"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetElasticsearch: &Elasticsearch{URLs: []string{"es1.example.net:9200"}, Index: "orders"}}}
+			Expect(cfg.Validate()).To(MatchError(`invalid target_elasticsearch for stream GINKGO: url "es1.example.net:9200" must be a http or https url`))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetElasticsearch: &Elasticsearch{URLs: []string{"https://es1.example.net:9200"}, Index: "{subject:0}"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_elasticsearch for stream GINKGO: invalid index placeholder {subject:0}: subject tokens start at 1"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetElasticsearch: &Elasticsearch{URLs: []string{"https://es1.example.net:9200"}, Index: "{host}"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_elasticsearch for stream GINKGO: invalid index placeholder {host}"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetElasticsearch: &Elasticsearch{URLs: []string{"https://es1.example.net:9200"}, Index: "orders", Username: "bob", APIKey: "a2V5"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_elasticsearch for stream GINKGO: only one of username and api_key can be set"))
+		})
+
+		It("Should validate clickhouse targets", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetClickHouse: &ClickHouse{URL: "https://ch.example.net:8443", Table: "readings", Columns: map[string]string{"host": "sensor.host", "seq": "$sequence"}}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].TargetClickHouse.Format).To(Equal(ClickHouseJSON))
+			Expect(cfg.Streams[0].TargetClickHouse.Timeout).To(Equal(30 * time.Second))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetClickHouse: &ClickHouse{URL: "https://ch.example.net:8443"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_clickhouse for stream GINKGO: table is required"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetClickHouse: &ClickHouse{URL: "https://ch.example.net:8443", Table: "readings", Format: "tsv"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_clickhouse for stream GINKGO: format must be json or csv"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetClickHouse: &ClickHouse{URL: "https://ch.example.net:8443", Table: "readings", Format: ClickHouseCSV, Columns: map[string]string{"host": "host"}}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_clickhouse for stream GINKGO: column host must map to a csv field number starting at 1"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetClickHouse: &ClickHouse{URL: "https://ch.example.net:8443", Table: "readings", Columns: map[string]string{"host": "$host"}}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_clickhouse for stream GINKGO: column host has an unknown source $host"))
+		})
+
+		It("Should validate syslog targets", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetSyslog: &Syslog{Address: "siem.example.net:6514", Facility: "auth", Severity: "warning"}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].TargetSyslog.Framing).To(Equal(SyslogOctetCounting))
+			Expect(cfg.Streams[0].TargetSyslog.FacilityCode).To(Equal(4))
+			Expect(cfg.Streams[0].TargetSyslog.SeverityCode).To(Equal(4))
+			Expect(cfg.Streams[0].TargetSyslog.AppName).To(Equal("stream-replicator"))
+			Expect(cfg.Streams[0].TargetSyslog.Timeout).To(Equal(10 * time.Second))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetSyslog: &Syslog{Address: "siem.example.net"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_syslog for stream GINKGO: invalid address: address siem.example.net: missing port in address"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetSyslog: &Syslog{Address: "siem.example.net:6514", Framing: "udp"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_syslog for stream GINKGO: framing must be octet-counting or non-transparent"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetSyslog: &Syslog{Address: "siem.example.net:6514", Facility: "local9"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_syslog for stream GINKGO: unknown facility local9"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetSyslog: &Syslog{Address: "siem.example.net:6514", Severity: "9"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_syslog for stream GINKGO: unknown severity 9"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetSyslog: &Syslog{Address: "siem.example.net:6514", AppName: "stream replicator"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_syslog for stream GINKGO: app_name can be at most 48 characters without spaces"))
+		})
+
+		It("Should validate redis targets", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetRedis: &Redis{URL: "rediss://redis.example.net:6380", MaxLen: 1000}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].TargetRedis.Batch).To(Equal(1))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetRedis: &Redis{URL: "https://redis.example.net"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_redis for stream GINKGO: url must be a redis or rediss url"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetRedis: &Redis{URL: "redis://redis.example.net", MaxLen: -1}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_redis for stream GINKGO: max_len can not be negative"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetRedis: &Redis{URL: "redis://redis.example.net", ExactTrim: true}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_redis for stream GINKGO: exact_trim requires max_len"))
+		})
+
+		It("Should validate postgres targets", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetPostgres: &Postgres{URL: "postgres://pg.example.net/archive?sslmode=verify-full", Table: "archive.orders"}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].TargetPostgres.Batch).To(Equal(1))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetPostgres: &Postgres{URL: "mysql://db.example.net/archive", Table: "orders"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_postgres for stream GINKGO: url must be a postgres url"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetPostgres: &Postgres{URL: "postgres://pg.example.net/archive"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_postgres for stream GINKGO: table is required"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetPostgres: &Postgres{URL: "postgres://pg.example.net/archive", Table: "db.archive.orders"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_postgres for stream GINKGO: table must be in table or schema.table form"))
+		})
+
+		It("Should validate remote write targets", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetRemoteWrite: &RemoteWrite{URL: "https://prometheus.example.net/api/v1/write", Labels: map[string]string{"host": "sensor.host", "stream": "$stream"}}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].TargetRemoteWrite.Name).To(Equal("name"))
+			Expect(cfg.Streams[0].TargetRemoteWrite.Value).To(Equal("value"))
+			Expect(cfg.Streams[0].TargetRemoteWrite.Timeout).To(Equal(30 * time.Second))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetRemoteWrite: &RemoteWrite{URL: "https://prometheus.example.net/api/v1/write", Metric: "temperature"}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].TargetRemoteWrite.Name).To(BeEmpty())
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetRemoteWrite: &RemoteWrite{URL: "https://prometheus.example.net/api/v1/write", Metric: "temperature", Name: "metric"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_remote_write for stream GINKGO: only one of metric and name can be set"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetRemoteWrite: &RemoteWrite{URL: "https://prometheus.example.net/api/v1/write", Metric: "temperature.celsius"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_remote_write for stream GINKGO: invalid metric name temperature.celsius"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetRemoteWrite: &RemoteWrite{URL: "https://prometheus.example.net/api/v1/write", Labels: map[string]string{"__name__": "name"}}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_remote_write for stream GINKGO: invalid label name __name__"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetRemoteWrite: &RemoteWrite{URL: "https://prometheus.example.net/api/v1/write", Labels: map[string]string{"seq": "$sequence"}}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_remote_write for stream GINKGO: label seq has an unknown source $sequence"))
+		})
+
+		It("Should validate and inherit reconnect settings", func() {
+			cfg.Reconnect = &Reconnect{MinDelayString: "1s", MaxDelayString: "10s", Jitter: 0.2}
+			cfg.Streams = []*Stream{{Stream: "GINKGO"}, {Stream: "OTHER", Reconnect: &Reconnect{}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].Reconnect).To(Equal(cfg.Reconnect))
+			Expect(cfg.Reconnect.MinDelay).To(Equal(time.Second))
+			Expect(cfg.Reconnect.MaxDelay).To(Equal(10 * time.Second))
+			Expect(cfg.Streams[1].Reconnect.MinDelay).To(Equal(6 * time.Second))
+			Expect(cfg.Streams[1].Reconnect.MaxDelay).To(Equal(2 * time.Minute))
+
+			policy := cfg.Reconnect.Policy()
+			Expect(policy.Millis[0]).To(Equal(1000))
+			Expect(policy.Millis[len(policy.Millis)-1]).To(Equal(10000))
+			Expect(policy.Duration(0)).To(BeNumerically("~", time.Second, 200*time.Millisecond))
+
+			Expect(policy.Millis).To(HaveLen(18))
+
+			cfg.Reconnect = &Reconnect{MinDelayString: "1s", MaxDelayString: "5s", Steps: 5}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Reconnect.Policy().Millis).To(Equal([]int{1000, 2000, 3000, 4000, 5000}))
+
+			cfg.Reconnect = &Reconnect{Steps: -1}
+			Expect(cfg.Validate()).To(MatchError("steps can not be negative"))
+
+			cfg.Reconnect = &Reconnect{Steps: 5, Multiplier: 2}
+			Expect(cfg.Validate()).To(MatchError("only one of steps and multiplier can be set"))
+
+			cfg.Reconnect = &Reconnect{MinDelayString: "1m", MaxDelayString: "10s"}
+			Expect(cfg.Validate()).To(MatchError("max_delay cannot be less than min_delay"))
+
+			cfg.Reconnect = nil
+			cfg.Streams = []*Stream{{Stream: "GINKGO", Reconnect: &Reconnect{Jitter: 2}}}
+			Expect(cfg.Validate()).To(MatchError("invalid reconnect for stream GINKGO: jitter must be between 0 and 1"))
+
+			cfg.Reconnect = &Reconnect{MaxReconnects: 10, PingIntervalString: "20s", FlusherTimeoutString: "5s"}
+			cfg.Streams = []*Stream{{Stream: "GINKGO"}}
+			cfg.Events = &Events{URL: "nats://localhost:4222", Subject: "events.%s"}
+			cfg.HeartBeat = &HeartBeat{URL: "nats://localhost:4222", Subjects: []Subject{{Name: "hb"}}, Reconnect: &Reconnect{}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Reconnect.PingInterval).To(Equal(20 * time.Second))
+			Expect(cfg.Reconnect.FlusherTimeout).To(Equal(5 * time.Second))
+			Expect(cfg.Reconnect.ConnectOptions()).To(HaveLen(4))
+			Expect(cfg.Events.Reconnect).To(Equal(cfg.Reconnect))
+			Expect(cfg.HeartBeat.Reconnect.MaxReconnects).To(Equal(0))
+			Expect(cfg.HeartBeat.Reconnect.PingInterval).To(Equal(time.Duration(0)))
+
+			cfg.HeartBeat.Reconnect = &Reconnect{MaxReconnects: -1}
+			Expect(cfg.Validate()).To(MatchError("invalid heartbeat reconnect: max_reconnects can not be negative"))
+
+			cfg.HeartBeat = nil
+			cfg.Events.Reconnect = &Reconnect{PingIntervalString: "0s"}
+			Expect(cfg.Validate()).To(MatchError("invalid events reconnect: ping_interval must be positive"))
+
+			cfg.Events = nil
+			cfg.Reconnect = &Reconnect{FlusherTimeoutString: "x"}
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring("invalid flusher_timeout")))
+		})
+
+		It("Should validate and inherit backoff policies", func() {
+			Expect(cfg.Backoff.RetryPolicy()).To(Equal(backoff.TwentySec))
+			Expect(cfg.Backoff.ElectionPolicy()).To(Equal(backoff.FiveSec))
+			_, ok := cfg.Backoff.HeartbeatPolicy()
+			Expect(ok).To(BeFalse())
+
+			cfg.Backoff = &Backoff{Retries: &BackoffPolicy{InitialString: "1s", MaxString: "1m", Multiplier: 2}, Elections: &BackoffPolicy{}, Heartbeats: &BackoffPolicy{MaxString: "2s"}}
+			cfg.Profiles = []*Config{{ReplicatorName: "SITE1"}}
+			cfg.HeartBeat = &HeartBeat{URL: "nats://localhost:4222", Subjects: []Subject{{Name: "hb"}}}
+			cfg.Events = &Events{URL: "nats://localhost:4222", Subject: "events.%s"}
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetURL: "nats://t1:4222", AdvisoryConf: &Advisory{Subject: "advisories.%s"}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Profiles[0].Backoff).To(Equal(cfg.Backoff))
+			Expect(cfg.HeartBeat.Backoff).To(Equal(cfg.Backoff))
+			Expect(cfg.Events.Backoff).To(Equal(cfg.Backoff))
+			Expect(cfg.Streams[0].AdvisoryConf.Backoff).To(Equal(cfg.Backoff))
+
+			Expect(cfg.Backoff.RetryPolicy().Millis).To(Equal([]int{1000, 2000, 4000, 8000, 16000, 32000, 60000}))
+			Expect(cfg.Backoff.Elections.Initial).To(Equal(500 * time.Millisecond))
+			Expect(cfg.Backoff.Elections.Max).To(Equal(5 * time.Second))
+			Expect(cfg.Backoff.Elections.Multiplier).To(Equal(1.5))
+			Expect(cfg.Backoff.ElectionPolicy().Millis[0]).To(Equal(500))
+			policy, ok := cfg.Backoff.HeartbeatPolicy()
+			Expect(ok).To(BeTrue())
+			Expect(policy.Millis[len(policy.Millis)-1]).To(Equal(2000))
+
+			cfg.Profiles = nil
+			cfg.HeartBeat = nil
+			cfg.Events = nil
+			cfg.Streams = nil
+			cfg.Backoff = &Backoff{Retries: &BackoffPolicy{InitialString: "10s", MaxString: "1s"}}
+			Expect(cfg.Validate()).To(MatchError("invalid backoff retries: max cannot be less than initial"))
+
+			cfg.Backoff = &Backoff{Elections: &BackoffPolicy{Multiplier: 0.5}}
+			Expect(cfg.Validate()).To(MatchError("invalid backoff elections: multiplier must be 1 or more"))
+
+			cfg.Backoff = &Backoff{Heartbeats: &BackoffPolicy{Jitter: 2}}
+			Expect(cfg.Validate()).To(MatchError("invalid backoff heartbeats: jitter must be between 0 and 1"))
+
+			cfg.Backoff = nil
+			cfg.Reconnect = &Reconnect{MinDelayString: "1s", MaxDelayString: "10s", Multiplier: 2}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Reconnect.Policy().Millis).To(Equal([]int{1000, 2000, 4000, 8000, 10000}))
+
+			cfg.Reconnect = &Reconnect{Multiplier: -1}
+			Expect(cfg.Validate()).To(MatchError("multiplier can not be negative"))
+		})
+
+		It("Should validate and inherit proxies", func() {
+			cfg.Proxy = "http://proxy.example.net:3128"
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetProxy: "socks5://socks.example.net:1080"}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].SourceProxy).To(Equal("http://proxy.example.net:3128"))
+			Expect(cfg.Streams[0].TargetProxy).To(Equal("socks5://socks.example.net:1080"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceProxy: "ftp://proxy.example.net"}}
+			Expect(cfg.Validate()).To(MatchError(`invalid source_proxy for stream GINKGO: unsupported proxy scheme "ftp", http, socks5 and socks5h are supported`))
 		})
 
-		It("Should support inheriting TLS from the replicator", func() {
-			cfg.TLS = &TLS{}
-			cfg.Streams = []*Stream{
-				{Stream: "GINKGO"},
-			}
+		It("Should validate signing and verification", func() {
+			cfg.Signing = &Signing{}
+			Expect(cfg.Validate()).To(MatchError("seed_file is required with signing"))
+			cfg.Signing = nil
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", Verify: &Verify{}}}
+			Expect(cfg.Validate()).To(MatchError("verify requires at least one public key for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", Verify: &Verify{PublicKeys: []string{"invalid"}}}}
+			Expect(cfg.Validate()).To(MatchError(`invalid verify public key "invalid" for stream GINKGO: illegal base32 data at input byte 0`))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", Verify: &Verify{PublicKeys: []string{"UDB5UMGNAJSVXA27RDW6HYLYMVZAVHJNDZY4MXXLNAWKNHZ3AMXK3HTW"}}}}
 			Expect(cfg.Validate()).ToNot(HaveOccurred())
-			Expect(cfg.Streams[0].TLS).To(BeIdenticalTo(cfg.TLS))
-			Expect(cfg.Streams[0].SourceTLS).To(BeIdenticalTo(cfg.TLS))
-			Expect(cfg.Streams[0].TargetTLS).To(BeIdenticalTo(cfg.TLS))
+			Expect(cfg.Streams[0].Verify.Mode).To(Equal(VerifyDrop))
+
+			cfg.Streams[0].Verify.Mode = "ignore"
+			Expect(cfg.Validate()).To(MatchError("verify mode must be drop or flag for stream GINKGO"))
 		})
 
-		It("Should support source specific TLS", func() {
-			stls := &TLS{}
-			cfg.TLS = &TLS{}
-			cfg.Streams = []*Stream{
-				{Stream: "GINKGO", SourceTLS: stls},
-			}
+		It("Should validate failover", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", Failover: &Failover{}}}
+			Expect(cfg.Validate()).To(MatchError("failover requires a source_url and target_url for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", TargetPrefix: "copy.", Failover: &Failover{}}}
+			Expect(cfg.Validate()).To(MatchError("failover can not be used with filter_subject, target_subject_prefix or target_subject_remove for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", Failover: &Failover{DetectAfterString: "5s"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid failover for stream GINKGO: detect_after must be at least 10s"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetStream: "GINKGO_COPY", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", StartSequence: 10, Failover: &Failover{DetectAfterString: "1m"}}}
 			Expect(cfg.Validate()).ToNot(HaveOccurred())
-			Expect(cfg.Streams[0].TLS).To(BeIdenticalTo(cfg.TLS))
-			Expect(cfg.Streams[0].SourceTLS).To(BeIdenticalTo(stls))
-			Expect(cfg.Streams[0].TargetTLS).To(BeIdenticalTo(cfg.TLS))
+			Expect(cfg.Streams[0].Failover.DetectAfter).To(Equal(time.Minute))
+
+			start := time.Now()
+			r := cfg.Streams[0].Reversed(start)
+			Expect(r.Stream).To(Equal("GINKGO_COPY"))
+			Expect(r.TargetStream).To(Equal("GINKGO"))
+			Expect(r.SourceURL).To(Equal("nats://b:4222"))
+			Expect(r.TargetURL).To(Equal("nats://a:4222"))
+			Expect(r.StartSequence).To(BeZero())
+			Expect(r.StartTime).To(Equal(start))
+			Expect(r.Failover).To(BeNil())
+			Expect(cfg.Streams[0].Stream).To(Equal("GINKGO"))
 		})
 
-		It("Should support target specific TLS", func() {
-			ttls := &TLS{}
-			cfg.TLS = &TLS{}
-			cfg.Streams = []*Stream{
-				{Stream: "GINKGO", TargetTLS: ttls},
+		It("Should validate monitor only streams", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", MonitorOnly: true}}
+			Expect(cfg.Validate()).To(MatchError("monitor_only requires a source_url and target_url for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", TargetCore: true, MonitorOnly: true}}
+			Expect(cfg.Validate()).To(MatchError("monitor_only can only be used with JetStream sources and targets for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", MonitorOnly: true, MonitorIntervalString: "100ms"}}
+			Expect(cfg.Validate()).To(MatchError("monitor_interval must be at least 1s for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", MonitorOnly: true}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].MonitorInterval).To(Equal(30 * time.Second))
+		})
+
+		It("Should validate checksum verification", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", VerifyChecksum: &VerifyChecksum{}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].VerifyChecksum.Mode).To(Equal(VerifyCount))
+
+			cfg.Streams[0].VerifyChecksum.Mode = "ignore"
+			Expect(cfg.Validate()).To(MatchError("verify_checksum mode must be count, flag or drop for stream GINKGO"))
+		})
+
+		It("Should validate jetstream headers", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", JetStreamHeaders: &JetStreamHeaders{Expected: "drop"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid jetstream_headers for stream GINKGO: expected must be strip, preserve or remap"))
+
+			cfg.Streams[0].JetStreamHeaders = &JetStreamHeaders{Republish: "drop"}
+			Expect(cfg.Validate()).To(MatchError("invalid jetstream_headers for stream GINKGO: republish must be strip, preserve or remap"))
+
+			cfg.Streams[0].JetStreamHeaders = &JetStreamHeaders{Source: "drop"}
+			Expect(cfg.Validate()).To(MatchError("invalid jetstream_headers for stream GINKGO: source must be strip, preserve or remap"))
+
+			cfg.Streams[0].JetStreamHeaders = &JetStreamHeaders{Prefix: "Source "}
+			Expect(cfg.Validate()).To(MatchError(`invalid jetstream_headers for stream GINKGO: invalid prefix "Source "`))
+
+			cfg.Streams[0].JetStreamHeaders = &JetStreamHeaders{Prefix: "NATS-Source-"}
+			Expect(cfg.Validate()).To(MatchError("invalid jetstream_headers for stream GINKGO: prefix can not start with Nats-"))
+
+			cfg.Streams[0].JetStreamHeaders = &JetStreamHeaders{}
+			cfg.Streams[0].MonitorOnly = true
+			Expect(cfg.Validate()).To(MatchError("jetstream_headers can not be used with monitor_only for stream GINKGO"))
+
+			cfg.Streams[0].MonitorOnly = false
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].JetStreamHeaders).To(Equal(&JetStreamHeaders{Expected: HeadersStrip, Republish: HeadersPreserve, Source: HeadersRemap, Prefix: "X-Source-"}))
+		})
+
+		It("Should validate tenant guards", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", TenantGuard: &TenantGuard{}}}
+			Expect(cfg.Validate()).To(MatchError("invalid tenant_guard for stream GINKGO: header is required"))
+
+			cfg.Streams[0].TenantGuard = &TenantGuard{Header: "Tenant ID"}
+			Expect(cfg.Validate()).To(MatchError(`invalid tenant_guard for stream GINKGO: invalid header "Tenant ID"`))
+
+			cfg.Streams[0].TenantGuard = &TenantGuard{Header: "Tenant"}
+			Expect(cfg.Validate()).To(MatchError("invalid tenant_guard for stream GINKGO: at least one value is required"))
+
+			cfg.Streams[0].TenantGuard = &TenantGuard{Header: "Tenant", Values: []string{"{stream}"}}
+			Expect(cfg.Validate()).To(MatchError("invalid tenant_guard for stream GINKGO: invalid value placeholder {stream}"))
+
+			cfg.Streams[0].TenantGuard = &TenantGuard{Header: "Tenant", Values: []string{"{subject:0}"}}
+			Expect(cfg.Validate()).To(MatchError("invalid tenant_guard for stream GINKGO: invalid value placeholder {subject:0}: subject tokens start at 1"))
+
+			cfg.Streams[0].TenantGuard = &TenantGuard{Header: "Tenant", Values: []string{"acme"}, Mode: "count"}
+			Expect(cfg.Validate()).To(MatchError("invalid tenant_guard for stream GINKGO: mode must be drop or flag"))
+
+			cfg.Streams[0].MonitorOnly = true
+			Expect(cfg.Validate()).To(MatchError("tenant_guard can not be used with monitor_only for stream GINKGO"))
+
+			cfg.Streams[0].MonitorOnly = false
+			cfg.Streams[0].TenantGuard = &TenantGuard{Header: "Tenant", Values: []string{"acme", "{subject:2}-eu"}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].TenantGuard.Mode).To(Equal(VerifyDrop))
+
+			guard := cfg.Streams[0].TenantGuard
+			Expect(guard.Accepts("orders.new", "acme")).To(BeTrue())
+			Expect(guard.Accepts("tenants.initech.orders", "initech-eu")).To(BeTrue())
+			Expect(guard.Accepts("tenants.initech.orders", "initech")).To(BeFalse())
+			Expect(guard.Accepts("tenants", "{subject:2}-eu")).To(BeFalse())
+			Expect(guard.Accepts("orders.new", "")).To(BeFalse())
+		})
+
+		It("Should validate ordering verification", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", VerifyOrdering: true}}
+			Expect(cfg.Validate()).To(MatchError("verify_ordering requires a JetStream target for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://b:4222", TargetCore: true, VerifyOrdering: true}}
+			Expect(cfg.Validate()).To(MatchError("verify_ordering requires a JetStream target for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", MonitorOnly: true, VerifyOrdering: true}}
+			Expect(cfg.Validate()).To(MatchError("verify_ordering can not be used with monitor_only for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://b:4222", VerifyOrdering: true}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+		})
+
+		It("Should connect using nats CLI contexts", func() {
+			td := GinkgoT().TempDir()
+			Expect(os.MkdirAll(filepath.Join(td, "nats", "context"), 0700)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(td, "nats", "context", "east.json"), []byte(`{"url":"nats://n1:4222,nats://n2:4222","creds":"/etc/east.creds","ca":"/etc/ca.pem"}`), 0600)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(td, "west.json"), []byte(`{"url":"nats://w1:4222","user":"bob","password":"s3cret","inbox_prefix":"_INBOX_west","socks_proxy":"socks.example.net:1080"}`), 0600)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(td, "jwt.json"), []byte(`{"url":"nats://w1:4222","user_jwt":"x"}`), 0600)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(td, "domain.json"), []byte(`{"url":"nats://w1:4222","jetstream_domain":"hub"}`), 0600)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(td, "prefix.json"), []byte(`{"url":"nats://w1:4222","jetstream_api_prefix":"$JS.hub.API"}`), 0600)).To(Succeed())
+
+			os.Setenv("XDG_CONFIG_HOME", td)
+			defer os.Unsetenv("XDG_CONFIG_HOME")
+
+			targetTLS := &TLS{CA: "/etc/other.pem"}
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceContext: "east", TargetContext: filepath.Join(td, "west.json"), TargetTLS: targetTLS}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			// credentials are passed as options and never added to the url
+			Expect(cfg.Streams[0].SourceServers()).To(Equal("nats://n1:4222,nats://n2:4222"))
+			Expect(cfg.Streams[0].SourceContextOptions()).To(HaveLen(1))
+			Expect(cfg.Streams[0].SourceURL).To(BeEmpty())
+			Expect(cfg.Streams[0].SourceTLS).To(Equal(&TLS{CA: "/etc/ca.pem"}))
+			Expect(cfg.Streams[0].SourceProxy).To(BeEmpty())
+			Expect(cfg.Streams[0].TargetServers()).To(Equal("nats://w1:4222"))
+			Expect(cfg.Streams[0].TargetContextOptions()).To(HaveLen(2))
+			Expect(cfg.Streams[0].TargetURL).To(BeEmpty())
+			Expect(cfg.Streams[0].TargetTLS).To(BeIdenticalTo(targetTLS))
+			Expect(cfg.Streams[0].TargetProxy).To(Equal("socks5://socks.example.net:1080"))
+
+			// validating again resolves the contexts again
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].SourceServers()).To(Equal("nats://n1:4222,nats://n2:4222"))
+
+			// an explicit proxy is kept
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetContext: filepath.Join(td, "west.json"), TargetProxy: "http://proxy.example.net:3128"}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].TargetProxy).To(Equal("http://proxy.example.net:3128"))
+
+			r := cfg.Streams[0].Reversed(time.Now())
+			Expect(r.SourceServers()).To(Equal("nats://w1:4222"))
+			Expect(r.SourceContextOptions()).To(HaveLen(2))
+			Expect(r.TargetContextOptions()).To(BeEmpty())
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", SourceContext: "east"}}
+			Expect(cfg.Validate()).To(MatchError("only one of source_url, source_urls and source_context can be set for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetContext: "unknown"}}
+			Expect(cfg.Validate()).To(MatchError(`invalid target_context for stream GINKGO: unknown context "unknown"`))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetContext: filepath.Join(td, "jwt.json")}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_context for stream GINKGO: user_jwt is not supported, use creds instead"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetContext: filepath.Join(td, "domain.json")}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_context for stream GINKGO: jetstream_domain is not supported"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetContext: filepath.Join(td, "prefix.json")}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_context for stream GINKGO: jetstream_api_prefix is not supported"))
+
+			cfg.Streams = nil
+			cfg.HeartBeat = &HeartBeat{Context: "east", Interval: "10s", Subjects: []Subject{{Name: "x"}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.HeartBeat.Servers()).To(Equal("nats://n1:4222,nats://n2:4222"))
+			Expect(cfg.HeartBeat.ContextOptions()).To(HaveLen(1))
+			Expect(cfg.HeartBeat.URL).To(BeEmpty())
+			Expect(cfg.HeartBeat.TLS).To(Equal(TLS{CA: "/etc/ca.pem"}))
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+
+			cfg.HeartBeat = &HeartBeat{Context: "east", URL: "nats://a:4222"}
+			Expect(cfg.Validate()).To(MatchError("only one of url and context can be set with heartbeat"))
+		})
+
+		It("Should validate deduplication", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetCore: true, TargetURL: "nats://b:4222", Dedup: &Dedup{Header: "Event-Id"}}}
+			Expect(cfg.Validate()).To(MatchError("dedup requires a JetStream target for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://b:4222", Dedup: &Dedup{}}}
+			Expect(cfg.Validate()).To(MatchError("invalid dedup for stream GINKGO: one of header or field is required"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://b:4222", Dedup: &Dedup{Header: "Event-Id", Field: "id"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid dedup for stream GINKGO: one of header or field is required"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://b:4222", Dedup: &Dedup{Field: "id", WindowString: "10ms"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid dedup for stream GINKGO: window must be at least 1s"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://b:4222", Dedup: &Dedup{Field: "id"}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].Dedup.Window).To(Equal(2 * time.Minute))
+		})
+
+		It("Should validate stream templates", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://b:4222", StreamTemplate: &StreamTemplate{Name: "T_{subject:2}"}}}
+			Expect(cfg.Validate()).To(MatchError("stream_template requires a JetStream source and target for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", TargetInitiated: true, StreamTemplate: &StreamTemplate{Name: "T_{subject:2}"}}}
+			Expect(cfg.Validate()).To(MatchError("stream_template can not be used with target_initiated, monitor_only or failover for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", NoTargetCreate: true, StreamTemplate: &StreamTemplate{Name: "T_{subject:2}"}}}
+			Expect(cfg.Validate()).To(MatchError("stream_template can not be used with no_target_create, target_quota or verify_ordering for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", StreamTemplate: &StreamTemplate{}}}
+			Expect(cfg.Validate()).To(MatchError("invalid stream_template for stream GINKGO: name is required"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", StreamTemplate: &StreamTemplate{Name: "T_{stream}"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid stream_template for stream GINKGO: name requires a {subject:N} placeholder"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", StreamTemplate: &StreamTemplate{Name: "T_{subject}"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid stream_template for stream GINKGO: invalid name placeholder {subject}: subject tokens start at 1"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", StreamTemplate: &StreamTemplate{Name: "T_{time:2006}"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid stream_template for stream GINKGO: invalid name placeholder {time:2006}"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", StreamTemplate: &StreamTemplate{Name: "{stream}_{subject:3}_{subject:2}"}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].StreamTemplate.Tokens).To(Equal(3))
+			Expect(cfg.Streams[0].StreamTemplate.StreamName("GINKGO", "tenants.acme.eu.orders")).To(Equal("GINKGO_eu_acme"))
+			Expect(cfg.Streams[0].StreamTemplate.StreamName("GINKGO", "tenants.acme")).To(Equal(""))
+		})
+
+		It("Should validate starting from the target", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetCore: true, TargetURL: "nats://b:4222", StartFromTarget: true}}
+			Expect(cfg.Validate()).To(MatchError("start_from_target requires a JetStream source and target for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", TargetInitiated: true, StartFromTarget: true}}
+			Expect(cfg.Validate()).To(MatchError("start_from_target can not be used with target_initiated, it always starts from the target for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", StartFromTarget: true, TargetConnections: 2}}
+			Expect(cfg.Validate()).To(MatchError("start_from_target can not be used with shard or target_connections for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", StartFromTarget: true, Shard: &Shard{Index: 0, Count: 2}}}
+			Expect(cfg.Validate()).To(MatchError("start_from_target can not be used with shard or target_connections for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", StartFromTarget: true, StartAtEnd: true}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+		})
+
+		It("Should validate consumer names", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://b:4222", ConsumerName: "SR_{name}_{bogus}"}}
+			Expect(cfg.Validate()).To(MatchError("invalid consumer_name placeholder {bogus} for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://b:4222", ConsumerName: "SR.{name}"}}
+			Expect(cfg.Validate()).To(MatchError(`consumer_name "SR.GINKGO" is not a valid consumer name for stream GINKGO`))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", Name: "EAST", TargetStream: "COPY", TargetURL: "nats://u:p@b:4222,nats://a:4222", ConsumerName: "SR_{replicator}_{name}_{stream}_{target}_{target_id}"}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+
+			id := cfg.Streams[0].TargetID()
+			Expect(id).To(HaveLen(8))
+			Expect(cfg.Streams[0].DurableName("GINKGO")).To(Equal("SR_GINKGO_EAST_GINKGO_COPY_" + id))
+
+			other := &Stream{Stream: "GINKGO", TargetStream: "COPY", TargetURL: "nats://a:4222, nats://x:y@b:4222"}
+			Expect(other.TargetID()).To(Equal(id))
+			other.TargetURL = "nats://c:4222"
+			Expect(other.TargetID()).ToNot(Equal(id))
+
+			Expect((&Stream{Stream: "GINKGO"}).DurableName("GINKGO")).To(Equal(""))
+		})
+
+		It("Should validate subject stats", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", MonitorOnly: true, SubjectStats: &SubjectStats{}}}
+			Expect(cfg.Validate()).To(MatchError("subject_stats can not be used with monitor_only for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://b:4222", SubjectStats: &SubjectStats{Limit: -1}}}
+			Expect(cfg.Validate()).To(MatchError("invalid subject_stats for stream GINKGO: limit can not be negative"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://b:4222", SubjectStats: &SubjectStats{}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].SubjectStats.Limit).To(Equal(100))
+		})
+
+		It("Should validate target settings", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://b:4222", TargetCore: true, TargetSettings: &TargetSettings{}}}
+			Expect(cfg.Validate()).To(MatchError("target_settings requires a JetStream target for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://b:4222", NoTargetCreate: true, TargetSettings: &TargetSettings{}}}
+			Expect(cfg.Validate()).To(MatchError("target_settings can not be used with no_target_create for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://b:4222", TargetSettings: &TargetSettings{Replicas: 7}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_settings for stream GINKGO: replicas must be between 1 and 5"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://b:4222", TargetSettings: &TargetSettings{Tags: []string{" "}}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_settings for stream GINKGO: tags can not be empty"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://b:4222", TargetSettings: &TargetSettings{Storage: "disk"}}}
+			Expect(cfg.Validate()).To(MatchError(`invalid target_settings for stream GINKGO: invalid storage "disk", valid values are file or memory`))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://b:4222", TargetSettings: &TargetSettings{MaxAgeString: "500ms"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_settings for stream GINKGO: max_age must be at least 1s"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://b:4222", TargetSettings: &TargetSettings{Replicas: 3, Cluster: "dr", Tags: []string{"ssd"}, Storage: "memory", MaxAgeString: "1w"}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].TargetSettings.MaxAge).To(Equal(7 * 24 * time.Hour))
+			Expect(cfg.Streams[0].Reversed(time.Now()).TargetSettings).To(BeNil())
+		})
+
+		It("Should validate delta copies", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", Delta: &Delta{}}}
+			Expect(cfg.Validate()).To(MatchError("delta requires inspect_field, inspect_header or inspect_subject_token for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", InspectJSONField: "sender", TargetConnections: 2, Delta: &Delta{}}}
+			Expect(cfg.Validate()).To(MatchError("delta can not be used with target_connections for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", InspectJSONField: "sender", Delta: &Delta{Mode: "json_patch"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid delta for stream GINKGO: mode must be full or merge_patch"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", InspectJSONField: "sender", Delta: &Delta{FullIntervalString: "10ms"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid delta for stream GINKGO: full_interval must be at least 1s"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", InspectHeaderValue: "Sender", Delta: &Delta{}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].Delta.Mode).To(Equal(DeltaFull))
+			Expect(cfg.Streams[0].Delta.FullInterval).To(Equal(time.Hour))
+		})
+
+		It("Should validate consumer inactivity", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://t1:4222", SourceKafka: &Kafka{Brokers: []string{"k1:9092"}, Topics: []string{"orders"}}, ConsumerInactivityString: "5m"}}
+			Expect(cfg.Validate()).To(MatchError("consumer_inactivity requires a JetStream source for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", TargetInitiated: true, ConsumerInactivityString: "5m"}}
+			Expect(cfg.Validate()).To(MatchError("consumer_inactivity can not be used with target_initiated or monitor_only for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetSyslog: &Syslog{Address: "siem.example.net:6514", Facility: "auth", Severity: "warning"}, ConsumerInactivityString: "5m"}}
+			Expect(cfg.Validate()).To(MatchError("consumer_inactivity can not be used with target_syslog for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://s1:4222", TargetHTTP: &HTTP{URL: "https://example.net"}, ConsumerInactivityString: "5m"}}
+			Expect(cfg.Validate()).To(MatchError("consumer_inactivity can not be used with target_http for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", ConsumerInactivityString: "30s"}}
+			Expect(cfg.Validate()).To(MatchError("consumer_inactivity must be at least 1m for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", ConsumerInactivityString: "5m"}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].ConsumerInactivity).To(Equal(5 * time.Minute))
+		})
+
+		It("Should validate target quotas", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetQuota: &TargetQuota{}}}
+			Expect(cfg.Validate()).To(MatchError("target_quota requires a JetStream target for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://b:4222", TargetQuota: &TargetQuota{MaxMsgs: -1}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_quota for stream GINKGO: max_msgs and max_bytes can not be negative"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://b:4222", TargetQuota: &TargetQuota{PausePercent: 101}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_quota for stream GINKGO: pause_percent must be between 1 and 100"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://b:4222", TargetQuota: &TargetQuota{PausePercent: 50}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_quota for stream GINKGO: throttle_percent must be between 1 and pause_percent"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://b:4222", TargetQuota: &TargetQuota{IntervalString: "100ms"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid target_quota for stream GINKGO: interval must be at least 1s"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://b:4222", TargetQuota: &TargetQuota{MaxBytes: 1024}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].TargetQuota.ThrottlePercent).To(Equal(80))
+			Expect(cfg.Streams[0].TargetQuota.PausePercent).To(Equal(95))
+			Expect(cfg.Streams[0].TargetQuota.Interval).To(Equal(10 * time.Second))
+		})
+
+		It("Should validate storage pressure", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://b:4222", StoragePressure: &StoragePressure{}}}
+			Expect(cfg.Validate()).To(MatchError("storage_pressure requires a JetStream source and target for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", MonitorOnly: true, StoragePressure: &StoragePressure{}}}
+			Expect(cfg.Validate()).To(MatchError("storage_pressure can not be used with monitor_only or stream_template for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", StoragePressure: &StoragePressure{ResumePercent: 101}}}
+			Expect(cfg.Validate()).To(MatchError("invalid storage_pressure for stream GINKGO: resume_percent must be between 1 and 100"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", StoragePressure: &StoragePressure{IntervalString: "100ms"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid storage_pressure for stream GINKGO: interval must be at least 1s"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", StoragePressure: &StoragePressure{}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].StoragePressure.ResumePercent).To(Equal(90))
+			Expect(cfg.Streams[0].StoragePressure.Interval).To(Equal(10 * time.Second))
+		})
+
+		It("Should validate drift detection", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", TargetURL: "nats://b:4222", Drift: &Drift{}}}
+			Expect(cfg.Validate()).To(MatchError("drift requires a JetStream source and target for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", MonitorOnly: true, Drift: &Drift{}}}
+			Expect(cfg.Validate()).To(MatchError("drift can not be used with monitor_only or stream_template for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", Drift: &Drift{IntervalString: "100ms"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid drift for stream GINKGO: interval must be at least 1s"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", Drift: &Drift{Correct: []string{"retention"}}}}
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring(`invalid drift for stream GINKGO: can not correct "retention"`)))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", Drift: &Drift{Correct: []string{"max_age"}}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].Drift.Interval).To(Equal(5 * time.Minute))
+		})
+
+		It("Should validate bandwidth schedules", func() {
+			window := func(percent int, bps int64) *BandwidthWindow {
+				return &BandwidthWindow{Maintenance: Maintenance{Schedule: "0 8 * * 1-5", DurationString: "10h", Timezone: "UTC"}, Percent: percent, BytesPerSecond: bps}
 			}
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetInitiated: true, Bandwidth: &Bandwidth{BytesPerSecond: 1000}}}
+			Expect(cfg.Validate()).To(MatchError("bandwidth requires a source_url and can not be used with target_initiated or target_archive for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", Bandwidth: &Bandwidth{}}}
+			Expect(cfg.Validate()).To(MatchError("invalid bandwidth for stream GINKGO: bytes_per_second or schedule is required"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", Bandwidth: &Bandwidth{Schedule: []*BandwidthWindow{window(10, 0)}}}}
+			Expect(cfg.Validate()).To(MatchError("invalid bandwidth for stream GINKGO: percent requires bytes_per_second for window 0"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", Bandwidth: &Bandwidth{BytesPerSecond: 1000, Schedule: []*BandwidthWindow{window(10, 100)}}}}
+			Expect(cfg.Validate()).To(MatchError("invalid bandwidth for stream GINKGO: only one of percent and bytes_per_second can be set for window 0"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", Bandwidth: &Bandwidth{BytesPerSecond: 1000, Schedule: []*BandwidthWindow{window(101, 0)}}}}
+			Expect(cfg.Validate()).To(MatchError("invalid bandwidth for stream GINKGO: percent must be between 1 and 100 for window 0"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", Bandwidth: &Bandwidth{BytesPerSecond: 1000, Schedule: []*BandwidthWindow{window(0, 0)}}}}
+			Expect(cfg.Validate()).To(MatchError("invalid bandwidth for stream GINKGO: percent or bytes_per_second is required for window 0"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", Bandwidth: &Bandwidth{BytesPerSecond: 1000, Schedule: []*BandwidthWindow{{Percent: 10}}}}}
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring("invalid bandwidth for stream GINKGO: invalid window 0: invalid schedule")))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", Bandwidth: &Bandwidth{BytesPerSecond: 1000, Schedule: []*BandwidthWindow{window(10, 0)}}}}
 			Expect(cfg.Validate()).ToNot(HaveOccurred())
-			Expect(cfg.Streams[0].TLS).To(BeIdenticalTo(cfg.TLS))
-			Expect(cfg.Streams[0].TargetTLS).To(BeIdenticalTo(ttls))
-			Expect(cfg.Streams[0].SourceTLS).To(BeIdenticalTo(cfg.TLS))
+
+			// thursday
+			limit, next := cfg.Streams[0].Bandwidth.Limit(time.Date(2023, 6, 1, 9, 0, 0, 0, time.UTC))
+			Expect(limit).To(Equal(int64(100)))
+			Expect(next).To(Equal(time.Date(2023, 6, 1, 18, 0, 0, 0, time.UTC)))
+
+			// saturday
+			limit, next = cfg.Streams[0].Bandwidth.Limit(time.Date(2023, 6, 3, 9, 0, 0, 0, time.UTC))
+			Expect(limit).To(Equal(int64(1000)))
+			Expect(next).To(Equal(time.Date(2023, 6, 5, 8, 0, 0, 0, time.UTC)))
+		})
+
+		It("Should validate transforms", func() {
+			cfg.Streams = []*Stream{{Stream: "GINKGO", Transform: &Transform{}}}
+			Expect(cfg.Validate()).To(MatchError("transform requires a filter or mapping for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", Transform: &Transform{Filter: "data.amount >"}}}
+			Expect(cfg.Validate()).To(MatchError(ContainSubstring("invalid transform for stream GINKGO: invalid filter")))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", Transform: &Transform{Filter: "data.amount > 10", Mapping: `{"id": data.id}`}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", Transform: &Transform{Filter: "data.amount > 10", Workers: -1}}}
+			Expect(cfg.Validate()).To(MatchError("transform workers can not be negative for stream GINKGO"))
+		})
+
+		It("Should validate scripts", func() {
+			file := filepath.Join(GinkgoT().TempDir(), "route.lua")
+			Expect(os.WriteFile(file, []byte("function route(msg) return true end"), 0600)).To(Succeed())
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", Script: &Script{}}}
+			Expect(cfg.Validate()).To(MatchError("invalid script for stream GINKGO: file is required"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", Script: &Script{File: file}}}
+			Expect(cfg.Validate()).To(MatchError("invalid script for stream GINKGO: script does not define the function process"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", Script: &Script{File: file, Function: "route", TimeoutString: "-1s"}}}
+			Expect(cfg.Validate()).To(MatchError("invalid script for stream GINKGO: timeout must be positive"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", SourceURL: "nats://a:4222", TargetURL: "nats://b:4222", MonitorOnly: true, Script: &Script{File: file, Function: "route"}}}
+			Expect(cfg.Validate()).To(MatchError("script can not be used with monitor_only for stream GINKGO"))
+
+			cfg.Streams = []*Stream{{Stream: "GINKGO", Script: &Script{File: file, Function: "route"}}}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].Script.Timeout).To(Equal(time.Second))
+		})
+
+		It("Should validate events", func() {
+			cfg.Events = &Events{}
+			Expect(cfg.Validate()).To(MatchError("url is required with events"))
+			cfg.Events.URL = "nats://localhost:4222"
+			Expect(cfg.Validate()).To(MatchError("subject is required with events"))
+			cfg.Events.Subject = "choria.sr.events.%s"
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+		})
+
+		It("Should validate audit", func() {
+			cfg.Proxy = "http://proxy.example.net:3128"
+			cfg.Audit = &Audit{}
+			Expect(cfg.Validate()).To(MatchError("log_file or url is required with audit"))
+
+			cfg.Audit.LogFile = "/var/log/stream-replicator-audit.log"
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Audit.Subject).To(Equal(DefaultAuditSubject))
+			Expect(cfg.Audit.Proxy).To(Equal("http://proxy.example.net:3128"))
+		})
+
+		It("Should validate topology", func() {
+			cfg.Proxy = "http://proxy.example.net:3128"
+			cfg.Topology = &Topology{}
+			Expect(cfg.Validate()).To(MatchError("url is required with topology"))
+
+			cfg.Topology.URL = "nats://localhost:4222"
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Topology.Bucket).To(Equal(DefaultTopologyBucket))
+			Expect(cfg.Topology.Interval).To(Equal(30 * time.Second))
+			Expect(cfg.Topology.Proxy).To(Equal("http://proxy.example.net:3128"))
+
+			cfg.Topology.IntervalString = "500ms"
+			Expect(cfg.Validate()).To(MatchError("topology interval must be at least 1s"))
+
+			cfg.Topology.IntervalString = "1m"
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Topology.Interval).To(Equal(time.Minute))
+		})
+
+		It("Should require authentication for the admin api", func() {
+			cfg.AdminAPI = true
+			Expect(cfg.Validate()).To(MatchError("admin_api requires admin_api_auth"))
+
+			cfg.AdminAPIAuth = &AdminAPIAuth{}
+			Expect(cfg.Validate()).To(MatchError("invalid admin_api_auth: one of username, token or token_file is required"))
+
+			cfg.AdminAPIAuth = &AdminAPIAuth{Username: "admin"}
+			Expect(cfg.Validate()).To(MatchError("invalid admin_api_auth: username requires password"))
+
+			cfg.AdminAPIAuth = &AdminAPIAuth{Password: "secret"}
+			Expect(cfg.Validate()).To(MatchError("invalid admin_api_auth: one of username, token or token_file is required"))
+
+			cfg.AdminAPIAuth = &AdminAPIAuth{Username: "admin", Password: "secret", Token: "t"}
+			Expect(cfg.Validate()).To(MatchError("invalid admin_api_auth: only one of username, token and token_file can be set"))
+
+			cfg.AdminAPIAuth = &AdminAPIAuth{Username: "admin", Password: "secret"}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+
+			cfg.AdminAPIAuth = &AdminAPIAuth{TokenFile: "/etc/stream-replicator/admin.token"}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+
+			cfg.AdminAPIAuth = &AdminAPIAuth{Token: "t", TrustedProxies: []string{"192.0.2.1", "2001:db8::/32", "proxy.example.net"}}
+			Expect(cfg.Validate()).To(MatchError(`invalid admin_api_auth: invalid trusted_proxies entry "proxy.example.net"`))
+
+			cfg.AdminAPIAuth.TrustedProxies = []string{"192.0.2.1", "2001:db8::/32"}
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+		})
+
+		It("Should validate metrics forwarding", func() {
+			cfg.Proxy = "http://proxy.example.net:3128"
+			cfg.Metrics = &MetricsForwarding{}
+			Expect(cfg.Validate()).To(MatchError("url is required with metrics_forwarding"))
+
+			cfg.Metrics.URL = "nats://localhost:4222"
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Metrics.Subject).To(Equal(DefaultMetricsSubject))
+			Expect(cfg.Metrics.Interval).To(Equal(15 * time.Second))
+			Expect(cfg.Metrics.Proxy).To(Equal("http://proxy.example.net:3128"))
+
+			cfg.Metrics.Aggregate = true
+			Expect(cfg.Validate()).To(MatchError("metrics_forwarding aggregate requires monitor_port"))
+			cfg.MonitorPort = 8080
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+
+			cfg.Metrics.Subject = "metrics.>"
+			Expect(cfg.Validate()).To(MatchError(`invalid metrics_forwarding subject "metrics.>"`))
+			cfg.Metrics.Subject = "metrics"
+
+			cfg.Metrics.IntervalString = "500ms"
+			Expect(cfg.Validate()).To(MatchError("metrics_forwarding interval must be at least 1s"))
+
+			cfg.Metrics.IntervalString = "1m"
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Metrics.Interval).To(Equal(time.Minute))
 		})
 
 		It("Should configure the state file", func() {
@@ -100,6 +1627,22 @@ var _ = Describe("Config", func() {
 			Expect(cfg.Streams[0].StateFile).To(Equal(filepath.Join(os.TempDir(), "GINKGO_OTHER.json")))
 		})
 
+		It("Should load the state encryption key", func() {
+			cfg.StateDirectory = os.TempDir()
+			cfg.Streams = []*Stream{{Stream: "GINKGO"}}
+			cfg.StateEncryption = &StateEncryption{}
+			Expect(cfg.Validate()).To(MatchError("invalid state_encryption: key_env or key_file is required"))
+
+			cfg.StateEncryption.KeyEnv = "GINKGO_STATE_KEY"
+			os.Setenv("GINKGO_STATE_KEY", "c2hvcnQ=")
+			defer os.Unsetenv("GINKGO_STATE_KEY")
+			Expect(cfg.Validate()).To(MatchError("invalid state_encryption: key must be 32 bytes, got 5"))
+
+			os.Setenv("GINKGO_STATE_KEY", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+			Expect(cfg.Streams[0].StateKey).To(Equal([]byte("0123456789abcdef0123456789abcdef")))
+		})
+
 		It("Should parse inspect durations", func() {
 			cfg.Streams = []*Stream{{
 				Stream:           "GINKGO",
@@ -184,6 +1727,65 @@ var _ = Describe("Config", func() {
 
 			cfg.HeartBeat.Interval = "1s"
 			Expect(cfg.Validate()).ToNot(HaveOccurred())
+
+			leader := true
+			cfg.HeartBeat.Subjects[0].LeaderElection = &leader
+			Expect(cfg.Validate()).To(MatchError("leader_election requires heartbeat leader_election for subject s.1"))
+
+			cfg.HeartBeat.LeaderElection = true
+			Expect(cfg.Validate()).ToNot(HaveOccurred())
+		})
+	})
+
+	Describe("parse", func() {
+		It("Should parse plain configurations", func() {
+			cfg, err := parse([]byte("name: GINKGO\nstreams:\n- stream: ORDERS\n"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.ReplicatorName).To(Equal("GINKGO"))
+			Expect(cfg.Streams[0].Stream).To(Equal("ORDERS"))
+		})
+
+		It("Should parse ConfigMaps", func() {
+			cfg, err := parse([]byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: replicator
+data:
+  config.yaml: |
+    streams:
+    - stream: ORDERS
+  other.yaml: "x: 1"
+`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.ReplicatorName).To(Equal("replicator"))
+			Expect(cfg.Streams[0].Stream).To(Equal("ORDERS"))
+
+			cfg, err = parse([]byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"replicator"},"data":{"sr.yaml":"name: GINKGO"}}`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.ReplicatorName).To(Equal("GINKGO"))
+
+			_, err = parse([]byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"replicator"},"data":{"a":"","b":""}}`))
+			Expect(err).To(MatchError("ConfigMap replicator has no config.yaml key"))
+		})
+
+		It("Should parse custom resources", func() {
+			cfg, err := parse([]byte(`apiVersion: choria.io/v1
+kind: StreamReplicator
+metadata:
+  name: replicator
+spec:
+  kubernetes:
+    lease_duration: 10s
+  streams:
+  - stream: ORDERS
+`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfg.ReplicatorName).To(Equal("replicator"))
+			Expect(cfg.Kubernetes.LeaseDurationString).To(Equal("10s"))
+			Expect(cfg.Streams[0].Stream).To(Equal("ORDERS"))
+
+			_, err = parse([]byte(`{"apiVersion":"choria.io/v1","kind":"StreamReplicator","metadata":{"name":"replicator"}}`))
+			Expect(err).To(MatchError("StreamReplicator replicator has no spec"))
 		})
 	})
 })
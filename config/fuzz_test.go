@@ -0,0 +1,69 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func FuzzParse(f *testing.F) {
+	f.Add([]byte(`name: GINKGO
+streams:
+  - stream: ORDERS
+    source_url: nats://localhost:4222
+    target_url: nats://localhost:4222
+    target_prefix: copy
+    inspect_field: sender
+    inspect_duration: 1h
+    fetch:
+      batch: 100
+      max_batch: 1000
+`))
+	f.Add([]byte(`{"name":"GINKGO","streams":[{"stream":"ORDERS","target_elasticsearch":{"urls":["http://localhost:9200"],"index":"{stream}-{time:2006.01.02}"}}]}`))
+	f.Add([]byte(`name: GINKGO
+reconnect:
+  min_delay: 1s
+  max_delay: 10s
+streams:
+  - stream: ORDERS
+    source_url: nats://localhost:4222
+    target_url: nats://localhost:4222
+    catch_up:
+      pending: 1000
+    memory_budget: 1024
+`))
+	f.Add([]byte("name: [\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		cfg, err := parse(data)
+		if err != nil {
+			return
+		}
+
+		// validation creates the state directory
+		cfg.StateDirectory = ""
+
+		cfg.Validate()
+	})
+}
+
+func FuzzIndexName(f *testing.F) {
+	f.Add("{stream}-{subject:2}-{time:2006.01.02}", "ORDERS", "orders.new.eu")
+	f.Add("logs-{subject}", "LOGS", "")
+	f.Add("{subject:10}", "X", "a.b")
+	f.Add("{time:}{unknown}", "X", "a..b")
+
+	ts := time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	f.Fuzz(func(t *testing.T, index string, stream string, subject string) {
+		e := &Elasticsearch{URLs: []string{"http://localhost:9200"}, Index: index}
+		if e.validate() != nil {
+			return
+		}
+
+		e.IndexName(stream, subject, ts)
+	})
+}
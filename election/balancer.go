@@ -0,0 +1,240 @@
+// Copyright (c) 2023, R.I. Pienaar and the Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package election
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+var invalidKeyChars = regexp.MustCompile(`[^-_=a-zA-Z0-9]`)
+
+// Balancer spreads the leadership of elections sharing a pool across the candidates taking part in them, candidates
+// lead at most their fair share of the elections and stand down from elections beyond it so candidates that joined
+// can take over. Candidates advertise their membership and the elections they take part in in the election bucket,
+// these expire with the bucket TTL so departed candidates are forgotten and their elections are taken over.
+//
+// A Balancer is shared by all the elections of a candidate in the pool using WithBalancer
+type Balancer struct {
+	name       string
+	pool       string
+	bucket     nats.KeyValue
+	elections  map[string]struct{}
+	leading    map[string]struct{}
+	interval   time.Duration
+	advertised time.Time
+	members    map[string]int
+	total      int
+
+	mu sync.Mutex
+}
+
+// NewBalancer creates a balancer for the candidate name in pool, pool should be unique in the bucket and shared by all
+// candidates whose elections should be balanced
+func NewBalancer(name string, pool string, bucket nats.KeyValue) *Balancer {
+	return &Balancer{
+		name:      name,
+		pool:      invalidKeyChars.ReplaceAllString(pool, "_"),
+		bucket:    bucket,
+		elections: make(map[string]struct{}),
+		leading:   make(map[string]struct{}),
+		members:   make(map[string]int),
+	}
+}
+
+// WithBalancer balances the leadership of the election with the others sharing b
+func WithBalancer(b *Balancer) Option {
+	return func(o *options) { o.balancer = b }
+}
+
+// Leading is the number of elections the candidate leads
+func (b *Balancer) Leading() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.leading)
+}
+
+func (b *Balancer) memberPrefix() string {
+	return fmt.Sprintf("%s_members.", b.pool)
+}
+
+func (b *Balancer) electionPrefix() string {
+	return fmt.Sprintf("%s_elections.", b.pool)
+}
+
+func (b *Balancer) memberKey() string {
+	return b.memberPrefix() + invalidKeyChars.ReplaceAllString(b.name, "_")
+}
+
+// register adds the election with key to the pool, interval is how often its campaigns run
+func (b *Balancer) register(key string, interval time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.elections[key] = struct{}{}
+
+	// advertisements have to be refreshed well within the bucket TTL
+	if b.interval == 0 || interval < b.interval {
+		b.interval = interval
+	}
+	b.advertised = time.Time{}
+}
+
+// deregister removes the election with key from the pool, the membership is removed with the last election
+func (b *Balancer) deregister(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.elections, key)
+	delete(b.leading, key)
+	b.bucket.Delete(b.electionPrefix() + invalidKeyChars.ReplaceAllString(key, "_"))
+
+	if len(b.elections) == 0 {
+		b.bucket.Delete(b.memberKey())
+	} else {
+		b.advertised = time.Time{}
+	}
+}
+
+// refreshLocked advertises the membership, leadership count and elections of the candidate and loads those of the
+// others when the last refresh is older than the campaign interval
+func (b *Balancer) refreshLocked() error {
+	if !b.advertised.IsZero() && time.Since(b.advertised) < b.interval {
+		return nil
+	}
+
+	_, err := b.bucket.Put(b.memberKey(), []byte(fmt.Sprintf("%d %s", len(b.leading), b.name)))
+	if err != nil {
+		return err
+	}
+
+	for key := range b.elections {
+		_, err = b.bucket.Put(b.electionPrefix()+invalidKeyChars.ReplaceAllString(key, "_"), []byte(key))
+		if err != nil {
+			return err
+		}
+	}
+
+	keys, err := b.bucket.Keys()
+	if err != nil && !errors.Is(err, nats.ErrNoKeysFound) {
+		return err
+	}
+
+	members := make(map[string]int)
+	total := 0
+	for _, key := range keys {
+		switch {
+		case strings.HasPrefix(key, b.electionPrefix()):
+			total++
+
+		case strings.HasPrefix(key, b.memberPrefix()):
+			entry, err := b.bucket.Get(key)
+			if err != nil {
+				continue
+			}
+
+			count, name, ok := strings.Cut(string(entry.Value()), " ")
+			if !ok {
+				continue
+			}
+			leading, err := strconv.Atoi(count)
+			if err != nil {
+				continue
+			}
+			members[name] = leading
+		}
+	}
+
+	members[b.name] = len(b.leading)
+	if total < len(b.elections) {
+		total = len(b.elections)
+	}
+
+	b.members = members
+	b.total = total
+	b.advertised = time.Now()
+
+	return nil
+}
+
+// fairShareLocked is the most elections a candidate should lead
+func (b *Balancer) fairShareLocked() int {
+	members := len(b.members)
+	if members == 0 {
+		members = 1
+	}
+
+	return int(math.Ceil(float64(b.total) / float64(members)))
+}
+
+// mayCampaign determines if the candidate leads fewer elections than its fair share
+func (b *Balancer) mayCampaign() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	err := b.refreshLocked()
+	if err != nil {
+		// without knowing the others leading is better than nobody leading
+		return true
+	}
+
+	return len(b.leading) < b.fairShareLocked()
+}
+
+// won records that the candidate leads the election with key
+func (b *Balancer) won(key string) {
+	b.mu.Lock()
+	b.leading[key] = struct{}{}
+	b.advertised = time.Time{}
+	b.mu.Unlock()
+}
+
+// lost records that the candidate no longer leads the election with key
+func (b *Balancer) lost(key string) {
+	b.mu.Lock()
+	if _, ok := b.leading[key]; ok {
+		delete(b.leading, key)
+		b.advertised = time.Time{}
+	}
+	b.mu.Unlock()
+}
+
+// shouldStandDown determines if the candidate leads more than its fair share while another leads less than it, the
+// election with key is then recorded as lost so elections stand down one at a time
+func (b *Balancer) shouldStandDown(key string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	err := b.refreshLocked()
+	if err != nil {
+		return false
+	}
+
+	fair := b.fairShareLocked()
+	if len(b.leading) <= fair {
+		return false
+	}
+
+	for name, leading := range b.members {
+		if name != b.name && leading < fair {
+			delete(b.leading, key)
+			b.members[b.name] = len(b.leading)
+			b.advertised = time.Time{}
+
+			return true
+		}
+	}
+
+	return false
+}
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/choria-io/stream-replicator/backoff"
+	"github.com/choria-io/stream-replicator/clock"
 	"github.com/nats-io/nats.go"
 )
 
@@ -63,6 +64,10 @@ type election struct {
 	lastSeq    uint64
 	tries      int
 	notifyNext bool
+	liveness   liveness
+	ttlChecked time.Time
+	invalidTTL time.Duration
+	ttlWarned  bool
 
 	mu sync.Mutex
 }
@@ -78,6 +83,7 @@ func NewElection(name string, key string, bucket nats.KeyValue, opts ...Option)
 			key:        key,
 			bucket:     bucket,
 			replicator: "unknown",
+			clock:      clock.New(),
 		},
 	}
 
@@ -90,19 +96,26 @@ func NewElection(name string, key string, bucket nats.KeyValue, opts ...Option)
 
 func (e *election) configure(ctx context.Context) error {
 	var status nats.KeyValueStatus
+	var err error
 
-	err := backoff.Default.For(ctx, func(try int) error {
-		var err error
+	// bucket status is retried by the campaign backoff when one is set
+	bo := e.opts.bo
+	if bo == nil {
+		bo = backoff.Default
+	}
 
+	for try := 1; ; try++ {
 		status, err = e.opts.bucket.Status()
-		if err != nil {
-			e.debugf("Obtaining bucket stats failed on try %d: %v", try, err)
+		if err == nil {
+			break
 		}
 
-		return err
-	})
-	if err != nil {
-		return err
+		e.debugf("Obtaining bucket stats failed on try %d: %v", try, err)
+
+		err = backoff.Sleep(ctx, bo.Duration(try))
+		if err != nil {
+			return err
+		}
 	}
 
 	e.opts.ttl = status.TTL()
@@ -111,17 +124,15 @@ func (e *election) configure(ctx context.Context) error {
 	}
 
 	if !skipValidate {
-		if e.opts.ttl < time.Second {
-			return fmt.Errorf("bucket TTL should be 1 second or more")
-		}
-
-		if e.opts.ttl > time.Hour {
-			return fmt.Errorf("bucket TTL should be less than or equal to 1 hour")
+		err = validateTTL(e.opts.ttl, e.opts.cInterval)
+		if err != nil {
+			return err
 		}
+	}
+	e.ttlChecked = e.opts.clock.Now()
 
-		if e.opts.cInterval.Seconds() < 1 {
-			return fmt.Errorf("campaign interval %v too small", e.opts.cInterval)
-		}
+	if e.opts.balancer != nil {
+		e.opts.balancer.register(e.opts.key, e.opts.ttl/3)
 	}
 
 	e.debugf("Campaign interval: %v", e.opts.cInterval)
@@ -136,20 +147,67 @@ func (e *election) debugf(format string, a ...any) {
 	e.opts.debug(format, a...)
 }
 
+func (e *election) warnf(format string, a ...any) {
+	if e.opts.warn == nil {
+		e.debugf(format, a...)
+		return
+	}
+	e.opts.warn(format, a...)
+}
+
+// preferredKey is where preferred candidates advertise they are campaigning, it expires with the bucket TTL
+func (e *election) preferredKey() string {
+	return fmt.Sprintf("%s_preferred", e.opts.key)
+}
+
+// preferredCampaigning determines if a preferred candidate campaigned within the bucket TTL
+func (e *election) preferredCampaigning() bool {
+	_, err := e.opts.bucket.Get(e.preferredKey())
+	return err == nil
+}
+
 func (e *election) campaignForLeadership() error {
 	campaignsCounter.WithLabelValues(e.opts.key, e.opts.name, stateNames[CandidateState], e.opts.replicator).Inc()
 
+	if e.opts.pinned {
+		if !e.opts.preferred && e.preferredCampaigning() {
+			e.tries++
+			return nil
+		}
+
+		if e.opts.preferred {
+			_, err := e.opts.bucket.Put(e.preferredKey(), []byte(e.opts.name))
+			if err != nil {
+				e.debugf("advertising preferred candidate failed: %v", err)
+			}
+		}
+	}
+
+	if e.opts.balancer != nil && !e.opts.balancer.mayCampaign() {
+		e.tries++
+		return nil
+	}
+
 	seq, err := e.opts.bucket.Create(e.opts.key, []byte(e.opts.name))
+	if err != nil && e.leaderStalled() && e.forceElection() {
+		seq, err = e.opts.bucket.Create(e.opts.key, []byte(e.opts.name))
+	}
 	if err != nil {
 		e.tries++
 		return nil
 	}
 
+	if e.opts.balancer != nil {
+		e.opts.balancer.won(e.opts.key)
+	}
+
 	e.lastSeq = seq
 	e.state = LeaderState
 	e.tries = 0
 	e.notifyNext = true // sets state that would notify about win on next campaign
+	e.liveness = liveness{}
 	leaderGauge.WithLabelValues(e.opts.key, e.opts.name, e.opts.replicator).Set(1)
+	e.publishProgress()
 
 	return nil
 }
@@ -157,27 +215,44 @@ func (e *election) campaignForLeadership() error {
 func (e *election) maintainLeadership() error {
 	campaignsCounter.WithLabelValues(e.opts.key, e.opts.name, stateNames[LeaderState], e.opts.replicator).Inc()
 
+	if e.opts.pinned && !e.opts.preferred && e.preferredCampaigning() {
+		e.debugf("preferred candidate is campaigning, standing down")
+		e.opts.bucket.Delete(e.opts.key)
+
+		// when the win was not announced yet there is nothing to lose
+		announced := !e.notifyNext
+		e.notifyNext = false
+		e.loseLeadership(announced)
+
+		return nil
+	}
+
+	if e.opts.balancer != nil && e.opts.balancer.shouldStandDown(e.opts.key) {
+		e.debugf("leading more than the fair share of balanced elections, standing down")
+		e.opts.bucket.Delete(e.opts.key)
+
+		announced := !e.notifyNext
+		e.notifyNext = false
+		e.loseLeadership(announced)
+
+		return nil
+	}
+
 	seq, err := e.opts.bucket.Update(e.opts.key, []byte(e.opts.name), e.lastSeq)
 	if err != nil {
 		e.debugf("key update failed, moving to candidate state: %v", err)
-		e.state = CandidateState
-		e.lastSeq = math.MaxUint64
-
-		leaderGauge.WithLabelValues(e.opts.key, e.opts.name, e.opts.replicator).Set(0)
-
-		if e.opts.lostCb != nil {
-			e.opts.lostCb()
-		}
+		e.loseLeadership(true)
 
 		return err
 	}
 	e.lastSeq = seq
+	e.publishProgress()
 
 	// we wait till the next campaign to notify that we are leader to give others a chance to stand down
 	if e.notifyNext {
 		e.notifyNext = false
 		if e.opts.wonCb != nil {
-			ctxSleep(e.ctx, 200*time.Millisecond)
+			e.sleep(200 * time.Millisecond)
 			e.opts.wonCb()
 		}
 	}
@@ -185,6 +260,22 @@ func (e *election) maintainLeadership() error {
 	return nil
 }
 
+// loseLeadership moves to the candidate state, notify calls the lost callback
+func (e *election) loseLeadership(notify bool) {
+	e.state = CandidateState
+	e.lastSeq = math.MaxUint64
+
+	leaderGauge.WithLabelValues(e.opts.key, e.opts.name, e.opts.replicator).Set(0)
+
+	if e.opts.balancer != nil {
+		e.opts.balancer.lost(e.opts.key)
+	}
+
+	if notify && e.opts.lostCb != nil {
+		e.opts.lostCb()
+	}
+}
+
 func (e *election) try() error {
 	e.mu.Lock()
 	defer e.mu.Unlock()
@@ -212,21 +303,28 @@ func (e *election) campaign(wg *sync.WaitGroup) error {
 	e.state = CandidateState
 	e.mu.Unlock()
 
-	// spread out startups a bit
-	splay := time.Duration(rand.Intn(5000)) * time.Millisecond
-	ctxSleep(e.ctx, splay)
+	// spread out startups a bit, preferred candidates start right away to avoid another winning first
+	if !e.opts.pinned || !e.opts.preferred {
+		splay := time.Duration(rand.Intn(5000)) * time.Millisecond
+		e.sleep(splay)
+	}
 
-	var ticker *time.Ticker
+	var ticker clock.Ticker
 	if e.opts.bo != nil {
 		d := e.opts.bo.Duration(0)
 		campaignIntervalGauge.WithLabelValues(e.opts.key, e.opts.name, e.opts.replicator).Set(d.Seconds())
-		ticker = time.NewTicker(d)
+		ticker = e.opts.clock.NewTicker(d)
 	} else {
 		campaignIntervalGauge.WithLabelValues(e.opts.key, e.opts.name, e.opts.replicator).Set(e.opts.cInterval.Seconds())
-		ticker = time.NewTicker(e.opts.cInterval)
+		ticker = e.opts.clock.NewTicker(e.opts.cInterval)
 	}
 
 	tick := func() {
+		if e.checkTTL() && e.opts.bo == nil {
+			campaignIntervalGauge.WithLabelValues(e.opts.key, e.opts.name, e.opts.replicator).Set(e.opts.cInterval.Seconds())
+			ticker.Reset(e.opts.cInterval)
+		}
+
 		err := e.try()
 		if err != nil {
 			e.debugf("election attempt failed: %v", err)
@@ -234,6 +332,10 @@ func (e *election) campaign(wg *sync.WaitGroup) error {
 
 		if e.opts.bo != nil {
 			d := e.opts.bo.Duration(e.tries)
+			// leaders have to campaign within the bucket TTL to keep their key
+			if e.State() == LeaderState && e.opts.cInterval > 0 && d > e.opts.cInterval {
+				d = e.opts.cInterval
+			}
 			campaignIntervalGauge.WithLabelValues(e.opts.key, e.opts.name, e.opts.replicator).Set(d.Seconds())
 			ticker.Reset(d)
 		}
@@ -244,7 +346,7 @@ func (e *election) campaign(wg *sync.WaitGroup) error {
 
 	for {
 		select {
-		case <-ticker.C:
+		case <-ticker.C():
 			tick()
 
 		case <-e.ctx.Done():
@@ -257,6 +359,12 @@ func (e *election) campaign(wg *sync.WaitGroup) error {
 			}
 
 			e.opts.bucket.Delete(e.opts.key)
+			if e.opts.pinned && e.opts.preferred {
+				e.opts.bucket.Delete(e.preferredKey())
+			}
+			if e.opts.balancer != nil {
+				e.opts.balancer.deregister(e.opts.key)
+			}
 
 			return nil
 		}
@@ -332,15 +440,7 @@ func (e *election) State() State {
 	return e.state
 }
 
-func ctxSleep(ctx context.Context, duration time.Duration) error {
-	if ctx.Err() != nil {
-		return ctx.Err()
-	}
-
-	sctx, cancel := context.WithTimeout(ctx, duration)
-	defer cancel()
-
-	<-sctx.Done()
-
-	return ctx.Err()
+// sleep sleeps for duration using the election clock, it returns early when the election stops
+func (e *election) sleep(duration time.Duration) error {
+	return clock.Sleep(e.ctx, e.opts.clock, duration)
 }
@@ -12,10 +12,15 @@ import (
 	"testing"
 	"time"
 
+	"github.com/choria-io/stream-replicator/clock"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
 	"github.com/nats-io/nats-server/v2/server"
 	"github.com/nats-io/nats.go"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 )
 
 func TestLeader(t *testing.T) {
@@ -203,6 +208,532 @@ var _ = Describe("Leader Election", func() {
 				Fail(fmt.Sprintf("Had %d leaders", maxActive))
 			}
 		})
+
+		It("Should campaign using the clock", func() {
+			var (
+				campaigns = 0
+				won       = false
+				mu        = sync.Mutex{}
+			)
+
+			skipValidate = true
+			mock := clock.NewMock(time.Now())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			elect, err := NewElection("member", "election", kv,
+				WithClock(mock),
+				OnCampaign(func(_ State) {
+					mu.Lock()
+					campaigns++
+					mu.Unlock()
+				}),
+				OnWon(func() {
+					mu.Lock()
+					won = true
+					mu.Unlock()
+				}))
+			Expect(err).ToNot(HaveOccurred())
+
+			go elect.Start(ctx)
+
+			status := func() (int, bool) {
+				mu.Lock()
+				defer mu.Unlock()
+				return campaigns, won
+			}
+
+			// waits for the startup splay before the first campaign
+			Eventually(mock.Timers).Should(Equal(1))
+			Consistently(func() int { c, _ := status(); return c }, "200ms").Should(Equal(0))
+
+			Eventually(func() bool {
+				mock.Add(100 * time.Millisecond)
+				_, won := status()
+				return won
+			}, "5s").Should(BeTrue())
+
+			// no campaigns happen while time does not move
+			before, _ := status()
+			Expect(before).To(BeNumerically(">=", 2))
+			Consistently(func() int { c, _ := status(); return c }, "500ms").Should(Equal(before))
+
+			mock.Add(time.Second)
+			Eventually(func() int { c, _ := status(); return c }).Should(BeNumerically(">", before))
+		})
+
+		It("Should adapt to bucket TTL changes", func() {
+			var (
+				warnings []string
+				won      = false
+				mu       = sync.Mutex{}
+			)
+
+			skipValidate = true
+			mock := clock.NewMock(time.Now())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			elect, err := NewElection("member", "election", kv,
+				WithClock(mock),
+				WithWarnings(func(format string, a ...any) {
+					mu.Lock()
+					warnings = append(warnings, fmt.Sprintf(format, a...))
+					mu.Unlock()
+				}),
+				OnWon(func() {
+					mu.Lock()
+					won = true
+					mu.Unlock()
+				}))
+			Expect(err).ToNot(HaveOccurred())
+
+			go elect.Start(ctx)
+
+			logged := func() []string {
+				mu.Lock()
+				defer mu.Unlock()
+				return append([]string{}, warnings...)
+			}
+
+			Eventually(mock.Timers).Should(Equal(1))
+			Eventually(func() bool {
+				mock.Add(100 * time.Millisecond)
+				mu.Lock()
+				defer mu.Unlock()
+				return won
+			}, "5s").Should(BeTrue())
+
+			setTTL := func(ttl time.Duration) {
+				mgr, err := jsm.New(nc)
+				Expect(err).ToNot(HaveOccurred())
+				str, err := mgr.LoadStream("KV_LEADER_ELECTION")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(str.UpdateConfiguration(str.Configuration(), jsm.MaxAge(ttl))).To(Succeed())
+			}
+
+			setTTL(2 * time.Second)
+			mock.Add(time.Minute)
+			Eventually(logged).Should(Equal([]string{"Election bucket TTL changed from 750ms to 2s, campaigning every 1.5s"}))
+
+			// the leader keeps its key campaigning at the new interval
+			for i := 0; i < 5; i++ {
+				mock.Add(1500 * time.Millisecond)
+				time.Sleep(20 * time.Millisecond)
+			}
+			entry, err := kv.Get("election")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(entry.Value())).To(Equal("member"))
+
+			// unsupported TTLs are warned about once and the interval is kept
+			setTTL(0)
+			mock.Add(time.Minute)
+			Eventually(logged).Should(HaveLen(2))
+			Expect(logged()[1]).To(Equal("Election bucket TTL changed from 2s to 0s, keeping the campaign interval of 1.5s: bucket TTL should be 1 second or more"))
+			mock.Add(time.Minute)
+			Consistently(logged, "200ms").Should(HaveLen(2))
+		})
+
+		It("Should pin leadership to the preferred candidate", func() {
+			var (
+				active    = map[string]struct{}{}
+				maxActive = 0
+				mu        = sync.Mutex{}
+				wg        = sync.WaitGroup{}
+			)
+
+			skipValidate = true
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			leaders := func() []string {
+				mu.Lock()
+				defer mu.Unlock()
+
+				var names []string
+				for name := range active {
+					names = append(names, name)
+				}
+				return names
+			}
+
+			start := func(ctx context.Context, wg *sync.WaitGroup, name string, preferred bool) {
+				elect, err := NewElection(name, "election", kv,
+					WithLeaderPreference(preferred),
+					OnWon(func() {
+						mu.Lock()
+						active[name] = struct{}{}
+						if len(active) > maxActive {
+							maxActive = len(active)
+						}
+						mu.Unlock()
+					}),
+					OnLost(func() {
+						mu.Lock()
+						delete(active, name)
+						mu.Unlock()
+					}),
+					WithDebug(debugger))
+				Expect(err).ToNot(HaveOccurred())
+
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					elect.Start(ctx)
+				}()
+			}
+
+			start(ctx, &wg, "backup", false)
+			Eventually(leaders, "10s", "50ms").Should(Equal([]string{"backup"}))
+
+			// the preferred candidate takes over once it campaigns
+			pctx, pcancel := context.WithCancel(ctx)
+			defer pcancel()
+			pwg := sync.WaitGroup{}
+			start(pctx, &pwg, "primary", true)
+			Eventually(leaders, "5s", "50ms").Should(Equal([]string{"primary"}))
+			Consistently(leaders, "2s", "50ms").Should(Equal([]string{"primary"}))
+
+			// and the other candidate fails over when it is gone, losses are not notified on shutdown
+			pcancel()
+			pwg.Wait()
+			mu.Lock()
+			delete(active, "primary")
+			mu.Unlock()
+			Eventually(leaders, "5s", "50ms").Should(Equal([]string{"backup"}))
+
+			cancel()
+			wg.Wait()
+
+			mu.Lock()
+			defer mu.Unlock()
+			Expect(maxActive).To(Equal(1))
+		})
+
+		It("Should balance leadership across candidates", func() {
+			var (
+				leading = map[string]map[string]bool{"a": {}, "b": {}}
+				mu      = sync.Mutex{}
+				wg      = sync.WaitGroup{}
+			)
+
+			skipValidate = true
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			counts := func() map[string]int {
+				mu.Lock()
+				defer mu.Unlock()
+
+				res := map[string]int{}
+				for name, keys := range leading {
+					for _, l := range keys {
+						if l {
+							res[name]++
+						}
+					}
+				}
+				return res
+			}
+
+			start := func(ctx context.Context, wg *sync.WaitGroup, name string) *Balancer {
+				b := NewBalancer(name, "GINKGO", kv)
+
+				for i := 1; i <= 4; i++ {
+					key := fmt.Sprintf("group_%d", i)
+					elect, err := NewElection(name, key, kv,
+						WithBalancer(b),
+						OnWon(func() {
+							mu.Lock()
+							leading[name][key] = true
+							mu.Unlock()
+						}),
+						OnLost(func() {
+							mu.Lock()
+							leading[name][key] = false
+							mu.Unlock()
+						}),
+						WithDebug(debugger))
+					Expect(err).ToNot(HaveOccurred())
+
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						elect.Start(ctx)
+					}()
+				}
+
+				return b
+			}
+
+			a := start(ctx, &wg, "a")
+			Eventually(counts, "15s", "50ms").Should(Equal(map[string]int{"a": 4}))
+			Expect(a.Leading()).To(Equal(4))
+
+			// leadership is shared once another candidate joins
+			bctx, bcancel := context.WithCancel(ctx)
+			defer bcancel()
+			bwg := sync.WaitGroup{}
+			b := start(bctx, &bwg, "b")
+			Eventually(counts, "15s", "50ms").Should(Equal(map[string]int{"a": 2, "b": 2}))
+			Consistently(counts, "2s", "50ms").Should(Equal(map[string]int{"a": 2, "b": 2}))
+			Expect(b.Leading()).To(Equal(2))
+
+			// and taken back once it leaves, losses are not notified on shutdown
+			bcancel()
+			bwg.Wait()
+			mu.Lock()
+			leading["b"] = map[string]bool{}
+			mu.Unlock()
+			Eventually(counts, "15s", "50ms").Should(Equal(map[string]int{"a": 4}))
+
+			_, err := kv.Get("GINKGO_members.b")
+			Expect(err).To(MatchError(nats.ErrKeyNotFound))
+
+			cancel()
+			wg.Wait()
+		})
+
+		It("Should force a new election when the leader makes no progress", func() {
+			var (
+				active = map[string]struct{}{}
+				mu     = sync.Mutex{}
+				wg     = sync.WaitGroup{}
+			)
+
+			skipValidate = true
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			leaders := func() []string {
+				mu.Lock()
+				defer mu.Unlock()
+
+				var names []string
+				for name := range active {
+					names = append(names, name)
+				}
+				return names
+			}
+
+			start := func(name string, progress func() time.Time) {
+				elect, err := NewElection(name, "election", kv,
+					WithLiveness(progress, time.Second),
+					OnWon(func() {
+						mu.Lock()
+						active[name] = struct{}{}
+						mu.Unlock()
+					}),
+					OnLost(func() {
+						mu.Lock()
+						delete(active, name)
+						mu.Unlock()
+					}),
+					WithDebug(debugger))
+				Expect(err).ToNot(HaveOccurred())
+
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					elect.Start(ctx)
+				}()
+			}
+
+			// the wedged candidate keeps campaigning but its progress stays where it was when it won
+			wedged := time.Now()
+			start("wedged", func() time.Time { return wedged })
+			Eventually(leaders, "10s", "50ms").Should(Equal([]string{"wedged"}))
+
+			entry, err := kv.Get("election_progress")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(entry.Value())).To(Equal(fmt.Sprintf("%d wedged", wedged.UnixMilli())))
+
+			start("healthy", time.Now)
+			Eventually(leaders, "10s", "50ms").Should(Equal([]string{"healthy"}))
+			Consistently(leaders, "2s", "50ms").Should(Equal([]string{"healthy"}))
+			Expect(getPromCountValue(staleLeadersCounter, "election", "healthy", "unknown")).To(Equal(1.0))
+
+			cancel()
+			wg.Wait()
+		})
+
+		It("Should move leadership away from a partitioned leader", func() {
+			var (
+				active    = map[string]struct{}{}
+				maxActive = 0
+				proxies   = map[string]*testutil.Proxy{}
+				mu        = sync.Mutex{}
+				wg        = sync.WaitGroup{}
+			)
+
+			skipValidate = true
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			leaders := func() []string {
+				mu.Lock()
+				defer mu.Unlock()
+
+				var names []string
+				for name := range active {
+					names = append(names, name)
+				}
+				return names
+			}
+
+			for i := 0; i < 2; i++ {
+				name := fmt.Sprintf("member %d", i)
+
+				proxy, err := testutil.NewProxy(srv.ClientURL())
+				Expect(err).ToNot(HaveOccurred())
+				defer proxy.Close()
+				proxies[name] = proxy
+
+				// without a reconnect buffer campaigns fail as soon as the connection is lost rather than after the
+				// request timeout, so a partitioned leader stands down before its key expires
+				pnc, err := nats.Connect(proxy.URL(), nats.MaxReconnects(-1), nats.ReconnectWait(100*time.Millisecond), nats.ReconnectBufSize(-1))
+				Expect(err).ToNot(HaveOccurred())
+				defer pnc.Close()
+
+				pjs, err := pnc.JetStream()
+				Expect(err).ToNot(HaveOccurred())
+				pkv, err := pjs.KeyValue("LEADER_ELECTION")
+				Expect(err).ToNot(HaveOccurred())
+
+				elect, err := NewElection(name, "election", pkv,
+					OnWon(func() {
+						mu.Lock()
+						active[name] = struct{}{}
+						if len(active) > maxActive {
+							maxActive = len(active)
+						}
+						mu.Unlock()
+					}),
+					OnLost(func() {
+						mu.Lock()
+						delete(active, name)
+						mu.Unlock()
+					}),
+					WithDebug(debugger))
+				Expect(err).ToNot(HaveOccurred())
+
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					elect.Start(ctx)
+				}()
+			}
+
+			Eventually(leaders, "10s", "50ms").Should(HaveLen(1))
+			first := leaders()[0]
+
+			proxies[first].Partition()
+			Eventually(leaders, "10s", "50ms").Should(And(HaveLen(1), Not(ContainElement(first))))
+			second := leaders()[0]
+
+			// the healed member reconnects as a candidate and does not take leadership back
+			proxies[first].Heal()
+			Consistently(leaders, "3s", "50ms").Should(Equal([]string{second}))
+
+			cancel()
+			wg.Wait()
+
+			mu.Lock()
+			defer mu.Unlock()
+			Expect(maxActive).To(Equal(1))
+		})
+	})
+})
+
+var _ = Describe("Leader Election in Clusters", func() {
+	It("Should keep a single leader when the bucket leader fails", func() {
+		skipValidate = false
+
+		testutil.WithJetStreamCluster(nil, 3, func(servers []*server.Server, nc *nats.Conn, _ *jsm.Manager) {
+			js, err := nc.JetStream()
+			Expect(err).ToNot(HaveOccurred())
+
+			kv, err := js.CreateKeyValue(&nats.KeyValueConfig{
+				Bucket:   "LEADER_ELECTION",
+				TTL:      2 * time.Second,
+				Replicas: 3,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			status, err := kv.Status()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(status.(*nats.KeyValueBucketStatus).StreamInfo().Cluster.Replicas).To(HaveLen(2))
+
+			var (
+				active    = make(map[string]struct{})
+				maxActive = 0
+				mu        = sync.Mutex{}
+				wg        = &sync.WaitGroup{}
+			)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			leaders := func() int {
+				mu.Lock()
+				defer mu.Unlock()
+				return len(active)
+			}
+
+			for i := 0; i < 2; i++ {
+				name := fmt.Sprintf("member %d", i)
+
+				elect, err := NewElection(name, "election", kv,
+					OnWon(func() {
+						mu.Lock()
+						active[name] = struct{}{}
+						if len(active) > maxActive {
+							maxActive = len(active)
+						}
+						mu.Unlock()
+					}),
+					OnLost(func() {
+						mu.Lock()
+						delete(active, name)
+						mu.Unlock()
+					}))
+				Expect(err).ToNot(HaveOccurred())
+
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					elect.Start(ctx)
+				}()
+			}
+
+			Eventually(leaders, "15s", "100ms").Should(Equal(1))
+
+			leader := testutil.StreamLeader(servers, "KV_LEADER_ELECTION")
+			Expect(leader).ToNot(BeNil())
+			leader.Shutdown()
+			leader.WaitForShutdown()
+
+			Eventually(func() *server.Server {
+				return testutil.StreamLeader(servers, "KV_LEADER_ELECTION")
+			}, "15s", "100ms").ShouldNot(BeNil())
+
+			// campaigns continue against the new stream leader so leadership is kept or taken over
+			Eventually(leaders, "15s", "100ms").Should(Equal(1))
+			Consistently(leaders, "4s", "100ms").Should(Equal(1))
+
+			cancel()
+			wg.Wait()
+
+			mu.Lock()
+			defer mu.Unlock()
+			Expect(maxActive).To(Equal(1))
+		})
 	})
 })
 
@@ -240,3 +771,30 @@ func startJSServer(t GinkgoTInterface) (*server.Server, *nats.Conn) {
 
 	return s, nc
 }
+
+func ctxSleep(ctx context.Context, duration time.Duration) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	sctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	<-sctx.Done()
+
+	return ctx.Err()
+}
+
+func getPromCountValue(ctr *prometheus.CounterVec, labels ...string) float64 {
+	pb := &dto.Metric{}
+	m, err := ctr.GetMetricWithLabelValues(labels...)
+	if err != nil {
+		return 0
+	}
+
+	if m.Write(pb) != nil {
+		return 0
+	}
+
+	return pb.GetCounter().GetValue()
+}
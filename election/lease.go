@@ -0,0 +1,308 @@
+// Copyright (c) 2023, R.I. Pienaar and the Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package election
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/clock"
+	"github.com/choria-io/stream-replicator/internal/kubernetes"
+)
+
+// implements Election using a Kubernetes Lease
+type leaseElection struct {
+	opts     *options
+	client   *kubernetes.Client
+	duration time.Duration
+	state    State
+
+	ctx        context.Context
+	cancel     context.CancelFunc
+	started    bool
+	renewed    time.Time
+	tries      int
+	notifyNext bool
+
+	mu sync.Mutex
+}
+
+// NewLeaseElection creates an election for the Kubernetes Lease called key, the leader holds the lease by renewing
+// it within duration. Leader preferences are not supported.
+func NewLeaseElection(name string, key string, client *kubernetes.Client, duration time.Duration, opts ...Option) (Election, error) {
+	if !skipValidate && duration < 3*time.Second {
+		return nil, fmt.Errorf("lease duration should be 3 seconds or more")
+	}
+
+	e := &leaseElection{
+		state:    UnknownState,
+		client:   client,
+		duration: duration,
+		opts: &options{
+			name:       name,
+			key:        key,
+			replicator: "unknown",
+			clock:      clock.New(),
+		},
+	}
+
+	for _, opt := range opts {
+		opt(e.opts)
+	}
+
+	if e.opts.cInterval == 0 {
+		e.opts.cInterval = duration / 3
+	}
+
+	return e, nil
+}
+
+func (e *leaseElection) debugf(format string, a ...any) {
+	if e.opts.debug == nil {
+		return
+	}
+	e.opts.debug(format, a...)
+}
+
+func (e *leaseElection) try() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.opts.campaignCb != nil {
+		e.opts.campaignCb(e.state)
+	}
+
+	campaignsCounter.WithLabelValues(e.opts.key, e.opts.name, stateNames[e.state], e.opts.replicator).Inc()
+
+	ctx, cancel := context.WithTimeout(e.ctx, e.opts.cInterval)
+	defer cancel()
+
+	now := e.opts.clock.Now()
+
+	lease, err := e.client.GetLease(ctx, e.opts.key)
+	if errors.Is(err, kubernetes.ErrNotFound) {
+		lease = kubernetes.NewLease(e.opts.key)
+		e.hold(lease, now)
+
+		_, err = e.client.CreateLease(ctx, lease)
+		if err != nil {
+			return e.failed(now, err)
+		}
+
+		e.won(now)
+		return nil
+	}
+	if err != nil {
+		return e.failed(now, err)
+	}
+
+	switch {
+	case lease.Spec.HolderIdentity == e.opts.name:
+		lease.Spec.RenewTime = kubernetes.NewMicroTime(now)
+		lease.Spec.LeaseDurationSeconds = e.durationSeconds()
+
+		_, err = e.client.UpdateLease(ctx, lease)
+		if err != nil {
+			return e.failed(now, err)
+		}
+
+		if e.state != LeaderState {
+			e.won(now)
+			return nil
+		}
+		e.renewed = now
+
+		// we wait till the next renewal to notify that we are leader to give the previous leader a chance to stand down
+		if e.notifyNext {
+			e.notifyNext = false
+			if e.opts.wonCb != nil {
+				e.opts.wonCb()
+			}
+		}
+
+	case !lease.Expired(now):
+		if e.state == LeaderState {
+			e.debugf("lease is held by %s, moving to candidate state", lease.Spec.HolderIdentity)
+			e.loseLeadership()
+		}
+		e.tries++
+
+	default:
+		e.hold(lease, now)
+		lease.Spec.LeaseTransitions++
+
+		_, err = e.client.UpdateLease(ctx, lease)
+		if err != nil {
+			return e.failed(now, err)
+		}
+
+		e.won(now)
+	}
+
+	return nil
+}
+
+// durationSeconds is the lease duration rounded up to whole seconds as stored in leases
+func (e *leaseElection) durationSeconds() int {
+	return int(math.Ceil(e.duration.Seconds()))
+}
+
+// hold sets the lease spec to be held by this candidate from now
+func (e *leaseElection) hold(lease *kubernetes.Lease, now time.Time) {
+	lease.Spec.HolderIdentity = e.opts.name
+	lease.Spec.LeaseDurationSeconds = e.durationSeconds()
+	lease.Spec.AcquireTime = kubernetes.NewMicroTime(now)
+	lease.Spec.RenewTime = kubernetes.NewMicroTime(now)
+}
+
+func (e *leaseElection) won(now time.Time) {
+	e.state = LeaderState
+	e.renewed = now
+	e.tries = 0
+	e.notifyNext = true
+	leaderGauge.WithLabelValues(e.opts.key, e.opts.name, e.opts.replicator).Set(1)
+}
+
+// failed handles failed lease operations, a leader that could not renew in time or lost a race stands down before
+// other candidates can consider the lease expired
+func (e *leaseElection) failed(now time.Time, err error) error {
+	e.tries++
+
+	if e.state != LeaderState {
+		return err
+	}
+
+	if errors.Is(err, kubernetes.ErrConflict) || now.Sub(e.renewed) > e.duration/2 {
+		e.debugf("lease renewal failed, moving to candidate state: %v", err)
+		e.loseLeadership()
+	}
+
+	return err
+}
+
+func (e *leaseElection) loseLeadership() {
+	// when the win was not announced yet there is nothing to lose
+	announced := !e.notifyNext
+
+	e.state = CandidateState
+	e.notifyNext = false
+	leaderGauge.WithLabelValues(e.opts.key, e.opts.name, e.opts.replicator).Set(0)
+
+	if announced && e.opts.lostCb != nil {
+		e.opts.lostCb()
+	}
+}
+
+// release gives up a held lease so other candidates can take over without waiting for it to expire
+func (e *leaseElection) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), e.opts.cInterval)
+	defer cancel()
+
+	lease, err := e.client.GetLease(ctx, e.opts.key)
+	if err != nil || lease.Spec.HolderIdentity != e.opts.name {
+		return
+	}
+
+	lease.Spec.HolderIdentity = ""
+	_, err = e.client.UpdateLease(ctx, lease)
+	if err != nil {
+		e.debugf("releasing lease failed: %v", err)
+	}
+}
+
+func (e *leaseElection) campaign(wg *sync.WaitGroup) error {
+	defer wg.Done()
+
+	e.mu.Lock()
+	e.state = CandidateState
+	e.mu.Unlock()
+
+	// spread out startups a bit
+	splay := time.Duration(rand.Int63n(int64(e.opts.cInterval)))
+	clock.Sleep(e.ctx, e.opts.clock, splay)
+
+	campaignIntervalGauge.WithLabelValues(e.opts.key, e.opts.name, e.opts.replicator).Set(e.opts.cInterval.Seconds())
+	ticker := e.opts.clock.NewTicker(e.opts.cInterval)
+
+	tick := func() {
+		err := e.try()
+		if err != nil {
+			e.debugf("election attempt failed: %v", err)
+		}
+	}
+
+	// initial campaign
+	tick()
+
+	for {
+		select {
+		case <-ticker.C():
+			tick()
+
+		case <-e.ctx.Done():
+			ticker.Stop()
+			e.stop()
+			e.release()
+
+			return nil
+		}
+	}
+}
+
+func (e *leaseElection) stop() {
+	e.mu.Lock()
+	e.started = false
+	e.cancel()
+	e.state = CandidateState
+	e.mu.Unlock()
+}
+
+func (e *leaseElection) Start(ctx context.Context) error {
+	e.mu.Lock()
+	if e.started {
+		e.mu.Unlock()
+		return fmt.Errorf("already running")
+	}
+
+	e.debugf("Campaign interval: %v", e.opts.cInterval)
+
+	e.ctx, e.cancel = context.WithCancel(ctx)
+	e.started = true
+	e.mu.Unlock()
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+
+	err := e.campaign(wg)
+	if err != nil {
+		e.stop()
+		return err
+	}
+
+	wg.Wait()
+
+	return nil
+}
+
+func (e *leaseElection) Stop() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.started {
+		return
+	}
+
+	if e.cancel != nil {
+		e.cancel()
+	}
+
+	e.started = false
+	e.state = CandidateState
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2023, R.I. Pienaar and the Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package election
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/internal/kubernetes"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Lease Election", func() {
+	var (
+		api      *testutil.KubernetesAPI
+		client   *kubernetes.Client
+		debugger func(f string, a ...any)
+	)
+
+	BeforeEach(func() {
+		skipValidate = false
+		api = testutil.NewKubernetesAPI()
+		client = kubernetes.New(api.URL(), "ginkgo")
+		debugger = func(f string, a ...any) {
+			fmt.Fprintf(GinkgoWriter, fmt.Sprintf("%s\n", f), a...)
+		}
+	})
+
+	AfterEach(func() {
+		api.Close()
+	})
+
+	It("Should validate the lease duration", func() {
+		_, err := NewLeaseElection("test", "test-key", client, time.Second)
+		Expect(err).To(MatchError("lease duration should be 3 seconds or more"))
+	})
+
+	It("Should elect a single leader and hand over on shutdown", func() {
+		var (
+			active = make(map[string]struct{})
+			lost   = 0
+			mu     = sync.Mutex{}
+			wg     = sync.WaitGroup{}
+		)
+
+		skipValidate = true
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		cancels := map[string]context.CancelFunc{}
+
+		for _, name := range []string{"a", "b"} {
+			name := name
+			elect, err := NewLeaseElection(name, "election", client, 1500*time.Millisecond,
+				WithDebug(debugger),
+				OnWon(func() {
+					mu.Lock()
+					active[name] = struct{}{}
+					mu.Unlock()
+				}),
+				OnLost(func() {
+					mu.Lock()
+					lost++
+					delete(active, name)
+					mu.Unlock()
+				}))
+			Expect(err).ToNot(HaveOccurred())
+
+			ectx, ecancel := context.WithCancel(ctx)
+			cancels[name] = ecancel
+
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				Expect(elect.Start(ectx)).To(Succeed())
+			}()
+		}
+
+		leaders := func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+
+			var names []string
+			for name := range active {
+				names = append(names, name)
+			}
+			return names
+		}
+
+		Eventually(leaders, "3s").Should(HaveLen(1))
+		Consistently(leaders, "1s").Should(HaveLen(1))
+
+		leader := leaders()[0]
+		Expect(api.Holder("ginkgo", "election")).To(Equal(leader))
+
+		// the lease is released on shutdown so the other candidate takes over before it would expire
+		cancels[leader]()
+		mu.Lock()
+		delete(active, leader)
+		mu.Unlock()
+
+		Eventually(leaders, "2s").Should(HaveLen(1))
+		Expect(leaders()[0]).ToNot(Equal(leader))
+		Expect(api.Holder("ginkgo", "election")).To(Equal(leaders()[0]))
+
+		cancel()
+		wg.Wait()
+
+		mu.Lock()
+		Expect(lost).To(Equal(0))
+		mu.Unlock()
+	})
+
+	It("Should stand down when the lease can not be renewed", func() {
+		var (
+			won  = 0
+			lost = 0
+			mu   = sync.Mutex{}
+		)
+
+		skipValidate = true
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		elect, err := NewLeaseElection("a", "election", client, 1500*time.Millisecond,
+			WithDebug(debugger),
+			OnWon(func() {
+				mu.Lock()
+				won++
+				mu.Unlock()
+			}),
+			OnLost(func() {
+				mu.Lock()
+				lost++
+				mu.Unlock()
+			}))
+		Expect(err).ToNot(HaveOccurred())
+
+		go elect.Start(ctx)
+
+		status := func() (int, int) {
+			mu.Lock()
+			defer mu.Unlock()
+			return won, lost
+		}
+
+		Eventually(func() int { w, _ := status(); return w }, "2s").Should(Equal(1))
+
+		// stands down before the lease expires for other candidates
+		api.SetFailing(true)
+		Eventually(func() int { _, l := status(); return l }, "2s").Should(Equal(1))
+
+		api.SetFailing(false)
+		Eventually(func() int { w, _ := status(); return w }, "2s").Should(Equal(2))
+	})
+})
@@ -0,0 +1,107 @@
+// Copyright (c) 2023, R.I. Pienaar and the Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package election
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// WithLiveness has the leader publish the progress reported by progress to the bucket, candidates force a new election
+// when the leader keeps holding the key while its progress did not change for stale. Candidates observe progress
+// records using their own clock so the clocks of candidates do not have to be in sync
+func WithLiveness(progress func() time.Time, stale time.Duration) Option {
+	return func(o *options) {
+		o.progress = progress
+		o.stale = stale
+	}
+}
+
+// liveness is the progress published by a leader and observed by a candidate
+type liveness struct {
+	published   string
+	publishedAt time.Time
+	observed    string
+	observedRev uint64
+	observedAt  time.Time
+}
+
+// progressKey is where the leader publishes its progress, it expires with the bucket TTL
+func (e *election) progressKey() string {
+	return fmt.Sprintf("%s_progress", e.opts.key)
+}
+
+// publishProgress records the progress of the leader when it changed, unchanged records are refreshed well within the
+// bucket TTL so they do not expire while leading
+func (e *election) publishProgress() {
+	if e.opts.progress == nil {
+		return
+	}
+
+	record := fmt.Sprintf("%d %s", e.opts.progress().UnixMilli(), e.opts.name)
+	if record == e.liveness.published && e.opts.clock.Since(e.liveness.publishedAt) < e.opts.ttl/2 {
+		return
+	}
+
+	_, err := e.opts.bucket.Put(e.progressKey(), []byte(record))
+	if err != nil {
+		e.debugf("publishing progress failed: %v", err)
+		return
+	}
+
+	e.liveness.published = record
+	e.liveness.publishedAt = e.opts.clock.Now()
+}
+
+// leaderStalled determines if the progress record of the leader did not change for the stale duration, leaders not
+// publishing progress are never considered stalled
+func (e *election) leaderStalled() bool {
+	if e.opts.progress == nil {
+		return false
+	}
+
+	entry, err := e.opts.bucket.Get(e.progressKey())
+	if err != nil {
+		e.liveness.observed = ""
+		return false
+	}
+
+	record := string(entry.Value())
+	if record != e.liveness.observed {
+		e.liveness.observed = record
+		e.liveness.observedRev = entry.Revision()
+		e.liveness.observedAt = e.opts.clock.Now()
+		return false
+	}
+
+	return e.opts.clock.Since(e.liveness.observedAt) >= e.opts.stale
+}
+
+// forceElection removes the key held by a stalled leader so candidates can campaign for it, should another candidate
+// have taken over meanwhile the key is left alone
+func (e *election) forceElection() bool {
+	_, leader, _ := strings.Cut(e.liveness.observed, " ")
+	e.liveness.observed = ""
+
+	entry, err := e.opts.bucket.Get(e.opts.key)
+	if err != nil || string(entry.Value()) != leader {
+		return false
+	}
+
+	err = e.opts.bucket.Delete(e.opts.key, nats.LastRevision(entry.Revision()))
+	if err != nil {
+		e.debugf("removing the key of stalled leader %s failed: %v", leader, err)
+		return false
+	}
+	e.opts.bucket.Delete(e.progressKey(), nats.LastRevision(e.liveness.observedRev))
+
+	staleLeadersCounter.WithLabelValues(e.opts.key, e.opts.name, e.opts.replicator).Inc()
+	e.debugf("leader %s made no progress for %v, forcing a new election", leader, e.opts.stale)
+
+	return true
+}
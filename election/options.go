@@ -7,6 +7,7 @@ package election
 import (
 	"time"
 
+	"github.com/choria-io/stream-replicator/clock"
 	"github.com/nats-io/nats.go"
 )
 
@@ -25,6 +26,13 @@ type options struct {
 	campaignCb func(s State)
 	bo         Backoff
 	debug      func(format string, a ...any)
+	warn       func(format string, a ...any)
+	clock      clock.Clock
+	pinned     bool
+	preferred  bool
+	balancer   *Balancer
+	progress   func() time.Time
+	stale      time.Duration
 }
 
 // WithBackoff will use the provided Backoff timer source to decrease campaign intervals over time
@@ -32,6 +40,20 @@ func WithBackoff(bo Backoff) Option {
 	return func(o *options) { o.bo = bo }
 }
 
+// WithClock sets the clock used to schedule campaigns, defaults to real time
+func WithClock(c clock.Clock) Option {
+	return func(o *options) { o.clock = c }
+}
+
+// WithLeaderPreference pins leadership to preferred candidates, others only lead while no preferred candidate is
+// campaigning, all candidates for the key have to set it
+func WithLeaderPreference(preferred bool) Option {
+	return func(o *options) {
+		o.pinned = true
+		o.preferred = preferred
+	}
+}
+
 // OnWon is a callback called when winning an election
 func OnWon(cb func()) Option {
 	return func(o *options) { o.wonCb = cb }
@@ -52,6 +74,11 @@ func WithDebug(cb func(format string, a ...any)) Option {
 	return func(o *options) { o.debug = cb }
 }
 
+// WithWarnings sets a function to log warnings with, like changes to the bucket the election adapted to
+func WithWarnings(cb func(format string, a ...any)) Option {
+	return func(o *options) { o.warn = cb }
+}
+
 // WithReplicator sets the replicator name for monitoring
 func WithReplicator(r string) Option {
 	return func(o *options) { o.replicator = r }
@@ -25,10 +25,16 @@ var (
 		Name: prometheus.BuildFQName(prometheusNamespace, "election", "interval_seconds"),
 		Help: "The number of seconds between campaigns",
 	}, []string{"election", "identity", "replicator"})
+
+	staleLeadersCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName(prometheusNamespace, "election", "stale_leaders"),
+		Help: "The number of times a candidate forced a new election as the leader made no progress",
+	}, []string{"election", "identity", "replicator"})
 )
 
 func init() {
 	prometheus.MustRegister(campaignsCounter)
 	prometheus.MustRegister(leaderGauge)
 	prometheus.MustRegister(campaignIntervalGauge)
+	prometheus.MustRegister(staleLeadersCounter)
 }
@@ -0,0 +1,80 @@
+// Copyright (c) 2023, R.I. Pienaar and the Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package election
+
+import (
+	"fmt"
+	"time"
+)
+
+// ttlCheckInterval is how often elections check if the TTL of their bucket changed
+var ttlCheckInterval = time.Minute
+
+// validateTTL checks that ttl is supported and results in a usable campaign interval
+func validateTTL(ttl time.Duration, interval time.Duration) error {
+	if ttl < time.Second {
+		return fmt.Errorf("bucket TTL should be 1 second or more")
+	}
+
+	if ttl > time.Hour {
+		return fmt.Errorf("bucket TTL should be less than or equal to 1 hour")
+	}
+
+	if interval.Seconds() < 1 {
+		return fmt.Errorf("campaign interval %v too small", interval)
+	}
+
+	return nil
+}
+
+// checkTTL adapts the campaign interval when the TTL of the bucket changed, the current interval is kept when the new
+// TTL is not supported. It returns true when the campaign interval changed
+func (e *election) checkTTL() bool {
+	if e.opts.clock.Since(e.ttlChecked) < ttlCheckInterval {
+		return false
+	}
+	e.ttlChecked = e.opts.clock.Now()
+
+	status, err := e.opts.bucket.Status()
+	if err != nil {
+		e.debugf("Obtaining bucket stats failed: %v", err)
+		return false
+	}
+
+	ttl := status.TTL()
+	if ttl == e.opts.ttl {
+		return false
+	}
+
+	interval := time.Duration(float64(ttl) * 0.75)
+	switch {
+	case interval <= 0:
+		err = fmt.Errorf("bucket TTL should be 1 second or more")
+	case !skipValidate:
+		err = validateTTL(ttl, interval)
+	}
+	if err != nil {
+		// warned about once rather than every check
+		if !e.ttlWarned || ttl != e.invalidTTL {
+			e.warnf("Election bucket TTL changed from %v to %v, keeping the campaign interval of %v: %v", e.opts.ttl, ttl, e.opts.cInterval, err)
+			e.invalidTTL = ttl
+			e.ttlWarned = true
+		}
+
+		return false
+	}
+
+	e.warnf("Election bucket TTL changed from %v to %v, campaigning every %v", e.opts.ttl, ttl, interval)
+
+	e.opts.ttl = ttl
+	e.opts.cInterval = interval
+	e.ttlWarned = false
+
+	if e.opts.balancer != nil {
+		e.opts.balancer.register(e.opts.key, ttl/3)
+	}
+
+	return true
+}
@@ -0,0 +1,200 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package events publishes operational events about the replicator, like
+// credential rotations, to a NATS subject for consumption by monitoring systems
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/util"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/jsm.go/api"
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/ksuid"
+	"github.com/sirupsen/logrus"
+)
+
+// EventType is the kind of event being published
+type EventType string
+
+var (
+	CredentialsReloadedEvent  EventType = "credentials_reloaded"
+	CredentialsInvalidEvent   EventType = "credentials_invalid"
+	CertificateReloadedEvent  EventType = "certificate_reloaded"
+	CertificateInvalidEvent   EventType = "certificate_invalid"
+	AdminActionEvent          EventType = "admin_action"
+	MaintenanceStartedEvent   EventType = "maintenance_started"
+	MaintenanceEndedEvent     EventType = "maintenance_ended"
+	FailoverEvent             EventType = "failover"
+	MonitorGapEvent           EventType = "monitor_gap"
+	MonitorDriftEvent         EventType = "monitor_drift"
+	OrderingViolationEvent    EventType = "ordering_violation"
+	TargetQuotaThrottledEvent EventType = "target_quota_throttled"
+	TargetQuotaPausedEvent    EventType = "target_quota_paused"
+	TargetQuotaAvailableEvent EventType = "target_quota_available"
+	QuiescedEvent             EventType = "quiesced"
+	QuiesceResumedEvent       EventType = "quiesce_resumed"
+	SourceRecreatedEvent      EventType = "source_recreated"
+	ConsumerRecreatedEvent    EventType = "consumer_recreated"
+	StoragePressureEvent      EventType = "storage_pressure"
+	StorageAvailableEvent     EventType = "storage_available"
+	StartupReconciledEvent    EventType = "startup_reconciliation"
+	ConfigDriftEvent          EventType = "config_drift"
+	DriftCorrectedEvent       EventType = "drift_corrected"
+	EventProtocol                       = "io.choria.sr.v1.event"
+)
+
+// Event is an operational event published by the replicator
+type Event struct {
+	Protocol   string            `json:"protocol"`
+	EventID    string            `json:"event_id"`
+	Event      EventType         `json:"event"`
+	Replicator string            `json:"replicator"`
+	Stream     string            `json:"stream,omitempty"`
+	Name       string            `json:"name,omitempty"`
+	Timestamp  int64             `json:"timestamp"`
+	Message    string            `json:"message"`
+	Data       map[string]string `json:"data,omitempty"`
+}
+
+// Publisher publishes events in the background
+type Publisher struct {
+	cfg        *config.Events
+	replicator string
+	nc         *nats.Conn
+	out        chan *Event
+	log        *logrus.Entry
+}
+
+// New creates a new event publisher, events are queued until Run is called
+func New(cfg *config.Events, replicator string, log *logrus.Entry) (*Publisher, error) {
+	return &Publisher{
+		cfg:        cfg,
+		replicator: replicator,
+		out:        make(chan *Event, 1000),
+		log:        log.WithFields(logrus.Fields{"events": cfg.Subject, "reliable": cfg.Reliable}),
+	}, nil
+}
+
+// Run connects to NATS and publishes events until ctx is canceled
+func (p *Publisher) Run(ctx context.Context, wg *sync.WaitGroup) error {
+	var err error
+
+	p.nc, err = util.ConnectNats(ctx, "events", p.cfg.URL, &p.cfg.TLS, &p.cfg.Choria, false, p.cfg.Process, p.log.WithField("connection", "events"), append(p.cfg.Reconnect.ConnectOptions(), util.WithProxy(p.cfg.Proxy))...)
+	if err != nil {
+		return err
+	}
+
+	wg.Add(1)
+	go p.publisher(ctx, wg)
+
+	p.log.Infof("Events publishing to %s", p.cfg.Subject)
+
+	return nil
+}
+
+// Publish enqueues an event for publishing, it is safe to call on a nil Publisher
+func (p *Publisher) Publish(event *Event) {
+	if p == nil {
+		return
+	}
+
+	p.prepare(event)
+
+	select {
+	case p.out <- event:
+		eventsCount.WithLabelValues(string(event.Event), p.replicator).Inc()
+	default:
+		p.log.Warnf("Could not enqueue %v event, channel has %d entries", event.Event, len(p.out))
+	}
+}
+
+// prepare sets the protocol, id, replicator and timestamp of event unless already set
+func (p *Publisher) prepare(event *Event) {
+	event.Protocol = EventProtocol
+
+	if event.EventID == "" {
+		id, _ := ksuid.NewRandom()
+		event.EventID = id.String()
+	}
+	if event.Replicator == "" {
+		event.Replicator = p.replicator
+	}
+	if event.Timestamp == 0 {
+		event.Timestamp = time.Now().Unix()
+	}
+}
+
+func (p *Publisher) publish(ctx context.Context, event *Event) error {
+	subject := strings.ReplaceAll(p.cfg.Subject, "%s", string(event.Event))
+
+	d, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	tries := 1
+	if p.cfg.Reliable {
+		tries = 10
+	}
+
+	return p.cfg.Backoff.RetryPolicy().For(ctx, func(try int) error {
+		if try > tries {
+			p.log.Warnf("Giving up on event after %d tries", try-1)
+			return nil
+		}
+
+		if !p.cfg.Reliable {
+			return p.nc.Publish(subject, d)
+		}
+
+		msg := nats.NewMsg(subject)
+		msg.Data = d
+		msg.Header.Add(api.JSMsgId, event.EventID)
+
+		timeout, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+
+		res, err := p.nc.RequestMsgWithContext(timeout, msg)
+		if err != nil {
+			eventsPublishErrors.WithLabelValues(string(event.Event), p.replicator).Inc()
+			return err
+		}
+
+		ack, err := jsm.ParsePubAck(res)
+		if err != nil {
+			eventsPublishErrors.WithLabelValues(string(event.Event), p.replicator).Inc()
+			return err
+		}
+
+		p.log.Debugf("Published %s event to %s with sequence %d", event.Event, ack.Stream, ack.Sequence)
+
+		return nil
+	})
+}
+
+func (p *Publisher) publisher(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for {
+		select {
+		case event := <-p.out:
+			err := p.publish(ctx, event)
+			if err != nil {
+				p.log.Errorf("Could not publish event: %v", err)
+			}
+
+		case <-ctx.Done():
+			p.log.Warnf("Events publisher shutting down on context interrupt")
+			return
+		}
+	}
+}
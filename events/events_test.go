@@ -0,0 +1,103 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+func TestEvents(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Events")
+}
+
+var _ = Describe("Events", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	Describe("Publish", func() {
+		It("Should be safe on a nil publisher", func() {
+			var p *Publisher
+			p.Publish(&Event{Event: CredentialsReloadedEvent})
+		})
+
+		It("Should publish events", func() {
+			testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, _ *jsm.Manager) {
+				sub, err := nc.SubscribeSync("events.>")
+				Expect(err).ToNot(HaveOccurred())
+
+				p, err := New(&config.Events{Subject: "events.%s", URL: nc.ConnectedUrl()}, "GINKGO", log)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(p.Run(ctx, &wg)).To(Succeed())
+
+				p.Publish(&Event{Event: CredentialsReloadedEvent, Stream: "ORDERS", Name: "orders", Message: "reloaded", Data: map[string]string{"file": "/tmp/x.creds"}})
+
+				msg, err := sub.NextMsg(5 * time.Second)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(msg.Subject).To(Equal("events.credentials_reloaded"))
+
+				event := &Event{}
+				Expect(json.Unmarshal(msg.Data, event)).To(Succeed())
+				Expect(event.Protocol).To(Equal(EventProtocol))
+				Expect(event.EventID).To(HaveLen(27))
+				Expect(event.Replicator).To(Equal("GINKGO"))
+				Expect(event.Stream).To(Equal("ORDERS"))
+				Expect(event.Name).To(Equal("orders"))
+				Expect(event.Timestamp).To(BeNumerically("~", time.Now().Unix(), 1))
+				Expect(event.Data).To(Equal(map[string]string{"file": "/tmp/x.creds"}))
+			})
+		})
+
+		It("Should publish reliably to JetStream", func() {
+			testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+				stream, err := mgr.NewStream("EVENTS", jsm.Subjects("events.>"))
+				Expect(err).ToNot(HaveOccurred())
+
+				p, err := New(&config.Events{Subject: "events.%s", URL: nc.ConnectedUrl(), Reliable: true}, "GINKGO", log)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(p.Run(ctx, &wg)).To(Succeed())
+
+				p.Publish(&Event{Event: CertificateInvalidEvent})
+
+				Eventually(func() uint64 {
+					nfo, err := stream.State()
+					if err != nil {
+						return 0
+					}
+					return nfo.Msgs
+				}).Should(Equal(uint64(1)))
+			})
+		})
+	})
+})
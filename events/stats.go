@@ -0,0 +1,26 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package events
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	eventsCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "events", "total_messages"),
+		Help: "The total number of events sent",
+	}, []string{"event", "replicator"})
+
+	eventsPublishErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "events", "publish_errors"),
+		Help: "The number of times publishing events failed",
+	}, []string{"event", "replicator"})
+)
+
+func init() {
+	prometheus.MustRegister(eventsCount)
+	prometheus.MustRegister(eventsPublishErrors)
+}
@@ -16,6 +16,7 @@ import (
 	"time"
 
 	"github.com/choria-io/stream-replicator/backoff"
+	"github.com/choria-io/stream-replicator/clock"
 	"github.com/choria-io/stream-replicator/config"
 	"github.com/choria-io/stream-replicator/election"
 	"github.com/choria-io/stream-replicator/internal/util"
@@ -48,17 +49,33 @@ type HeartBeat struct {
 	log            *logrus.Entry
 	paused         atomic.Bool
 	inproc         nats.InProcessConnProvider
+	proxy          string
+	contextOpts    []util.ConnectOption
+	reconnect      *config.Reconnect
 	hostname       string
+	bucket         string
+	elections      backoff.Policy
+	retries        *backoff.Policy
+	clock          clock.Clock
+}
+
+// Option configures optional heartbeat behavior
+type Option func(hb *HeartBeat)
+
+// WithClock sets the clock used to schedule and timestamp heartbeats and leader elections, defaults to real time
+func WithClock(c clock.Clock) Option {
+	return func(hb *HeartBeat) { hb.clock = c }
 }
 
 type Subject struct {
-	name     string
-	interval time.Duration
-	headers  map[string]string
+	name       string
+	interval   time.Duration
+	headers    map[string]string
+	leaderOnly bool
 }
 
 // New creates a new instance of the Heartbeat struct
-func New(hbcfg *config.HeartBeat, replicatorName string, log *logrus.Entry) (*HeartBeat, error) {
+func New(hbcfg *config.HeartBeat, replicatorName string, log *logrus.Entry, opts ...Option) (*HeartBeat, error) {
 	var err error
 	hb := &HeartBeat{
 		replicatorName: replicatorName,
@@ -68,8 +85,22 @@ func New(hbcfg *config.HeartBeat, replicatorName string, log *logrus.Entry) (*He
 		leaderElection: hbcfg.LeaderElection,
 		headers:        hbcfg.Headers,
 		inproc:         hbcfg.Process,
+		proxy:          hbcfg.Proxy,
+		contextOpts:    hbcfg.ContextOptions(),
+		reconnect:      hbcfg.Reconnect,
 		log:            log,
-		url:            hbcfg.URL,
+		url:            hbcfg.Servers(),
+		bucket:         hbcfg.Bucket,
+		elections:      hbcfg.Backoff.ElectionPolicy(),
+		clock:          clock.New(),
+	}
+
+	if policy, ok := hbcfg.Backoff.HeartbeatPolicy(); ok {
+		hb.retries = &policy
+	}
+
+	for _, opt := range opts {
+		opt(hb)
 	}
 
 	if hb.headers == nil {
@@ -93,7 +124,10 @@ func New(hbcfg *config.HeartBeat, replicatorName string, log *logrus.Entry) (*He
 
 	for _, s := range hbcfg.Subjects {
 		var err error
-		sub := &Subject{name: s.Name}
+		sub := &Subject{name: s.Name, leaderOnly: hb.leaderElection}
+		if s.LeaderElection != nil && !*s.LeaderElection {
+			sub.leaderOnly = false
+		}
 		if s.Interval == "" {
 			s.Interval = hb.interval
 		}
@@ -123,7 +157,8 @@ func New(hbcfg *config.HeartBeat, replicatorName string, log *logrus.Entry) (*He
 // Run initializes a the jetstream connection and spawns a go routine for every configured subject
 // that will publish a heartbeat message on the defined interval
 func (hb *HeartBeat) Run(ctx context.Context, wg *sync.WaitGroup) error {
-	nc, err := util.ConnectNats(ctx, "subject-heartbeats", hb.url, &hb.tls, &hb.choria, false, hb.inproc, hb.log)
+	opts := append(append([]util.ConnectOption{}, hb.contextOpts...), hb.reconnect.ConnectOptions()...)
+	nc, err := util.ConnectNats(ctx, "subject-heartbeats", hb.url, &hb.tls, &hb.choria, false, hb.inproc, hb.log, append(opts, util.WithProxy(hb.proxy))...)
 	if err != nil {
 		return err
 	}
@@ -141,16 +176,30 @@ func (hb *HeartBeat) Run(ctx context.Context, wg *sync.WaitGroup) error {
 		return fmt.Errorf("unable to create jetstream context: %v", err)
 	}
 
+	var seen nats.KeyValue
+	if hb.bucket != "" {
+		seen, err = Bucket(js, hb.bucket)
+		if err != nil {
+			return fmt.Errorf("could not access heartbeat bucket %s: %v", hb.bucket, err)
+		}
+	}
+
 	for _, subject := range hb.subjects {
+		// subjects opting out of leader election are never paused
+		var paused *atomic.Bool
+		if subject.leaderOnly {
+			paused = &hb.paused
+		}
+
 		wg.Add(1)
 		hbSubjects.WithLabelValues(hb.replicatorName).Inc()
-		go heartBeatWorker(ctx, wg, subject, nc, js, hb.replicatorName, hb.hostname, &hb.paused, hb.log.WithField("subject", subject.name))
+		go heartBeatWorker(ctx, wg, subject, nc, js, seen, hb.retries, hb.replicatorName, hb.hostname, paused, hb.clock, hb.log.WithField("subject", subject.name))
 	}
 
 	return nil
 }
 
-func heartBeatWorker(ctx context.Context, wg *sync.WaitGroup, sub *Subject, nc *nats.Conn, js nats.JetStreamContext, replicatorName, hostname string, paused *atomic.Bool, log *logrus.Entry) {
+func heartBeatWorker(ctx context.Context, wg *sync.WaitGroup, sub *Subject, nc *nats.Conn, js nats.JetStreamContext, seen nats.KeyValue, retries *backoff.Policy, replicatorName, hostname string, paused *atomic.Bool, clk clock.Clock, log *logrus.Entry) {
 	defer wg.Done()
 
 	log.Infof("Starting heartbeat with interval: %v", sub.interval)
@@ -163,20 +212,29 @@ func heartBeatWorker(ctx context.Context, wg *sync.WaitGroup, sub *Subject, nc *
 	msg.Header.Add(OriginatorHeader, hostname)
 	msg.Header.Add(SubjectHeader, sub.name)
 
-	ticker := time.NewTicker(sub.interval)
+	ticker := clk.NewTicker(sub.interval)
 	if enableBackoff {
+		// the first heartbeat is splayed by the configured heartbeat backoff
+		splay := backoff.FiveSec
+		if retries != nil {
+			splay = *retries
+		}
 		ticker.Reset(1 * time.Hour)
-		time.AfterFunc(backoff.FiveSec.Duration(10), func() { ticker.Reset(sub.interval) })
+		clk.AfterFunc(splay.Duration(10), func() { ticker.Reset(sub.interval) })
 	}
 
+	// failures counts consecutive failed heartbeats, with retries configured they are sent again sooner than the interval
+	failures := 0
+
 	for {
 		select {
-		case <-ticker.C:
-			if paused.Load() {
+		case <-ticker.C():
+			if paused != nil && paused.Load() {
 				log.Debug("Not sending heartbeat when paused")
 				continue
 			}
-			msg.Data = []byte(strconv.Itoa(int(time.Now().Unix())))
+			now := clk.Now()
+			msg.Data = []byte(strconv.Itoa(int(now.Unix())))
 
 			timer := hbPublishTime.WithLabelValues(replicatorName, sub.name)
 			obs := prometheus.NewTimer(timer)
@@ -188,6 +246,34 @@ func heartBeatWorker(ctx context.Context, wg *sync.WaitGroup, sub *Subject, nc *
 			if err != nil {
 				hbPublishedCtrErr.WithLabelValues(replicatorName, sub.name).Inc()
 				log.Errorf("Unable to publish message to subject: %v", err)
+
+				failures++
+				if retries != nil {
+					delay := retries.Duration(failures - 1)
+					if delay >= sub.interval {
+						delay = sub.interval
+					}
+					ticker.Reset(delay)
+				}
+			} else {
+				if failures > 0 && retries != nil {
+					ticker.Reset(sub.interval)
+
+					// retries missed while publishing are not needed anymore
+					select {
+					case <-ticker.C():
+					default:
+					}
+				}
+				failures = 0
+
+				if seen != nil {
+					err = putLastSeen(seen, &LastSeen{Originator: hostname, Replicator: replicatorName, Subject: sub.name, Time: now.UTC()})
+					if err != nil {
+						hbLastSeenCtrErr.WithLabelValues(replicatorName, sub.name).Inc()
+						log.Errorf("Unable to record the last heartbeat: %v", err)
+					}
+				}
 			}
 
 			hbPublishedCtr.WithLabelValues(replicatorName, sub.name).Inc()
@@ -221,7 +307,7 @@ func (hb *HeartBeat) setupElection(ctx context.Context, nc *nats.Conn) error {
 		hbPaused.WithLabelValues(hb.replicatorName, hb.hostname).Set(1.0)
 	}
 
-	e, err := election.NewElection(hb.hostname, hb.electionName, kv, election.WithBackoff(backoff.FiveSec), election.OnWon(win), election.OnLost(lost))
+	e, err := election.NewElection(hb.hostname, hb.electionName, kv, election.WithBackoff(hb.elections), election.WithWarnings(hb.log.Warnf), election.WithClock(hb.clock), election.OnWon(win), election.OnLost(lost))
 	if err != nil {
 		return err
 	}
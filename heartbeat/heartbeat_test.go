@@ -11,6 +11,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/choria-io/stream-replicator/clock"
 	"github.com/choria-io/stream-replicator/config"
 	"github.com/choria-io/stream-replicator/election"
 	"github.com/choria-io/stream-replicator/internal/testutil"
@@ -197,6 +198,114 @@ var _ = Describe("Subject Heartbeat", func() {
 			})
 		})
 
+		It("should send heart beats as the clock moves", func() {
+			testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+				jstream, err := mgr.NewStream("TEST", jsm.Subjects("heartbeat"))
+				Expect(err).ToNot(HaveOccurred())
+				hbConfig.URL = nc.ConnectedUrl()
+
+				mock := clock.NewMock(time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC))
+				hb, err := New(&hbConfig, "test_replicator", log, WithClock(mock))
+				Expect(err).ToNot(HaveOccurred())
+
+				go func() {
+					defer GinkgoRecover()
+					err = hb.Run(ctx, &wg)
+					Expect(err).ToNot(HaveOccurred())
+				}()
+				defer cancel()
+
+				Eventually(mock.Timers).Should(Equal(1))
+				Consistently(streamMesssage(jstream), "200ms").Should(Equal(uint64(0)))
+
+				for i := 1; i <= 3; i++ {
+					mock.Add(500 * time.Millisecond)
+					Eventually(streamMesssage(jstream)).Should(Equal(uint64(i)))
+				}
+
+				msg, err := jstream.ReadLastMessageForSubject("heartbeat")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(msg.Data)).To(Equal(strconv.Itoa(int(mock.Now().Unix()))))
+			})
+		})
+
+		It("should retry failed heart beats before the next interval", func() {
+			testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+				hbConfig.URL = nc.ConnectedUrl()
+				hbConfig.Subjects[0].Interval = "10s"
+				hbConfig.Backoff = &config.Backoff{Heartbeats: &config.BackoffPolicy{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 2}}
+
+				mock := clock.NewMock(time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC))
+				hb, err := New(&hbConfig, "retry_replicator", log, WithClock(mock))
+				Expect(err).ToNot(HaveOccurred())
+
+				go func() {
+					defer GinkgoRecover()
+					Expect(hb.Run(ctx, &wg)).ToNot(HaveOccurred())
+				}()
+				defer cancel()
+
+				// without a stream the heartbeat fails
+				Eventually(mock.Timers).Should(Equal(1))
+				mock.Add(10 * time.Second)
+				Eventually(func() float64 { return getPromCountValue(hbPublishedCtrErr, "retry_replicator", "heartbeat") }).Should(Equal(1.0))
+
+				jstream, err := mgr.NewStream("TEST", jsm.Subjects("heartbeat"))
+				Expect(err).ToNot(HaveOccurred())
+
+				// far less than the interval passes while retrying
+				Eventually(func() (uint64, error) {
+					mock.Add(10 * time.Millisecond)
+					return streamMesssage(jstream)()
+				}, "2s").Should(Equal(uint64(1)))
+
+				// successful heartbeats are sent at the interval again
+				Eventually(func() float64 { return getPromCountValue(hbPublishedCtr, "retry_replicator", "heartbeat") }).Should(Equal(2.0))
+				mock.Add(5 * time.Second)
+				Consistently(streamMesssage(jstream), "200ms").Should(Equal(uint64(1)))
+				mock.Add(5 * time.Second)
+				Eventually(streamMesssage(jstream)).Should(Equal(uint64(2)))
+			})
+		})
+
+		It("should record the last heartbeat of the originator in the bucket", func() {
+			testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+				_, err := mgr.NewStream("TEST", jsm.Subjects("heartbeat"))
+				Expect(err).ToNot(HaveOccurred())
+				hbConfig.URL = nc.ConnectedUrl()
+				hbConfig.Bucket = "HEARTBEATS"
+
+				mock := clock.NewMock(time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC))
+				stubHostname = "dc1.example.net"
+				hb, err := New(&hbConfig, "test_replicator", log, WithClock(mock))
+				Expect(err).ToNot(HaveOccurred())
+
+				go func() {
+					defer GinkgoRecover()
+					Expect(hb.Run(ctx, &wg)).ToNot(HaveOccurred())
+				}()
+				defer cancel()
+
+				js, err := nc.JetStream()
+				Expect(err).ToNot(HaveOccurred())
+
+				var kv nats.KeyValue
+				Eventually(func() (kverr error) {
+					kv, kverr = js.KeyValue("HEARTBEATS")
+					return kverr
+				}).Should(Succeed())
+
+				Eventually(mock.Timers).Should(Equal(1))
+				mock.Add(500 * time.Millisecond)
+
+				Eventually(func() ([]*LastSeen, error) { return LoadLastSeen(kv) }).Should(HaveLen(1))
+
+				entry, err := kv.Get("dc1_example_net")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(entry.Value()).To(MatchJSON(`{"originator":"dc1.example.net","replicator":"test_replicator","subject":"heartbeat","time":"2023-07-01T12:00:00.5Z"}`))
+			})
+		})
+
 		It("should perform leader election and set metrics", func() {
 			testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
 				hbConfig.LeaderElection = true
@@ -246,6 +355,58 @@ var _ = Describe("Subject Heartbeat", func() {
 				Expect(getPromCountValue(hbPublishedCtrErr, "leader_election_replicator", "heartbeat")).To(Equal(0.0))
 			})
 		})
+
+		It("should send heart beats from every replicator for subjects opting out of leader election", func() {
+			testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+				_, err := mgr.NewStream("TEST", jsm.Subjects("heartbeat", "alive"))
+				Expect(err).ToNot(HaveOccurred())
+
+				js, err := nc.JetStream()
+				Expect(err).ToNot(HaveOccurred())
+				_, err = js.CreateKeyValue(&nats.KeyValueConfig{
+					Bucket: "CHORIA_LEADER_ELECTION",
+					TTL:    750 * time.Millisecond,
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				var mu sync.Mutex
+				seen := map[string]map[string]bool{"heartbeat": {}, "alive": {}}
+				_, err = nc.Subscribe("*", func(msg *nats.Msg) {
+					mu.Lock()
+					seen[msg.Subject][msg.Header.Get(OriginatorHeader)] = true
+					mu.Unlock()
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				leader := false
+				hbConfig.LeaderElection = true
+				hbConfig.URL = nc.ConnectedUrl()
+				hbConfig.Subjects = append(hbConfig.Subjects, config.Subject{Name: "alive", Interval: "200ms", LeaderElection: &leader})
+
+				stubHostname = "host1"
+				hb1, err := New(&hbConfig, "always_replicator", log)
+				Expect(err).ToNot(HaveOccurred())
+
+				stubHostname = "host2"
+				hb2, err := New(&hbConfig, "always_replicator", log)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(hb1.Run(ctx, &wg)).To(Succeed())
+				Expect(hb2.Run(ctx, &wg)).To(Succeed())
+
+				originators := func(subject string) func() int {
+					return func() int {
+						mu.Lock()
+						defer mu.Unlock()
+						return len(seen[subject])
+					}
+				}
+
+				Eventually(originators("alive"), "5s").Should(Equal(2))
+				Eventually(originators("heartbeat"), "10s").Should(Equal(1))
+				Consistently(originators("heartbeat"), "1s").Should(Equal(1))
+			})
+		})
 	})
 })
 
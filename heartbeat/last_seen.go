@@ -0,0 +1,95 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package heartbeat
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// DefaultBucket is the Key-Value bucket the admin heartbeats command reads when not configured
+const DefaultBucket = "CHORIA_SR_HEARTBEATS"
+
+var invalidKeyChars = regexp.MustCompile(`[^-_=a-zA-Z0-9]`)
+
+// LastSeen is the last heartbeat of an originator stored in the heartbeat bucket
+type LastSeen struct {
+	// Originator is the hostname that published the heartbeat, as in the Choria-SR-Originator header
+	Originator string `json:"originator"`
+	// Replicator is the name of the replicator that published the heartbeat
+	Replicator string `json:"replicator"`
+	// Subject is the subject the heartbeat was published to
+	Subject string `json:"subject"`
+	// Time is when the heartbeat was published
+	Time time.Time `json:"time"`
+}
+
+// Key is the key the heartbeat is stored under, the originator with characters not valid in keys replaced by _
+func (l *LastSeen) Key() string {
+	return invalidKeyChars.ReplaceAllString(l.Originator, "_")
+}
+
+// Bucket binds to the heartbeat bucket creating it when it does not exist
+func Bucket(js nats.JetStreamContext, bucket string) (nats.KeyValue, error) {
+	kv, err := js.KeyValue(bucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{
+			Bucket:      bucket,
+			Description: "Choria Stream Replicator heartbeats",
+		})
+	}
+
+	return kv, err
+}
+
+func putLastSeen(kv nats.KeyValue, seen *LastSeen) error {
+	j, err := json.Marshal(seen)
+	if err != nil {
+		return err
+	}
+
+	_, err = kv.Put(seen.Key(), j)
+
+	return err
+}
+
+// LoadLastSeen loads the last heartbeat of every originator from the bucket sorted by originator
+func LoadLastSeen(kv nats.KeyValue) ([]*LastSeen, error) {
+	keys, err := kv.Keys()
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var seen []*LastSeen
+	for _, key := range keys {
+		entry, err := kv.Get(key)
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		s := &LastSeen{}
+		err = json.Unmarshal(entry.Value(), s)
+		if err != nil {
+			return nil, err
+		}
+
+		seen = append(seen, s)
+	}
+
+	sort.Slice(seen, func(i, j int) bool { return seen[i].Originator < seen[j].Originator })
+
+	return seen, nil
+}
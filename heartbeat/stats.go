@@ -26,6 +26,10 @@ var (
 		Name: prometheus.BuildFQName("choria_stream_replicator", "heartbeat", "publish_time"),
 		Help: "Time taken to publish a message",
 	}, []string{"replicator", "subject"})
+	hbLastSeenCtrErr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "heartbeat", "last_seen_error_count"),
+		Help: "Number of failures to write the last heartbeat to the bucket",
+	}, []string{"replicator", "subject"})
 	hbPaused = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: prometheus.BuildFQName("choria_stream_replicator", "heartbeat", "paused"),
 		Help: "Paused under leader election",
@@ -37,5 +41,6 @@ func init() {
 	prometheus.MustRegister(hbPublishedCtr)
 	prometheus.MustRegister(hbPublishedCtrErr)
 	prometheus.MustRegister(hbPublishTime)
+	prometheus.MustRegister(hbLastSeenCtrErr)
 	prometheus.MustRegister(hbPaused)
 }
@@ -0,0 +1,87 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package idtrack
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+)
+
+// encryptedStateMagic prefixes state files encrypted using AES-256-GCM
+var encryptedStateMagic = []byte("SRENC1")
+
+// IsEncryptedState determines if data is an encrypted state file
+func IsEncryptedState(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedStateMagic)
+}
+
+// ReadState reads a state file decrypting it using key when encrypted, unencrypted files are returned as is
+func ReadState(file string, key []byte) ([]byte, error) {
+	d, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if !IsEncryptedState(d) {
+		return d, nil
+	}
+
+	if len(key) == 0 {
+		return nil, fmt.Errorf("state file %s is encrypted but no key is configured", file)
+	}
+
+	return decryptState(key, d)
+}
+
+func stateCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func encryptState(key []byte, data []byte) ([]byte, error) {
+	gcm, err := stateCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	out := append([]byte{}, encryptedStateMagic...)
+	out = append(out, nonce...)
+
+	return gcm.Seal(out, nonce, data, encryptedStateMagic), nil
+}
+
+func decryptState(key []byte, data []byte) ([]byte, error) {
+	gcm, err := stateCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data = bytes.TrimPrefix(data, encryptedStateMagic)
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted state is truncated")
+	}
+
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], encryptedStateMagic)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt state, invalid key or corrupt file")
+	}
+
+	return plain, nil
+}
@@ -16,6 +16,7 @@ import (
 	"time"
 
 	"github.com/choria-io/stream-replicator/backoff"
+	"github.com/choria-io/stream-replicator/clock"
 	"github.com/nats-io/nats.go"
 	"github.com/sirupsen/logrus"
 )
@@ -34,6 +35,7 @@ type Tracker struct {
 	warnAge     time.Duration
 	sizeTrigger float64
 	stateFile   string
+	stateKey    []byte
 	log         *logrus.Entry
 	nc          *nats.Conn
 	syncSubj    string
@@ -49,34 +51,60 @@ type Tracker struct {
 
 	noScrub bool
 
+	clock clock.Clock
+
+	// size is an estimate of the memory used by Items
+	size int64
+
 	sync.Mutex
 }
 
 const (
 	_EMPTY_ = ""
+
+	// itemOverhead estimates the memory used by an item besides its value, the Item and its map entry
+	itemOverhead = 160
 )
 
-func New(ctx context.Context, wg *sync.WaitGroup, interval time.Duration, warn time.Duration, sizeTrigger float64, stateFile string, stream string, worker string, replicator string, nc *nats.Conn, syncSubject string, log *logrus.Entry) (*Tracker, error) {
+// Option configures optional tracker behavior
+type Option func(t *Tracker)
+
+// WithClock sets the clock used to record, check and expire items, defaults to real time
+func WithClock(c clock.Clock) Option {
+	return func(t *Tracker) { t.clock = c }
+}
+
+func New(ctx context.Context, wg *sync.WaitGroup, interval time.Duration, warn time.Duration, sizeTrigger float64, stateFile string, stateKey []byte, stream string, worker string, replicator string, nc *nats.Conn, syncSubject string, log *logrus.Entry, opts ...Option) (*Tracker, error) {
 	t := &Tracker{
 		Items:       map[string]*Item{},
 		interval:    interval,
 		warnAge:     warn,
 		sizeTrigger: sizeTrigger,
 		stateFile:   stateFile,
+		stateKey:    stateKey,
 		stream:      stream,
 		worker:      worker,
 		replicator:  replicator,
 		nc:          nc,
 		syncSubj:    syncSubject,
+		clock:       clock.New(),
 		Mutex:       sync.Mutex{},
 	}
 
+	for _, opt := range opts {
+		opt(t)
+	}
+
 	t.log = log.WithFields(logrus.Fields{
 		"state":    stateFile,
 		"interval": t.interval,
 		"warn":     warn,
 	})
 
+	if len(stateKey) > 0 {
+		t.log = t.log.WithField("encrypted", true)
+	}
+
 	if t.syncSubj != "" && t.nc != nil {
 		wg.Add(1)
 		go t.clusterSync(ctx, wg)
@@ -131,6 +159,9 @@ func (t *Tracker) clusterSync(ctx context.Context, wg *sync.WaitGroup) {
 		}
 
 		t.Lock()
+		if _, ok := t.Items[i.Value]; !ok {
+			t.size += itemSize(i.Value)
+		}
 		t.Items[i.Value] = i
 		cnt := len(t.Items)
 		t.Unlock()
@@ -211,14 +242,14 @@ func (t *Tracker) RecordSeen(v string, sz float64) {
 	}
 
 	if t.recoverCB != nil && !i.Seen.IsZero() {
-		since := time.Since(i.Seen)
+		since := t.clock.Since(i.Seen)
 		if since > t.warnAge && since < t.interval {
 			i.Advised = false
 			go t.recoverCB(v, *i)
 		}
 	}
 
-	i.Seen = time.Now().UTC()
+	i.Seen = t.clock.Now().UTC()
 	i.Size = sz
 
 	// gossip the fact that we saw this node
@@ -242,7 +273,17 @@ func (t *Tracker) RecordCopied(v string) {
 		return
 	}
 
-	i.Copied = time.Now()
+	i.Copied = t.clock.Now()
+}
+
+// ResetCopied forgets when items were last copied so the next message of every item is copied
+func (t *Tracker) ResetCopied() {
+	t.Lock()
+	defer t.Unlock()
+
+	for _, i := range t.Items {
+		i.Copied = time.Time{}
+	}
 }
 
 // RecordAdvised records that we advised about the item
@@ -270,6 +311,14 @@ func (t *Tracker) lastSeen(v string) (time.Time, time.Time, float64) {
 	return i.Seen, i.Copied, i.Size
 }
 
+// StateSize is an estimate of the memory, in bytes, used by tracked items
+func (t *Tracker) StateSize() int64 {
+	t.Lock()
+	defer t.Unlock()
+
+	return t.size
+}
+
 // ShouldProcess determines if a message should be processed considering last seen times, size deltas and copied delta
 func (t *Tracker) ShouldProcess(v string, sz float64) bool {
 	if v == _EMPTY_ {
@@ -278,7 +327,7 @@ func (t *Tracker) ShouldProcess(v string, sz float64) bool {
 
 	// we splay the deadline by 10% of the interval to avoid big copy spikes
 	splay := rand.Int63n(int64(float64(t.interval) * 0.10))
-	deadline := time.Now().Add(-1 * (t.interval - time.Second - time.Duration(splay)))
+	deadline := t.clock.Now().Add(-1 * (t.interval - time.Second - time.Duration(splay)))
 
 	seen, copied, psz := t.lastSeen(v)
 
@@ -300,11 +349,11 @@ func (t *Tracker) ShouldProcess(v string, sz float64) bool {
 func (t *Tracker) maintainer(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	ticker := time.NewTicker(10 * time.Second)
+	ticker := t.clock.NewTicker(10 * time.Second)
 
 	for {
 		select {
-		case <-ticker.C:
+		case <-ticker.C():
 			t.saveState()
 
 		case <-ctx.Done():
@@ -315,6 +364,11 @@ func (t *Tracker) maintainer(ctx context.Context, wg *sync.WaitGroup) {
 	}
 }
 
+// Flush saves the state to the state file now, does nothing when state tracking is not configured
+func (t *Tracker) Flush() error {
+	return t.saveState()
+}
+
 func (t *Tracker) loadState() error {
 	t.Lock()
 	defer t.Unlock()
@@ -332,7 +386,7 @@ func (t *Tracker) loadState() error {
 		return nil
 	}
 
-	d, err := os.ReadFile(t.stateFile)
+	d, err := ReadState(t.stateFile, t.stateKey)
 	if err != nil {
 		return err
 	}
@@ -348,6 +402,10 @@ func (t *Tracker) loadState() error {
 	}
 
 	t.Items = tt.Items
+	t.size = 0
+	for v := range t.Items {
+		t.size += itemSize(v)
+	}
 
 	t.scrub()
 
@@ -379,6 +437,13 @@ func (t *Tracker) saveState() error {
 		return err
 	}
 
+	if len(t.stateKey) > 0 {
+		data, err = encryptState(t.stateKey, data)
+		if err != nil {
+			return fmt.Errorf("could not encrypt state: %v", err)
+		}
+	}
+
 	tmpfile, err := os.CreateTemp(filepath.Dir(t.stateFile), "cache")
 	if err != nil {
 		return fmt.Errorf("coult not create temp file: %v", err)
@@ -405,13 +470,13 @@ func (t *Tracker) saveState() error {
 func (t *Tracker) scrub() {
 	before := len(t.Items)
 
-	expireDeadline := time.Now().Add(-1 * t.interval)
+	expireDeadline := t.clock.Now().Add(-1 * t.interval)
 	// to avoid notifying about old stuff loaded from disk etc
-	expireIgnoreDeadline := time.Now().Add(-2 * t.interval)
+	expireIgnoreDeadline := t.clock.Now().Add(-2 * t.interval)
 	expired := map[string]Item{}
 	shouldExpire := t.expireCB != nil
 
-	warnDeadline := time.Now().Add(-1 * t.warnAge)
+	warnDeadline := t.clock.Now().Add(-1 * t.warnAge)
 	warnings := map[string]Item{}
 	shouldWarn := t.warnCB != nil
 
@@ -431,6 +496,7 @@ func (t *Tracker) scrub() {
 	for _, v := range deletes {
 		trackedItems.WithLabelValues(t.stream, t.replicator, t.worker).Dec()
 		delete(t.Items, v)
+		t.size -= itemSize(v)
 	}
 
 	if shouldExpire && len(expired) > 0 {
@@ -447,8 +513,9 @@ func (t *Tracker) scrub() {
 }
 
 func (t *Tracker) addItem(v string) *Item {
-	i := &Item{Seen: time.Now().UTC()}
+	i := &Item{Seen: t.clock.Now().UTC()}
 	t.Items[v] = i
+	t.size += itemSize(v)
 
 	trackedItems.WithLabelValues(t.stream, t.replicator, t.worker).Add(1)
 
@@ -458,3 +525,8 @@ func (t *Tracker) addItem(v string) *Item {
 
 	return i
 }
+
+// itemSize estimates the memory used by an item tracking v, v is stored as its key and value
+func itemSize(v string) int64 {
+	return int64(itemOverhead + 2*len(v))
+}
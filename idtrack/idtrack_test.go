@@ -13,6 +13,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/choria-io/stream-replicator/clock"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/sirupsen/logrus"
@@ -44,7 +45,7 @@ var _ = Describe("Tracker", func() {
 		Expect(err).ToNot(HaveOccurred())
 		td.Close()
 
-		tracker, err = New(ctx, &wg, 60*time.Minute, 30*time.Minute, 1024, td.Name(), "TEST", "1", "GINKGO", nil, "", log)
+		tracker, err = New(ctx, &wg, 60*time.Minute, 30*time.Minute, 1024, td.Name(), nil, "TEST", "1", "GINKGO", nil, "", log)
 		Expect(err).ToNot(HaveOccurred())
 	})
 
@@ -120,6 +121,43 @@ var _ = Describe("Tracker", func() {
 			Expect(tracker.loadState()).ToNot(HaveOccurred())
 			Expect(tracker.Items).To(HaveLen(10))
 		})
+
+		It("Should encrypt the state when a key is set", func() {
+			tf, err := os.CreateTemp("", "")
+			Expect(err).ToNot(HaveOccurred())
+			tf.Close()
+			defer os.Remove(tf.Name())
+
+			tracker.stateFile = tf.Name()
+
+			for i := 1; i <= 10; i++ {
+				tracker.RecordSeen(fmt.Sprintf("node%d", i), float64(i*1024))
+			}
+			Expect(tracker.saveState()).ToNot(HaveOccurred())
+
+			// unencrypted state is loaded and encrypted on the next save
+			tracker.stateKey = []byte("0123456789abcdef0123456789abcdef")
+			tracker.Items = make(map[string]*Item)
+			Expect(tracker.loadState()).ToNot(HaveOccurred())
+			Expect(tracker.Items).To(HaveLen(10))
+			Expect(tracker.saveState()).ToNot(HaveOccurred())
+
+			d, err := os.ReadFile(tf.Name())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(IsEncryptedState(d)).To(BeTrue())
+			Expect(string(d)).ToNot(ContainSubstring("node1"))
+
+			tracker.Items = make(map[string]*Item)
+			Expect(tracker.loadState()).ToNot(HaveOccurred())
+			Expect(tracker.Items).To(HaveLen(10))
+
+			tracker.Items = make(map[string]*Item)
+			tracker.stateKey = []byte("fedcba9876543210fedcba9876543210")
+			Expect(tracker.loadState()).To(MatchError("could not decrypt state, invalid key or corrupt file"))
+
+			tracker.stateKey = nil
+			Expect(tracker.loadState()).To(MatchError(fmt.Sprintf("state file %s is encrypted but no key is configured", tf.Name())))
+		})
 	})
 
 	Describe("ShouldProcess", func() {
@@ -194,6 +232,18 @@ var _ = Describe("Tracker", func() {
 		})
 	})
 
+	Describe("ResetCopied", func() {
+		It("Should process items again", func() {
+			tracker.RecordSeen("new", 1024)
+			tracker.RecordCopied("new")
+			Expect(tracker.ShouldProcess("new", 1024)).To(BeFalse())
+
+			tracker.ResetCopied()
+			Expect(tracker.Items["new"].Copied.IsZero()).To(BeTrue())
+			Expect(tracker.ShouldProcess("new", 1024)).To(BeTrue())
+		})
+	})
+
 	Describe("RecordAdvised", func() {
 		It("Should correctly set the advised state", func() {
 			tracker.RecordSeen("new", 1024)
@@ -221,6 +271,21 @@ var _ = Describe("Tracker", func() {
 		Expect(tracker.expireCB).ToNot(BeNil())
 	})
 
+	Describe("StateSize", func() {
+		It("Should track the size of items", func() {
+			Expect(tracker.StateSize()).To(Equal(int64(0)))
+
+			tracker.RecordSeen("one", 1024)
+			tracker.RecordSeen("one", 1024)
+			tracker.RecordSeen("three", 1024)
+			Expect(tracker.StateSize()).To(Equal(itemSize("one") + itemSize("three")))
+
+			tracker.Items["one"].Seen = time.Now().UTC().Add(-2 * time.Hour)
+			tracker.scrub()
+			Expect(tracker.StateSize()).To(Equal(itemSize("three")))
+		})
+	})
+
 	Describe("RecordSeen", func() {
 		It("Should handle non existing items", func() {
 			notified := false
@@ -262,4 +327,33 @@ var _ = Describe("Tracker", func() {
 			Expect(item.Size).To(Equal(float64(1024)))
 		})
 	})
+
+	Describe("WithClock", func() {
+		It("Should track and expire items using the clock", func() {
+			mock := clock.NewMock(time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC))
+
+			tracker, err = New(ctx, &wg, 60*time.Minute, 30*time.Minute, 1024, td.Name(), nil, "TEST", "1", "GINKGO", nil, "", log, WithClock(mock))
+			Expect(err).ToNot(HaveOccurred())
+
+			expired := make(chan map[string]Item, 1)
+			tracker.NotifyExpired(func(items map[string]Item) { expired <- items })
+
+			Expect(tracker.ShouldProcess("new", 1024)).To(BeTrue())
+			tracker.RecordSeen("new", 1024)
+			tracker.RecordCopied("new")
+			Expect(tracker.Items["new"].Seen).To(Equal(mock.Now()))
+			Expect(tracker.ShouldProcess("new", 1024)).To(BeFalse())
+
+			// the maintainer scrubs on its ticks, items expire once older than the interval
+			Eventually(mock.Timers).Should(Equal(1))
+			mock.Add(50 * time.Minute)
+			Expect(tracker.ShouldProcess("new", 1024)).To(BeFalse())
+			Consistently(expired, "50ms").ShouldNot(Receive())
+
+			mock.Add(11 * time.Minute)
+			Eventually(expired).Should(Receive(HaveKey("new")))
+			Expect(tracker.StateSize()).To(Equal(int64(0)))
+			Expect(tracker.ShouldProcess("new", 1024)).To(BeTrue())
+		})
+	})
 })
@@ -0,0 +1,166 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package analyze aggregates sampled stream messages into subject cardinality, message size and rate reports
+package analyze
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sizes are the message sizes of a Result
+type Sizes struct {
+	Min     int     `json:"min"`
+	Average float64 `json:"average"`
+	P50     int     `json:"p50"`
+	P90     int     `json:"p90"`
+	P99     int     `json:"p99"`
+	Max     int     `json:"max"`
+}
+
+// Token is the cardinality of a subject token in a Result
+type Token struct {
+	Position int      `json:"position"`
+	Values   int      `json:"values"`
+	Examples []string `json:"examples"`
+}
+
+// Subject is the activity of a single subject in a Result
+type Subject struct {
+	Subject  string  `json:"subject"`
+	Messages int     `json:"messages"`
+	Bytes    int     `json:"bytes"`
+	Average  float64 `json:"average_size"`
+	Rate     float64 `json:"rate"`
+}
+
+// Result is the analysis of the messages sampled from a stream, reports are produced by Finish
+type Result struct {
+	Name     string     `json:"name"`
+	Stream   string     `json:"stream"`
+	Filter   string     `json:"filter,omitempty"`
+	Messages int        `json:"messages"`
+	Bytes    int        `json:"bytes"`
+	FirstSeq uint64     `json:"first_sequence"`
+	LastSeq  uint64     `json:"last_sequence"`
+	Start    time.Time  `json:"start"`
+	End      time.Time  `json:"end"`
+	Rate     float64    `json:"rate"`
+	Distinct int        `json:"distinct_subjects"`
+	Tokens   []*Token   `json:"tokens"`
+	Sizes    *Sizes     `json:"sizes"`
+	Subjects []*Subject `json:"subjects"`
+
+	sizes    []int
+	subjects map[string]*Subject
+	tokens   []map[string]int
+}
+
+// NewResult creates a Result for the configuration name sampling stream, filtered by filter when not empty
+func NewResult(name string, stream string, filter string) *Result {
+	return &Result{
+		Name:     name,
+		Stream:   stream,
+		Filter:   filter,
+		subjects: make(map[string]*Subject),
+	}
+}
+
+// Add records a message of size bytes published to subject at ts and stored in the stream at seq
+func (r *Result) Add(subject string, size int, ts time.Time, seq uint64) {
+	if r.Messages == 0 {
+		r.FirstSeq = seq
+		r.Start = ts
+	}
+	r.LastSeq = seq
+	r.End = ts
+	r.Messages++
+	r.Bytes += size
+	r.sizes = append(r.sizes, size)
+
+	s, ok := r.subjects[subject]
+	if !ok {
+		s = &Subject{Subject: subject}
+		r.subjects[subject] = s
+	}
+	s.Messages++
+	s.Bytes += size
+
+	for i, token := range strings.Split(subject, ".") {
+		if i == len(r.tokens) {
+			r.tokens = append(r.tokens, make(map[string]int))
+		}
+		r.tokens[i][token]++
+	}
+}
+
+// Finish calculates the rates, sizes and tokens of the messages added and keeps the top most active subjects, all when top is 0
+func (r *Result) Finish(top int) {
+	r.Distinct = len(r.subjects)
+	r.Subjects = []*Subject{}
+	r.Tokens = []*Token{}
+
+	if r.Messages == 0 {
+		return
+	}
+
+	// rates are based on the time between the first and last message sampled
+	span := r.End.Sub(r.Start).Seconds()
+	if span > 0 {
+		r.Rate = float64(r.Messages) / span
+	}
+
+	for _, s := range r.subjects {
+		s.Average = float64(s.Bytes) / float64(s.Messages)
+		if span > 0 {
+			s.Rate = float64(s.Messages) / span
+		}
+		r.Subjects = append(r.Subjects, s)
+	}
+	sort.Slice(r.Subjects, func(i, j int) bool {
+		if r.Subjects[i].Messages == r.Subjects[j].Messages {
+			return r.Subjects[i].Subject < r.Subjects[j].Subject
+		}
+		return r.Subjects[i].Messages > r.Subjects[j].Messages
+	})
+	if top > 0 && len(r.Subjects) > top {
+		r.Subjects = r.Subjects[:top]
+	}
+
+	for i, values := range r.tokens {
+		t := &Token{Position: i + 1, Values: len(values)}
+		for v := range values {
+			t.Examples = append(t.Examples, v)
+		}
+		sort.Slice(t.Examples, func(a, b int) bool {
+			if values[t.Examples[a]] == values[t.Examples[b]] {
+				return t.Examples[a] < t.Examples[b]
+			}
+			return values[t.Examples[a]] > values[t.Examples[b]]
+		})
+		if len(t.Examples) > 3 {
+			t.Examples = t.Examples[:3]
+		}
+		r.Tokens = append(r.Tokens, t)
+	}
+
+	sizes := make([]int, len(r.sizes))
+	copy(sizes, r.sizes)
+	sort.Ints(sizes)
+
+	pct := func(p float64) int {
+		return sizes[int(float64(len(sizes)-1)*p)]
+	}
+
+	r.Sizes = &Sizes{
+		Min:     sizes[0],
+		Average: float64(r.Bytes) / float64(r.Messages),
+		P50:     pct(0.5),
+		P90:     pct(0.9),
+		P99:     pct(0.99),
+		Max:     sizes[len(sizes)-1],
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package analyze
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestAnalyze(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Analyze")
+}
+
+var _ = Describe("Result", func() {
+	start := time.Unix(1680000000, 0)
+
+	It("Should report empty samples", func() {
+		r := NewResult("ORDERS", "ORDERS", "")
+		r.Finish(10)
+
+		Expect(r.Messages).To(Equal(0))
+		Expect(r.Distinct).To(Equal(0))
+		Expect(r.Rate).To(Equal(0.0))
+		Expect(r.Sizes).To(BeNil())
+		Expect(r.Subjects).To(BeEmpty())
+		Expect(r.Subjects).ToNot(BeNil())
+		Expect(r.Tokens).To(BeEmpty())
+		Expect(r.Tokens).ToNot(BeNil())
+	})
+
+	It("Should report a single subject", func() {
+		r := NewResult("ORDERS", "ORDERS", "orders.>")
+		r.Add("orders.new", 10, start, 5)
+		r.Add("orders.new", 30, start.Add(time.Second), 6)
+		r.Add("orders.new", 20, start.Add(2*time.Second), 8)
+		r.Finish(10)
+
+		Expect(r.Messages).To(Equal(3))
+		Expect(r.Bytes).To(Equal(60))
+		Expect(r.FirstSeq).To(Equal(uint64(5)))
+		Expect(r.LastSeq).To(Equal(uint64(8)))
+		Expect(r.Start).To(Equal(start))
+		Expect(r.End).To(Equal(start.Add(2 * time.Second)))
+		Expect(r.Rate).To(Equal(1.5))
+		Expect(r.Distinct).To(Equal(1))
+		Expect(r.Subjects).To(Equal([]*Subject{{Subject: "orders.new", Messages: 3, Bytes: 60, Average: 20, Rate: 1.5}}))
+		Expect(r.Tokens).To(Equal([]*Token{
+			{Position: 1, Values: 1, Examples: []string{"orders"}},
+			{Position: 2, Values: 1, Examples: []string{"new"}},
+		}))
+		Expect(r.Sizes).To(Equal(&Sizes{Min: 10, Average: 20, P50: 20, P90: 20, P99: 20, Max: 30}))
+	})
+
+	It("Should not calculate rates for a single message", func() {
+		r := NewResult("ORDERS", "ORDERS", "")
+		r.Add("orders", 100, start, 1)
+		r.Finish(0)
+
+		Expect(r.Rate).To(Equal(0.0))
+		Expect(r.Subjects[0].Rate).To(Equal(0.0))
+		Expect(r.Sizes).To(Equal(&Sizes{Min: 100, Average: 100, P50: 100, P90: 100, P99: 100, Max: 100}))
+	})
+
+	It("Should calculate percentiles at their boundaries", func() {
+		r := NewResult("ORDERS", "ORDERS", "")
+		for i := 100; i >= 1; i-- {
+			r.Add("orders", i, start, uint64(101-i))
+		}
+		r.Finish(0)
+
+		Expect(r.Sizes).To(Equal(&Sizes{Min: 1, Average: 50.5, P50: 50, P90: 90, P99: 99, Max: 100}))
+
+		// percentiles select the nearest lower sample, fewer samples than needed for a percentile report lower sizes
+		r = NewResult("ORDERS", "ORDERS", "")
+		for i := 1; i <= 10; i++ {
+			r.Add("orders", i, start, uint64(i))
+		}
+		r.Finish(0)
+
+		Expect(r.Sizes).To(Equal(&Sizes{Min: 1, Average: 5.5, P50: 5, P90: 9, P99: 9, Max: 10}))
+
+		r = NewResult("ORDERS", "ORDERS", "")
+		r.Add("orders", 1, start, 1)
+		r.Add("orders", 2, start, 2)
+		r.Finish(0)
+
+		Expect(r.Sizes).To(Equal(&Sizes{Min: 1, Average: 1.5, P50: 1, P90: 1, P99: 1, Max: 2}))
+	})
+
+	It("Should rank subjects and tokens by activity", func() {
+		r := NewResult("ORDERS", "ORDERS", "")
+		r.Add("orders.eu.new", 1, start, 1)
+		r.Add("orders.us.new", 1, start, 2)
+		r.Add("orders.us.new", 1, start, 3)
+		r.Add("orders.ap.shipped", 1, start, 4)
+		r.Add("orders.uk.new", 1, start, 5)
+		r.Add("orders.us", 1, start.Add(time.Second), 6)
+		r.Finish(2)
+
+		Expect(r.Distinct).To(Equal(5))
+		Expect(r.Subjects).To(HaveLen(2))
+		Expect(r.Subjects[0].Subject).To(Equal("orders.us.new"))
+		Expect(r.Subjects[1].Subject).To(Equal("orders.ap.shipped"))
+
+		Expect(r.Tokens).To(Equal([]*Token{
+			{Position: 1, Values: 1, Examples: []string{"orders"}},
+			{Position: 2, Values: 4, Examples: []string{"us", "ap", "eu"}},
+			{Position: 3, Values: 2, Examples: []string{"new", "shipped"}},
+		}))
+	})
+})
@@ -0,0 +1,135 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package apiauth authenticates requests to the admin API and adds the credentials to requests made by its clients
+package apiauth
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/choria-io/stream-replicator/config"
+)
+
+// TokenIdentity is the identity of callers authenticated using a bearer token
+const TokenIdentity = "token"
+
+// Authenticate checks the basic authentication or bearer token of r against auth and returns the identity of the
+// caller, the validated user name or TokenIdentity, unless r comes from a trusted proxy that set X-Remote-User
+func Authenticate(auth *config.AdminAPIAuth, r *http.Request) (string, error) {
+	if auth == nil {
+		return "", fmt.Errorf("no admin_api_auth configured")
+	}
+
+	identity, err := authenticate(auth, r)
+	if err != nil {
+		return "", err
+	}
+
+	if user := r.Header.Get("X-Remote-User"); user != "" && trustedProxy(auth, r.RemoteAddr) {
+		return user, nil
+	}
+
+	return identity, nil
+}
+
+func authenticate(auth *config.AdminAPIAuth, r *http.Request) (string, error) {
+	if auth.Username != "" {
+		user, pass, ok := r.BasicAuth()
+		if !ok {
+			return "", fmt.Errorf("no basic authentication supplied")
+		}
+
+		// both are compared to not leak which one was wrong through timing
+		userOK := subtle.ConstantTimeCompare([]byte(user), []byte(auth.Username)) == 1
+		passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(auth.Password)) == 1
+		if !userOK || !passOK {
+			return "", fmt.Errorf("invalid credentials for user %q", user)
+		}
+
+		return auth.Username, nil
+	}
+
+	token, err := Token(auth)
+	if err != nil {
+		return "", err
+	}
+	if token == "" {
+		return "", fmt.Errorf("admin api token is empty")
+	}
+
+	supplied, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return "", fmt.Errorf("no bearer token supplied")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(supplied), []byte(token)) != 1 {
+		return "", fmt.Errorf("invalid bearer token")
+	}
+
+	return TokenIdentity, nil
+}
+
+// trustedProxy checks if the remote address addr is one of the trusted proxies in auth
+func trustedProxy(auth *config.AdminAPIAuth, addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, p := range auth.TrustedProxies {
+		if pip := net.ParseIP(p); pip != nil {
+			if pip.Equal(ip) {
+				return true
+			}
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(p)
+		if err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Authorize adds the credentials in auth to req
+func Authorize(req *http.Request, auth *config.AdminAPIAuth) error {
+	if auth.Username != "" {
+		req.SetBasicAuth(auth.Username, auth.Password)
+		return nil
+	}
+
+	token, err := Token(auth)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	return nil
+}
+
+// Token is the bearer token of auth, read from the token file when set
+func Token(auth *config.AdminAPIAuth) (string, error) {
+	if auth.TokenFile == "" {
+		return auth.Token, nil
+	}
+
+	token, err := os.ReadFile(auth.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("could not read token_file: %v", err)
+	}
+
+	return strings.TrimSpace(string(token)), nil
+}
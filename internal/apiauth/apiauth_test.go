@@ -0,0 +1,131 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package apiauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/choria-io/stream-replicator/config"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestAPIAuth(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "API Auth")
+}
+
+var _ = Describe("API Auth", func() {
+	request := func() *http.Request {
+		return httptest.NewRequest(http.MethodPost, "/api/v1/quiesce", nil)
+	}
+
+	authErr := func(auth *config.AdminAPIAuth, r *http.Request) error {
+		_, err := Authenticate(auth, r)
+		return err
+	}
+
+	Describe("Authenticate", func() {
+		It("Should reject requests without configured credentials", func() {
+			Expect(authErr(nil, request())).To(MatchError("no admin_api_auth configured"))
+		})
+
+		It("Should authenticate basic authentication", func() {
+			auth := &config.AdminAPIAuth{Username: "admin", Password: "s3cret"}
+
+			req := request()
+			Expect(authErr(auth, req)).To(MatchError("no basic authentication supplied"))
+
+			req.SetBasicAuth("admin", "wrong")
+			Expect(authErr(auth, req)).To(MatchError(`invalid credentials for user "admin"`))
+
+			req.SetBasicAuth("other", "s3cret")
+			Expect(authErr(auth, req)).To(MatchError(`invalid credentials for user "other"`))
+
+			req.Header.Set("Authorization", "Bearer s3cret")
+			Expect(authErr(auth, req)).To(MatchError("no basic authentication supplied"))
+
+			Expect(Authorize(req, auth)).To(Succeed())
+			Expect(Authenticate(auth, req)).To(Equal("admin"))
+		})
+
+		It("Should authenticate bearer tokens", func() {
+			auth := &config.AdminAPIAuth{Token: "s3cret"}
+
+			req := request()
+			Expect(authErr(auth, req)).To(MatchError("no bearer token supplied"))
+
+			req.SetBasicAuth("admin", "s3cret")
+			Expect(authErr(auth, req)).To(MatchError("no bearer token supplied"))
+
+			req.Header.Set("Authorization", "Bearer wrong")
+			Expect(authErr(auth, req)).To(MatchError("invalid bearer token"))
+
+			Expect(Authorize(req, auth)).To(Succeed())
+			Expect(req.Header.Get("Authorization")).To(Equal("Bearer s3cret"))
+			Expect(Authenticate(auth, req)).To(Equal(TokenIdentity))
+		})
+
+		It("Should read rotated tokens from the token file", func() {
+			tf := filepath.Join(GinkgoT().TempDir(), "token")
+			auth := &config.AdminAPIAuth{TokenFile: tf}
+
+			req := request()
+			req.Header.Set("Authorization", "Bearer first")
+			Expect(authErr(auth, req)).To(MatchError(ContainSubstring("could not read token_file")))
+
+			Expect(os.WriteFile(tf, []byte("first\n"), 0600)).To(Succeed())
+			Expect(Authenticate(auth, req)).To(Equal(TokenIdentity))
+
+			Expect(os.WriteFile(tf, []byte("second\n"), 0600)).To(Succeed())
+			Expect(authErr(auth, req)).To(MatchError("invalid bearer token"))
+
+			Expect(Authorize(req, auth)).To(Succeed())
+			Expect(req.Header.Get("Authorization")).To(Equal("Bearer second"))
+			Expect(Authenticate(auth, req)).To(Equal(TokenIdentity))
+
+			// an empty token never authenticates
+			Expect(os.WriteFile(tf, nil, 0600)).To(Succeed())
+			req.Header.Set("Authorization", "Bearer ")
+			Expect(authErr(auth, req)).To(MatchError("admin api token is empty"))
+		})
+
+		It("Should only trust X-Remote-User from trusted proxies", func() {
+			auth := &config.AdminAPIAuth{Username: "proxy", Password: "s3cret"}
+
+			req := request()
+			req.RemoteAddr = "192.0.2.10:51234"
+			req.Header.Set("X-Remote-User", "bob")
+			Expect(authErr(auth, req)).To(MatchError("no basic authentication supplied"))
+
+			req.SetBasicAuth("bob", "wrong")
+			Expect(authErr(auth, req)).To(MatchError(`invalid credentials for user "bob"`))
+
+			req.SetBasicAuth("proxy", "s3cret")
+			Expect(Authenticate(auth, req)).To(Equal("proxy"))
+
+			auth.TrustedProxies = []string{"192.0.2.1", "198.51.100.0/24"}
+			Expect(Authenticate(auth, req)).To(Equal("proxy"))
+
+			req.RemoteAddr = "192.0.2.1:51234"
+			Expect(Authenticate(auth, req)).To(Equal("bob"))
+
+			req.RemoteAddr = "198.51.100.20:51234"
+			Expect(Authenticate(auth, req)).To(Equal("bob"))
+
+			req.Header.Del("X-Remote-User")
+			Expect(Authenticate(auth, req)).To(Equal("proxy"))
+
+			// trusted proxies still have to authenticate
+			req.Header.Set("X-Remote-User", "bob")
+			req.SetBasicAuth("proxy", "wrong")
+			Expect(authErr(auth, req)).To(MatchError(`invalid credentials for user "proxy"`))
+		})
+	})
+})
@@ -0,0 +1,186 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package archive stores messages of a stream as compressed batches in S3 compatible storage or local directories
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/nats-io/jsm.go/api"
+)
+
+// ManifestFormat is the version of the manifest and object layout
+const ManifestFormat = 1
+
+// ErrNotFound is returned by Store.Get for objects that do not exist
+var ErrNotFound = errors.New("object not found")
+
+// Store stores objects by key
+type Store interface {
+	// Put stores data as key, replacing any existing object
+	Put(ctx context.Context, key string, data []byte) error
+	// Get retrieves key, ErrNotFound when it does not exist
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// Record is an archived message, objects hold one JSON encoded record per line
+type Record struct {
+	// Subject is the subject the message was stored with in the source stream
+	Subject string `json:"subject"`
+	// Sequence is the sequence of the message in the source stream
+	Sequence uint64 `json:"seq"`
+	// Time is when the message was stored in the source stream
+	Time time.Time `json:"time"`
+	// Header holds the message headers
+	Header map[string][]string `json:"headers,omitempty"`
+	// Data is the message body
+	Data []byte `json:"data"`
+}
+
+// Object describes an object holding a batch of records
+type Object struct {
+	// Key is the name of the object in the store
+	Key string `json:"key"`
+	// FirstSeq is the sequence of the first record in the object
+	FirstSeq uint64 `json:"first_seq"`
+	// LastSeq is the sequence of the last record in the object
+	LastSeq uint64 `json:"last_seq"`
+	// Messages is how many records the object holds
+	Messages int `json:"messages"`
+	// Size is the compressed size of the object
+	Size int64 `json:"size"`
+	// FirstTime is the time of the first record in the object
+	FirstTime time.Time `json:"first_time"`
+	// LastTime is the time of the last record in the object
+	LastTime time.Time `json:"last_time"`
+}
+
+// Manifest lists the objects archived for a stream in sequence order
+type Manifest struct {
+	// Format is the ManifestFormat the archive was written with
+	Format int `json:"format"`
+	// Stream is the name of the archived stream
+	Stream string `json:"stream"`
+	// Config is the configuration of the stream when the manifest was last updated
+	Config api.StreamConfig `json:"config"`
+	// LastSeq is the sequence of the last archived message
+	LastSeq uint64 `json:"last_seq"`
+	// Messages is the total amount of archived messages
+	Messages uint64 `json:"messages"`
+	// Objects are the archived objects ordered by sequence
+	Objects []Object `json:"objects"`
+	// Updated is when the manifest was last written
+	Updated time.Time `json:"updated"`
+}
+
+// NewStore creates the store described by cfg, connections to S3 compatible services are made through proxy when set
+func NewStore(cfg *config.Archive, proxy string) (Store, error) {
+	if cfg.Directory != "" {
+		return newDirectoryStore(cfg.Directory), nil
+	}
+
+	return newS3Store(cfg, proxy)
+}
+
+// ManifestKey is the key of the manifest for stream
+func ManifestKey(prefix string, stream string) string {
+	return path.Join(prefix, stream, "manifest.json")
+}
+
+// ObjectKey is the key of the object holding records first to last of stream, keys sort in sequence order
+func ObjectKey(prefix string, stream string, first uint64, last uint64) string {
+	return path.Join(prefix, stream, fmt.Sprintf("%020d-%020d.jsonl.gz", first, last))
+}
+
+// LoadManifest retrieves the manifest for stream, ErrNotFound when nothing was archived yet
+func LoadManifest(ctx context.Context, store Store, prefix string, stream string) (*Manifest, error) {
+	mb, err := store.Get(ctx, ManifestKey(prefix, stream))
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{}
+	err = json.Unmarshal(mb, manifest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+
+	if manifest.Format > ManifestFormat {
+		return nil, fmt.Errorf("unsupported manifest format %d", manifest.Format)
+	}
+
+	return manifest, nil
+}
+
+// SaveManifest stores manifest for stream
+func SaveManifest(ctx context.Context, store Store, prefix string, manifest *Manifest) error {
+	manifest.Format = ManifestFormat
+	manifest.Updated = time.Now().UTC()
+
+	mb, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return store.Put(ctx, ManifestKey(prefix, manifest.Stream), mb)
+}
+
+// Encode creates the gzip compressed object for records
+func Encode(records []*Record) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	gz := gzip.NewWriter(buf)
+	enc := json.NewEncoder(gz)
+
+	for _, r := range records {
+		err := enc.Encode(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := gz.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Decode reads the records from an object created by Encode
+func Decode(data []byte) ([]*Record, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	var records []*Record
+
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 0, 64*1024), 128*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		r := &Record{}
+		err = json.Unmarshal(scanner.Bytes(), r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid record %d: %v", len(records)+1, err)
+		}
+		records = append(records, r)
+	}
+
+	return records, scanner.Err()
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package archive
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/nats-io/jsm.go/api"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestArchive(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Archive")
+}
+
+// fakeS3 is a minimal path style S3 service supporting only object puts and gets
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		// plain http uploads are signed per chunk, chunks are <hex size>;chunk-signature=<sig>\r\n<data>\r\n
+		if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+			var data []byte
+			for len(body) > 0 {
+				line, rest, _ := bytes.Cut(body, []byte("\r\n"))
+				size, _, _ := strings.Cut(string(line), ";")
+				n, err := strconv.ParseInt(size, 16, 64)
+				if err != nil || int64(len(rest)) < n {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				data = append(data, rest[:n]...)
+				body = bytes.TrimPrefix(rest[n:], []byte("\r\n"))
+			}
+			body = data
+		}
+
+		f.objects[r.URL.Path] = body
+		w.Header().Set("ETag", `"etag"`)
+
+	case http.MethodGet:
+		body, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`)
+			return
+		}
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Write(body)
+
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+var _ = Describe("Archive", func() {
+	var ctx context.Context
+
+	BeforeEach(func() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		DeferCleanup(cancel)
+	})
+
+	Describe("Encode", func() {
+		It("Should round trip records", func() {
+			records := []*Record{
+				{Subject: "ORDERS.new", Sequence: 1, Time: time.Unix(1000, 0).UTC(), Header: map[string][]string{"Nats-Msg-Id": {"1"}}, Data: []byte("one")},
+				{Subject: "ORDERS.new", Sequence: 3, Time: time.Unix(1001, 0).UTC(), Data: []byte{0, 1, 2}},
+			}
+
+			data, err := Encode(records)
+			Expect(err).ToNot(HaveOccurred())
+
+			decoded, err := Decode(data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(decoded).To(Equal(records))
+		})
+	})
+
+	Describe("Keys", func() {
+		It("Should create sortable keys", func() {
+			Expect(ObjectKey("backups", "ORDERS", 1, 100)).To(Equal("backups/ORDERS/00000000000000000001-00000000000000000100.jsonl.gz"))
+			Expect(ObjectKey("", "ORDERS", 1, 100)).To(Equal("ORDERS/00000000000000000001-00000000000000000100.jsonl.gz"))
+			Expect(ManifestKey("backups", "ORDERS")).To(Equal("backups/ORDERS/manifest.json"))
+		})
+	})
+
+	Describe("Stores", func() {
+		testStore := func(store Store) {
+			_, err := LoadManifest(ctx, store, "backups", "ORDERS")
+			Expect(err).To(MatchError(ErrNotFound))
+
+			Expect(store.Put(ctx, ObjectKey("backups", "ORDERS", 1, 2), []byte("data"))).To(Succeed())
+			data, err := store.Get(ctx, ObjectKey("backups", "ORDERS", 1, 2))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(data)).To(Equal("data"))
+
+			manifest := &Manifest{Stream: "ORDERS", Config: api.StreamConfig{Name: "ORDERS", Subjects: []string{"ORDERS.>"}}, LastSeq: 2, Messages: 2}
+			Expect(SaveManifest(ctx, store, "backups", manifest)).To(Succeed())
+
+			loaded, err := LoadManifest(ctx, store, "backups", "ORDERS")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loaded.Format).To(Equal(ManifestFormat))
+			Expect(loaded.LastSeq).To(Equal(uint64(2)))
+			Expect(loaded.Config.Subjects).To(Equal([]string{"ORDERS.>"}))
+		}
+
+		It("Should store objects in directories", func() {
+			store, err := NewStore(&config.Archive{Directory: GinkgoT().TempDir()}, "")
+			Expect(err).ToNot(HaveOccurred())
+			testStore(store)
+		})
+
+		It("Should store objects in S3 compatible services", func() {
+			fake := &fakeS3{objects: map[string][]byte{}}
+			srv := httptest.NewServer(fake)
+			DeferCleanup(srv.Close)
+
+			store, err := NewStore(&config.Archive{
+				Endpoint:  strings.TrimPrefix(srv.URL, "http://"),
+				Bucket:    "archive",
+				Region:    "us-east-1",
+				AccessKey: "access",
+				SecretKey: "secret",
+				Insecure:  true,
+			}, "")
+			Expect(err).ToNot(HaveOccurred())
+			testStore(store)
+
+			Expect(fake.objects).To(HaveKey("/archive/backups/ORDERS/manifest.json"))
+		})
+	})
+})
@@ -0,0 +1,66 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package archive
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// directoryStore stores objects as files below a directory
+type directoryStore struct {
+	dir string
+}
+
+func newDirectoryStore(dir string) *directoryStore {
+	return &directoryStore{dir: dir}
+}
+
+// Put writes data to a temporary file that is renamed to key so readers never see partial objects
+func (d *directoryStore) Put(_ context.Context, key string, data []byte) error {
+	target := filepath.Join(d.dir, filepath.FromSlash(key))
+
+	err := os.MkdirAll(filepath.Dir(target), 0700)
+	if err != nil {
+		return err
+	}
+
+	tf, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tf.Name())
+
+	_, err = tf.Write(data)
+	if err != nil {
+		tf.Close()
+		return err
+	}
+
+	err = tf.Sync()
+	if err != nil {
+		tf.Close()
+		return err
+	}
+
+	err = tf.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tf.Name(), target)
+}
+
+func (d *directoryStore) Get(_ context.Context, key string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(d.dir, filepath.FromSlash(key)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+
+	return data, err
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package bench collects the replication latencies of benchmark messages and summarizes them
+package bench
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Results records the latency of every benchmark message received, safe for use from subscription callbacks
+type Results struct {
+	count     int
+	seen      map[int]struct{}
+	latencies []time.Duration
+	done      chan struct{}
+	mu        sync.Mutex
+}
+
+// Summary is a summary of the latencies in Results
+type Summary struct {
+	Count   int
+	Min     time.Duration
+	Average time.Duration
+	P50     time.Duration
+	P90     time.Duration
+	P99     time.Duration
+	Max     time.Duration
+}
+
+// NewResults creates Results expecting messages with sequences 1 to count
+func NewResults(count int) *Results {
+	return &Results{
+		count: count,
+		seen:  make(map[int]struct{}, count),
+		done:  make(chan struct{}),
+	}
+}
+
+// Add records the latency of message seq without blocking, redelivered or unexpected sequences are ignored and return false
+func (r *Results) Add(seq int, latency time.Duration) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if seq < 1 || seq > r.count {
+		return false
+	}
+
+	if _, ok := r.seen[seq]; ok {
+		return false
+	}
+
+	r.seen[seq] = struct{}{}
+	r.latencies = append(r.latencies, latency)
+
+	if len(r.latencies) == r.count {
+		close(r.done)
+	}
+
+	return true
+}
+
+// Done is closed once every expected message was received
+func (r *Results) Done() <-chan struct{} {
+	return r.done
+}
+
+// Count is the number of unique messages received
+func (r *Results) Count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.latencies)
+}
+
+// Summary summarizes the latencies received so far
+func (r *Results) Summary() Summary {
+	r.mu.Lock()
+	latencies := make([]time.Duration, len(r.latencies))
+	copy(latencies, r.latencies)
+	r.mu.Unlock()
+
+	s := Summary{Count: len(latencies)}
+	if len(latencies) == 0 {
+		return s
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	var sum time.Duration
+	for _, l := range latencies {
+		sum += l
+	}
+
+	pct := func(p float64) time.Duration {
+		return latencies[int(float64(len(latencies)-1)*p)]
+	}
+
+	s.Min = latencies[0]
+	s.Average = sum / time.Duration(len(latencies))
+	s.P50 = pct(0.5)
+	s.P90 = pct(0.9)
+	s.P99 = pct(0.99)
+	s.Max = latencies[len(latencies)-1]
+
+	return s
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package bench
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestBench(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Bench")
+}
+
+var _ = Describe("Results", func() {
+	It("Should ignore duplicate and unexpected sequences", func() {
+		r := NewResults(3)
+
+		Expect(r.Add(1, time.Millisecond)).To(BeTrue())
+		Expect(r.Add(1, time.Second)).To(BeFalse())
+		Expect(r.Add(0, time.Second)).To(BeFalse())
+		Expect(r.Add(4, time.Second)).To(BeFalse())
+		Expect(r.Count()).To(Equal(1))
+		Consistently(r.Done(), "10ms").ShouldNot(BeClosed())
+
+		Expect(r.Add(2, 2*time.Millisecond)).To(BeTrue())
+		Expect(r.Add(3, 3*time.Millisecond)).To(BeTrue())
+		Expect(r.Done()).To(BeClosed())
+
+		// redeliveries after completion do not block or panic
+		Expect(r.Add(3, time.Second)).To(BeFalse())
+		Expect(r.Summary().Max).To(Equal(3 * time.Millisecond))
+	})
+
+	It("Should not block concurrent redeliveries", func() {
+		r := NewResults(100)
+		wg := sync.WaitGroup{}
+
+		for w := 0; w < 4; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 1; i <= 100; i++ {
+					r.Add(i, time.Duration(i)*time.Millisecond)
+				}
+			}()
+		}
+
+		wg.Wait()
+		Expect(r.Done()).To(BeClosed())
+		Expect(r.Count()).To(Equal(100))
+	})
+
+	It("Should summarize latencies", func() {
+		r := NewResults(100)
+		Expect(r.Summary()).To(Equal(Summary{}))
+
+		for i := 100; i >= 1; i-- {
+			r.Add(i, time.Duration(i)*time.Millisecond)
+		}
+
+		Expect(r.Summary()).To(Equal(Summary{
+			Count:   100,
+			Min:     time.Millisecond,
+			Average: 50500 * time.Microsecond,
+			P50:     50 * time.Millisecond,
+			P90:     90 * time.Millisecond,
+			P99:     99 * time.Millisecond,
+			Max:     100 * time.Millisecond,
+		}))
+	})
+})
@@ -0,0 +1,206 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package cron parses standard 5 field cron expressions, minute hour day-of-month month day-of-week,
+// and calculates when they next match
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression
+type Schedule struct {
+	expr   string
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	// when both day fields are restricted a day matches either of them like in cron
+	anyDom bool
+	anyDow bool
+}
+
+type field struct {
+	name  string
+	min   int
+	max   int
+	names map[string]int
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dowField = field{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+
+	macros = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+// Parse parses a cron expression like "30 2 * * mon-fri", the @yearly, @monthly, @weekly, @daily and @hourly
+// macros are supported
+func Parse(expr string) (*Schedule, error) {
+	spec := strings.TrimSpace(expr)
+	if macro, ok := macros[strings.ToLower(spec)]; ok {
+		spec = macro
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields in cron expression %q, found %d", expr, len(fields))
+	}
+
+	s := &Schedule{
+		expr:   expr,
+		anyDom: fields[2] == "*" || fields[2] == "?",
+		anyDow: fields[4] == "*" || fields[4] == "?",
+	}
+
+	var err error
+	for i, f := range []struct {
+		bits *uint64
+		f    field
+	}{{&s.minute, minuteField}, {&s.hour, hourField}, {&s.dom, domField}, {&s.month, monthField}, {&s.dow, dowField}} {
+		*f.bits, err = f.f.parse(fields[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 7 is an alias for sunday
+	if s.dow&(1<<7) > 0 {
+		s.dow |= 1
+	}
+
+	return s, nil
+}
+
+// String is the expression the schedule was parsed from
+func (s *Schedule) String() string {
+	return s.expr
+}
+
+// Next is the first minute after t matching the schedule in the location of t, zero when nothing matches
+// within 5 years
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Truncate(time.Minute).Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func (s *Schedule) matchDay(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) > 0
+	dow := s.dow&(1<<uint(t.Weekday())) > 0
+
+	if s.anyDom || s.anyDow {
+		return dom && dow
+	}
+
+	return dom || dow
+}
+
+// parse parses a comma separated list of values, ranges and steps into a bit set of matching values
+func (f field) parse(spec string) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(spec, ",") {
+		rng, stepSpec, stepped := strings.Cut(part, "/")
+
+		step := 1
+		if stepped {
+			var err error
+			step, err = strconv.Atoi(stepSpec)
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q in %s field", stepSpec, f.name)
+			}
+		}
+
+		var start, end int
+		var err error
+
+		switch {
+		case rng == "*" || rng == "?":
+			start, end = f.min, f.max
+		case strings.Contains(rng, "-"):
+			from, to, _ := strings.Cut(rng, "-")
+			start, err = f.value(from)
+			if err != nil {
+				return 0, err
+			}
+			end, err = f.value(to)
+			if err != nil {
+				return 0, err
+			}
+			if end < start {
+				return 0, fmt.Errorf("invalid range %q in %s field", rng, f.name)
+			}
+		default:
+			start, err = f.value(rng)
+			if err != nil {
+				return 0, err
+			}
+			end = start
+			if stepped {
+				end = f.max
+			}
+		}
+
+		for i := start; i <= end; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+
+	return bits, nil
+}
+
+// value parses a single number or name and checks it is in range
+func (f field) value(spec string) (int, error) {
+	if v, ok := f.names[strings.ToLower(spec)]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(spec)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q in %s field", spec, f.name)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("%s %d is not between %d and %d", f.name, v, f.min, f.max)
+	}
+
+	return v, nil
+}
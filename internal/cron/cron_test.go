@@ -0,0 +1,88 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package cron
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestCron(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Cron")
+}
+
+var _ = Describe("Cron", func() {
+	// a saturday
+	start := time.Date(2023, 7, 1, 12, 30, 15, 0, time.UTC)
+
+	next := func(expr string, t time.Time) time.Time {
+		s, err := Parse(expr)
+		Expect(err).ToNot(HaveOccurred())
+		return s.Next(t)
+	}
+
+	Describe("Parse", func() {
+		It("Should validate expressions", func() {
+			for expr, msg := range map[string]string{
+				"* * * *":       "expected 5 fields",
+				"60 * * * *":    "minute 60 is not between 0 and 59",
+				"* 24 * * *":    "hour 24 is not between 0 and 23",
+				"* * 0 * *":     "day of month 0 is not between 1 and 31",
+				"* * * 13 *":    "month 13 is not between 1 and 12",
+				"* * * * 8":     "day of week 8 is not between 0 and 7",
+				"*/0 * * * *":   "invalid step",
+				"5-1 * * * *":   "invalid range",
+				"x * * * *":     "invalid value",
+				"* * * jan-x *": "invalid value",
+			} {
+				_, err := Parse(expr)
+				Expect(err).To(MatchError(ContainSubstring(msg)), expr)
+			}
+		})
+
+		It("Should support macros", func() {
+			s, err := Parse("@daily")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.String()).To(Equal("@daily"))
+			Expect(s.Next(start)).To(Equal(time.Date(2023, 7, 2, 0, 0, 0, 0, time.UTC)))
+		})
+	})
+
+	Describe("Next", func() {
+		It("Should find the next matching minute", func() {
+			Expect(next("* * * * *", start)).To(Equal(time.Date(2023, 7, 1, 12, 31, 0, 0, time.UTC)))
+			Expect(next("30 12 * * *", start)).To(Equal(time.Date(2023, 7, 2, 12, 30, 0, 0, time.UTC)))
+			Expect(next("*/15 * * * *", start)).To(Equal(time.Date(2023, 7, 1, 12, 45, 0, 0, time.UTC)))
+			Expect(next("10,20 2 * * *", start)).To(Equal(time.Date(2023, 7, 2, 2, 10, 0, 0, time.UTC)))
+			Expect(next("0 2-4/2 * * *", start)).To(Equal(time.Date(2023, 7, 2, 2, 0, 0, 0, time.UTC)))
+			Expect(next("0 0 1 jan *", start)).To(Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+		})
+
+		It("Should support days of the week", func() {
+			Expect(next("0 2 * * mon-fri", start)).To(Equal(time.Date(2023, 7, 3, 2, 0, 0, 0, time.UTC)))
+			Expect(next("0 2 * * 7", start)).To(Equal(time.Date(2023, 7, 2, 2, 0, 0, 0, time.UTC)))
+			Expect(next("0 2 * * SUN", start)).To(Equal(time.Date(2023, 7, 2, 2, 0, 0, 0, time.UTC)))
+		})
+
+		It("Should match either day field when both are restricted", func() {
+			// the 15th or any monday
+			Expect(next("0 0 15 * 1", start)).To(Equal(time.Date(2023, 7, 3, 0, 0, 0, 0, time.UTC)))
+			Expect(next("0 0 15 * 1", time.Date(2023, 7, 12, 0, 0, 0, 0, time.UTC))).To(Equal(time.Date(2023, 7, 15, 0, 0, 0, 0, time.UTC)))
+		})
+
+		It("Should use the location of the time", func() {
+			loc := time.FixedZone("test", 2*60*60)
+			Expect(next("0 2 * * *", start.In(loc))).To(Equal(time.Date(2023, 7, 2, 2, 0, 0, 0, loc)))
+		})
+
+		It("Should give up on impossible schedules", func() {
+			Expect(next("0 0 31 2 *", start).IsZero()).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,132 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package kafka holds helpers shared by the Kafka targets and sources
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/choria-io/stream-replicator/backoff"
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/util"
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
+)
+
+const dialTimeout = 10 * time.Second
+
+// ClientOptions creates options for a franz-go client connecting to the brokers in cfg, through proxy when not empty
+func ClientOptions(cfg *config.Kafka, proxy string) ([]kgo.Opt, error) {
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(cfg.Brokers...),
+		kgo.ClientID(cfg.ClientID),
+	}
+
+	var tlsc *tls.Config
+	if cfg.TLS != nil {
+		var err error
+		tlsc, err = util.ClientTLSConfig(cfg.TLS.CA, cfg.TLS.Cert, cfg.TLS.Key)
+		if err != nil {
+			return nil, fmt.Errorf("kafka tls: %w", err)
+		}
+	}
+
+	switch {
+	case proxy != "":
+		dial, err := util.ProxyDialer(proxy, dialTimeout)
+		if err != nil {
+			return nil, err
+		}
+
+		if tlsc != nil {
+			dial = util.TLSDialer(dial, tlsc)
+		}
+
+		opts = append(opts, kgo.Dialer(dial))
+
+	case tlsc != nil:
+		opts = append(opts, kgo.DialTLSConfig(tlsc))
+	}
+
+	if cfg.SASL != nil {
+		switch cfg.SASL.Mechanism {
+		case config.KafkaSASLPlain:
+			opts = append(opts, kgo.SASL(plain.Auth{User: cfg.SASL.Username, Pass: cfg.SASL.Password}.AsMechanism()))
+		case config.KafkaSASLScram256:
+			opts = append(opts, kgo.SASL(scram.Auth{User: cfg.SASL.Username, Pass: cfg.SASL.Password}.AsSha256Mechanism()))
+		case config.KafkaSASLScram512:
+			opts = append(opts, kgo.SASL(scram.Auth{User: cfg.SASL.Username, Pass: cfg.SASL.Password}.AsSha512Mechanism()))
+		default:
+			return nil, fmt.Errorf("unsupported sasl mechanism %q", cfg.SASL.Mechanism)
+		}
+	}
+
+	return opts, nil
+}
+
+// Connect creates a client and waits, retrying on policy, until the brokers can be reached
+func Connect(ctx context.Context, opts []kgo.Opt, policy backoff.Policy, log *logrus.Entry) (*kgo.Client, error) {
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = policy.For(ctx, func(try int) error {
+		timeout, cancel := context.WithTimeout(ctx, dialTimeout)
+		defer cancel()
+
+		err := client.Ping(timeout)
+		if err != nil {
+			log.Errorf("Could not reach the Kafka brokers on try %d: %v", try, err)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// HeadersFromNats converts NATS headers to Kafka record headers, a header with multiple values becomes multiple record headers
+func HeadersFromNats(h nats.Header) []kgo.RecordHeader {
+	var headers []kgo.RecordHeader
+
+	for k, vals := range h {
+		for _, v := range vals {
+			headers = append(headers, kgo.RecordHeader{Key: k, Value: []byte(v)})
+		}
+	}
+
+	return headers
+}
+
+// TopicForSubject creates a valid Kafka topic name from subject, characters not allowed in topics are replaced by _
+func TopicForSubject(subject string) string {
+	topic := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			return r
+		default:
+			return '_'
+		}
+	}, subject)
+
+	if len(topic) > 249 {
+		topic = topic[:249]
+	}
+
+	return topic
+}
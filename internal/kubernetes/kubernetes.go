@@ -0,0 +1,184 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package kubernetes is a minimal client for the Kubernetes API supporting the Lease objects used for leader
+// election when running in a cluster, it authenticates using the service account of the pod
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/choria-io/stream-replicator/internal/util"
+)
+
+// ServiceAccountDir is where the service account token, ca and namespace are mounted in pods
+const ServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+var (
+	// ErrNotFound is returned when an object does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when an object was changed since it was read
+	ErrConflict = errors.New("conflict")
+
+	invalidNameChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+)
+
+// Client accesses the Kubernetes API
+type Client struct {
+	url       string
+	namespace string
+	tokenFile string
+	token     string
+	hc        *http.Client
+}
+
+// Option configures the client
+type Option func(c *Client)
+
+// WithToken authenticates using token rather than the service account token
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithHTTPClient uses hc to make requests
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.hc = hc }
+}
+
+// New creates a client for the API at url accessing objects in namespace
+func New(url string, namespace string, opts ...Option) *Client {
+	c := &Client{
+		url:       strings.TrimSuffix(url, "/"),
+		namespace: namespace,
+		hc:        &http.Client{Timeout: 10 * time.Second},
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// InCluster creates a client using the service account of the pod, url and namespace default to the in-cluster
+// API service and the namespace of the pod when empty
+func InCluster(url string, namespace string) (*Client, error) {
+	if url == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, fmt.Errorf("not running in a kubernetes cluster, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+		}
+		url = "https://" + net.JoinHostPort(host, port)
+	}
+
+	if namespace == "" {
+		ns, err := os.ReadFile(filepath.Join(ServiceAccountDir, "namespace"))
+		if err != nil {
+			return nil, fmt.Errorf("could not determine the namespace: %w", err)
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+
+	tlsc, err := util.ClientTLSConfig(filepath.Join(ServiceAccountDir, "ca.crt"), "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsc
+
+	c := New(url, namespace, WithHTTPClient(&http.Client{Transport: transport, Timeout: 10 * time.Second}))
+	// projected tokens are rotated so they are read for every request
+	c.tokenFile = filepath.Join(ServiceAccountDir, "token")
+
+	return c, nil
+}
+
+// Namespace is the namespace objects are accessed in
+func (c *Client) Namespace() string {
+	return c.namespace
+}
+
+// Name turns s into a valid object name
+func Name(s string) string {
+	name := invalidNameChars.ReplaceAllString(strings.ToLower(s), "-")
+	name = strings.Trim(name, "-.")
+	if len(name) > 253 {
+		name = strings.Trim(name[:253], "-.")
+	}
+
+	return name
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, in any, out any) error {
+	var body io.Reader
+	if in != nil {
+		j, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(j)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	token := c.token
+	if c.tokenFile != "" {
+		t, err := os.ReadFile(c.tokenFile)
+		if err != nil {
+			return fmt.Errorf("could not read service account token: %w", err)
+		}
+		token = strings.TrimSpace(string(t))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case resp.StatusCode == http.StatusConflict:
+		return ErrConflict
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		status := struct {
+			Message string `json:"message"`
+		}{}
+		json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&status)
+		if status.Message == "" {
+			status.Message = resp.Status
+		}
+		return fmt.Errorf("%s %s failed: %s", method, path, status.Message)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
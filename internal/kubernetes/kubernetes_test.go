@@ -0,0 +1,103 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestKubernetes(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Kubernetes")
+}
+
+var _ = Describe("Kubernetes", func() {
+	now := time.Date(2023, 7, 1, 12, 30, 15, 123456789, time.UTC)
+
+	Describe("Name", func() {
+		It("Should create valid object names", func() {
+			Expect(Name("SR_stream_replicator_ORDERS")).To(Equal("sr-stream-replicator-orders"))
+			Expect(Name("_x.y_")).To(Equal("x.y"))
+			Expect(Name(string(make([]byte, 300)) + "a")).To(Equal("a"))
+		})
+	})
+
+	Describe("MicroTime", func() {
+		It("Should round trip with microsecond precision", func() {
+			j, err := json.Marshal(NewMicroTime(now))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(j)).To(Equal(`"2023-07-01T12:30:15.123456Z"`))
+
+			t := &MicroTime{}
+			Expect(json.Unmarshal(j, t)).To(Succeed())
+			Expect(t.Time).To(Equal(now.Truncate(time.Microsecond)))
+
+			Expect(json.Unmarshal([]byte("null"), t)).To(Succeed())
+			Expect(t.IsZero()).To(BeTrue())
+		})
+	})
+
+	Describe("Lease", func() {
+		It("Should determine expiry", func() {
+			lease := NewLease("test")
+			Expect(lease.Expired(now)).To(BeTrue())
+
+			lease.Spec = LeaseSpec{HolderIdentity: "a", LeaseDurationSeconds: 10, RenewTime: NewMicroTime(now)}
+			Expect(lease.Expired(now.Add(9 * time.Second))).To(BeFalse())
+			Expect(lease.Expired(now.Add(10 * time.Second))).To(BeTrue())
+
+			lease.Spec.HolderIdentity = ""
+			Expect(lease.Expired(now)).To(BeTrue())
+		})
+
+		It("Should manage leases", func() {
+			api := testutil.NewKubernetesAPI()
+			defer api.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			client := New(api.URL(), "ginkgo", WithToken("secret"))
+			Expect(client.Namespace()).To(Equal("ginkgo"))
+
+			_, err := client.GetLease(ctx, "test")
+			Expect(err).To(MatchError(ErrNotFound))
+
+			lease := NewLease("test")
+			lease.Spec = LeaseSpec{HolderIdentity: "a", LeaseDurationSeconds: 10, RenewTime: NewMicroTime(now)}
+			created, err := client.CreateLease(ctx, lease)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(created.Metadata.ResourceVersion).ToNot(BeEmpty())
+			Expect(api.Holder("ginkgo", "test")).To(Equal("a"))
+
+			_, err = client.CreateLease(ctx, lease)
+			Expect(err).To(MatchError(ErrConflict))
+
+			got, err := client.GetLease(ctx, "test")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(got.Spec.RenewTime.Time).To(Equal(now.Truncate(time.Microsecond)))
+
+			got.Spec.HolderIdentity = "b"
+			_, err = client.UpdateLease(ctx, got)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(api.Holder("ginkgo", "test")).To(Equal("b"))
+
+			// stale resource versions conflict
+			_, err = client.UpdateLease(ctx, created)
+			Expect(err).To(MatchError(ErrConflict))
+
+			api.SetFailing(true)
+			_, err = client.GetLease(ctx, "test")
+			Expect(err).To(MatchError("GET /apis/coordination.k8s.io/v1/namespaces/ginkgo/leases/test failed: failing"))
+		})
+	})
+})
@@ -0,0 +1,126 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// MicroTime is a time with microsecond precision as used in Lease objects
+type MicroTime struct {
+	time.Time
+}
+
+const microTimeLayout = "2006-01-02T15:04:05.000000Z07:00"
+
+// NewMicroTime creates a MicroTime for t
+func NewMicroTime(t time.Time) *MicroTime {
+	return &MicroTime{t.UTC().Truncate(time.Microsecond)}
+}
+
+func (t MicroTime) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("%q", t.UTC().Format(microTimeLayout))), nil
+}
+
+func (t *MicroTime) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "" || s == "null" {
+		t.Time = time.Time{}
+		return nil
+	}
+
+	var err error
+	t.Time, err = time.Parse(time.RFC3339Nano, s)
+
+	return err
+}
+
+// ObjectMeta is the metadata of an object
+type ObjectMeta struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace,omitempty"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+// LeaseSpec is the state of a coordination.k8s.io/v1 Lease
+type LeaseSpec struct {
+	HolderIdentity       string     `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int        `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          *MicroTime `json:"acquireTime,omitempty"`
+	RenewTime            *MicroTime `json:"renewTime,omitempty"`
+	LeaseTransitions     int        `json:"leaseTransitions,omitempty"`
+}
+
+// Lease is a coordination.k8s.io/v1 Lease
+type Lease struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   ObjectMeta `json:"metadata"`
+	Spec       LeaseSpec  `json:"spec"`
+}
+
+// NewLease creates a Lease called name
+func NewLease(name string) *Lease {
+	return &Lease{
+		APIVersion: "coordination.k8s.io/v1",
+		Kind:       "Lease",
+		Metadata:   ObjectMeta{Name: name},
+	}
+}
+
+// Expired determines if the lease was not renewed within its duration at t
+func (l *Lease) Expired(t time.Time) bool {
+	if l.Spec.HolderIdentity == "" || l.Spec.RenewTime == nil {
+		return true
+	}
+
+	return !t.Before(l.Spec.RenewTime.Add(time.Duration(l.Spec.LeaseDurationSeconds) * time.Second))
+}
+
+func (c *Client) leasesPath() string {
+	return fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases", url.PathEscape(c.namespace))
+}
+
+// GetLease retrieves the lease called name, ErrNotFound when it does not exist
+func (c *Client) GetLease(ctx context.Context, name string) (*Lease, error) {
+	lease := &Lease{}
+	err := c.do(ctx, http.MethodGet, c.leasesPath()+"/"+url.PathEscape(name), nil, lease)
+	if err != nil {
+		return nil, err
+	}
+
+	return lease, nil
+}
+
+// CreateLease creates lease, ErrConflict when it already exists
+func (c *Client) CreateLease(ctx context.Context, lease *Lease) (*Lease, error) {
+	lease.Metadata.Namespace = c.namespace
+
+	created := &Lease{}
+	err := c.do(ctx, http.MethodPost, c.leasesPath(), lease, created)
+	if err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
+
+// UpdateLease updates lease, ErrConflict when it changed since it was retrieved
+func (c *Client) UpdateLease(ctx context.Context, lease *Lease) (*Lease, error) {
+	lease.Metadata.Namespace = c.namespace
+
+	updated := &Lease{}
+	err := c.do(ctx, http.MethodPut, c.leasesPath()+"/"+url.PathEscape(lease.Metadata.Name), lease, updated)
+	if err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
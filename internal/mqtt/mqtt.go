@@ -0,0 +1,159 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package mqtt subscribes to topics on MQTT brokers using protocol version 3.1.1 or 5
+package mqtt
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/util"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	dialTimeout = 10 * time.Second
+	keepAlive   = 30 * time.Second
+)
+
+// Message is a message received from the broker, it is only acknowledged to the broker once Ack is called
+type Message struct {
+	// Topic is the topic the message was published to
+	Topic string
+	// Payload is the message body
+	Payload []byte
+	// Properties are the MQTT 5 user properties of the message
+	Properties map[string][]string
+
+	ack func() error
+}
+
+// Ack acknowledges the message, messages have to be acknowledged in the order they were received
+func (m *Message) Ack() error {
+	if m.ack == nil {
+		return nil
+	}
+
+	return m.ack()
+}
+
+// Client is a connection to a broker delivering messages for the subscribed topics
+type Client interface {
+	// Messages receives messages in the order the broker sent them
+	Messages() <-chan *Message
+	// Close disconnects from the broker
+	Close() error
+}
+
+// Connect connects to the broker in cfg, through proxy when not empty, and subscribes to the topics. The connection
+// is re-established, and topics subscribed again, until ctx is canceled
+func Connect(ctx context.Context, cfg *config.MQTT, proxy string, log *logrus.Entry) (Client, error) {
+	broker, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	dial, err := dialer(broker, cfg.TLS, proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Version == config.MQTTVersion5 {
+		return connectV5(ctx, cfg, broker, dial, log)
+	}
+
+	return connectV3(ctx, cfg, broker, dial, log)
+}
+
+// SubjectForTopic creates a NATS subject from a MQTT topic or topic filter, levels become tokens and wildcards are
+// converted, characters not valid in subjects are replaced by _
+func SubjectForTopic(topic string) string {
+	// shared subscriptions deliver messages for the topic filter following $share/<group>/
+	if strings.HasPrefix(topic, "$share/") {
+		parts := strings.SplitN(topic, "/", 3)
+		if len(parts) == 3 {
+			topic = parts[2]
+		}
+	}
+
+	levels := strings.Split(topic, "/")
+	for i, level := range levels {
+		switch level {
+		case "":
+			levels[i] = "_"
+		case "+":
+			levels[i] = "*"
+		case "#":
+			levels[i] = ">"
+		default:
+			levels[i] = strings.Map(func(r rune) rune {
+				switch r {
+				case '.', '*', '>', ' ', '\t', '\r', '\n':
+					return '_'
+				default:
+					return r
+				}
+			}, level)
+		}
+	}
+
+	return strings.Join(levels, ".")
+}
+
+// dialer creates a function that connects to broker, through proxy when set and using TLS for tls:// brokers
+func dialer(broker *url.URL, t *config.TLS, proxy string) (func(ctx context.Context) (net.Conn, error), error) {
+	useTLS := false
+	port := "1883"
+	switch broker.Scheme {
+	case "tls", "ssl", "mqtts":
+		useTLS = true
+		port = "8883"
+	}
+
+	address := broker.Host
+	if broker.Port() == "" {
+		address = net.JoinHostPort(broker.Hostname(), port)
+	}
+
+	d := &net.Dialer{Timeout: dialTimeout}
+	dial := d.DialContext
+
+	if proxy != "" {
+		var err error
+		dial, err = util.ProxyDialer(proxy, dialTimeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if useTLS || t != nil {
+		var tlsc *tls.Config
+		var err error
+
+		if t != nil {
+			tlsc, err = util.ClientTLSConfig(t.CA, t.Cert, t.Key)
+		} else {
+			tlsc, err = util.ClientTLSConfig("", "", "")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("mqtt tls: %w", err)
+		}
+
+		dial = util.TLSDialer(dial, tlsc)
+	}
+
+	return func(ctx context.Context) (net.Conn, error) {
+		timeout, cancel := context.WithTimeout(ctx, dialTimeout)
+		defer cancel()
+
+		return dial(timeout, "tcp", address)
+	}, nil
+}
@@ -0,0 +1,31 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mqtt
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestMQTT(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "MQTT")
+}
+
+var _ = Describe("SubjectForTopic", func() {
+	It("Should map topics and filters to subjects", func() {
+		Expect(SubjectForTopic("sensors/room1/temp")).To(Equal("sensors.room1.temp"))
+		Expect(SubjectForTopic("sensors/+/temp")).To(Equal("sensors.*.temp"))
+		Expect(SubjectForTopic("sensors/#")).To(Equal("sensors.>"))
+		Expect(SubjectForTopic("$share/replicators/sensors/#")).To(Equal("sensors.>"))
+	})
+
+	It("Should replace characters not valid in subjects", func() {
+		Expect(SubjectForTopic("/sensors/room 1/v1.2/")).To(Equal("_.sensors.room_1.v1_2._"))
+		Expect(SubjectForTopic("a/b*/c>")).To(Equal("a.b_.c_"))
+	})
+})
@@ -0,0 +1,91 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mqtt
+
+import (
+	"context"
+	"net"
+	"net/url"
+
+	"github.com/choria-io/stream-replicator/config"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/sirupsen/logrus"
+)
+
+// v3Client is a MQTT 3.1.1 client
+type v3Client struct {
+	client paho.Client
+	msgs   chan *Message
+}
+
+func connectV3(ctx context.Context, cfg *config.MQTT, broker *url.URL, dial func(context.Context) (net.Conn, error), log *logrus.Entry) (Client, error) {
+	c := &v3Client{msgs: make(chan *Message, 1000)}
+
+	filters := map[string]byte{}
+	for _, topic := range cfg.Topics {
+		filters[topic] = byte(cfg.QoS)
+	}
+
+	handler := func(_ paho.Client, m paho.Message) {
+		select {
+		case c.msgs <- &Message{Topic: m.Topic(), Payload: m.Payload(), ack: func() error { m.Ack(); return nil }}:
+		case <-ctx.Done():
+		}
+	}
+
+	opts := paho.NewClientOptions().
+		AddBroker(broker.String()).
+		SetProtocolVersion(4).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetCleanSession(cfg.CleanSession).
+		SetKeepAlive(keepAlive).
+		SetOrderMatters(true).
+		SetAutoAckDisabled(true).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(dialTimeout).
+		SetMaxReconnectInterval(dialTimeout).
+		SetCustomOpenConnectionFn(func(_ *url.URL, _ paho.ClientOptions) (net.Conn, error) {
+			return dial(ctx)
+		}).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			log.Warnf("Lost connection to MQTT broker %s: %v", broker.Host, err)
+		}).
+		SetOnConnectHandler(func(client paho.Client) {
+			log.Infof("Connected to MQTT broker %s, subscribing to %v", broker.Host, cfg.Topics)
+
+			token := client.SubscribeMultiple(filters, handler)
+			if token.WaitTimeout(dialTimeout) && token.Error() != nil {
+				log.Errorf("Subscribing to %v failed: %v", cfg.Topics, token.Error())
+			}
+		})
+
+	c.client = paho.NewClient(opts)
+
+	token := c.client.Connect()
+	select {
+	case <-token.Done():
+		if token.Error() != nil {
+			return nil, token.Error()
+		}
+	case <-ctx.Done():
+		c.client.Disconnect(0)
+		return nil, ctx.Err()
+	}
+
+	return c, nil
+}
+
+func (c *v3Client) Messages() <-chan *Message {
+	return c.msgs
+}
+
+func (c *v3Client) Close() error {
+	c.client.Disconnect(250)
+
+	return nil
+}
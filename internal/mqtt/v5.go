@@ -0,0 +1,129 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mqtt
+
+import (
+	"context"
+	"net"
+	"net/url"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/sirupsen/logrus"
+)
+
+// v5Client is a MQTT 5 client
+type v5Client struct {
+	cm   *autopaho.ConnectionManager
+	msgs chan *Message
+}
+
+func connectV5(ctx context.Context, cfg *config.MQTT, broker *url.URL, dial func(context.Context) (net.Conn, error), log *logrus.Entry) (Client, error) {
+	c := &v5Client{msgs: make(chan *Message, 1000)}
+
+	var subs []paho.SubscribeOptions
+	for _, topic := range cfg.Topics {
+		// retained messages are only sent for new subscriptions so reconnects do not copy them again
+		subs = append(subs, paho.SubscribeOptions{Topic: topic, QoS: byte(cfg.QoS), RetainHandling: 1})
+	}
+
+	received := func(pr paho.PublishReceived) (bool, error) {
+		msg := &Message{
+			Topic:   pr.Packet.Topic,
+			Payload: pr.Packet.Payload,
+			ack:     func() error { return pr.Client.Ack(pr.Packet) },
+		}
+
+		if pr.Packet.Properties != nil && len(pr.Packet.Properties.User) > 0 {
+			msg.Properties = map[string][]string{}
+			for _, p := range pr.Packet.Properties.User {
+				msg.Properties[p.Key] = append(msg.Properties[p.Key], p.Value)
+			}
+		}
+
+		select {
+		case c.msgs <- msg:
+		case <-ctx.Done():
+		}
+
+		return true, nil
+	}
+
+	acfg := autopaho.ClientConfig{
+		ServerUrls:                    []*url.URL{broker},
+		KeepAlive:                     uint16(keepAlive.Seconds()),
+		ConnectRetryDelay:             dialTimeout,
+		ConnectTimeout:                dialTimeout,
+		CleanStartOnInitialConnection: cfg.CleanSession,
+		ConnectUsername:               cfg.Username,
+		ConnectPassword:               []byte(cfg.Password),
+		AttemptConnection: func(ctx context.Context, _ autopaho.ClientConfig, _ *url.URL) (net.Conn, error) {
+			conn, err := dial(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			return packets.NewThreadSafeConn(conn), nil
+		},
+		ConnectPacketBuilder: func(cp *paho.Connect, _ *url.URL) *paho.Connect {
+			// properties default to not requesting problem information, brokers then may omit user properties
+			if cp.Properties == nil {
+				cp.Properties = &paho.ConnectProperties{}
+			}
+			cp.Properties.RequestProblemInfo = true
+
+			return cp
+		},
+		OnConnectionUp: func(cm *autopaho.ConnectionManager, _ *paho.Connack) {
+			log.Infof("Connected to MQTT broker %s, subscribing to %v", broker.Host, cfg.Topics)
+
+			_, err := cm.Subscribe(ctx, &paho.Subscribe{Subscriptions: subs})
+			if err != nil {
+				log.Errorf("Subscribing to %v failed: %v", cfg.Topics, err)
+			}
+		},
+		OnConnectError: func(err error) {
+			log.Warnf("Connecting to MQTT broker %s failed: %v", broker.Host, err)
+		},
+		ClientConfig: paho.ClientConfig{
+			ClientID:                   cfg.ClientID,
+			EnableManualAcknowledgment: true,
+			OnPublishReceived:          []func(paho.PublishReceived) (bool, error){received},
+			OnClientError: func(err error) {
+				log.Warnf("MQTT client error: %v", err)
+			},
+		},
+	}
+
+	if !cfg.CleanSession {
+		acfg.SessionExpiryInterval = uint32(cfg.SessionExpiry.Seconds())
+	}
+
+	var err error
+	c.cm, err = autopaho.NewConnection(ctx, acfg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.cm.AwaitConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *v5Client) Messages() <-chan *Message {
+	return c.msgs
+}
+
+func (c *v5Client) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+
+	return c.cm.Disconnect(ctx)
+}
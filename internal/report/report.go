@@ -0,0 +1,344 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package report keeps a rolling history of replication statistics and produces
+// summaries of activity over a time window from it
+package report
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Summary is a summary of replication activity over a time window
+type Summary struct {
+	Replicator string    `json:"replicator"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Seconds    float64   `json:"seconds"`
+	Streams    []*Stream `json:"streams"`
+}
+
+// Stream is the activity of a single stream replication in a Summary
+type Stream struct {
+	Stream           string            `json:"stream"`
+	Name             string            `json:"name"`
+	ReceivedMessages uint64            `json:"received_messages"`
+	ReceivedBytes    uint64            `json:"received_bytes"`
+	CopiedMessages   uint64            `json:"copied_messages"`
+	CopiedBytes      uint64            `json:"copied_bytes"`
+	SkippedMessages  uint64            `json:"skipped_messages"`
+	SkippedBytes     uint64            `json:"skipped_bytes"`
+	TooOldMessages   uint64            `json:"too_old_messages"`
+	StreamSequence   uint64            `json:"stream_sequence"`
+	Pending          uint64            `json:"pending_messages"`
+	MaxPending       uint64            `json:"max_pending_messages"`
+	Advisories       map[string]uint64 `json:"advisories"`
+	Errors           Errors            `json:"errors"`
+}
+
+// Errors are the errors encountered by a stream replication in a Summary
+type Errors struct {
+	Handler           uint64 `json:"handler"`
+	Ack               uint64 `json:"ack"`
+	MetaParse         uint64 `json:"meta_parse"`
+	ConsumerRecreated uint64 `json:"consumer_recreated"`
+	AdvisoryPublish   uint64 `json:"advisory_publish"`
+}
+
+const (
+	receivedMessagesMetric  = "choria_stream_replicator_replicator_total_messages"
+	receivedBytesMetric     = "choria_stream_replicator_replicator_total_bytes"
+	copiedMessagesMetric    = "choria_stream_replicator_replicator_copied_messages"
+	copiedBytesMetric       = "choria_stream_replicator_replicator_copied_bytes"
+	skippedMessagesMetric   = "choria_stream_replicator_replicator_skipped_messages"
+	skippedBytesMetric      = "choria_stream_replicator_replicator_skipped_bytes"
+	tooOldMessagesMetric    = "choria_stream_replicator_replicator_too_old_messages"
+	streamSequenceMetric    = "choria_stream_replicator_replicator_stream_sequence"
+	pendingMessagesMetric   = "choria_stream_replicator_replicator_pending_messages"
+	handlerErrorsMetric     = "choria_stream_replicator_replicator_handler_error_count"
+	ackErrorsMetric         = "choria_stream_replicator_replicator_ack_failed_count"
+	metaParseErrorsMetric   = "choria_stream_replicator_replicator_meta_parse_failed_count"
+	consumerRecreatedMetric = "choria_stream_replicator_replicator_consumer_recreated"
+	advisoriesMetric        = "choria_stream_replicator_advisor_total_messages"
+	advisoryErrorsMetric    = "choria_stream_replicator_advisor_publish_errors"
+)
+
+// streamFields maps per stream metrics to the field in Stream they are recorded in
+var streamFields = map[string]func(s *Stream) *uint64{
+	receivedMessagesMetric:  func(s *Stream) *uint64 { return &s.ReceivedMessages },
+	receivedBytesMetric:     func(s *Stream) *uint64 { return &s.ReceivedBytes },
+	copiedMessagesMetric:    func(s *Stream) *uint64 { return &s.CopiedMessages },
+	copiedBytesMetric:       func(s *Stream) *uint64 { return &s.CopiedBytes },
+	skippedMessagesMetric:   func(s *Stream) *uint64 { return &s.SkippedMessages },
+	skippedBytesMetric:      func(s *Stream) *uint64 { return &s.SkippedBytes },
+	tooOldMessagesMetric:    func(s *Stream) *uint64 { return &s.TooOldMessages },
+	streamSequenceMetric:    func(s *Stream) *uint64 { return &s.StreamSequence },
+	pendingMessagesMetric:   func(s *Stream) *uint64 { return &s.Pending },
+	handlerErrorsMetric:     func(s *Stream) *uint64 { return &s.Errors.Handler },
+	ackErrorsMetric:         func(s *Stream) *uint64 { return &s.Errors.Ack },
+	metaParseErrorsMetric:   func(s *Stream) *uint64 { return &s.Errors.MetaParse },
+	consumerRecreatedMetric: func(s *Stream) *uint64 { return &s.Errors.ConsumerRecreated },
+}
+
+type streamKey struct {
+	stream string
+	name   string
+}
+
+type snapshot struct {
+	time    time.Time
+	streams map[streamKey]*Stream
+	// advisories are only known per source stream
+	advisories     map[string]map[string]uint64
+	advisoryErrors map[string]uint64
+}
+
+// History periodically records replication statistics
+type History struct {
+	replicator string
+	gatherer   prometheus.Gatherer
+	interval   time.Duration
+	retention  time.Duration
+	snapshots  []*snapshot
+	mu         sync.Mutex
+}
+
+// NewHistory creates a History recording statistics for replicator every interval and keeping retention worth of history
+func NewHistory(replicator string, gatherer prometheus.Gatherer, interval time.Duration, retention time.Duration) *History {
+	if gatherer == nil {
+		gatherer = prometheus.DefaultGatherer
+	}
+
+	return &History{
+		replicator: replicator,
+		gatherer:   gatherer,
+		interval:   interval,
+		retention:  retention,
+	}
+}
+
+// Run records statistics until ctx is canceled
+func (h *History) Run(ctx context.Context) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		h.Record()
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Record takes a snapshot of current statistics and expires old ones
+func (h *History) Record() error {
+	snap, err := h.snapshot()
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.snapshots = append(h.snapshots, snap)
+
+	cutoff := snap.time.Add(-h.retention)
+	for len(h.snapshots) > 1 && h.snapshots[0].time.Before(cutoff) {
+		h.snapshots = h.snapshots[1:]
+	}
+
+	return nil
+}
+
+// Report produces a summary of activity during the window up to now, when less history is
+// known than the window the report covers the time since the oldest record
+func (h *History) Report(window time.Duration) (*Summary, error) {
+	current, err := h.snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	start := current.time.Add(-window)
+	var base *snapshot
+	maxPending := make(map[streamKey]uint64)
+
+	for _, snap := range h.snapshots {
+		if snap.time.Before(start) {
+			continue
+		}
+
+		if base == nil {
+			base = snap
+		}
+
+		for k, s := range snap.streams {
+			if s.Pending > maxPending[k] {
+				maxPending[k] = s.Pending
+			}
+		}
+	}
+
+	if base == nil {
+		base = &snapshot{time: current.time}
+	}
+
+	report := &Summary{
+		Replicator: h.replicator,
+		Start:      base.time,
+		End:        current.time,
+		Seconds:    current.time.Sub(base.time).Seconds(),
+		Streams:    []*Stream{},
+	}
+
+	for k, cur := range current.streams {
+		prev, ok := base.streams[k]
+		if !ok {
+			prev = &Stream{}
+		}
+
+		s := &Stream{
+			Stream:           k.stream,
+			Name:             k.name,
+			ReceivedMessages: delta(cur.ReceivedMessages, prev.ReceivedMessages),
+			ReceivedBytes:    delta(cur.ReceivedBytes, prev.ReceivedBytes),
+			CopiedMessages:   delta(cur.CopiedMessages, prev.CopiedMessages),
+			CopiedBytes:      delta(cur.CopiedBytes, prev.CopiedBytes),
+			SkippedMessages:  delta(cur.SkippedMessages, prev.SkippedMessages),
+			SkippedBytes:     delta(cur.SkippedBytes, prev.SkippedBytes),
+			TooOldMessages:   delta(cur.TooOldMessages, prev.TooOldMessages),
+			StreamSequence:   cur.StreamSequence,
+			Pending:          cur.Pending,
+			MaxPending:       maxPending[k],
+			Advisories:       make(map[string]uint64),
+			Errors: Errors{
+				Handler:           delta(cur.Errors.Handler, prev.Errors.Handler),
+				Ack:               delta(cur.Errors.Ack, prev.Errors.Ack),
+				MetaParse:         delta(cur.Errors.MetaParse, prev.Errors.MetaParse),
+				ConsumerRecreated: delta(cur.Errors.ConsumerRecreated, prev.Errors.ConsumerRecreated),
+				AdvisoryPublish:   delta(current.advisoryErrors[k.stream], base.advisoryErrors[k.stream]),
+			},
+		}
+
+		if cur.Pending > s.MaxPending {
+			s.MaxPending = cur.Pending
+		}
+
+		for event, cnt := range current.advisories[k.stream] {
+			if d := delta(cnt, base.advisories[k.stream][event]); d > 0 {
+				s.Advisories[event] = d
+			}
+		}
+
+		report.Streams = append(report.Streams, s)
+	}
+
+	sort.Slice(report.Streams, func(i, j int) bool {
+		if report.Streams[i].Stream == report.Streams[j].Stream {
+			return report.Streams[i].Name < report.Streams[j].Name
+		}
+		return report.Streams[i].Stream < report.Streams[j].Stream
+	})
+
+	return report, nil
+}
+
+func delta(cur uint64, prev uint64) uint64 {
+	if prev > cur {
+		return cur
+	}
+
+	return cur - prev
+}
+
+func metricValue(m *dto.Metric) uint64 {
+	switch {
+	case m.Counter != nil:
+		return uint64(m.Counter.GetValue())
+	case m.Gauge != nil:
+		return uint64(m.Gauge.GetValue())
+	default:
+		return 0
+	}
+}
+
+func labels(m *dto.Metric) map[string]string {
+	res := make(map[string]string, len(m.Label))
+	for _, l := range m.Label {
+		res[l.GetName()] = l.GetValue()
+	}
+
+	return res
+}
+
+func (h *History) snapshot() (*snapshot, error) {
+	families, err := h.gatherer.Gather()
+	if err != nil {
+		return nil, fmt.Errorf("could not gather statistics: %v", err)
+	}
+
+	snap := &snapshot{
+		time:           time.Now(),
+		streams:        make(map[streamKey]*Stream),
+		advisories:     make(map[string]map[string]uint64),
+		advisoryErrors: make(map[string]uint64),
+	}
+
+	for _, family := range families {
+		for _, m := range family.Metric {
+			l := labels(m)
+			if l["replicator"] != h.replicator {
+				continue
+			}
+
+			v := metricValue(m)
+
+			switch family.GetName() {
+			case advisoriesMetric:
+				if snap.advisories[l["stream"]] == nil {
+					snap.advisories[l["stream"]] = make(map[string]uint64)
+				}
+				snap.advisories[l["stream"]][l["advisory"]] += v
+				continue
+
+			case advisoryErrorsMetric:
+				snap.advisoryErrors[l["stream"]] += v
+				continue
+			}
+
+			field, ok := streamFields[family.GetName()]
+			if !ok {
+				continue
+			}
+
+			*field(snap.stream(l["stream"], l["worker"])) = v
+		}
+	}
+
+	return snap, nil
+}
+
+func (s *snapshot) stream(stream string, name string) *Stream {
+	k := streamKey{stream: stream, name: name}
+
+	st, ok := s.streams[k]
+	if !ok {
+		st = &Stream{Stream: stream, Name: name}
+		s.streams[k] = st
+	}
+
+	return st
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package report
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestReport(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Report")
+}
+
+var _ = Describe("History", func() {
+	var (
+		reg      *prometheus.Registry
+		copied   *prometheus.CounterVec
+		pending  *prometheus.GaugeVec
+		advisory *prometheus.CounterVec
+		hist     *History
+	)
+
+	BeforeEach(func() {
+		reg = prometheus.NewRegistry()
+		copied = prometheus.NewCounterVec(prometheus.CounterOpts{Name: copiedMessagesMetric}, []string{"stream", "replicator", "worker"})
+		pending = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: pendingMessagesMetric}, []string{"stream", "replicator", "worker"})
+		advisory = prometheus.NewCounterVec(prometheus.CounterOpts{Name: advisoriesMetric}, []string{"advisory", "stream", "replicator"})
+		reg.MustRegister(copied, pending, advisory)
+
+		hist = NewHistory("TEST", reg, time.Minute, time.Hour)
+	})
+
+	Describe("Report", func() {
+		It("Should report activity since the start of the window", func() {
+			copied.WithLabelValues("ORDERS", "TEST", "W1").Add(10)
+			copied.WithLabelValues("ORDERS", "OTHER", "W1").Add(10)
+			pending.WithLabelValues("ORDERS", "TEST", "W1").Set(100)
+			Expect(hist.Record()).To(Succeed())
+
+			copied.WithLabelValues("ORDERS", "TEST", "W1").Add(5)
+			copied.WithLabelValues("ORDERS", "TEST", "W2").Add(2)
+			pending.WithLabelValues("ORDERS", "TEST", "W1").Set(20)
+			advisory.WithLabelValues("timeout", "ORDERS", "TEST").Add(3)
+
+			rep, err := hist.Report(time.Hour)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rep.Replicator).To(Equal("TEST"))
+			Expect(rep.Streams).To(HaveLen(2))
+
+			Expect(rep.Streams[0].Stream).To(Equal("ORDERS"))
+			Expect(rep.Streams[0].Name).To(Equal("W1"))
+			Expect(rep.Streams[0].CopiedMessages).To(Equal(uint64(5)))
+			Expect(rep.Streams[0].Pending).To(Equal(uint64(20)))
+			Expect(rep.Streams[0].MaxPending).To(Equal(uint64(100)))
+			Expect(rep.Streams[0].Advisories).To(Equal(map[string]uint64{"timeout": 3}))
+
+			Expect(rep.Streams[1].Name).To(Equal("W2"))
+			Expect(rep.Streams[1].CopiedMessages).To(Equal(uint64(2)))
+		})
+
+		It("Should ignore records older than the window", func() {
+			copied.WithLabelValues("ORDERS", "TEST", "W1").Add(10)
+			Expect(hist.Record()).To(Succeed())
+			hist.snapshots[0].time = time.Now().Add(-2 * time.Hour)
+
+			copied.WithLabelValues("ORDERS", "TEST", "W1").Add(5)
+			Expect(hist.Record()).To(Succeed())
+
+			copied.WithLabelValues("ORDERS", "TEST", "W1").Add(1)
+
+			rep, err := hist.Report(time.Hour)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rep.Streams[0].CopiedMessages).To(Equal(uint64(1)))
+		})
+	})
+
+	Describe("Record", func() {
+		It("Should expire old records", func() {
+			Expect(hist.Record()).To(Succeed())
+			hist.snapshots[0].time = time.Now().Add(-2 * time.Hour)
+			Expect(hist.Record()).To(Succeed())
+			Expect(hist.Record()).To(Succeed())
+			Expect(hist.snapshots).To(HaveLen(2))
+		})
+	})
+})
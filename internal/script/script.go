@@ -0,0 +1,211 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package script routes, filters and changes the headers of messages using Lua scripts, see
+// https://www.lua.org/manual/5.1/
+package script
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// DefaultFunction is the function called for every message when none is configured
+const DefaultFunction = "process"
+
+// Script calls a function in a Lua script for messages, states are pooled so messages can be handled concurrently
+type Script struct {
+	proto    *lua.FunctionProto
+	function string
+	timeout  time.Duration
+	states   sync.Pool
+}
+
+// New compiles the script in file, function is called with every message and has to be defined by the script
+func New(file string, function string, timeout time.Duration) (*Script, error) {
+	if function == "" {
+		function = DefaultFunction
+	}
+
+	body, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	chunk, err := parse.Parse(bytes.NewReader(body), file)
+	if err != nil {
+		return nil, fmt.Errorf("invalid script: %v", err)
+	}
+
+	s := &Script{function: function, timeout: timeout}
+	s.proto, err = lua.Compile(chunk, file)
+	if err != nil {
+		return nil, fmt.Errorf("invalid script: %v", err)
+	}
+
+	// creating a state runs the script once and ensures it defines the function
+	state, err := s.newState()
+	if err != nil {
+		return nil, err
+	}
+	s.states.Put(state)
+
+	return s, nil
+}
+
+// newState creates a state that loaded the script, only libraries without access to the system are available
+func (s *Script) newState() (*lua.LState, error) {
+	state := lua.NewState(lua.Options{SkipOpenLibs: true})
+
+	libs := []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	}
+
+	for _, lib := range libs {
+		state.Push(state.NewFunction(lib.open))
+		state.Push(lua.LString(lib.name))
+		state.Call(1, 0)
+	}
+
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring", "require", "module"} {
+		state.SetGlobal(name, lua.LNil)
+	}
+
+	state.Push(state.NewFunctionFromProto(s.proto))
+	err := s.call(state, 0, 0)
+	if err != nil {
+		state.Close()
+		return nil, fmt.Errorf("loading script failed: %v", err)
+	}
+
+	if state.GetGlobal(s.function).Type() != lua.LTFunction {
+		state.Close()
+		return nil, fmt.Errorf("script does not define the function %s", s.function)
+	}
+
+	return state, nil
+}
+
+// call calls the function on the stack of state bounded by the timeout
+func (s *Script) call(state *lua.LState, nargs int, nret int) error {
+	if s.timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		defer cancel()
+
+		state.SetContext(ctx)
+		defer state.RemoveContext()
+	}
+
+	return state.PCall(nargs, nret, nil)
+}
+
+// Apply calls the function with msg, it can change the subject and headers of msg and returns false when msg should
+// not be copied
+func (s *Script) Apply(msg *nats.Msg) (bool, error) {
+	state, ok := s.states.Get().(*lua.LState)
+	if !ok {
+		var err error
+		state, err = s.newState()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	headers := state.NewTable()
+	for k := range msg.Header {
+		headers.RawSetString(k, lua.LString(msg.Header.Get(k)))
+	}
+
+	m := state.NewTable()
+	m.RawSetString("subject", lua.LString(msg.Subject))
+	m.RawSetString("data", lua.LString(msg.Data))
+	m.RawSetString("headers", headers)
+
+	state.Push(state.GetGlobal(s.function))
+	state.Push(m)
+	err := s.call(state, 1, 1)
+	if err != nil {
+		// the state might be left in any condition, a new one is created for later messages
+		state.Close()
+		return false, fmt.Errorf("script failed: %v", err)
+	}
+
+	keep := lua.LVAsBool(state.Get(-1))
+	state.Pop(1)
+
+	if keep {
+		err = s.update(msg, m)
+	}
+	s.states.Put(state)
+
+	if err != nil {
+		return false, err
+	}
+
+	return keep, nil
+}
+
+// update applies the subject and header changes the script made in m to msg
+func (s *Script) update(msg *nats.Msg, m *lua.LTable) error {
+	subject, ok := m.RawGetString("subject").(lua.LString)
+	if !ok || subject == "" || strings.ContainsAny(string(subject), " \t\r\n") {
+		return fmt.Errorf("script set an invalid subject")
+	}
+	msg.Subject = string(subject)
+
+	headers, ok := m.RawGetString("headers").(*lua.LTable)
+	if !ok {
+		return fmt.Errorf("script set invalid headers")
+	}
+
+	for k := range msg.Header {
+		if headers.RawGetString(k) == lua.LNil {
+			msg.Header.Del(k)
+		}
+	}
+
+	var err error
+	headers.ForEach(func(k lua.LValue, v lua.LValue) {
+		key, kok := k.(lua.LString)
+		if !kok {
+			err = fmt.Errorf("script set a header with an invalid name")
+			return
+		}
+
+		// numbers and booleans are converted, unchanged headers keep all their values
+		switch v.Type() {
+		case lua.LTString, lua.LTNumber, lua.LTBool:
+		default:
+			err = fmt.Errorf("script set header %s to an invalid value", key)
+			return
+		}
+
+		val := v.String()
+		if msg.Header.Get(string(key)) == val {
+			return
+		}
+
+		if msg.Header == nil {
+			msg.Header = nats.Header{}
+		}
+		msg.Header.Set(string(key), val)
+	})
+
+	return err
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package script
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestScript(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Script")
+}
+
+var _ = Describe("Script", func() {
+	load := func(body string) (*Script, error) {
+		file := filepath.Join(GinkgoT().TempDir(), "script.lua")
+		Expect(os.WriteFile(file, []byte(body), 0600)).To(Succeed())
+
+		return New(file, "", 100*time.Millisecond)
+	}
+
+	message := func() *nats.Msg {
+		msg := nats.NewMsg("orders.eu")
+		msg.Data = []byte(`{"id":1}`)
+		msg.Header.Add("Region", "eu")
+		msg.Header.Add("Region", "fr")
+		msg.Header.Set("Internal", "1")
+
+		return msg
+	}
+
+	Describe("New", func() {
+		It("Should detect invalid scripts", func() {
+			_, err := New(filepath.Join(GinkgoT().TempDir(), "missing.lua"), "", time.Second)
+			Expect(err).To(MatchError(os.ErrNotExist))
+
+			_, err = load("function process(msg")
+			Expect(err).To(MatchError(ContainSubstring("invalid script")))
+
+			_, err = load("error('failed')")
+			Expect(err).To(MatchError(ContainSubstring("loading script failed")))
+
+			_, err = load("function other(msg) return true end")
+			Expect(err).To(MatchError("script does not define the function process"))
+		})
+
+		It("Should not give access to the system", func() {
+			for _, body := range []string{
+				"os.exit(1)",
+				"io.open('/etc/passwd')",
+				"dofile('/etc/passwd')",
+				"require('os')",
+			} {
+				_, err := load(body + "\nfunction process(msg) return true end")
+				Expect(err).To(MatchError(ContainSubstring("loading script failed")), body)
+			}
+		})
+	})
+
+	Describe("Apply", func() {
+		It("Should filter messages", func() {
+			s, err := load(`function process(msg) return msg.headers["Region"] == "eu" and string.find(msg.data, '"id"') ~= nil end`)
+			Expect(err).ToNot(HaveOccurred())
+
+			keep, err := s.Apply(message())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keep).To(BeTrue())
+
+			msg := message()
+			msg.Header.Del("Region")
+			keep, err = s.Apply(msg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keep).To(BeFalse())
+		})
+
+		It("Should route messages and change headers", func() {
+			s, err := load(`
+function process(msg)
+  msg.subject = "routed." .. msg.subject
+  msg.headers["Internal"] = nil
+  msg.headers["Attempt"] = 1
+  msg.headers["Routed"] = "yes"
+  return true
+end`)
+			Expect(err).ToNot(HaveOccurred())
+
+			msg := message()
+			keep, err := s.Apply(msg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keep).To(BeTrue())
+			Expect(msg.Subject).To(Equal("routed.orders.eu"))
+			Expect(msg.Header).To(Equal(nats.Header{"Region": {"eu", "fr"}, "Attempt": {"1"}, "Routed": {"yes"}}))
+			Expect(msg.Data).To(Equal([]byte(`{"id":1}`)))
+		})
+
+		It("Should not change dropped messages", func() {
+			s, err := load(`function process(msg) msg.subject = "other" return false end`)
+			Expect(err).ToNot(HaveOccurred())
+
+			msg := message()
+			keep, err := s.Apply(msg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keep).To(BeFalse())
+			Expect(msg.Subject).To(Equal("orders.eu"))
+		})
+
+		It("Should fail for invalid changes", func() {
+			s, err := load(`
+function process(msg)
+  if msg.data == "subject" then msg.subject = "a b" end
+  if msg.data == "header" then msg.headers["Bad"] = {} end
+  if msg.data == "error" then error("failed") end
+  return true
+end`)
+			Expect(err).ToNot(HaveOccurred())
+
+			for data, expected := range map[string]string{
+				"subject": "script set an invalid subject",
+				"header":  "script set header Bad to an invalid value",
+				"error":   "script failed",
+			} {
+				msg := message()
+				msg.Data = []byte(data)
+				keep, err := s.Apply(msg)
+				Expect(err).To(MatchError(ContainSubstring(expected)))
+				Expect(keep).To(BeFalse())
+			}
+
+			keep, err := s.Apply(message())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keep).To(BeTrue())
+		})
+
+		It("Should stop slow scripts", func() {
+			s, err := load(`function process(msg) while true do end end`)
+			Expect(err).ToNot(HaveOccurred())
+
+			keep, err := s.Apply(message())
+			Expect(err).To(MatchError(ContainSubstring("script failed")))
+			Expect(keep).To(BeFalse())
+		})
+
+		It("Should handle messages concurrently", func() {
+			s, err := load(`
+count = 0
+function process(msg)
+  count = count + 1
+  msg.headers["Count"] = count
+  return true
+end`)
+			Expect(err).ToNot(HaveOccurred())
+
+			wg := sync.WaitGroup{}
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+
+					for i := 0; i < 100; i++ {
+						keep, err := s.Apply(message())
+						Expect(err).ToNot(HaveOccurred())
+						Expect(keep).To(BeTrue())
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	})
+})
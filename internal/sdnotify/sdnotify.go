@@ -0,0 +1,107 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package sdnotify implements the systemd sd_notify protocol used to signal
+// readiness and to pet the service watchdog, all functions are noops when not
+// running under systemd
+package sdnotify
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// ReadyMessage tells systemd that startup is complete
+	ReadyMessage = "READY=1"
+	// StoppingMessage tells systemd that the service is shutting down
+	StoppingMessage = "STOPPING=1"
+	// WatchdogMessage pets the systemd watchdog
+	WatchdogMessage = "WATCHDOG=1"
+)
+
+// Enabled determines if the process is being managed by systemd with notify support
+func Enabled() bool {
+	return os.Getenv("NOTIFY_SOCKET") != ""
+}
+
+// Notify sends state to systemd, it does nothing when systemd did not request notifications
+func Notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	addr := &net.UnixAddr{Name: socket, Net: "unixgram"}
+	// abstract sockets are indicated using a leading @
+	if socket[0] == '@' {
+		addr.Name = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		return fmt.Errorf("could not connect to the systemd notify socket: %w", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	if err != nil {
+		return fmt.Errorf("could not notify systemd: %w", err)
+	}
+
+	return nil
+}
+
+// Ready notifies systemd that the service is ready
+func Ready() error {
+	return Notify(ReadyMessage)
+}
+
+// Stopping notifies systemd that the service is shutting down
+func Stopping() error {
+	return Notify(StoppingMessage)
+}
+
+// Status sets a free form status that will be shown in systemctl status
+func Status(format string, a ...any) error {
+	return Notify("STATUS=" + fmt.Sprintf(format, a...))
+}
+
+// Watchdog pets the systemd watchdog
+func Watchdog() error {
+	return Notify(WatchdogMessage)
+}
+
+// WatchdogInterval is the interval the service has to pet the watchdog in, 0 when the watchdog is not enabled
+func WatchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+
+	// when set the watchdog is only meant for a specific process
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" {
+		p, err := strconv.Atoi(pid)
+		if err != nil {
+			return 0, fmt.Errorf("invalid WATCHDOG_PID: %w", err)
+		}
+
+		if p != os.Getpid() {
+			return 0, nil
+		}
+	}
+
+	us, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid WATCHDOG_USEC: %w", err)
+	}
+	if us <= 0 {
+		return 0, fmt.Errorf("invalid WATCHDOG_USEC: %d", us)
+	}
+
+	return time.Duration(us) * time.Microsecond, nil
+}
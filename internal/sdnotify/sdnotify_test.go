@@ -0,0 +1,78 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestSDNotify(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "SDNotify")
+}
+
+var _ = Describe("SDNotify", func() {
+	BeforeEach(func() {
+		os.Unsetenv("NOTIFY_SOCKET")
+		os.Unsetenv("WATCHDOG_USEC")
+		os.Unsetenv("WATCHDOG_PID")
+	})
+
+	Describe("Notify", func() {
+		It("Should do nothing without a socket", func() {
+			Expect(Enabled()).To(BeFalse())
+			Expect(Ready()).To(Succeed())
+		})
+
+		It("Should send the state to the socket", func() {
+			td := GinkgoT().TempDir()
+			sock := filepath.Join(td, "notify")
+
+			conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sock, Net: "unixgram"})
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+
+			os.Setenv("NOTIFY_SOCKET", sock)
+			defer os.Unsetenv("NOTIFY_SOCKET")
+
+			Expect(Enabled()).To(BeTrue())
+			Expect(Ready()).To(Succeed())
+
+			buf := make([]byte, 100)
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			n, err := conn.Read(buf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(buf[:n])).To(Equal(ReadyMessage))
+		})
+	})
+
+	Describe("WatchdogInterval", func() {
+		It("Should be disabled by default", func() {
+			Expect(WatchdogInterval()).To(Equal(time.Duration(0)))
+		})
+
+		It("Should parse the interval", func() {
+			os.Setenv("WATCHDOG_USEC", "30000000")
+			defer os.Unsetenv("WATCHDOG_USEC")
+
+			Expect(WatchdogInterval()).To(Equal(30 * time.Second))
+
+			os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+			defer os.Unsetenv("WATCHDOG_PID")
+			Expect(WatchdogInterval()).To(Equal(30 * time.Second))
+
+			os.Setenv("WATCHDOG_PID", "1")
+			Expect(WatchdogInterval()).To(Equal(time.Duration(0)))
+		})
+	})
+})
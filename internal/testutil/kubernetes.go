@@ -0,0 +1,153 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package testutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// KubernetesAPI is a fake Kubernetes API server storing coordination.k8s.io/v1 Leases in memory
+type KubernetesAPI struct {
+	srv     *httptest.Server
+	leases  map[string]map[string]any
+	version int
+	fail    bool
+	mu      sync.Mutex
+}
+
+// NewKubernetesAPI starts a fake Kubernetes API server, Close has to be called to stop it
+func NewKubernetesAPI() *KubernetesAPI {
+	k := &KubernetesAPI{leases: make(map[string]map[string]any)}
+	k.srv = httptest.NewServer(http.HandlerFunc(k.handle))
+
+	return k
+}
+
+// URL is the url of the API server
+func (k *KubernetesAPI) URL() string {
+	return k.srv.URL
+}
+
+// Close stops the API server
+func (k *KubernetesAPI) Close() {
+	k.srv.Close()
+}
+
+// SetFailing makes all requests fail with an internal server error while fail is true
+func (k *KubernetesAPI) SetFailing(fail bool) {
+	k.mu.Lock()
+	k.fail = fail
+	k.mu.Unlock()
+}
+
+// Holder is the holder identity of the Lease name in namespace, empty when it does not exist or is not held
+func (k *KubernetesAPI) Holder(namespace string, name string) string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	lease, ok := k.leases[namespace+"/"+name]
+	if !ok {
+		return ""
+	}
+
+	spec, _ := lease["spec"].(map[string]any)
+	holder, _ := spec["holderIdentity"].(string)
+
+	return holder
+}
+
+func (k *KubernetesAPI) handle(w http.ResponseWriter, r *http.Request) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.fail {
+		k.status(w, http.StatusInternalServerError, "failing")
+		return
+	}
+
+	// /apis/coordination.k8s.io/v1/namespaces/<ns>/leases[/<name>]
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 6 || parts[0] != "apis" || parts[1] != "coordination.k8s.io" || parts[3] != "namespaces" || parts[5] != "leases" {
+		k.status(w, http.StatusNotFound, "unknown resource")
+		return
+	}
+	namespace := parts[4]
+
+	var name string
+	if len(parts) == 7 {
+		name = parts[6]
+	}
+
+	var body map[string]any
+	if r.Method == http.MethodPost || r.Method == http.MethodPut {
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			k.status(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		meta, _ := body["metadata"].(map[string]any)
+		if meta == nil {
+			k.status(w, http.StatusBadRequest, "metadata is required")
+			return
+		}
+		if name == "" {
+			name, _ = meta["name"].(string)
+		}
+	}
+
+	key := namespace + "/" + name
+	current, exists := k.leases[key]
+
+	switch r.Method {
+	case http.MethodGet:
+		if !exists {
+			k.status(w, http.StatusNotFound, "not found")
+			return
+		}
+		json.NewEncoder(w).Encode(current)
+
+	case http.MethodPost:
+		if exists {
+			k.status(w, http.StatusConflict, "already exists")
+			return
+		}
+		k.store(w, key, body)
+
+	case http.MethodPut:
+		if !exists {
+			k.status(w, http.StatusNotFound, "not found")
+			return
+		}
+
+		meta := body["metadata"].(map[string]any)
+		if meta["resourceVersion"] != current["metadata"].(map[string]any)["resourceVersion"] {
+			k.status(w, http.StatusConflict, "the object has been modified")
+			return
+		}
+		k.store(w, key, body)
+
+	default:
+		k.status(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (k *KubernetesAPI) store(w http.ResponseWriter, key string, lease map[string]any) {
+	k.version++
+	lease["metadata"].(map[string]any)["resourceVersion"] = strconv.Itoa(k.version)
+	k.leases[key] = lease
+
+	json.NewEncoder(w).Encode(lease)
+}
+
+func (k *KubernetesAPI) status(w http.ResponseWriter, code int, message string) {
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{"kind": "Status", "code": code, "message": message})
+}
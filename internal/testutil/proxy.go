@@ -0,0 +1,179 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package testutil
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Proxy forwards TCP connections to a server while allowing tests to partition or delay the traffic, clients
+// connect to URL() in place of the server
+type Proxy struct {
+	target      string
+	listener    net.Listener
+	conns       map[net.Conn]net.Conn
+	partitioned bool
+	latency     time.Duration
+	accepted    int
+	mu          sync.Mutex
+	wg          sync.WaitGroup
+}
+
+// NewProxy starts a proxy forwarding to target, a host:port or nats:// url
+func NewProxy(target string) (*Proxy, error) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Proxy{
+		target:   strings.TrimPrefix(target, "nats://"),
+		listener: l,
+		conns:    make(map[net.Conn]net.Conn),
+	}
+
+	p.wg.Add(1)
+	go p.accept()
+
+	return p, nil
+}
+
+// URL is the url clients connect to
+func (p *Proxy) URL() string {
+	return fmt.Sprintf("nats://%s", p.listener.Addr().String())
+}
+
+// Partition closes all connections and closes new ones as they are accepted until Heal is called
+func (p *Proxy) Partition() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.partitioned = true
+	for client, server := range p.conns {
+		client.Close()
+		server.Close()
+		delete(p.conns, client)
+	}
+}
+
+// Heal allows connections again after Partition
+func (p *Proxy) Heal() {
+	p.mu.Lock()
+	p.partitioned = false
+	p.mu.Unlock()
+}
+
+// SetLatency delays all data forwarded in either direction by d, 0 disables delays
+func (p *Proxy) SetLatency(d time.Duration) {
+	p.mu.Lock()
+	p.latency = d
+	p.mu.Unlock()
+}
+
+// Connections is how many connections are being forwarded
+func (p *Proxy) Connections() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.conns)
+}
+
+// Accepted is how many connections were forwarded since the proxy started, reconnects increase it
+func (p *Proxy) Accepted() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.accepted
+}
+
+// Close stops the proxy and closes all connections
+func (p *Proxy) Close() error {
+	err := p.listener.Close()
+	p.Partition()
+	p.wg.Wait()
+
+	return err
+}
+
+func (p *Proxy) accept() {
+	defer p.wg.Done()
+
+	for {
+		client, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		p.mu.Lock()
+		partitioned := p.partitioned
+		p.mu.Unlock()
+
+		if partitioned {
+			client.Close()
+			continue
+		}
+
+		server, err := net.Dial("tcp", p.target)
+		if err != nil {
+			client.Close()
+			continue
+		}
+
+		p.mu.Lock()
+		p.conns[client] = server
+		p.accepted++
+		p.mu.Unlock()
+
+		p.wg.Add(2)
+		go p.forward(client, server, client)
+		go p.forward(client, server, server)
+	}
+}
+
+// forward copies data read from src, one of client or server, to the other one
+func (p *Proxy) forward(client net.Conn, server net.Conn, src net.Conn) {
+	defer p.wg.Done()
+
+	dst := server
+	if src == server {
+		dst = client
+	}
+
+	defer func() {
+		client.Close()
+		server.Close()
+
+		p.mu.Lock()
+		delete(p.conns, client)
+		p.mu.Unlock()
+	}()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			p.mu.Lock()
+			latency := p.latency
+			p.mu.Unlock()
+
+			if latency > 0 {
+				time.Sleep(latency)
+			}
+
+			_, werr := dst.Write(buf[:n])
+			if werr != nil {
+				return
+			}
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
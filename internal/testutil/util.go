@@ -5,7 +5,11 @@
 package testutil
 
 import (
+	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/nats-io/jsm.go"
@@ -13,8 +17,7 @@ import (
 	"github.com/nats-io/nats.go"
 	"github.com/sirupsen/logrus"
 
-	. "github.com/onsi/ginkgo/v2" //lint:ignore ST1001 silly bot
-	. "github.com/onsi/gomega"    //lint:ignore ST1001 silly bot
+	. "github.com/onsi/gomega" //lint:ignore ST1001 silly bot
 )
 
 type logger struct {
@@ -26,9 +29,30 @@ func (l *logger) Noticef(format string, v ...any) {
 }
 
 func WithJetStream(log *logrus.Entry, cb func(srv *server.Server, nc *nats.Conn, mgr *jsm.Manager)) {
-	d, err := os.MkdirTemp("", "jstest")
+	s, nc, mgr, stop, err := StartJetStream(log)
 	Expect(err).ToNot(HaveOccurred())
-	defer os.RemoveAll(d)
+	defer stop()
+
+	cb(s, nc, mgr)
+}
+
+// WithLoadedJetStream is like WithJetStream with stream holding count messages of size bytes, see LoadStream
+func WithLoadedJetStream(log *logrus.Entry, stream string, count int, size int, cb func(srv *server.Server, nc *nats.Conn, mgr *jsm.Manager)) {
+	WithJetStream(log, func(srv *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+		_, err := LoadStream(nc, mgr, stream, count, size)
+		Expect(err).ToNot(HaveOccurred())
+
+		cb(srv, nc, mgr)
+	})
+}
+
+// StartJetStream starts a JetStream server storing data in a temporary directory, stop shuts it down and removes the
+// data, it can be used outside of ginkgo suites like in benchmarks
+func StartJetStream(log *logrus.Entry) (srv *server.Server, nc *nats.Conn, mgr *jsm.Manager, stop func(), err error) {
+	d, err := os.MkdirTemp("", "jstest")
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
 
 	opts := &server.Options{
 		JetStream: true,
@@ -39,28 +63,257 @@ func WithJetStream(log *logrus.Entry, cb func(srv *server.Server, nc *nats.Conn,
 		LogFile:   "/dev/stdout",
 	}
 
-	s, err := server.NewServer(opts)
-	Expect(err).ToNot(HaveOccurred())
+	srv, err = server.NewServer(opts)
+	if err != nil {
+		os.RemoveAll(d)
+		return nil, nil, nil, nil, err
+	}
 
 	if log != nil {
-		s.SetLogger(&logger{log}, true, true)
+		srv.SetLogger(&logger{log}, true, true)
 	}
 
-	go s.Start()
-	if !s.ReadyForConnections(10 * time.Second) {
-		Fail("nats server did not start")
+	shutdown := func() {
+		srv.Shutdown()
+		srv.WaitForShutdown()
+		os.RemoveAll(d)
 	}
-	defer func() {
-		s.Shutdown()
-		s.WaitForShutdown()
-	}()
 
-	nc, err := nats.Connect(s.ClientURL(), nats.UseOldRequestStyle())
-	Expect(err).ToNot(HaveOccurred())
-	defer nc.Close()
+	go srv.Start()
+	if !srv.ReadyForConnections(10 * time.Second) {
+		shutdown()
+		return nil, nil, nil, nil, fmt.Errorf("nats server did not start")
+	}
+
+	nc, err = nats.Connect(srv.ClientURL(), nats.UseOldRequestStyle())
+	if err != nil {
+		shutdown()
+		return nil, nil, nil, nil, err
+	}
+
+	mgr, err = jsm.New(nc, jsm.WithTimeout(time.Second))
+	if err != nil {
+		nc.Close()
+		shutdown()
+		return nil, nil, nil, nil, err
+	}
+
+	stop = func() {
+		nc.Close()
+		shutdown()
+	}
+
+	return srv, nc, mgr, stop, nil
+}
 
-	mgr, err := jsm.New(nc, jsm.WithTimeout(time.Second))
+// WithJetStreamCluster is like WithJetStream using a cluster of n servers, nc connects to all of them
+func WithJetStreamCluster(log *logrus.Entry, n int, cb func(servers []*server.Server, nc *nats.Conn, mgr *jsm.Manager)) {
+	servers, nc, mgr, stop, err := StartJetStreamCluster(log, n)
 	Expect(err).ToNot(HaveOccurred())
+	defer stop()
 
-	cb(s, nc, mgr)
+	cb(servers, nc, mgr)
+}
+
+// StartJetStreamCluster starts a JetStream cluster of n servers storing data in a temporary directory and waits for
+// it to elect a meta leader, stop shuts all servers down and removes the data
+func StartJetStreamCluster(log *logrus.Entry, n int) (servers []*server.Server, nc *nats.Conn, mgr *jsm.Manager, stop func(), err error) {
+	if n < 1 {
+		return nil, nil, nil, nil, fmt.Errorf("at least 1 server is required")
+	}
+
+	d, err := os.MkdirTemp("", "jscluster")
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	shutdown := func() {
+		for _, srv := range servers {
+			srv.Shutdown()
+			srv.WaitForShutdown()
+		}
+		os.RemoveAll(d)
+	}
+
+	var routes []string
+	var ports []int
+	for i := 0; i < n; i++ {
+		port, err := freePort()
+		if err != nil {
+			shutdown()
+			return nil, nil, nil, nil, err
+		}
+		ports = append(ports, port)
+		routes = append(routes, fmt.Sprintf("nats://localhost:%d", port))
+	}
+
+	for i := 0; i < n; i++ {
+		opts := &server.Options{
+			ServerName: fmt.Sprintf("s%d", i+1),
+			JetStream:  true,
+			StoreDir:   filepath.Join(d, fmt.Sprintf("s%d", i+1)),
+			Port:       -1,
+			HTTPPort:   -1,
+			Host:       "localhost",
+			Cluster: server.ClusterOpts{
+				Name: "TEST",
+				Host: "localhost",
+				Port: ports[i],
+			},
+			Routes: server.RoutesFromStr(strings.Join(routes, ",")),
+		}
+
+		srv, err := server.NewServer(opts)
+		if err != nil {
+			shutdown()
+			return nil, nil, nil, nil, err
+		}
+
+		if log != nil {
+			srv.SetLogger(&logger{log.WithField("server", opts.ServerName)}, true, true)
+		}
+
+		servers = append(servers, srv)
+		go srv.Start()
+	}
+
+	for _, srv := range servers {
+		if !srv.ReadyForConnections(10 * time.Second) {
+			shutdown()
+			return nil, nil, nil, nil, fmt.Errorf("nats server %s did not start", srv.Name())
+		}
+	}
+
+	if n > 1 {
+		err = waitForMetaLeader(servers, 20*time.Second)
+		if err != nil {
+			shutdown()
+			return nil, nil, nil, nil, err
+		}
+	}
+
+	nc, err = nats.Connect(ClientURLs(servers), nats.UseOldRequestStyle(), nats.MaxReconnects(-1), nats.ReconnectWait(100*time.Millisecond))
+	if err != nil {
+		shutdown()
+		return nil, nil, nil, nil, err
+	}
+
+	mgr, err = jsm.New(nc, jsm.WithTimeout(5*time.Second))
+	if err != nil {
+		nc.Close()
+		shutdown()
+		return nil, nil, nil, nil, err
+	}
+
+	stop = func() {
+		nc.Close()
+		shutdown()
+	}
+
+	return servers, nc, mgr, stop, nil
+}
+
+// ClientURLs is a comma separated list of the client urls of servers that are running
+func ClientURLs(servers []*server.Server) string {
+	var urls []string
+	for _, srv := range servers {
+		if srv.Running() {
+			urls = append(urls, srv.ClientURL())
+		}
+	}
+
+	return strings.Join(urls, ",")
+}
+
+// StreamLeader is the server that is the leader of stream in the default account, nil when there is none
+func StreamLeader(servers []*server.Server, stream string) *server.Server {
+	for _, srv := range servers {
+		if srv.Running() && srv.JetStreamIsStreamLeader(server.DEFAULT_GLOBAL_ACCOUNT, stream) {
+			return srv
+		}
+	}
+
+	return nil
+}
+
+func waitForMetaLeader(servers []*server.Server, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for time.Now().Before(deadline) {
+		leader := false
+		current := true
+		for _, srv := range servers {
+			if srv.JetStreamIsLeader() {
+				leader = true
+			}
+			if !srv.JetStreamIsCurrent() {
+				current = false
+			}
+		}
+
+		if leader && current {
+			return nil
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	return fmt.Errorf("jetstream cluster did not elect a leader within %v", timeout)
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// LoadStream creates stream listening on stream.> and publishes count messages of size bytes to it spread over 10
+// subjects, message data is JSON holding the message number padded to size where possible, opts configure the stream
+func LoadStream(nc *nats.Conn, mgr *jsm.Manager, stream string, count int, size int, opts ...jsm.StreamOption) (*jsm.Stream, error) {
+	str, err := mgr.NewStream(stream, append([]jsm.StreamOption{jsm.Subjects(stream + ".>")}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	js, err := nc.JetStream(nats.PublishAsyncMaxPending(1000))
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 1; i <= count; i++ {
+		_, err = js.PublishAsync(fmt.Sprintf("%s.%d", stream, i%10), Payload(i, size))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	select {
+	case <-js.PublishAsyncComplete():
+	case <-time.After(time.Minute):
+		return nil, fmt.Errorf("timeout loading %d messages into %s", count, stream)
+	}
+
+	nfo, err := str.State()
+	if err != nil {
+		return nil, err
+	}
+	if nfo.Msgs != uint64(count) {
+		return nil, fmt.Errorf("loaded %d of %d messages into %s", nfo.Msgs, count, stream)
+	}
+
+	return str, nil
+}
+
+// Payload is JSON data of at least size bytes holding i
+func Payload(i int, size int) []byte {
+	msg := fmt.Sprintf(`{"id":%d,"pad":""}`, i)
+	if pad := size - len(msg); pad > 0 {
+		msg = fmt.Sprintf(`{"id":%d,"pad":"%s"}`, i, strings.Repeat("x", pad))
+	}
+
+	return []byte(msg)
 }
@@ -0,0 +1,43 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package transform
+
+import (
+	"testing"
+
+	"github.com/nats-io/nats.go"
+)
+
+func FuzzApply(f *testing.F) {
+	f.Add("orders.new", "eu", []byte(`{"id":"1","amount":20,"items":[{"sku":"a"},{"sku":"b"}]}`))
+	f.Add("", "", []byte(`{"amount":1e308,"items":null}`))
+	f.Add("a..b", "\x00", []byte(`[1,2,3]`))
+	f.Add("x", "us", []byte(`not json`))
+
+	transforms := []*Transform{}
+	for _, e := range [][2]string{
+		{`subject startsWith "orders." && headers.Region == "eu"`, `{"id": data?.id, "region": headers.Region}`},
+		{`data?.amount > 10`, `data?.items`},
+		{"", `raw + subject`},
+		{`len(headers) > 0`, ""},
+	} {
+		tr, err := New(e[0], e[1])
+		if err != nil {
+			f.Fatalf("invalid transform: %v", err)
+		}
+		transforms = append(transforms, tr)
+	}
+
+	f.Fuzz(func(t *testing.T, subject string, region string, data []byte) {
+		for _, tr := range transforms {
+			msg := nats.NewMsg(subject)
+			msg.Header.Add("Region", region)
+			msg.Data = append([]byte{}, data...)
+
+			// errors are expected for payloads expressions can not handle, panics are not
+			tr.Apply(msg)
+		}
+	})
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package transform filters and restructures message payloads using expressions
+// in the expr language, see https://expr-lang.org/docs/language-definition
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+	"github.com/nats-io/nats.go"
+)
+
+// Env is the environment expressions are evaluated in
+type Env struct {
+	// Subject is the subject of the message
+	Subject string `expr:"subject"`
+	// Headers holds the first value of every message header
+	Headers map[string]string `expr:"headers"`
+	// Data is the JSON decoded payload with whole numbers as integers, nil when the payload is not JSON
+	Data any `expr:"data"`
+	// Raw is the payload as a string
+	Raw string `expr:"raw"`
+}
+
+// Transform applies a filter and mapping to messages
+type Transform struct {
+	filter  *vm.Program
+	mapping *vm.Program
+}
+
+// New compiles the filter and mapping expressions, either can be empty
+func New(filter string, mapping string) (*Transform, error) {
+	t := &Transform{}
+
+	var err error
+
+	if filter != "" {
+		t.filter, err = expr.Compile(filter, expr.Env(Env{}), expr.AsBool())
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter: %v", err)
+		}
+	}
+
+	if mapping != "" {
+		t.mapping, err = expr.Compile(mapping, expr.Env(Env{}))
+		if err != nil {
+			return nil, fmt.Errorf("invalid mapping: %v", err)
+		}
+	}
+
+	return t, nil
+}
+
+// Apply runs the filter against msg and replaces its payload with the result of the mapping, returns false
+// when the filter did not match or the mapping resulted in nil meaning msg should not be copied
+func (t *Transform) Apply(msg *nats.Msg) (bool, error) {
+	env := Env{
+		Subject: msg.Subject,
+		Headers: make(map[string]string, len(msg.Header)),
+		Raw:     string(msg.Data),
+	}
+
+	for k := range msg.Header {
+		env.Headers[k] = msg.Header.Get(k)
+	}
+
+	if json.Valid(msg.Data) {
+		err := json.Unmarshal(msg.Data, &env.Data)
+		if err != nil {
+			return false, err
+		}
+		env.Data = integers(env.Data)
+	}
+
+	if t.filter != nil {
+		res, err := expr.Run(t.filter, env)
+		if err != nil {
+			return false, fmt.Errorf("filter failed: %v", err)
+		}
+
+		if !res.(bool) {
+			return false, nil
+		}
+	}
+
+	if t.mapping == nil {
+		return true, nil
+	}
+
+	res, err := expr.Run(t.mapping, env)
+	if err != nil {
+		return false, fmt.Errorf("mapping failed: %v", err)
+	}
+
+	switch v := res.(type) {
+	case nil:
+		return false, nil
+	case string:
+		msg.Data = []byte(v)
+	case []byte:
+		msg.Data = v
+	default:
+		msg.Data, err = json.Marshal(v)
+		if err != nil {
+			return false, fmt.Errorf("mapping failed: %v", err)
+		}
+	}
+
+	return true, nil
+}
+
+// integers converts whole numbers in v to int so integer operators like % can be used on them
+func integers(v any) any {
+	switch val := v.(type) {
+	case float64:
+		if val == math.Trunc(val) && math.Abs(val) < 1<<53 {
+			return int(val)
+		}
+	case map[string]any:
+		for k, item := range val {
+			val[k] = integers(item)
+		}
+	case []any:
+		for i, item := range val {
+			val[i] = integers(item)
+		}
+	}
+
+	return v
+}
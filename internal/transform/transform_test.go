@@ -0,0 +1,97 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package transform
+
+import (
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestTransform(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Transform")
+}
+
+var _ = Describe("Transform", func() {
+	var msg *nats.Msg
+
+	BeforeEach(func() {
+		msg = nats.NewMsg("orders.new")
+		msg.Header.Add("Region", "eu")
+		msg.Data = []byte(`{"id":"1","amount":20,"items":[{"sku":"a"},{"sku":"b"}]}`)
+	})
+
+	Describe("New", func() {
+		It("Should validate expressions", func() {
+			_, err := New("data.amount +", "")
+			Expect(err).To(MatchError(ContainSubstring("invalid filter")))
+
+			_, err = New("subject", "")
+			Expect(err).To(MatchError(ContainSubstring("invalid filter")))
+
+			_, err = New("", "unknown.field")
+			Expect(err).To(MatchError(ContainSubstring("invalid mapping")))
+
+			_, err = New(`subject startsWith "orders."`, `{"id": data.id}`)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Describe("Apply", func() {
+		It("Should filter messages", func() {
+			t, err := New(`headers.Region == "eu" && data.amount > 10`, "")
+			Expect(err).ToNot(HaveOccurred())
+
+			keep, err := t.Apply(msg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keep).To(BeTrue())
+			Expect(msg.Data).To(MatchJSON(`{"id":"1","amount":20,"items":[{"sku":"a"},{"sku":"b"}]}`))
+
+			msg.Data = []byte(`{"amount":5}`)
+			keep, err = t.Apply(msg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keep).To(BeFalse())
+		})
+
+		It("Should restructure payloads", func() {
+			t, err := New("", `{"order": data.id, "total": data.amount * 2, "skus": map(data.items, #.sku), "subject": subject}`)
+			Expect(err).ToNot(HaveOccurred())
+
+			keep, err := t.Apply(msg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keep).To(BeTrue())
+			Expect(msg.Data).To(MatchJSON(`{"order":"1","total":40,"skus":["a","b"],"subject":"orders.new"}`))
+
+			t, err = New("", `upper(raw)`)
+			Expect(err).ToNot(HaveOccurred())
+			msg.Data = []byte("hello world")
+			keep, err = t.Apply(msg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keep).To(BeTrue())
+			Expect(string(msg.Data)).To(Equal("HELLO WORLD"))
+		})
+
+		It("Should drop messages mapped to nil", func() {
+			t, err := New("", `data.amount > 10 ? nil : data`)
+			Expect(err).ToNot(HaveOccurred())
+
+			keep, err := t.Apply(msg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keep).To(BeFalse())
+		})
+
+		It("Should fail for expressions that can not be evaluated", func() {
+			t, err := New(`data.amount > 10`, "")
+			Expect(err).ToNot(HaveOccurred())
+
+			msg.Data = []byte("not json")
+			_, err = t.Apply(msg)
+			Expect(err).To(MatchError(ContainSubstring("filter failed")))
+		})
+	})
+})
@@ -0,0 +1,252 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package util
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/sirupsen/logrus"
+)
+
+// credentialsCommandTimeout is how long a credentials command may run
+var credentialsCommandTimeout = time.Minute
+
+// credentialsReloader provides the user JWT and signs nonces using credentials read from files or the output of a
+// command, the last credentials that were successfully loaded are used until valid new ones are found
+type credentialsReloader struct {
+	// name is the file or command the credentials are read from
+	name string
+	read func() (jwt []byte, seed []byte, err error)
+	// tracker detects changed files, commands are instead refreshed on interval or before the jwt expires
+	tracker   *fileTracker
+	interval  time.Duration
+	refreshAt time.Time
+	jwt       string
+	seed      []byte
+	expires   time.Time
+	failed    bool
+	log       *logrus.Entry
+	mu        sync.Mutex
+}
+
+// newCredentialsReloader creates a reloader for a JWT and seed file, for credentials files both are the same file
+func newCredentialsReloader(jwtFile string, seedFile string, log *logrus.Entry) *credentialsReloader {
+	files := []string{jwtFile}
+	if seedFile != jwtFile {
+		files = append(files, seedFile)
+	}
+
+	return &credentialsReloader{
+		name:    jwtFile,
+		tracker: newFileTracker(files...),
+		log:     log,
+		read: func() ([]byte, []byte, error) {
+			jwtContents, err := os.ReadFile(jwtFile)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if seedFile == jwtFile {
+				return jwtContents, jwtContents, nil
+			}
+
+			seedContents, err := os.ReadFile(seedFile)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			return jwtContents, seedContents, nil
+		},
+	}
+}
+
+// newCredentialsCommand creates a reloader that runs command with args, without a shell, to obtain credentials in the
+// credentials file format, the command is run again every interval or, when interval is 0, once 3/4 of the lifetime of
+// the jwt passed
+func newCredentialsCommand(command string, args []string, interval time.Duration, log *logrus.Entry) (*credentialsReloader, error) {
+	if strings.TrimSpace(command) == "" {
+		return nil, fmt.Errorf("credentials command is empty")
+	}
+
+	return &credentialsReloader{
+		name:     command,
+		interval: interval,
+		log:      log,
+		read: func() ([]byte, []byte, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), credentialsCommandTimeout)
+			defer cancel()
+
+			stderr := &bytes.Buffer{}
+			cmd := exec.CommandContext(ctx, command, args...)
+			cmd.Stderr = stderr
+
+			out, err := cmd.Output()
+			if err != nil {
+				return nil, nil, fmt.Errorf("credentials command %s failed: %w: %s", command, err, strings.TrimSpace(stderr.String()))
+			}
+
+			return out, out, nil
+		},
+	}, nil
+}
+
+func (r *credentialsReloader) load() (string, []byte, time.Time, error) {
+	jwtContents, seedContents, err := r.read()
+	if err != nil {
+		return "", nil, time.Time{}, err
+	}
+
+	ujwt, err := nkeys.ParseDecoratedJWT(jwtContents)
+	if err != nil {
+		return "", nil, time.Time{}, fmt.Errorf("invalid jwt in %s: %w", r.name, err)
+	}
+	ujwt = strings.TrimSpace(ujwt)
+
+	claims, err := jwt.DecodeUserClaims(ujwt)
+	if err != nil {
+		return "", nil, time.Time{}, fmt.Errorf("invalid jwt in %s: %w", r.name, err)
+	}
+
+	var expires time.Time
+	if claims.Expires > 0 {
+		expires = time.Unix(claims.Expires, 0)
+		if expires.Before(time.Now()) {
+			return "", nil, time.Time{}, fmt.Errorf("jwt in %s expired at %v", r.name, expires)
+		}
+	}
+
+	kp, err := nkeys.ParseDecoratedNKey(seedContents)
+	if err != nil {
+		return "", nil, time.Time{}, fmt.Errorf("invalid nkey seed in %s: %w", r.name, err)
+	}
+	defer kp.Wipe()
+
+	pub, err := kp.PublicKey()
+	if err != nil {
+		return "", nil, time.Time{}, err
+	}
+
+	if pub != claims.Subject {
+		return "", nil, time.Time{}, fmt.Errorf("nkey seed in %s does not match its jwt", r.name)
+	}
+
+	seed, err := kp.Seed()
+	if err != nil {
+		return "", nil, time.Time{}, err
+	}
+
+	// the seed is wiped with the key pair so we keep a copy
+	return ujwt, append([]byte{}, seed...), expires, nil
+}
+
+// store keeps loaded credentials and schedules the next refresh, must be called with the lock held
+func (r *credentialsReloader) store(ujwt string, seed []byte, expires time.Time) {
+	r.jwt = ujwt
+	r.seed = seed
+	r.expires = expires
+	r.failed = false
+
+	if r.tracker != nil {
+		r.tracker.update()
+		return
+	}
+
+	switch {
+	case r.interval > 0:
+		r.refreshAt = time.Now().Add(r.interval)
+	case !expires.IsZero():
+		r.refreshAt = time.Now().Add(time.Until(expires) * 3 / 4)
+	default:
+		r.refreshAt = time.Time{}
+	}
+}
+
+// current loads the credentials on first use, or when the cached ones expired, so that connections keep retrying until valid credentials exist
+func (r *credentialsReloader) current() (string, []byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.jwt == "" || (!r.expires.IsZero() && r.expires.Before(time.Now())) {
+		ujwt, seed, expires, err := r.load()
+		if err != nil {
+			return "", nil, err
+		}
+
+		r.store(ujwt, seed, expires)
+	}
+
+	return r.jwt, r.seed, nil
+}
+
+func (r *credentialsReloader) userJWT() (string, error) {
+	ujwt, _, err := r.current()
+	return ujwt, err
+}
+
+func (r *credentialsReloader) sign(nonce []byte) ([]byte, error) {
+	_, seed, err := r.current()
+	if err != nil {
+		return nil, err
+	}
+
+	kp, err := nkeys.FromSeed(seed)
+	if err != nil {
+		return nil, err
+	}
+	defer kp.Wipe()
+
+	return kp.Sign(nonce)
+}
+
+// due checks if the credentials should be loaded again, must be called with the lock held
+func (r *credentialsReloader) due() bool {
+	if r.tracker != nil {
+		return r.tracker.changed()
+	}
+
+	return !r.refreshAt.IsZero() && time.Now().After(r.refreshAt)
+}
+
+// reload loads the credentials if they changed, returning true when new credentials were loaded,
+// an error is returned the first time changed credentials failed to load
+func (r *credentialsReloader) reload() (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.jwt == "" || !r.due() {
+		return false, nil
+	}
+
+	ujwt, seed, expires, err := r.load()
+	if err != nil {
+		r.log.Warnf("Could not reload credentials from %s, continuing with previous credentials: %v", r.name, err)
+		if r.failed {
+			return false, nil
+		}
+		r.failed = true
+
+		return false, err
+	}
+
+	changed := ujwt != r.jwt
+	r.store(ujwt, seed, expires)
+
+	if !changed {
+		return false, nil
+	}
+
+	r.log.Warnf("Reloaded credentials from %s", r.name)
+
+	return true, nil
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package util
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+// writeUserCredentials writes a credentials file for a new user expiring after lifetime, returning the user jwt
+func writeUserCredentials(file string, lifetime time.Duration) string {
+	akp, err := nkeys.CreateAccount()
+	Expect(err).ToNot(HaveOccurred())
+	ukp, err := nkeys.CreateUser()
+	Expect(err).ToNot(HaveOccurred())
+	upk, err := ukp.PublicKey()
+	Expect(err).ToNot(HaveOccurred())
+	useed, err := ukp.Seed()
+	Expect(err).ToNot(HaveOccurred())
+
+	claims := jwt.NewUserClaims(upk)
+	if lifetime > 0 {
+		claims.Expires = time.Now().Add(lifetime).Unix()
+	}
+	ujwt, err := claims.Encode(akp)
+	Expect(err).ToNot(HaveOccurred())
+
+	creds, err := jwt.FormatUserConfig(ujwt, useed)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(os.WriteFile(file, creds, 0600)).To(Succeed())
+
+	return ujwt
+}
+
+var _ = Describe("Credentials Command", func() {
+	var (
+		log       *logrus.Entry
+		dir       string
+		command   string
+		credsFile string
+	)
+
+	BeforeEach(func() {
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		// a space in the path ensures arguments are passed without splitting them
+		dir = filepath.Join(GinkgoT().TempDir(), "credentials broker")
+		Expect(os.Mkdir(dir, 0700)).To(Succeed())
+		credsFile = filepath.Join(dir, "user.creds")
+
+		command = filepath.Join(dir, "get-creds")
+		Expect(os.WriteFile(command, []byte("#!/bin/sh\nif [ -f \"$1.fail\" ]; then echo 'broker unavailable' >&2; exit 1; fi\ncat \"$1\"\n"), 0700)).To(Succeed())
+	})
+
+	fail := func(failing bool) {
+		if failing {
+			Expect(os.WriteFile(credsFile+".fail", nil, 0600)).To(Succeed())
+		} else {
+			Expect(os.Remove(credsFile + ".fail")).To(Succeed())
+		}
+	}
+
+	It("Should pass arguments from the url without splitting them", func() {
+		ujwt := writeUserCredentials(credsFile, 0)
+
+		q := url.Values{}
+		q.Set("credentials_command", command)
+		q.Add("credentials_arg", credsFile)
+
+		opts, creds, err := authOptions(q, log)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(opts).To(HaveLen(1))
+		Expect(creds.userJWT()).To(Equal(ujwt))
+
+		q.Set("credentials_command", command+" "+credsFile)
+		q.Del("credentials_arg")
+		_, creds, err = authOptions(q, log)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = creds.userJWT()
+		Expect(err).To(MatchError(ContainSubstring("credentials command " + command + " " + credsFile + " failed")))
+
+		q.Set("credentials_command", " ")
+		_, _, err = authOptions(q, log)
+		Expect(err).To(MatchError("credentials command is empty"))
+	})
+
+	It("Should refresh credentials before they expire", func() {
+		first := writeUserCredentials(credsFile, 4*time.Second)
+
+		r, err := newCredentialsCommand(command, []string{credsFile}, 0, log)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r.userJWT()).To(Equal(first))
+		expires := r.expires
+
+		second := writeUserCredentials(credsFile, time.Hour)
+
+		// not due yet so the command is not run again
+		changed, err := r.reload()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeFalse())
+		Expect(r.userJWT()).To(Equal(first))
+
+		Eventually(func() bool {
+			changed, err := r.reload()
+			Expect(err).ToNot(HaveOccurred())
+			return changed
+		}, "5s", "50ms").Should(BeTrue())
+		Expect(time.Now()).To(BeTemporally("<", expires))
+		Expect(r.userJWT()).To(Equal(second))
+	})
+
+	It("Should keep previous credentials when the command fails", func() {
+		fail(true)
+		r, err := newCredentialsCommand(command, []string{credsFile}, 10*time.Millisecond, log)
+		Expect(err).ToNot(HaveOccurred())
+
+		// without previous credentials connecting fails until the command succeeds
+		_, err = r.userJWT()
+		Expect(err).To(MatchError(ContainSubstring("failed: exit status 1: broker unavailable")))
+		_, err = r.sign([]byte("nonce"))
+		Expect(err).To(HaveOccurred())
+
+		first := writeUserCredentials(credsFile, time.Hour)
+		fail(false)
+		Expect(r.userJWT()).To(Equal(first))
+
+		fail(true)
+		time.Sleep(20 * time.Millisecond)
+		changed, err := r.reload()
+		Expect(err).To(MatchError(ContainSubstring("broker unavailable")))
+		Expect(changed).To(BeFalse())
+		Expect(r.userJWT()).To(Equal(first))
+		sig, err := r.sign([]byte("nonce"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sig).ToNot(BeEmpty())
+
+		// the failure is only reported once
+		time.Sleep(20 * time.Millisecond)
+		changed, err = r.reload()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeFalse())
+
+		second := writeUserCredentials(credsFile, time.Hour)
+		fail(false)
+		time.Sleep(20 * time.Millisecond)
+		changed, err = r.reload()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(r.userJWT()).To(Equal(second))
+	})
+})
@@ -0,0 +1,123 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package util
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// ParseProxyURL parses and validates a proxy url in http://, socks5:// or socks5h:// form
+func ParseProxyURL(u string) (*url.URL, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url: %w", err)
+	}
+
+	switch parsed.Scheme {
+	case "http", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, http, socks5 and socks5h are supported", parsed.Scheme)
+	}
+
+	if parsed.Host == "" {
+		return nil, fmt.Errorf("proxy url %s has no host", parsed.Redacted())
+	}
+
+	return parsed, nil
+}
+
+// ProxyDialer creates a dial function connecting through the proxy u for clients other than NATS
+func ProxyDialer(u string, timeout time.Duration) (func(ctx context.Context, network string, address string) (net.Conn, error), error) {
+	parsed, err := ParseProxyURL(u)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &net.Dialer{Timeout: timeout}
+
+	return func(_ context.Context, network string, address string) (net.Conn, error) {
+		return dialProxy(d, parsed, network, address)
+	}, nil
+}
+
+// dialProxy connects to address via the proxy described by u
+func dialProxy(d *net.Dialer, u *url.URL, network string, address string) (net.Conn, error) {
+	if u.Scheme == "http" {
+		return dialHTTPConnect(d, u, network, address)
+	}
+
+	dialer, err := proxy.FromURL(u, d)
+	if err != nil {
+		return nil, err
+	}
+
+	return dialer.Dial(network, address)
+}
+
+// bufferedConn reads data the proxy sent after its response before reading from the connection
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// dialHTTPConnect establishes a tunnel to address using the HTTP CONNECT method
+func dialHTTPConnect(d *net.Dialer, u *url.URL, network string, address string) (net.Conn, error) {
+	conn, err := d.Dial(network, u.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+
+	if u.User != nil {
+		pass, _ := u.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+
+	if d.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(d.Timeout))
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	err = req.Write(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy request failed: %w", err)
+	}
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy response failed: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused connection to %s: %s", u.Host, address, resp.Status)
+	}
+
+	return &bufferedConn{Conn: conn, r: r}, nil
+}
@@ -0,0 +1,284 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package util
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fileCheckInterval is how often watched files are checked for changes
+var fileCheckInterval = 10 * time.Second
+
+// ReloadHandler is notified when a certificate or credential of kind, loaded from file, is reloaded, err is set when the changed file is invalid
+type ReloadHandler func(kind string, file string, err error)
+
+const (
+	CertificateReload = "certificate"
+	CredentialsReload = "credentials"
+)
+
+// fileTracker detects changes to a set of files based on their size and modification time
+type fileTracker struct {
+	files  []string
+	stamps map[string]string
+}
+
+func newFileTracker(files ...string) *fileTracker {
+	t := &fileTracker{files: files, stamps: make(map[string]string)}
+	t.update()
+
+	return t
+}
+
+func (t *fileTracker) stamp(file string) string {
+	stat, err := os.Stat(file)
+	if err != nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%d:%d", stat.Size(), stat.ModTime().UnixNano())
+}
+
+// changed checks if any file changed since the last update, files that are
+// missing are considered unchanged as they are likely being replaced
+func (t *fileTracker) changed() bool {
+	for _, f := range t.files {
+		s := t.stamp(f)
+		if s != "" && s != t.stamps[f] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t *fileTracker) update() {
+	for _, f := range t.files {
+		t.stamps[f] = t.stamp(f)
+	}
+}
+
+// lookupHost resolves host names when dialing servers
+var lookupHost = net.DefaultResolver.LookupHost
+
+// reconnectDialer is a nats.CustomDialer that can force the client to reconnect by closing its connection,
+// connections are made through proxy when set
+type reconnectDialer struct {
+	net.Dialer
+
+	proxy  *url.URL
+	conn   net.Conn
+	forced atomic.Bool
+	mu     sync.Mutex
+}
+
+func (d *reconnectDialer) Dial(network string, address string) (conn net.Conn, err error) {
+	if d.proxy != nil {
+		conn, err = dialProxy(&d.Dialer, d.proxy, network, address)
+	} else {
+		conn, err = d.dialResolved(network, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	d.conn = conn
+	d.mu.Unlock()
+
+	return conn, nil
+}
+
+// dialResolved resolves the host in address on every dial, so reconnects follow DNS changes, and tries all its addresses in random order
+func (d *reconnectDialer) dialResolved(network string, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) != nil {
+		return d.Dialer.Dial(network, address)
+	}
+
+	ctx := context.Background()
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+
+	addrs, err := lookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no addresses found for %s", host)
+	}
+
+	rand.Shuffle(len(addrs), func(i, j int) { addrs[i], addrs[j] = addrs[j], addrs[i] })
+
+	// like the NATS client the timeout is shared between all addresses
+	dialer := d.Dialer
+	dialer.Timeout = dialer.Timeout / time.Duration(len(addrs))
+
+	var conn net.Conn
+	for _, addr := range addrs {
+		conn, err = dialer.Dial(network, net.JoinHostPort(addr, port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+
+	return nil, err
+}
+
+// reconnect closes the current connection, the NATS client will then reconnect
+func (d *reconnectDialer) reconnect() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.conn != nil {
+		d.forced.Store(true)
+		d.conn.Close()
+		d.conn = nil
+	}
+}
+
+// wasForced checks if the last disconnection was caused by reconnect, clearing the flag
+func (d *reconnectDialer) wasForced() bool {
+	return d.forced.Swap(false)
+}
+
+// certificateReloader provides the client certificate for TLS connections and reloads it when the files change
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+	tracker  *fileTracker
+	cert     *tls.Certificate
+	failed   bool
+	log      *logrus.Entry
+	mu       sync.Mutex
+}
+
+func newCertificateReloader(certFile string, keyFile string, log *logrus.Entry) (*certificateReloader, error) {
+	r := &certificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		tracker:  newFileTracker(certFile, keyFile),
+		log:      log,
+	}
+
+	cert, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	r.cert = cert
+
+	return r, nil
+}
+
+func (r *certificateReloader) load() (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading client certificate: %w", err)
+	}
+
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("error parsing client certificate: %w", err)
+	}
+
+	return &cert, nil
+}
+
+func (r *certificateReloader) getClientCertificate(_ *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.cert, nil
+}
+
+// reload loads the certificate if it changed, returning true when a new certificate was loaded,
+// an error is returned the first time a changed certificate failed to load
+func (r *certificateReloader) reload() (bool, error) {
+	if !r.tracker.changed() {
+		return false, nil
+	}
+
+	cert, err := r.load()
+	if err != nil {
+		// likely still being written, we try again later as the tracker was not updated
+		r.log.Warnf("Could not reload changed client certificate %s: %v", r.certFile, err)
+		if r.failed {
+			return false, nil
+		}
+		r.failed = true
+
+		return false, err
+	}
+
+	r.mu.Lock()
+	r.cert = cert
+	r.mu.Unlock()
+	r.tracker.update()
+	r.failed = false
+
+	r.log.Warnf("Reloaded client certificate %s valid until %v", r.certFile, cert.Leaf.NotAfter)
+
+	return true, nil
+}
+
+// watchReloads periodically checks for changed certificates and credentials and reconnects using the new ones until done is closed
+func watchReloads(ctx context.Context, done chan struct{}, dialer *reconnectDialer, certs *certificateReloader, creds *credentialsReloader, handler ReloadHandler, log *logrus.Entry) {
+	ticker := time.NewTicker(fileCheckInterval)
+	defer ticker.Stop()
+
+	notify := func(kind string, file string, err error) {
+		if handler != nil {
+			handler(kind, file, err)
+		}
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			var reconnect bool
+
+			if certs != nil {
+				changed, err := certs.reload()
+				if changed || err != nil {
+					notify(CertificateReload, certs.certFile, err)
+				}
+				reconnect = changed
+			}
+
+			if creds != nil {
+				changed, err := creds.reload()
+				if changed || err != nil {
+					notify(CredentialsReload, creds.name, err)
+				}
+				reconnect = reconnect || changed
+			}
+
+			if reconnect {
+				log.Warnf("Reconnecting to apply the new client certificate or credentials")
+				dialer.reconnect()
+			}
+
+		case <-done:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
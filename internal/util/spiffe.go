@@ -0,0 +1,135 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package util
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"github.com/choria-io/stream-replicator/backoff"
+	"github.com/sirupsen/logrus"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+)
+
+// SPIFFEReload is the kind of reload notified when a new X.509 SVID was received
+const SPIFFEReload = "spiffe"
+
+// spiffeSource holds the X.509 SVID and trust bundles received from a SPIFFE Workload API, they are
+// updated by the Workload API as they rotate
+type spiffeSource struct {
+	source *workloadapi.X509Source
+	id     spiffeid.ID
+	serial string
+	log    *logrus.Entry
+}
+
+// newSPIFFESource connects to the Workload API at socket, or SPIFFE_ENDPOINT_SOCKET when empty, retrying
+// on policy until the first SVID is received
+func newSPIFFESource(ctx context.Context, socket string, policy backoff.Policy, log *logrus.Entry) (*spiffeSource, error) {
+	var opts []workloadapi.X509SourceOption
+	if socket != "" {
+		opts = append(opts, workloadapi.WithClientOptions(workloadapi.WithAddr(socket)))
+	}
+
+	s := &spiffeSource{log: log}
+
+	err := policy.For(ctx, func(try int) error {
+		timeout, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+
+		source, err := workloadapi.NewX509Source(timeout, opts...)
+		if err != nil {
+			log.Errorf("Could not obtain a SPIFFE X.509 SVID on try %d: %v", try, err)
+			return err
+		}
+
+		s.source = source
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	svid, err := s.source.GetX509SVID()
+	if err != nil {
+		s.source.Close()
+		return nil, err
+	}
+
+	s.id = svid.ID
+	s.serial = svid.Certificates[0].SerialNumber.String()
+
+	log.Infof("Using SPIFFE X.509 SVID %s valid until %v", s.id, svid.Certificates[0].NotAfter)
+
+	return s, nil
+}
+
+// tlsConfig creates a TLS configuration presenting the SVID, servers are verified using the trust bundle
+// and must present one of serverIDs or be a member of the client trust domain, when verifyBundle is false
+// only the client certificate is configured and servers are verified using the configured CA
+func (s *spiffeSource) tlsConfig(serverIDs []string, verifyBundle bool) (*tls.Config, error) {
+	if !verifyBundle {
+		return &tls.Config{MinVersion: tls.VersionTLS12, GetClientCertificate: tlsconfig.GetClientCertificate(s.source)}, nil
+	}
+
+	authorizer := tlsconfig.AuthorizeMemberOf(s.id.TrustDomain())
+
+	if len(serverIDs) > 0 {
+		var ids []spiffeid.ID
+		for _, sid := range serverIDs {
+			id, err := spiffeid.FromString(sid)
+			if err != nil {
+				return nil, fmt.Errorf("invalid spiffe server id %q: %w", sid, err)
+			}
+			ids = append(ids, id)
+		}
+
+		authorizer = tlsconfig.AuthorizeOneOf(ids...)
+	}
+
+	return tlsconfig.MTLSClientConfig(s.source, s.source, authorizer), nil
+}
+
+// watch reconnects when a new SVID is received so the connection presents it, until done is closed
+func (s *spiffeSource) watch(ctx context.Context, done chan struct{}, dialer *reconnectDialer, handler ReloadHandler) {
+	defer s.source.Close()
+
+	for {
+		select {
+		case <-s.source.Updated():
+			svid, err := s.source.GetX509SVID()
+			if err != nil {
+				s.log.Errorf("Could not obtain the updated SPIFFE X.509 SVID: %v", err)
+				if handler != nil {
+					handler(SPIFFEReload, s.id.String(), err)
+				}
+				continue
+			}
+
+			// updates also happen when only the trust bundles changed
+			serial := svid.Certificates[0].SerialNumber.String()
+			if serial == s.serial {
+				continue
+			}
+			s.serial = serial
+			s.id = svid.ID
+
+			s.log.Warnf("Received a new SPIFFE X.509 SVID %s valid until %v, reconnecting", s.id, svid.Certificates[0].NotAfter)
+			if handler != nil {
+				handler(SPIFFEReload, s.id.String(), nil)
+			}
+			dialer.reconnect()
+
+		case <-done:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package util
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+)
+
+// ClientTLSConfig creates a TLS configuration for clients other than NATS, servers are verified using ca or the system
+// certificate authorities when empty and a client certificate is presented when cert and key are set
+func ClientTLSConfig(ca string, cert string, key string) (*tls.Config, error) {
+	tlsc := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if ca != "" {
+		pem, err := os.ReadFile(ca)
+		if err != nil {
+			return nil, fmt.Errorf("could not read ca: %w", err)
+		}
+
+		tlsc.RootCAs = x509.NewCertPool()
+		if !tlsc.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ca %s", ca)
+		}
+	}
+
+	if cert != "" && key != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %w", err)
+		}
+
+		tlsc.Certificates = []tls.Certificate{pair}
+	}
+
+	return tlsc, nil
+}
+
+// TLSDialer wraps connections made by dial in TLS using tlsc
+func TLSDialer(dial func(context.Context, string, string) (net.Conn, error), tlsc *tls.Config) func(context.Context, string, string) (net.Conn, error) {
+	return func(ctx context.Context, network string, address string) (net.Conn, error) {
+		conn, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+
+		c := tlsc.Clone()
+		if c.ServerName == "" {
+			c.ServerName, _, _ = net.SplitHostPort(address)
+		}
+
+		tconn := tls.Client(conn, c)
+		err = tconn.HandshakeContext(ctx)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		return tconn, nil
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"net"
 	"net/url"
 	"os"
 	"strconv"
@@ -17,6 +18,7 @@ import (
 	"github.com/choria-io/stream-replicator/backoff"
 	"github.com/choria-io/tokens"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
 	"github.com/sirupsen/logrus"
 )
 
@@ -24,6 +26,9 @@ type tlsConfig interface {
 	CertificateAuthority() string
 	PublicCertificate() string
 	PrivateKey() string
+	SPIFFEEnabled() bool
+	SPIFFESocket() string
+	SPIFFEServerIDs() []string
 }
 
 type choriaConn interface {
@@ -32,14 +37,132 @@ type choriaConn interface {
 	Collective() string
 }
 
-func ConnectNats(ctx context.Context, name string, srv string, tlsc tlsConfig, choria choriaConn, oldStyle bool, conn nats.InProcessConnProvider, log *logrus.Entry) (nc *nats.Conn, err error) {
+type connectOpts struct {
+	reloadHandler ReloadHandler
+	reconnect     backoff.Policy
+	proxy         string
+	maxReconnects int
+	pingInterval  time.Duration
+	flushTimeout  time.Duration
+	user          string
+	password      string
+	token         string
+	inboxPrefix   string
+	auth          url.Values
+}
+
+// ConnectOption configures optional behavior of ConnectNats
+type ConnectOption func(*connectOpts)
+
+// WithReconnectPolicy sets the backoff policy used between connection attempts
+func WithReconnectPolicy(p backoff.Policy) ConnectOption {
+	return func(o *connectOpts) {
+		o.reconnect = p
+	}
+}
+
+// WithMaxReconnects limits how many times a lost connection is reconnected before it is closed, unlimited when 0
+func WithMaxReconnects(n int) ConnectOption {
+	return func(o *connectOpts) {
+		o.maxReconnects = n
+	}
+}
+
+// WithPingInterval sets how often the server is pinged to detect stale connections, the NATS default when 0
+func WithPingInterval(d time.Duration) ConnectOption {
+	return func(o *connectOpts) {
+		o.pingInterval = d
+	}
+}
+
+// WithFlusherTimeout sets how long writes to the server may block before the connection is considered stale, the
+// NATS default when 0
+func WithFlusherTimeout(d time.Duration) ConnectOption {
+	return func(o *connectOpts) {
+		o.flushTimeout = d
+	}
+}
+
+// WithProxy connects through a HTTP CONNECT or SOCKS5 proxy, see ParseProxyURL
+func WithProxy(u string) ConnectOption {
+	return func(o *connectOpts) {
+		o.proxy = u
+	}
+}
+
+// WithUserInfo authenticates using user and password
+func WithUserInfo(user string, password string) ConnectOption {
+	return func(o *connectOpts) {
+		o.user = user
+		o.password = password
+	}
+}
+
+// WithToken authenticates using token
+func WithToken(token string) ConnectOption {
+	return func(o *connectOpts) {
+		o.token = token
+	}
+}
+
+// WithCredentialsFile authenticates using a credentials file that is reloaded when it changes, unless the url sets credentials
+func WithCredentialsFile(file string) ConnectOption {
+	return func(o *connectOpts) {
+		o.auth = url.Values{"credentials": []string{file}}
+	}
+}
+
+// WithNKeySeedFile authenticates using a nkey seed file, unless the url sets credentials
+func WithNKeySeedFile(file string) ConnectOption {
+	return func(o *connectOpts) {
+		o.auth = url.Values{"seed": []string{file}}
+	}
+}
+
+// WithInboxPrefix sets the prefix of the subjects replies are received on
+func WithInboxPrefix(prefix string) ConnectOption {
+	return func(o *connectOpts) {
+		o.inboxPrefix = prefix
+	}
+}
+
+// WithReloadHandler sets a handler notified when certificates or credentials are reloaded
+func WithReloadHandler(h ReloadHandler) ConnectOption {
+	return func(o *connectOpts) {
+		o.reloadHandler = h
+	}
+}
+
+func ConnectNats(ctx context.Context, name string, srv string, tlsc tlsConfig, choria choriaConn, oldStyle bool, conn nats.InProcessConnProvider, log *logrus.Entry, options ...ConnectOption) (nc *nats.Conn, err error) {
+	copts := &connectOpts{reconnect: backoff.TwoMinutesSlowStart}
+	for _, opt := range options {
+		opt(copts)
+	}
+
+	// closed when the connection is closed to stop watching for reloads
+	closed := make(chan struct{})
+	dialer := &reconnectDialer{Dialer: net.Dialer{Timeout: nats.DefaultTimeout}}
+
+	maxReconnects := -1
+	if copts.maxReconnects > 0 {
+		maxReconnects = copts.maxReconnects
+	}
+
 	opts := []nats.Option{
-		nats.MaxReconnects(-1),
+		nats.SetCustomDialer(dialer),
+		// host names are resolved by the dialer, or the proxy, on every connection attempt
+		nats.SkipHostLookup(),
+		nats.MaxReconnects(maxReconnects),
 		nats.IgnoreAuthErrorAbort(),
 		nats.NoEcho(),
 		nats.Name(fmt.Sprintf("Choria Stream Replicator: %s", name)),
 		nats.CustomReconnectDelay(func(n int) time.Duration {
-			d := backoff.TwoMinutesSlowStart.Duration(n)
+			// reconnecting to apply new credentials should not wait
+			if dialer.wasForced() {
+				return 0
+			}
+
+			d := copts.reconnect.Duration(n)
 			log.Infof("Sleeping %v till the next reconnection attempt", d)
 			return d
 		}),
@@ -55,6 +178,8 @@ func ConnectNats(ctx context.Context, name string, srv string, tlsc tlsConfig, c
 			log.Warnf("NATS client reconnected after a previous disconnection, connected to %s", nc.ConnectedUrlRedacted())
 		}),
 		nats.ClosedHandler(func(nc *nats.Conn) {
+			close(closed)
+
 			err := nc.LastError()
 			if err == nil {
 				log.Warnf("Connection closed due to unknown reason")
@@ -72,12 +197,60 @@ func ConnectNats(ctx context.Context, name string, srv string, tlsc tlsConfig, c
 		opts = append(opts, nats.UseOldRequestStyle())
 	}
 
+	if copts.pingInterval > 0 {
+		opts = append(opts, nats.PingInterval(copts.pingInterval))
+	}
+
+	if copts.flushTimeout > 0 {
+		opts = append(opts, nats.FlusherTimeout(copts.flushTimeout))
+	}
+
+	if copts.proxy != "" {
+		dialer.proxy, err = ParseProxyURL(copts.proxy)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Infof("Connecting via proxy %s", dialer.proxy.Redacted())
+	}
+
 	var configuredTLS bool
+	var certs *certificateReloader
+	var svids *spiffeSource
+
+	defer func() {
+		if err != nil && svids != nil {
+			svids.source.Close()
+		}
+	}()
 
 	if tlsc != nil {
-		if tlsc.PrivateKey() != "" && tlsc.PublicCertificate() != "" {
-			log.Infof("Configuring Client Certificate for connection")
-			opts = append(opts, nats.ClientCert(tlsc.PublicCertificate(), tlsc.PrivateKey()))
+		switch {
+		case tlsc.SPIFFEEnabled():
+			log.Infof("Configuring SPIFFE Workload API client certificate for connection")
+			svids, err = newSPIFFESource(ctx, tlsc.SPIFFESocket(), copts.reconnect, log)
+			if err != nil {
+				return nil, err
+			}
+
+			// servers are verified using the Workload API trust bundle unless a CA is configured
+			tlsConf, err := svids.tlsConfig(tlsc.SPIFFEServerIDs(), tlsc.CertificateAuthority() == "")
+			if err != nil {
+				return nil, err
+			}
+
+			opts = append(opts, nats.Secure(tlsConf))
+			configuredTLS = true
+
+		case tlsc.PrivateKey() != "" && tlsc.PublicCertificate() != "":
+			log.Infof("Configuring Client Certificate %s for connection", tlsc.PublicCertificate())
+			certs, err = newCertificateReloader(tlsc.PublicCertificate(), tlsc.PrivateKey(), log)
+			if err != nil {
+				return nil, err
+			}
+
+			// the certificate is supplied on every connect so that reloaded certificates are used on reconnect
+			opts = append(opts, nats.Secure(&tls.Config{MinVersion: tls.VersionTLS12, GetClientCertificate: certs.getClientCertificate}))
 			configuredTLS = true
 		}
 
@@ -113,32 +286,33 @@ func ConnectNats(ctx context.Context, name string, srv string, tlsc tlsConfig, c
 		}
 	}
 
+	servers, err := parseServers(srv)
+	if err != nil {
+		return nil, err
+	}
+
+	proxyPath, err := websocketProxyPath(servers)
+	if err != nil {
+		return nil, err
+	}
+
 	var urls []string
 	var hasCreds bool
-	for _, u := range strings.Split(srv, ",") {
-		parsed, err := url.Parse(strings.TrimSpace(u))
-		if err != nil {
-			return nil, err
-		}
-
+	var creds *credentialsReloader
+	for _, parsed := range servers {
 		if !hasCreds && parsed.RawQuery != "" {
 			queries, err := url.ParseQuery(parsed.RawQuery)
 			if err != nil {
 				return nil, err
 			}
 
-			if queries.Has("credentials") {
-				creds := queries.Get("credentials")
-				log.Debugf("Using %q as credentials file", creds)
-				opts = append(opts, nats.UserCredentials(creds))
-				hasCreds = true
+			authOpts, reloader, err := authOptions(queries, log)
+			if err != nil {
+				return nil, err
 			}
-
-			if queries.Has("jwt") && queries.Has("nkey") {
-				jwt := queries.Get("jwt")
-				nkey := queries.Get("nkey")
-				log.Debugf("Using %q as jwt file and %q as nkey file", jwt, nkey)
-				opts = append(opts, nats.UserCredentials(jwt, nkey))
+			if len(authOpts) > 0 {
+				opts = append(opts, authOpts...)
+				creds = reloader
 				hasCreds = true
 			}
 			parsed.RawQuery = ""
@@ -147,11 +321,36 @@ func ConnectNats(ctx context.Context, name string, srv string, tlsc tlsConfig, c
 		urls = append(urls, parsed.Redacted())
 	}
 
+	if !hasCreds && len(copts.auth) > 0 {
+		authOpts, reloader, err := authOptions(copts.auth, log)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, authOpts...)
+		creds = reloader
+	}
+
+	switch {
+	case copts.user != "":
+		opts = append(opts, nats.UserInfo(copts.user, copts.password))
+	case copts.token != "":
+		opts = append(opts, nats.Token(copts.token))
+	}
+
+	if copts.inboxPrefix != "" {
+		opts = append(opts, nats.CustomInboxPrefix(copts.inboxPrefix))
+	}
+
+	if proxyPath != "" {
+		log.Infof("Connecting to websocket path %s", proxyPath)
+		opts = append(opts, nats.ProxyPath(proxyPath))
+	}
+
 	if conn != nil {
 		opts = append(opts, nats.InProcessServer(conn))
 	}
 
-	err = backoff.TwoMinutesSlowStart.For(ctx, func(try int) error {
+	err = copts.reconnect.For(ctx, func(try int) error {
 		log.Infof("Attempting to connect to %s on try %d", strings.Join(urls, ", "), try)
 
 		nc, err = nats.Connect(srv, opts...)
@@ -167,9 +366,153 @@ func ConnectNats(ctx context.Context, name string, srv string, tlsc tlsConfig, c
 		return nil, err
 	}
 
+	if certs != nil || creds != nil {
+		go watchReloads(ctx, closed, dialer, certs, creds, copts.reloadHandler, log)
+	}
+
+	if svids != nil {
+		go svids.watch(ctx, closed, dialer, copts.reloadHandler)
+	}
+
 	return nc, err
 }
 
+// parseServers parses the comma separated list of server urls in srv
+func parseServers(srv string) ([]*url.URL, error) {
+	var servers []*url.URL
+	for _, u := range strings.Split(srv, ",") {
+		parsed, err := url.Parse(strings.TrimSpace(u))
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, parsed)
+	}
+
+	return servers, nil
+}
+
+// websocketProxyPath is the path of websocket urls in servers, used to reach servers behind reverse proxies as the NATS
+// client otherwise ignores it, websocket and other urls can not be mixed and all paths have to be the same
+func websocketProxyPath(servers []*url.URL) (string, error) {
+	var proxyPath string
+
+	for i, u := range servers {
+		isWebsocket := u.Scheme == "ws" || u.Scheme == "wss"
+		if i > 0 && isWebsocket != (servers[0].Scheme == "ws" || servers[0].Scheme == "wss") {
+			return "", fmt.Errorf("websocket and non websocket urls can not be mixed in %s", u.Redacted())
+		}
+		if !isWebsocket {
+			continue
+		}
+
+		path := u.Path
+		if strings.Trim(path, "/") == "" {
+			path = ""
+		}
+
+		switch {
+		case i == 0:
+			proxyPath = path
+		case path != proxyPath:
+			return "", fmt.Errorf("websocket urls must all use the same path, %s does not use %q", u.Redacted(), proxyPath)
+		}
+	}
+
+	return proxyPath, nil
+}
+
+// authOptions creates the options authenticating using the credential parameters of a url, creds is set when the
+// credentials are reloaded when they change
+func authOptions(queries url.Values, log *logrus.Entry) (opts []nats.Option, creds *credentialsReloader, err error) {
+	var mechanisms []string
+	for _, q := range []string{"credentials", "credentials_command", "jwt", "seed", "seed_env"} {
+		if queries.Has(q) {
+			mechanisms = append(mechanisms, q)
+		}
+	}
+	if len(mechanisms) > 1 {
+		return nil, nil, fmt.Errorf("only one of credentials, credentials_command, jwt, seed and seed_env can be set, found %s", strings.Join(mechanisms, ", "))
+	}
+
+	switch {
+	case queries.Has("credentials"):
+		file := queries.Get("credentials")
+		log.Debugf("Using %q as credentials file", file)
+		creds = newCredentialsReloader(file, file, log)
+		opts = append(opts, nats.UserJWT(creds.userJWT, creds.sign))
+
+	case queries.Has("credentials_command"):
+		command := queries.Get("credentials_command")
+		args := queries["credentials_arg"]
+		log.Debugf("Using credentials from command %q with arguments %q", command, args)
+
+		var interval time.Duration
+		if queries.Has("credentials_refresh") {
+			interval, err = ParseDurationString(queries.Get("credentials_refresh"))
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid credentials_refresh: %w", err)
+			}
+		}
+
+		creds, err = newCredentialsCommand(command, args, interval, log)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, nats.UserJWT(creds.userJWT, creds.sign))
+
+	case queries.Has("jwt") && queries.Has("nkey"):
+		jwt := queries.Get("jwt")
+		nkey := queries.Get("nkey")
+		log.Debugf("Using %q as jwt file and %q as nkey file", jwt, nkey)
+		creds = newCredentialsReloader(jwt, nkey, log)
+		opts = append(opts, nats.UserJWT(creds.userJWT, creds.sign))
+
+	case queries.Has("seed"):
+		seed := queries.Get("seed")
+		log.Debugf("Using %q as nkey seed file", seed)
+		opt, err := nats.NkeyOptionFromSeed(seed)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid nkey seed file %s: %w", seed, err)
+		}
+		opts = append(opts, opt)
+
+	case queries.Has("seed_env"):
+		env := queries.Get("seed_env")
+		log.Debugf("Using nkey seed from environment variable %s", env)
+		opt, err := nkeyOptionFromEnv(env)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, opt)
+	}
+
+	return opts, creds, nil
+}
+
+// nkeyOptionFromEnv authenticates using a nkey seed stored in the environment variable env
+func nkeyOptionFromEnv(env string) (nats.Option, error) {
+	seed := strings.TrimSpace(os.Getenv(env))
+	if seed == "" {
+		return nil, fmt.Errorf("environment variable %s does not hold a nkey seed", env)
+	}
+
+	kp, err := nkeys.FromSeed([]byte(seed))
+	if err != nil {
+		return nil, fmt.Errorf("invalid nkey seed in environment variable %s: %w", env, err)
+	}
+
+	pub, err := kp.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if !nkeys.IsValidPublicUserKey(pub) {
+		return nil, fmt.Errorf("nkey seed in environment variable %s is not a user seed", env)
+	}
+
+	return nats.Nkey(pub, kp.Sign), nil
+}
+
 func ParseDurationString(dstr string) (dur time.Duration, err error) {
 	dstr = strings.TrimSpace(dstr)
 
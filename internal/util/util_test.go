@@ -0,0 +1,392 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/choria-io/stream-replicator/backoff"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+func TestUtil(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Util")
+}
+
+// startServer starts a NATS server using opts, on a random port of 127.0.0.1 unless set in opts
+func startServer(opts *server.Options) *server.Server {
+	if opts.Host == "" {
+		opts.Host = "127.0.0.1"
+	}
+	if opts.Port == 0 {
+		opts.Port = -1
+	}
+	opts.NoLog = true
+	opts.NoSigs = true
+
+	srv, err := server.NewServer(opts)
+	Expect(err).ToNot(HaveOccurred())
+	go srv.Start()
+	Expect(srv.ReadyForConnections(5 * time.Second)).To(BeTrue())
+	DeferCleanup(srv.Shutdown)
+
+	return srv
+}
+
+var _ = Describe("NKey Authentication", func() {
+	var (
+		log     *logrus.Entry
+		user    nkeys.KeyPair
+		seed    []byte
+		srv     *server.Server
+		connect func(query string) error
+	)
+
+	BeforeEach(func() {
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		var err error
+		user, err = nkeys.CreateUser()
+		Expect(err).ToNot(HaveOccurred())
+		seed, err = user.Seed()
+		Expect(err).ToNot(HaveOccurred())
+		pub, err := user.PublicKey()
+		Expect(err).ToNot(HaveOccurred())
+
+		srv = startServer(&server.Options{Nkeys: []*server.NkeyUser{{Nkey: pub}}})
+
+		connect = func(query string) error {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			nc, err := ConnectNats(ctx, "ginkgo", srv.ClientURL()+"?"+query, nil, nil, false, nil, log, WithReconnectPolicy(backoff.Linear(10*time.Millisecond, 10*time.Millisecond, 1, 0)))
+			if err != nil {
+				return err
+			}
+			nc.Close()
+
+			return nil
+		}
+
+		os.Unsetenv("GINKGO_NKEY_SEED")
+		DeferCleanup(os.Unsetenv, "GINKGO_NKEY_SEED")
+	})
+
+	It("Should connect using a seed file", func() {
+		sf := filepath.Join(GinkgoT().TempDir(), "user.nk")
+		Expect(os.WriteFile(sf, seed, 0600)).To(Succeed())
+
+		Expect(connect(url.Values{"seed": []string{sf}}.Encode())).To(Succeed())
+	})
+
+	It("Should connect using a seed from the environment", func() {
+		os.Setenv("GINKGO_NKEY_SEED", string(seed)+"\n")
+		Expect(connect("seed_env=GINKGO_NKEY_SEED")).To(Succeed())
+	})
+
+	It("Should fail without credentials", func() {
+		Expect(connect("")).To(HaveOccurred())
+	})
+
+	It("Should not allow seed and seed_env together", func() {
+		sf := filepath.Join(GinkgoT().TempDir(), "user.nk")
+		Expect(os.WriteFile(sf, seed, 0600)).To(Succeed())
+		os.Setenv("GINKGO_NKEY_SEED", string(seed))
+
+		err := connect(url.Values{"seed": []string{sf}, "seed_env": []string{"GINKGO_NKEY_SEED"}}.Encode())
+		Expect(err).To(MatchError("only one of credentials, credentials_command, jwt, seed and seed_env can be set, found seed, seed_env"))
+	})
+
+	It("Should handle missing and invalid seeds without disclosing them", func() {
+		Expect(connect("seed_env=GINKGO_NKEY_SEED")).To(MatchError("environment variable GINKGO_NKEY_SEED does not hold a nkey seed"))
+
+		os.Setenv("GINKGO_NKEY_SEED", "  ")
+		Expect(connect("seed_env=GINKGO_NKEY_SEED")).To(MatchError("environment variable GINKGO_NKEY_SEED does not hold a nkey seed"))
+
+		// a damaged seed
+		invalid := string(seed[:len(seed)-4]) + "AAAA"
+		os.Setenv("GINKGO_NKEY_SEED", invalid)
+		err := connect("seed_env=GINKGO_NKEY_SEED")
+		Expect(err).To(MatchError(HavePrefix("invalid nkey seed in environment variable GINKGO_NKEY_SEED")))
+		Expect(err.Error()).ToNot(ContainSubstring(invalid))
+		Expect(err.Error()).ToNot(ContainSubstring(string(seed[:10])))
+
+		// a valid seed that is not for a user
+		account, err := nkeys.CreateAccount()
+		Expect(err).ToNot(HaveOccurred())
+		aseed, err := account.Seed()
+		Expect(err).ToNot(HaveOccurred())
+		os.Setenv("GINKGO_NKEY_SEED", string(aseed))
+		err = connect("seed_env=GINKGO_NKEY_SEED")
+		Expect(err).To(MatchError("nkey seed in environment variable GINKGO_NKEY_SEED is not a user seed"))
+		Expect(err.Error()).ToNot(ContainSubstring(string(aseed[:10])))
+
+		sf := filepath.Join(GinkgoT().TempDir(), "user.nk")
+		err = connect(url.Values{"seed": []string{sf}}.Encode())
+		Expect(err).To(MatchError(HavePrefix("invalid nkey seed file " + sf)))
+
+		Expect(os.WriteFile(sf, []byte(invalid), 0600)).To(Succeed())
+		err = connect(url.Values{"seed": []string{sf}}.Encode())
+		Expect(err).To(MatchError(HavePrefix("invalid nkey seed file " + sf)))
+		Expect(err.Error()).ToNot(ContainSubstring(string(seed[:10])))
+	})
+})
+
+var _ = Describe("WebSocket urls", func() {
+	DescribeTable("websocketProxyPath",
+		func(srv string, path string, errMatch string) {
+			servers, err := parseServers(srv)
+			Expect(err).ToNot(HaveOccurred())
+
+			p, err := websocketProxyPath(servers)
+			if errMatch != "" {
+				Expect(err).To(MatchError(errMatch))
+				return
+			}
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p).To(Equal(path))
+		},
+		Entry("nats url", "nats://n1:4222", "", ""),
+		Entry("nats url with path", "nats://n1:4222/ignored", "", ""),
+		Entry("nats url list", "nats://n1:4222, tls://n2:4222", "", ""),
+		Entry("ws url", "ws://n1:8080", "", ""),
+		Entry("ws url root path", "ws://n1:8080/", "", ""),
+		Entry("wss url with path", "wss://n1:443/nats", "/nats", ""),
+		Entry("wss url with nested path and credentials", "wss://u:p@n1:443/proxy/nats/?credentials=/x", "/proxy/nats/", ""),
+		Entry("ws url list with same paths", "wss://n1/nats,wss://n2/nats", "/nats", ""),
+		Entry("ws url list without paths", "ws://n1,wss://n2/", "", ""),
+		Entry("ws url list with different paths", "wss://n1/nats,wss://n2/other", "", `websocket urls must all use the same path, wss://n2/other does not use "/nats"`),
+		Entry("ws url list with missing path", "wss://n1/nats,wss://n2", "", `websocket urls must all use the same path, wss://n2 does not use "/nats"`),
+		Entry("ws then nats", "ws://n1:8080,nats://n2:4222", "", "websocket and non websocket urls can not be mixed in nats://n2:4222"),
+		Entry("nats then wss", "nats://n1:4222,wss://u:secret@n2", "", "websocket and non websocket urls can not be mixed in wss://u:xxxxx@n2"),
+	)
+
+	It("Should connect over websockets with a path", func() {
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log := logrus.NewEntry(logger)
+
+		// the server does not expose the address of a random websocket port so pick a free one
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		port := l.Addr().(*net.TCPAddr).Port
+		Expect(l.Close()).To(Succeed())
+
+		opts := &server.Options{}
+		opts.Websocket.Host = "127.0.0.1"
+		opts.Websocket.Port = port
+		opts.Websocket.NoTLS = true
+		srv := startServer(opts)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		wsURL := fmt.Sprintf("ws://127.0.0.1:%d/nats", port)
+		nc, err := ConnectNats(ctx, "ginkgo", wsURL, nil, nil, false, nil, log)
+		Expect(err).ToNot(HaveOccurred())
+		defer nc.Close()
+
+		Expect(nc.ConnectedUrl()).To(Equal(wsURL))
+		Expect(nc.Opts.ProxyPath).To(Equal("/nats"))
+
+		_, err = ConnectNats(ctx, "ginkgo", wsURL+","+srv.ClientURL(), nil, nil, false, nil, log)
+		Expect(err).To(MatchError("websocket and non websocket urls can not be mixed in " + srv.ClientURL()))
+	})
+})
+
+var _ = Describe("DNS Resolution", func() {
+	var (
+		log       *logrus.Entry
+		addresses map[string][]string
+		lookups   map[string]int
+		mu        sync.Mutex
+	)
+
+	BeforeEach(func() {
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		addresses = map[string][]string{}
+		lookups = map[string]int{}
+
+		DeferCleanup(func(l func(context.Context, string) ([]string, error)) { lookupHost = l }, lookupHost)
+		lookupHost = func(_ context.Context, host string) ([]string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			lookups[host]++
+			addrs, ok := addresses[host]
+			if !ok {
+				return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+			}
+
+			return append([]string{}, addrs...), nil
+		}
+	})
+
+	resolve := func(host string, addrs ...string) {
+		mu.Lock()
+		addresses[host] = addrs
+		mu.Unlock()
+	}
+
+	lookupCount := func(host string) int {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return lookups[host]
+	}
+
+	It("Should try all addresses and resolve again on every dial", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		defer listener.Close()
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				conn.Close()
+			}
+		}()
+
+		port := fmt.Sprintf("%d", listener.Addr().(*net.TCPAddr).Port)
+		dialer := &reconnectDialer{Dialer: net.Dialer{Timeout: time.Second}}
+
+		_, err = dialer.Dial("tcp", net.JoinHostPort("nats.example.net", port))
+		Expect(err).To(MatchError(ContainSubstring("no such host")))
+
+		// nothing listens on 127.0.0.2 so only one of the addresses can be connected to
+		resolve("nats.example.net", "127.0.0.2", "127.0.0.1")
+		for i := 0; i < 5; i++ {
+			conn, err := dialer.Dial("tcp", net.JoinHostPort("nats.example.net", port))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(conn.RemoteAddr().String()).To(Equal(listener.Addr().String()))
+			conn.Close()
+		}
+		Expect(lookupCount("nats.example.net")).To(Equal(6))
+
+		resolve("nats.example.net", "127.0.0.2")
+		_, err = dialer.Dial("tcp", net.JoinHostPort("nats.example.net", port))
+		Expect(err).To(HaveOccurred())
+
+		// addresses are dialed without resolving
+		conn, err := dialer.Dial("tcp", listener.Addr().String())
+		Expect(err).ToNot(HaveOccurred())
+		conn.Close()
+		Expect(lookupCount("nats.example.net")).To(Equal(7))
+	})
+
+	It("Should reconnect to the servers the name resolves to after a failure", func() {
+		srv1 := startServer(&server.Options{})
+		port := srv1.Addr().(*net.TCPAddr).Port
+		resolve("nats.example.net", "127.0.0.1")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		reconnected := make(chan struct{}, 1)
+		nc, err := ConnectNats(ctx, "ginkgo", fmt.Sprintf("nats://nats.example.net:%d", port), nil, nil, false, nil, log, WithReconnectPolicy(backoff.Linear(10*time.Millisecond, 10*time.Millisecond, 1, 0)))
+		Expect(err).ToNot(HaveOccurred())
+		defer nc.Close()
+		nc.SetReconnectHandler(func(_ *nats.Conn) { reconnected <- struct{}{} })
+		Expect(nc.ConnectedAddr()).To(Equal(srv1.Addr().String()))
+
+		// the name moves to a new server on another address that the client was never told about
+		resolve("nats.example.net", "127.0.0.2")
+		srv2 := startServer(&server.Options{Host: "127.0.0.2", Port: port})
+		srv1.Shutdown()
+
+		Eventually(reconnected, "5s").Should(Receive())
+		Expect(nc.ConnectedAddr()).To(Equal(srv2.Addr().String()))
+		Expect(nc.ConnectedUrl()).To(Equal(fmt.Sprintf("nats://nats.example.net:%d", port)))
+		Expect(nc.Flush()).To(Succeed())
+	})
+})
+
+var _ = Describe("Authentication Options", func() {
+	var log *logrus.Entry
+
+	BeforeEach(func() {
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+	})
+
+	connect := func(srv *server.Server, opts ...ConnectOption) (*nats.Conn, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+
+		opts = append(opts, WithReconnectPolicy(backoff.Linear(10*time.Millisecond, 10*time.Millisecond, 1, 0)))
+
+		return ConnectNats(ctx, "ginkgo", srv.ClientURL(), nil, nil, false, nil, log, opts...)
+	}
+
+	It("Should authenticate using user and password", func() {
+		srv := startServer(&server.Options{Username: "bob", Password: "s3cret"})
+
+		_, err := connect(srv)
+		Expect(err).To(HaveOccurred())
+
+		_, err = connect(srv, WithUserInfo("bob", "wrong"))
+		Expect(err).To(HaveOccurred())
+
+		nc, err := connect(srv, WithUserInfo("bob", "s3cret"), WithInboxPrefix("_INBOX_bob"))
+		Expect(err).ToNot(HaveOccurred())
+		defer nc.Close()
+		Expect(nc.NewRespInbox()).To(HavePrefix("_INBOX_bob."))
+	})
+
+	It("Should authenticate using a token", func() {
+		srv := startServer(&server.Options{Authorization: "s3cret"})
+
+		_, err := connect(srv)
+		Expect(err).To(HaveOccurred())
+
+		nc, err := connect(srv, WithToken("s3cret"))
+		Expect(err).ToNot(HaveOccurred())
+		nc.Close()
+	})
+
+	It("Should authenticate using a nkey seed file", func() {
+		kp, err := nkeys.CreateUser()
+		Expect(err).ToNot(HaveOccurred())
+		pub, err := kp.PublicKey()
+		Expect(err).ToNot(HaveOccurred())
+		seed, err := kp.Seed()
+		Expect(err).ToNot(HaveOccurred())
+
+		seedFile := filepath.Join(GinkgoT().TempDir(), "user.nk")
+		Expect(os.WriteFile(seedFile, seed, 0600)).To(Succeed())
+
+		srv := startServer(&server.Options{Nkeys: []*server.NkeyUser{{Nkey: pub}}})
+
+		_, err = connect(srv)
+		Expect(err).To(HaveOccurred())
+
+		nc, err := connect(srv, WithNKeySeedFile(seedFile))
+		Expect(err).ToNot(HaveOccurred())
+		nc.Close()
+	})
+})
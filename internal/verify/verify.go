@@ -0,0 +1,126 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package verify calculates digests of the message payloads in streams and compares those of sources and targets
+package verify
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"sort"
+)
+
+// Sum is the digest of a sequence of message payloads, Digest is set by Finish
+type Sum struct {
+	Messages uint64 `json:"messages"`
+	Digest   string `json:"digest"`
+
+	h hash.Hash
+}
+
+// StreamSum is the digest of the messages in a stream, and of the messages of every subject when tracking subjects
+type StreamSum struct {
+	Sum
+	Stream   string          `json:"stream"`
+	LastSeq  uint64          `json:"last_sequence"`
+	Subjects map[string]*Sum `json:"subjects,omitempty"`
+
+	perSubject bool
+}
+
+// Result is the comparison of the digests of a source and target stream
+type Result struct {
+	Name     string     `json:"name"`
+	Source   *StreamSum `json:"source"`
+	Target   *StreamSum `json:"target"`
+	Match    bool       `json:"match"`
+	Diverged []string   `json:"diverged_subjects,omitempty"`
+}
+
+// Add adds a message payload to the digest
+func (s *Sum) Add(data []byte) {
+	if s.h == nil {
+		s.h = sha256.New()
+	}
+
+	// length prefixes ensure payloads split differently do not produce the same digest
+	var l [8]byte
+	binary.BigEndian.PutUint64(l[:], uint64(len(data)))
+	s.h.Write(l[:])
+	s.h.Write(data)
+	s.Messages++
+}
+
+// Finish calculates Digest from the payloads added
+func (s *Sum) Finish() {
+	if s.h == nil {
+		s.h = sha256.New()
+	}
+
+	s.Digest = hex.EncodeToString(s.h.Sum(nil))
+}
+
+// NewStreamSum creates a StreamSum for messages in stream up to lastSeq, tracking every subject when perSubject is set
+func NewStreamSum(stream string, lastSeq uint64, perSubject bool) *StreamSum {
+	return &StreamSum{Stream: stream, LastSeq: lastSeq, perSubject: perSubject}
+}
+
+// Add adds a message payload published to subject to the digest
+func (s *StreamSum) Add(subject string, data []byte) {
+	s.Sum.Add(data)
+
+	if !s.perSubject {
+		return
+	}
+
+	if s.Subjects == nil {
+		s.Subjects = make(map[string]*Sum)
+	}
+
+	sum, ok := s.Subjects[subject]
+	if !ok {
+		sum = &Sum{}
+		s.Subjects[subject] = sum
+	}
+	sum.Add(data)
+}
+
+// Finish calculates the digests of the stream and every subject
+func (s *StreamSum) Finish() {
+	s.Sum.Finish()
+	for _, sum := range s.Subjects {
+		sum.Finish()
+	}
+}
+
+// Compare compares finished source and target digests of the configuration name, subjects found in only one stream or
+// with different digests are reported as diverged
+func Compare(name string, source *StreamSum, target *StreamSum) *Result {
+	res := &Result{
+		Name:   name,
+		Source: source,
+		Target: target,
+		Match:  source.Messages == target.Messages && source.Digest == target.Digest,
+	}
+
+	seen := make(map[string]struct{})
+	for subj := range source.Subjects {
+		seen[subj] = struct{}{}
+	}
+	for subj := range target.Subjects {
+		seen[subj] = struct{}{}
+	}
+
+	for subj := range seen {
+		s, t := source.Subjects[subj], target.Subjects[subj]
+		if s == nil || t == nil || s.Digest != t.Digest {
+			res.Diverged = append(res.Diverged, subj)
+		}
+	}
+	sort.Strings(res.Diverged)
+
+	return res
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package verify
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestVerify(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Verify")
+}
+
+var _ = Describe("Verify", func() {
+	sum := func(perSubject bool, msgs ...string) *StreamSum {
+		s := NewStreamSum("ORDERS", uint64(len(msgs)), perSubject)
+		for i := 0; i < len(msgs); i += 2 {
+			s.Add(msgs[i], []byte(msgs[i+1]))
+		}
+		s.Finish()
+
+		return s
+	}
+
+	Describe("Sum", func() {
+		It("Should digest empty streams", func() {
+			s := &Sum{}
+			s.Finish()
+
+			Expect(s.Messages).To(Equal(uint64(0)))
+			Expect(s.Digest).To(Equal("e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"))
+		})
+
+		It("Should produce the same digest for the same payloads", func() {
+			a := &Sum{}
+			a.Add([]byte("one"))
+			a.Add([]byte("two"))
+			a.Finish()
+
+			b := &Sum{}
+			b.Add([]byte("one"))
+			b.Add([]byte("two"))
+			b.Finish()
+
+			Expect(a.Messages).To(Equal(uint64(2)))
+			Expect(a.Digest).To(Equal(b.Digest))
+		})
+
+		It("Should produce different digests for payloads split differently", func() {
+			a := &Sum{}
+			a.Add([]byte("ab"))
+			a.Add([]byte("c"))
+			a.Finish()
+
+			b := &Sum{}
+			b.Add([]byte("a"))
+			b.Add([]byte("bc"))
+			b.Finish()
+
+			Expect(a.Digest).ToNot(Equal(b.Digest))
+		})
+	})
+
+	Describe("StreamSum", func() {
+		It("Should only track subjects when requested", func() {
+			s := sum(false, "orders.new", "1", "orders.shipped", "2")
+			Expect(s.Messages).To(Equal(uint64(2)))
+			Expect(s.Subjects).To(BeNil())
+
+			s = sum(true, "orders.new", "1", "orders.shipped", "2", "orders.new", "3")
+			Expect(s.Messages).To(Equal(uint64(3)))
+			Expect(s.Subjects).To(HaveLen(2))
+			Expect(s.Subjects["orders.new"].Messages).To(Equal(uint64(2)))
+			Expect(s.Subjects["orders.shipped"].Messages).To(Equal(uint64(1)))
+			Expect(s.Subjects["orders.new"].Digest).To(Equal(sum(false, "orders.new", "1", "orders.new", "3").Digest))
+		})
+	})
+
+	Describe("Compare", func() {
+		It("Should match identical streams", func() {
+			res := Compare("EDGE", sum(true, "orders.new", "1", "orders.shipped", "2"), sum(true, "orders.new", "1", "orders.shipped", "2"))
+
+			Expect(res.Name).To(Equal("EDGE"))
+			Expect(res.Match).To(BeTrue())
+			Expect(res.Diverged).To(BeEmpty())
+		})
+
+		It("Should not match streams with different payloads or counts", func() {
+			Expect(Compare("EDGE", sum(false, "orders.new", "1"), sum(false, "orders.new", "2")).Match).To(BeFalse())
+			Expect(Compare("EDGE", sum(false, "orders.new", "1"), sum(false, "orders.new", "1", "orders.new", "1")).Match).To(BeFalse())
+			Expect(Compare("EDGE", sum(false), sum(false)).Match).To(BeTrue())
+		})
+
+		It("Should report diverged subjects in order", func() {
+			source := sum(true, "orders.new", "1", "orders.shipped", "2", "orders.returned", "3")
+			target := sum(true, "orders.new", "1", "orders.shipped", "x", "orders.lost", "4")
+
+			res := Compare("EDGE", source, target)
+			Expect(res.Match).To(BeFalse())
+			Expect(res.Diverged).To(Equal([]string{"orders.lost", "orders.returned", "orders.shipped"}))
+		})
+	})
+})
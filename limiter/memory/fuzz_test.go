@@ -0,0 +1,59 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package memory
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+func FuzzProcessAndRecord(f *testing.F) {
+	f.Add([]byte(`{"sender":"some.node"}`), "sender", "test.1", 2, "some.node")
+	f.Add([]byte(`{"a":{"b":[1,2,{"c":"x"}]}}`), "a.b.2.c", "test", -1, "")
+	f.Add([]byte(`{"a":`), "a.#.b|@reverse", "", 5, "x")
+	f.Add([]byte(`[]`), "..*?", "a..b", 0, "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := sync.WaitGroup{}
+	f.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	log := logrus.NewEntry(logger)
+
+	cfg := &config.Stream{InspectDuration: time.Hour, WarnDuration: 30 * time.Minute, PayloadSizeTrigger: 1024, InspectJSONField: "sender"}
+	l, err := New(ctx, &wg, cfg, "GINKGO", "GINKGO", nil, log)
+	if err != nil {
+		f.Fatalf("limiter failed: %v", err)
+	}
+
+	handler := func(_ *nats.Msg, _ bool) error { return nil }
+
+	f.Fuzz(func(t *testing.T, data []byte, field string, subject string, token int, header string) {
+		msg := nats.NewMsg(subject)
+		msg.Data = data
+		msg.Header.Add("sender", header)
+
+		for _, mode := range []*limiter{
+			{jsonField: field, processed: l.processed, name: l.name, replicator: l.replicator},
+			{token: token, processed: l.processed, name: l.name, replicator: l.replicator},
+			{header: "sender", processed: l.processed, name: l.name, replicator: l.replicator},
+		} {
+			if err := mode.ProcessAndRecord(msg, handler); err != nil {
+				t.Fatalf("process failed: %v", err)
+			}
+		}
+	})
+}
@@ -35,7 +35,8 @@ type limiter struct {
 
 var _EMPTY_ = ""
 
-func New(ctx context.Context, wg *sync.WaitGroup, cfg *config.Stream, name string, replicator string, nc *nats.Conn, log *logrus.Entry) (*limiter, error) {
+// New creates a memory limiter, opts configure the tracker that records processed items
+func New(ctx context.Context, wg *sync.WaitGroup, cfg *config.Stream, name string, replicator string, nc *nats.Conn, log *logrus.Entry, opts ...idtrack.Option) (*limiter, error) {
 	if cfg.InspectDuration == 0 {
 		return nil, fmt.Errorf("inspect duration not set, memory limiter can not start")
 	}
@@ -81,7 +82,7 @@ func New(ctx context.Context, wg *sync.WaitGroup, cfg *config.Stream, name strin
 	}
 
 	var err error
-	l.processed, err = idtrack.New(ctx, wg, l.duration, cfg.WarnDuration, cfg.PayloadSizeTrigger, l.stateFile, l.stream, cfg.Name, replicator, nc, l.syncSubj, log)
+	l.processed, err = idtrack.New(ctx, wg, l.duration, cfg.WarnDuration, cfg.PayloadSizeTrigger, l.stateFile, cfg.StateKey, l.stream, cfg.Name, replicator, nc, l.syncSubj, log, opts...)
 	if err != nil {
 		return nil, err
 	}
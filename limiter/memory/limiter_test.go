@@ -11,7 +11,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/choria-io/stream-replicator/clock"
 	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/idtrack"
 	"github.com/nats-io/nats.go"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -219,4 +221,35 @@ var _ = Describe("Limiter", func() {
 		Expect(processed).To(Equal(1))
 		Expect(skipped).To(Equal(1))
 	})
+
+	It("Should process values again once the inspect duration passed", func() {
+		mock := clock.NewMock(time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC))
+
+		cfg.InspectHeaderValue = "sender"
+		limiter, err := New(ctx, &wg, cfg, "GINKGO", "GINKGO", nil, log, idtrack.WithClock(mock))
+		Expect(err).ToNot(HaveOccurred())
+
+		msg := nats.NewMsg("test")
+		msg.Header.Add("sender", "some.node")
+		msg.Data = []byte(`{}`)
+
+		processed := 0
+		handler := func(msg *nats.Msg, process bool) error {
+			if process {
+				processed++
+			}
+
+			return nil
+		}
+
+		Expect(limiter.ProcessAndRecord(msg, handler)).ToNot(HaveOccurred())
+		mock.Add(50 * time.Minute)
+		Expect(limiter.ProcessAndRecord(msg, handler)).ToNot(HaveOccurred())
+		Expect(processed).To(Equal(1))
+
+		// the deadline is splayed by up to 10% of the duration
+		mock.Add(time.Hour)
+		Expect(limiter.ProcessAndRecord(msg, handler)).ToNot(HaveOccurred())
+		Expect(processed).To(Equal(2))
+	})
 })
@@ -0,0 +1,284 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package metrics forwards the metrics of replicators to each other over NATS so
+// that one monitor port can expose the metrics of an entire HA group
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/clock"
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/util"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// InstanceHeader uniquely identifies the process that forwarded metrics
+	InstanceHeader = "Choria-SR-Instance"
+	// HostHeader is the host name of the replicator that forwarded metrics
+	HostHeader = "Choria-SR-Host"
+	// ForwardedLabel is the label added to forwarded metrics holding the host they were forwarded from
+	ForwardedLabel = "forwarded_from"
+)
+
+var invalidTokenChars = regexp.MustCompile(`[^a-zA-Z0-9_\-]`)
+
+// Forwarder periodically publishes the metrics of this replicator and, when aggregating, collects those of others
+type Forwarder struct {
+	cfg        *config.MetricsForwarding
+	replicator string
+	host       string
+	instance   string
+	gatherer   prometheus.Gatherer
+	clock      clock.Clock
+	nc         *nats.Conn
+	peers      map[string]*peer
+	log        *logrus.Entry
+	mu         sync.Mutex
+}
+
+// peer is the last metrics received from another replicator, a restarted replicator replaces its earlier metrics
+type peer struct {
+	host     string
+	families []*dto.MetricFamily
+	seen     time.Time
+}
+
+// Option configures optional forwarder behavior
+type Option func(f *Forwarder)
+
+// WithClock sets the clock used to schedule forwarding and expire peers, defaults to real time
+func WithClock(c clock.Clock) Option {
+	return func(f *Forwarder) { f.clock = c }
+}
+
+// WithGatherer sets the metrics that are forwarded, defaults to prometheus.DefaultGatherer
+func WithGatherer(g prometheus.Gatherer) Option {
+	return func(f *Forwarder) { f.gatherer = g }
+}
+
+// WithHostname sets the host name metrics are forwarded as, defaults to the host name of the machine
+func WithHostname(h string) Option {
+	return func(f *Forwarder) { f.host = h }
+}
+
+// New creates a forwarder that starts forwarding metrics once Run is called
+func New(cfg *config.MetricsForwarding, replicator string, log *logrus.Entry, opts ...Option) (*Forwarder, error) {
+	f := &Forwarder{
+		cfg:        cfg,
+		replicator: replicator,
+		gatherer:   prometheus.DefaultGatherer,
+		clock:      clock.New(),
+		peers:      make(map[string]*peer),
+		log:        log.WithField("metrics", cfg.Subject),
+	}
+
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	var err error
+	if f.host == "" {
+		f.host, err = os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("could not determine host name: %v", err)
+		}
+	}
+
+	id := make([]byte, 8)
+	_, err = rand.Read(id)
+	if err != nil {
+		return nil, err
+	}
+	f.instance = hex.EncodeToString(id)
+
+	return f, nil
+}
+
+// Subject is the subject this replicator forwards its metrics to
+func (f *Forwarder) Subject() string {
+	return fmt.Sprintf("%s.%s.%s", f.cfg.Subject, invalidTokenChars.ReplaceAllString(f.replicator, "_"), invalidTokenChars.ReplaceAllString(f.host, "_"))
+}
+
+// Aggregating indicates if metrics forwarded by other replicators are collected
+func (f *Forwarder) Aggregating() bool {
+	return f.cfg.Aggregate
+}
+
+// Run connects to NATS and forwards metrics until ctx is canceled, when aggregating metrics forwarded by others are collected
+func (f *Forwarder) Run(ctx context.Context, wg *sync.WaitGroup) error {
+	var err error
+
+	f.nc, err = util.ConnectNats(ctx, "metrics", f.cfg.URL, &f.cfg.TLS, &f.cfg.Choria, false, f.cfg.Process, f.log.WithField("connection", "metrics"), append(f.cfg.Reconnect.ConnectOptions(), util.WithProxy(f.cfg.Proxy))...)
+	if err != nil {
+		return err
+	}
+
+	if f.cfg.Aggregate {
+		_, err = f.nc.Subscribe(f.cfg.Subject+".>", f.handle)
+		if err != nil {
+			f.nc.Close()
+			return err
+		}
+
+		f.log.Infof("Aggregating metrics forwarded to %s.>", f.cfg.Subject)
+	}
+
+	wg.Add(1)
+	go f.forward(ctx, wg)
+
+	f.log.Infof("Forwarding metrics to %s every %v", f.Subject(), f.cfg.Interval)
+
+	return nil
+}
+
+func (f *Forwarder) forward(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer f.nc.Close()
+
+	ticker := f.clock.NewTicker(f.cfg.Interval)
+	defer ticker.Stop()
+
+	f.publish()
+
+	for {
+		select {
+		case <-ticker.C():
+			f.publish()
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// publish publishes the current local metrics
+func (f *Forwarder) publish() {
+	families, err := f.gatherer.Gather()
+	if err != nil {
+		// partial results are still forwarded, the same as a scrape would expose them
+		f.log.Warnf("Could not gather all metrics: %v", err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	enc := expfmt.NewEncoder(buf, expfmt.FmtProtoDelim)
+	for _, mf := range families {
+		err = enc.Encode(mf)
+		if err != nil {
+			forwardErrors.WithLabelValues(f.replicator).Inc()
+			f.log.Errorf("Could not encode metric %s: %v", mf.GetName(), err)
+			return
+		}
+	}
+
+	msg := nats.NewMsg(f.Subject())
+	msg.Header.Add(InstanceHeader, f.instance)
+	msg.Header.Add(HostHeader, f.host)
+	msg.Data = buf.Bytes()
+
+	err = f.nc.PublishMsg(msg)
+	if err != nil {
+		forwardErrors.WithLabelValues(f.replicator).Inc()
+		f.log.Errorf("Could not forward metrics: %v", err)
+		return
+	}
+
+	forwardedCount.WithLabelValues(f.replicator).Inc()
+}
+
+// handle stores metrics forwarded by another replicator
+func (f *Forwarder) handle(msg *nats.Msg) {
+	instance := msg.Header.Get(InstanceHeader)
+	if instance == "" || instance == f.instance {
+		return
+	}
+
+	host := msg.Header.Get(HostHeader)
+	if host == "" {
+		host = msg.Subject
+	}
+
+	families, err := decode(msg.Data, host)
+	if err != nil {
+		aggregateErrors.WithLabelValues(f.replicator).Inc()
+		f.log.Errorf("Invalid metrics received from %s: %v", host, err)
+		return
+	}
+
+	f.mu.Lock()
+	f.peers[host] = &peer{host: host, families: families, seen: f.clock.Now()}
+	f.mu.Unlock()
+}
+
+// decode decodes forwarded metric families adding the ForwardedLabel to every metric
+func decode(data []byte, host string) ([]*dto.MetricFamily, error) {
+	var families []*dto.MetricFamily
+
+	dec := expfmt.NewDecoder(bytes.NewReader(data), expfmt.FmtProtoDelim)
+	for {
+		mf := &dto.MetricFamily{}
+		err := dec.Decode(mf)
+		if errors.Is(err, io.EOF) {
+			return families, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range mf.Metric {
+			labels := []*dto.LabelPair{{Name: stringPtr(ForwardedLabel), Value: stringPtr(host)}}
+			for _, l := range m.Label {
+				if l.GetName() != ForwardedLabel {
+					labels = append(labels, l)
+				}
+			}
+			sort.Slice(labels, func(i, j int) bool { return labels[i].GetName() < labels[j].GetName() })
+			m.Label = labels
+		}
+
+		families = append(families, mf)
+	}
+}
+
+// Gather implements prometheus.Gatherer returning the metrics forwarded by other replicators within the last 3 intervals
+func (f *Forwarder) Gather() ([]*dto.MetricFamily, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var res []*dto.MetricFamily
+	for host, p := range f.peers {
+		if f.clock.Since(p.seen) > 3*f.cfg.Interval {
+			f.log.Warnf("Expiring metrics forwarded from %s after not receiving any since %v", p.host, p.seen)
+			delete(f.peers, host)
+			continue
+		}
+
+		res = append(res, p.families...)
+	}
+
+	aggregatedPeers.WithLabelValues(f.replicator).Set(float64(len(f.peers)))
+
+	return res, nil
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/choria-io/stream-replicator/clock"
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+)
+
+func TestMetrics(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Metrics")
+}
+
+var _ = Describe("Metrics", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	// registry creates a registry with a copied_messages counter set to value
+	registry := func(value float64) *prometheus.Registry {
+		reg := prometheus.NewRegistry()
+		ctr := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "copied_messages", Help: "Copied messages"}, []string{"stream"})
+		reg.MustRegister(ctr)
+		ctr.WithLabelValues("ORDERS").Add(value)
+
+		return reg
+	}
+
+	// copied finds the copied_messages value per forwarded_from label, local metrics are stored under local
+	copied := func(families []*dto.MetricFamily) map[string]float64 {
+		res := make(map[string]float64)
+		for _, mf := range families {
+			if mf.GetName() != "copied_messages" {
+				continue
+			}
+
+			for _, m := range mf.Metric {
+				from := "local"
+				for _, l := range m.Label {
+					if l.GetName() == ForwardedLabel {
+						from = l.GetValue()
+					}
+				}
+				res[from] = m.GetCounter().GetValue()
+			}
+		}
+
+		return res
+	}
+
+	It("Should forward metrics to aggregating replicators", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, _ *jsm.Manager) {
+			clk := clock.NewMock(time.Now())
+
+			aggregator, err := New(&config.MetricsForwarding{URL: nc.ConnectedUrl(), Subject: config.DefaultMetricsSubject, Interval: time.Second, Aggregate: true}, "GINKGO", log, WithHostname("sr1.example.net"), WithGatherer(registry(10)), WithClock(clk))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aggregator.Subject()).To(Equal("choria.stream-replicator.metrics.GINKGO.sr1_example_net"))
+			Expect(aggregator.Run(ctx, &wg)).To(Succeed())
+
+			standby, err := New(&config.MetricsForwarding{URL: nc.ConnectedUrl(), Subject: config.DefaultMetricsSubject, Interval: time.Hour}, "GINKGO", log, WithHostname("sr2.example.net"), WithGatherer(registry(5)))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(standby.Run(ctx, &wg)).To(Succeed())
+
+			Eventually(func() map[string]float64 {
+				families, err := aggregator.Gather()
+				Expect(err).ToNot(HaveOccurred())
+				return copied(families)
+			}, 5*time.Second).Should(Equal(map[string]float64{"sr2.example.net": 5}))
+
+			standbyFamilies, err := standby.Gather()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(standbyFamilies).To(BeEmpty())
+
+			families, err := prometheus.Gatherers{registry(10), aggregator}.Gather()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(copied(families)).To(Equal(map[string]float64{"local": 10, "sr2.example.net": 5}))
+
+			clk.Add(4 * time.Second)
+			families, err = aggregator.Gather()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(families).To(BeEmpty())
+		})
+	})
+})
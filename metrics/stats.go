@@ -0,0 +1,35 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	forwardedCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "metrics", "forwarded_count"),
+		Help: "The number of times metrics were forwarded to aggregating replicators",
+	}, []string{"replicator"})
+	forwardErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "metrics", "forward_errors"),
+		Help: "The number of times forwarding metrics failed",
+	}, []string{"replicator"})
+	aggregateErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "metrics", "aggregate_errors"),
+		Help: "The number of invalid forwarded metrics received",
+	}, []string{"replicator"})
+	aggregatedPeers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "metrics", "aggregated_replicators"),
+		Help: "The number of replicators whose forwarded metrics are exposed",
+	}, []string{"replicator"})
+)
+
+func init() {
+	prometheus.MustRegister(forwardedCount)
+	prometheus.MustRegister(forwardErrors)
+	prometheus.MustRegister(aggregateErrors)
+	prometheus.MustRegister(aggregatedPeers)
+}
@@ -0,0 +1,40 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+// adaptiveBatch sizes batches of messages between a minimum and maximum, doubling the size while more messages than a
+// batch are pending in the source and halving it once fewer than half a batch are
+type adaptiveBatch struct {
+	min  int
+	max  int
+	size int
+}
+
+func newAdaptiveBatch(min int, max int) *adaptiveBatch {
+	if max < min {
+		max = min
+	}
+
+	return &adaptiveBatch{min: min, max: max, size: min}
+}
+
+// adjust is the size of the next batch given how many messages are pending
+func (a *adaptiveBatch) adjust(pending uint64) int {
+	switch {
+	case pending > uint64(a.size) && a.size < a.max:
+		a.size *= 2
+		if a.size > a.max {
+			a.size = a.max
+		}
+
+	case pending < uint64(a.size/2) && a.size > a.min:
+		a.size /= 2
+		if a.size < a.min {
+			a.size = a.min
+		}
+	}
+
+	return a.size
+}
@@ -0,0 +1,37 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Adaptive Batch", func() {
+	It("Should not exceed a fixed size", func() {
+		b := newAdaptiveBatch(10, 0)
+		Expect(b.adjust(1000)).To(Equal(10))
+		Expect(b.adjust(0)).To(Equal(10))
+	})
+
+	It("Should grow and shrink within bounds", func() {
+		b := newAdaptiveBatch(10, 100)
+		Expect(b.size).To(Equal(10))
+
+		Expect(b.adjust(10)).To(Equal(10))
+		Expect(b.adjust(1000)).To(Equal(20))
+		Expect(b.adjust(1000)).To(Equal(40))
+		Expect(b.adjust(1000)).To(Equal(80))
+		Expect(b.adjust(1000)).To(Equal(100))
+		Expect(b.adjust(1000)).To(Equal(100))
+
+		Expect(b.adjust(60)).To(Equal(100))
+		Expect(b.adjust(40)).To(Equal(50))
+		Expect(b.adjust(0)).To(Equal(25))
+		Expect(b.adjust(0)).To(Equal(12))
+		Expect(b.adjust(0)).To(Equal(10))
+		Expect(b.adjust(0)).To(Equal(10))
+	})
+})
@@ -0,0 +1,450 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/choria-io/stream-replicator/backoff"
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/archive"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/jsm.go/api"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// archivePullBatch is how many messages are requested from the source consumer at a time
+const archivePullBatch = 100
+
+// archiveCopier collects messages from the source stream into batches stored as objects in an archive. The archive
+// manifest records the last stored sequence, copying resumes from there and messages are only acknowledged once stored
+type archiveCopier struct {
+	s        *Stream
+	sr       *config.Config
+	cfg      *config.Stream
+	acfg     *config.Archive
+	source   *Target
+	store    archive.Store
+	cname    string
+	msgs     chan *nats.Msg
+	manifest *archive.Manifest
+	records  []*archive.Record
+	size     int64
+	last     *nats.Msg
+	lastSeq  uint64
+	log      *logrus.Entry
+}
+
+func newArchiveCopier(s *Stream, log *logrus.Entry) *archiveCopier {
+	return &archiveCopier{
+		s:      s,
+		sr:     s.sr,
+		cfg:    s.cfg,
+		acfg:   s.cfg.TargetArchive,
+		source: s.source,
+		store:  s.archive,
+		cname:  s.cname,
+		msgs:   make(chan *nats.Msg, archivePullBatch),
+		log: log.WithFields(logrus.Fields{
+			"copier":   "archive",
+			"consumer": s.cname,
+		}),
+	}
+}
+
+func (s *Stream) connectArchive() (err error) {
+	s.archive, err = archive.NewStore(s.cfg.TargetArchive, s.cfg.TargetProxy)
+	if err != nil {
+		return fmt.Errorf("archive setup failed: %v", err)
+	}
+
+	return nil
+}
+
+// loadArchiveManifest loads the manifest for stream retrying by policy until it succeeds, nil when nothing was archived yet
+func loadArchiveManifest(ctx context.Context, store archive.Store, prefix string, stream string, policy backoff.Policy, log *logrus.Entry) (manifest *archive.Manifest, err error) {
+	err = policy.For(ctx, func(try int) error {
+		manifest, err = archive.LoadManifest(ctx, store, prefix, stream)
+		switch {
+		case errors.Is(err, archive.ErrNotFound):
+			manifest = nil
+			return nil
+		case err != nil:
+			log.Errorf("Loading archive manifest failed on try %d: %v", try, err)
+		}
+
+		return err
+	})
+
+	return manifest, err
+}
+
+func (c *archiveCopier) copyMessages(ctx context.Context) error {
+	c.log.Infof("Starting archive data copier for %s", c.cfg.Stream)
+
+	var err error
+
+	c.manifest, err = loadArchiveManifest(ctx, c.store, c.acfg.Prefix, c.cfg.Stream, c.s.retries, c.log)
+	if err != nil {
+		c.log.Warnf("Copier shutting down after context interrupt")
+		return nil
+	}
+	if c.manifest == nil {
+		c.manifest = &archive.Manifest{Stream: c.cfg.Stream}
+	}
+
+	c.lastSeq = c.manifest.LastSeq
+
+	err = c.s.retries.For(ctx, func(try int) error {
+		err := c.recreateConsumer()
+		if err != nil {
+			c.log.Errorf("Creating consumer failed on try %d: %v", try, err)
+		}
+
+		return err
+	})
+	if err != nil {
+		c.log.Warnf("Copier shutting down after context interrupt")
+		return nil
+	}
+
+	inbox := c.source.nc.NewRespInbox()
+	c.source.mu.Lock()
+	c.source.sub, err = c.source.nc.ChanSubscribe(inbox, c.msgs)
+	c.source.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	poll := func() {
+		err := c.source.consumer.NextMsgRequest(inbox, &api.JSApiConsumerGetNextRequest{Expires: pollFrequency, Batch: archivePullBatch, MaxBytes: c.s.fetchMaxBytes()})
+		if err != nil {
+			c.log.Errorf("Could not request next messages: %v", err)
+		}
+	}
+
+	var flushC <-chan time.Time
+	outstanding := 0
+
+	polls := time.NewTicker(pollFrequency)
+	health := time.NewTicker(c.s.hcInterval)
+	liveness := time.NewTicker(livenessInterval)
+	defer func() {
+		polls.Stop()
+		health.Stop()
+		liveness.Stop()
+	}()
+
+	poll()
+	outstanding = archivePullBatch
+
+	for {
+		select {
+		case <-liveness.C:
+			c.s.markActive()
+
+		case <-polls.C:
+			poll()
+			outstanding = archivePullBatch
+
+		case <-health.C:
+			fixed, err := c.healthCheckSource()
+			if err != nil {
+				c.log.Errorf("Source health check failed: %v", err)
+			}
+			if fixed {
+				c.log.Infof("Source consumer %s recreated", c.cname)
+				consumerRepairCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+				poll()
+				outstanding = archivePullBatch
+			}
+
+		case <-flushC:
+			flushC = nil
+			err = c.flush(ctx)
+			if err != nil {
+				c.log.Warnf("Copier shutting down after context interrupt")
+				return nil
+			}
+			poll()
+			outstanding = archivePullBatch
+
+		case msg := <-c.msgs:
+			if len(msg.Data) == 0 && msg.Header != nil && msg.Header.Get("Status") != _EMPTY_ {
+				// an expired request waited for pollFrequency, one cut short by max_bytes after receiving messages
+				// continues right away, others are retried on the next poll
+				if msg.Header.Get("Status") == "408" || (fetchMaxBytesExceeded(msg) && outstanding < archivePullBatch) {
+					poll()
+					outstanding = archivePullBatch
+				}
+				continue
+			}
+
+			polls.Reset(pollFrequency)
+
+			outstanding--
+			if outstanding <= 0 {
+				poll()
+				outstanding = archivePullBatch
+			}
+
+			err = c.handler(msg)
+			if err != nil {
+				handlerErrorCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+				c.s.reportError(err)
+				c.log.Errorf("Handling message failed: %v", err)
+				continue
+			}
+
+			if len(c.records) == 1 && flushC == nil {
+				flushC = time.After(c.acfg.FlushInterval)
+			}
+
+			if len(c.records) >= c.acfg.MaxMessages || c.size >= c.acfg.MaxBytes {
+				flushC = nil
+				err = c.flush(ctx)
+				if err != nil {
+					c.log.Warnf("Copier shutting down after context interrupt")
+					return nil
+				}
+				poll()
+				outstanding = archivePullBatch
+			}
+
+		case <-ctx.Done():
+			c.log.Warnf("Copier shutting down after context interrupt")
+
+			// messages not stored now are copied again after restart, storing them now avoids small duplicate objects
+			if len(c.records) > 0 {
+				timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				err = c.storeBatch(timeout)
+				cancel()
+				if err != nil {
+					c.log.Warnf("Could not store %d message(s) while shutting down: %v", len(c.records), err)
+				}
+			}
+
+			return nil
+		}
+	}
+}
+
+// handler adds msg to the current batch, redelivered messages already in the batch are ignored
+func (c *archiveCopier) handler(msg *nats.Msg) error {
+	receivedMessageCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+	receivedMessageSize.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Add(float64(len(msg.Data)))
+	obs := prometheus.NewTimer(processTime.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name))
+	defer obs.ObserveDuration()
+
+	meta, err := jsm.ParseJSMsgMetadata(msg)
+	if err != nil {
+		metaParsingFailedCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+		return fmt.Errorf("message metadata parse failed: %v", err)
+	}
+
+	if meta.StreamSequence() <= c.lastSeq {
+		return nil
+	}
+
+	c.lastSeq = meta.StreamSequence()
+	c.last = msg
+
+	streamSequence.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Set(float64(meta.StreamSequence()))
+	pendingMessages.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Set(float64(meta.Pending()))
+
+	if c.cfg.MaxAgeDuration > 0 && time.Since(meta.TimeStamp()) > c.cfg.MaxAgeDuration {
+		ageSkippedCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+		return nil
+	}
+
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+	msg.Header.Add(srcHeader, srcHeaderValue(c.cfg.Stream, int64(meta.StreamSequence()), c.sr.ReplicatorName, c.cfg.Name, meta.TimeStamp().UnixMilli()))
+
+	if !c.s.verifyMessage(msg) || !c.s.transformMessage(msg) {
+		return nil
+	}
+
+	err = c.s.prepareMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	c.records = append(c.records, &archive.Record{
+		Subject:  msg.Subject,
+		Sequence: meta.StreamSequence(),
+		Time:     meta.TimeStamp().UTC(),
+		Header:   msg.Header,
+		Data:     msg.Data,
+	})
+	c.size += int64(len(msg.Data))
+
+	return nil
+}
+
+// flush stores the current batch, retrying until it succeeds, and acknowledges all messages received so far
+func (c *archiveCopier) flush(ctx context.Context) error {
+	if len(c.records) > 0 {
+		err := c.s.retries.For(ctx, func(try int) error {
+			err := c.storeBatch(ctx)
+			if err != nil {
+				handlerErrorCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+				c.s.reportError(err)
+				c.log.Errorf("Storing %d message(s) failed on try %d: %v", len(c.records), try, err)
+			}
+
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.last != nil {
+		err := c.last.Ack()
+		if err != nil {
+			ackFailedCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+			c.log.Errorf("ACK failed: %v", err)
+		}
+		c.last = nil
+	}
+
+	return nil
+}
+
+// storeBatch stores the current batch as an object and then records it in the manifest
+func (c *archiveCopier) storeBatch(ctx context.Context) error {
+	first := c.records[0]
+	last := c.records[len(c.records)-1]
+
+	data, err := archive.Encode(c.records)
+	if err != nil {
+		return err
+	}
+
+	obj := archive.Object{
+		Key:       archive.ObjectKey(c.acfg.Prefix, c.cfg.Stream, first.Sequence, last.Sequence),
+		FirstSeq:  first.Sequence,
+		LastSeq:   last.Sequence,
+		Messages:  len(c.records),
+		Size:      int64(len(data)),
+		FirstTime: first.Time,
+		LastTime:  last.Time,
+	}
+
+	err = c.store.Put(ctx, obj.Key, data)
+	if err != nil {
+		return err
+	}
+
+	c.source.mu.Lock()
+	scfg := c.source.cfg
+	c.source.mu.Unlock()
+
+	manifest := *c.manifest
+	manifest.Config = scfg
+	manifest.LastSeq = obj.LastSeq
+	manifest.Messages += uint64(obj.Messages)
+	manifest.Objects = append(append([]archive.Object{}, c.manifest.Objects...), obj)
+
+	err = archive.SaveManifest(ctx, c.store, c.acfg.Prefix, &manifest)
+	if err != nil {
+		return err
+	}
+
+	c.manifest = &manifest
+
+	c.log.Infof("Archived %d message(s) %d-%d to %s", obj.Messages, obj.FirstSeq, obj.LastSeq, obj.Key)
+
+	copiedMessageCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Add(float64(obj.Messages))
+	copiedMessageSize.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Add(float64(c.size))
+	archivedObjectCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+	archivedObjectSize.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Add(float64(obj.Size))
+
+	if len(c.s.hooks.copied) > 0 {
+		for _, rec := range c.records {
+			c.s.messageCopied(&nats.Msg{Subject: rec.Subject, Header: rec.Header, Data: rec.Data})
+		}
+	}
+
+	c.records = nil
+	c.size = 0
+
+	return nil
+}
+
+// healthCheckSource recreates the consumer when it was removed, continuing after the last received message
+func (c *archiveCopier) healthCheckSource() (fixed bool, err error) {
+	c.source.mu.Lock()
+	stream := c.source.stream
+	c.source.mu.Unlock()
+
+	_, err = stream.LoadConsumer(c.cname)
+	if !jsm.IsNatsError(err, 10014) {
+		return false, err
+	}
+
+	c.log.Errorf("Consumer %s was not found, attempting to recreate", c.cname)
+
+	err = c.recreateConsumer()
+
+	return err == nil, err
+}
+
+// recreateConsumer replaces the source consumer with one starting after the last archived or received message, the
+// archive rather than the consumer tracks progress
+func (c *archiveCopier) recreateConsumer() error {
+	c.source.mu.Lock()
+	defer c.source.mu.Unlock()
+
+	opts := []jsm.ConsumerOption{
+		jsm.DurableName(c.cname),
+		jsm.ConsumerDescription(fmt.Sprintf("%s %s", ConsumerDescriptionPrefix, c.cfg.Name)),
+		jsm.AcknowledgeAll(),
+		jsm.MaxAckPending(uint(c.acfg.MaxMessages)),
+		jsm.AckWait(c.acfg.FlushInterval + time.Minute),
+	}
+
+	if c.cfg.FilterSubject != _EMPTY_ {
+		opts = append(opts, jsm.FilterStreamBySubject(c.cfg.FilterSubject))
+	}
+
+	if c.lastSeq > 0 {
+		opts = append(opts, jsm.StartAtSequence(c.lastSeq+1))
+	} else {
+		switch {
+		case c.cfg.StartAtEnd:
+			opts = append(opts, jsm.StartWithNextReceived())
+		case c.cfg.StartSequence > 0:
+			opts = append(opts, jsm.StartAtSequence(c.cfg.StartSequence))
+		case c.cfg.StartDelta > 0:
+			opts = append(opts, jsm.StartAtTime(time.Now().UTC().Add(-1*c.cfg.StartDelta)))
+		case !c.cfg.StartTime.IsZero():
+			opts = append(opts, jsm.StartAtTime(c.cfg.StartTime.UTC()))
+		default:
+			opts = append(opts, jsm.DeliverAllAvailable())
+		}
+	}
+
+	consumer, err := c.source.stream.LoadConsumer(c.cname)
+	if err == nil {
+		err = consumer.Delete()
+		if err != nil {
+			return err
+		}
+	} else if !jsm.IsNatsError(err, 10014) {
+		return err
+	}
+
+	c.source.consumer, err = c.source.stream.NewConsumerFromDefault(jsm.DefaultConsumer, opts...)
+
+	return err
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/archive"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/jsm.go/api"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Archive Copier", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+		dir    string
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+		dir = GinkgoT().TempDir()
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	run := func(ctx context.Context, nc *nats.Conn) {
+		scfg := &config.Stream{
+			Stream:        "ORDERS",
+			SourceURL:     nc.ConnectedUrl(),
+			TargetArchive: &config.Archive{Directory: dir, Prefix: "backups", MaxMessages: 10, FlushIntervalString: "1s"},
+		}
+		cfg := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+		Expect(cfg.Validate()).To(Succeed())
+
+		stream, err := NewStream(scfg, cfg, log)
+		Expect(err).ToNot(HaveOccurred())
+
+		wg.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+		}()
+	}
+
+	publish := func(nc *nats.Conn, from int, to int) {
+		for i := from; i <= to; i++ {
+			msg := nats.NewMsg(fmt.Sprintf("ORDERS.%d", i))
+			msg.Data = []byte(fmt.Sprintf("order %d", i))
+			msg.Header.Add(api.JSMsgId, fmt.Sprintf("%d", i))
+			_, err := nc.RequestMsg(msg, time.Second)
+			Expect(err).ToNot(HaveOccurred())
+		}
+	}
+
+	manifest := func(store archive.Store) func() (uint64, error) {
+		return func() (uint64, error) {
+			m, err := archive.LoadManifest(ctx, store, "backups", "ORDERS")
+			if err != nil {
+				return 0, err
+			}
+			return m.LastSeq, nil
+		}
+	}
+
+	It("Should archive messages in batches", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("ORDERS", jsm.Subjects("ORDERS.>"))
+			Expect(err).ToNot(HaveOccurred())
+			publish(nc, 1, 25)
+
+			store, err := archive.NewStore(&config.Archive{Directory: dir}, "")
+			Expect(err).ToNot(HaveOccurred())
+
+			run(ctx, nc)
+			Eventually(manifest(store), "10s").Should(Equal(uint64(25)))
+
+			m, err := archive.LoadManifest(ctx, store, "backups", "ORDERS")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m.Messages).To(Equal(uint64(25)))
+			Expect(m.Config.Subjects).To(Equal([]string{"ORDERS.>"}))
+			Expect(m.Objects).To(HaveLen(3))
+
+			var ranges []string
+			for _, obj := range m.Objects {
+				ranges = append(ranges, fmt.Sprintf("%d-%d", obj.FirstSeq, obj.LastSeq))
+			}
+			Expect(ranges).To(Equal([]string{"1-10", "11-20", "21-25"}))
+
+			data, err := store.Get(ctx, m.Objects[0].Key)
+			Expect(err).ToNot(HaveOccurred())
+			records, err := archive.Decode(data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(records).To(HaveLen(10))
+			Expect(records[0].Subject).To(Equal("ORDERS.1"))
+			Expect(records[0].Sequence).To(Equal(uint64(1)))
+			Expect(string(records[0].Data)).To(Equal("order 1"))
+			Expect(records[0].Header[api.JSMsgId]).To(Equal([]string{"1"}))
+			Expect(records[0].Header[srcHeader][0]).To(HavePrefix("ORDERS 1 GINKGO"))
+		})
+	})
+
+	It("Should resume after the last archived message", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("ORDERS", jsm.Subjects("ORDERS.>"))
+			Expect(err).ToNot(HaveOccurred())
+			publish(nc, 1, 10)
+
+			store, err := archive.NewStore(&config.Archive{Directory: dir}, "")
+			Expect(err).ToNot(HaveOccurred())
+
+			first, stop := context.WithCancel(ctx)
+			run(first, nc)
+			Eventually(manifest(store), "10s").Should(Equal(uint64(10)))
+			stop()
+			wg.Wait()
+
+			publish(nc, 11, 15)
+
+			run(ctx, nc)
+			Eventually(manifest(store), "10s").Should(Equal(uint64(15)))
+
+			m, err := archive.LoadManifest(ctx, store, "backups", "ORDERS")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m.Messages).To(Equal(uint64(15)))
+			Expect(m.Objects).To(HaveLen(2))
+			Expect(m.Objects[1].FirstSeq).To(Equal(uint64(11)))
+		})
+	})
+})
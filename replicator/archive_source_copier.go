@@ -0,0 +1,331 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/archive"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/jsm.go/api"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// ArchiveSequenceHeader holds the sequence a message restored from an archive had in the archived stream
+const ArchiveSequenceHeader = "Choria-SR-Archive-Sequence"
+
+// archiveSourceCopier restores messages from an archive into the target stream in sequence order, the last
+// restored message in the target records where restoring continues after a restart
+type archiveSourceCopier struct {
+	s       *Stream
+	sr      *config.Config
+	cfg     *config.Stream
+	acfg    *config.Archive
+	store   archive.Store
+	dest    *Target
+	copied  int64
+	skipped int64
+	log     *logrus.Entry
+}
+
+func newArchiveSourceCopier(s *Stream, log *logrus.Entry) *archiveSourceCopier {
+	return &archiveSourceCopier{
+		s:     s,
+		sr:    s.sr,
+		cfg:   s.cfg,
+		acfg:  s.cfg.SourceArchive,
+		store: s.archive,
+		dest:  s.dest,
+		log:   log.WithField("copier", "archive_source"),
+	}
+}
+
+func (s *Stream) connectArchiveSource(ctx context.Context) (err error) {
+	s.archive, err = archive.NewStore(s.cfg.SourceArchive, s.cfg.SourceProxy)
+	if err != nil {
+		return fmt.Errorf("archive setup failed: %v", err)
+	}
+
+	manifest, err := loadArchiveManifest(ctx, s.archive, s.cfg.SourceArchive.Prefix, s.cfg.Stream, s.retries, s.log.WithField("connection", "archive"))
+	if err != nil {
+		return err
+	}
+	if manifest == nil {
+		return fmt.Errorf("no archive found for stream %s", s.cfg.Stream)
+	}
+
+	// the target is created like the archived stream, it can not receive messages while mirroring or sourcing
+	scfg := manifest.Config
+	scfg.Mirror = nil
+	scfg.Sources = nil
+	scfg.Subjects = nil
+	for _, subject := range manifest.Config.Subjects {
+		scfg.Subjects = append(scfg.Subjects, s.TargetForSubject(subject))
+	}
+
+	err = s.connectTarget(ctx, scfg)
+	if err != nil {
+		return err
+	}
+
+	if s.dest == nil {
+		return fmt.Errorf("connection setup failed")
+	}
+
+	return nil
+}
+
+func (c *archiveSourceCopier) copyMessages(ctx context.Context) error {
+	next, err := c.startSequence(ctx)
+	if err != nil {
+		return err
+	}
+
+	var startTime time.Time
+	switch {
+	case c.cfg.StartDelta > 0:
+		startTime = time.Now().Add(-1 * c.cfg.StartDelta)
+	case !c.cfg.StartTime.IsZero():
+		startTime = c.cfg.StartTime
+	}
+
+	c.log.Infof("Starting archive restore data copier for %s from sequence %d", c.cfg.Stream, next)
+
+	liveness := time.NewTicker(livenessInterval)
+	defer liveness.Stop()
+
+	for {
+		manifest, err := loadArchiveManifest(ctx, c.store, c.acfg.Prefix, c.cfg.Stream, c.s.retries, c.log)
+		if err != nil {
+			c.log.Warnf("Copier shutting down after context interrupt")
+			return nil
+		}
+		if manifest == nil {
+			manifest = &archive.Manifest{}
+		}
+
+		for _, obj := range manifest.Objects {
+			if obj.LastSeq < next || (!startTime.IsZero() && obj.LastTime.Before(startTime)) {
+				continue
+			}
+
+			records, err := c.loadObject(ctx, obj)
+			if err != nil {
+				return err
+			}
+			if records == nil {
+				c.log.Warnf("Copier shutting down after context interrupt")
+				return nil
+			}
+
+			for _, rec := range records {
+				if rec.Sequence < next {
+					continue
+				}
+
+				select {
+				case <-liveness.C:
+					c.s.markActive()
+				default:
+				}
+
+				if !startTime.IsZero() && rec.Time.Before(startTime) {
+					atomic.AddInt64(&c.skipped, 1)
+					next = rec.Sequence + 1
+					continue
+				}
+
+				// records are restored in order so a failing message is retried until it succeeds
+				err = c.s.retries.For(ctx, func(try int) error {
+					err := c.handler(ctx, rec)
+					if err != nil {
+						handlerErrorCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+						c.s.reportError(err)
+						c.log.Errorf("Restoring message %d failed on try %d: %v", rec.Sequence, try, err)
+					}
+
+					return err
+				})
+				if err != nil {
+					c.log.Warnf("Copier shutting down after context interrupt")
+					return nil
+				}
+
+				next = rec.Sequence + 1
+			}
+		}
+
+		// new objects are restored as they are archived until the replicator is stopped
+		wait := time.NewTimer(c.s.hcInterval)
+		for waiting := true; waiting; {
+			select {
+			case <-liveness.C:
+				c.s.markActive()
+			case <-wait.C:
+				waiting = false
+			case <-ctx.Done():
+				wait.Stop()
+				c.log.Warnf("Copier shutting down after context interrupt")
+				return nil
+			}
+		}
+	}
+}
+
+// loadObject retrieves and decodes obj retrying until it succeeds, nil records when ctx is canceled
+func (c *archiveSourceCopier) loadObject(ctx context.Context, obj archive.Object) ([]*archive.Record, error) {
+	var data []byte
+
+	err := c.s.retries.For(ctx, func(try int) error {
+		var err error
+
+		data, err = c.store.Get(ctx, obj.Key)
+		if err != nil {
+			c.log.Errorf("Loading object %s failed on try %d: %v", obj.Key, try, err)
+		}
+
+		return err
+	})
+	if err != nil {
+		return nil, nil
+	}
+
+	records, err := archive.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive object %s: %v", obj.Key, err)
+	}
+
+	c.log.Infof("Restoring %d message(s) %d-%d from %s", len(records), obj.FirstSeq, obj.LastSeq, obj.Key)
+
+	return records, nil
+}
+
+// startSequence is the first archived sequence to restore, continuing after the last message the target holds
+func (c *archiveSourceCopier) startSequence(ctx context.Context) (uint64, error) {
+	var stream *jsm.Stream
+
+	err := c.s.retries.For(ctx, func(try int) error {
+		var err error
+
+		stream, err = c.dest.mgr.LoadStream(c.cfg.TargetStream)
+		if err != nil {
+			c.log.Infof("Loading stream failed on try %d: %v", try, err)
+		}
+
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	state, err := stream.State()
+	if err != nil {
+		return 0, err
+	}
+
+	if state.Msgs > 0 {
+		msg, err := stream.ReadMessage(state.LastSeq)
+		if err != nil {
+			return 0, fmt.Errorf("could not read the last message in %s: %v", c.cfg.TargetStream, err)
+		}
+
+		hdrs := nats.Header{}
+		if len(msg.Header) > 0 {
+			hdrs, err = decodeHeadersMsg(msg.Header)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		if seq := hdrs.Get(ArchiveSequenceHeader); seq != _EMPTY_ {
+			last, err := strconv.ParseUint(seq, 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("last message in %s has an invalid %s header: %v", c.cfg.TargetStream, ArchiveSequenceHeader, err)
+			}
+
+			return last + 1, nil
+		}
+
+		if c.cfg.StartSequence == 0 {
+			return 0, fmt.Errorf("target stream %s holds messages not restored from the archive, set start_sequence to restore into it", c.cfg.TargetStream)
+		}
+	}
+
+	if c.cfg.StartAtEnd {
+		manifest, err := loadArchiveManifest(ctx, c.store, c.acfg.Prefix, c.cfg.Stream, c.s.retries, c.log)
+		if err != nil {
+			return 0, err
+		}
+		if manifest != nil {
+			return manifest.LastSeq + 1, nil
+		}
+	}
+
+	return c.cfg.StartSequence, nil
+}
+
+func (c *archiveSourceCopier) handler(ctx context.Context, rec *archive.Record) error {
+	receivedMessageCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+	receivedMessageSize.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Add(float64(len(rec.Data)))
+	obs := prometheus.NewTimer(processTime.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name))
+	defer obs.ObserveDuration()
+
+	streamSequence.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Set(float64(rec.Sequence))
+
+	if c.cfg.MaxAgeDuration > 0 && time.Since(rec.Time) > c.cfg.MaxAgeDuration {
+		ageSkippedCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+		return nil
+	}
+
+	msg := nats.NewMsg(c.s.TargetForSubject(rec.Subject))
+	msg.Data = rec.Data
+	for k, vals := range rec.Header {
+		for _, v := range vals {
+			msg.Header.Add(k, v)
+		}
+	}
+
+	// messages restored again after a failure are discarded by the duplicate window of the target
+	if msg.Header.Get(api.JSMsgId) == _EMPTY_ {
+		msg.Header.Set(api.JSMsgId, fmt.Sprintf("%s:%d", c.cfg.Stream, rec.Sequence))
+	}
+	msg.Header.Set(ArchiveSequenceHeader, strconv.FormatUint(rec.Sequence, 10))
+	msg.Header.Add(srcHeader, srcHeaderValue(c.cfg.Stream, int64(rec.Sequence), c.sr.ReplicatorName, c.cfg.Name, rec.Time.UnixMilli()))
+
+	c.s.mapJetStreamHeaders(msg)
+	if !c.s.verifyMessage(msg) || !c.s.transformMessage(msg) {
+		atomic.AddInt64(&c.skipped, 1)
+		return nil
+	}
+
+	err := c.s.prepareMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	err = c.s.sink.publish(ctx, msg)
+	if err != nil {
+		return err
+	}
+
+	copied := atomic.AddInt64(&c.copied, 1)
+	if copied%1000 == 0 {
+		c.log.Infof("Restored %d message(s), skipped %d", copied, atomic.LoadInt64(&c.skipped))
+	}
+
+	copiedMessageCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+	copiedMessageSize.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Add(float64(len(msg.Data)))
+	c.s.subjects.record(msg.Subject, len(msg.Data))
+	c.s.messageCopied(msg)
+
+	return nil
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/archive"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/jsm.go/api"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Archive Source Copier", func() {
+	var (
+		ctx      context.Context
+		cancel   context.CancelFunc
+		wg       = sync.WaitGroup{}
+		log      *logrus.Entry
+		dir      string
+		store    archive.Store
+		manifest *archive.Manifest
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		var err error
+		dir = GinkgoT().TempDir()
+		store, err = archive.NewStore(&config.Archive{Directory: dir}, "")
+		Expect(err).ToNot(HaveOccurred())
+		manifest = &archive.Manifest{Stream: "ORDERS", Config: api.StreamConfig{Name: "ORDERS", Subjects: []string{"ORDERS.>"}, MaxMsgs: 1000, Storage: api.FileStorage, Replicas: 1}}
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	// archiveObject stores records first to last like the archive copier would
+	archiveObject := func(first uint64, last uint64) {
+		var records []*archive.Record
+		for seq := first; seq <= last; seq++ {
+			rec := &archive.Record{Subject: fmt.Sprintf("ORDERS.%d", seq), Sequence: seq, Time: time.Now().UTC(), Data: []byte(fmt.Sprintf("order %d", seq))}
+			if seq%2 == 0 {
+				rec.Header = map[string][]string{api.JSMsgId: {fmt.Sprintf("order-%d", seq)}}
+			}
+			records = append(records, rec)
+		}
+
+		data, err := archive.Encode(records)
+		Expect(err).ToNot(HaveOccurred())
+
+		obj := archive.Object{Key: archive.ObjectKey("", "ORDERS", first, last), FirstSeq: first, LastSeq: last, Messages: len(records), FirstTime: records[0].Time, LastTime: records[len(records)-1].Time}
+		Expect(store.Put(ctx, obj.Key, data)).To(Succeed())
+
+		manifest.Objects = append(manifest.Objects, obj)
+		manifest.LastSeq = last
+		manifest.Messages += uint64(len(records))
+		Expect(archive.SaveManifest(ctx, store, "", manifest)).To(Succeed())
+	}
+
+	run := func(ctx context.Context, nc *nats.Conn) {
+		scfg := &config.Stream{
+			Stream:        "ORDERS",
+			TargetURL:     nc.ConnectedUrl(),
+			SourceArchive: &config.Archive{Directory: dir},
+		}
+		cfg := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+		Expect(cfg.Validate()).To(Succeed())
+
+		stream, err := NewStream(scfg, cfg, log)
+		Expect(err).ToNot(HaveOccurred())
+		stream.hcInterval = 200 * time.Millisecond
+
+		wg.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+		}()
+	}
+
+	streamMessages := func(mgr *jsm.Manager) func() (uint64, error) {
+		return func() (uint64, error) {
+			stream, err := mgr.LoadStream("ORDERS")
+			if err != nil {
+				return 0, err
+			}
+			nfo, err := stream.State()
+			if err != nil {
+				return 0, err
+			}
+			return nfo.Msgs, nil
+		}
+	}
+
+	It("Should restore archived messages and new objects", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			archiveObject(1, 10)
+
+			run(ctx, nc)
+			Eventually(streamMessages(mgr), "10s").Should(BeNumerically("==", 10))
+
+			stream, err := mgr.LoadStream("ORDERS")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stream.Subjects()).To(Equal([]string{"ORDERS.>"}))
+			Expect(stream.MaxMsgs()).To(Equal(int64(1000)))
+
+			msg, err := stream.ReadMessage(1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(msg.Subject).To(Equal("ORDERS.1"))
+			Expect(string(msg.Data)).To(Equal("order 1"))
+			hdrs, err := decodeHeadersMsg(msg.Header)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hdrs.Get(api.JSMsgId)).To(Equal("ORDERS:1"))
+			Expect(hdrs.Get(ArchiveSequenceHeader)).To(Equal("1"))
+
+			msg, err = stream.ReadMessage(2)
+			Expect(err).ToNot(HaveOccurred())
+			hdrs, err = decodeHeadersMsg(msg.Header)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hdrs.Get(api.JSMsgId)).To(Equal("order-2"))
+
+			archiveObject(11, 15)
+			Eventually(streamMessages(mgr), "10s").Should(BeNumerically("==", 15))
+		})
+	})
+
+	It("Should resume after the last restored message", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			archiveObject(1, 10)
+
+			first, stop := context.WithCancel(ctx)
+			run(first, nc)
+			Eventually(streamMessages(mgr), "10s").Should(BeNumerically("==", 10))
+			stop()
+			wg.Wait()
+
+			// without a duplicate window messages restored again would be stored again
+			stream, err := mgr.LoadStream("ORDERS")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stream.UpdateConfiguration(stream.Configuration(), jsm.DuplicateWindow(time.Millisecond*100))).To(Succeed())
+			time.Sleep(200 * time.Millisecond)
+
+			archiveObject(11, 15)
+
+			run(ctx, nc)
+			Eventually(streamMessages(mgr), "10s").Should(BeNumerically("==", 15))
+			Consistently(streamMessages(mgr), "1s").Should(BeNumerically("==", 15))
+
+			msg, err := stream.ReadMessage(11)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(msg.Subject).To(Equal("ORDERS.11"))
+		})
+	})
+
+	It("Should not restore into streams holding other messages", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			archiveObject(1, 10)
+
+			_, err := mgr.NewStream("ORDERS", jsm.Subjects("ORDERS.>"))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = nc.Request("ORDERS.live", []byte("live"), time.Second)
+			Expect(err).ToNot(HaveOccurred())
+
+			scfg := &config.Stream{Stream: "ORDERS", TargetURL: nc.ConnectedUrl(), SourceArchive: &config.Archive{Directory: dir}}
+			cfg := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+			Expect(cfg.Validate()).To(Succeed())
+
+			stream, err := NewStream(scfg, cfg, log)
+			Expect(err).ToNot(HaveOccurred())
+
+			wg.Add(1)
+			Expect(stream.Run(ctx, &wg)).To(MatchError("target stream ORDERS holds messages not restored from the archive, set start_sequence to restore into it"))
+		})
+	})
+})
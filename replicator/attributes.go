@@ -0,0 +1,63 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"encoding/base64"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// SubjectHeader holds the subject of a message delivered to targets that do not have subjects
+	SubjectHeader = "Choria-SR-Subject"
+	// SequenceHeader holds the stream sequence of a message delivered to targets that do not have subjects
+	SequenceHeader = "Choria-SR-Sequence"
+	// EncodingHeader is set to base64 when the data of a message was encoded for targets only accepting text
+	EncodingHeader = "Choria-SR-Encoding"
+)
+
+// messageAttributes maps the headers of msg to attributes, only headers in mapping are kept under their mapped
+// names unless mapping is empty, the subject and sequence are always added
+func messageAttributes(msg *nats.Msg, mapping map[string]string) map[string]string {
+	attrs := make(map[string]string)
+
+	for k, vals := range msg.Header {
+		name := k
+		if len(mapping) > 0 {
+			name = mapping[k]
+		}
+
+		if name == _EMPTY_ || len(vals) == 0 || vals[0] == _EMPTY_ {
+			continue
+		}
+
+		attrs[name] = vals[0]
+	}
+
+	attrs[SubjectHeader] = msg.Subject
+
+	meta, err := jsm.ParseJSMsgMetadata(msg)
+	if err == nil {
+		attrs[SequenceHeader] = strconv.FormatUint(meta.StreamSequence(), 10)
+	}
+
+	return attrs
+}
+
+// textBody is the data of msg for targets only accepting text, data that is not valid UTF-8 is base64 encoded
+// and EncodingHeader is set in attrs
+func textBody(msg *nats.Msg, attrs map[string]string) string {
+	if utf8.Valid(msg.Data) {
+		return string(msg.Data)
+	}
+
+	attrs[EncodingHeader] = "base64"
+
+	return base64.StdEncoding.EncodeToString(msg.Data)
+}
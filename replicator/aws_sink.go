@@ -0,0 +1,209 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/util"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/jsm.go/api"
+	"github.com/nats-io/nats.go"
+)
+
+// snsSink publishes messages to an AWS SNS topic
+type snsSink struct {
+	cfg    *config.SNS
+	client *sns.Client
+	fifo   bool
+	stream string
+}
+
+// sqsSink sends messages to an AWS SQS queue
+type sqsSink struct {
+	cfg    *config.SQS
+	client *sqs.Client
+	fifo   bool
+	stream string
+}
+
+// awsConfig loads the AWS configuration for cfg using static credentials when set, through proxy when not empty
+func awsConfig(ctx context.Context, cfg *config.AWS, proxy string) (aws.Config, error) {
+	var opts []func(*awsconfig.LoadOptions) error
+
+	if cfg.Region != _EMPTY_ {
+		opts = append(opts, awsconfig.WithRegion(cfg.Region))
+	}
+
+	if cfg.AccessKey != _EMPTY_ {
+		opts = append(opts, awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(cfg.AccessKey, cfg.SecretKey, _EMPTY_)))
+	}
+
+	if proxy != _EMPTY_ {
+		dial, err := util.ProxyDialer(proxy, 30*time.Second)
+		if err != nil {
+			return aws.Config{}, err
+		}
+
+		opts = append(opts, awsconfig.WithHTTPClient(awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+			tr.Proxy = nil
+			tr.DialContext = dial
+		})))
+	}
+
+	return awsconfig.LoadDefaultConfig(ctx, opts...)
+}
+
+func (s *Stream) connectSNS(ctx context.Context) error {
+	scfg := s.cfg.TargetSNS
+
+	acfg, err := awsConfig(ctx, &scfg.AWS, s.cfg.TargetProxy)
+	if err != nil {
+		return fmt.Errorf("sns setup failed: %v", err)
+	}
+
+	client := sns.NewFromConfig(acfg, func(o *sns.Options) {
+		if scfg.Endpoint != _EMPTY_ {
+			o.BaseEndpoint = aws.String(scfg.Endpoint)
+		}
+	})
+
+	s.log.WithField("connection", "sns").Infof("Publishing messages to topic %s", scfg.TopicARN)
+
+	s.batchSink = &snsSink{cfg: scfg, client: client, fifo: strings.HasSuffix(scfg.TopicARN, ".fifo"), stream: s.cfg.Stream}
+	s.delivery = &scfg.Delivery
+
+	return nil
+}
+
+func (s *Stream) connectSQS(ctx context.Context) error {
+	qcfg := s.cfg.TargetSQS
+
+	acfg, err := awsConfig(ctx, &qcfg.AWS, s.cfg.TargetProxy)
+	if err != nil {
+		return fmt.Errorf("sqs setup failed: %v", err)
+	}
+
+	client := sqs.NewFromConfig(acfg, func(o *sqs.Options) {
+		if qcfg.Endpoint != _EMPTY_ {
+			o.BaseEndpoint = aws.String(qcfg.Endpoint)
+		}
+	})
+
+	s.log.WithField("connection", "sqs").Infof("Sending messages to queue %s", qcfg.QueueURL)
+
+	s.batchSink = &sqsSink{cfg: qcfg, client: client, fifo: strings.HasSuffix(qcfg.QueueURL, ".fifo"), stream: s.cfg.Stream}
+	s.delivery = &qcfg.Delivery
+
+	return nil
+}
+
+// fifoIDs are the message group and deduplication ids used for FIFO topics and queues, messages are grouped by
+// subject and deduplicated using their message id
+func fifoIDs(msg *nats.Msg, stream string) (*string, *string) {
+	id := msg.Header.Get(api.JSMsgId)
+	if id == _EMPTY_ {
+		meta, err := jsm.ParseJSMsgMetadata(msg)
+		if err == nil {
+			id = stream + ":" + strconv.FormatUint(meta.StreamSequence(), 10)
+		}
+	}
+
+	if id == _EMPTY_ {
+		return aws.String(msg.Subject), nil
+	}
+
+	return aws.String(msg.Subject), aws.String(id)
+}
+
+func (k *snsSink) deliver(ctx context.Context, msgs []*nats.Msg) error {
+	input := &sns.PublishBatchInput{TopicArn: aws.String(k.cfg.TopicARN)}
+
+	for i, msg := range msgs {
+		attrs := messageAttributes(msg, k.cfg.Attributes)
+		entry := snstypes.PublishBatchRequestEntry{
+			Id:                aws.String(strconv.Itoa(i)),
+			Message:           aws.String(textBody(msg, attrs)),
+			MessageAttributes: make(map[string]snstypes.MessageAttributeValue),
+		}
+
+		for k, v := range attrs {
+			entry.MessageAttributes[k] = snstypes.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(v)}
+		}
+
+		if k.fifo {
+			entry.MessageGroupId, entry.MessageDeduplicationId = fifoIDs(msg, k.stream)
+		}
+
+		input.PublishBatchRequestEntries = append(input.PublishBatchRequestEntries, entry)
+	}
+
+	out, err := k.client.PublishBatch(ctx, input)
+	if err != nil {
+		return err
+	}
+
+	if len(out.Failed) > 0 {
+		return fmt.Errorf("%d of %d message(s) failed: %s", len(out.Failed), len(msgs), aws.ToString(out.Failed[0].Message))
+	}
+
+	return nil
+}
+
+func (k *snsSink) close() error {
+	return nil
+}
+
+func (k *sqsSink) deliver(ctx context.Context, msgs []*nats.Msg) error {
+	input := &sqs.SendMessageBatchInput{QueueUrl: aws.String(k.cfg.QueueURL)}
+
+	for i, msg := range msgs {
+		attrs := messageAttributes(msg, k.cfg.Attributes)
+		entry := sqstypes.SendMessageBatchRequestEntry{
+			Id:                aws.String(strconv.Itoa(i)),
+			MessageBody:       aws.String(textBody(msg, attrs)),
+			MessageAttributes: make(map[string]sqstypes.MessageAttributeValue),
+		}
+
+		for k, v := range attrs {
+			entry.MessageAttributes[k] = sqstypes.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(v)}
+		}
+
+		if k.fifo {
+			entry.MessageGroupId, entry.MessageDeduplicationId = fifoIDs(msg, k.stream)
+		}
+
+		input.Entries = append(input.Entries, entry)
+	}
+
+	out, err := k.client.SendMessageBatch(ctx, input)
+	if err != nil {
+		return err
+	}
+
+	if len(out.Failed) > 0 {
+		return fmt.Errorf("%d of %d message(s) failed: %s", len(out.Failed), len(msgs), aws.ToString(out.Failed[0].Message))
+	}
+
+	return nil
+}
+
+func (k *sqsSink) close() error {
+	return nil
+}
@@ -0,0 +1,217 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/jsm.go/api"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+type sqsTestAttribute struct {
+	DataType    string
+	StringValue string
+}
+
+type sqsTestEntry struct {
+	Id                     string
+	MessageBody            string
+	MessageGroupId         string
+	MessageDeduplicationId string
+	MessageAttributes      map[string]sqsTestAttribute
+}
+
+var _ = Describe("AWS Sinks", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+		mu     sync.Mutex
+		srv    *httptest.Server
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+			srv.Close()
+		})
+	})
+
+	aws := func() config.AWS {
+		return config.AWS{Region: "us-east-1", AccessKey: "ginkgo", SecretKey: "secret", Endpoint: srv.URL}
+	}
+
+	run := func(nc *nats.Conn, mgr *jsm.Manager, scfg *config.Stream, data func(i int) []byte) {
+		_, err := mgr.NewStream("ORDERS", jsm.Subjects("ORDERS.>"))
+		Expect(err).ToNot(HaveOccurred())
+
+		for i := 1; i <= 5; i++ {
+			msg := nats.NewMsg(fmt.Sprintf("ORDERS.%d", i))
+			msg.Data = data(i)
+			msg.Header.Add(api.JSMsgId, fmt.Sprintf("%d", i))
+			msg.Header.Add("Customer", "bob")
+			_, err := nc.RequestMsg(msg, time.Second)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		scfg.Stream = "ORDERS"
+		scfg.SourceURL = nc.ConnectedUrl()
+		cfg := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+		Expect(cfg.Validate()).To(Succeed())
+
+		stream, err := NewStream(scfg, cfg, log)
+		Expect(err).ToNot(HaveOccurred())
+
+		wg.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+		}()
+	}
+
+	It("Should send messages to FIFO SQS queues", func() {
+		var entries []sqsTestEntry
+
+		srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+
+			Expect(r.Header.Get("X-Amz-Target")).To(Equal("AmazonSQS.SendMessageBatch"))
+
+			var req struct {
+				QueueUrl string
+				Entries  []sqsTestEntry
+			}
+			Expect(json.NewDecoder(r.Body).Decode(&req)).To(Succeed())
+			Expect(req.QueueUrl).To(HaveSuffix("/orders.fifo"))
+
+			type result struct {
+				Id               string
+				MessageId        string
+				MD5OfMessageBody string
+			}
+			resp := struct {
+				Successful []result
+				Failed     []any
+			}{Failed: []any{}}
+
+			for _, e := range req.Entries {
+				sum := md5.Sum([]byte(e.MessageBody))
+				resp.Successful = append(resp.Successful, result{Id: e.Id, MessageId: e.MessageDeduplicationId, MD5OfMessageBody: hex.EncodeToString(sum[:])})
+			}
+
+			mu.Lock()
+			entries = append(entries, req.Entries...)
+			mu.Unlock()
+
+			w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+			Expect(json.NewEncoder(w).Encode(resp)).To(Succeed())
+		}))
+
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			run(nc, mgr, &config.Stream{TargetSQS: &config.SQS{
+				AWS:      aws(),
+				QueueURL: srv.URL + "/123456789012/orders.fifo",
+				Delivery: config.Delivery{Batch: 5, FlushIntervalString: "200ms"},
+			}}, func(i int) []byte { return []byte{0xff, byte(i)} })
+
+			Eventually(func() int {
+				mu.Lock()
+				defer mu.Unlock()
+				return len(entries)
+			}, "10s").Should(Equal(5))
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			e := entries[0]
+			Expect(e.MessageBody).To(Equal(base64.StdEncoding.EncodeToString([]byte{0xff, 1})))
+			Expect(e.MessageGroupId).To(Equal("ORDERS.1"))
+			Expect(e.MessageDeduplicationId).To(Equal("1"))
+			Expect(e.MessageAttributes).To(HaveKeyWithValue("Customer", sqsTestAttribute{DataType: "String", StringValue: "bob"}))
+			Expect(e.MessageAttributes).To(HaveKeyWithValue(SubjectHeader, sqsTestAttribute{DataType: "String", StringValue: "ORDERS.1"}))
+			Expect(e.MessageAttributes).To(HaveKeyWithValue(SequenceHeader, sqsTestAttribute{DataType: "String", StringValue: "1"}))
+			Expect(e.MessageAttributes).To(HaveKeyWithValue(EncodingHeader, sqsTestAttribute{DataType: "String", StringValue: "base64"}))
+		})
+	})
+
+	It("Should publish messages to SNS topics", func() {
+		var forms []url.Values
+
+		srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+
+			Expect(r.ParseForm()).To(Succeed())
+			Expect(r.PostForm.Get("Action")).To(Equal("PublishBatch"))
+			Expect(r.PostForm.Get("TopicArn")).To(Equal("arn:aws:sns:us-east-1:123456789012:orders"))
+
+			var members strings.Builder
+			for i := 1; r.PostForm.Has(fmt.Sprintf("PublishBatchRequestEntries.member.%d.Id", i)); i++ {
+				fmt.Fprintf(&members, "<member><Id>%s</Id><MessageId>%d</MessageId></member>", r.PostForm.Get(fmt.Sprintf("PublishBatchRequestEntries.member.%d.Id", i)), i)
+			}
+
+			mu.Lock()
+			forms = append(forms, r.PostForm)
+			mu.Unlock()
+
+			w.Header().Set("Content-Type", "text/xml")
+			fmt.Fprintf(w, `<PublishBatchResponse xmlns="http://sns.amazonaws.com/doc/2010-03-31/"><PublishBatchResult><Successful>%s</Successful><Failed/></PublishBatchResult><ResponseMetadata><RequestId>ginkgo</RequestId></ResponseMetadata></PublishBatchResponse>`, members.String())
+		}))
+
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			run(nc, mgr, &config.Stream{TargetSNS: &config.SNS{
+				AWS:        aws(),
+				TopicARN:   "arn:aws:sns:us-east-1:123456789012:orders",
+				Attributes: map[string]string{"Customer": "customer"},
+			}}, func(i int) []byte { return []byte(fmt.Sprintf("order %d", i)) })
+
+			Eventually(func() int {
+				mu.Lock()
+				defer mu.Unlock()
+				return len(forms)
+			}, "10s").Should(Equal(5))
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			attrs := map[string]string{}
+			form := forms[0]
+			for i := 1; form.Has(fmt.Sprintf("PublishBatchRequestEntries.member.1.MessageAttributes.entry.%d.Name", i)); i++ {
+				prefix := fmt.Sprintf("PublishBatchRequestEntries.member.1.MessageAttributes.entry.%d.", i)
+				Expect(form.Get(prefix + "Value.DataType")).To(Equal("String"))
+				attrs[form.Get(prefix+"Name")] = form.Get(prefix + "Value.StringValue")
+			}
+
+			Expect(form.Get("PublishBatchRequestEntries.member.1.Message")).To(Equal("order 1"))
+			Expect(form.Has("PublishBatchRequestEntries.member.1.MessageGroupId")).To(BeFalse())
+			Expect(attrs).To(Equal(map[string]string{"customer": "bob", SubjectHeader: "ORDERS.1", SequenceHeader: "1"}))
+		})
+	})
+})
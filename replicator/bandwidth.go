@@ -0,0 +1,124 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/clock"
+	"github.com/choria-io/stream-replicator/config"
+)
+
+// bandwidthLimiter limits the bandwidth a stream copies messages with to the limit its bandwidth schedule sets for
+// the time of day, up to a second of unused bandwidth can be used at once
+type bandwidthLimiter struct {
+	cfg *config.Bandwidth
+	// rate is the current limit in bytes per second, 0 when unlimited
+	rate float64
+	// until is when the limit has to be determined again, zero when it never changes
+	until  time.Time
+	budget float64
+	last   time.Time
+	clock  clock.Clock
+	mu     sync.Mutex
+}
+
+func newBandwidthLimiter(cfg *config.Bandwidth, clk clock.Clock) *bandwidthLimiter {
+	b := &bandwidthLimiter{cfg: cfg, clock: clk, last: clk.Now()}
+	b.updateLocked(b.last)
+	b.budget = b.rate
+
+	return b
+}
+
+// updateLocked determines the limit that applies at now, must be called with b.mu held
+func (b *bandwidthLimiter) updateLocked(now time.Time) {
+	limit, until := b.cfg.Limit(now)
+	b.rate = float64(limit)
+	b.until = until
+}
+
+// limit is the bandwidth currently copied within in bytes per second, 0 when unlimited
+func (b *bandwidthLimiter) limit() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock.Now()
+	if !b.until.IsZero() && !now.Before(b.until) {
+		b.updateLocked(now)
+	}
+
+	return int64(b.rate)
+}
+
+// reserve takes size bytes from the available bandwidth, returns how long to wait before trying again when not
+// enough is available
+func (b *bandwidthLimiter) reserve(size int64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock.Now()
+	if !b.until.IsZero() && !now.Before(b.until) {
+		b.updateLocked(now)
+	}
+
+	if b.rate == 0 {
+		b.budget = 0
+		b.last = now
+		return 0
+	}
+
+	b.budget += now.Sub(b.last).Seconds() * b.rate
+	if b.budget > b.rate {
+		b.budget = b.rate
+	}
+	b.last = now
+
+	// a message larger than the budget is copied once any budget is available and then delays later ones
+	if b.budget > 0 {
+		b.budget -= float64(size)
+		return 0
+	}
+
+	wait := time.Duration(-b.budget/b.rate*float64(time.Second)) + time.Millisecond
+	if !b.until.IsZero() && b.until.Sub(now) < wait {
+		wait = b.until.Sub(now)
+	}
+
+	return wait
+}
+
+// wait waits till size bytes of bandwidth are available, progress is called regularly while waiting
+func (b *bandwidthLimiter) wait(ctx context.Context, size int64, progress func()) error {
+	d := b.reserve(size)
+	if d <= 0 {
+		return nil
+	}
+
+	ticker := b.clock.NewTicker(scheduleProgressInterval)
+	defer ticker.Stop()
+
+	for d > 0 {
+		timer := b.clock.NewTimer(d)
+
+		select {
+		case <-timer.C():
+			d = b.reserve(size)
+
+		case <-ticker.C():
+			timer.Stop()
+			progress()
+			d = b.reserve(size)
+
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"time"
+
+	"github.com/choria-io/stream-replicator/clock"
+	"github.com/choria-io/stream-replicator/config"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Bandwidth", func() {
+	var clk *clock.Mock
+
+	BeforeEach(func() {
+		clk = clock.NewMock(time.Date(2023, 6, 1, 7, 0, 0, 0, time.UTC))
+	})
+
+	newLimiter := func(bw *config.Bandwidth) *bandwidthLimiter {
+		scfg := &config.Stream{
+			Stream:    "TEST",
+			SourceURL: "nats://localhost:4222",
+			TargetURL: "nats://localhost:4222",
+			Bandwidth: bw,
+		}
+		sr := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+		Expect(sr.Validate()).To(Succeed())
+
+		return newBandwidthLimiter(scfg.Bandwidth, clk)
+	}
+
+	window := func(schedule string, duration string, percent int) *config.BandwidthWindow {
+		return &config.BandwidthWindow{
+			Maintenance: config.Maintenance{Schedule: schedule, DurationString: duration, Timezone: "UTC"},
+			Percent:     percent,
+		}
+	}
+
+	It("Should follow the schedule", func() {
+		b := newLimiter(&config.Bandwidth{
+			BytesPerSecond: 10000,
+			Schedule:       []*config.BandwidthWindow{window("0 8 * * *", "10h", 10), window("0 12 * * *", "1h", 50)},
+		})
+		Expect(b.limit()).To(Equal(int64(10000)))
+
+		clk.Add(time.Hour)
+		Expect(b.limit()).To(Equal(int64(1000)))
+
+		// the lowest limit applies while windows overlap
+		clk.Add(4 * time.Hour)
+		Expect(b.limit()).To(Equal(int64(1000)))
+
+		clk.Add(6 * time.Hour)
+		Expect(b.limit()).To(Equal(int64(10000)))
+	})
+
+	It("Should limit unlimited streams only during windows", func() {
+		b := newLimiter(&config.Bandwidth{
+			Schedule: []*config.BandwidthWindow{{
+				Maintenance:    config.Maintenance{Schedule: "0 8 * * *", DurationString: "10h", Timezone: "UTC"},
+				BytesPerSecond: 2000,
+			}},
+		})
+		Expect(b.limit()).To(Equal(int64(0)))
+		Expect(b.reserve(1 << 30)).To(Equal(time.Duration(0)))
+
+		clk.Add(time.Hour)
+		Expect(b.limit()).To(Equal(int64(2000)))
+	})
+
+	It("Should wait for bandwidth", func() {
+		b := newLimiter(&config.Bandwidth{BytesPerSecond: 1000})
+
+		// a second of bandwidth is available at start, later messages wait for it
+		Expect(b.reserve(600)).To(Equal(time.Duration(0)))
+		Expect(b.reserve(600)).To(Equal(time.Duration(0)))
+		Expect(b.reserve(600)).To(Equal(201 * time.Millisecond))
+
+		clk.Add(time.Hour)
+		Expect(b.reserve(600)).To(Equal(time.Duration(0)))
+		Expect(b.reserve(600)).To(Equal(time.Duration(0)))
+		Expect(b.reserve(600)).To(Equal(201 * time.Millisecond))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		done := make(chan error, 1)
+		go func() { done <- b.wait(ctx, 600, func() {}) }()
+
+		Eventually(clk.Timers).Should(Equal(2))
+		Consistently(done, 50*time.Millisecond).ShouldNot(Receive())
+		clk.Add(201 * time.Millisecond)
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
+	It("Should wake up when a window ends", func() {
+		b := newLimiter(&config.Bandwidth{
+			Schedule: []*config.BandwidthWindow{{
+				Maintenance:    config.Maintenance{Schedule: "0 7 * * *", DurationString: "1m", Timezone: "UTC"},
+				BytesPerSecond: 1,
+			}},
+		})
+
+		Expect(b.reserve(1000)).To(Equal(time.Duration(0)))
+		Expect(b.reserve(1000)).To(Equal(time.Minute))
+
+		clk.Add(time.Minute)
+		Expect(b.reserve(1000)).To(Equal(time.Duration(0)))
+	})
+})
@@ -0,0 +1,438 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/jsm.go/api"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// batchCopier pulls batches of messages from the source stream and delivers them to a batchSink using a number of
+// concurrent workers, messages are acknowledged once their batch was delivered
+type batchCopier struct {
+	s        *Stream
+	sr       *config.Config
+	cfg      *config.Stream
+	dcfg     *config.Delivery
+	sink     batchSink
+	source   *Target
+	cname    string
+	mu       sync.Mutex
+	inflight map[int]uint64
+	acked    uint64
+	copied   int64
+	skipped  int64
+	log      *logrus.Entry
+}
+
+func newBatchCopier(s *Stream, log *logrus.Entry) *batchCopier {
+	return &batchCopier{
+		s:        s,
+		sr:       s.sr,
+		cfg:      s.cfg,
+		dcfg:     s.delivery,
+		sink:     s.batchSink,
+		source:   s.source,
+		cname:    s.cname,
+		inflight: make(map[int]uint64),
+		log: log.WithFields(logrus.Fields{
+			"copier":   "batch",
+			"consumer": s.cname,
+		}),
+	}
+}
+
+func (c *batchCopier) copyMessages(ctx context.Context) error {
+	c.log.Infof("Starting batch data copier for %s with %d worker(s) delivering up to %d message(s) each", c.cfg.Stream, c.dcfg.Concurrency, c.dcfg.Batch)
+
+	err := c.s.retries.For(ctx, func(try int) error {
+		_, err := c.healthCheckSource()
+		if err != nil {
+			c.log.Errorf("Creating consumer failed on try %d: %v", try, err)
+		}
+
+		return err
+	})
+	if err != nil {
+		c.log.Warnf("Copier shutting down after context interrupt")
+		return nil
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < c.dcfg.Concurrency; i++ {
+		wg.Add(1)
+		go c.worker(ctx, &wg, i)
+	}
+
+	health := time.NewTicker(c.s.hcInterval)
+	liveness := time.NewTicker(livenessInterval)
+	defer func() {
+		health.Stop()
+		liveness.Stop()
+	}()
+
+	for {
+		select {
+		case <-liveness.C:
+			c.s.markActive()
+
+		case <-health.C:
+			fixed, err := c.healthCheckSource()
+			if err != nil {
+				c.log.Errorf("Source health check failed: %v", err)
+			}
+			if fixed {
+				c.log.Infof("Source consumer %s recreated", c.cname)
+				consumerRepairCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+			}
+
+		case <-ctx.Done():
+			wg.Wait()
+			c.log.Warnf("Copier shutting down after context interrupt")
+			return nil
+		}
+	}
+}
+
+// worker repeatedly requests a batch of messages and delivers it
+func (c *batchCopier) worker(ctx context.Context, wg *sync.WaitGroup, id int) {
+	defer wg.Done()
+
+	sizer := newAdaptiveBatch(c.dcfg.Batch, c.dcfg.MaxBatch)
+	size := sizer.size
+	worker := fmt.Sprintf("%s_%d", c.cfg.Name, id)
+	batchSize.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, worker).Set(float64(size))
+
+	msgs := make(chan *nats.Msg, sizer.max)
+	inbox := c.source.nc.NewRespInbox()
+	sub, err := c.source.nc.ChanSubscribe(inbox, msgs)
+	if err != nil {
+		c.log.Errorf("Worker %d could not subscribe: %v", id, err)
+		return
+	}
+	defer sub.Unsubscribe()
+
+	for ctx.Err() == nil {
+		// while over the memory budget single messages are requested once no other batches are being delivered
+		request := size
+		if c.s.budget != nil {
+			memoryBudgetUsed.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Set(float64(c.s.budget.used()))
+
+			if c.s.budget.exceeded() {
+				if c.s.budget.inFlight() > 0 {
+					memoryThrottledCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+					select {
+					case <-time.After(budgetInterval):
+					case <-ctx.Done():
+					}
+					continue
+				}
+
+				request = 1
+			}
+		}
+
+		c.source.mu.Lock()
+		consumer := c.source.consumer
+		c.source.mu.Unlock()
+
+		err = consumer.NextMsgRequest(inbox, &api.JSApiConsumerGetNextRequest{Expires: c.dcfg.FlushInterval, Batch: request, MaxBytes: c.s.fetchMaxBytes()})
+		if err != nil {
+			c.log.Errorf("Could not request next messages: %v", err)
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+			}
+			continue
+		}
+
+		batch := c.collect(ctx, msgs, request)
+		if len(batch) == 0 {
+			continue
+		}
+
+		// sizes the next request using what was pending once this batch was received
+		meta, err := jsm.ParseJSMsgMetadata(batch[len(batch)-1])
+		if err == nil {
+			next := sizer.adjust(meta.Pending())
+			if next != size {
+				c.log.Debugf("Worker %d requesting batches of %d message(s) with %d pending", id, next, meta.Pending())
+				size = next
+				batchSize.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, worker).Set(float64(size))
+			}
+		}
+
+		var sz int64
+		for _, msg := range batch {
+			sz += c.s.budget.acquire(msg)
+		}
+
+		c.deliver(ctx, id, batch)
+		c.s.budget.release(sz)
+	}
+}
+
+// collect receives messages for a batch request of size messages until it is complete or expired
+func (c *batchCopier) collect(ctx context.Context, msgs chan *nats.Msg, size int) []*nats.Msg {
+	var batch []*nats.Msg
+
+	// guards against status messages that never arrive, left over messages are received by the next request
+	timeout := time.NewTimer(c.dcfg.FlushInterval + 5*time.Second)
+	defer timeout.Stop()
+
+	for len(batch) < size {
+		select {
+		case msg := <-msgs:
+			if len(msg.Data) == 0 && msg.Header != nil && msg.Header.Get("Status") != _EMPTY_ {
+				return batch
+			}
+			batch = append(batch, msg)
+
+		case <-timeout.C:
+			return batch
+
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	return batch
+}
+
+// deliver prepares and delivers msgs retrying until delivered or retries are exhausted, messages are then acknowledged
+func (c *batchCopier) deliver(ctx context.Context, id int, msgs []*nats.Msg) {
+	obs := prometheus.NewTimer(processTime.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name))
+	defer obs.ObserveDuration()
+
+	var candidates, deliver []*nats.Msg
+	var seqs []uint64
+	var first, last uint64
+	var size int
+
+	for _, msg := range msgs {
+		receivedMessageCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+		receivedMessageSize.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Add(float64(len(msg.Data)))
+
+		meta, err := jsm.ParseJSMsgMetadata(msg)
+		if err != nil {
+			metaParsingFailedCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+			c.log.Warnf("Could not parse message metadata from %v: %v", msg.Reply, err)
+			continue
+		}
+
+		if first == 0 {
+			first = meta.StreamSequence()
+		}
+		last = meta.StreamSequence()
+
+		streamSequence.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Set(float64(meta.StreamSequence()))
+		pendingMessages.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Set(float64(meta.Pending()))
+		messageAge.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Observe(time.Since(meta.TimeStamp()).Seconds())
+		c.s.countRedelivery(meta)
+
+		if c.cfg.MaxAgeDuration > 0 && time.Since(meta.TimeStamp()) > c.cfg.MaxAgeDuration {
+			ageSkippedCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+			continue
+		}
+
+		if msg.Header == nil {
+			msg.Header = nats.Header{}
+		}
+		msg.Header.Add(srcHeader, srcHeaderValue(c.cfg.Stream, int64(meta.StreamSequence()), c.sr.ReplicatorName, c.cfg.Name, meta.TimeStamp().UnixMilli()))
+
+		c.s.mapJetStreamHeaders(msg)
+		if c.s.forwardCopy(msg) || !c.s.shardMessage(msg) || !c.s.verifyMessage(msg) {
+			atomic.AddInt64(&c.skipped, 1)
+			continue
+		}
+
+		candidates = append(candidates, msg)
+		seqs = append(seqs, meta.StreamSequence())
+	}
+
+	// the batch is transformed at once so transform workers handle its messages concurrently
+	keep, err := c.s.transformMessages(ctx, candidates)
+	if err != nil {
+		return
+	}
+
+	for i, msg := range candidates {
+		if !keep[i] {
+			atomic.AddInt64(&c.skipped, 1)
+			continue
+		}
+
+		err = c.s.prepareMessage(msg)
+		if err != nil {
+			c.log.Errorf("Could not sign message %d: %v", seqs[i], err)
+			continue
+		}
+
+		msg.Subject = c.s.TargetForSubject(msg.Subject)
+		deliver = append(deliver, msg)
+		size += len(msg.Data)
+	}
+
+	c.mu.Lock()
+	c.inflight[id] = first
+	c.mu.Unlock()
+
+	delivered := true
+	if len(deliver) > 0 {
+		err = c.s.scheduleMessages(ctx, deliver...)
+		if err != nil {
+			return
+		}
+
+		err := c.s.retries.For(ctx, func(try int) error {
+			// avoids redelivery while retrying
+			if try > 1 {
+				for _, msg := range msgs {
+					msg.InProgress()
+				}
+			}
+
+			err := c.sink.deliver(ctx, deliver)
+			if err == nil {
+				return nil
+			}
+
+			handlerErrorCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+			c.s.reportError(err)
+
+			if c.dcfg.Retries > 0 && try > c.dcfg.Retries {
+				c.log.Errorf("Discarding %d message(s) %d-%d after %d failed deliveries: %v", len(deliver), first, last, try, err)
+				delivered = false
+				return nil
+			}
+
+			c.log.Errorf("Delivering %d message(s) %d-%d failed on try %d: %v", len(deliver), first, last, try, err)
+
+			return err
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	for _, msg := range msgs {
+		var err error
+		if delivered {
+			err = msg.Ack()
+		} else {
+			err = msg.Term()
+		}
+		if err != nil {
+			ackFailedCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+			c.log.Errorf("ACK failed: %v", err)
+		}
+	}
+
+	c.mu.Lock()
+	delete(c.inflight, id)
+	if last > c.acked {
+		c.acked = last
+	}
+	c.mu.Unlock()
+
+	if !delivered {
+		atomic.AddInt64(&c.skipped, int64(len(deliver)))
+		return
+	}
+
+	copied := atomic.AddInt64(&c.copied, int64(len(deliver)))
+	c.log.Debugf("Delivered %d message(s) %d-%d, copied %d skipped %d", len(deliver), first, last, copied, atomic.LoadInt64(&c.skipped))
+
+	copiedMessageCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Add(float64(len(deliver)))
+	copiedMessageSize.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Add(float64(size))
+
+	for _, msg := range deliver {
+		c.s.subjects.record(msg.Subject, len(msg.Data))
+		c.s.messageCopied(msg)
+	}
+}
+
+// resumeSequence is where a recreated consumer starts, the oldest batch being delivered or after the last acknowledged
+func (c *batchCopier) resumeSequence() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var resume uint64
+	for _, seq := range c.inflight {
+		if seq > 0 && (resume == 0 || seq < resume) {
+			resume = seq
+		}
+	}
+
+	if resume == 0 && c.acked > 0 {
+		resume = c.acked + 1
+	}
+
+	return resume
+}
+
+// healthCheckSource creates the consumer when it does not exist, the consumer tracks progress using acknowledgements
+func (c *batchCopier) healthCheckSource() (fixed bool, err error) {
+	c.source.mu.Lock()
+	defer c.source.mu.Unlock()
+
+	stream := c.source.stream
+
+	c.source.consumer, err = stream.LoadConsumer(c.cname)
+	if err == nil {
+		c.s.reportConsumerState(c.source.consumer)
+	}
+	if !jsm.IsNatsError(err, 10014) {
+		return false, err
+	}
+
+	opts := []jsm.ConsumerOption{
+		jsm.DurableName(c.cname),
+		jsm.ConsumerDescription(fmt.Sprintf("%s %s", ConsumerDescriptionPrefix, c.cfg.Name)),
+		jsm.AcknowledgeExplicit(),
+		jsm.MaxAckPending(uint(newAdaptiveBatch(c.dcfg.Batch, c.dcfg.MaxBatch).max * c.dcfg.Concurrency)),
+		jsm.AckWait(time.Minute),
+	}
+
+	if c.cfg.FilterSubject != _EMPTY_ {
+		opts = append(opts, jsm.FilterStreamBySubject(c.cfg.FilterSubject))
+	}
+
+	resume := c.resumeSequence()
+	if resume > 0 {
+		c.log.Errorf("Consumer %s was not found, attempting to recreate", c.cname)
+		opts = append(opts, jsm.StartAtSequence(resume))
+	} else {
+		switch {
+		case c.cfg.StartAtEnd:
+			opts = append(opts, jsm.StartWithNextReceived())
+		case c.cfg.StartSequence > 0:
+			opts = append(opts, jsm.StartAtSequence(c.cfg.StartSequence))
+		case c.cfg.StartDelta > 0:
+			opts = append(opts, jsm.StartAtTime(time.Now().UTC().Add(-1*c.cfg.StartDelta)))
+		case !c.cfg.StartTime.IsZero():
+			opts = append(opts, jsm.StartAtTime(c.cfg.StartTime.UTC()))
+		default:
+			opts = append(opts, jsm.DeliverAllAvailable())
+		}
+	}
+
+	c.source.consumer, err = stream.NewConsumerFromDefault(jsm.DefaultConsumer, opts...)
+	if err == nil && resume > 0 {
+		c.s.consumerRecreated(consumerDeleted, resume)
+	}
+
+	return err == nil, err
+}
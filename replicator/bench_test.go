@@ -0,0 +1,124 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/sirupsen/logrus"
+)
+
+func BenchmarkSourceInitiatedCopier(b *testing.B) {
+	cases := []struct {
+		name  string
+		size  int
+		batch int
+		conns int
+	}{
+		{name: "single_128b", size: 128},
+		{name: "batch_128b", size: 128, batch: 100},
+		{name: "batch_4kb", size: 4096, batch: 100},
+		{name: "connections_4kb", size: 4096, batch: 100, conns: 4},
+	}
+
+	for _, bc := range cases {
+		bc := bc
+		b.Run(bc.name, func(b *testing.B) {
+			scfg := &config.Stream{TargetConnections: bc.conns}
+			if bc.batch > 0 {
+				scfg.Fetch = &config.Fetch{Batch: bc.batch}
+			}
+
+			benchmarkCopier(b, scfg, bc.size)
+		})
+	}
+}
+
+func BenchmarkSrcHeaderValue(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		srcHeaderValue("ORDERS", int64(i), "GINKGO", "copy", 1688472000000)
+	}
+}
+
+// benchmarkCopier copies b.N messages of size bytes using scfg, the source, target and naming settings are set here
+func benchmarkCopier(b *testing.B, scfg *config.Stream, size int) {
+	b.Helper()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	log := logrus.NewEntry(logger)
+
+	_, nc, mgr, stop, err := testutil.StartJetStream(nil)
+	if err != nil {
+		b.Fatalf("could not start server: %v", err)
+	}
+	defer stop()
+
+	_, err = testutil.LoadStream(nc, mgr, "BENCH", b.N, size)
+	if err != nil {
+		b.Fatalf("could not load messages: %v", err)
+	}
+
+	tcs, err := mgr.NewStream("BENCH_COPY", jsm.Subjects("copy.>"))
+	if err != nil {
+		b.Fatalf("could not create target: %v", err)
+	}
+
+	scfg.Stream = "BENCH"
+	scfg.TargetStream = "BENCH_COPY"
+	scfg.TargetPrefix = "copy"
+	scfg.SourceURL = nc.ConnectedUrl()
+	scfg.TargetURL = nc.ConnectedUrl()
+
+	sr := &config.Config{ReplicatorName: "BENCH", Streams: []*config.Stream{scfg}}
+	err = sr.Validate()
+	if err != nil {
+		b.Fatalf("invalid configuration: %v", err)
+	}
+
+	stream, err := NewStream(scfg, sr, log)
+	if err != nil {
+		b.Fatalf("could not create stream: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	wg := sync.WaitGroup{}
+
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+
+	wg.Add(1)
+	go stream.Run(ctx, &wg)
+
+	for {
+		nfo, err := tcs.State()
+		if err != nil {
+			b.Fatalf("could not get target state: %v", err)
+		}
+		if nfo.Msgs >= uint64(b.N) {
+			break
+		}
+
+		select {
+		case <-time.After(5 * time.Millisecond):
+		case <-ctx.Done():
+			b.Fatalf("copied %d of %d messages", nfo.Msgs, b.N)
+		}
+	}
+
+	b.StopTimer()
+	cancel()
+	wg.Wait()
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// ChecksumHeader holds the hex encoded SHA-256 checksum of the message payload
+	ChecksumHeader = "Choria-SR-Checksum"
+	// ChecksumFailedHeader is added to messages that failed checksum verification in flag mode
+	ChecksumFailedHeader = "Choria-SR-Checksum-Failed"
+)
+
+func payloadChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// stampChecksum sets the checksum of the payload of msg replacing any checksum added by earlier replicators
+func stampChecksum(msg *nats.Msg) {
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+
+	msg.Header.Set(ChecksumHeader, payloadChecksum(msg.Data))
+}
+
+// verifyChecksum checks the payload of msg against its checksum, messages without one only fail when required
+func verifyChecksum(msg *nats.Msg, required bool) error {
+	var expected string
+	if msg.Header != nil {
+		expected = msg.Header.Get(ChecksumHeader)
+	}
+
+	if expected == "" {
+		if required {
+			return fmt.Errorf("no checksum")
+		}
+		return nil
+	}
+
+	if payloadChecksum(msg.Data) != expected {
+		return fmt.Errorf("checksum mismatch")
+	}
+
+	return nil
+}
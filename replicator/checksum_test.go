@@ -0,0 +1,231 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Checksums", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	setup := func(nc *nats.Conn, mgr *jsm.Manager) (*config.Config, *config.Stream, *jsm.Stream) {
+		_, err := mgr.NewStream("TEST")
+		Expect(err).ToNot(HaveOccurred())
+		tcs, err := mgr.NewStream("TEST_COPY", jsm.Subjects("copy.>"))
+		Expect(err).ToNot(HaveOccurred())
+
+		stream := &config.Stream{
+			Stream:       "TEST",
+			TargetStream: "TEST_COPY",
+			TargetPrefix: "copy",
+			SourceURL:    nc.ConnectedUrl(),
+			TargetURL:    nc.ConnectedUrl(),
+		}
+
+		return &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{stream}}, stream, tcs
+	}
+
+	// publishes count messages to the source, every one with a checksum that is corrupt when corrupt is true
+	publish := func(nc *nats.Conn, count int, checksum bool, corrupt bool) {
+		for i := 1; i <= count; i++ {
+			msg := nats.NewMsg("TEST")
+			msg.Data = []byte(fmt.Sprintf(`{"msg":%d}`, i))
+			if checksum {
+				stampChecksum(msg)
+			}
+			if corrupt {
+				msg.Data = []byte("corrupt")
+			}
+			_, err := nc.RequestMsg(msg, time.Second)
+			Expect(err).ToNot(HaveOccurred())
+		}
+	}
+
+	// for use with Eventually()
+	streamMesssage := func(s *jsm.Stream) func() (uint64, error) {
+		return func() (uint64, error) {
+			nfo, err := s.State()
+			if err != nil {
+				return 0, err
+			}
+			return nfo.Msgs, nil
+		}
+	}
+
+	run := func(scfg *config.Stream, sr *config.Config) {
+		stream, err := NewStream(scfg, sr, log)
+		Expect(err).ToNot(HaveOccurred())
+
+		wg.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+		}()
+	}
+
+	headers := func(s *jsm.Stream, seq uint64) nats.Header {
+		msg, err := s.ReadMessage(seq)
+		Expect(err).ToNot(HaveOccurred())
+		hdrs, err := decodeHeadersMsg(msg.Header)
+		Expect(err).ToNot(HaveOccurred())
+
+		return hdrs
+	}
+
+	Describe("verifyChecksum", func() {
+		It("Should verify payloads", func() {
+			msg := nats.NewMsg("x")
+			msg.Data = []byte("hello world")
+			Expect(verifyChecksum(msg, false)).To(Succeed())
+			Expect(verifyChecksum(msg, true)).To(MatchError("no checksum"))
+
+			stampChecksum(msg)
+			Expect(msg.Header.Get(ChecksumHeader)).To(Equal("b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"))
+			Expect(verifyChecksum(msg, true)).To(Succeed())
+
+			msg.Data = []byte("hello there")
+			Expect(verifyChecksum(msg, false)).To(MatchError("checksum mismatch"))
+		})
+	})
+
+	It("Should add checksums to transformed messages", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			sr, scfg, tcs := setup(nc, mgr)
+			publish(nc, 4, false, false)
+
+			scfg.Checksum = true
+			scfg.Transform = &config.Transform{Mapping: `{"id": data.msg}`}
+			run(scfg, sr)
+
+			Eventually(streamMesssage(tcs)).Should(BeNumerically("==", 4))
+
+			msg, err := tcs.ReadMessage(1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(msg.Data).To(MatchJSON(`{"id":1}`))
+			Expect(verifyChecksum(&nats.Msg{Data: msg.Data, Header: headers(tcs, 1)}, true)).To(Succeed())
+		})
+	})
+
+	It("Should count messages failing verification", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			sr, scfg, tcs := setup(nc, mgr)
+			publish(nc, 2, true, false)
+			publish(nc, 3, true, true)
+			publish(nc, 1, false, false)
+
+			scfg.Name = "COUNT"
+			scfg.VerifyChecksum = &config.VerifyChecksum{Mode: config.VerifyCount}
+			run(scfg, sr)
+
+			Eventually(streamMesssage(tcs)).Should(BeNumerically("==", 6))
+			Expect(getPromCountValue(checksumFailedCount, "TEST", "GINKGO", "COUNT")).To(Equal(3.0))
+		})
+	})
+
+	It("Should flag messages failing verification", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			sr, scfg, tcs := setup(nc, mgr)
+			publish(nc, 1, true, false)
+			publish(nc, 1, true, true)
+			publish(nc, 1, false, false)
+
+			scfg.VerifyChecksum = &config.VerifyChecksum{Mode: config.VerifyFlag, Required: true}
+			run(scfg, sr)
+
+			Eventually(streamMesssage(tcs)).Should(BeNumerically("==", 3))
+			Expect(headers(tcs, 1).Get(ChecksumFailedHeader)).To(BeEmpty())
+			Expect(headers(tcs, 2).Get(ChecksumFailedHeader)).To(Equal("checksum mismatch"))
+			Expect(headers(tcs, 3).Get(ChecksumFailedHeader)).To(Equal("no checksum"))
+		})
+	})
+
+	It("Should drop messages failing verification", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			sr, scfg, tcs := setup(nc, mgr)
+			publish(nc, 3, true, true)
+			publish(nc, 2, true, false)
+
+			scfg.VerifyChecksum = &config.VerifyChecksum{Mode: config.VerifyDrop}
+			run(scfg, sr)
+
+			Eventually(streamMesssage(tcs)).Should(BeNumerically("==", 2))
+			Consistently(streamMesssage(tcs), "500ms").Should(BeNumerically("==", 2))
+		})
+	})
+})
+
+func getPromCountValue(ctr *prometheus.CounterVec, labels ...string) float64 {
+	pb := &dto.Metric{}
+	m, err := ctr.GetMetricWithLabelValues(labels...)
+	if err != nil {
+		return 0
+	}
+
+	if m.Write(pb) != nil {
+		return 0
+	}
+
+	return pb.GetCounter().GetValue()
+}
+
+func getPromGaugeValue(gauge *prometheus.GaugeVec, labels ...string) float64 {
+	pb := &dto.Metric{}
+	m, err := gauge.GetMetricWithLabelValues(labels...)
+	if err != nil {
+		return 0
+	}
+
+	if m.Write(pb) != nil {
+		return 0
+	}
+
+	return pb.GetGauge().GetValue()
+}
+
+func getPromHistogramValue(hist *prometheus.HistogramVec, labels ...string) *dto.Histogram {
+	pb := &dto.Metric{}
+	m, err := hist.GetMetricWithLabelValues(labels...)
+	if err != nil {
+		return nil
+	}
+
+	if m.(prometheus.Metric).Write(pb) != nil {
+		return nil
+	}
+
+	return pb.GetHistogram()
+}
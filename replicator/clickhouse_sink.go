@@ -0,0 +1,288 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/util"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+// clickhouseSink inserts batches of messages into a ClickHouse table using the HTTP interface
+type clickhouseSink struct {
+	cfg     *config.ClickHouse
+	client  *http.Client
+	stream  string
+	columns []string
+	log     *logrus.Entry
+}
+
+func (s *Stream) connectClickHouse() error {
+	ccfg := s.cfg.TargetClickHouse
+
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.MaxIdleConnsPerHost = ccfg.Concurrency
+
+	var err error
+	if s.cfg.TargetProxy != _EMPTY_ {
+		tr.Proxy = nil
+		tr.DialContext, err = util.ProxyDialer(s.cfg.TargetProxy, 30*time.Second)
+		if err != nil {
+			return fmt.Errorf("clickhouse target setup failed: %v", err)
+		}
+	}
+
+	if ccfg.TLS != nil {
+		tr.TLSClientConfig, err = util.ClientTLSConfig(ccfg.TLS.CA, ccfg.TLS.Cert, ccfg.TLS.Key)
+		if err != nil {
+			return fmt.Errorf("clickhouse target tls: %v", err)
+		}
+	}
+
+	var columns []string
+	for column := range ccfg.Columns {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	log := s.log.WithField("connection", "clickhouse")
+	log.Infof("Inserting messages into table %s using %s", ccfg.Table, ccfg.URL)
+
+	s.batchSink = &clickhouseSink{
+		cfg:     ccfg,
+		client:  &http.Client{Transport: tr, Timeout: ccfg.Timeout},
+		stream:  s.cfg.Stream,
+		columns: columns,
+		log:     log,
+	}
+	s.delivery = &ccfg.Delivery
+
+	return nil
+}
+
+// deliver inserts msgs in one insert, messages that can not be parsed are logged and discarded
+func (c *clickhouseSink) deliver(ctx context.Context, msgs []*nats.Msg) error {
+	var body bytes.Buffer
+	var first, last uint64
+
+	for _, msg := range msgs {
+		meta, err := jsm.ParseJSMsgMetadata(msg)
+		if err != nil {
+			c.log.Errorf("Discarding message on %s without metadata: %v", msg.Subject, err)
+			continue
+		}
+
+		if first == 0 {
+			first = meta.StreamSequence()
+		}
+		last = meta.StreamSequence()
+
+		err = c.appendRows(&body, msg, meta)
+		if err != nil {
+			c.log.Errorf("Discarding message %d on %s: %v", meta.StreamSequence(), msg.Subject, err)
+		}
+	}
+
+	if body.Len() == 0 {
+		return nil
+	}
+
+	return c.insert(ctx, body.Bytes(), fmt.Sprintf("%s-%d-%d", c.stream, first, last))
+}
+
+// appendRows adds the rows in msg to body in the format of the insert
+func (c *clickhouseSink) appendRows(body *bytes.Buffer, msg *nats.Msg, meta *jsm.MsgInfo) error {
+	switch {
+	case len(c.columns) == 0 && c.cfg.Format == config.ClickHouseCSV:
+		data := bytes.TrimRight(msg.Data, "\r\n")
+		if len(data) == 0 {
+			return fmt.Errorf("empty csv payload")
+		}
+		body.Write(data)
+		body.WriteByte('\n')
+
+	case len(c.columns) == 0:
+		err := json.Compact(body, msg.Data)
+		if err != nil {
+			return fmt.Errorf("invalid json payload: %v", err)
+		}
+		body.WriteByte('\n')
+
+	case c.cfg.Format == config.ClickHouseCSV:
+		records, err := csv.NewReader(bytes.NewReader(msg.Data)).ReadAll()
+		if err != nil {
+			return fmt.Errorf("invalid csv payload: %v", err)
+		}
+
+		for _, record := range records {
+			err = c.appendRow(body, msg, meta, func(source string) any {
+				field, _ := strconv.Atoi(source)
+				if field > len(record) {
+					return nil
+				}
+				return record[field-1]
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+	default:
+		dec := json.NewDecoder(bytes.NewReader(msg.Data))
+		dec.UseNumber()
+
+		var doc map[string]any
+		err := dec.Decode(&doc)
+		if err != nil {
+			return fmt.Errorf("invalid json payload: %v", err)
+		}
+
+		return c.appendRow(body, msg, meta, func(source string) any {
+			return jsonField(doc, source)
+		})
+	}
+
+	return nil
+}
+
+// appendRow adds a JSON row for the mapped columns to body, values are looked up using field unless they refer to the message
+func (c *clickhouseSink) appendRow(body *bytes.Buffer, msg *nats.Msg, meta *jsm.MsgInfo, field func(string) any) error {
+	row := make(map[string]any, len(c.columns))
+
+	for _, column := range c.columns {
+		var val any
+
+		switch source := c.cfg.Columns[column]; source {
+		case "$stream":
+			val = c.stream
+		case "$subject":
+			val = msg.Subject
+		case "$sequence":
+			val = meta.StreamSequence()
+		case "$time":
+			val = meta.TimeStamp().UTC().Format(time.RFC3339Nano)
+		case "$data":
+			val = string(msg.Data)
+		default:
+			val = field(source)
+		}
+
+		// missing values are left out so the column default is used
+		if val != nil {
+			row[column] = val
+		}
+	}
+
+	line, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+
+	body.Write(line)
+	body.WriteByte('\n')
+
+	return nil
+}
+
+// jsonField looks up a dot separated path in doc, nil when not found
+func jsonField(doc map[string]any, path string) any {
+	var val any = doc
+
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := val.(map[string]any)
+		if !ok {
+			return nil
+		}
+		val, ok = obj[key]
+		if !ok {
+			return nil
+		}
+	}
+
+	return val
+}
+
+// query is the insert statement for the configured table and columns
+func (c *clickhouseSink) query() string {
+	format := "JSONEachRow"
+	if len(c.columns) == 0 && c.cfg.Format == config.ClickHouseCSV {
+		format = "CSV"
+	}
+
+	table := quoteIdentifier(c.cfg.Table)
+	if c.cfg.Database != _EMPTY_ {
+		table = quoteIdentifier(c.cfg.Database) + "." + table
+	}
+
+	if len(c.columns) == 0 {
+		return fmt.Sprintf("INSERT INTO %s FORMAT %s", table, format)
+	}
+
+	columns := make([]string, len(c.columns))
+	for i, column := range c.columns {
+		columns[i] = quoteIdentifier(column)
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (%s) FORMAT %s", table, strings.Join(columns, ", "), format)
+}
+
+// quoteIdentifier quotes name using backticks, escaping backslashes before the backticks so a trailing backslash can
+// not escape the closing quote
+func quoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(strings.ReplaceAll(name, "\\", "\\\\"), "`", "\\`") + "`"
+}
+
+// insert posts body, token lets the server discard inserts of batches that were retried after succeeding
+func (c *clickhouseSink) insert(ctx context.Context, body []byte, token string) error {
+	params := url.Values{}
+	params.Set("query", c.query())
+	params.Set("date_time_input_format", "best_effort")
+	params.Set("insert_deduplication_token", token)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(c.cfg.URL, "/")+"/?"+params.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	if c.cfg.Username != _EMPTY_ {
+		req.SetBasicAuth(c.cfg.Username, c.cfg.Password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// reading the body allows the connection to be reused
+	rbody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response %s: %s", resp.Status, strings.TrimSpace(string(rbody)))
+	}
+
+	return nil
+}
+
+func (c *clickhouseSink) close() error {
+	c.client.CloseIdleConnections()
+
+	return nil
+}
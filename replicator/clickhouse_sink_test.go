@@ -0,0 +1,175 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("ClickHouse Sink", func() {
+	var (
+		ctx      context.Context
+		cancel   context.CancelFunc
+		wg       = sync.WaitGroup{}
+		log      *logrus.Entry
+		mu       sync.Mutex
+		queries  []url.Values
+		bodies   []string
+		auth     [2]string
+		failures int
+		srv      *httptest.Server
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		queries = nil
+		bodies = nil
+		failures = 0
+
+		srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+
+			body, err := io.ReadAll(r.Body)
+			Expect(err).ToNot(HaveOccurred())
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			queries = append(queries, r.URL.Query())
+
+			if failures > 0 {
+				failures--
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprintln(w, "Code: 241. DB::Exception: Memory limit exceeded")
+				return
+			}
+
+			auth[0], auth[1], _ = r.BasicAuth()
+			bodies = append(bodies, string(body))
+		}))
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+			srv.Close()
+		})
+	})
+
+	run := func(nc *nats.Conn, mgr *jsm.Manager, ccfg *config.ClickHouse, payloads ...string) *Stream {
+		_, err := mgr.NewStream("TELEMETRY", jsm.Subjects("TELEMETRY.>"))
+		Expect(err).ToNot(HaveOccurred())
+
+		for i, payload := range payloads {
+			_, err := nc.Request(fmt.Sprintf("TELEMETRY.%d", i+1), []byte(payload), time.Second)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		scfg := &config.Stream{Stream: "TELEMETRY", SourceURL: nc.ConnectedUrl(), TargetClickHouse: ccfg}
+		cfg := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+		Expect(cfg.Validate()).To(Succeed())
+
+		stream, err := NewStream(scfg, cfg, log)
+		Expect(err).ToNot(HaveOccurred())
+
+		wg.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+		}()
+
+		return stream
+	}
+
+	inserted := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+
+		var rows []string
+		for _, body := range bodies {
+			rows = append(rows, strings.Split(strings.TrimSpace(body), "\n")...)
+		}
+		return rows
+	}
+
+	It("Should quote identifiers", func() {
+		Expect(quoteIdentifier("readings")).To(Equal("`readings`"))
+		Expect(quoteIdentifier("read`ings")).To(Equal("`read\\`ings`"))
+		Expect(quoteIdentifier(`readings\`)).To(Equal("`readings\\\\`"))
+		Expect(quoteIdentifier("read\\`ings")).To(Equal("`read\\\\\\`ings`"))
+	})
+
+	It("Should insert mapped JSON fields", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			run(nc, mgr, &config.ClickHouse{
+				URL:      srv.URL,
+				Database: "analytics",
+				Table:    "readings",
+				Username: "default",
+				Password: "secret",
+				Columns:  map[string]string{"host": "sensor.host", "value": "value", "subject": "$subject", "seq": "$sequence"},
+				Delivery: config.Delivery{Batch: 4, FlushIntervalString: "200ms"},
+			}, `{"sensor": {"host": "h1"}, "value": 1.5}`, "not json", `{"value": 12345678901234567890}`, `{"sensor": {"host": "h4"}, "value": 4}`)
+
+			Eventually(inserted, "10s").Should(HaveLen(3))
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			Expect(auth).To(Equal([2]string{"default", "secret"}))
+			Expect(queries[0].Get("query")).To(Equal("INSERT INTO `analytics`.`readings` (`host`, `seq`, `subject`, `value`) FORMAT JSONEachRow"))
+			Expect(queries[0].Get("insert_deduplication_token")).To(Equal("TELEMETRY-1-4"))
+
+			rows := strings.Split(strings.TrimSpace(bodies[0]), "\n")
+			Expect(rows[0]).To(MatchJSON(`{"host": "h1", "value": 1.5, "subject": "TELEMETRY.1", "seq": 1}`))
+			Expect(rows[1]).To(Equal(`{"seq":3,"subject":"TELEMETRY.3","value":12345678901234567890}`))
+			Expect(rows[2]).To(MatchJSON(`{"host": "h4", "value": 4, "subject": "TELEMETRY.4", "seq": 4}`))
+		})
+	})
+
+	It("Should insert CSV rows and retry failed inserts", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			mu.Lock()
+			failures = 1
+			mu.Unlock()
+
+			run(nc, mgr, &config.ClickHouse{
+				URL:      srv.URL,
+				Table:    "readings",
+				Format:   config.ClickHouseCSV,
+				Delivery: config.Delivery{Batch: 3, FlushIntervalString: "200ms"},
+			}, "h1,1.5\n", "h2,2\r\nh3,3", "h4,4")
+
+			Eventually(inserted, "10s").Should(Equal([]string{"h1,1.5", "h2,2\r", "h3,3", "h4,4"}))
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			Expect(queries).To(HaveLen(2))
+			Expect(queries[1].Get("query")).To(Equal("INSERT INTO `readings` FORMAT CSV"))
+			Expect(queries[1].Get("insert_deduplication_token")).To(Equal(queries[0].Get("insert_deduplication_token")))
+		})
+	})
+})
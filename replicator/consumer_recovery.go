@@ -0,0 +1,94 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/choria-io/stream-replicator/events"
+	"github.com/nats-io/jsm.go"
+)
+
+const (
+	// consumerDeleted is the reason given when a consumer was removed from the source, by the server or an operator
+	consumerDeleted = "deleted"
+	// consumerInactive is the reason given when a consumer stopped delivering its pending messages
+	consumerInactive = "inactive"
+)
+
+// consumerRecreated publishes an event for a source consumer that was recreated after it was lost for reason
+func (s *Stream) consumerRecreated(reason string, start uint64) {
+	msg := fmt.Sprintf("Source consumer %s was %s and recreated", s.cname, reason)
+	if start > 0 {
+		msg = fmt.Sprintf("%s starting at sequence %d", msg, start)
+	}
+
+	s.log.Warn(msg)
+
+	s.events.Publish(&events.Event{
+		Event:   events.ConsumerRecreatedEvent,
+		Stream:  s.cfg.Stream,
+		Name:    s.cfg.Name,
+		Message: msg,
+		Data: map[string]string{
+			"consumer":       s.cname,
+			"reason":         reason,
+			"start_sequence": strconv.FormatUint(start, 10),
+		},
+	})
+}
+
+// consumerInactive checks if the consumer has messages pending but delivered none since the last message was received
+// for longer than consumer_inactivity, the server can end up with such consumers after losing their state. Must be
+// called with the source lock held.
+func (c *sourceInitiatedCopier) consumerInactive() (bool, error) {
+	if c.cfg.ConsumerInactivity == 0 || c.source.consumer == nil {
+		return false, nil
+	}
+
+	idle := time.Since(c.lastReceived)
+	if idle < c.cfg.ConsumerInactivity {
+		return false, nil
+	}
+
+	nfo, err := c.source.consumer.State()
+	if err != nil {
+		return false, err
+	}
+
+	// messages awaiting acknowledgement or redelivery after a NaK are still being handled
+	if nfo.NumPending == 0 || nfo.NumAckPending > 0 {
+		return false, nil
+	}
+
+	c.log.Warnf("Consumer %s delivered none of its %d pending message(s) in %v", c.cname, nfo.NumPending, idle.Round(time.Second))
+	consumerInactiveCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+
+	return true, nil
+}
+
+// reportConsumerState updates the consumer metrics from the current state of the source consumer
+func (s *Stream) reportConsumerState(consumer *jsm.Consumer) {
+	nfo, err := consumer.State()
+	if err != nil {
+		s.log.Warnf("Could not load the state of consumer %s: %v", s.cname, err)
+		return
+	}
+
+	consumerAckFloor.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Set(float64(nfo.AckFloor.Stream))
+	consumerPendingMessages.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Set(float64(nfo.NumPending))
+	consumerAckPendingMessages.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Set(float64(nfo.NumAckPending))
+	consumerRedeliveredMessages.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Set(float64(nfo.NumRedelivered))
+}
+
+// countRedelivery counts messages the consumer delivered before, many indicate the target is too slow to acknowledge
+// within the ack wait
+func (s *Stream) countRedelivery(meta *jsm.MsgInfo) {
+	if meta.Delivered() > 1 {
+		redeliveredMessageCount.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Inc()
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/events"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Consumer Recovery", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	publish := func(nc *nats.Conn, count int) {
+		for i := 0; i < count; i++ {
+			_, err := nc.Request("TEST", []byte("x"), time.Second)
+			Expect(err).ToNot(HaveOccurred())
+		}
+	}
+
+	// newStream creates a stream copying TEST to COPY publishing events to received
+	newStream := func(nc *nats.Conn, received chan *nats.Msg, inactivity time.Duration) *Stream {
+		_, err := nc.ChanSubscribe("events.>", received)
+		Expect(err).ToNot(HaveOccurred())
+
+		p := startEvents(ctx, &wg, nc, log)
+
+		scfg := &config.Stream{
+			Stream:             "TEST",
+			TargetStream:       "COPY",
+			TargetPrefix:       "copy",
+			SourceURL:          nc.ConnectedUrl(),
+			TargetURL:          nc.ConnectedUrl(),
+			ConsumerInactivity: inactivity,
+		}
+
+		stream, err := NewStream(scfg, &config.Config{ReplicatorName: "GINKGO"}, log, WithEvents(p))
+		Expect(err).ToNot(HaveOccurred())
+		stream.hcInterval = 10 * time.Millisecond
+
+		return stream
+	}
+
+	targetMessages := func(mgr *jsm.Manager) func() (uint64, error) {
+		return func() (uint64, error) {
+			target, err := mgr.LoadStream("COPY")
+			if err != nil {
+				return 0, err
+			}
+			nfo, err := target.State()
+			return nfo.Msgs, err
+		}
+	}
+
+	It("Should recreate deleted consumers after the last copied message", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			source, err := mgr.NewStream("TEST")
+			Expect(err).ToNot(HaveOccurred())
+			publish(nc, 10)
+
+			received := make(chan *nats.Msg, 10)
+			stream := newStream(nc, received, 0)
+
+			go func() {
+				defer GinkgoRecover()
+				wg.Add(1)
+				Expect(stream.Run(ctx, &wg)).To(Succeed())
+			}()
+			defer cancel()
+
+			Eventually(targetMessages(mgr), 5*time.Second).Should(Equal(uint64(10)))
+
+			consumer, err := source.LoadConsumer(stream.cname)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(consumer.Delete()).To(Succeed())
+
+			event := nextEvent(received)
+			Expect(event.Event).To(Equal(events.ConsumerRecreatedEvent))
+			Expect(event.Data["reason"]).To(Equal("deleted"))
+			Expect(event.Data["start_sequence"]).To(Equal("11"))
+
+			publish(nc, 5)
+			Eventually(targetMessages(mgr), 5*time.Second).Should(Equal(uint64(15)))
+			Consistently(targetMessages(mgr), 500*time.Millisecond).Should(Equal(uint64(15)))
+		})
+	})
+
+	It("Should recreate consumers that deliver none of their pending messages", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("TEST")
+			Expect(err).ToNot(HaveOccurred())
+
+			received := make(chan *nats.Msg, 10)
+			stream := newStream(nc, received, time.Minute)
+			Expect(stream.connect(ctx)).To(Succeed())
+
+			c := newSourceInitiatedCopier(stream, log)
+			c.lastReceived = time.Now()
+
+			fixed, err := c.healthCheckSource()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fixed).To(BeTrue())
+
+			// messages are pending but nothing requests them
+			publish(nc, 10)
+
+			fixed, err = c.healthCheckSource()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fixed).To(BeFalse())
+
+			c.lastReceived = time.Now().Add(-2 * time.Minute)
+			fixed, err = c.healthCheckSource()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fixed).To(BeTrue())
+			Expect(getPromCountValue(consumerInactiveCount, "TEST", "GINKGO", "")).To(Equal(1.0))
+
+			event := nextEvent(received)
+			Expect(event.Event).To(Equal(events.ConsumerRecreatedEvent))
+			Expect(event.Data["reason"]).To(Equal("inactive"))
+			Expect(event.Data["consumer"]).To(Equal(stream.cname))
+			Expect(received).ToNot(Receive())
+		})
+	})
+
+	It("Should report the consumer state and redeliveries", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("TEST")
+			Expect(err).ToNot(HaveOccurred())
+
+			received := make(chan *nats.Msg, 10)
+			stream := newStream(nc, received, 0)
+			Expect(stream.connect(ctx)).To(Succeed())
+
+			c := newSourceInitiatedCopier(stream, log)
+			_, err = c.healthCheckSource()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(getPromGaugeValue(consumerPendingMessages, "TEST", "GINKGO", "")).To(Equal(0.0))
+
+			publish(nc, 10)
+
+			msg, err := c.source.consumer.NextMsg()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(msg.Nak()).To(Succeed())
+
+			msg, err = c.source.consumer.NextMsg()
+			Expect(err).ToNot(HaveOccurred())
+			meta, err := jsm.ParseJSMsgMetadata(msg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(meta.Delivered()).To(Equal(2))
+			stream.countRedelivery(meta)
+			Expect(getPromCountValue(redeliveredMessageCount, "TEST", "GINKGO", "")).To(Equal(1.0))
+
+			_, err = c.healthCheckSource()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(getPromGaugeValue(consumerAckFloor, "TEST", "GINKGO", "")).To(Equal(0.0))
+			Expect(getPromGaugeValue(consumerPendingMessages, "TEST", "GINKGO", "")).To(Equal(9.0))
+			Expect(getPromGaugeValue(consumerAckPendingMessages, "TEST", "GINKGO", "")).To(Equal(1.0))
+			Expect(getPromGaugeValue(consumerRedeliveredMessages, "TEST", "GINKGO", "")).To(Equal(1.0))
+
+			Expect(msg.AckSync()).To(Succeed())
+
+			_, err = c.healthCheckSource()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(getPromGaugeValue(consumerAckFloor, "TEST", "GINKGO", "")).To(Equal(1.0))
+			Expect(getPromGaugeValue(consumerAckPendingMessages, "TEST", "GINKGO", "")).To(Equal(0.0))
+			Expect(getPromGaugeValue(consumerRedeliveredMessages, "TEST", "GINKGO", "")).To(Equal(0.0))
+		})
+	})
+})
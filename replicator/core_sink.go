@@ -0,0 +1,52 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/choria-io/stream-replicator/internal/util"
+	"github.com/nats-io/nats.go"
+)
+
+// coreSink publishes messages to core NATS subjects on the target without a target stream
+type coreSink struct {
+	nc *nats.Conn
+}
+
+func (s *Stream) connectCore(ctx context.Context) error {
+	log := s.log.WithField("connection", "target")
+
+	nc, err := util.ConnectNats(ctx, s.cfg.Stream, s.cfg.TargetServers(), s.cfg.TargetTLS, s.cfg.TargetChoriaConn, true, s.cfg.TargetProcess, log, s.connectOptions("target")...)
+	if err != nil {
+		return fmt.Errorf("target connection failed: %v", err)
+	}
+
+	log.Infof("Publishing messages to core NATS subjects on %s", nc.ConnectedUrlRedacted())
+
+	s.sink = &coreSink{nc: nc}
+
+	return nil
+}
+
+// publish publishes msg and waits for the server to process it, there is no way to know if any subscriber received it
+func (c *coreSink) publish(ctx context.Context, msg *nats.Msg) error {
+	// the reply of msg is used to acknowledge it in the source and must not be sent to subscribers
+	err := c.nc.PublishMsg(&nats.Msg{Subject: msg.Subject, Header: msg.Header, Data: msg.Data})
+	if err != nil {
+		return err
+	}
+
+	fctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	return c.nc.FlushWithContext(fctx)
+}
+
+func (c *coreSink) close() error {
+	return c.nc.Drain()
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Core NATS Sink", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	It("Should publish messages to core NATS subjects", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			stream, err := mgr.NewStream("ORDERS", jsm.Subjects("ORDERS.>"))
+			Expect(err).ToNot(HaveOccurred())
+
+			sub, err := nc.SubscribeSync("copy.ORDERS.>")
+			Expect(err).ToNot(HaveOccurred())
+
+			for i := 1; i <= 10; i++ {
+				_, err = nc.Request(fmt.Sprintf("ORDERS.%d", i), []byte(fmt.Sprintf("order %d", i)), time.Second)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			scfg := &config.Stream{Stream: "ORDERS", SourceURL: nc.ConnectedUrl(), TargetURL: nc.ConnectedUrl(), TargetPrefix: "copy", TargetCore: true}
+			cfg := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+			Expect(cfg.Validate()).To(Succeed())
+
+			s, err := NewStream(scfg, cfg, log)
+			Expect(err).ToNot(HaveOccurred())
+
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				Expect(s.Run(ctx, &wg)).ToNot(HaveOccurred())
+			}()
+
+			for i := 1; i <= 10; i++ {
+				msg, err := sub.NextMsg(5 * time.Second)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(msg.Subject).To(Equal(fmt.Sprintf("copy.ORDERS.%d", i)))
+				Expect(string(msg.Data)).To(Equal(fmt.Sprintf("order %d", i)))
+				Expect(msg.Header.Get(srcHeader)).To(HavePrefix(fmt.Sprintf("ORDERS %d GINKGO", i)))
+			}
+
+			Eventually(func() (uint64, error) {
+				nfo, err := stream.LoadConsumer(s.cname)
+				if err != nil {
+					return 0, err
+				}
+				state, err := nfo.State()
+				if err != nil {
+					return 0, err
+				}
+				return state.AckFloor.Stream, nil
+			}).Should(Equal(uint64(10)))
+
+			names, err := mgr.StreamNames(nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(names).To(Equal([]string{"ORDERS"}))
+		})
+	})
+})
@@ -0,0 +1,121 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// DeadLetterReasonHeader describes why a message was published to the dead letter subject
+	DeadLetterReasonHeader = "Choria-SR-Dead-Letter-Reason"
+	// DeadLetterSubjectHeader is the subject a dead lettered message had in the source
+	DeadLetterSubjectHeader = "Choria-SR-Dead-Letter-Subject"
+)
+
+var errDeadlineExceeded = errors.New("processing deadline exceeded")
+
+// processWithDeadline calls process bounded by the processing deadline of the stream. Process works on a copy of msg
+// using a context canceled at the deadline, once it passed process is abandoned while msg is retried or dead lettered.
+// Only one abandoned process is left running, later messages are processed once it returned so publishes and delta
+// and ordering state never race
+func (s *Stream) processWithDeadline(ctx context.Context, msg *nats.Msg, process func(ctx context.Context, msg *nats.Msg) (bool, error)) (bool, error) {
+	d := s.cfg.ProcessingDeadline
+	if d == nil {
+		return process(ctx, msg)
+	}
+
+	s.mu.Lock()
+	abandoned := s.abandoned
+	s.mu.Unlock()
+
+	if abandoned != nil {
+		select {
+		case <-abandoned:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+
+		s.mu.Lock()
+		s.abandoned = nil
+		s.mu.Unlock()
+	}
+
+	dctx, cancel := context.WithTimeout(ctx, d.Timeout)
+	defer cancel()
+
+	work := &nats.Msg{Subject: msg.Subject, Reply: msg.Reply, Data: msg.Data, Sub: msg.Sub, Header: make(nats.Header, len(msg.Header))}
+	for k, v := range msg.Header {
+		work.Header[k] = append([]string(nil), v...)
+	}
+
+	type result struct {
+		queued bool
+		err    error
+	}
+
+	done := make(chan result, 1)
+	returned := make(chan struct{})
+	go func() {
+		defer close(returned)
+		queued, err := process(dctx, work)
+		done <- result{queued, err}
+	}()
+
+	select {
+	case res := <-done:
+		// processing that noticed the deadline passing fails like processing that was abandoned
+		if res.err == nil || ctx.Err() != nil || !errors.Is(dctx.Err(), context.DeadlineExceeded) {
+			return res.queued, res.err
+		}
+
+	case <-dctx.Done():
+		s.mu.Lock()
+		s.abandoned = returned
+		s.mu.Unlock()
+
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+	}
+
+	deadlineExceededCount.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Inc()
+
+	return false, fmt.Errorf("%w after %v", errDeadlineExceeded, d.Timeout)
+}
+
+// deadLetter publishes msg to subject on the source, the message is expected to be stored by a stream
+func (s *Stream) deadLetter(ctx context.Context, subject string, msg *nats.Msg, reason error) error {
+	js, err := s.source.nc.JetStream()
+	if err != nil {
+		return err
+	}
+
+	dead := nats.NewMsg(subject)
+	dead.Data = msg.Data
+	for k, v := range msg.Header {
+		// headers like Nats-Msg-Id and Nats-Expected-Stream would apply to the dead letter stream
+		if strings.HasPrefix(k, "Nats-") {
+			continue
+		}
+		dead.Header[k] = append([]string(nil), v...)
+	}
+	dead.Header.Set(DeadLetterSubjectHeader, msg.Subject)
+	dead.Header.Set(DeadLetterReasonHeader, reason.Error())
+
+	_, err = js.PublishMsg(dead, nats.Context(ctx))
+	if err != nil {
+		return err
+	}
+
+	deadLetteredCount.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Inc()
+
+	return nil
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/choria-io/stream-replicator/replicator/replicatortest"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+// hangingPublisher does not return for messages on subject until released or, unless ignoring cancellation like some
+// targets do, its context is canceled
+type hangingPublisher struct {
+	*replicatortest.Publisher
+	subject      string
+	released     chan struct{}
+	ignoreCancel bool
+	canceled     atomic.Int32
+}
+
+func (p *hangingPublisher) Publish(ctx context.Context, msg *nats.Msg) error {
+	if msg.Subject == p.subject {
+		if p.ignoreCancel {
+			<-p.released
+		} else {
+			select {
+			case <-p.released:
+			case <-ctx.Done():
+				p.canceled.Add(1)
+				return ctx.Err()
+			}
+		}
+	}
+
+	return p.Publisher.Publish(ctx, msg)
+}
+
+var _ = Describe("Processing Deadline", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	run := func(nc *nats.Conn, deadline *config.ProcessingDeadline, pub Publisher) {
+		scfg := &config.Stream{Name: "DEADLINE", Stream: "ORDERS", SourceURL: nc.ConnectedUrl(), ProcessingDeadline: deadline}
+		sr := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+		Expect(sr.Validate()).To(Succeed())
+
+		stream, err := NewStream(scfg, sr, log, WithPublisher(pub))
+		Expect(err).ToNot(HaveOccurred())
+
+		wg.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+		}()
+	}
+
+	It("Should retry messages exceeding the deadline", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("ORDERS", jsm.Subjects("ORDERS.>"))
+			Expect(err).ToNot(HaveOccurred())
+
+			for i := 0; i < 3; i++ {
+				_, err = nc.Request(fmt.Sprintf("ORDERS.%d", i), []byte("order"), time.Second)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			pub := replicatortest.NewPublisher()
+			pub.SetLatency(time.Second)
+			before := getPromCountValue(deadlineExceededCount, "ORDERS", "GINKGO", "DEADLINE")
+
+			run(nc, &config.ProcessingDeadline{TimeoutString: "100ms"}, pub)
+
+			Eventually(func() float64 { return getPromCountValue(deadlineExceededCount, "ORDERS", "GINKGO", "DEADLINE") }, "5s").Should(BeNumerically(">", before))
+			Expect(pub.Count()).To(Equal(0))
+
+			pub.SetLatency(0)
+			Expect(pub.WaitForMessages(ctx, 3)).To(Succeed())
+			Expect(pub.Messages()[0].Subject).To(Equal("ORDERS.0"))
+		})
+	})
+
+	It("Should dead letter messages exceeding the deadline", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("ORDERS", jsm.Subjects("ORDERS.>"))
+			Expect(err).ToNot(HaveOccurred())
+			dlq, err := mgr.NewStream("DLQ", jsm.Subjects("dlq.orders"))
+			Expect(err).ToNot(HaveOccurred())
+
+			for i := 0; i < 3; i++ {
+				_, err = nc.Request(fmt.Sprintf("ORDERS.%d", i), []byte(fmt.Sprintf("order %d", i)), time.Second)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			pub := &hangingPublisher{Publisher: replicatortest.NewPublisher(), subject: "ORDERS.1", released: make(chan struct{})}
+			DeferCleanup(func() { close(pub.released) })
+
+			run(nc, &config.ProcessingDeadline{TimeoutString: "200ms", Action: config.DeadlineDeadLetter, DeadLetterSubject: "dlq.orders"}, pub)
+
+			Expect(pub.WaitForMessages(ctx, 2)).To(Succeed())
+			Expect(pub.Messages()[0].Subject).To(Equal("ORDERS.0"))
+			Expect(pub.Messages()[1].Subject).To(Equal("ORDERS.2"))
+			Expect(pub.canceled.Load()).To(Equal(int32(1)))
+
+			nfo, err := dlq.State()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(nfo.Msgs).To(Equal(uint64(1)))
+
+			msg, err := dlq.ReadMessage(1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(msg.Data)).To(Equal("order 1"))
+
+			hdrs, err := decodeHeadersMsg(msg.Header)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hdrs.Get(DeadLetterSubjectHeader)).To(Equal("ORDERS.1"))
+			Expect(hdrs.Get(DeadLetterReasonHeader)).To(Equal("processing deadline exceeded after 200ms"))
+			Expect(hdrs.Get(srcHeader)).To(HavePrefix("ORDERS 2 GINKGO"))
+			Expect(getPromCountValue(deadLetteredCount, "ORDERS", "GINKGO", "DEADLINE")).To(Equal(1.0))
+		})
+	})
+
+	It("Should not process messages while abandoned processing did not return", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("ORDERS", jsm.Subjects("ORDERS.>"))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = mgr.NewStream("DLQ", jsm.Subjects("dlq.orders"))
+			Expect(err).ToNot(HaveOccurred())
+
+			for i := 0; i < 3; i++ {
+				_, err = nc.Request(fmt.Sprintf("ORDERS.%d", i), []byte(fmt.Sprintf("order %d", i)), time.Second)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			pub := &hangingPublisher{Publisher: replicatortest.NewPublisher(), subject: "ORDERS.1", released: make(chan struct{}), ignoreCancel: true}
+			before := getPromCountValue(deadlineExceededCount, "ORDERS", "GINKGO", "DEADLINE")
+
+			run(nc, &config.ProcessingDeadline{TimeoutString: "100ms", Action: config.DeadlineDeadLetter, DeadLetterSubject: "dlq.orders"}, pub)
+
+			Eventually(func() float64 { return getPromCountValue(deadlineExceededCount, "ORDERS", "GINKGO", "DEADLINE") }, "5s").Should(BeNumerically(">", before))
+			Consistently(pub.Count, "500ms").Should(Equal(1))
+
+			// the late publish of the abandoned message completes before the next message is processed
+			close(pub.released)
+			Expect(pub.WaitForMessages(ctx, 3)).To(Succeed())
+			Expect(pub.Messages()[1].Subject).To(Equal("ORDERS.1"))
+			Expect(pub.Messages()[2].Subject).To(Equal("ORDERS.2"))
+		})
+	})
+})
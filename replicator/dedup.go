@@ -0,0 +1,56 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"encoding/json"
+
+	"github.com/nats-io/jsm.go/api"
+	"github.com/nats-io/nats.go"
+	"github.com/tidwall/gjson"
+)
+
+// dedupMessage sets the message id of msg to its deduplication key, the target stores messages with the same id only
+// once within its duplicate window no matter which stream or replicator copied them
+func (s *Stream) dedupMessage(msg *nats.Msg) {
+	if s.cfg.Dedup == nil {
+		return
+	}
+
+	var key string
+	switch {
+	case s.cfg.Dedup.Header != _EMPTY_:
+		if msg.Header != nil {
+			key = msg.Header.Get(s.cfg.Dedup.Header)
+		}
+	default:
+		key = gjson.GetBytes(msg.Data, s.cfg.Dedup.Field).String()
+	}
+
+	if key == _EMPTY_ {
+		dedupKeyMissingCount.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Inc()
+		s.log.Debugf("Copying message on %s without a deduplication key", msg.Subject)
+		return
+	}
+
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+	msg.Header.Set(api.JSMsgId, key)
+}
+
+// recordDuplicate counts messages the target did not store as it already held a message with the same key
+func (s *Stream) recordDuplicate(resp *nats.Msg) {
+	if s.cfg.Dedup == nil || resp == nil {
+		return
+	}
+
+	var ack api.PubAck
+	if json.Unmarshal(resp.Data, &ack) != nil || !ack.Duplicate {
+		return
+	}
+
+	dedupDuplicateCount.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Inc()
+}
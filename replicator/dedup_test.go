@@ -0,0 +1,143 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Deduplication", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	// publishes events with ids from to to on subject, the id is in the Event-Id header and the id field
+	publish := func(nc *nats.Conn, subject string, from int, to int) {
+		for i := from; i <= to; i++ {
+			msg := nats.NewMsg(subject)
+			msg.Header.Add("Event-Id", fmt.Sprintf("event-%d", i))
+			msg.Data = []byte(fmt.Sprintf(`{"id":"event-%d"}`, i))
+			_, err := nc.RequestMsg(msg, time.Second)
+			Expect(err).ToNot(HaveOccurred())
+		}
+	}
+
+	// for use with Eventually()
+	streamMessages := func(s *jsm.Stream) func() (uint64, error) {
+		return func() (uint64, error) {
+			nfo, err := s.State()
+			if err != nil {
+				return 0, err
+			}
+			return nfo.Msgs, nil
+		}
+	}
+
+	// copies the streams named into MERGED using dedup
+	run := func(nc *nats.Conn, dedup *config.Dedup, streams ...string) {
+		cfg := &config.Config{ReplicatorName: "GINKGO"}
+		for _, stream := range streams {
+			cfg.Streams = append(cfg.Streams, &config.Stream{
+				Name:           "DEDUP",
+				Stream:         stream,
+				TargetStream:   "MERGED",
+				TargetPrefix:   "merged",
+				NoTargetCreate: true,
+				SourceURL:      nc.ConnectedUrl(),
+				TargetURL:      nc.ConnectedUrl(),
+				Dedup:          &config.Dedup{Header: dedup.Header, Field: dedup.Field, WindowString: dedup.WindowString},
+			})
+		}
+		Expect(cfg.Validate()).To(Succeed())
+
+		for _, scfg := range cfg.Streams {
+			stream, err := NewStream(scfg, cfg, log)
+			Expect(err).ToNot(HaveOccurred())
+
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				Expect(stream.Run(ctx, &wg)).To(Succeed())
+			}()
+		}
+	}
+
+	It("Should store events arriving from several sources once", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("EAST", jsm.Subjects("east"))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = mgr.NewStream("WEST", jsm.Subjects("west"))
+			Expect(err).ToNot(HaveOccurred())
+			merged, err := mgr.NewStream("MERGED", jsm.Subjects("merged.>"), jsm.DuplicateWindow(time.Minute))
+			Expect(err).ToNot(HaveOccurred())
+
+			publish(nc, "east", 1, 6)
+			publish(nc, "west", 4, 10)
+
+			run(nc, &config.Dedup{Header: "Event-Id"}, "EAST", "WEST")
+
+			Eventually(func() float64 {
+				return getPromCountValue(copiedMessageCount, "EAST", "GINKGO", "DEDUP") + getPromCountValue(copiedMessageCount, "WEST", "GINKGO", "DEDUP")
+			}, 5*time.Second).Should(Equal(13.0))
+			Expect(streamMessages(merged)()).To(Equal(uint64(10)))
+			Expect(getPromCountValue(dedupDuplicateCount, "EAST", "GINKGO", "DEDUP") + getPromCountValue(dedupDuplicateCount, "WEST", "GINKGO", "DEDUP")).To(Equal(3.0))
+
+			msg, err := merged.ReadMessage(1)
+			Expect(err).ToNot(HaveOccurred())
+			hdrs, err := decodeHeadersMsg(msg.Header)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hdrs.Get("Nats-Msg-Id")).To(HavePrefix("event-"))
+		})
+	})
+
+	It("Should key on payload fields", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("NORTH", jsm.Subjects("north"))
+			Expect(err).ToNot(HaveOccurred())
+			merged, err := mgr.NewStream("MERGED", jsm.Subjects("merged.>"))
+			Expect(err).ToNot(HaveOccurred())
+
+			publish(nc, "north", 1, 3)
+			publish(nc, "north", 1, 3)
+			_, err = nc.Request("north", []byte(`{"other":1}`), time.Second)
+			Expect(err).ToNot(HaveOccurred())
+
+			run(nc, &config.Dedup{Field: "id"}, "NORTH")
+
+			Eventually(streamMessages(merged), 5*time.Second).Should(Equal(uint64(4)))
+			Eventually(func() float64 {
+				return getPromCountValue(dedupDuplicateCount, "NORTH", "GINKGO", "DEDUP")
+			}).Should(Equal(3.0))
+			Expect(getPromCountValue(dedupKeyMissingCount, "NORTH", "GINKGO", "DEDUP")).To(Equal(1.0))
+		})
+	})
+})
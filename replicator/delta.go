@@ -0,0 +1,267 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/clock"
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/nats-io/nats.go"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// DeltaHeader indicates if a message holds the full document, full, or a JSON merge patch, merge-patch
+	DeltaHeader = "Choria-SR-Delta"
+
+	deltaHeaderFull  = "full"
+	deltaHeaderPatch = "merge-patch"
+)
+
+// deltaEncoder remembers the last document copied per identity so unchanged documents are skipped and changed ones
+// can be copied as JSON merge patches
+type deltaEncoder struct {
+	cfg     *config.Delta
+	field   string
+	header  string
+	token   int
+	clock   clock.Clock
+	docs    map[string]*deltaDoc
+	expired time.Time
+	mu      sync.Mutex
+}
+
+// deltaDoc is the last document copied for an identity
+type deltaDoc struct {
+	data []byte
+	full time.Time
+}
+
+func newDeltaEncoder(scfg *config.Stream, clk clock.Clock) *deltaEncoder {
+	return &deltaEncoder{
+		cfg:     scfg.Delta,
+		field:   scfg.InspectJSONField,
+		header:  scfg.InspectHeaderValue,
+		token:   scfg.InspectSubjectToken,
+		clock:   clk,
+		docs:    make(map[string]*deltaDoc),
+		expired: clk.Now(),
+	}
+}
+
+// identity is the identity msg belongs to using the inspection settings of the stream
+func (d *deltaEncoder) identity(msg *nats.Msg) string {
+	switch {
+	case d.field != _EMPTY_:
+		res := gjson.GetBytes(msg.Data, d.field)
+		if res.Exists() {
+			return res.String()
+		}
+
+	case d.token == -1:
+		return msg.Subject
+
+	case d.token > 0:
+		parts := strings.Split(msg.Subject, ".")
+		if len(parts) >= d.token {
+			return parts[d.token-1]
+		}
+
+	case d.header != _EMPTY_ && msg.Header != nil:
+		return msg.Header.Get(d.header)
+	}
+
+	return _EMPTY_
+}
+
+// encode prepares msg to be copied, send is false when it repeats the last document copied for its identity. Once
+// msg was copied commit has to be called so later documents are compared to it.
+func (d *deltaEncoder) encode(msg *nats.Msg) (send bool, commit func()) {
+	id := d.identity(msg)
+	if id == _EMPTY_ {
+		return true, func() {}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.clock.Now()
+	d.expireLocked(now)
+
+	doc := msg.Data
+	last, ok := d.docs[id]
+	if ok && now.Sub(last.full) >= d.cfg.FullInterval {
+		ok = false
+	}
+	if ok && bytes.Equal(last.data, doc) {
+		return false, func() {}
+	}
+
+	full := now
+	var patch []byte
+	if ok && d.cfg.Mode == config.DeltaMergePatch {
+		patch = mergePatch(last.data, doc)
+		if bytes.Equal(patch, []byte("{}")) {
+			// the same document encoded differently
+			return false, func() {}
+		}
+		if patch != nil && len(patch) < len(doc) {
+			full = last.full
+		} else {
+			patch = nil
+		}
+	}
+
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+
+	switch {
+	case patch != nil:
+		msg.Header.Set(DeltaHeader, deltaHeaderPatch)
+		msg.Data = patch
+	case d.cfg.Mode == config.DeltaMergePatch:
+		msg.Header.Set(DeltaHeader, deltaHeaderFull)
+	}
+
+	return true, func() {
+		d.mu.Lock()
+		d.docs[id] = &deltaDoc{data: doc, full: full}
+		d.mu.Unlock()
+	}
+}
+
+// deltaMessage prepares msg to be copied when delta copies are enabled, send is false when msg does not need to be
+// copied and commit has to be called once msg was copied
+func (s *Stream) deltaMessage(msg *nats.Msg) (send bool, commit func()) {
+	if s.delta == nil {
+		return true, func() {}
+	}
+
+	size := len(msg.Data)
+	send, commit = s.delta.encode(msg)
+
+	switch {
+	case !send:
+		deltaSkippedCount.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Inc()
+		deltaSavedBytes.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Add(float64(size))
+	case msg.Header.Get(DeltaHeader) == deltaHeaderPatch:
+		deltaPatchedCount.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Inc()
+		deltaSavedBytes.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Add(float64(size - len(msg.Data)))
+	}
+
+	return send, commit
+}
+
+// expireLocked forgets identities that did not copy a full document within the full interval, their next document is
+// copied in full anyway, must be called with d.mu held
+func (d *deltaEncoder) expireLocked(now time.Time) {
+	if now.Sub(d.expired) < d.cfg.FullInterval {
+		return
+	}
+
+	for id, doc := range d.docs {
+		if now.Sub(doc.full) >= d.cfg.FullInterval {
+			delete(d.docs, id)
+		}
+	}
+
+	d.expired = now
+}
+
+// mergePatch creates a RFC 7386 JSON merge patch turning the JSON object from into to, nil when either is not an
+// object or the patch can not express the change
+func mergePatch(from []byte, to []byte) []byte {
+	var fdoc, tdoc map[string]any
+
+	err := decodeJSON(from, &fdoc)
+	if err != nil || fdoc == nil {
+		return nil
+	}
+	err = decodeJSON(to, &tdoc)
+	if err != nil || tdoc == nil {
+		return nil
+	}
+
+	patch, ok := objectPatch(fdoc, tdoc)
+	if !ok {
+		return nil
+	}
+
+	res, err := json.Marshal(patch)
+	if err != nil {
+		return nil
+	}
+
+	return res
+}
+
+// objectPatch is the merge patch from from to to, ok is false when to holds null values that a patch would remove
+func objectPatch(from map[string]any, to map[string]any) (patch map[string]any, ok bool) {
+	patch = make(map[string]any)
+
+	for k, v := range to {
+		if v == nil {
+			return nil, false
+		}
+
+		old, has := from[k]
+		oldObj, oldIsObj := old.(map[string]any)
+		newObj, newIsObj := v.(map[string]any)
+
+		switch {
+		case has && oldIsObj && newIsObj:
+			sub, ok := objectPatch(oldObj, newObj)
+			if !ok {
+				return nil, false
+			}
+			if len(sub) > 0 {
+				patch[k] = sub
+			}
+
+		case !has || !reflect.DeepEqual(old, v):
+			if containsNull(v) {
+				return nil, false
+			}
+			patch[k] = v
+		}
+	}
+
+	for k := range from {
+		_, has := to[k]
+		if !has {
+			patch[k] = nil
+		}
+	}
+
+	return patch, true
+}
+
+// containsNull determines if null values are held in objects within v, patches would remove those keys
+func containsNull(v any) bool {
+	switch val := v.(type) {
+	case map[string]any:
+		for _, i := range val {
+			if i == nil || containsNull(i) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func decodeJSON(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	return dec.Decode(v)
+}
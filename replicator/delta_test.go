@@ -0,0 +1,123 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"time"
+
+	"github.com/choria-io/stream-replicator/clock"
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Delta", func() {
+	var clk *clock.Mock
+
+	BeforeEach(func() {
+		clk = clock.NewMock(time.Now())
+	})
+
+	encoder := func(mode string) *deltaEncoder {
+		return newDeltaEncoder(&config.Stream{InspectJSONField: "sender", Delta: &config.Delta{Mode: mode, FullInterval: time.Hour}}, clk)
+	}
+
+	// copies msg returning the payload and delta header when it was sent
+	copyMsg := func(d *deltaEncoder, data string) (bool, string, string) {
+		msg := nats.NewMsg("state")
+		msg.Data = []byte(data)
+
+		send, commit := d.encode(msg)
+		if send {
+			commit()
+		}
+
+		return send, string(msg.Data), msg.Header.Get(DeltaHeader)
+	}
+
+	It("Should skip documents that did not change", func() {
+		d := encoder(config.DeltaFull)
+
+		send, _, _ := copyMsg(d, `{"sender":"n1","load":1}`)
+		Expect(send).To(BeTrue())
+		send, _, _ = copyMsg(d, `{"sender":"n1","load":1}`)
+		Expect(send).To(BeFalse())
+
+		send, data, header := copyMsg(d, `{"sender":"n1","load":2}`)
+		Expect(send).To(BeTrue())
+		Expect(data).To(Equal(`{"sender":"n1","load":2}`))
+		Expect(header).To(BeEmpty())
+
+		send, _, _ = copyMsg(d, `{"sender":"n2","load":2}`)
+		Expect(send).To(BeTrue())
+
+		clk.Add(time.Hour)
+		send, _, _ = copyMsg(d, `{"sender":"n1","load":2}`)
+		Expect(send).To(BeTrue())
+	})
+
+	It("Should only remember copied documents", func() {
+		d := encoder(config.DeltaFull)
+
+		msg := nats.NewMsg("state")
+		msg.Data = []byte(`{"sender":"n1"}`)
+		send, _ := d.encode(msg)
+		Expect(send).To(BeTrue())
+
+		send, _, _ = copyMsg(d, `{"sender":"n1"}`)
+		Expect(send).To(BeTrue())
+	})
+
+	It("Should copy merge patches of changed documents", func() {
+		d := encoder(config.DeltaMergePatch)
+		doc := `{"sender":"n1","facts":{"os":"linux","kernel":"6.1","uptime":1},"tags":["a","b"],"description":"a long description of the node"}`
+
+		send, data, header := copyMsg(d, doc)
+		Expect(send).To(BeTrue())
+		Expect(data).To(Equal(doc))
+		Expect(header).To(Equal("full"))
+
+		send, data, header = copyMsg(d, `{"sender":"n1","facts":{"os":"linux","uptime":2},"tags":["a","b"],"description":"a long description of the node","new":true}`)
+		Expect(send).To(BeTrue())
+		Expect(header).To(Equal("merge-patch"))
+		Expect(data).To(MatchJSON(`{"facts":{"kernel":null,"uptime":2},"new":true}`))
+
+		// the same document with keys in another order
+		send, _, _ = copyMsg(d, `{"new":true,"sender":"n1","tags":["a","b"],"facts":{"uptime":2,"os":"linux"},"description":"a long description of the node"}`)
+		Expect(send).To(BeFalse())
+
+		// null values can not be expressed in patches
+		send, data, header = copyMsg(d, `{"sender":"n1","facts":{"os":"linux","uptime":3},"tags":["a","b"],"description":"a long description of the node","new":null}`)
+		Expect(send).To(BeTrue())
+		Expect(header).To(Equal("full"))
+		Expect(data).To(ContainSubstring(`"new":null`))
+
+		clk.Add(time.Hour)
+		send, _, header = copyMsg(d, `{"sender":"n1","facts":{"os":"linux","uptime":4},"tags":["a","b"],"description":"a long description of the node"}`)
+		Expect(send).To(BeTrue())
+		Expect(header).To(Equal("full"))
+	})
+
+	It("Should copy documents without identity", func() {
+		d := encoder(config.DeltaMergePatch)
+
+		for i := 0; i < 2; i++ {
+			send, data, header := copyMsg(d, `{"load":1}`)
+			Expect(send).To(BeTrue())
+			Expect(data).To(Equal(`{"load":1}`))
+			Expect(header).To(BeEmpty())
+		}
+	})
+
+	It("Should create merge patches", func() {
+		Expect(mergePatch([]byte(`{"a":1}`), []byte(`[1]`))).To(BeNil())
+		Expect(mergePatch([]byte(`"a"`), []byte(`{"a":1}`))).To(BeNil())
+		Expect(mergePatch([]byte(`{"a":{"b":1}}`), []byte(`{"a":1}`))).To(MatchJSON(`{"a":1}`))
+		Expect(mergePatch([]byte(`{"a":1}`), []byte(`{"a":{"b":null}}`))).To(BeNil())
+		Expect(mergePatch([]byte(`{"a":[1,{"b":1}]}`), []byte(`{"a":[1,{"b":null}]}`))).To(MatchJSON(`{"a":[1,{"b":null}]}`))
+		Expect(mergePatch([]byte(`{"a":12345678901234567890}`), []byte(`{"a":12345678901234567891}`))).To(MatchJSON(`{"a":12345678901234567891}`))
+	})
+})
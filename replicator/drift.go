@@ -0,0 +1,180 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/choria-io/stream-replicator/events"
+	"github.com/nats-io/jsm.go/api"
+)
+
+// streamSetting is a stream setting compared between the source and target, set copies it from one configuration to
+// another when it can be corrected
+type streamSetting struct {
+	name  string
+	value func(cfg api.StreamConfig) any
+	set   func(target *api.StreamConfig, expected api.StreamConfig)
+}
+
+// streamSettings are the settings compared to detect drift, placement, storage and replicas may differ between sites
+var streamSettings = []streamSetting{
+	{"subjects", func(c api.StreamConfig) any {
+		subjects := append([]string{}, c.Subjects...)
+		sort.Strings(subjects)
+		return strings.Join(subjects, ",")
+	}, func(t *api.StreamConfig, e api.StreamConfig) { t.Subjects = e.Subjects }},
+	{"retention", func(c api.StreamConfig) any { return c.Retention }, nil},
+	{"max_msgs", func(c api.StreamConfig) any { return c.MaxMsgs }, func(t *api.StreamConfig, e api.StreamConfig) { t.MaxMsgs = e.MaxMsgs }},
+	{"max_bytes", func(c api.StreamConfig) any { return c.MaxBytes }, func(t *api.StreamConfig, e api.StreamConfig) { t.MaxBytes = e.MaxBytes }},
+	{"max_age", func(c api.StreamConfig) any { return c.MaxAge }, func(t *api.StreamConfig, e api.StreamConfig) { t.MaxAge = e.MaxAge }},
+	{"max_msgs_per_subject", func(c api.StreamConfig) any { return c.MaxMsgsPer }, func(t *api.StreamConfig, e api.StreamConfig) { t.MaxMsgsPer = e.MaxMsgsPer }},
+	{"max_msg_size", func(c api.StreamConfig) any { return c.MaxMsgSize }, func(t *api.StreamConfig, e api.StreamConfig) { t.MaxMsgSize = e.MaxMsgSize }},
+	{"discard", func(c api.StreamConfig) any { return c.Discard }, func(t *api.StreamConfig, e api.StreamConfig) { t.Discard = e.Discard }},
+	{"duplicate_window", func(c api.StreamConfig) any { return c.Duplicates }, func(t *api.StreamConfig, e api.StreamConfig) { t.Duplicates = e.Duplicates }},
+	{"allow_rollup_hdrs", func(c api.StreamConfig) any { return c.RollupAllowed }, func(t *api.StreamConfig, e api.StreamConfig) { t.RollupAllowed = e.RollupAllowed }},
+	{"deny_delete", func(c api.StreamConfig) any { return c.DenyDelete }, nil},
+	{"deny_purge", func(c api.StreamConfig) any { return c.DenyPurge }, nil},
+}
+
+// driftedSettings are the settings of target that differ from expected
+func driftedSettings(expected api.StreamConfig, target api.StreamConfig) []streamSetting {
+	var drifted []streamSetting
+
+	for _, setting := range streamSettings {
+		if fmt.Sprint(setting.value(expected)) != fmt.Sprint(setting.value(target)) {
+			drifted = append(drifted, setting)
+		}
+	}
+
+	return drifted
+}
+
+// configDrift describes the settings of target that differ from expected, ignoring placement, storage and replicas
+func configDrift(expected api.StreamConfig, target api.StreamConfig) []string {
+	var drift []string
+
+	for _, setting := range driftedSettings(expected, target) {
+		drift = append(drift, fmt.Sprintf("%s: expected %v found %v", setting.name, setting.value(expected), setting.value(target)))
+	}
+
+	return drift
+}
+
+// watchDrift compares the source and target stream configurations on the configured interval while leading until
+// ctx is done
+func (s *Stream) watchDrift(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := s.clock.NewTicker(s.cfg.Drift.Interval)
+	defer ticker.Stop()
+
+	var last []string
+
+	for {
+		select {
+		case <-ticker.C():
+			if !s.Leading() {
+				continue
+			}
+
+			drift, err := s.checkDrift()
+			if err != nil {
+				s.log.Errorf("Could not check the configuration of target stream %s for drift: %v", s.cfg.TargetStream, err)
+				continue
+			}
+
+			s.reportDrift(last, drift)
+			last = drift
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// checkDrift compares the target stream configuration with what the source stream would create, correcting the
+// settings allowed to be corrected, and describes the remaining differences
+func (s *Stream) checkDrift() ([]string, error) {
+	s.source.mu.Lock()
+	source := s.source.stream
+	s.source.mu.Unlock()
+
+	nfo, err := source.Information()
+	if err != nil {
+		return nil, fmt.Errorf("could not load source stream: %v", err)
+	}
+
+	s.dest.mu.Lock()
+	mgr := s.dest.mgr
+	s.dest.mu.Unlock()
+
+	target, err := mgr.LoadStream(s.cfg.TargetStream)
+	if err != nil {
+		return nil, fmt.Errorf("could not load target stream: %v", err)
+	}
+
+	expected := s.withTargetSettings(s.targetStreamConfig(nfo.Config))
+	tcfg := target.Configuration()
+
+	var corrected []string
+	for _, setting := range driftedSettings(expected, tcfg) {
+		if setting.set == nil || !containsString(s.cfg.Drift.Correct, setting.name) {
+			continue
+		}
+
+		corrected = append(corrected, fmt.Sprintf("%s: %v to %v", setting.name, setting.value(tcfg), setting.value(expected)))
+		setting.set(&tcfg, expected)
+	}
+
+	if len(corrected) > 0 {
+		err = target.UpdateConfiguration(tcfg)
+		if err != nil {
+			return nil, fmt.Errorf("could not correct %s: %v", strings.Join(corrected, ", "), err)
+		}
+
+		driftCorrectedCount.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Add(float64(len(corrected)))
+		msg := fmt.Sprintf("Corrected the configuration of %s to match %s: %s", s.cfg.TargetStream, s.cfg.Stream, strings.Join(corrected, ", "))
+		s.log.Warn(msg)
+		s.events.Publish(&events.Event{
+			Event:   events.DriftCorrectedEvent,
+			Stream:  s.cfg.Stream,
+			Name:    s.cfg.Name,
+			Message: msg,
+			Data:    map[string]string{"corrected": strings.Join(corrected, ", ")},
+		})
+	}
+
+	return configDrift(expected, target.Configuration()), nil
+}
+
+// reportDrift updates the drift metric and publishes an event when the differences changed from last to drift
+func (s *Stream) reportDrift(last []string, drift []string) {
+	configDriftSettings.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Set(float64(len(drift)))
+
+	if strings.Join(drift, "\n") == strings.Join(last, "\n") {
+		return
+	}
+
+	msg := fmt.Sprintf("Configuration of %s matches %s", s.cfg.TargetStream, s.cfg.Stream)
+	if len(drift) > 0 {
+		msg = fmt.Sprintf("Configuration of %s differs from %s: %s", s.cfg.TargetStream, s.cfg.Stream, strings.Join(drift, ", "))
+		s.log.Warn(msg)
+	} else if last != nil {
+		s.log.Info(msg)
+	}
+
+	s.events.Publish(&events.Event{
+		Event:   events.ConfigDriftEvent,
+		Stream:  s.cfg.Stream,
+		Name:    s.cfg.Name,
+		Message: msg,
+		Data:    map[string]string{"drift": strings.Join(drift, ", ")},
+	})
+}
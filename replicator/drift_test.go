@@ -0,0 +1,116 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/events"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/jsm.go/api"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Drift", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	It("Should describe differing settings", func() {
+		expected := api.StreamConfig{Subjects: []string{"b", "a"}, MaxAge: time.Hour, MaxMsgs: 10}
+		target := api.StreamConfig{Subjects: []string{"a", "b"}, MaxAge: time.Minute, MaxMsgs: 10, DenyPurge: true}
+
+		Expect(configDrift(expected, target)).To(Equal([]string{
+			"max_age: expected 1h0m0s found 1m0s",
+			"deny_purge: expected false found true",
+		}))
+	})
+
+	It("Should report drift and correct allowed settings", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			source, err := mgr.NewStream("TEST", jsm.Subjects("TEST"), jsm.MaxAge(time.Hour))
+			Expect(err).ToNot(HaveOccurred())
+
+			received := make(chan *nats.Msg, 10)
+			_, err = nc.ChanSubscribe("events.>", received)
+			Expect(err).ToNot(HaveOccurred())
+
+			p := startEvents(ctx, &wg, nc, log)
+
+			scfg := &config.Stream{
+				Stream:       "TEST",
+				TargetStream: "COPY",
+				TargetPrefix: "copy",
+				SourceURL:    nc.ConnectedUrl(),
+				TargetURL:    nc.ConnectedUrl(),
+				Drift:        &config.Drift{Correct: []string{"max_age"}},
+			}
+			sr := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+			Expect(sr.Validate()).To(Succeed())
+			scfg.Drift.Interval = 100 * time.Millisecond
+
+			stream, err := NewStream(scfg, sr, log, WithEvents(p))
+			Expect(err).ToNot(HaveOccurred())
+
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				Expect(stream.Run(ctx, &wg)).To(Succeed())
+			}()
+
+			var target *jsm.Stream
+			Eventually(func() error {
+				target, err = mgr.LoadStream("COPY")
+				return err
+			}, 5*time.Second).Should(Succeed())
+
+			Expect(source.UpdateConfiguration(source.Configuration(), jsm.MaxAge(2*time.Hour), jsm.MaxMessages(100))).To(Succeed())
+
+			event := nextEvent(received)
+			Expect(event.Event).To(Equal(events.DriftCorrectedEvent))
+			Expect(event.Data["corrected"]).To(Equal("max_age: 1h0m0s to 2h0m0s"))
+
+			event = nextEvent(received)
+			Expect(event.Event).To(Equal(events.ConfigDriftEvent))
+			Expect(event.Data["drift"]).To(Equal("max_msgs: expected 100 found -1"))
+			Expect(getPromGaugeValue(configDriftSettings, "TEST", "GINKGO", "GINKGO")).To(Equal(1.0))
+			Expect(getPromCountValue(driftCorrectedCount, "TEST", "GINKGO", "GINKGO")).To(Equal(1.0))
+
+			Expect(target.Reset()).To(Succeed())
+			Expect(target.MaxAge()).To(Equal(2 * time.Hour))
+			Expect(target.MaxMsgs()).To(Equal(int64(-1)))
+
+			Expect(target.UpdateConfiguration(target.Configuration(), jsm.MaxMessages(100))).To(Succeed())
+
+			event = nextEvent(received)
+			Expect(event.Event).To(Equal(events.ConfigDriftEvent))
+			Expect(event.Message).To(Equal("Configuration of COPY matches TEST"))
+			Expect(event.Data["drift"]).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,227 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/util"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+// elasticsearchSink bulk indexes JSON messages into Elasticsearch or OpenSearch
+type elasticsearchSink struct {
+	cfg    *config.Elasticsearch
+	client *http.Client
+	stream string
+	node   uint32
+	log    *logrus.Entry
+}
+
+type bulkAction struct {
+	Index    string `json:"_index"`
+	ID       string `json:"_id"`
+	Pipeline string `json:"pipeline,omitempty"`
+}
+
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		ID     string `json:"_id"`
+		Status int    `json:"status"`
+		Error  *struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+func (s *Stream) connectElasticsearch() error {
+	ecfg := s.cfg.TargetElasticsearch
+
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.MaxIdleConnsPerHost = ecfg.Concurrency
+
+	var err error
+	if s.cfg.TargetProxy != _EMPTY_ {
+		tr.Proxy = nil
+		tr.DialContext, err = util.ProxyDialer(s.cfg.TargetProxy, 30*time.Second)
+		if err != nil {
+			return fmt.Errorf("elasticsearch target setup failed: %v", err)
+		}
+	}
+
+	if ecfg.TLS != nil {
+		tr.TLSClientConfig, err = util.ClientTLSConfig(ecfg.TLS.CA, ecfg.TLS.Cert, ecfg.TLS.Key)
+		if err != nil {
+			return fmt.Errorf("elasticsearch target tls: %v", err)
+		}
+	}
+
+	log := s.log.WithField("connection", "elasticsearch")
+	log.Infof("Indexing messages into %s using %s", ecfg.Index, strings.Join(ecfg.URLs, ", "))
+
+	s.batchSink = &elasticsearchSink{
+		cfg:    ecfg,
+		client: &http.Client{Transport: tr, Timeout: ecfg.Timeout},
+		stream: s.cfg.Stream,
+		log:    log,
+	}
+	s.delivery = &ecfg.Delivery
+
+	return nil
+}
+
+// deliver indexes msgs using the bulk api, documents rejected due to load are retried while other rejected
+// documents are logged and discarded as retrying them will not succeed
+func (e *elasticsearchSink) deliver(ctx context.Context, msgs []*nats.Msg) error {
+	body, count := e.body(msgs)
+	if count == 0 {
+		return nil
+	}
+
+	resp, err := e.bulk(ctx, body)
+	if err != nil {
+		return err
+	}
+
+	retry := 0
+	var reason string
+	for _, item := range resp.Items {
+		for _, res := range item {
+			if res.Status < 300 {
+				continue
+			}
+
+			msg := fmt.Sprintf("status %d", res.Status)
+			if res.Error != nil {
+				msg = fmt.Sprintf("%s: %s", res.Error.Type, res.Error.Reason)
+			}
+
+			if res.Status == http.StatusTooManyRequests || res.Status >= 500 {
+				retry++
+				reason = msg
+				continue
+			}
+
+			e.log.Errorf("Discarding document %s rejected by the cluster: %s", res.ID, msg)
+		}
+	}
+
+	if retry > 0 {
+		return fmt.Errorf("%d of %d document(s) failed: %s", retry, count, reason)
+	}
+
+	return nil
+}
+
+// body is the bulk request indexing msgs and the number of documents in it, messages that do not hold JSON are skipped
+func (e *elasticsearchSink) body(msgs []*nats.Msg) ([]byte, int) {
+	var body bytes.Buffer
+	var count int
+
+	for _, msg := range msgs {
+		meta, err := jsm.ParseJSMsgMetadata(msg)
+		if err != nil {
+			e.log.Errorf("Discarding message on %s without metadata: %v", msg.Subject, err)
+			continue
+		}
+
+		// ids based on the source position make retried batches overwrite previously indexed documents
+		id := e.stream + "-" + strconv.FormatUint(meta.StreamSequence(), 10)
+
+		var doc bytes.Buffer
+		err = json.Compact(&doc, msg.Data)
+		if err != nil {
+			e.log.Errorf("Discarding message %d on %s that does not hold JSON: %v", meta.StreamSequence(), msg.Subject, err)
+			continue
+		}
+
+		action, err := json.Marshal(map[string]bulkAction{"index": {
+			Index:    e.cfg.IndexName(e.stream, msg.Subject, meta.TimeStamp()),
+			ID:       id,
+			Pipeline: e.cfg.Pipeline,
+		}})
+		if err != nil {
+			e.log.Errorf("Discarding message %d on %s: %v", meta.StreamSequence(), msg.Subject, err)
+			continue
+		}
+
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(doc.Bytes())
+		body.WriteByte('\n')
+		count++
+	}
+
+	return body.Bytes(), count
+}
+
+// bulk posts body to the current node, moving on to the next node when the request fails
+func (e *elasticsearchSink) bulk(ctx context.Context, body []byte) (*bulkResponse, error) {
+	node := atomic.LoadUint32(&e.node)
+	url := strings.TrimSuffix(e.cfg.URLs[int(node)%len(e.cfg.URLs)], "/") + "/_bulk"
+
+	res, err := e.post(ctx, url, body)
+	if err != nil {
+		atomic.CompareAndSwapUint32(&e.node, node, node+1)
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (e *elasticsearchSink) post(ctx context.Context, url string, body []byte) (*bulkResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	switch {
+	case e.cfg.Username != _EMPTY_:
+		req.SetBasicAuth(e.cfg.Username, e.cfg.Password)
+	case e.cfg.APIKey != _EMPTY_:
+		req.Header.Set("Authorization", "ApiKey "+e.cfg.APIKey)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("unexpected response %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	res := &bulkResponse{}
+	err = json.NewDecoder(resp.Body).Decode(res)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bulk response: %v", err)
+	}
+
+	return res, nil
+}
+
+func (e *elasticsearchSink) close() error {
+	e.client.CloseIdleConnections()
+
+	return nil
+}
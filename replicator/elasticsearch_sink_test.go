@@ -0,0 +1,196 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Elasticsearch Sink", func() {
+	var (
+		ctx       context.Context
+		cancel    context.CancelFunc
+		wg        = sync.WaitGroup{}
+		log       *logrus.Entry
+		mu        sync.Mutex
+		docs      map[string]map[string]any
+		indexes   map[string]string
+		auth      string
+		throttled int
+		srv       *httptest.Server
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		docs = map[string]map[string]any{}
+		indexes = map[string]string{}
+		throttled = 0
+
+		srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+
+			Expect(r.URL.Path).To(Equal("/_bulk"))
+			Expect(r.Header.Get("Content-Type")).To(Equal("application/x-ndjson"))
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			auth = r.Header.Get("Authorization")
+
+			var items []map[string]any
+			scanner := bufio.NewScanner(r.Body)
+			for scanner.Scan() {
+				action := map[string]map[string]string{}
+				Expect(json.Unmarshal(scanner.Bytes(), &action)).To(Succeed())
+				Expect(scanner.Scan()).To(BeTrue())
+
+				meta := action["index"]
+				doc := map[string]any{}
+				Expect(json.Unmarshal(scanner.Bytes(), &doc)).To(Succeed())
+
+				switch {
+				case throttled > 0:
+					throttled--
+					items = append(items, map[string]any{"index": map[string]any{"_id": meta["_id"], "status": 429, "error": map[string]string{"type": "es_rejected_execution_exception", "reason": "rejected"}}})
+				case doc["reject"] == true:
+					items = append(items, map[string]any{"index": map[string]any{"_id": meta["_id"], "status": 400, "error": map[string]string{"type": "mapper_parsing_exception", "reason": "failed to parse"}}})
+				default:
+					docs[meta["_id"]] = doc
+					indexes[meta["_id"]] = meta["_index"]
+					items = append(items, map[string]any{"index": map[string]any{"_id": meta["_id"], "status": 201}})
+				}
+			}
+
+			Expect(json.NewEncoder(w).Encode(map[string]any{"errors": true, "items": items})).To(Succeed())
+		}))
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+			srv.Close()
+		})
+	})
+
+	run := func(nc *nats.Conn, ecfg *config.Elasticsearch) *Stream {
+		scfg := &config.Stream{Stream: "ORDERS", SourceURL: nc.ConnectedUrl(), TargetElasticsearch: ecfg}
+		cfg := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+		Expect(cfg.Validate()).To(Succeed())
+
+		stream, err := NewStream(scfg, cfg, log)
+		Expect(err).ToNot(HaveOccurred())
+
+		wg.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+		}()
+
+		return stream
+	}
+
+	publish := func(nc *nats.Conn, mgr *jsm.Manager, bodies ...string) {
+		_, err := mgr.NewStream("ORDERS", jsm.Subjects("ORDERS.>"))
+		Expect(err).ToNot(HaveOccurred())
+
+		for i, body := range bodies {
+			_, err := nc.Request(fmt.Sprintf("ORDERS.EU.%d", i+1), []byte(body), time.Second)
+			Expect(err).ToNot(HaveOccurred())
+		}
+	}
+
+	ackFloor := func(mgr *jsm.Manager, stream *Stream) func() (uint64, error) {
+		return func() (uint64, error) {
+			consumer, err := mgr.LoadConsumer("ORDERS", stream.cname)
+			if err != nil {
+				return 0, err
+			}
+			nfo, err := consumer.State()
+			if err != nil {
+				return 0, err
+			}
+			return nfo.AckFloor.Stream, nil
+		}
+	}
+
+	indexed := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(docs)
+	}
+
+	It("Should index JSON documents and discard rejected ones", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			publish(nc, mgr, `{"order": 1}`, "not json", `{"order": 3,
+"lines": 2}`, `{"order": 4, "reject": true}`, `{"order": 5}`)
+
+			stream := run(nc, &config.Elasticsearch{
+				URLs:     []string{srv.URL},
+				Index:    "{stream}-{subject:2}-{time:2006.01}",
+				APIKey:   "a2V5",
+				Delivery: config.Delivery{Batch: 5, FlushIntervalString: "200ms"},
+			})
+			Eventually(ackFloor(mgr, stream), "10s").Should(Equal(uint64(5)))
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			Expect(auth).To(Equal("ApiKey a2V5"))
+			Expect(docs).To(HaveLen(3))
+			Expect(docs).To(HaveKey("ORDERS-1"))
+			Expect(docs["ORDERS-3"]).To(Equal(map[string]any{"order": float64(3), "lines": float64(2)}))
+			Expect(docs).To(HaveKey("ORDERS-5"))
+			Expect(indexes["ORDERS-1"]).To(Equal(fmt.Sprintf("orders-eu-%s", time.Now().UTC().Format("2006.01"))))
+		})
+	})
+
+	It("Should retry throttled documents on the next node", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			mu.Lock()
+			throttled = 1
+			mu.Unlock()
+
+			publish(nc, mgr, `{"order": 1}`, `{"order": 2}`)
+
+			dead := httptest.NewServer(http.NotFoundHandler())
+			dead.Close()
+
+			stream := run(nc, &config.Elasticsearch{
+				URLs:     []string{dead.URL, srv.URL},
+				Index:    "orders",
+				Username: "bob",
+				Password: "secret",
+				Delivery: config.Delivery{Batch: 2, FlushIntervalString: "200ms"},
+			})
+			Eventually(indexed, "10s").Should(Equal(2))
+			Eventually(ackFloor(mgr, stream), "10s").Should(Equal(uint64(2)))
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			Expect(auth).To(HavePrefix("Basic "))
+			Expect(indexes).To(Equal(map[string]string{"ORDERS-1": "orders", "ORDERS-2": "orders"}))
+		})
+	})
+})
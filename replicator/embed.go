@@ -0,0 +1,364 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/audit"
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/events"
+	"github.com/choria-io/stream-replicator/heartbeat"
+	"github.com/choria-io/stream-replicator/metrics"
+	"github.com/choria-io/stream-replicator/topology"
+	"github.com/sirupsen/logrus"
+)
+
+// Replicator runs every stream, profile, event publisher, heartbeat, audit log, metrics forwarder and topology
+// registry of a configuration, it allows the replicator to be embedded in other programs:
+//
+//	cfg, err := config.Load("replicator.yaml")
+//	if err != nil {
+//		return err
+//	}
+//
+//	r, err := replicator.New(cfg, replicator.WithLogger(log))
+//	if err != nil {
+//		return err
+//	}
+//
+//	return r.Run(ctx)
+//
+// Process level concerns of the replicate command, like signal handling, Prometheus listeners, the admin API and
+// systemd notifications, are left to the embedding program
+type Replicator struct {
+	cfg        *config.Config
+	log        *logrus.Entry
+	streamOpts []Option
+	version    string
+	scheduler  *Scheduler
+	profiles   []*embeddedProfile
+	ran        bool
+	mu         sync.Mutex
+}
+
+// ReplicatorOption configures optional behavior of a Replicator
+type ReplicatorOption func(r *Replicator)
+
+// WithLogger logs using log, by default logs are written to stderr at the configured loglevel
+func WithLogger(log *logrus.Entry) ReplicatorOption {
+	return func(r *Replicator) { r.log = log }
+}
+
+// WithStreamOptions configures every stream of the replicator using opts
+func WithStreamOptions(opts ...Option) ReplicatorOption {
+	return func(r *Replicator) { r.streamOpts = append(r.streamOpts, opts...) }
+}
+
+// WithVersion is the version of the embedding program reported in topology registrations
+func WithVersion(version string) ReplicatorOption {
+	return func(r *Replicator) { r.version = version }
+}
+
+// embeddedStream is a Stream or Failover
+type embeddedStream interface {
+	Run(ctx context.Context, wg *sync.WaitGroup) error
+	Ready() <-chan struct{}
+	LastActive() time.Time
+	Leading() bool
+}
+
+var (
+	_ embeddedStream = (*Stream)(nil)
+	_ embeddedStream = (*Failover)(nil)
+)
+
+type embeddedProfile struct {
+	cfg     *config.Config
+	log     *logrus.Entry
+	events  *events.Publisher
+	streams []*embeddedState
+}
+
+type embeddedState struct {
+	cfg    *config.Stream
+	stream embeddedStream
+	exited chan struct{}
+}
+
+// New creates a Replicator for cfg, which should be loaded using config.Load or be validated using its Validate
+// method. All streams are created here so configuration errors are reported before anything is started
+func New(cfg *config.Config, opts ...ReplicatorOption) (*Replicator, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("configuration is required")
+	}
+
+	r := &Replicator{cfg: cfg}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	if r.log == nil {
+		logger := logrus.New()
+		level, err := logrus.ParseLevel(cfg.LogLevel)
+		if err == nil {
+			logger.SetLevel(level)
+		}
+		r.log = logrus.NewEntry(logger)
+	}
+
+	if cfg.Scheduler != nil {
+		r.scheduler = NewScheduler(cfg.Scheduler)
+	}
+
+	for _, p := range cfg.AllProfiles() {
+		profile, err := r.newProfile(p, p != cfg)
+		if err != nil {
+			return nil, err
+		}
+		r.profiles = append(r.profiles, profile)
+	}
+
+	return r, nil
+}
+
+func (r *Replicator) newProfile(cfg *config.Config, named bool) (*embeddedProfile, error) {
+	log := r.log
+	if named {
+		log = log.WithField("profile", cfg.ReplicatorName)
+	}
+
+	profile := &embeddedProfile{cfg: cfg, log: log}
+
+	var opts []Option
+	if cfg.Events != nil {
+		publisher, err := events.New(cfg.Events, cfg.ReplicatorName, log)
+		if err != nil {
+			return nil, err
+		}
+		profile.events = publisher
+		opts = append(opts, WithEvents(publisher))
+	}
+	if r.scheduler != nil {
+		opts = append(opts, WithScheduler(r.scheduler))
+	}
+	opts = append(opts, r.streamOpts...)
+
+	for _, s := range cfg.Streams {
+		var stream embeddedStream
+		var err error
+
+		if s.Failover != nil {
+			stream, err = NewFailover(s, cfg, log, opts...)
+		} else {
+			stream, err = NewStream(s, cfg, log, opts...)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not create stream %s: %v", s.Name, err)
+		}
+
+		profile.streams = append(profile.streams, &embeddedState{cfg: s, stream: stream, exited: make(chan struct{})})
+	}
+
+	return profile, nil
+}
+
+// Run starts all services and streams and blocks until ctx is canceled and all of them stopped, a Replicator can
+// only be run once
+func (r *Replicator) Run(ctx context.Context) error {
+	r.mu.Lock()
+	if r.ran {
+		r.mu.Unlock()
+		return fmt.Errorf("replicator already ran")
+	}
+	r.ran = true
+	r.mu.Unlock()
+
+	var err error
+	var auditor *audit.Auditor
+	var forwarder *metrics.Forwarder
+
+	// these are created before anything is started so failures do not leave services running
+	if r.cfg.Audit != nil {
+		auditor, err = audit.New(r.cfg.Audit, r.cfg.ReplicatorName, r.log)
+		if err != nil {
+			return err
+		}
+	}
+
+	if r.cfg.Metrics != nil {
+		forwarder, err = metrics.New(r.cfg.Metrics, r.cfg.ReplicatorName, r.log)
+		if err != nil {
+			return err
+		}
+	}
+
+	wg := &sync.WaitGroup{}
+
+	if auditor != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := auditor.Run(ctx, wg)
+			if err != nil {
+				r.log.Errorf("Could not start audit publisher: %v", err)
+			}
+		}()
+	}
+
+	if forwarder != nil {
+		err = forwarder.Run(ctx, wg)
+		if err != nil {
+			r.log.Errorf("Could not start metrics forwarding: %v", err)
+		}
+	}
+
+	if r.scheduler != nil {
+		r.log.Infof("Scheduling streams within %d bytes per second", r.cfg.Scheduler.BytesPerSecond)
+		wg.Add(1)
+		go r.scheduler.Run(ctx, wg)
+	}
+
+	for _, p := range r.profiles {
+		r.runProfile(ctx, wg, p)
+	}
+
+	if r.cfg.Topology != nil {
+		registry := topology.New(r.cfg.Topology, r.topologyRegistrations, r.log)
+		err = registry.Run(ctx, wg)
+		if err != nil {
+			r.log.Errorf("Could not start topology registry: %v", err)
+		}
+	}
+
+	<-ctx.Done()
+	wg.Wait()
+
+	return nil
+}
+
+func (r *Replicator) runProfile(ctx context.Context, wg *sync.WaitGroup, p *embeddedProfile) {
+	if p.events != nil {
+		// events are queued while connecting so this should not delay starting the streams
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := p.events.Run(ctx, wg)
+			if err != nil {
+				p.log.Errorf("Could not start events publisher: %v", err)
+			}
+		}()
+	}
+
+	for _, s := range p.streams {
+		wg.Add(1)
+		go func(s *embeddedState) {
+			defer wg.Done()
+			defer close(s.exited)
+
+			wg.Add(1)
+			err := s.stream.Run(ctx, wg)
+			if err != nil {
+				p.log.Errorf("Could not start replicator for %s: %v", s.cfg.Name, err)
+			}
+		}(s)
+	}
+
+	if p.cfg.HeartBeat != nil {
+		hb, err := heartbeat.New(p.cfg.HeartBeat, p.cfg.ReplicatorName, p.log)
+		if err != nil {
+			p.log.Errorf("Could not initialize heartbeat: %v", err)
+			return
+		}
+
+		err = hb.Run(ctx, wg)
+		if err != nil {
+			p.log.Errorf("Could not start heartbeat: %v", err)
+		}
+	}
+}
+
+// Streams are the Streams that are not part of failover pairs, keyed by profile and stream name
+func (r *Replicator) Streams() map[string]*Stream {
+	streams := make(map[string]*Stream)
+	for _, p := range r.profiles {
+		for _, s := range p.streams {
+			if stream, ok := s.stream.(*Stream); ok {
+				streams[fmt.Sprintf("%s/%s", p.cfg.ReplicatorName, s.cfg.Name)] = stream
+			}
+		}
+	}
+
+	return streams
+}
+
+// Ready is closed once every stream is ready or stopped after Run was called
+func (r *Replicator) Ready() <-chan struct{} {
+	ready := make(chan struct{})
+
+	go func() {
+		for _, p := range r.profiles {
+			for _, s := range p.streams {
+				select {
+				case <-s.stream.Ready():
+				case <-s.exited:
+				}
+			}
+		}
+		close(ready)
+	}()
+
+	return ready
+}
+
+// topologyRegistrations registers all streams with their current state and health
+func (r *Replicator) topologyRegistrations() []*topology.Registration {
+	host, _ := os.Hostname()
+
+	var regs []*topology.Registration
+	for _, p := range r.profiles {
+		for _, s := range p.streams {
+			var reg *topology.Registration
+
+			// failed over streams are registered in the direction they replicate in
+			if f, ok := s.stream.(*Failover); ok && f.FailedOver() {
+				reg = topology.NewRegistration(p.cfg.ReplicatorName, host, s.cfg.Reversed(time.Time{}))
+				reg.FailedOver = true
+			} else {
+				reg = topology.NewRegistration(p.cfg.ReplicatorName, host, s.cfg)
+			}
+
+			reg.Version = r.version
+
+			select {
+			case <-s.exited:
+				reg.State = topology.StoppedState
+			default:
+				select {
+				case <-s.stream.Ready():
+					if !s.stream.Leading() {
+						reg.State = topology.StandbyState
+					} else {
+						reg.State = topology.ReplicatingState
+						reg.LastActive = s.stream.LastActive()
+						reg.Healthy = time.Since(reg.LastActive) <= time.Minute
+					}
+				default:
+					reg.State = topology.StartingState
+				}
+			}
+
+			regs = append(regs, reg)
+		}
+	}
+
+	return regs
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/choria-io/stream-replicator/topology"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Replicator", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		log    *logrus.Entry
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		DeferCleanup(cancel)
+
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+	})
+
+	It("Should require a configuration", func() {
+		_, err := New(nil)
+		Expect(err).To(MatchError("configuration is required"))
+	})
+
+	It("Should report invalid streams before starting", func() {
+		cfg := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{{Name: "ORDERS", Stream: "ORDERS", TargetURL: "nats://localhost:4222"}}}
+
+		_, err := New(cfg, WithLogger(log))
+		Expect(err).To(MatchError("could not create stream ORDERS: source_url, source_kafka, source_mqtt, source_archive or source_file is required"))
+	})
+
+	It("Should run all configured streams until canceled", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("ORDERS", jsm.Subjects("ORDERS.>"))
+			Expect(err).ToNot(HaveOccurred())
+
+			for i := 0; i < 10; i++ {
+				_, err = nc.Request(fmt.Sprintf("ORDERS.%d", i), []byte("order"), time.Second)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			cfg := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{{
+				Stream:       "ORDERS",
+				TargetStream: "COPY",
+				TargetPrefix: "copy",
+				SourceURL:    nc.ConnectedUrl(),
+				TargetURL:    nc.ConnectedUrl(),
+			}}}
+			Expect(cfg.Validate()).To(Succeed())
+
+			r, err := New(cfg, WithLogger(log), WithVersion("1.2.3"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.Streams()).To(HaveKey("GINKGO/GINKGO"))
+
+			regs := r.topologyRegistrations()
+			Expect(regs).To(HaveLen(1))
+			Expect(regs[0].State).To(Equal(topology.StartingState))
+			Expect(regs[0].Version).To(Equal("1.2.3"))
+
+			done := make(chan error, 1)
+			go func() { done <- r.Run(ctx) }()
+
+			Eventually(r.Ready(), "10s").Should(BeClosed())
+
+			Eventually(func() (uint64, error) {
+				target, err := mgr.LoadStream("COPY")
+				if err != nil {
+					return 0, err
+				}
+				nfo, err := target.State()
+				if err != nil {
+					return 0, err
+				}
+				return nfo.Msgs, nil
+			}, "10s").Should(Equal(uint64(10)))
+
+			Expect(r.topologyRegistrations()[0].State).To(Equal(topology.ReplicatingState))
+			Expect(r.Run(ctx)).To(MatchError("replicator already ran"))
+
+			cancel()
+			Eventually(done, "10s").Should(Receive(BeNil()))
+			Expect(r.topologyRegistrations()[0].State).To(Equal(topology.StoppedState))
+		})
+	})
+})
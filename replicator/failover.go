@@ -0,0 +1,407 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/events"
+	"github.com/choria-io/stream-replicator/internal/util"
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+// FencingBucket is the Key-Value bucket on the target where failover fencing markers are stored
+const FencingBucket = "CHORIA_SR_FENCING"
+
+// FenceMarker records that a stream failed over, while present on the target the stream is only replicated in reverse
+type FenceMarker struct {
+	// Stream is the source stream that failed over
+	Stream string `json:"stream"`
+	// Replicator is the name of the replicator that failed over
+	Replicator string `json:"replicator"`
+	// Host is the host the replicator that failed over runs on
+	Host string `json:"host"`
+	// Reason is why the stream failed over
+	Reason string `json:"reason"`
+	// Time is when the stream failed over, reverse replication starts from here
+	Time time.Time `json:"time"`
+}
+
+// Failover runs a stream in its configured direction until it fails over, on command or on losing the source, and
+// then replicates from the target back to the source after fencing the old direction on the target
+type Failover struct {
+	cfg      *config.Stream
+	sr       *config.Config
+	log      *logrus.Entry
+	slog     *logrus.Entry
+	opts     []Option
+	key      string
+	interval time.Duration
+	trigger  chan string
+	ready    chan struct{}
+	once     sync.Once
+
+	stream   *Stream
+	reversed bool
+	fence    nats.KeyValue
+	mu       sync.Mutex
+}
+
+// NewFailover creates a stream that can fail over, the stream configuration must have Failover set
+func NewFailover(stream *config.Stream, sr *config.Config, log *logrus.Entry, opts ...Option) (*Failover, error) {
+	if stream.Failover == nil {
+		return nil, fmt.Errorf("failover is not configured for stream %s", stream.Stream)
+	}
+
+	s, err := NewStream(stream, sr, log, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Failover{
+		cfg:      stream,
+		sr:       sr,
+		log:      s.log,
+		slog:     log,
+		opts:     opts,
+		key:      fmt.Sprintf("%s_%s", s.cname, stream.Stream),
+		interval: livenessInterval,
+		trigger:  make(chan string, 1),
+		ready:    make(chan struct{}),
+		stream:   s,
+	}, nil
+}
+
+// Ready is closed once the stream is ready to copy in either direction
+func (f *Failover) Ready() <-chan struct{} {
+	return f.ready
+}
+
+// LastActive is the last time the copier of the current direction was known to be running
+func (f *Failover) LastActive() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.stream.LastActive()
+}
+
+// Leading determines if this replicator leads the stream in the current direction
+func (f *Failover) Leading() bool {
+	f.mu.Lock()
+	s := f.stream
+	f.mu.Unlock()
+
+	return s.Leading()
+}
+
+// Quiesce quiesces the stream replicating in the current direction, see Stream.Quiesce
+func (f *Failover) Quiesce(ctx context.Context) (*QuiesceState, error) {
+	f.mu.Lock()
+	s := f.stream
+	f.mu.Unlock()
+
+	return s.Quiesce(ctx)
+}
+
+// Resume continues copying in the current direction after being quiesced, see Stream.Resume
+func (f *Failover) Resume() bool {
+	f.mu.Lock()
+	s := f.stream
+	f.mu.Unlock()
+
+	return s.Resume()
+}
+
+// SubjectStats reports the most active subjects of the stream replicating in the current direction, see Stream.SubjectStats
+func (f *Failover) SubjectStats(top int) *SubjectStats {
+	f.mu.Lock()
+	s := f.stream
+	f.mu.Unlock()
+
+	return s.SubjectStats(top)
+}
+
+// FailedOver determines if the stream replicates in reverse
+func (f *Failover) FailedOver() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.reversed
+}
+
+// Trigger fails the stream over for reason
+func (f *Failover) Trigger(reason string) error {
+	if f.FailedOver() {
+		return fmt.Errorf("stream %s already failed over", f.cfg.Stream)
+	}
+
+	select {
+	case f.trigger <- reason:
+		return nil
+	default:
+		return fmt.Errorf("stream %s is already failing over", f.cfg.Stream)
+	}
+}
+
+// Run replicates until ctx is done
+func (f *Failover) Run(ctx context.Context, wg *sync.WaitGroup) error {
+	defer wg.Done()
+
+	err := f.connectFence(ctx)
+	if err != nil {
+		f.log.Errorf("Could not set up failover fencing: %v", err)
+		return err
+	}
+
+	marker, err := f.marker()
+	if err != nil {
+		return err
+	}
+
+	if marker == nil {
+		marker, err = f.runForward(ctx)
+		if err != nil || marker == nil {
+			return err
+		}
+	} else {
+		f.log.Warnf("Stream was failed over by %s at %s: %s, replicating from the target", marker.Replicator, marker.Time.Format(time.RFC3339), marker.Reason)
+	}
+
+	return f.runReversed(ctx, marker)
+}
+
+// runForward replicates in the configured direction until ctx is done or the stream is failed over, returns the fence
+// marker when failed over
+func (f *Failover) runForward(ctx context.Context) (*FenceMarker, error) {
+	sctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- f.start(sctx, f.stream) }()
+
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	var lost time.Time
+
+	// stops forward replication once marker is stored on the target
+	stop := func(marker *FenceMarker) (*FenceMarker, error) {
+		cancel()
+		<-done
+
+		return marker, nil
+	}
+
+	for {
+		select {
+		case reason := <-f.trigger:
+			marker, err := f.failover(reason)
+			if err != nil {
+				f.log.Errorf("Could not fail over: %v", err)
+				continue
+			}
+
+			return stop(marker)
+
+		case <-ticker.C:
+			marker, err := f.marker()
+			if err != nil {
+				f.log.Errorf("Could not check for failover fencing: %v", err)
+				continue
+			}
+
+			// another member of the group failed over
+			if marker != nil {
+				f.log.Warnf("Stream was failed over by %s: %s, stopping replication to the target", marker.Replicator, marker.Reason)
+				return stop(marker)
+			}
+
+			if f.cfg.Failover.DetectAfter == 0 || !f.stream.Leading() || f.stream.sourceConnected() {
+				lost = time.Time{}
+				continue
+			}
+
+			if lost.IsZero() {
+				lost = time.Now()
+				continue
+			}
+
+			since := time.Since(lost)
+			if since < f.cfg.Failover.DetectAfter {
+				continue
+			}
+
+			marker, err = f.failover(fmt.Sprintf("source unreachable for %v", since.Round(time.Second)))
+			if err != nil {
+				f.log.Errorf("Could not fail over: %v", err)
+				continue
+			}
+
+			return stop(marker)
+
+		case err := <-done:
+			return nil, err
+
+		case <-ctx.Done():
+			<-done
+			return nil, nil
+		}
+	}
+}
+
+// forwardCopy determines if msg, read from the target by a reversed stream, was copied to the target from the original
+// source, like messages still being copied forward after the fencing time, copying those back would duplicate them
+func (s *Stream) forwardCopy(msg *nats.Msg) bool {
+	if !s.cfg.IsReversed() || msg.Header == nil {
+		return false
+	}
+
+	// stream, sequence, replicator, name and time of the first copy
+	parts := strings.Split(msg.Header.Get(srcHeader), " ")
+
+	return len(parts) == 5 && parts[0] == s.cfg.TargetStream && parts[3] == s.cfg.Name
+}
+
+// runReversed replicates from the target back to the source starting at the time of the failover until ctx is done
+func (f *Failover) runReversed(ctx context.Context, marker *FenceMarker) error {
+	s, err := NewStream(f.cfg.Reversed(marker.Time), f.sr, f.slog, f.opts...)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.stream = s
+	f.reversed = true
+	f.mu.Unlock()
+
+	failedOver.WithLabelValues(f.cfg.Stream, f.sr.ReplicatorName, f.cfg.Name).Set(1)
+
+	return f.start(ctx, s)
+}
+
+// start runs s until ctx is done and marks the failover ready once s is
+func (f *Failover) start(ctx context.Context, s *Stream) error {
+	go func() {
+		select {
+		case <-s.Ready():
+			f.once.Do(func() { close(f.ready) })
+		case <-ctx.Done():
+		}
+	}()
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	err := s.Run(ctx, wg)
+	wg.Wait()
+
+	return err
+}
+
+// failover fences the configured direction on the target and announces the failover
+func (f *Failover) failover(reason string) (*FenceMarker, error) {
+	host, _ := os.Hostname()
+
+	marker := &FenceMarker{
+		Stream:     f.cfg.Stream,
+		Replicator: f.sr.ReplicatorName,
+		Host:       host,
+		Reason:     reason,
+		Time:       time.Now().UTC(),
+	}
+
+	j, err := json.Marshal(marker)
+	if err != nil {
+		return nil, err
+	}
+
+	// only one member of a group can fence the stream, others use the existing marker
+	_, err = f.fence.Create(f.key, j)
+	if errors.Is(err, nats.ErrKeyExists) {
+		existing, err := f.marker()
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			return existing, nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not store fencing marker: %v", err)
+	}
+
+	f.log.Warnf("Failing over: %s, replicating from the target from now on", reason)
+
+	f.stream.events.Publish(&events.Event{
+		Event:   events.FailoverEvent,
+		Stream:  f.cfg.Stream,
+		Name:    f.cfg.Name,
+		Message: fmt.Sprintf("Failed over, replicating from %s back to %s: %s", f.cfg.TargetStream, f.cfg.Stream, reason),
+		Data:    map[string]string{"reason": reason, "time": marker.Time.Format(time.RFC3339)},
+	})
+
+	return marker, nil
+}
+
+// marker retrieves the fence marker for the stream from the target, nil when not failed over
+func (f *Failover) marker() (*FenceMarker, error) {
+	entry, err := f.fence.Get(f.key)
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	marker := &FenceMarker{}
+	err = json.Unmarshal(entry.Value(), marker)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fencing marker %s: %v", f.key, err)
+	}
+
+	return marker, nil
+}
+
+// connectFence connects to the target and creates the fencing bucket when needed
+func (f *Failover) connectFence(ctx context.Context) error {
+	opts := f.cfg.TargetContextOptions()
+	if f.cfg.TargetProxy != "" {
+		opts = append(opts, util.WithProxy(f.cfg.TargetProxy))
+	}
+	opts = append(opts, f.cfg.Reconnect.ConnectOptions()...)
+
+	nc, err := util.ConnectNats(ctx, f.cfg.Stream, f.cfg.TargetServers(), f.cfg.TargetTLS, f.cfg.TargetChoriaConn, false, f.cfg.TargetProcess, f.log.WithField("connection", "fencing"), opts...)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		nc.Close()
+	}()
+
+	js, err := nc.JetStream()
+	if err != nil {
+		return err
+	}
+
+	f.fence, err = js.KeyValue(FencingBucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		f.fence, err = js.CreateKeyValue(&nats.KeyValueConfig{
+			Bucket:      FencingBucket,
+			Description: "Choria Stream Replicator failover fencing markers",
+		})
+	}
+
+	return err
+}
@@ -0,0 +1,229 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/events"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Failover", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	// runs cb with the TEST stream on the source and TEST_COPY on the target, on different servers
+	withSites := func(cb func(snc *nats.Conn, source *jsm.Stream, tnc *nats.Conn, target *jsm.Stream)) {
+		testutil.WithJetStream(log, func(_ *server.Server, snc *nats.Conn, smgr *jsm.Manager) {
+			testutil.WithJetStream(log, func(_ *server.Server, tnc *nats.Conn, tmgr *jsm.Manager) {
+				source, err := smgr.NewStream("TEST", jsm.Subjects("test.>"))
+				Expect(err).ToNot(HaveOccurred())
+				target, err := tmgr.NewStream("TEST_COPY", jsm.Subjects("test.>"))
+				Expect(err).ToNot(HaveOccurred())
+
+				cb(snc, source, tnc, target)
+			})
+		})
+	}
+
+	newFailover := func(snc *nats.Conn, tnc *nats.Conn, opts ...Option) *Failover {
+		scfg := &config.Stream{
+			Stream:       "TEST",
+			TargetStream: "TEST_COPY",
+			SourceURL:    snc.ConnectedUrl(),
+			TargetURL:    tnc.ConnectedUrl(),
+			Failover:     &config.Failover{},
+		}
+
+		f, err := NewFailover(scfg, &config.Config{ReplicatorName: "GINKGO"}, log, opts...)
+		Expect(err).ToNot(HaveOccurred())
+		f.interval = 100 * time.Millisecond
+
+		return f
+	}
+
+	run := func(f *Failover) {
+		wg.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			Expect(f.Run(ctx, &wg)).To(Succeed())
+		}()
+	}
+
+	publish := func(nc *nats.Conn, start int, count int) {
+		for i := start; i < start+count; i++ {
+			_, err := nc.Request(fmt.Sprintf("test.%d", i), []byte(fmt.Sprintf(`{"msg":%d}`, i)), time.Second)
+			Expect(err).ToNot(HaveOccurred())
+		}
+	}
+
+	// for use with Eventually()
+	streamMessages := func(s *jsm.Stream) func() (uint64, error) {
+		return func() (uint64, error) {
+			nfo, err := s.State()
+			if err != nil {
+				return 0, err
+			}
+			return nfo.Msgs, nil
+		}
+	}
+
+	fenceMarker := func(nc *nats.Conn) (*FenceMarker, error) {
+		js, err := nc.JetStream()
+		if err != nil {
+			return nil, err
+		}
+		kv, err := js.KeyValue(FencingBucket)
+		if err != nil {
+			return nil, err
+		}
+		entry, err := kv.Get("stream_replicator_TEST")
+		if err != nil {
+			return nil, err
+		}
+
+		marker := &FenceMarker{}
+		err = json.Unmarshal(entry.Value(), marker)
+
+		return marker, err
+	}
+
+	It("Should require failover to be configured", func() {
+		_, err := NewFailover(&config.Stream{Stream: "TEST"}, &config.Config{}, log)
+		Expect(err).To(MatchError("failover is not configured for stream TEST"))
+	})
+
+	It("Should fence the stream and replicate in reverse once triggered", func() {
+		withSites(func(snc *nats.Conn, source *jsm.Stream, tnc *nats.Conn, target *jsm.Stream) {
+			received := make(chan *nats.Msg, 10)
+			sub, err := tnc.ChanSubscribe("events.failover", received)
+			Expect(err).ToNot(HaveOccurred())
+			defer sub.Unsubscribe()
+
+			p := startEvents(ctx, &wg, tnc, log)
+
+			publish(snc, 0, 10)
+
+			f := newFailover(snc, tnc, WithEvents(p))
+			run(f)
+
+			Eventually(f.Ready(), 5*time.Second).Should(BeClosed())
+			Eventually(streamMessages(target), 5*time.Second).Should(Equal(uint64(10)))
+			Expect(f.FailedOver()).To(BeFalse())
+
+			Expect(f.Trigger("testing")).To(Succeed())
+			Eventually(f.FailedOver, 5*time.Second).Should(BeTrue())
+			Expect(f.Trigger("again")).To(MatchError("stream TEST already failed over"))
+
+			marker, err := fenceMarker(tnc)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(marker.Stream).To(Equal("TEST"))
+			Expect(marker.Replicator).To(Equal("GINKGO"))
+			Expect(marker.Reason).To(Equal("testing"))
+
+			event := nextEvent(received)
+			Expect(event.Event).To(Equal(events.FailoverEvent))
+			Expect(event.Data["reason"]).To(Equal("testing"))
+
+			// messages written to the target after the failover are copied back to the source, the ones before are not
+			publish(tnc, 10, 5)
+			Eventually(streamMessages(source), 5*time.Second).Should(Equal(uint64(15)))
+			Consistently(streamMessages(source), 500*time.Millisecond).Should(Equal(uint64(15)))
+		})
+	})
+
+	It("Should replicate in reverse when already fenced", func() {
+		withSites(func(snc *nats.Conn, source *jsm.Stream, tnc *nats.Conn, target *jsm.Stream) {
+			js, err := tnc.JetStream()
+			Expect(err).ToNot(HaveOccurred())
+			kv, err := js.CreateKeyValue(&nats.KeyValueConfig{Bucket: FencingBucket})
+			Expect(err).ToNot(HaveOccurred())
+
+			j, err := json.Marshal(&FenceMarker{Stream: "TEST", Replicator: "OTHER", Reason: "testing", Time: time.Now().UTC()})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = kv.Put("stream_replicator_TEST", j)
+			Expect(err).ToNot(HaveOccurred())
+
+			publish(snc, 0, 5)
+
+			f := newFailover(snc, tnc)
+			run(f)
+
+			Eventually(f.FailedOver, 5*time.Second).Should(BeTrue())
+			Eventually(f.Ready(), 5*time.Second).Should(BeClosed())
+			Expect(f.Trigger("testing")).To(MatchError("stream TEST already failed over"))
+
+			publish(tnc, 5, 5)
+			Eventually(streamMessages(source), 5*time.Second).Should(Equal(uint64(10)))
+			Consistently(streamMessages(target), 500*time.Millisecond).Should(Equal(uint64(5)))
+		})
+	})
+
+	It("Should not copy messages copied forward after the fence time back to the source", func() {
+		withSites(func(snc *nats.Conn, source *jsm.Stream, tnc *nats.Conn, target *jsm.Stream) {
+			js, err := tnc.JetStream()
+			Expect(err).ToNot(HaveOccurred())
+			kv, err := js.CreateKeyValue(&nats.KeyValueConfig{Bucket: FencingBucket})
+			Expect(err).ToNot(HaveOccurred())
+
+			fenced := time.Now().UTC().Add(-time.Minute)
+			j, err := json.Marshal(&FenceMarker{Stream: "TEST", Replicator: "OTHER", Reason: "testing", Time: fenced})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = kv.Put("stream_replicator_TEST", j)
+			Expect(err).ToNot(HaveOccurred())
+
+			publish(snc, 0, 5)
+
+			// another group member copied test.5 forward after the fence time before it saw the marker
+			msg := nats.NewMsg("test.5")
+			msg.Data = []byte(`{"msg":5}`)
+			msg.Header.Add(srcHeader, srcHeaderValue("TEST", 5, "OTHER", "", time.Now().UnixMilli()))
+			_, err = tnc.RequestMsg(msg, time.Second)
+			Expect(err).ToNot(HaveOccurred())
+
+			f := newFailover(snc, tnc)
+			run(f)
+
+			Eventually(f.FailedOver, 5*time.Second).Should(BeTrue())
+			Eventually(f.Ready(), 5*time.Second).Should(BeClosed())
+
+			publish(tnc, 6, 1)
+			Eventually(streamMessages(source), 5*time.Second).Should(Equal(uint64(6)))
+			Consistently(streamMessages(source), 500*time.Millisecond).Should(Equal(uint64(6)))
+
+			last, err := source.ReadMessage(6)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(last.Subject).To(Equal("test.6"))
+		})
+	})
+})
@@ -0,0 +1,229 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/archive"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// fileSuffix is the extension of complete files holding one JSON document per message
+	fileSuffix = ".jsonl"
+	// filePartialSuffix is added to files while they are being written
+	filePartialSuffix = ".partial"
+	// fileDoneSuffix is added to files once all their messages were copied
+	fileDoneSuffix = ".done"
+)
+
+// fileSink writes messages to newline delimited JSON files, files are only renamed to their final name once
+// complete so files being written are never read
+type fileSink struct {
+	mu     sync.Mutex
+	cfg    *config.File
+	stream string
+	file   *os.File
+	name   string
+	size   int64
+	timer  *time.Timer
+	log    *logrus.Entry
+}
+
+func (s *Stream) connectFile() error {
+	log := s.log.WithField("connection", "file")
+
+	err := os.MkdirAll(s.cfg.TargetFile.Directory, 0700)
+	if err != nil {
+		return fmt.Errorf("file target setup failed: %v", err)
+	}
+
+	sink := &fileSink{cfg: s.cfg.TargetFile, stream: s.cfg.Stream, log: log}
+
+	err = sink.completePartial()
+	if err != nil {
+		return fmt.Errorf("file target setup failed: %v", err)
+	}
+
+	log.Infof("Writing files to %s", s.cfg.TargetFile.Directory)
+
+	s.sink = sink
+
+	return nil
+}
+
+// completePartial completes files left partially written by a previous run, their messages were acknowledged
+func (k *fileSink) completePartial() error {
+	partial, err := filepath.Glob(filepath.Join(k.cfg.Directory, fmt.Sprintf("%s-*%s%s", k.stream, fileSuffix, filePartialSuffix)))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range partial {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		// a message being written when the replicator stopped was not acknowledged and will be received again
+		end := bytes.LastIndexByte(data, '\n') + 1
+		if end < len(data) {
+			err = os.Truncate(path, int64(end))
+			if err != nil {
+				return err
+			}
+		}
+
+		if end == 0 {
+			err = os.Remove(path)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		k.log.Warnf("Completing partially written file %s", path)
+
+		err = os.Rename(path, strings.TrimSuffix(path, filePartialSuffix))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recordForMsg creates the JSON representation of msg, the sequence and time are taken from its metadata when present
+func recordForMsg(msg *nats.Msg) *archive.Record {
+	rec := &archive.Record{
+		Subject: msg.Subject,
+		Time:    time.Now().UTC(),
+		Header:  msg.Header,
+		Data:    msg.Data,
+	}
+
+	meta, err := jsm.ParseJSMsgMetadata(msg)
+	if err == nil {
+		rec.Sequence = meta.StreamSequence()
+		rec.Time = meta.TimeStamp().UTC()
+	}
+
+	return rec
+}
+
+// publish appends msg to the current file, starting a new file when it would grow beyond max_bytes
+func (k *fileSink) publish(_ context.Context, msg *nats.Msg) error {
+	line, err := json.Marshal(recordForMsg(msg))
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.file != nil && k.size+int64(len(line)) > k.cfg.MaxBytes {
+		err = k.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	if k.file == nil {
+		err = k.open()
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := k.file.Write(line)
+	if err != nil {
+		// removes a partially written line so the message can be written again
+		if n > 0 {
+			k.file.Truncate(k.size)
+		}
+		return err
+	}
+
+	k.size += int64(n)
+
+	return nil
+}
+
+// open starts a new file that is completed after rotate_interval
+func (k *fileSink) open() (err error) {
+	k.name = filepath.Join(k.cfg.Directory, fmt.Sprintf("%s-%s%s", k.stream, time.Now().UTC().Format("20060102T150405.000000000Z"), fileSuffix))
+	k.file, err = os.OpenFile(k.name+filePartialSuffix, os.O_CREATE|os.O_WRONLY|os.O_APPEND|os.O_EXCL, 0600)
+	if err != nil {
+		k.file = nil
+		return err
+	}
+	k.size = 0
+
+	f := k.file
+	k.timer = time.AfterFunc(k.cfg.RotateInterval, func() {
+		k.mu.Lock()
+		defer k.mu.Unlock()
+
+		if k.file != f {
+			return
+		}
+
+		err := k.rotate()
+		if err != nil {
+			k.log.Errorf("Could not complete file %s: %v", k.name, err)
+		}
+	})
+
+	return nil
+}
+
+// rotate syncs, closes and completes the current file
+func (k *fileSink) rotate() error {
+	k.timer.Stop()
+
+	err := k.file.Sync()
+	if err != nil {
+		return err
+	}
+
+	err = k.file.Close()
+	k.file = nil
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(k.name+filePartialSuffix, k.name)
+	if err != nil {
+		return err
+	}
+
+	k.log.Infof("Completed file %s holding %d bytes", k.name, k.size)
+
+	return nil
+}
+
+func (k *fileSink) close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.file == nil {
+		return nil
+	}
+
+	return k.rotate()
+}
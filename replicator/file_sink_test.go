@@ -0,0 +1,146 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/archive"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("File Sink", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+		dir    string
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+		dir = GinkgoT().TempDir()
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	run := func(ctx context.Context, nc *nats.Conn, fcfg *config.File) {
+		scfg := &config.Stream{Stream: "ORDERS", SourceURL: nc.ConnectedUrl(), TargetFile: fcfg}
+		cfg := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+		Expect(cfg.Validate()).To(Succeed())
+
+		stream, err := NewStream(scfg, cfg, log)
+		Expect(err).ToNot(HaveOccurred())
+
+		wg.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+		}()
+	}
+
+	files := func(pattern string) func() []string {
+		return func() []string {
+			matches, err := filepath.Glob(filepath.Join(dir, pattern))
+			Expect(err).ToNot(HaveOccurred())
+			return matches
+		}
+	}
+
+	records := func(path string) []*archive.Record {
+		f, err := os.Open(path)
+		Expect(err).ToNot(HaveOccurred())
+		defer f.Close()
+
+		var recs []*archive.Record
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			rec := &archive.Record{}
+			Expect(json.Unmarshal(scanner.Bytes(), rec)).To(Succeed())
+			recs = append(recs, rec)
+		}
+
+		return recs
+	}
+
+	It("Should write messages to files rotated by size and time", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("ORDERS", jsm.Subjects("ORDERS.>"))
+			Expect(err).ToNot(HaveOccurred())
+
+			for i := 1; i <= 10; i++ {
+				_, err = nc.Request(fmt.Sprintf("ORDERS.%d", i), []byte(fmt.Sprintf("order %d", i)), time.Second)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			run(ctx, nc, &config.File{Directory: dir, MaxBytes: 1000, RotateIntervalString: "1s"})
+
+			// rotate_interval completes the last file once all messages are written
+			Eventually(files("ORDERS-*.jsonl.partial"), "10s").Should(BeEmpty())
+			Eventually(func() int {
+				var count int
+				for _, path := range files("ORDERS-*.jsonl")() {
+					count += len(records(path))
+				}
+				return count
+			}, "10s").Should(Equal(10))
+
+			complete := files("ORDERS-*.jsonl")()
+			Expect(len(complete)).To(BeNumerically(">", 1))
+
+			recs := records(complete[0])
+			Expect(recs[0].Subject).To(Equal("ORDERS.1"))
+			Expect(recs[0].Sequence).To(Equal(uint64(1)))
+			Expect(string(recs[0].Data)).To(Equal("order 1"))
+			Expect(recs[0].Header[srcHeader][0]).To(HavePrefix("ORDERS 1 GINKGO"))
+
+			for _, path := range complete {
+				nfo, err := os.Stat(path)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(nfo.Size()).To(BeNumerically("<=", 1000))
+			}
+		})
+	})
+
+	It("Should complete partially written files on start", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("ORDERS", jsm.Subjects("ORDERS.>"))
+			Expect(err).ToNot(HaveOccurred())
+
+			partial := filepath.Join(dir, "ORDERS-20230101T000000.000000000Z.jsonl.partial")
+			Expect(os.WriteFile(partial, []byte("{\"subject\":\"ORDERS.1\",\"seq\":1,\"time\":\"2023-01-01T00:00:00Z\",\"data\":\"eA==\"}\n{\"subject\":\"ORD"), 0600)).To(Succeed())
+
+			run(ctx, nc, &config.File{Directory: dir})
+
+			Eventually(files("ORDERS-*.jsonl"), "10s").Should(HaveLen(1))
+			Expect(partial).ToNot(BeAnExistingFile())
+
+			recs := records(filepath.Join(dir, "ORDERS-20230101T000000.000000000Z.jsonl"))
+			Expect(recs).To(HaveLen(1))
+			Expect(recs[0].Subject).To(Equal("ORDERS.1"))
+		})
+	})
+})
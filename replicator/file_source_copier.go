@@ -0,0 +1,249 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/archive"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/jsm.go/api"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// fileMaxLine is the longest line accepted when reading files
+const fileMaxLine = 128 * 1024 * 1024
+
+// fileSourceCopier copies messages from complete files written by the file target to the target stream in file
+// name order, files are renamed or removed once all their messages were stored
+type fileSourceCopier struct {
+	s       *Stream
+	sr      *config.Config
+	cfg     *config.Stream
+	fcfg    *config.File
+	copied  int64
+	skipped int64
+	log     *logrus.Entry
+}
+
+func newFileSourceCopier(s *Stream, log *logrus.Entry) *fileSourceCopier {
+	return &fileSourceCopier{
+		s:    s,
+		sr:   s.sr,
+		cfg:  s.cfg,
+		fcfg: s.cfg.SourceFile,
+		log: log.WithFields(logrus.Fields{
+			"copier":    "file_source",
+			"directory": s.cfg.SourceFile.Directory,
+		}),
+	}
+}
+
+func (s *Stream) connectFileSource(ctx context.Context) error {
+	if s.publisher != nil {
+		s.sink = &publisherSink{p: s.publisher}
+		return nil
+	}
+
+	scfg := jsm.DefaultStream
+	scfg.Subjects = nil
+	for _, subject := range s.cfg.SourceFile.Subjects {
+		scfg.Subjects = append(scfg.Subjects, s.TargetForSubject(subject))
+	}
+
+	err := s.connectTarget(ctx, scfg)
+	if err != nil {
+		return err
+	}
+
+	if s.dest == nil {
+		return fmt.Errorf("connection setup failed")
+	}
+
+	return nil
+}
+
+func (c *fileSourceCopier) copyMessages(ctx context.Context) error {
+	c.log.Infof("Starting file data copier for %s from %s", c.cfg.TargetStream, c.fcfg.Directory)
+
+	liveness := time.NewTicker(livenessInterval)
+	defer liveness.Stop()
+
+	for {
+		files, err := c.completeFiles()
+		if err != nil {
+			c.log.Errorf("Could not list files: %v", err)
+		}
+
+		for _, path := range files {
+			done, err := c.copyFile(ctx, path, liveness)
+			if err != nil {
+				return err
+			}
+			if !done {
+				c.log.Warnf("Copier shutting down after context interrupt")
+				return nil
+			}
+
+			if c.fcfg.Remove {
+				err = os.Remove(path)
+			} else {
+				err = os.Rename(path, path+fileDoneSuffix)
+			}
+			if err != nil {
+				return fmt.Errorf("could not mark %s as copied: %v", path, err)
+			}
+		}
+
+		// new files are copied as they are added until the replicator is stopped
+		wait := time.NewTimer(c.s.hcInterval)
+		for waiting := true; waiting; {
+			select {
+			case <-liveness.C:
+				c.s.markActive()
+			case <-wait.C:
+				waiting = false
+			case <-ctx.Done():
+				wait.Stop()
+				c.log.Warnf("Copier shutting down after context interrupt")
+				return nil
+			}
+		}
+	}
+}
+
+// completeFiles lists the complete files for the stream in name order, which is the order they were written in
+func (c *fileSourceCopier) completeFiles() ([]string, error) {
+	entries, err := os.ReadDir(c.fcfg.Directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, c.cfg.Stream+"-") || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+
+		files = append(files, filepath.Join(c.fcfg.Directory, name))
+	}
+
+	return files, nil
+}
+
+// copyFile copies all messages in path retrying each until it succeeds, false when ctx is canceled
+func (c *fileSourceCopier) copyFile(ctx context.Context, path string, liveness *time.Ticker) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	c.log.Infof("Copying messages from %s", path)
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), fileMaxLine)
+
+	for line := 1; scanner.Scan(); line++ {
+		select {
+		case <-liveness.C:
+			c.s.markActive()
+		default:
+		}
+
+		rec := &archive.Record{}
+		err = json.Unmarshal(scanner.Bytes(), rec)
+		if err != nil {
+			return false, fmt.Errorf("invalid message on line %d of %s: %v", line, path, err)
+		}
+
+		err = c.s.retries.For(ctx, func(try int) error {
+			err := c.handler(ctx, rec)
+			if err != nil {
+				handlerErrorCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+				c.s.reportError(err)
+				c.log.Errorf("Copying message on line %d of %s failed on try %d: %v", line, path, try, err)
+			}
+
+			return err
+		})
+		if err != nil {
+			return false, nil
+		}
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return false, fmt.Errorf("could not read %s: %v", path, err)
+	}
+
+	return true, nil
+}
+
+func (c *fileSourceCopier) handler(ctx context.Context, rec *archive.Record) error {
+	receivedMessageCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+	receivedMessageSize.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Add(float64(len(rec.Data)))
+	obs := prometheus.NewTimer(processTime.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name))
+	defer obs.ObserveDuration()
+
+	if c.cfg.MaxAgeDuration > 0 && time.Since(rec.Time) > c.cfg.MaxAgeDuration {
+		ageSkippedCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+		return nil
+	}
+
+	msg := nats.NewMsg(c.s.TargetForSubject(rec.Subject))
+	msg.Data = rec.Data
+	for k, vals := range rec.Header {
+		for _, v := range vals {
+			msg.Header.Add(k, v)
+		}
+	}
+
+	// messages copied again after a failure are discarded by the duplicate window of the target
+	if msg.Header.Get(api.JSMsgId) == _EMPTY_ && rec.Sequence > 0 {
+		msg.Header.Set(api.JSMsgId, fmt.Sprintf("%s:%d", c.cfg.Stream, rec.Sequence))
+	}
+	msg.Header.Add(srcHeader, srcHeaderValue(c.cfg.Stream, int64(rec.Sequence), c.sr.ReplicatorName, c.cfg.Name, rec.Time.UnixMilli()))
+
+	c.s.mapJetStreamHeaders(msg)
+	if !c.s.verifyMessage(msg) || !c.s.transformMessage(msg) {
+		atomic.AddInt64(&c.skipped, 1)
+		return nil
+	}
+
+	err := c.s.prepareMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	err = c.s.sink.publish(ctx, msg)
+	if err != nil {
+		return err
+	}
+
+	copied := atomic.AddInt64(&c.copied, 1)
+	if copied%1000 == 0 {
+		c.log.Infof("Copied %d message(s), skipped %d", copied, atomic.LoadInt64(&c.skipped))
+	}
+
+	copiedMessageCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+	copiedMessageSize.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Add(float64(len(msg.Data)))
+	c.s.subjects.record(msg.Subject, len(msg.Data))
+	c.s.messageCopied(msg)
+
+	return nil
+}
@@ -0,0 +1,185 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/archive"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/choria-io/stream-replicator/replicator/replicatortest"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/jsm.go/api"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("File Source Copier", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+		dir    string
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+		dir = GinkgoT().TempDir()
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	// writeFile writes a complete file holding messages first to last like the file target would
+	writeFile := func(name string, first uint64, last uint64) string {
+		var data []byte
+		for seq := first; seq <= last; seq++ {
+			rec := &archive.Record{Subject: fmt.Sprintf("ORDERS.%d", seq), Sequence: seq, Time: time.Now().UTC(), Data: []byte(fmt.Sprintf("order %d", seq))}
+			if seq%2 == 0 {
+				rec.Header = map[string][]string{api.JSMsgId: {fmt.Sprintf("order-%d", seq)}}
+			}
+
+			line, err := json.Marshal(rec)
+			Expect(err).ToNot(HaveOccurred())
+			data = append(data, append(line, '\n')...)
+		}
+
+		path := filepath.Join(dir, name)
+		Expect(os.WriteFile(path, data, 0600)).To(Succeed())
+
+		return path
+	}
+
+	run := func(nc *nats.Conn, fcfg *config.File) {
+		scfg := &config.Stream{Stream: "ORDERS", TargetURL: nc.ConnectedUrl(), SourceFile: fcfg}
+		cfg := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+		Expect(cfg.Validate()).To(Succeed())
+
+		stream, err := NewStream(scfg, cfg, log)
+		Expect(err).ToNot(HaveOccurred())
+		stream.hcInterval = 200 * time.Millisecond
+
+		wg.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+		}()
+	}
+
+	streamMessages := func(mgr *jsm.Manager) func() (uint64, error) {
+		return func() (uint64, error) {
+			stream, err := mgr.LoadStream("ORDERS")
+			if err != nil {
+				return 0, err
+			}
+			nfo, err := stream.State()
+			if err != nil {
+				return 0, err
+			}
+			return nfo.Msgs, nil
+		}
+	}
+
+	It("Should copy complete files in order and mark them done", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			second := writeFile("ORDERS-20230101T010000.000000000Z.jsonl", 6, 10)
+			first := writeFile("ORDERS-20230101T000000.000000000Z.jsonl", 1, 5)
+			writeFile("ORDERS-20230101T020000.000000000Z.jsonl.partial", 11, 15)
+			writeFile("OTHER-20230101T000000.000000000Z.jsonl", 1, 5)
+
+			run(nc, &config.File{Directory: dir, Subjects: []string{"ORDERS.>"}})
+			Eventually(streamMessages(mgr), "10s").Should(BeNumerically("==", 10))
+			Eventually(second+fileDoneSuffix, "10s").Should(BeAnExistingFile())
+			Expect(first + fileDoneSuffix).To(BeAnExistingFile())
+			Expect(first).ToNot(BeAnExistingFile())
+
+			stream, err := mgr.LoadStream("ORDERS")
+			Expect(err).ToNot(HaveOccurred())
+
+			msg, err := stream.ReadMessage(1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(msg.Subject).To(Equal("ORDERS.1"))
+			Expect(string(msg.Data)).To(Equal("order 1"))
+			hdrs, err := decodeHeadersMsg(msg.Header)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hdrs.Get(api.JSMsgId)).To(Equal("ORDERS:1"))
+
+			msg, err = stream.ReadMessage(6)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(msg.Subject).To(Equal("ORDERS.6"))
+			hdrs, err = decodeHeadersMsg(msg.Header)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hdrs.Get(api.JSMsgId)).To(Equal("order-6"))
+
+			// files are copied as they are completed
+			Expect(os.Rename(filepath.Join(dir, "ORDERS-20230101T020000.000000000Z.jsonl.partial"), filepath.Join(dir, "ORDERS-20230101T020000.000000000Z.jsonl"))).To(Succeed())
+			Eventually(streamMessages(mgr), "10s").Should(BeNumerically("==", 15))
+		})
+	})
+
+	It("Should copy to a publisher without a target server", func() {
+		first := writeFile("ORDERS-20230101T000000.000000000Z.jsonl", 1, 5)
+		second := writeFile("ORDERS-20230101T010000.000000000Z.jsonl", 6, 10)
+
+		pub := replicatortest.NewPublisher()
+		pub.FailNext(2, errors.New("injected failure"))
+
+		scfg := &config.Stream{Stream: "ORDERS", SourceFile: &config.File{Directory: dir, Subjects: []string{"ORDERS.>"}}}
+		cfg := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+		Expect(cfg.Validate()).To(Succeed())
+
+		stream, err := NewStream(scfg, cfg, log, WithPublisher(pub))
+		Expect(err).ToNot(HaveOccurred())
+
+		wg.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+		}()
+
+		Expect(pub.WaitForMessages(ctx, 10)).To(Succeed())
+		Eventually(second+fileDoneSuffix, "10s").Should(BeAnExistingFile())
+		Expect(first + fileDoneSuffix).To(BeAnExistingFile())
+		Expect(pub.Attempts()).To(Equal(12))
+
+		for i, msg := range pub.Messages() {
+			Expect(msg.Subject).To(Equal(fmt.Sprintf("ORDERS.%d", i+1)))
+			Expect(string(msg.Data)).To(Equal(fmt.Sprintf("order %d", i+1)))
+			Expect(msg.Header.Get(srcHeader)).To(HavePrefix(fmt.Sprintf("ORDERS %d GINKGO", i+1)))
+		}
+
+		cancel()
+		wg.Wait()
+		Expect(pub.Closed()).To(BeTrue())
+	})
+
+	It("Should remove copied files when configured", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			path := writeFile("ORDERS-20230101T000000.000000000Z.jsonl", 1, 5)
+
+			run(nc, &config.File{Directory: dir, Subjects: []string{"ORDERS.>"}, Remove: true})
+			Eventually(streamMessages(mgr), "10s").Should(BeNumerically("==", 5))
+			Eventually(path, "10s").ShouldNot(BeAnExistingFile())
+			Expect(path + fileDoneSuffix).ToNot(BeAnExistingFile())
+		})
+	})
+})
@@ -0,0 +1,71 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/nats-io/nats.go"
+)
+
+// hooks are the callbacks embedding programs registered using OnStreamStart, OnStreamStop, OnMessageCopied and
+// OnError, callbacks are called synchronously from the copiers so they should return quickly
+type hooks struct {
+	start  []func(stream *config.Stream)
+	stop   []func(stream *config.Stream, err error)
+	copied []func(stream *config.Stream, msg *nats.Msg)
+	errors []func(stream *config.Stream, err error)
+}
+
+// OnStreamStart calls cb once the stream is connected and starts copying
+func OnStreamStart(cb func(stream *config.Stream)) Option {
+	return func(s *Stream) { s.hooks.start = append(s.hooks.start, cb) }
+}
+
+// OnStreamStop calls cb once a started stream stops, err is set when copying failed
+func OnStreamStop(cb func(stream *config.Stream, err error)) Option {
+	return func(s *Stream) { s.hooks.stop = append(s.hooks.stop, cb) }
+}
+
+// OnMessageCopied calls cb for every message stored in the target, msg is the message as it was stored and must
+// not be modified
+func OnMessageCopied(cb func(stream *config.Stream, msg *nats.Msg)) Option {
+	return func(s *Stream) { s.hooks.copied = append(s.hooks.copied, cb) }
+}
+
+// OnError calls cb for errors setting up the stream and for every failed attempt to read, handle or publish a
+// message, messages are retried so errors are not necessarily fatal
+func OnError(cb func(stream *config.Stream, err error)) Option {
+	return func(s *Stream) { s.hooks.errors = append(s.hooks.errors, cb) }
+}
+
+func (s *Stream) streamStarted() {
+	for _, cb := range s.hooks.start {
+		cb(s.cfg)
+	}
+}
+
+func (s *Stream) streamStopped(err error) {
+	for _, cb := range s.hooks.stop {
+		cb(s.cfg, err)
+	}
+}
+
+// messageCopied notifies hooks that msg was stored in the target
+func (s *Stream) messageCopied(msg *nats.Msg) {
+	for _, cb := range s.hooks.copied {
+		cb(s.cfg, msg)
+	}
+}
+
+// reportError notifies hooks of err
+func (s *Stream) reportError(err error) {
+	if err == nil {
+		return
+	}
+
+	for _, cb := range s.hooks.errors {
+		cb(s.cfg, err)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/replicator/replicatortest"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Hooks", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	It("Should notify hooks of the stream lifecycle, copies and errors", func() {
+		var (
+			mu      sync.Mutex
+			events  []string
+			copied  []string
+			errs    []error
+			stopErr = errors.New("not stopped")
+		)
+
+		record := func(event string) {
+			mu.Lock()
+			events = append(events, event)
+			mu.Unlock()
+		}
+
+		src := newTestSource()
+		pub := replicatortest.NewPublisher()
+		pub.FailNext(1, errors.New("injected failure"))
+
+		scfg := &config.Config{ReplicatorName: "GINKGO"}
+		stream, err := NewStream(&config.Stream{Stream: "ORDERS"}, scfg, log,
+			WithSource(src),
+			WithPublisher(pub),
+			OnStreamStart(func(s *config.Stream) { record("start " + s.Stream) }),
+			OnStreamStop(func(s *config.Stream, err error) {
+				record("stop " + s.Stream)
+				mu.Lock()
+				stopErr = err
+				mu.Unlock()
+			}),
+			OnMessageCopied(func(_ *config.Stream, msg *nats.Msg) {
+				mu.Lock()
+				copied = append(copied, msg.Subject)
+				mu.Unlock()
+			}),
+			OnError(func(_ *config.Stream, err error) {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}),
+		)
+		Expect(err).ToNot(HaveOccurred())
+
+		wg.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			Expect(stream.Run(ctx, &wg)).To(Succeed())
+		}()
+
+		for i := int64(1); i <= 3; i++ {
+			src.add(fmt.Sprintf("orders.%d", i), i, time.Now())
+		}
+
+		Expect(pub.WaitForMessages(ctx, 3)).To(Succeed())
+
+		Eventually(func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]string{}, copied...)
+		}, "5s").Should(Equal([]string{"orders.1", "orders.2", "orders.3"}))
+
+		cancel()
+		wg.Wait()
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		Expect(events).To(Equal([]string{"start ORDERS", "stop ORDERS"}))
+		Expect(stopErr).ToNot(HaveOccurred())
+		Expect(errs).To(HaveLen(1))
+		Expect(errs[0]).To(MatchError("injected failure"))
+	})
+})
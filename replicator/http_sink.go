@@ -0,0 +1,156 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/util"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats.go"
+)
+
+// httpSink posts messages to a HTTP endpoint, single messages are posted as the request body while batches are
+// posted as newline delimited JSON documents
+type httpSink struct {
+	cfg    *config.HTTP
+	client *http.Client
+}
+
+func (s *Stream) connectHTTP() error {
+	hcfg := s.cfg.TargetHTTP
+
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.MaxIdleConnsPerHost = hcfg.Concurrency
+
+	var err error
+	if s.cfg.TargetProxy != _EMPTY_ {
+		tr.Proxy = nil
+		tr.DialContext, err = util.ProxyDialer(s.cfg.TargetProxy, 30*time.Second)
+		if err != nil {
+			return fmt.Errorf("http target setup failed: %v", err)
+		}
+	}
+
+	if hcfg.TLS != nil {
+		tr.TLSClientConfig, err = util.ClientTLSConfig(hcfg.TLS.CA, hcfg.TLS.Cert, hcfg.TLS.Key)
+		if err != nil {
+			return fmt.Errorf("http target tls: %v", err)
+		}
+	}
+
+	s.log.WithField("connection", "http").Infof("Posting messages to %s", hcfg.URL)
+
+	s.batchSink = &httpSink{cfg: hcfg, client: &http.Client{Transport: tr, Timeout: hcfg.Timeout}}
+	s.delivery = &hcfg.Delivery
+
+	return nil
+}
+
+func (h *httpSink) deliver(ctx context.Context, msgs []*nats.Msg) error {
+	req, err := h.request(ctx, msgs)
+	if err != nil {
+		return err
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// reading the body allows the connection to be reused
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+// request creates the request posting msgs, a batch size of 1 posts the message data with headers as HTTP headers
+func (h *httpSink) request(ctx context.Context, msgs []*nats.Msg) (*http.Request, error) {
+	var body []byte
+	var req *http.Request
+	var err error
+
+	if h.cfg.Batch == 1 {
+		msg := msgs[0]
+
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, h.cfg.URL, bytes.NewReader(msg.Data))
+		if err != nil {
+			return nil, err
+		}
+
+		for k, vals := range msg.Header {
+			for _, v := range vals {
+				req.Header.Add(k, v)
+			}
+		}
+
+		req.Header.Set(SubjectHeader, msg.Subject)
+		meta, err := jsm.ParseJSMsgMetadata(msg)
+		if err == nil {
+			req.Header.Set(SequenceHeader, strconv.FormatUint(meta.StreamSequence(), 10))
+		}
+
+		if req.Header.Get("Content-Type") == _EMPTY_ {
+			req.Header.Set("Content-Type", "application/octet-stream")
+		}
+	} else {
+		for _, msg := range msgs {
+			line, err := json.Marshal(recordForMsg(msg))
+			if err != nil {
+				return nil, err
+			}
+			body = append(append(body, line...), '\n')
+		}
+
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, h.cfg.URL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/x-ndjson")
+	}
+
+	for k, v := range h.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+
+	switch {
+	case h.cfg.Username != _EMPTY_:
+		req.SetBasicAuth(h.cfg.Username, h.cfg.Password)
+
+	case h.cfg.Token != _EMPTY_:
+		req.Header.Set("Authorization", "Bearer "+h.cfg.Token)
+
+	case h.cfg.TokenFile != _EMPTY_:
+		token, err := os.ReadFile(h.cfg.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read token_file: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	return req, nil
+}
+
+func (h *httpSink) close() error {
+	h.client.CloseIdleConnections()
+
+	return nil
+}
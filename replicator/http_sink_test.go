@@ -0,0 +1,214 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/archive"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/jsm.go/api"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("HTTP Sink", func() {
+	var (
+		ctx      context.Context
+		cancel   context.CancelFunc
+		wg       = sync.WaitGroup{}
+		log      *logrus.Entry
+		mu       sync.Mutex
+		requests []*http.Request
+		bodies   [][]byte
+		failures int
+		srv      *httptest.Server
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		requests = nil
+		bodies = nil
+		failures = 0
+
+		srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			Expect(err).ToNot(HaveOccurred())
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if failures != 0 {
+				failures--
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+
+			requests = append(requests, r)
+			bodies = append(bodies, body)
+		}))
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+			srv.Close()
+		})
+	})
+
+	run := func(nc *nats.Conn, hcfg *config.HTTP) *Stream {
+		scfg := &config.Stream{Stream: "ORDERS", SourceURL: nc.ConnectedUrl(), TargetHTTP: hcfg}
+		cfg := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+		Expect(cfg.Validate()).To(Succeed())
+
+		stream, err := NewStream(scfg, cfg, log)
+		Expect(err).ToNot(HaveOccurred())
+
+		wg.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+		}()
+
+		return stream
+	}
+
+	publish := func(nc *nats.Conn, mgr *jsm.Manager, count int) {
+		_, err := mgr.NewStream("ORDERS", jsm.Subjects("ORDERS.>"))
+		Expect(err).ToNot(HaveOccurred())
+
+		for i := 1; i <= count; i++ {
+			msg := nats.NewMsg(fmt.Sprintf("ORDERS.%d", i))
+			msg.Data = []byte(fmt.Sprintf("order %d", i))
+			msg.Header.Add(api.JSMsgId, fmt.Sprintf("%d", i))
+			_, err := nc.RequestMsg(msg, time.Second)
+			Expect(err).ToNot(HaveOccurred())
+		}
+	}
+
+	received := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(requests)
+	}
+
+	ackFloor := func(mgr *jsm.Manager, stream *Stream) func() (uint64, error) {
+		return func() (uint64, error) {
+			consumer, err := mgr.LoadConsumer("ORDERS", stream.cname)
+			if err != nil {
+				return 0, err
+			}
+			nfo, err := consumer.State()
+			if err != nil {
+				return 0, err
+			}
+			return nfo.AckFloor.Stream, nil
+		}
+	}
+
+	It("Should post single messages with headers and authentication", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			publish(nc, mgr, 5)
+
+			stream := run(nc, &config.HTTP{URL: srv.URL, Username: "bob", Password: "secret", Headers: map[string]string{"X-Source": "ginkgo"}})
+			Eventually(received, "10s").Should(Equal(5))
+			Eventually(ackFloor(mgr, stream), "10s").Should(Equal(uint64(5)))
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			req := requests[0]
+			Expect(req.Method).To(Equal(http.MethodPost))
+			Expect(string(bodies[0])).To(Equal("order 1"))
+			Expect(req.Header.Get(SubjectHeader)).To(Equal("ORDERS.1"))
+			Expect(req.Header.Get(SequenceHeader)).To(Equal("1"))
+			Expect(req.Header.Get(api.JSMsgId)).To(Equal("1"))
+			Expect(req.Header.Get(srcHeader)).To(HavePrefix("ORDERS 1 GINKGO"))
+			Expect(req.Header.Get("Content-Type")).To(Equal("application/octet-stream"))
+			Expect(req.Header.Get("X-Source")).To(Equal("ginkgo"))
+
+			user, pass, ok := req.BasicAuth()
+			Expect(ok).To(BeTrue())
+			Expect(user).To(Equal("bob"))
+			Expect(pass).To(Equal("secret"))
+		})
+	})
+
+	It("Should post batches concurrently", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			publish(nc, mgr, 25)
+
+			stream := run(nc, &config.HTTP{URL: srv.URL, Token: "s3cret", Delivery: config.Delivery{Batch: 10, Concurrency: 2, FlushIntervalString: "200ms"}})
+			Eventually(ackFloor(mgr, stream), "10s").Should(Equal(uint64(25)))
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			seen := map[uint64]bool{}
+			for i, req := range requests {
+				Expect(req.Header.Get("Content-Type")).To(Equal("application/x-ndjson"))
+				Expect(req.Header.Get("Authorization")).To(Equal("Bearer s3cret"))
+
+				var lines int
+				scanner := bufio.NewScanner(bytes.NewReader(bodies[i]))
+				for scanner.Scan() {
+					rec := &archive.Record{}
+					Expect(json.Unmarshal(scanner.Bytes(), rec)).To(Succeed())
+					Expect(string(rec.Data)).To(Equal(fmt.Sprintf("order %d", rec.Sequence)))
+					Expect(rec.Subject).To(Equal(fmt.Sprintf("ORDERS.%d", rec.Sequence)))
+					seen[rec.Sequence] = true
+					lines++
+				}
+				Expect(lines).To(BeNumerically("<=", 10))
+			}
+			Expect(seen).To(HaveLen(25))
+		})
+	})
+
+	It("Should retry failed deliveries", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			mu.Lock()
+			failures = 1
+			mu.Unlock()
+
+			publish(nc, mgr, 1)
+
+			stream := run(nc, &config.HTTP{URL: srv.URL})
+			Eventually(received, "10s").Should(Equal(1))
+			Eventually(ackFloor(mgr, stream), "10s").Should(Equal(uint64(1)))
+		})
+	})
+
+	It("Should discard messages once retries are exhausted", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			mu.Lock()
+			failures = -1
+			mu.Unlock()
+
+			publish(nc, mgr, 1)
+
+			stream := run(nc, &config.HTTP{URL: srv.URL, Delivery: config.Delivery{Retries: 1}})
+			Eventually(ackFloor(mgr, stream), "10s").Should(Equal(uint64(1)))
+			Expect(received()).To(Equal(0))
+		})
+	})
+})
@@ -0,0 +1,72 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"strings"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/nats-io/nats.go"
+)
+
+// defaultJetStreamHeaders strips expected headers, the conditions they express held for the source and would cause
+// the target to reject messages, and remaps source headers that would confuse targets sourcing other streams
+var defaultJetStreamHeaders = &config.JetStreamHeaders{
+	Expected:  config.HeadersStrip,
+	Republish: config.HeadersPreserve,
+	Source:    config.HeadersRemap,
+	Prefix:    "X-Source-",
+}
+
+// republishHeaders are added by JetStream to messages republished from a stream
+var republishHeaders = map[string]bool{
+	nats.JSStream:       true,
+	nats.JSSubject:      true,
+	nats.JSSequence:     true,
+	nats.JSLastSequence: true,
+	nats.JSTimeStamp:    true,
+	nats.MsgSize:        true,
+}
+
+// mapJetStreamHeaders strips, preserves or remaps the Nats-Expected-*, republish and source headers of msg as
+// configured after recording the origin of messages copied from mirrors and sourcing streams
+func (s *Stream) mapJetStreamHeaders(msg *nats.Msg) {
+	if msg.Header == nil {
+		return
+	}
+
+	s.setOriginHeader(msg)
+
+	cfg := s.cfg.JetStreamHeaders
+	if cfg == nil {
+		cfg = defaultJetStreamHeaders
+	}
+
+	for k, v := range msg.Header {
+		if !strings.HasPrefix(k, "Nats-") {
+			continue
+		}
+
+		var mode string
+		switch {
+		case strings.HasPrefix(k, "Nats-Expected-"):
+			mode = cfg.Expected
+		case republishHeaders[k]:
+			mode = cfg.Republish
+		case k == streamSourceHeader:
+			mode = cfg.Source
+		default:
+			continue
+		}
+
+		switch mode {
+		case config.HeadersStrip:
+			delete(msg.Header, k)
+		case config.HeadersRemap:
+			delete(msg.Header, k)
+			msg.Header[cfg.Prefix+strings.TrimPrefix(k, "Nats-")] = v
+		}
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/jsm.go/api"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("JetStream Headers", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	newStream := func(headers *config.JetStreamHeaders) *Stream {
+		scfg := &config.Stream{
+			Stream:           "TEST",
+			SourceURL:        "nats://localhost:4222",
+			TargetURL:        "nats://localhost:4222",
+			JetStreamHeaders: headers,
+		}
+		sr := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+		Expect(sr.Validate()).To(Succeed())
+
+		stream, err := NewStream(scfg, sr, log)
+		Expect(err).ToNot(HaveOccurred())
+
+		return stream
+	}
+
+	message := func() *nats.Msg {
+		msg := nats.NewMsg("orders")
+		msg.Header.Set(nats.ExpectedStreamHdr, "ORDERS")
+		msg.Header.Set(nats.ExpectedLastSeqHdr, "10")
+		msg.Header.Set(nats.JSStream, "ORDERS")
+		msg.Header.Set(nats.JSSequence, "11")
+		msg.Header.Set(nats.MsgIdHdr, "1")
+		msg.Header.Set("Region", "eu")
+
+		return msg
+	}
+
+	It("Should strip expected headers by default", func() {
+		stream := newStream(nil)
+
+		msg := message()
+		stream.mapJetStreamHeaders(msg)
+		Expect(msg.Header).To(Equal(nats.Header{
+			nats.JSStream:   {"ORDERS"},
+			nats.JSSequence: {"11"},
+			nats.MsgIdHdr:   {"1"},
+			"Region":        {"eu"},
+		}))
+	})
+
+	It("Should strip, preserve and remap headers as configured", func() {
+		stream := newStream(&config.JetStreamHeaders{Expected: config.HeadersPreserve, Republish: config.HeadersStrip})
+		msg := message()
+		stream.mapJetStreamHeaders(msg)
+		Expect(msg.Header).To(Equal(nats.Header{
+			nats.ExpectedStreamHdr:  {"ORDERS"},
+			nats.ExpectedLastSeqHdr: {"10"},
+			nats.MsgIdHdr:           {"1"},
+			"Region":                {"eu"},
+		}))
+
+		stream = newStream(&config.JetStreamHeaders{Expected: config.HeadersRemap, Republish: config.HeadersRemap, Prefix: "Origin-"})
+		msg = message()
+		stream.mapJetStreamHeaders(msg)
+		Expect(msg.Header).To(Equal(nats.Header{
+			"Origin-Expected-Stream":        {"ORDERS"},
+			"Origin-Expected-Last-Sequence": {"10"},
+			"Origin-Stream":                 {"ORDERS"},
+			"Origin-Sequence":               {"11"},
+			nats.MsgIdHdr:                   {"1"},
+			"Region":                        {"eu"},
+		}))
+
+		stream.mapJetStreamHeaders(&nats.Msg{Subject: "orders"})
+	})
+
+	It("Should copy messages published with expectations", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("TEST", jsm.Subjects("TEST"))
+			Expect(err).ToNot(HaveOccurred())
+			target, err := mgr.NewStream("COPY", jsm.Subjects("copy.>"))
+			Expect(err).ToNot(HaveOccurred())
+
+			for i := 0; i < 5; i++ {
+				msg := nats.NewMsg("TEST")
+				msg.Header.Set(nats.ExpectedStreamHdr, "TEST")
+				_, err := nc.RequestMsg(msg, time.Second)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			scfg := &config.Stream{
+				Stream:           "TEST",
+				TargetStream:     "COPY",
+				TargetPrefix:     "copy",
+				SourceURL:        nc.ConnectedUrl(),
+				TargetURL:        nc.ConnectedUrl(),
+				NoTargetCreate:   true,
+				JetStreamHeaders: &config.JetStreamHeaders{Expected: config.HeadersRemap},
+			}
+			sr := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+			Expect(sr.Validate()).To(Succeed())
+
+			stream, err := NewStream(scfg, sr, log)
+			Expect(err).ToNot(HaveOccurred())
+
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				Expect(stream.Run(ctx, &wg)).To(Succeed())
+			}()
+
+			Eventually(func() (uint64, error) {
+				nfo, err := target.State()
+				return nfo.Msgs, err
+			}, 10*time.Second).Should(Equal(uint64(5)))
+
+			msg, err := target.ReadMessage(1)
+			Expect(err).ToNot(HaveOccurred())
+			headers, err := decodeHeadersMsg(msg.Header)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(headers.Get("X-Source-Expected-Stream")).To(Equal("TEST"))
+			Expect(headers.Get(nats.ExpectedStreamHdr)).To(BeEmpty())
+		})
+	})
+
+	Describe("Mirrors and sourcing streams", func() {
+		// copy replicates TEST to COPY and waits for count messages in COPY
+		copy := func(mgr *jsm.Manager, nc *nats.Conn, count int, headers *config.JetStreamHeaders) *jsm.Stream {
+			scfg := &config.Stream{
+				Stream:           "TEST",
+				TargetStream:     "COPY",
+				TargetPrefix:     "copy",
+				SourceURL:        nc.ConnectedUrl(),
+				TargetURL:        nc.ConnectedUrl(),
+				JetStreamHeaders: headers,
+			}
+			sr := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+			Expect(sr.Validate()).To(Succeed())
+
+			stream, err := NewStream(scfg, sr, log)
+			Expect(err).ToNot(HaveOccurred())
+
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				Expect(stream.Run(ctx, &wg)).To(Succeed())
+			}()
+
+			var target *jsm.Stream
+			Eventually(func() (uint64, error) {
+				target, err = mgr.LoadStream("COPY")
+				if err != nil {
+					return 0, err
+				}
+				nfo, err := target.State()
+				return nfo.Msgs, err
+			}, 10*time.Second).Should(Equal(uint64(count)))
+
+			return target
+		}
+
+		origin := func(target *jsm.Stream, seq uint64) string {
+			msg, err := target.ReadMessage(seq)
+			Expect(err).ToNot(HaveOccurred())
+			headers, err := decodeHeadersMsg(msg.Header)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(headers.Get("Nats-Stream-Source")).To(BeEmpty())
+
+			return headers.Get(OriginHeader)
+		}
+
+		It("Should copy mirrors into plain streams recording the origin sequence", func() {
+			testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+				_, err := mgr.NewStream("ORDERS", jsm.Subjects("orders.>"))
+				Expect(err).ToNot(HaveOccurred())
+				for i := 0; i < 5; i++ {
+					_, err := nc.Request("orders.new", []byte("x"), time.Second)
+					Expect(err).ToNot(HaveOccurred())
+				}
+
+				mirror, err := mgr.NewStream("TEST", jsm.Mirror(&api.StreamSource{Name: "ORDERS", OptStartSeq: 3}))
+				Expect(err).ToNot(HaveOccurred())
+				Eventually(func() (uint64, error) {
+					nfo, err := mirror.State()
+					return nfo.Msgs, err
+				}, 5*time.Second).Should(Equal(uint64(3)))
+
+				target := copy(mgr, nc, 3, nil)
+				Expect(target.IsMirror()).To(BeFalse())
+				Expect(target.Subjects()).To(Equal([]string{"copy.orders.>"}))
+				Expect(origin(target, 1)).To(Equal("ORDERS 3"))
+				Expect(origin(target, 3)).To(Equal("ORDERS 5"))
+			})
+		})
+
+		It("Should copy sourcing streams recording the origin of sourced messages", func() {
+			testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+				_, err := mgr.NewStream("ORDERS", jsm.Subjects("orders.>"))
+				Expect(err).ToNot(HaveOccurred())
+				_, err = mgr.NewStream("INVOICES", jsm.Subjects("invoices.>"))
+				Expect(err).ToNot(HaveOccurred())
+
+				_, err = nc.Request("orders.new", []byte("x"), time.Second)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = nc.Request("invoices.new", []byte("x"), time.Second)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = nc.Request("invoices.new", []byte("x"), time.Second)
+				Expect(err).ToNot(HaveOccurred())
+
+				sourcing, err := mgr.NewStream("TEST", jsm.Subjects("local"), jsm.Sources(&api.StreamSource{Name: "ORDERS"}, &api.StreamSource{Name: "INVOICES", FilterSubject: "invoices.new"}))
+				Expect(err).ToNot(HaveOccurred())
+				Eventually(func() (uint64, error) {
+					nfo, err := sourcing.State()
+					return nfo.Msgs, err
+				}, 5*time.Second).Should(Equal(uint64(3)))
+				_, err = nc.Request("local", []byte("x"), time.Second)
+				Expect(err).ToNot(HaveOccurred())
+
+				target := copy(mgr, nc, 4, nil)
+				Expect(target.IsSourced()).To(BeFalse())
+				Expect(target.Subjects()).To(ConsistOf("copy.local", "copy.orders.>", "copy.invoices.new"))
+
+				origins := map[string]int{}
+				for seq := uint64(1); seq <= 4; seq++ {
+					origins[origin(target, seq)]++
+				}
+				Expect(origins).To(Equal(map[string]int{"ORDERS 1": 1, "INVOICES 1": 1, "INVOICES 2": 1, "": 1}))
+			})
+		})
+
+		It("Should refuse to copy into mirrors", func() {
+			testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+				_, err := mgr.NewStream("TEST", jsm.Subjects("TEST"))
+				Expect(err).ToNot(HaveOccurred())
+				_, err = mgr.NewStream("COPY", jsm.Mirror(&api.StreamSource{Name: "TEST"}))
+				Expect(err).ToNot(HaveOccurred())
+
+				scfg := &config.Stream{Stream: "TEST", TargetStream: "COPY", SourceURL: nc.ConnectedUrl(), TargetURL: nc.ConnectedUrl()}
+				sr := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+				Expect(sr.Validate()).To(Succeed())
+
+				stream, err := NewStream(scfg, sr, log)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(stream.connect(ctx)).To(MatchError("target stream COPY is a mirror of TEST and can not receive copied messages"))
+			})
+		})
+	})
+})
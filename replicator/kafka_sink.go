@@ -0,0 +1,76 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/choria-io/stream-replicator/internal/kafka"
+	"github.com/nats-io/nats.go"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// kafkaSink publishes messages to a Kafka topic using an idempotent producer
+type kafkaSink struct {
+	client *kgo.Client
+	topic  string
+}
+
+func (s *Stream) connectKafka(ctx context.Context) error {
+	log := s.log.WithField("connection", "kafka")
+
+	opts, err := kafka.ClientOptions(s.cfg.TargetKafka, s.cfg.TargetProxy)
+	if err != nil {
+		return fmt.Errorf("kafka connection failed: %v", err)
+	}
+
+	// the producer is idempotent by default, all in-sync replicas has to acknowledge for that to hold
+	opts = append(opts, kgo.RequiredAcks(kgo.AllISRAcks()))
+
+	policy := s.retries
+	if s.cfg.Reconnect != nil {
+		policy = s.cfg.Reconnect.Policy()
+	}
+
+	client, err := kafka.Connect(ctx, opts, policy, log)
+	if err != nil {
+		return fmt.Errorf("kafka connection failed: %v", err)
+	}
+
+	log.Infof("Connected to Kafka brokers %v", s.cfg.TargetKafka.Brokers)
+
+	s.sink = &kafkaSink{client: client, topic: s.cfg.TargetKafka.Topic}
+
+	return nil
+}
+
+// publish produces msg to the topic and waits for it to be acknowledged, the subject is used as key
+func (k *kafkaSink) publish(ctx context.Context, msg *nats.Msg) error {
+	topic := k.topic
+	if topic == _EMPTY_ {
+		topic = kafka.TopicForSubject(msg.Subject)
+	}
+
+	rec := &kgo.Record{
+		Topic:   topic,
+		Key:     []byte(msg.Subject),
+		Value:   msg.Data,
+		Headers: kafka.HeadersFromNats(msg.Header),
+	}
+
+	return k.client.ProduceSync(ctx, rec).FirstErr()
+}
+
+func (k *kafkaSink) close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := k.client.Flush(ctx)
+	k.client.Close()
+
+	return err
+}
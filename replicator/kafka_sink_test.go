@@ -0,0 +1,143 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+var _ = Describe("Kafka Sink", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	consume := func(cluster *kfake.Cluster, topic string, count int) []*kgo.Record {
+		client, err := kgo.NewClient(kgo.SeedBrokers(cluster.ListenAddrs()...), kgo.ConsumeTopics(topic), kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()))
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		var records []*kgo.Record
+		for len(records) < count {
+			fetches := client.PollFetches(ctx)
+			Expect(fetches.Errors()).To(BeEmpty())
+			records = append(records, fetches.Records()...)
+		}
+
+		return records
+	}
+
+	run := func(scfg *config.Stream) {
+		stream, err := NewStream(scfg, &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}, log)
+		Expect(err).ToNot(HaveOccurred())
+
+		wg.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+		}()
+	}
+
+	It("Should require a target", func() {
+		_, err := NewStream(&config.Stream{Stream: "TEST", SourceURL: "nats://localhost:4222"}, nil, log)
+		Expect(err).To(MatchError("target_url, target_kafka, target_archive, target_file, target_http, target_pubsub, target_sns, target_sqs, target_elasticsearch, target_clickhouse, target_syslog, target_redis, target_postgres or target_remote_write is required"))
+	})
+
+	It("Should copy messages to the configured topic", func() {
+		cluster, err := kfake.NewCluster(kfake.SeedTopics(1, "orders"))
+		Expect(err).ToNot(HaveOccurred())
+		defer cluster.Close()
+
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("TEST", jsm.Subjects("TEST.>"))
+			Expect(err).ToNot(HaveOccurred())
+
+			for i := 1; i <= 5; i++ {
+				msg := nats.NewMsg(fmt.Sprintf("TEST.%d", i))
+				msg.Data = []byte(fmt.Sprintf("msg %d", i))
+				msg.Header.Add("X-Test", "a")
+				msg.Header.Add("X-Test", "b")
+				_, err := nc.RequestMsg(msg, time.Second)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			run(&config.Stream{
+				Stream:      "TEST",
+				SourceURL:   nc.ConnectedUrl(),
+				TargetKafka: &config.Kafka{Brokers: cluster.ListenAddrs(), Topic: "orders", ClientID: "ginkgo"},
+			})
+
+			records := consume(cluster, "orders", 5)
+			Expect(records).To(HaveLen(5))
+			Expect(string(records[0].Key)).To(Equal("TEST.1"))
+			Expect(string(records[0].Value)).To(Equal("msg 1"))
+
+			var values []string
+			var source string
+			for _, h := range records[0].Headers {
+				switch h.Key {
+				case "X-Test":
+					values = append(values, string(h.Value))
+				case srcHeader:
+					source = string(h.Value)
+				}
+			}
+			Expect(values).To(Equal([]string{"a", "b"}))
+			Expect(source).To(HavePrefix("TEST 1 GINKGO"))
+		})
+	})
+
+	It("Should use the target subject as topic", func() {
+		cluster, err := kfake.NewCluster(kfake.SeedTopics(1, "copy.TEST.1"))
+		Expect(err).ToNot(HaveOccurred())
+		defer cluster.Close()
+
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("TEST", jsm.Subjects("TEST.>"))
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = nc.Request("TEST.1", []byte("msg 1"), time.Second)
+			Expect(err).ToNot(HaveOccurred())
+
+			run(&config.Stream{
+				Stream:       "TEST",
+				TargetPrefix: "copy",
+				SourceURL:    nc.ConnectedUrl(),
+				TargetKafka:  &config.Kafka{Brokers: cluster.ListenAddrs(), ClientID: "ginkgo"},
+			})
+
+			records := consume(cluster, "copy.TEST.1", 1)
+			Expect(string(records[0].Key)).To(Equal("copy.TEST.1"))
+		})
+	})
+})
@@ -0,0 +1,166 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/kafka"
+	"github.com/nats-io/jsm.go/api"
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// kafkaSource consumes Kafka topics using a consumer group, offsets are only committed once all records of a poll
+// were copied
+type kafkaSource struct {
+	s      *Stream
+	cfg    *config.Stream
+	client *kgo.Client
+	// poll are the records of the last poll, records those of them not yet returned by Next
+	poll    []*kgo.Record
+	records []*kgo.Record
+	log     *logrus.Entry
+}
+
+func newKafkaSource(s *Stream) *kafkaSource {
+	return &kafkaSource{
+		s:   s,
+		cfg: s.cfg,
+		log: s.log.WithFields(logrus.Fields{
+			"connection": "kafka",
+			"group":      s.cfg.SourceKafka.Group,
+		}),
+	}
+}
+
+func (k *kafkaSource) Open(ctx context.Context) error {
+	kcfg := k.cfg.SourceKafka
+
+	opts, err := kafka.ClientOptions(kcfg, k.cfg.SourceProxy)
+	if err != nil {
+		return fmt.Errorf("kafka connection failed: %v", err)
+	}
+
+	// only used when the group has no committed offsets yet
+	start := kgo.NewOffset().AtStart()
+	switch {
+	case k.cfg.StartAtEnd:
+		start = kgo.NewOffset().AtEnd()
+	case !k.cfg.StartTime.IsZero():
+		start = kgo.NewOffset().AfterMilli(k.cfg.StartTime.UnixMilli())
+	}
+
+	opts = append(opts,
+		kgo.ConsumerGroup(kcfg.Group),
+		kgo.ConsumeTopics(kcfg.Topics...),
+		kgo.ConsumeResetOffset(start),
+		kgo.DisableAutoCommit(),
+		// partitions are not moved to other group members while a poll is being copied
+		kgo.BlockRebalanceOnPoll(),
+	)
+
+	policy := k.s.retries
+	if k.cfg.Reconnect != nil {
+		policy = k.cfg.Reconnect.Policy()
+	}
+
+	k.client, err = kafka.Connect(ctx, opts, policy, k.log)
+	if err != nil {
+		return fmt.Errorf("kafka connection failed: %v", err)
+	}
+
+	k.log.Infof("Connected to Kafka brokers %v consuming %v", kcfg.Brokers, kcfg.Topics)
+
+	return nil
+}
+
+func (k *kafkaSource) Subjects() []string {
+	return k.cfg.SourceKafka.Topics
+}
+
+func (k *kafkaSource) Next(ctx context.Context) (*SourceMessage, error) {
+	if len(k.records) == 0 {
+		// polls are bounded so liveness is maintained while topics are idle
+		pctx, cancel := context.WithTimeout(ctx, livenessInterval)
+		fetches := k.client.PollFetches(pctx)
+		cancel()
+
+		if ctx.Err() != nil || fetches.IsClientClosed() {
+			return nil, ctx.Err()
+		}
+
+		for _, ferr := range fetches.Errors() {
+			if errors.Is(ferr.Err, context.DeadlineExceeded) || errors.Is(ferr.Err, context.Canceled) {
+				continue
+			}
+
+			handlerErrorCount.WithLabelValues(k.cfg.Stream, k.s.sr.ReplicatorName, k.cfg.Name).Inc()
+			k.s.reportError(ferr.Err)
+			k.log.Errorf("Fetching from %s partition %d failed: %v", ferr.Topic, ferr.Partition, ferr.Err)
+		}
+
+		k.records = fetches.Records()
+		k.poll = k.records
+		if len(k.records) == 0 {
+			k.client.AllowRebalance()
+			return nil, nil
+		}
+	}
+
+	rec := k.records[0]
+	k.records = k.records[1:]
+
+	msg := nats.NewMsg(k.subjectForRecord(rec))
+	msg.Data = rec.Value
+	for _, h := range rec.Headers {
+		msg.Header.Add(h.Key, string(h.Value))
+	}
+
+	// the target stream discards records redelivered after a failure to commit offsets
+	msg.Header.Set(api.JSMsgId, fmt.Sprintf("%s:%d:%d", rec.Topic, rec.Partition, rec.Offset))
+
+	m := &SourceMessage{Msg: msg, Source: rec.Topic, Sequence: rec.Offset, Time: rec.Timestamp}
+	if len(k.records) == 0 {
+		// records are ordered within each partition, the poll is committed once its last record was copied
+		m.Ack = k.commit(k.poll)
+	}
+
+	return m, nil
+}
+
+// commit commits the offsets of the records of a poll
+func (k *kafkaSource) commit(records []*kgo.Record) func() error {
+	return func() error {
+		defer k.client.AllowRebalance()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		return k.client.CommitRecords(ctx, records...)
+	}
+}
+
+// subjectForRecord determines the subject for rec before target_subject_prefix and target_subject_remove are applied
+func (k *kafkaSource) subjectForRecord(rec *kgo.Record) string {
+	if k.cfg.SourceKafka.SubjectFromKey && len(rec.Key) > 0 {
+		return string(rec.Key)
+	}
+
+	return rec.Topic
+}
+
+func (k *kafkaSource) Close() error {
+	if k.client != nil {
+		k.client.Close()
+	}
+
+	return nil
+}
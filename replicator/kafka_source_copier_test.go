@@ -0,0 +1,156 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/jsm.go/api"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+var _ = Describe("Kafka Source Copier", func() {
+	var (
+		ctx     context.Context
+		cancel  context.CancelFunc
+		wg      = sync.WaitGroup{}
+		log     *logrus.Entry
+		cluster *kfake.Cluster
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		var err error
+		cluster, err = kfake.NewCluster(kfake.SeedTopics(1, "orders"))
+		Expect(err).ToNot(HaveOccurred())
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+			cluster.Close()
+		})
+	})
+
+	produce := func(start int, count int) {
+		client, err := kgo.NewClient(kgo.SeedBrokers(cluster.ListenAddrs()...))
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		for i := start; i < start+count; i++ {
+			rec := &kgo.Record{
+				Topic:   "orders",
+				Key:     []byte(fmt.Sprintf("orders.%d", i)),
+				Value:   []byte(fmt.Sprintf("order %d", i)),
+				Headers: []kgo.RecordHeader{{Key: "X-Test", Value: []byte("a")}, {Key: "X-Test", Value: []byte("b")}},
+			}
+			Expect(client.ProduceSync(ctx, rec).FirstErr()).To(Succeed())
+		}
+	}
+
+	run := func(ctx context.Context, scfg *config.Stream) {
+		stream, err := NewStream(scfg, &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}, log)
+		Expect(err).ToNot(HaveOccurred())
+
+		wg.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+		}()
+	}
+
+	streamMessages := func(mgr *jsm.Manager) func() (uint64, error) {
+		return func() (uint64, error) {
+			stream, err := mgr.LoadStream("ORDERS")
+			if err != nil {
+				return 0, err
+			}
+			nfo, err := stream.State()
+			if err != nil {
+				return 0, err
+			}
+			return nfo.Msgs, nil
+		}
+	}
+
+	It("Should copy records to the target stream", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			produce(1, 5)
+
+			run(ctx, &config.Stream{
+				Stream:       "ORDERS",
+				TargetPrefix: "kafka",
+				TargetURL:    nc.ConnectedUrl(),
+				SourceKafka:  &config.Kafka{Brokers: cluster.ListenAddrs(), Topics: []string{"orders"}, ClientID: "ginkgo", Group: "ginkgo"},
+			})
+
+			Eventually(streamMessages(mgr), "10s").Should(BeNumerically("==", 5))
+
+			stream, err := mgr.LoadStream("ORDERS")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stream.Subjects()).To(Equal([]string{"kafka.orders"}))
+
+			msg, err := stream.ReadMessage(1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(msg.Subject).To(Equal("kafka.orders"))
+			Expect(string(msg.Data)).To(Equal("order 1"))
+
+			hdrs, err := decodeHeadersMsg(msg.Header)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hdrs.Values("X-Test")).To(Equal([]string{"a", "b"}))
+			Expect(hdrs.Get(api.JSMsgId)).To(Equal("orders:0:0"))
+			Expect(hdrs.Get(srcHeader)).To(HavePrefix("orders 0 GINKGO"))
+		})
+	})
+
+	It("Should resume from committed offsets", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("ORDERS", jsm.Subjects("orders.>"))
+			Expect(err).ToNot(HaveOccurred())
+
+			produce(1, 5)
+
+			scfg := &config.Stream{
+				Stream:         "ORDERS",
+				TargetURL:      nc.ConnectedUrl(),
+				NoTargetCreate: true,
+				SourceKafka:    &config.Kafka{Brokers: cluster.ListenAddrs(), Topics: []string{"orders"}, ClientID: "ginkgo", Group: "ginkgo", SubjectFromKey: true},
+			}
+
+			rctx, rcancel := context.WithCancel(ctx)
+			run(rctx, scfg)
+			Eventually(streamMessages(mgr), "10s").Should(BeNumerically("==", 5))
+			rcancel()
+			wg.Wait()
+
+			produce(6, 5)
+
+			run(ctx, scfg)
+			Eventually(streamMessages(mgr), "10s").Should(BeNumerically("==", 10))
+			Consistently(streamMessages(mgr), "500ms").Should(BeNumerically("==", 10))
+
+			stream, err := mgr.LoadStream("ORDERS")
+			Expect(err).ToNot(HaveOccurred())
+			msg, err := stream.ReadMessage(10)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(msg.Subject).To(Equal("orders.10"))
+		})
+	})
+})
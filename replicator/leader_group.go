@@ -0,0 +1,155 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/choria-io/stream-replicator/election"
+)
+
+// leaderGroup is the election shared by all streams of a leader_group in this process
+type leaderGroup struct {
+	key     string
+	pool    *groupPool
+	leading bool
+	won     []func()
+	lost    []func()
+	mu      sync.Mutex
+}
+
+// groupPool balances the leadership of all leader groups of a candidate in this process
+type groupPool struct {
+	balancer *election.Balancer
+	groups   int
+}
+
+var (
+	leaderGroups   = map[string]*leaderGroup{}
+	groupPools     = map[string]*groupPool{}
+	leaderGroupsMu sync.Mutex
+)
+
+// groupPoolKey identifies the pool of the stream, elections are held in the source bucket
+func (s *Stream) groupPoolKey() string {
+	return fmt.Sprintf("%s|%s|%s", s.cfg.SourceServers(), s.sr.ReplicatorName, s.cfg.LeaderElectionName)
+}
+
+// joinLeaderGroup calls win and lost as the leadership of the leader group of the stream changes, the group election
+// is started by the first stream to join it and runs until its ctx is done
+func (s *Stream) joinLeaderGroup(ctx context.Context, win func(), lost func()) error {
+	poolKey := s.groupPoolKey()
+	key := fmt.Sprintf("%s|%s", poolKey, s.cfg.LeaderGroup)
+
+	leaderGroupsMu.Lock()
+	defer leaderGroupsMu.Unlock()
+
+	if g, ok := leaderGroups[key]; ok {
+		g.mu.Lock()
+		g.won = append(g.won, win)
+		g.lost = append(g.lost, lost)
+		leading := g.leading
+		g.mu.Unlock()
+
+		if leading {
+			win()
+		}
+
+		return nil
+	}
+
+	js, err := s.source.nc.JetStream()
+	if err != nil {
+		return err
+	}
+
+	kv, err := js.KeyValue("CHORIA_LEADER_ELECTION")
+	if err != nil {
+		return err
+	}
+
+	pool, ok := groupPools[poolKey]
+	if !ok {
+		host, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+
+		// replicators commonly share the leader_election_name so the host tells them apart
+		member := fmt.Sprintf("%s-%s", host, s.cfg.LeaderElectionName)
+		pool = &groupPool{balancer: election.NewBalancer(member, fmt.Sprintf("SR_%s_GROUPS", s.sr.ReplicatorName), kv)}
+		groupPools[poolKey] = pool
+	}
+	pool.groups++
+
+	g := &leaderGroup{key: key, pool: pool, won: []func(){win}, lost: []func(){lost}}
+
+	e, err := election.NewElection(s.cfg.LeaderElectionName, fmt.Sprintf("SR_%s_GROUP_%s", s.sr.ReplicatorName, s.cfg.LeaderGroup), kv,
+		election.WithReplicator(s.sr.ReplicatorName),
+		election.WithBackoff(s.elections),
+		election.WithWarnings(s.log.Warnf),
+		election.WithBalancer(pool.balancer),
+		election.OnWon(g.notifyWon),
+		election.OnLost(g.notifyLost))
+	if err != nil {
+		pool.groups--
+		return err
+	}
+
+	leaderGroups[key] = g
+
+	go func() {
+		err := e.Start(ctx)
+		if err != nil {
+			s.log.Errorf("Leader group %s election failed: %v", s.cfg.LeaderGroup, err)
+		}
+
+		g.leave()
+	}()
+
+	return nil
+}
+
+func (g *leaderGroup) notifyWon() {
+	g.mu.Lock()
+	g.leading = true
+	cbs := append([]func(){}, g.won...)
+	g.mu.Unlock()
+
+	for _, cb := range cbs {
+		cb()
+	}
+}
+
+func (g *leaderGroup) notifyLost() {
+	g.mu.Lock()
+	g.leading = false
+	cbs := append([]func(){}, g.lost...)
+	g.mu.Unlock()
+
+	for _, cb := range cbs {
+		cb()
+	}
+}
+
+// leave removes the group once its election stopped so streams started later start a new one
+func (g *leaderGroup) leave() {
+	leaderGroupsMu.Lock()
+	defer leaderGroupsMu.Unlock()
+
+	delete(leaderGroups, g.key)
+
+	g.pool.groups--
+	if g.pool.groups == 0 {
+		for key, pool := range groupPools {
+			if pool == g.pool {
+				delete(groupPools, key)
+			}
+		}
+	}
+}
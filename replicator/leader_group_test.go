@@ -0,0 +1,119 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/election"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Leader Groups", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+		election.SkipTTLValidateForTests()
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	It("Should lead groups together and balance them across candidates", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			js, err := nc.JetStream()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = js.CreateKeyValue(&nats.KeyValueConfig{Bucket: "CHORIA_LEADER_ELECTION", TTL: 2 * time.Second})
+			Expect(err).ToNot(HaveOccurred())
+
+			for _, name := range []string{"ORDERS", "INVOICES", "EVENTS"} {
+				_, err = mgr.NewStream(name, jsm.Subjects(name))
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			groups := map[string]string{"ORDERS": "billing", "INVOICES": "billing", "EVENTS": "telemetry"}
+
+			start := func(candidate string) map[string]*Stream {
+				streams := map[string]*Stream{}
+				sr := &config.Config{ReplicatorName: "GINKGO"}
+
+				for _, name := range []string{"ORDERS", "INVOICES", "EVENTS"} {
+					scfg := &config.Stream{
+						Name:               name,
+						Stream:             name,
+						TargetStream:       name + "_COPY",
+						TargetPrefix:       "copy",
+						SourceURL:          nc.ConnectedUrl(),
+						TargetURL:          nc.ConnectedUrl(),
+						LeaderElectionName: candidate,
+						LeaderGroup:        groups[name],
+					}
+
+					stream, err := NewStream(scfg, sr, log)
+					Expect(err).ToNot(HaveOccurred())
+					streams[name] = stream
+
+					wg.Add(1)
+					go func() {
+						defer GinkgoRecover()
+						Expect(stream.Run(ctx, &wg)).To(Succeed())
+					}()
+				}
+
+				return streams
+			}
+
+			leaders := func(streams map[string]*Stream) func() map[string]bool {
+				return func() map[string]bool {
+					res := map[string]bool{}
+					for name, s := range streams {
+						res[name] = s.Leading()
+					}
+					return res
+				}
+			}
+
+			a := start("a")
+			Eventually(leaders(a), "20s", "100ms").Should(Equal(map[string]bool{"ORDERS": true, "INVOICES": true, "EVENTS": true}))
+
+			b := start("b")
+			Eventually(func() int {
+				led := 0
+				for _, l := range []map[string]*Stream{a, b} {
+					if l["ORDERS"].Leading() != l["INVOICES"].Leading() {
+						return -1
+					}
+					if l["ORDERS"].Leading() && l["EVENTS"].Leading() {
+						return -1
+					}
+					if l["ORDERS"].Leading() || l["EVENTS"].Leading() {
+						led++
+					}
+				}
+				return led
+			}, "30s", "100ms").Should(Equal(2))
+		})
+	})
+})
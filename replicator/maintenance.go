@@ -0,0 +1,108 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/events"
+)
+
+// maintenanceWindows pauses and resumes replication as maintenance windows start and end until ctx is done,
+// next is when the first window starts or ends
+func (s *Stream) maintenanceWindows(ctx context.Context, wg *sync.WaitGroup, next time.Time) {
+	defer wg.Done()
+
+	for {
+		if next.IsZero() {
+			s.log.Warnf("No more maintenance windows are scheduled")
+			<-ctx.Done()
+			return
+		}
+
+		timer := s.clock.NewTimer(next.Sub(s.clock.Now()))
+
+		select {
+		case <-timer.C():
+			next = s.updateMaintenance()
+
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// updateMaintenance pauses or resumes replication based on the maintenance windows in progress now, returns
+// when the current windows end or the next one starts
+func (s *Stream) updateMaintenance() time.Time {
+	now := s.clock.Now()
+
+	var active bool
+	var next time.Time
+
+	for _, w := range s.cfg.Maintenance {
+		if in, end := w.Active(now); in {
+			if !active || end.After(next) {
+				next = end
+			}
+			active = true
+		}
+	}
+
+	if !active {
+		for _, w := range s.cfg.Maintenance {
+			start := w.Next(now)
+			if !start.IsZero() && (next.IsZero() || start.Before(next)) {
+				next = start
+			}
+		}
+	}
+
+	s.mu.Lock()
+	changed := s.maintenance != active
+	s.maintenance = active
+	if changed && s.advisor != nil {
+		if active {
+			s.advisor.Pause()
+		} else if !s.pausedLocked() {
+			s.advisor.Resume()
+		}
+	}
+	s.mu.Unlock()
+
+	if !changed {
+		return next
+	}
+
+	event := &events.Event{
+		Stream: s.cfg.Stream,
+		Name:   s.cfg.Name,
+		Data:   map[string]string{},
+	}
+
+	if active {
+		inMaintenance.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Set(1)
+		s.log.Warnf("Pausing replication for maintenance until %s", next.Format(time.RFC3339))
+		event.Event = events.MaintenanceStartedEvent
+		event.Message = fmt.Sprintf("Replication paused for maintenance until %s", next.Format(time.RFC3339))
+		event.Data["until"] = next.Format(time.RFC3339)
+	} else {
+		inMaintenance.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Set(0)
+		s.log.Warnf("Resuming replication after maintenance")
+		event.Event = events.MaintenanceEndedEvent
+		event.Message = "Replication resumed after maintenance"
+		if !next.IsZero() {
+			event.Data["next"] = next.Format(time.RFC3339)
+		}
+	}
+
+	s.events.Publish(event)
+
+	return next
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/clock"
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/events"
+	"github.com/choria-io/stream-replicator/internal/cron"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Maintenance", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+		mock   *clock.Mock
+		day    = time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+		mock = clock.NewMock(day.Add(time.Hour + 30*time.Minute))
+	})
+
+	AfterEach(func() {
+		cancel()
+		wg.Wait()
+	})
+
+	window := func(schedule string, d time.Duration) *config.Maintenance {
+		c, err := cron.Parse(schedule)
+		Expect(err).ToNot(HaveOccurred())
+
+		return &config.Maintenance{Schedule: schedule, Duration: d, Cron: c, Location: time.UTC}
+	}
+
+	newStream := func(nc *nats.Conn, windows ...*config.Maintenance) *Stream {
+		p := startEvents(ctx, &wg, nc, log)
+
+		scfg := &config.Stream{
+			Stream:      "TEST",
+			SourceURL:   nc.ConnectedUrl(),
+			TargetURL:   nc.ConnectedUrl(),
+			Maintenance: windows,
+		}
+
+		stream, err := NewStream(scfg, &config.Config{ReplicatorName: "GINKGO"}, log, WithEvents(p), WithClock(mock))
+		Expect(err).ToNot(HaveOccurred())
+
+		return stream
+	}
+
+	It("Should pause and resume replication during windows", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, _ *jsm.Manager) {
+			received := make(chan *nats.Msg, 10)
+			sub, err := nc.ChanSubscribe("events.>", received)
+			Expect(err).ToNot(HaveOccurred())
+			defer sub.Unsubscribe()
+
+			stream := newStream(nc, window("0 2 * * *", time.Hour))
+
+			next := stream.updateMaintenance()
+			Expect(next).To(Equal(day.Add(2 * time.Hour)))
+			Expect(stream.isPaused()).To(BeFalse())
+			Consistently(received, 100*time.Millisecond).ShouldNot(Receive())
+
+			wg.Add(1)
+			go stream.maintenanceWindows(ctx, &wg, next)
+
+			Eventually(mock.Timers).Should(Equal(1))
+			mock.Add(30 * time.Minute)
+
+			event := nextEvent(received)
+			Expect(event.Event).To(Equal(events.MaintenanceStartedEvent))
+			Expect(event.Stream).To(Equal("TEST"))
+			Expect(event.Data["until"]).To(Equal("2023-07-01T03:00:00Z"))
+			Expect(stream.isPaused()).To(BeTrue())
+
+			Eventually(mock.Timers).Should(Equal(1))
+			mock.Add(time.Hour)
+
+			event = nextEvent(received)
+			Expect(event.Event).To(Equal(events.MaintenanceEndedEvent))
+			Expect(event.Data["next"]).To(Equal("2023-07-02T02:00:00Z"))
+			Expect(stream.isPaused()).To(BeFalse())
+		})
+	})
+
+	It("Should pause at start when in a window and combine overlapping windows", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, _ *jsm.Manager) {
+			received := make(chan *nats.Msg, 10)
+			sub, err := nc.ChanSubscribe("events.>", received)
+			Expect(err).ToNot(HaveOccurred())
+			defer sub.Unsubscribe()
+
+			stream := newStream(nc, window("0 1 * * *", time.Hour), window("15 1 * * *", 2*time.Hour))
+
+			next := stream.updateMaintenance()
+			Expect(next).To(Equal(day.Add(3*time.Hour + 15*time.Minute)))
+			Expect(stream.isPaused()).To(BeTrue())
+
+			event := nextEvent(received)
+			Expect(event.Event).To(Equal(events.MaintenanceStartedEvent))
+			Expect(event.Data["until"]).To(Equal("2023-07-01T03:15:00Z"))
+
+			// streams that lost the leadership stay paused once maintenance ends
+			stream.paused = true
+			mock.Set(next)
+			Expect(stream.updateMaintenance()).To(Equal(day.Add(25 * time.Hour)))
+			Expect(stream.isPaused()).To(BeTrue())
+
+			event = nextEvent(received)
+			Expect(event.Event).To(Equal(events.MaintenanceEndedEvent))
+		})
+	})
+})
@@ -0,0 +1,86 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"sync/atomic"
+
+	"github.com/choria-io/stream-replicator/idtrack"
+	"github.com/nats-io/nats.go"
+)
+
+// memoryBudget estimates the memory used by in-flight messages and tracker state of a stream, copiers request fewer
+// messages while the limit is exceeded, a nil memoryBudget is never exceeded
+type memoryBudget struct {
+	limit    int64
+	inflight int64
+	tracker  *idtrack.Tracker
+}
+
+func newMemoryBudget(limit int64, tracker *idtrack.Tracker) *memoryBudget {
+	return &memoryBudget{limit: limit, tracker: tracker}
+}
+
+// acquire records that msg is in-flight and returns its size to later release
+func (b *memoryBudget) acquire(msg *nats.Msg) int64 {
+	if b == nil {
+		return 0
+	}
+
+	sz := messageSize(msg)
+	atomic.AddInt64(&b.inflight, sz)
+
+	return sz
+}
+
+// release records that a message of sz bytes is no longer in-flight
+func (b *memoryBudget) release(sz int64) {
+	if b == nil || sz == 0 {
+		return
+	}
+
+	atomic.AddInt64(&b.inflight, -sz)
+}
+
+// inFlight is the size of all in-flight messages
+func (b *memoryBudget) inFlight() int64 {
+	if b == nil {
+		return 0
+	}
+
+	return atomic.LoadInt64(&b.inflight)
+}
+
+// used is the estimated memory used by in-flight messages and tracker state
+func (b *memoryBudget) used() int64 {
+	if b == nil {
+		return 0
+	}
+
+	used := b.inFlight()
+	if b.tracker != nil {
+		used += b.tracker.StateSize()
+	}
+
+	return used
+}
+
+// exceeded determines if more memory than the limit is used
+func (b *memoryBudget) exceeded() bool {
+	return b != nil && b.used() > b.limit
+}
+
+// messageSize estimates the memory used by msg
+func messageSize(msg *nats.Msg) int64 {
+	sz := len(msg.Subject) + len(msg.Reply) + len(msg.Data)
+	for k, vals := range msg.Header {
+		sz += len(k)
+		for _, v := range vals {
+			sz += len(v)
+		}
+	}
+
+	return int64(sz)
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Memory Budget", func() {
+	It("Should never be exceeded when not set", func() {
+		var b *memoryBudget
+		Expect(b.acquire(nats.NewMsg("x"))).To(Equal(int64(0)))
+		b.release(10)
+		Expect(b.inFlight()).To(Equal(int64(0)))
+		Expect(b.exceeded()).To(BeFalse())
+	})
+
+	It("Should track in-flight messages", func() {
+		b := newMemoryBudget(20, nil)
+
+		msg := nats.NewMsg("x.y")
+		msg.Data = []byte("hello")
+		msg.Header.Add("Key", "value")
+		Expect(messageSize(msg)).To(Equal(int64(16)))
+
+		sz := b.acquire(msg)
+		Expect(sz).To(Equal(int64(16)))
+		Expect(b.exceeded()).To(BeFalse())
+
+		sz2 := b.acquire(msg)
+		Expect(b.inFlight()).To(Equal(int64(32)))
+		Expect(b.used()).To(Equal(int64(32)))
+		Expect(b.exceeded()).To(BeTrue())
+
+		b.release(sz)
+		Expect(b.exceeded()).To(BeFalse())
+		b.release(sz2)
+		Expect(b.inFlight()).To(Equal(int64(0)))
+	})
+})
@@ -0,0 +1,168 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/jsm.go/api"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// OriginHeader holds the stream and sequence, space separated, a message copied from a mirror or sourcing stream
+	// had in the stream it originated from
+	OriginHeader = "Choria-SR-Origin"
+
+	// streamSourceHeader is added by JetStream to messages a stream sourced from another, it holds the name of the
+	// origin stream and the sequence the message had in it
+	streamSourceHeader = "Nats-Stream-Source"
+)
+
+// streamOrigin describes the streams a mirror or sourcing source stream copies messages from
+type streamOrigin struct {
+	// mirror is the name of the stream the source mirrors, sequences of the source are those of the mirrored stream
+	mirror string
+	// sources are the streams the source sources, messages sourced from them carry a streamSourceHeader
+	sources []*api.StreamSource
+	// subjects are the subjects the target stream is created with to hold messages of the origins
+	subjects []string
+}
+
+// resolveOrigin records the streams the source copies from when its configuration scfg is a mirror or sources
+// other streams
+func (s *Stream) resolveOrigin(scfg api.StreamConfig) {
+	switch {
+	case scfg.Mirror != nil:
+		s.origin = &streamOrigin{mirror: scfg.Mirror.Name}
+		s.log.Infof("Source stream %s is a mirror of %s, copies record their sequence in %s in the %s header", s.cfg.Stream, scfg.Mirror.Name, scfg.Mirror.Name, OriginHeader)
+
+	case len(scfg.Sources) > 0:
+		s.origin = &streamOrigin{sources: scfg.Sources}
+		s.log.Infof("Source stream %s sources %d streams, copies record the stream and sequence they originate from in the %s header", s.cfg.Stream, len(scfg.Sources), OriginHeader)
+	}
+}
+
+// resolveOriginSubjects determines the subjects of the streams a mirror or sourcing source stream with configuration
+// scfg copies from, the target stream is created with them so it can hold their messages
+func (s *Stream) resolveOriginSubjects(scfg api.StreamConfig) error {
+	if s.origin == nil || s.cfg.NoTargetCreate {
+		return nil
+	}
+
+	origins := scfg.Sources
+	if scfg.Mirror != nil {
+		origins = []*api.StreamSource{scfg.Mirror}
+	}
+
+	// mirrors have no subjects and sourced messages keep the subjects of their origins
+	subjects := append([]string{}, scfg.Subjects...)
+	for _, o := range origins {
+		osubjects, err := s.originSubjects(o)
+		if err != nil {
+			return err
+		}
+
+		for _, subj := range osubjects {
+			if !containsString(subjects, subj) {
+				subjects = append(subjects, subj)
+			}
+		}
+	}
+	s.origin.subjects = subjects
+
+	return nil
+}
+
+// originSubjects are the subjects of messages copied from the stream o describes
+func (s *Stream) originSubjects(o *api.StreamSource) ([]string, error) {
+	if o.FilterSubject != _EMPTY_ {
+		return []string{o.FilterSubject}, nil
+	}
+
+	if o.External != nil {
+		return nil, fmt.Errorf("the subjects of %s in another account or domain can not be determined, create the target stream and set no_target_create", o.Name)
+	}
+
+	origin, err := s.source.mgr.LoadStream(o.Name)
+	if err != nil {
+		return nil, fmt.Errorf("could not load %s to determine the subjects of the target stream: %v", o.Name, err)
+	}
+	if len(origin.Subjects()) == 0 {
+		return nil, fmt.Errorf("the subjects of %s can not be determined as it has none, create the target stream and set no_target_create", o.Name)
+	}
+
+	return origin.Subjects(), nil
+}
+
+// checkTargetStream fails when the target stream can not receive copies, or would track its own sources incorrectly
+// when receiving them
+func (s *Stream) checkTargetStream() error {
+	if s.cfg.StreamTemplate != nil {
+		return nil
+	}
+
+	s.dest.mu.Lock()
+	stream := s.dest.stream
+	s.dest.mu.Unlock()
+
+	if stream == nil {
+		var err error
+		stream, err = s.dest.mgr.LoadStream(s.cfg.TargetStream)
+		if err != nil {
+			s.log.Debugf("Could not load target stream %s to check its configuration: %v", s.cfg.TargetStream, err)
+			return nil
+		}
+	}
+
+	if stream.IsMirror() {
+		return fmt.Errorf("target stream %s is a mirror of %s and can not receive copied messages", s.cfg.TargetStream, stream.Mirror().Name)
+	}
+
+	headers := s.cfg.JetStreamHeaders
+	if headers == nil {
+		headers = defaultJetStreamHeaders
+	}
+
+	// JetStream finds where to resume sourcing using the source headers of stored messages
+	if stream.IsSourced() && s.origin != nil && len(s.origin.sources) > 0 && headers.Source == config.HeadersPreserve {
+		return fmt.Errorf("target stream %s sources other streams and would resume them incorrectly from preserved %s headers, set jetstream_headers source to remap or strip", s.cfg.TargetStream, streamSourceHeader)
+	}
+
+	return nil
+}
+
+// setOriginHeader records the stream and sequence msg originated from in the OriginHeader when the source is a mirror
+// or msg was sourced from another stream, an existing OriginHeader set by an earlier copy is kept
+func (s *Stream) setOriginHeader(msg *nats.Msg) {
+	if s.origin == nil || msg.Header.Get(OriginHeader) != _EMPTY_ {
+		return
+	}
+
+	if src := msg.Header.Get(streamSourceHeader); src != _EMPTY_ {
+		// the stream name is indexed with a hash of the filter and external settings, like ORDERS:a1b2c3
+		parts := strings.Fields(src)
+		if len(parts) < 2 {
+			return
+		}
+		name, _, _ := strings.Cut(parts[0], ":")
+		msg.Header.Set(OriginHeader, name+" "+parts[1])
+		return
+	}
+
+	if s.origin.mirror == _EMPTY_ {
+		return
+	}
+
+	meta, err := jsm.ParseJSMsgMetadata(msg)
+	if err != nil {
+		return
+	}
+
+	msg.Header.Set(OriginHeader, fmt.Sprintf("%s %d", s.origin.mirror, meta.StreamSequence()))
+}
@@ -0,0 +1,220 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/events"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/jsm.go/api"
+	"github.com/sirupsen/logrus"
+)
+
+// monitorCopier copies nothing, it compares the source and target streams to report lag, gaps and configuration drift
+type monitorCopier struct {
+	s        *Stream
+	sr       *config.Config
+	cfg      *config.Stream
+	source   *Target
+	dest     *Target
+	interval time.Duration
+	log      *logrus.Entry
+
+	gaps  uint64
+	drift []string
+}
+
+// monitorResult is the outcome of comparing the source and target streams
+type monitorResult struct {
+	// lag is how many source messages are newer than the last one copied to the target
+	lag uint64
+	// lagTime is the time between the last source message and the last one copied to the target
+	lagTime time.Duration
+	// gaps is how many source messages up to the last copied one are missing from the target
+	gaps uint64
+	// drift describes stream settings that differ between the source and target
+	drift []string
+}
+
+func newMonitorCopier(s *Stream, log *logrus.Entry) *monitorCopier {
+	return &monitorCopier{
+		s:        s,
+		sr:       s.sr,
+		cfg:      s.cfg,
+		source:   s.source,
+		dest:     s.dest,
+		interval: s.cfg.MonitorInterval,
+		log:      log.WithField("copier", "monitor"),
+	}
+}
+
+func (c *monitorCopier) copyMessages(ctx context.Context) error {
+	if c.interval == 0 {
+		c.interval = 30 * time.Second
+	}
+
+	c.log.Infof("Starting monitor only comparison of %s and %s every %v, no messages will be copied", c.cfg.Stream, c.cfg.TargetStream, c.interval)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		if !c.s.isPaused() {
+			c.check()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// check compares the streams, updates metrics and publishes events when gaps grow or the drift changes
+func (c *monitorCopier) check() {
+	res, err := c.compare()
+	if err != nil {
+		c.log.Errorf("Could not compare streams: %v", err)
+		return
+	}
+
+	c.s.markActive()
+
+	monitorLagMessages.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Set(float64(res.lag))
+	monitorLagSeconds.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Set(res.lagTime.Seconds())
+	monitorGapMessages.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Set(float64(res.gaps))
+	monitorConfigDrift.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Set(float64(len(res.drift)))
+
+	c.log.Debugf("Target lags %d messages and %v behind with %d gaps and %d configuration differences", res.lag, res.lagTime, res.gaps, len(res.drift))
+
+	if res.gaps > c.gaps {
+		c.log.Warnf("Target is missing %d source messages", res.gaps)
+		c.s.events.Publish(&events.Event{
+			Event:   events.MonitorGapEvent,
+			Stream:  c.cfg.Stream,
+			Name:    c.cfg.Name,
+			Message: fmt.Sprintf("Target %s is missing %d messages copied from %s", c.cfg.TargetStream, res.gaps, c.cfg.Stream),
+			Data:    map[string]string{"gaps": strconv.FormatUint(res.gaps, 10), "lag": strconv.FormatUint(res.lag, 10)},
+		})
+	}
+	c.gaps = res.gaps
+
+	if strings.Join(res.drift, "\n") != strings.Join(c.drift, "\n") {
+		msg := fmt.Sprintf("Configuration of %s matches %s", c.cfg.TargetStream, c.cfg.Stream)
+		if len(res.drift) > 0 {
+			msg = fmt.Sprintf("Configuration of %s differs from %s: %s", c.cfg.TargetStream, c.cfg.Stream, strings.Join(res.drift, ", "))
+			c.log.Warn(msg)
+		}
+
+		c.s.events.Publish(&events.Event{
+			Event:   events.MonitorDriftEvent,
+			Stream:  c.cfg.Stream,
+			Name:    c.cfg.Name,
+			Message: msg,
+			Data:    map[string]string{"drift": strings.Join(res.drift, ", ")},
+		})
+	}
+	c.drift = res.drift
+}
+
+func (c *monitorCopier) compare() (*monitorResult, error) {
+	c.source.mu.Lock()
+	source, err := c.source.stream.LatestInformation()
+	c.source.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("could not load source stream: %v", err)
+	}
+
+	c.dest.mu.Lock()
+	target, err := c.dest.mgr.LoadStream(c.cfg.TargetStream)
+	c.dest.mu.Unlock()
+	if jsm.IsNatsError(err, 10059) {
+		return &monitorResult{lag: source.State.Msgs, drift: []string{"target stream does not exist"}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not load target stream: %v", err)
+	}
+
+	nfo, err := target.LatestInformation()
+	if err != nil {
+		return nil, fmt.Errorf("could not load target stream: %v", err)
+	}
+
+	res := &monitorResult{drift: configDrift(c.s.withTargetSettings(c.s.targetStreamConfig(source.Config)), nfo.Config)}
+
+	seq, ts, err := c.lastCopied(target, nfo.State)
+	if err != nil {
+		return nil, err
+	}
+
+	if seq == 0 {
+		res.lag = source.State.Msgs
+		return res, nil
+	}
+
+	if source.State.LastSeq > seq {
+		res.lag = source.State.LastSeq - seq
+	}
+	if !ts.IsZero() && source.State.LastTime.After(ts) {
+		res.lagTime = source.State.LastTime.Sub(ts)
+	}
+
+	// source messages up to the last copied one that the target does not hold
+	if seq >= source.State.FirstSeq && source.State.Msgs > res.lag {
+		expected := source.State.Msgs - res.lag
+		if expected > nfo.State.Msgs {
+			res.gaps = expected - nfo.State.Msgs
+		}
+	}
+
+	return res, nil
+}
+
+// lastCopied is the source sequence and time of the last message in the target, 0 when it holds no copied messages
+func (c *monitorCopier) lastCopied(target *jsm.Stream, state api.StreamState) (uint64, time.Time, error) {
+	if state.Msgs == 0 {
+		return 0, time.Time{}, nil
+	}
+
+	msg, err := target.ReadMessage(state.LastSeq)
+	if jsm.IsNatsError(err, 10037) {
+		return 0, time.Time{}, nil
+	}
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("could not read last target message: %v", err)
+	}
+	if len(msg.Header) == 0 {
+		return 0, time.Time{}, nil
+	}
+
+	hdrs, err := decodeHeadersMsg(msg.Header)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	parts := strings.Split(hdrs.Get(srcHeader), " ")
+	if len(parts) != 5 || parts[0] != c.cfg.Stream {
+		return 0, time.Time{}, nil
+	}
+
+	seq, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || seq < 0 {
+		return 0, time.Time{}, nil
+	}
+
+	ms, err := strconv.ParseInt(parts[4], 10, 64)
+	if err != nil || ms < 0 {
+		return uint64(seq), time.Time{}, nil
+	}
+
+	return uint64(seq), time.UnixMilli(ms), nil
+}
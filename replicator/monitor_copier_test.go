@@ -0,0 +1,164 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/events"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Monitor Copier", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	newStream := func(nc *nats.Conn, opts ...Option) *Stream {
+		scfg := &config.Stream{
+			Stream:          "TEST",
+			TargetStream:    "TEST_COPY",
+			TargetPrefix:    "copy",
+			SourceURL:       nc.ConnectedUrl(),
+			TargetURL:       nc.ConnectedUrl(),
+			MonitorOnly:     true,
+			MonitorInterval: 100 * time.Millisecond,
+		}
+
+		stream, err := NewStream(scfg, &config.Config{ReplicatorName: "GINKGO"}, log, opts...)
+		Expect(err).ToNot(HaveOccurred())
+
+		return stream
+	}
+
+	// publishes a message to the target as if copied from source sequence seq
+	publishCopy := func(nc *nats.Conn, seq int) {
+		msg := nats.NewMsg(fmt.Sprintf("copy.test.%d", seq))
+		msg.Data = []byte(fmt.Sprintf(`{"msg":%d}`, seq))
+		msg.Header.Add(srcHeader, srcHeaderValue("TEST", int64(seq), "GINKGO", "", time.Now().UnixMilli()))
+		_, err := nc.RequestMsg(msg, time.Second)
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	It("Should report lag, gaps and drift without copying", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			source, err := mgr.NewStream("TEST", jsm.Subjects("test.>"), jsm.MaxAge(time.Hour))
+			Expect(err).ToNot(HaveOccurred())
+
+			for i := 1; i <= 10; i++ {
+				_, err := nc.Request(fmt.Sprintf("test.%d", i), []byte(fmt.Sprintf(`{"msg":%d}`, i)), time.Second)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			stream := newStream(nc)
+			Expect(stream.connect(ctx)).To(Succeed())
+			c := newMonitorCopier(stream, log)
+
+			res, err := c.compare()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res.lag).To(Equal(uint64(10)))
+			Expect(res.drift).To(Equal([]string{"target stream does not exist"}))
+
+			_, err = mgr.NewStream("TEST_COPY", jsm.Subjects("copy.test.>"), jsm.MaxAge(30*time.Minute))
+			Expect(err).ToNot(HaveOccurred())
+
+			res, err = c.compare()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res.lag).To(Equal(uint64(10)))
+			Expect(res.gaps).To(BeZero())
+			Expect(res.drift).To(Equal([]string{"max_age: expected 1h0m0s found 30m0s"}))
+
+			for _, seq := range []int{1, 2, 4, 5} {
+				publishCopy(nc, seq)
+			}
+
+			res, err = c.compare()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res.lag).To(Equal(uint64(5)))
+			Expect(res.gaps).To(Equal(uint64(1)))
+
+			nfo, err := source.Information()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(nfo.State.Consumers).To(BeZero())
+		})
+	})
+
+	It("Should publish events for gaps and drift", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			received := make(chan *nats.Msg, 10)
+			sub, err := nc.ChanSubscribe("events.>", received)
+			Expect(err).ToNot(HaveOccurred())
+			defer sub.Unsubscribe()
+
+			p := startEvents(ctx, &wg, nc, log)
+
+			source, err := mgr.NewStream("TEST", jsm.Subjects("test.>"))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = mgr.NewStream("TEST_COPY", jsm.Subjects("copy.test.>"), jsm.DenyDelete())
+			Expect(err).ToNot(HaveOccurred())
+
+			for i := 1; i <= 3; i++ {
+				_, err := nc.Request(fmt.Sprintf("test.%d", i), nil, time.Second)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			publishCopy(nc, 1)
+			publishCopy(nc, 3)
+
+			stream := newStream(nc, WithEvents(p))
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				Expect(stream.Run(ctx, &wg)).To(Succeed())
+			}()
+
+			seen := map[events.EventType]*events.Event{}
+			for len(seen) < 2 {
+				var msg *nats.Msg
+				Eventually(received, 5*time.Second).Should(Receive(&msg))
+
+				event := &events.Event{}
+				Expect(json.Unmarshal(msg.Data, event)).To(Succeed())
+				seen[event.Event] = event
+			}
+
+			Expect(seen[events.MonitorGapEvent].Data["gaps"]).To(Equal("1"))
+			Expect(seen[events.MonitorDriftEvent].Data["drift"]).To(Equal("deny_delete: expected false found true"))
+
+			// nothing more is published while nothing changes
+			Consistently(received, 500*time.Millisecond).ShouldNot(Receive())
+			Expect(stream.LastActive()).To(BeTemporally("~", time.Now(), time.Second))
+
+			nfo, err := source.Information()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(nfo.State.Consumers).To(BeZero())
+		})
+	})
+})
@@ -0,0 +1,94 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/mqtt"
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+// MQTTTopicHeader holds the topic a message copied from a MQTT broker was published to
+const MQTTTopicHeader = "Choria-SR-MQTT-Topic"
+
+// mqttSource receives messages from MQTT subscriptions, messages are only acknowledged to the broker once copied
+type mqttSource struct {
+	cfg    *config.Stream
+	client mqtt.Client
+	log    *logrus.Entry
+}
+
+func newMQTTSource(s *Stream) *mqttSource {
+	return &mqttSource{
+		cfg: s.cfg,
+		log: s.log.WithFields(logrus.Fields{
+			"connection": "mqtt",
+			"client_id":  s.cfg.SourceMQTT.ClientID,
+		}),
+	}
+}
+
+func (m *mqttSource) Open(ctx context.Context) (err error) {
+	m.client, err = mqtt.Connect(ctx, m.cfg.SourceMQTT, m.cfg.SourceProxy, m.log)
+	if err != nil {
+		return fmt.Errorf("mqtt connection failed: %v", err)
+	}
+
+	return nil
+}
+
+func (m *mqttSource) Subjects() []string {
+	var subjects []string
+	for _, topic := range m.cfg.SourceMQTT.Topics {
+		subjects = append(subjects, mqtt.SubjectForTopic(topic))
+	}
+
+	return subjects
+}
+
+func (m *mqttSource) Next(ctx context.Context) (*SourceMessage, error) {
+	timer := time.NewTimer(livenessInterval)
+	defer timer.Stop()
+
+	select {
+	case pub := <-m.client.Messages():
+		subject := mqtt.SubjectForTopic(pub.Topic)
+
+		msg := nats.NewMsg(subject)
+		msg.Data = pub.Payload
+		for k, vals := range pub.Properties {
+			for _, v := range vals {
+				msg.Header.Add(k, v)
+			}
+		}
+		msg.Header.Set(MQTTTopicHeader, pub.Topic)
+
+		return &SourceMessage{Msg: msg, Source: subject, Sequence: -1, Ack: pub.Ack}, nil
+
+	case <-timer.C:
+		return nil, nil
+
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (m *mqttSource) Close() error {
+	if m.client == nil {
+		return nil
+	}
+
+	err := m.client.Close()
+	if err != nil {
+		return fmt.Errorf("could not disconnect from the MQTT broker: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/eclipse/paho.golang/paho"
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/rs/zerolog"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("MQTT Source Copier", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+		broker *mochi.Server
+		addr   string
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		addr = l.Addr().String()
+		l.Close()
+
+		blog := zerolog.New(GinkgoWriter)
+		broker = mochi.New(&mochi.Options{Logger: &blog})
+		Expect(broker.AddHook(new(auth.AllowHook), nil)).To(Succeed())
+		Expect(broker.AddListener(listeners.NewTCP("t1", addr, nil))).To(Succeed())
+		Expect(broker.Serve()).To(Succeed())
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+			broker.Close()
+		})
+	})
+
+	run := func(scfg *config.Stream) {
+		cfg := &config.Config{ReplicatorName: "GINKGO", StateDirectory: GinkgoT().TempDir(), Streams: []*config.Stream{scfg}}
+		Expect(cfg.Validate()).To(Succeed())
+
+		stream, err := NewStream(scfg, cfg, log)
+		Expect(err).ToNot(HaveOccurred())
+
+		wg.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+		}()
+
+		Eventually(func() int {
+			return len(broker.Topics.Subscribers("sensors/room1/temp").Subscriptions)
+		}, "10s").Should(Equal(1))
+	}
+
+	streamMessages := func(mgr *jsm.Manager) func() (uint64, error) {
+		return func() (uint64, error) {
+			stream, err := mgr.LoadStream("SENSORS")
+			if err != nil {
+				return 0, err
+			}
+			nfo, err := stream.State()
+			if err != nil {
+				return 0, err
+			}
+			return nfo.Msgs, nil
+		}
+	}
+
+	for _, version := range []string{config.MQTTVersion311, config.MQTTVersion5} {
+		version := version
+
+		It(fmt.Sprintf("Should copy messages using MQTT %s", version), func() {
+			testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+				run(&config.Stream{
+					Stream:       "SENSORS",
+					TargetPrefix: "mqtt",
+					TargetURL:    nc.ConnectedUrl(),
+					SourceMQTT:   &config.MQTT{URL: "tcp://" + addr, Topics: []string{"sensors/#"}, Version: version},
+				})
+
+				conn, err := net.Dial("tcp", addr)
+				Expect(err).ToNot(HaveOccurred())
+				publisher := paho.NewClient(paho.ClientConfig{ClientID: "publisher", Conn: conn})
+				_, err = publisher.Connect(ctx, &paho.Connect{ClientID: "publisher", CleanStart: true, KeepAlive: 30})
+				Expect(err).ToNot(HaveOccurred())
+				defer publisher.Disconnect(&paho.Disconnect{})
+
+				for i := 1; i <= 5; i++ {
+					_, err = publisher.Publish(ctx, &paho.Publish{
+						Topic:      "sensors/room1/temp",
+						QoS:        1,
+						Payload:    []byte(fmt.Sprintf("%d", 20+i)),
+						Properties: &paho.PublishProperties{User: paho.UserProperties{{Key: "unit", Value: "C"}}},
+					})
+					Expect(err).ToNot(HaveOccurred())
+				}
+
+				Eventually(streamMessages(mgr), "10s").Should(BeNumerically("==", 5))
+
+				stream, err := mgr.LoadStream("SENSORS")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(stream.Subjects()).To(Equal([]string{"mqtt.sensors.>"}))
+
+				msg, err := stream.ReadMessage(1)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(msg.Subject).To(Equal("mqtt.sensors.room1.temp"))
+				Expect(string(msg.Data)).To(Equal("21"))
+
+				hdrs, err := decodeHeadersMsg(msg.Header)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(hdrs.Get(MQTTTopicHeader)).To(Equal("sensors/room1/temp"))
+				Expect(hdrs.Get(srcHeader)).To(HavePrefix("sensors.room1.temp -1 GINKGO"))
+				if version == config.MQTTVersion5 {
+					Expect(hdrs.Get("unit")).To(Equal("C"))
+				} else {
+					Expect(hdrs.Get("unit")).To(BeEmpty())
+				}
+			})
+		})
+	}
+})
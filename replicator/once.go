@@ -0,0 +1,132 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OnceSummary reports on a stream copied up to the end of its source using RunOnce
+type OnceSummary struct {
+	// Stream is the source stream
+	Stream string `json:"stream"`
+	// Name is the name of the stream configuration
+	Name string `json:"name"`
+	// TargetStream is the stream messages are copied to
+	TargetStream string `json:"target_stream"`
+	// Tail is the last message in the source when copying started
+	Tail uint64 `json:"tail"`
+	// SourceSequence is the last source message that was copied or skipped
+	SourceSequence uint64 `json:"source_sequence"`
+	// Copied is how many messages were copied
+	Copied int64 `json:"copied"`
+	// Skipped is how many messages were not copied, like when filtered or sampled
+	Skipped int64 `json:"skipped"`
+	// Failed is how many times copying a message failed, failed messages are retried
+	Failed int64 `json:"failed"`
+	// Duration is how long copying took
+	Duration time.Duration `json:"duration"`
+	// Error is why copying did not complete
+	Error string `json:"error,omitempty"`
+}
+
+// RunOnce runs the stream until all messages in the source when copying started are copied or skipped and then stops
+// it, ctx limits how long copying can take
+func (s *Stream) RunOnce(ctx context.Context) (*OnceSummary, error) {
+	start := time.Now()
+	summary := &OnceSummary{
+		Stream:       s.cfg.Stream,
+		Name:         s.cfg.Name,
+		TargetStream: s.cfg.TargetStream,
+	}
+
+	rctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	wg := &sync.WaitGroup{}
+	done := make(chan error, 1)
+
+	wg.Add(1)
+	go func() { done <- s.Run(rctx, wg) }()
+
+	// stops the stream and records how far it got
+	finish := func(err error) (*OnceSummary, error) {
+		cancel()
+		wg.Wait()
+
+		if c, ok := s.copier.(*sourceInitiatedCopier); ok {
+			summary.Copied = atomic.LoadInt64(&c.copied)
+			summary.Skipped = atomic.LoadInt64(&c.skipped)
+			summary.Failed = atomic.LoadInt64(&c.failed)
+		}
+		summary.Duration = time.Since(start)
+
+		if err != nil {
+			summary.Error = err.Error()
+		}
+
+		return summary, err
+	}
+
+	select {
+	case <-s.ready:
+	case err := <-done:
+		if err == nil {
+			err = fmt.Errorf("stream %s stopped before copying", s.cfg.Stream)
+		}
+		return finish(err)
+	case <-ctx.Done():
+		return finish(fmt.Errorf("stream %s did not start: %v", s.cfg.Stream, ctx.Err()))
+	}
+
+	if _, ok := s.copier.(*sourceInitiatedCopier); !ok {
+		return finish(fmt.Errorf("stream %s can not be copied once, only streams copying messages one at a time from a NATS Stream can", s.cfg.Stream))
+	}
+
+	nfo, err := s.source.stream.State()
+	if err != nil {
+		return finish(fmt.Errorf("could not load the source stream state: %v", err))
+	}
+	summary.Tail = nfo.LastSeq
+
+	ticker := time.NewTicker(quiesceInterval)
+	defer ticker.Stop()
+
+	for {
+		s.source.mu.Lock()
+		consumer := s.source.consumer
+		s.source.mu.Unlock()
+
+		// the consumer is created once copying starts, standby replicators wait till they lead
+		if consumer != nil {
+			nfo, err := consumer.State()
+			if err != nil {
+				return finish(fmt.Errorf("could not load the source consumer state: %v", err))
+			}
+
+			// filtered consumers have nothing pending once the last matching message is acknowledged
+			if nfo.NumAckPending == 0 && (nfo.AckFloor.Stream >= summary.Tail || nfo.NumPending == 0) {
+				summary.SourceSequence = nfo.AckFloor.Stream
+				s.log.Infof("Copied messages up to source sequence %d, stopping", summary.SourceSequence)
+				return finish(nil)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case err := <-done:
+			if err == nil {
+				err = fmt.Errorf("stream %s stopped before copying all messages", s.cfg.Stream)
+			}
+			return finish(err)
+		case <-ctx.Done():
+			return finish(fmt.Errorf("stream %s did not copy all messages: %v", s.cfg.Stream, ctx.Err()))
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Run Once", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		log    *logrus.Entry
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		DeferCleanup(cancel)
+	})
+
+	publish := func(nc *nats.Conn, subject string, count int) {
+		for i := 0; i < count; i++ {
+			_, err := nc.Request(subject, []byte("x"), time.Second)
+			Expect(err).ToNot(HaveOccurred())
+		}
+	}
+
+	stream := func(scfg *config.Stream) *Stream {
+		cfg := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+		Expect(cfg.Validate()).To(Succeed())
+
+		stream, err := NewStream(scfg, cfg, log)
+		Expect(err).ToNot(HaveOccurred())
+
+		return stream
+	}
+
+	It("Should copy up to the end of the source and stop", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("TEST")
+			Expect(err).ToNot(HaveOccurred())
+			publish(nc, "TEST", 10)
+
+			s := stream(&config.Stream{
+				Stream:       "TEST",
+				TargetStream: "COPY",
+				TargetPrefix: "copy",
+				SourceURL:    nc.ConnectedUrl(),
+				TargetURL:    nc.ConnectedUrl(),
+			})
+
+			summary, err := s.RunOnce(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(summary.Error).To(BeEmpty())
+			Expect(summary.Tail).To(Equal(uint64(10)))
+			Expect(summary.SourceSequence).To(Equal(uint64(10)))
+			Expect(summary.Copied).To(Equal(int64(10)))
+			Expect(summary.Failed).To(Equal(int64(0)))
+
+			target, err := mgr.LoadStream("COPY")
+			Expect(err).ToNot(HaveOccurred())
+			nfo, err := target.State()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(nfo.Msgs).To(Equal(uint64(10)))
+
+			// the copier stopped so later messages are not copied
+			publish(nc, "TEST", 1)
+			Consistently(func() (uint64, error) {
+				nfo, err := target.State()
+				return nfo.Msgs, err
+			}, 500*time.Millisecond).Should(Equal(uint64(10)))
+		})
+	})
+
+	It("Should stop when filtered messages are at the end of the source", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("TEST", jsm.Subjects("TEST.>"))
+			Expect(err).ToNot(HaveOccurred())
+			publish(nc, "TEST.orders", 5)
+			publish(nc, "TEST.other", 5)
+
+			s := stream(&config.Stream{
+				Stream:        "TEST",
+				TargetStream:  "COPY",
+				TargetPrefix:  "copy",
+				FilterSubject: "TEST.orders",
+				SourceURL:     nc.ConnectedUrl(),
+				TargetURL:     nc.ConnectedUrl(),
+			})
+
+			summary, err := s.RunOnce(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(summary.Tail).To(Equal(uint64(10)))
+			Expect(summary.SourceSequence).To(Equal(uint64(5)))
+			Expect(summary.Copied).To(Equal(int64(5)))
+		})
+	})
+
+	It("Should fail when copying does not complete in time", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			s := stream(&config.Stream{
+				Stream:    "MISSING",
+				SourceURL: nc.ConnectedUrl(),
+				TargetURL: nc.ConnectedUrl(),
+			})
+
+			tctx, tcancel := context.WithTimeout(ctx, 500*time.Millisecond)
+			defer tcancel()
+
+			summary, err := s.RunOnce(tctx)
+			Expect(err).To(HaveOccurred())
+			Expect(summary.Error).To(Equal(err.Error()))
+		})
+	})
+})
@@ -0,0 +1,35 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"github.com/choria-io/stream-replicator/clock"
+	"github.com/choria-io/stream-replicator/events"
+)
+
+// Option configures optional behavior of a Stream
+type Option func(s *Stream)
+
+// WithEvents publishes operational events, like credential rotations, using p
+func WithEvents(p *events.Publisher) Option {
+	return func(s *Stream) { s.events = p }
+}
+
+// WithPublisher copies messages to p in place of the configured target, only streams with a source_url or
+// source_file that are not target initiated support publishers
+func WithPublisher(p Publisher) Option {
+	return func(s *Stream) { s.publisher = p }
+}
+
+// WithSource copies messages read from src in place of a configured source, streams using sources can not set
+// source_url, source_kafka, source_mqtt, source_archive or source_file and can not be target initiated
+func WithSource(src Source) Option {
+	return func(s *Stream) { s.messages = src }
+}
+
+// WithClock sets the clock used to schedule maintenance windows, defaults to real time
+func WithClock(c clock.Clock) Option {
+	return func(s *Stream) { s.clock = c }
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/events"
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+// orderingEventInterval is how often ordering violation events are published at most
+const orderingEventInterval = time.Minute
+
+// orderingVerifier watches the target stream and checks that per subject the source sequences of copied messages only
+// increase
+type orderingVerifier struct {
+	s    *Stream
+	last map[string]uint64
+	log  *logrus.Entry
+
+	// violations seen since the last event
+	violations int
+	published  time.Time
+
+	mu sync.Mutex
+}
+
+func newOrderingVerifier(s *Stream) *orderingVerifier {
+	return &orderingVerifier{
+		s:    s,
+		last: make(map[string]uint64),
+		log:  s.log.WithField("verify", "ordering"),
+	}
+}
+
+// start watches messages arriving in the target stream from now on until ctx is done
+func (o *orderingVerifier) start(ctx context.Context) error {
+	o.s.dest.mu.Lock()
+	nc := o.s.dest.nc
+	o.s.dest.mu.Unlock()
+
+	js, err := nc.JetStream()
+	if err != nil {
+		return err
+	}
+
+	sub, err := js.Subscribe(_EMPTY_, func(msg *nats.Msg) {
+		o.check(msg.Subject, msg.Header)
+	}, nats.BindStream(o.s.cfg.TargetStream), nats.OrderedConsumer(), nats.DeliverNew(), nats.Description(fmt.Sprintf("%s ordering verification for %s", ConsumerDescriptionPrefix, o.s.cfg.Stream)))
+	if err != nil {
+		return fmt.Errorf("could not watch target stream %s: %v", o.s.cfg.TargetStream, err)
+	}
+
+	o.log.Infof("Verifying the order of messages copied to %s", o.s.cfg.TargetStream)
+
+	go func() {
+		<-ctx.Done()
+		sub.Unsubscribe()
+	}()
+
+	return nil
+}
+
+// reset forgets the sequences copied from this stream, its source sequences start over once the source stream is recreated
+func (o *orderingVerifier) reset() {
+	prefix := strings.Join([]string{o.s.cfg.Stream, o.s.sr.ReplicatorName, o.s.cfg.Name}, " ") + " "
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for key := range o.last {
+		if strings.HasPrefix(key, prefix) {
+			delete(o.last, key)
+		}
+	}
+}
+
+// check records the source sequence of a message stored on subject in the target, it returns false when the message
+// arrived out of order
+func (o *orderingVerifier) check(subject string, hdrs nats.Header) bool {
+	if hdrs == nil {
+		return true
+	}
+
+	parts := strings.Split(hdrs.Get(srcHeader), " ")
+	if len(parts) != 5 {
+		return true
+	}
+
+	seq, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || seq < 0 {
+		return true
+	}
+
+	// messages from every source stream, replicator and stream configuration are ordered independently
+	key := strings.Join([]string{parts[0], parts[2], parts[3], subject}, " ")
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	last, seen := o.last[key]
+	if !seen || uint64(seq) >= last {
+		o.last[key] = uint64(seq)
+		return true
+	}
+
+	// other replicators in a failover group watch the same target, only the leader reports
+	if !o.s.Leading() {
+		return false
+	}
+
+	o.violations++
+	orderingViolationCount.WithLabelValues(o.s.cfg.Stream, o.s.sr.ReplicatorName, o.s.cfg.Name).Inc()
+	o.log.Warnf("Message from %s sequence %d arrived on %s after sequence %d", parts[0], seq, subject, last)
+
+	if time.Since(o.published) >= orderingEventInterval {
+		o.s.events.Publish(&events.Event{
+			Event:   events.OrderingViolationEvent,
+			Stream:  o.s.cfg.Stream,
+			Name:    o.s.cfg.Name,
+			Message: fmt.Sprintf("Message from %s sequence %d arrived on %s after sequence %d", parts[0], seq, subject, last),
+			Data: map[string]string{
+				"subject":    subject,
+				"sequence":   strconv.FormatInt(seq, 10),
+				"previous":   strconv.FormatUint(last, 10),
+				"violations": strconv.Itoa(o.violations),
+			},
+		})
+		o.published = time.Now()
+		o.violations = 0
+	}
+
+	return false
+}
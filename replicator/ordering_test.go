@@ -0,0 +1,106 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/events"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Ordering verification", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	// publishes a message to the target as if copied from source sequence seq of stream
+	publishCopy := func(nc *nats.Conn, subject string, stream string, seq int) {
+		msg := nats.NewMsg(subject)
+		msg.Header.Add(srcHeader, srcHeaderValue(stream, int64(seq), "GINKGO", "ORDER", time.Now().UnixMilli()))
+		_, err := nc.RequestMsg(msg, time.Second)
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	It("Should report messages arriving out of order", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			received := make(chan *nats.Msg, 10)
+			sub, err := nc.ChanSubscribe("events.ordering_violation", received)
+			Expect(err).ToNot(HaveOccurred())
+			defer sub.Unsubscribe()
+
+			p := startEvents(ctx, &wg, nc, log)
+
+			_, err = mgr.NewStream("TEST")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = mgr.NewStream("TEST_COPY", jsm.Subjects("copy.>"))
+			Expect(err).ToNot(HaveOccurred())
+
+			scfg := &config.Stream{
+				Name:           "ORDER",
+				Stream:         "TEST",
+				TargetStream:   "TEST_COPY",
+				TargetPrefix:   "copy",
+				SourceURL:      nc.ConnectedUrl(),
+				TargetURL:      nc.ConnectedUrl(),
+				VerifyOrdering: true,
+			}
+			stream, err := NewStream(scfg, &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}, log, WithEvents(p))
+			Expect(err).ToNot(HaveOccurred())
+
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				Expect(stream.Run(ctx, &wg)).To(Succeed())
+			}()
+			Eventually(stream.Ready(), 5*time.Second).Should(BeClosed())
+
+			// subjects and source streams are ordered independently
+			publishCopy(nc, "copy.a", "TEST", 10)
+			publishCopy(nc, "copy.b", "TEST", 5)
+			publishCopy(nc, "copy.a", "OTHER", 1)
+			publishCopy(nc, "copy.a", "TEST", 11)
+			Consistently(received, 500*time.Millisecond).ShouldNot(Receive())
+			Expect(getPromCountValue(orderingViolationCount, "TEST", "GINKGO", "ORDER")).To(BeZero())
+
+			publishCopy(nc, "copy.a", "TEST", 9)
+
+			event := nextEvent(received)
+			Expect(event.Event).To(Equal(events.OrderingViolationEvent))
+			Expect(event.Data).To(Equal(map[string]string{"subject": "copy.a", "sequence": "9", "previous": "11", "violations": "1"}))
+
+			// further violations are counted but not announced again within the event interval
+			publishCopy(nc, "copy.b", "TEST", 4)
+			Eventually(func() float64 {
+				return getPromCountValue(orderingViolationCount, "TEST", "GINKGO", "ORDER")
+			}).Should(Equal(2.0))
+			Consistently(received, 500*time.Millisecond).ShouldNot(Receive())
+		})
+	})
+})
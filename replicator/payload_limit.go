@@ -0,0 +1,53 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/nats-io/nats.go"
+)
+
+// TruncatedHeader holds the original payload size of messages truncated to max_payload
+const TruncatedHeader = "Choria-SR-Truncated"
+
+// limitPayload applies max_payload to msg, ok is false when msg should not be copied. Failing to dead letter a message
+// is an error so the message is retried
+func (s *Stream) limitPayload(ctx context.Context, msg *nats.Msg) (ok bool, err error) {
+	limit := s.cfg.MaxPayload
+	if limit == nil || len(msg.Data) <= limit.Bytes {
+		return true, nil
+	}
+
+	size := len(msg.Data)
+	oversizedCount.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Inc()
+
+	switch limit.Action {
+	case config.PayloadTruncate:
+		if msg.Header == nil {
+			msg.Header = nats.Header{}
+		}
+		msg.Header.Set(TruncatedHeader, strconv.Itoa(size))
+		msg.Data = msg.Data[:limit.Bytes]
+
+		return true, nil
+
+	case config.PayloadDeadLetter:
+		err = s.deadLetter(ctx, limit.DeadLetterSubject, msg, fmt.Errorf("payload of %d bytes exceeds max_payload of %d bytes", size, limit.Bytes))
+		if err != nil {
+			return false, fmt.Errorf("could not dead letter oversized message: %v", err)
+		}
+
+		s.log.Warnf("Dead lettered message on %s with a payload of %d bytes", msg.Subject, size)
+		return false, nil
+
+	default:
+		s.log.Warnf("Dropping message on %s with a payload of %d bytes", msg.Subject, size)
+		return false, nil
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/choria-io/stream-replicator/replicator/replicatortest"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Max Payload", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	// copies a small, a large and a small message using limit and returns the copied messages
+	copyMessages := func(nc *nats.Conn, mgr *jsm.Manager, name string, limit *config.MaxPayload, expect int) []*nats.Msg {
+		_, err := mgr.NewStream("ORDERS", jsm.Subjects("ORDERS.>"))
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, body := range []string{"small", strings.Repeat("x", 100), "small"} {
+			_, err = nc.Request("ORDERS.new", []byte(body), time.Second)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		scfg := &config.Stream{Name: name, Stream: "ORDERS", SourceURL: nc.ConnectedUrl(), MaxPayload: limit}
+		sr := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+		Expect(sr.Validate()).To(Succeed())
+
+		pub := replicatortest.NewPublisher()
+		stream, err := NewStream(scfg, sr, log, WithPublisher(pub))
+		Expect(err).ToNot(HaveOccurred())
+
+		wg.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+		}()
+
+		Expect(pub.WaitForMessages(ctx, expect)).To(Succeed())
+		Eventually(func() float64 { return getPromCountValue(oversizedCount, "ORDERS", "GINKGO", name) }).Should(Equal(1.0))
+
+		return pub.Messages()
+	}
+
+	It("Should drop oversized messages", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			msgs := copyMessages(nc, mgr, "DROP", &config.MaxPayload{Bytes: 10}, 2)
+			Expect(msgs).To(HaveLen(2))
+			Expect(msgs[0].Header.Get(srcHeader)).To(HavePrefix("ORDERS 1 GINKGO"))
+			Expect(msgs[1].Header.Get(srcHeader)).To(HavePrefix("ORDERS 3 GINKGO"))
+		})
+	})
+
+	It("Should truncate oversized messages", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			msgs := copyMessages(nc, mgr, "TRUNCATE", &config.MaxPayload{Bytes: 10, Action: config.PayloadTruncate}, 3)
+			Expect(msgs).To(HaveLen(3))
+			Expect(msgs[0].Header.Get(TruncatedHeader)).To(BeEmpty())
+			Expect(string(msgs[1].Data)).To(Equal(strings.Repeat("x", 10)))
+			Expect(msgs[1].Header.Get(TruncatedHeader)).To(Equal("100"))
+			Expect(string(msgs[2].Data)).To(Equal("small"))
+		})
+	})
+
+	It("Should dead letter oversized messages", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			dlq, err := mgr.NewStream("DLQ", jsm.Subjects("dlq.orders"))
+			Expect(err).ToNot(HaveOccurred())
+
+			msgs := copyMessages(nc, mgr, "DEAD_LETTER", &config.MaxPayload{Bytes: 10, Action: config.PayloadDeadLetter, DeadLetterSubject: "dlq.orders"}, 2)
+			Expect(msgs).To(HaveLen(2))
+
+			Eventually(func() (uint64, error) {
+				nfo, err := dlq.State()
+				return nfo.Msgs, err
+			}).Should(Equal(uint64(1)))
+
+			msg, err := dlq.ReadMessage(1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(msg.Data)).To(Equal(strings.Repeat("x", 100)))
+
+			hdrs, err := decodeHeadersMsg(msg.Header)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hdrs.Get(DeadLetterSubjectHeader)).To(Equal("ORDERS.new"))
+			Expect(hdrs.Get(DeadLetterReasonHeader)).To(Equal("payload of 100 bytes exceeds max_payload of 10 bytes"))
+		})
+	})
+})
@@ -0,0 +1,63 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+var (
+	// headerPool holds headers of copied messages for reuse by later messages
+	headerPool = sync.Pool{New: func() any { return nats.Header{} }}
+
+	// bufferPool holds buffers used to format header values
+	bufferPool = sync.Pool{New: func() any { b := make([]byte, 0, 128); return &b }}
+)
+
+// messageHeader sets the header of msg from the pool when it has none
+func messageHeader(msg *nats.Msg) {
+	if msg.Header == nil {
+		msg.Header = headerPool.Get().(nats.Header)
+	}
+}
+
+// releaseHeader clears the header of msg and returns it to the pool, msg must not be used once released
+func releaseHeader(msg *nats.Msg) {
+	if msg.Header == nil {
+		return
+	}
+
+	for k := range msg.Header {
+		delete(msg.Header, k)
+	}
+	headerPool.Put(msg.Header)
+	msg.Header = nil
+}
+
+// srcHeaderValue formats the value of the srcHeader as space separated fields without the allocations of fmt
+func srcHeaderValue(stream string, seq int64, replicator string, name string, ts int64) string {
+	bp := bufferPool.Get().(*[]byte)
+	b := (*bp)[:0]
+
+	b = append(b, stream...)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, seq, 10)
+	b = append(b, ' ')
+	b = append(b, replicator...)
+	b = append(b, ' ')
+	b = append(b, name...)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, ts, 10)
+
+	v := string(b)
+
+	*bp = b
+	bufferPool.Put(bp)
+
+	return v
+}
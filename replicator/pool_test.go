@@ -0,0 +1,43 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Pools", func() {
+	Describe("srcHeaderValue", func() {
+		It("Should format the source header", func() {
+			Expect(srcHeaderValue("ORDERS", 10, "GINKGO", "copy", 1688472000000)).To(Equal("ORDERS 10 GINKGO copy 1688472000000"))
+			Expect(srcHeaderValue("ORDERS", -1, "GINKGO", "copy", -1)).To(Equal("ORDERS -1 GINKGO copy -1"))
+		})
+	})
+
+	Describe("releaseHeader", func() {
+		It("Should clear released headers", func() {
+			msg := nats.NewMsg("x")
+			messageHeader(msg)
+			Expect(msg.Header).ToNot(BeNil())
+			msg.Header.Add(srcHeader, "x")
+
+			releaseHeader(msg)
+			Expect(msg.Header).To(BeNil())
+
+			for i := 0; i < 10; i++ {
+				msg := nats.NewMsg("x")
+				messageHeader(msg)
+				Expect(msg.Header).To(BeEmpty())
+			}
+
+			msg = nats.NewMsg("x")
+			msg.Header = nats.Header{"Existing": []string{"1"}}
+			messageHeader(msg)
+			Expect(msg.Header.Get("Existing")).To(Equal("1"))
+		})
+	})
+})
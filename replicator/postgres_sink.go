@@ -0,0 +1,211 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/util"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+// postgresColumns are the columns of the table messages are copied into
+var postgresColumns = []string{"stream", "subject", "sequence", "time", "headers", "data", "raw"}
+
+// postgresStagingTable is the temporary table batches are copied into before being inserted into the table
+var postgresStagingTable = pgx.Identifier{"stream_replicator_staging"}
+
+// postgresUniqueKeyQuery determines if the table has a unique key on the stream and sequence columns
+const postgresUniqueKeyQuery = `SELECT EXISTS (SELECT 1 FROM pg_index i WHERE i.indrelid = $1::regclass AND i.indisunique AND i.indpred IS NULL AND ARRAY(SELECT a.attname::text FROM pg_attribute a WHERE a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey) ORDER BY a.attname) = ARRAY['sequence', 'stream'])`
+
+// postgresSink copies batches of messages into a PostgreSQL table
+type postgresSink struct {
+	cfg     *config.Postgres
+	pool    *pgxpool.Pool
+	table   pgx.Identifier
+	stream  string
+	created bool
+	mu      sync.Mutex
+	log     *logrus.Entry
+}
+
+func (s *Stream) connectPostgres(ctx context.Context) error {
+	pcfg := s.cfg.TargetPostgres
+
+	pgcfg, err := pgxpool.ParseConfig(pcfg.URL)
+	if err != nil {
+		return fmt.Errorf("postgres target setup failed: %v", err)
+	}
+
+	if s.cfg.TargetProxy != _EMPTY_ {
+		pgcfg.ConnConfig.DialFunc, err = util.ProxyDialer(s.cfg.TargetProxy, 30*time.Second)
+		if err != nil {
+			return fmt.Errorf("postgres target setup failed: %v", err)
+		}
+	}
+
+	pgcfg.MaxConns = int32(pcfg.Concurrency)
+
+	pool, err := pgxpool.NewWithConfig(ctx, pgcfg)
+	if err != nil {
+		return fmt.Errorf("postgres target setup failed: %v", err)
+	}
+
+	log := s.log.WithField("connection", "postgres")
+	log.Infof("Copying messages into table %s on %s", pcfg.Table, pgcfg.ConnConfig.Host)
+
+	sink := &postgresSink{
+		cfg:    pcfg,
+		pool:   pool,
+		table:  pgx.Identifier(strings.Split(pcfg.Table, ".")),
+		stream: s.cfg.Stream,
+		log:    log,
+	}
+
+	if !pcfg.CreateTable {
+		err = sink.checkUniqueKey(ctx)
+		if err != nil {
+			pool.Close()
+			return fmt.Errorf("postgres target setup failed: %v", err)
+		}
+	}
+
+	s.batchSink = sink
+	s.delivery = &pcfg.Delivery
+
+	return nil
+}
+
+// createTable creates the table once when configured to do so
+func (p *postgresSink) createTable(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.cfg.CreateTable || p.created {
+		return nil
+	}
+
+	_, err := p.pool.Exec(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+  stream text NOT NULL,
+  subject text NOT NULL,
+  sequence bigint NOT NULL,
+  time timestamptz NOT NULL,
+  headers jsonb,
+  data jsonb,
+  raw bytea,
+  UNIQUE (stream, sequence)
+)`, p.table.Sanitize()))
+	if err != nil {
+		return fmt.Errorf("could not create table %s: %v", p.cfg.Table, err)
+	}
+
+	p.created = true
+
+	return nil
+}
+
+// checkUniqueKey ensures a table not created by the replicator has the unique key on stream and sequence that prevents
+// batches delivered again from being inserted twice
+func (p *postgresSink) checkUniqueKey(ctx context.Context) error {
+	var found bool
+	err := p.pool.QueryRow(ctx, postgresUniqueKeyQuery, pgx.QueryExecModeSimpleProtocol, p.table.Sanitize()).Scan(&found)
+	if err != nil {
+		return fmt.Errorf("could not inspect table %s: %v", p.cfg.Table, err)
+	}
+
+	if !found {
+		return fmt.Errorf("table %s requires a unique key on stream and sequence", p.cfg.Table)
+	}
+
+	return nil
+}
+
+// deliver copies msgs into the table, payloads holding JSON are stored in data while others are stored in raw, as is JSON
+// holding escaped NUL characters that jsonb can not store
+func (p *postgresSink) deliver(ctx context.Context, msgs []*nats.Msg) error {
+	err := p.createTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]any, 0, len(msgs))
+	for _, msg := range msgs {
+		meta, err := jsm.ParseJSMsgMetadata(msg)
+		if err != nil {
+			p.log.Errorf("Discarding message on %s without metadata: %v", msg.Subject, err)
+			continue
+		}
+
+		var headers any
+		if len(msg.Header) > 0 {
+			headers = msg.Header
+		}
+
+		var data any
+		var raw []byte
+		if json.Valid(msg.Data) && !bytes.Contains(msg.Data, []byte(`\u0000`)) {
+			data = json.RawMessage(msg.Data)
+		} else {
+			raw = msg.Data
+		}
+
+		rows = append(rows, []any{p.stream, msg.Subject, int64(meta.StreamSequence()), meta.TimeStamp(), headers, data, raw})
+	}
+
+	if len(rows) == 0 {
+		return nil
+	}
+
+	return p.insert(ctx, rows)
+}
+
+// insert copies rows into a staging table and moves them into the table skipping messages that were already inserted,
+// as batches can be delivered again after failures, this relies on the unique key on stream and sequence of the table
+func (p *postgresSink) insert(ctx context.Context, rows [][]any) error {
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, fmt.Sprintf("CREATE TEMPORARY TABLE %s (LIKE %s INCLUDING DEFAULTS) ON COMMIT DROP", postgresStagingTable.Sanitize(), p.table.Sanitize()))
+	if err != nil {
+		return fmt.Errorf("could not create staging table: %v", err)
+	}
+
+	_, err = tx.CopyFrom(ctx, postgresStagingTable, postgresColumns, pgx.CopyFromRows(rows))
+	if err != nil {
+		return err
+	}
+
+	columns := make([]string, len(postgresColumns))
+	for i, c := range postgresColumns {
+		columns[i] = pgx.Identifier{c}.Sanitize()
+	}
+
+	_, err = tx.Exec(ctx, fmt.Sprintf("INSERT INTO %s (%s) SELECT %[2]s FROM %s ON CONFLICT DO NOTHING", p.table.Sanitize(), strings.Join(columns, ", "), postgresStagingTable.Sanitize()))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (p *postgresSink) close() error {
+	p.pool.Close()
+
+	return nil
+}
@@ -0,0 +1,370 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+// postgresTestRow is a row received by the fake server, fields are nil for NULL values
+type postgresTestRow [][]byte
+
+var _ = Describe("Postgres Sink", func() {
+	var (
+		ctx      context.Context
+		cancel   context.CancelFunc
+		wg       = sync.WaitGroup{}
+		log      *logrus.Entry
+		mu       sync.Mutex
+		queries  []string
+		rows     []postgresTestRow
+		failures int
+		failOn   string
+		unique   bool
+		addr     string
+	)
+
+	// decodeCopy parses the binary COPY format
+	decodeCopy := func(data []byte) []postgresTestRow {
+		var res []postgresTestRow
+
+		// skips the signature, flags and header extension, the trailer is optional
+		data = data[19:]
+		for len(data) >= 2 {
+			fields := int16(binary.BigEndian.Uint16(data))
+			data = data[2:]
+			if fields == -1 {
+				return res
+			}
+
+			row := postgresTestRow{}
+			for i := 0; i < int(fields); i++ {
+				size := int32(binary.BigEndian.Uint32(data))
+				data = data[4:]
+				if size == -1 {
+					row = append(row, nil)
+					continue
+				}
+				// copies the field as the buffer is reused for later batches
+				row = append(row, append([]byte{}, data[:size]...))
+				data = data[size:]
+			}
+			res = append(res, row)
+		}
+
+		return res
+	}
+
+	// serve is a minimal PostgreSQL server supporting the statements used by the sink
+	serve := func(conn net.Conn) {
+		defer GinkgoRecover()
+		defer conn.Close()
+
+		be := pgproto3.NewBackend(conn, conn)
+		_, err := be.ReceiveStartupMessage()
+		Expect(err).ToNot(HaveOccurred())
+
+		be.Send(&pgproto3.AuthenticationOk{})
+		be.Send(&pgproto3.ParameterStatus{Name: "standard_conforming_strings", Value: "on"})
+		be.Send(&pgproto3.ParameterStatus{Name: "client_encoding", Value: "UTF8"})
+		be.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+		Expect(be.Flush()).To(Succeed())
+
+		var copyData bytes.Buffer
+		var staged []postgresTestRow
+		copying := false
+
+		for {
+			msg, err := be.Receive()
+			if err != nil {
+				return
+			}
+
+			switch msg := msg.(type) {
+			case *pgproto3.Parse:
+				be.Send(&pgproto3.ParseComplete{})
+
+			case *pgproto3.Describe:
+				var fields []pgproto3.FieldDescription
+				for i, oid := range []uint32{pgtype.TextOID, pgtype.TextOID, pgtype.Int8OID, pgtype.TimestamptzOID, pgtype.JSONBOID, pgtype.JSONBOID, pgtype.ByteaOID} {
+					fields = append(fields, pgproto3.FieldDescription{Name: []byte(postgresColumns[i]), DataTypeOID: oid, Format: 1})
+				}
+				be.Send(&pgproto3.ParameterDescription{})
+				be.Send(&pgproto3.RowDescription{Fields: fields})
+
+			case *pgproto3.Sync:
+				be.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+
+			case *pgproto3.Query:
+				mu.Lock()
+				// the pool pings connections it reuses after a failure
+				if !strings.HasPrefix(msg.String, "-- ping") {
+					queries = append(queries, msg.String)
+				}
+				fail := failures > 0 && strings.HasPrefix(msg.String, failOn)
+				if fail {
+					failures--
+				}
+
+				// rows are only moved into the table when inserted from the staging table, keeping the first copy of every
+				// stream and sequence like the unique key of the table does
+				if strings.HasPrefix(msg.String, "INSERT INTO") {
+					for _, row := range staged {
+						duplicate := false
+						for _, existing := range rows {
+							if bytes.Equal(existing[0], row[0]) && bytes.Equal(existing[2], row[2]) {
+								duplicate = true
+							}
+						}
+						if !duplicate {
+							rows = append(rows, row)
+						}
+					}
+					staged = nil
+				}
+				if msg.String == "rollback" {
+					staged = nil
+				}
+				mu.Unlock()
+
+				switch {
+				case fail:
+					be.Send(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "53300", Message: "too many connections"})
+					be.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+				case strings.HasPrefix(msg.String, "SELECT EXISTS"):
+					found := []byte("f")
+					if unique {
+						found = []byte("t")
+					}
+					be.Send(&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{{Name: []byte("exists"), DataTypeOID: pgtype.BoolOID}}})
+					be.Send(&pgproto3.DataRow{Values: [][]byte{found}})
+					be.Send(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")})
+					be.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+				case strings.HasPrefix(msg.String, "copy"):
+					copying = true
+					copyData.Reset()
+					be.Send(&pgproto3.CopyInResponse{OverallFormat: 1, ColumnFormatCodes: []uint16{1, 1, 1, 1, 1, 1, 1}})
+				default:
+					be.Send(&pgproto3.CommandComplete{CommandTag: []byte("CREATE TABLE")})
+					be.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+				}
+
+			case *pgproto3.CopyData:
+				if copying {
+					copyData.Write(msg.Data)
+				}
+
+			case *pgproto3.CopyDone:
+				if !copying {
+					continue
+				}
+				copying = false
+
+				received := decodeCopy(copyData.Bytes())
+				staged = append(staged, received...)
+
+				be.Send(&pgproto3.CommandComplete{CommandTag: []byte(fmt.Sprintf("COPY %d", len(received)))})
+				be.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+
+			case *pgproto3.Terminate:
+				return
+			}
+
+			Expect(be.Flush()).To(Succeed())
+		}
+	}
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		queries = nil
+		rows = nil
+		failures = 0
+		failOn = "copy"
+		unique = true
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		addr = l.Addr().String()
+
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				go serve(conn)
+			}
+		}()
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+			l.Close()
+		})
+	})
+
+	run := func(nc *nats.Conn, mgr *jsm.Manager, pcfg *config.Postgres, bodies ...string) {
+		_, err := mgr.NewStream("ORDERS", jsm.Subjects("ORDERS.>"))
+		Expect(err).ToNot(HaveOccurred())
+
+		for i, body := range bodies {
+			msg := nats.NewMsg(fmt.Sprintf("ORDERS.%d", i+1))
+			msg.Data = []byte(body)
+			_, err := nc.RequestMsg(msg, time.Second)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		scfg := &config.Stream{Stream: "ORDERS", SourceURL: nc.ConnectedUrl(), TargetPostgres: pcfg}
+		cfg := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+		Expect(cfg.Validate()).To(Succeed())
+
+		stream, err := NewStream(scfg, cfg, log)
+		Expect(err).ToNot(HaveOccurred())
+
+		wg.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+		}()
+	}
+
+	received := func() []postgresTestRow {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]postgresTestRow{}, rows...)
+	}
+
+	It("Should create the table and copy messages", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			run(nc, mgr, &config.Postgres{
+				URL:         fmt.Sprintf("postgres://replicator:s3cret@%s/archive?sslmode=disable", addr),
+				Table:       "archive.orders",
+				CreateTable: true,
+				Delivery:    config.Delivery{Batch: 2, FlushIntervalString: "200ms"},
+			}, `{"order": 1}`, "order 2", `{"order": "\u0000"}`)
+
+			Eventually(received, "10s").Should(HaveLen(3))
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			Expect(queries[0]).To(HavePrefix(`CREATE TABLE IF NOT EXISTS "archive"."orders" (`))
+			Expect(queries[0]).To(ContainSubstring("UNIQUE (stream, sequence)"))
+			Expect(queries[1:6]).To(Equal([]string{
+				"begin",
+				`CREATE TEMPORARY TABLE "stream_replicator_staging" (LIKE "archive"."orders" INCLUDING DEFAULTS) ON COMMIT DROP`,
+				`copy "stream_replicator_staging" ( "stream", "subject", "sequence", "time", "headers", "data", "raw" ) from stdin binary;`,
+				`INSERT INTO "archive"."orders" ("stream", "subject", "sequence", "time", "headers", "data", "raw") SELECT "stream", "subject", "sequence", "time", "headers", "data", "raw" FROM "stream_replicator_staging" ON CONFLICT DO NOTHING`,
+				"commit",
+			}))
+
+			Expect(string(rows[0][0])).To(Equal("ORDERS"))
+			Expect(string(rows[0][1])).To(Equal("ORDERS.1"))
+			Expect(binary.BigEndian.Uint64(rows[0][2])).To(Equal(uint64(1)))
+			Expect(string(rows[0][4][1:])).To(ContainSubstring(`"Choria-SR-Source"`))
+			Expect(string(rows[0][5][1:])).To(Equal(`{"order": 1}`))
+			Expect(rows[0][6]).To(BeNil())
+
+			Expect(binary.BigEndian.Uint64(rows[1][2])).To(Equal(uint64(2)))
+			Expect(rows[1][5]).To(BeNil())
+			Expect(string(rows[1][6])).To(Equal("order 2"))
+
+			// jsonb can not store NUL characters
+			Expect(rows[2][5]).To(BeNil())
+			Expect(string(rows[2][6])).To(Equal(`{"order": "\u0000"}`))
+		})
+	})
+
+	It("Should retry failed copies", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			mu.Lock()
+			failures = 1
+			mu.Unlock()
+
+			run(nc, mgr, &config.Postgres{URL: fmt.Sprintf("postgres://%s/archive?sslmode=disable", addr), Table: "orders"}, `{"order": 1}`)
+
+			Eventually(received, "10s").Should(HaveLen(1))
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			Expect(queries).To(HaveLen(10))
+			Expect(queries[0]).To(ContainSubstring(`'"orders"'`))
+			Expect(queries[3]).To(HavePrefix("copy"))
+			Expect(queries[4]).To(Equal("rollback"))
+			Expect(queries[5:8]).To(Equal(queries[1:4]))
+		})
+	})
+
+	It("Should not insert batches delivered again twice", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			// the rows are inserted but the commit appears to fail so the batch is delivered again
+			mu.Lock()
+			failures = 1
+			failOn = "commit"
+			mu.Unlock()
+
+			run(nc, mgr, &config.Postgres{URL: fmt.Sprintf("postgres://%s/archive?sslmode=disable", addr), Table: "orders", Delivery: config.Delivery{Batch: 2, FlushIntervalString: "200ms"}}, `{"order": 1}`, `{"order": 2}`)
+
+			Eventually(func() int {
+				mu.Lock()
+				defer mu.Unlock()
+
+				inserts := 0
+				for _, q := range queries {
+					if strings.HasPrefix(q, "INSERT INTO") {
+						inserts++
+					}
+				}
+
+				return inserts
+			}, "10s").Should(Equal(2))
+			Consistently(received, "200ms").Should(HaveLen(2))
+		})
+	})
+
+	It("Should not start when the table has no unique key on stream and sequence", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			mu.Lock()
+			unique = false
+			mu.Unlock()
+
+			_, err := mgr.NewStream("ORDERS", jsm.Subjects("ORDERS.>"))
+			Expect(err).ToNot(HaveOccurred())
+
+			scfg := &config.Stream{Stream: "ORDERS", SourceURL: nc.ConnectedUrl(), TargetPostgres: &config.Postgres{URL: fmt.Sprintf("postgres://%s/archive?sslmode=disable", addr), Table: "orders"}}
+			cfg := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+			Expect(cfg.Validate()).To(Succeed())
+
+			stream, err := NewStream(scfg, cfg, log)
+			Expect(err).ToNot(HaveOccurred())
+
+			wg.Add(1)
+			Expect(stream.Run(ctx, &wg)).To(MatchError("postgres target setup failed: table orders requires a unique key on stream and sequence"))
+		})
+	})
+})
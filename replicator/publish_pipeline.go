@@ -0,0 +1,163 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+// publishPipeline publishes messages to the target stream over several connections without waiting for one message to
+// be stored before handling the next, messages with the same subject always use the same connection so they are stored
+// in order and are acknowledged once stored
+type publishPipeline struct {
+	c        *sourceInitiatedCopier
+	conns    []*nats.Conn
+	queues   []chan *pipelineMsg
+	inflight int64
+	wg       sync.WaitGroup
+	log      *logrus.Entry
+}
+
+type pipelineMsg struct {
+	msg  *nats.Msg
+	meta *jsm.MsgInfo
+	size int64
+}
+
+func newPublishPipeline(c *sourceInitiatedCopier, conns []*nats.Conn) *publishPipeline {
+	p := &publishPipeline{
+		c:     c,
+		conns: conns,
+		log:   c.log.WithField("pipeline", len(conns)),
+	}
+
+	for range conns {
+		p.queues = append(p.queues, make(chan *pipelineMsg, c.batch))
+	}
+
+	return p
+}
+
+// start starts a worker for every connection, they stop once ctx is done
+func (p *publishPipeline) start(ctx context.Context) {
+	p.log.Infof("Publishing messages over %d connections", len(p.conns))
+
+	for i := range p.conns {
+		p.wg.Add(1)
+		go p.worker(ctx, i)
+	}
+}
+
+// wait waits for the workers to stop
+func (p *publishPipeline) wait() {
+	p.wg.Wait()
+}
+
+// publish queues msg for the connection used for its subject, waiting while the queue is full
+func (p *publishPipeline) publish(ctx context.Context, msg *nats.Msg, meta *jsm.MsgInfo) error {
+	h := fnv.New32a()
+	h.Write([]byte(msg.Subject))
+
+	atomic.AddInt64(&p.inflight, 1)
+	pm := &pipelineMsg{msg: msg, meta: meta, size: p.c.s.budget.acquire(msg)}
+
+	select {
+	case p.queues[h.Sum32()%uint32(len(p.queues))] <- pm:
+		return nil
+	case <-ctx.Done():
+		atomic.AddInt64(&p.inflight, -1)
+		p.c.s.budget.release(pm.size)
+		return ctx.Err()
+	}
+}
+
+// flush waits for all queued messages to be published or given up on
+func (p *publishPipeline) flush(ctx context.Context) error {
+	for atomic.LoadInt64(&p.inflight) > 0 {
+		select {
+		case <-time.After(5 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+func (p *publishPipeline) worker(ctx context.Context, id int) {
+	defer p.wg.Done()
+
+	for {
+		select {
+		case pm := <-p.queues[id]:
+			p.deliver(ctx, id, pm)
+			p.c.s.budget.release(pm.size)
+			atomic.AddInt64(&p.inflight, -1)
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// deliver publishes pm retrying until it is stored so later messages for the same subject stay in order, unpublished
+// messages are redelivered by the source once the replicator restarts
+func (p *publishPipeline) deliver(ctx context.Context, id int, pm *pipelineMsg) {
+	cfg := p.c.cfg
+	name := p.c.sr.ReplicatorName
+
+	err := p.c.s.retries.For(ctx, func(try int) error {
+		// avoids redelivery while retrying
+		if try > 1 {
+			pm.msg.InProgress()
+		}
+
+		resp, err := p.conns[id].RequestMsg(pm.msg, 2*time.Second)
+		if err == nil {
+			err = jsm.ParseErrorResponse(resp)
+			p.c.s.targetPublishFailed(err)
+		}
+		if err != nil {
+			handlerErrorCount.WithLabelValues(cfg.Stream, name, cfg.Name).Inc()
+			p.c.s.reportError(err)
+			atomic.AddInt64(&p.c.failed, 1)
+			p.log.Errorf("Publishing message on %s failed on try %d using connection %d: %v", pm.msg.Subject, try, id, err)
+			return err
+		}
+
+		p.c.s.recordDuplicate(resp)
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	p.c.copiedMessage(pm.msg, pm.meta)
+
+	err = pm.msg.Ack()
+	releaseHeader(pm.msg)
+	if err != nil {
+		ackFailedCount.WithLabelValues(cfg.Stream, name, cfg.Name).Inc()
+		p.log.Errorf("ACK failed: %v", err)
+		return
+	}
+
+	if pm.meta != nil {
+		p.c.source.mu.Lock()
+		if pm.meta.StreamSequence() > p.c.source.resumeSeq {
+			p.c.source.resumeSeq = pm.meta.StreamSequence()
+		}
+		p.c.source.mu.Unlock()
+	}
+}
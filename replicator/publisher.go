@@ -0,0 +1,33 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Publisher receives copied messages in place of the configured target, see WithPublisher
+type Publisher interface {
+	// Publish stores msg, messages are acknowledged to the source once stored and redelivered after errors.
+	// msg and its headers are reused after Publish returns
+	Publish(ctx context.Context, msg *nats.Msg) error
+	// Close is called once the stream stops
+	Close() error
+}
+
+// publisherSink adapts a Publisher to the sink used by the copiers
+type publisherSink struct {
+	p Publisher
+}
+
+func (p *publisherSink) publish(ctx context.Context, msg *nats.Msg) error {
+	return p.p.Publish(ctx, msg)
+}
+
+func (p *publisherSink) close() error {
+	return p.p.Close()
+}
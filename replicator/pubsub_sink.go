@@ -0,0 +1,89 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/util"
+	"github.com/nats-io/nats.go"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+)
+
+// pubsubSink publishes messages to a Google Cloud Pub/Sub topic
+type pubsubSink struct {
+	cfg    *config.PubSub
+	client *pubsub.Client
+	topic  *pubsub.Topic
+}
+
+func (s *Stream) connectPubSub(ctx context.Context) error {
+	pcfg := s.cfg.TargetPubSub
+
+	var opts []option.ClientOption
+	if pcfg.CredentialsFile != _EMPTY_ {
+		opts = append(opts, option.WithCredentialsFile(pcfg.CredentialsFile))
+	}
+
+	if s.cfg.TargetProxy != _EMPTY_ {
+		dial, err := util.ProxyDialer(s.cfg.TargetProxy, 30*time.Second)
+		if err != nil {
+			return fmt.Errorf("pubsub connection failed: %v", err)
+		}
+
+		opts = append(opts, option.WithGRPCDialOption(grpc.WithContextDialer(func(ctx context.Context, address string) (net.Conn, error) {
+			return dial(ctx, "tcp", address)
+		})))
+	}
+
+	client, err := pubsub.NewClient(ctx, pcfg.Project, opts...)
+	if err != nil {
+		return fmt.Errorf("pubsub connection failed: %v", err)
+	}
+
+	// batches are formed by the copier so messages are sent as soon as they are published
+	topic := client.Topic(pcfg.Topic)
+	topic.PublishSettings.CountThreshold = pcfg.Batch
+	topic.PublishSettings.DelayThreshold = time.Millisecond
+
+	s.log.WithField("connection", "pubsub").Infof("Publishing messages to topic %s in project %s", pcfg.Topic, pcfg.Project)
+
+	s.batchSink = &pubsubSink{cfg: pcfg, client: client, topic: topic}
+	s.delivery = &pcfg.Delivery
+
+	return nil
+}
+
+// deliver publishes msgs and waits for all of them to be acknowledged by the service
+func (p *pubsubSink) deliver(ctx context.Context, msgs []*nats.Msg) error {
+	results := make([]*pubsub.PublishResult, len(msgs))
+	for i, msg := range msgs {
+		results[i] = p.topic.Publish(ctx, &pubsub.Message{
+			Data:       msg.Data,
+			Attributes: messageAttributes(msg, p.cfg.Attributes),
+		})
+	}
+
+	for _, res := range results {
+		_, err := res.Get(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *pubsubSink) close() error {
+	p.topic.Stop()
+
+	return p.client.Close()
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/jsm.go/api"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Pub/Sub Sink", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+		srv    *pstest.Server
+		client *pubsub.Client
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		srv = pstest.NewServer()
+		os.Setenv("PUBSUB_EMULATOR_HOST", srv.Addr)
+
+		var err error
+		client, err = pubsub.NewClient(ctx, "ginkgo")
+		Expect(err).ToNot(HaveOccurred())
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+			client.Close()
+			srv.Close()
+			os.Unsetenv("PUBSUB_EMULATOR_HOST")
+		})
+	})
+
+	It("Should publish messages with mapped attributes", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			topic, err := client.CreateTopic(ctx, "orders")
+			Expect(err).ToNot(HaveOccurred())
+			sub, err := client.CreateSubscription(ctx, "orders", pubsub.SubscriptionConfig{Topic: topic})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = mgr.NewStream("ORDERS", jsm.Subjects("ORDERS.>"))
+			Expect(err).ToNot(HaveOccurred())
+
+			for i := 1; i <= 10; i++ {
+				msg := nats.NewMsg(fmt.Sprintf("ORDERS.%d", i))
+				msg.Data = []byte(fmt.Sprintf("order %d", i))
+				msg.Header.Add(api.JSMsgId, fmt.Sprintf("%d", i))
+				msg.Header.Add("Customer", "bob")
+				_, err := nc.RequestMsg(msg, time.Second)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			scfg := &config.Stream{
+				Stream:    "ORDERS",
+				SourceURL: nc.ConnectedUrl(),
+				TargetPubSub: &config.PubSub{
+					Project:    "ginkgo",
+					Topic:      "orders",
+					Attributes: map[string]string{"Customer": "customer"},
+					Delivery:   config.Delivery{Batch: 5, FlushIntervalString: "200ms"},
+				},
+			}
+			cfg := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+			Expect(cfg.Validate()).To(Succeed())
+
+			stream, err := NewStream(scfg, cfg, log)
+			Expect(err).ToNot(HaveOccurred())
+
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+			}()
+
+			var mu sync.Mutex
+			received := map[string]*pubsub.Message{}
+
+			rctx, rcancel := context.WithCancel(ctx)
+			defer rcancel()
+
+			go sub.Receive(rctx, func(_ context.Context, msg *pubsub.Message) {
+				mu.Lock()
+				received[msg.Attributes[SequenceHeader]] = msg
+				mu.Unlock()
+				msg.Ack()
+			})
+
+			Eventually(func() int {
+				mu.Lock()
+				defer mu.Unlock()
+				return len(received)
+			}, "10s").Should(Equal(10))
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			msg := received["1"]
+			Expect(string(msg.Data)).To(Equal("order 1"))
+			Expect(msg.Attributes).To(Equal(map[string]string{
+				"customer":     "bob",
+				SubjectHeader:  "ORDERS.1",
+				SequenceHeader: "1",
+			}))
+		})
+	})
+})
@@ -0,0 +1,196 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/choria-io/stream-replicator/events"
+)
+
+// quiesceInterval is how often the source consumer is checked for outstanding messages while quiescing
+const quiesceInterval = 100 * time.Millisecond
+
+// QuiesceState is the point a quiesced stream stopped copying at
+type QuiesceState struct {
+	// Stream is the source stream
+	Stream string `json:"stream"`
+	// Name is the name of the stream configuration
+	Name string `json:"name"`
+	// TargetStream is the stream messages are copied to
+	TargetStream string `json:"target_stream"`
+	// Leader indicates if this replicator was copying the stream, standby replicators report no sequences
+	Leader bool `json:"leader"`
+	// SourceSequence is the last source message that was copied or skipped
+	SourceSequence uint64 `json:"source_sequence"`
+	// TargetSequence is the last message stored in the target stream, 0 for targets that are not NATS Streams
+	TargetSequence uint64 `json:"target_sequence"`
+	// Time is when copying stopped
+	Time time.Time `json:"time"`
+}
+
+// Quiesce pauses copying once all messages received from the source are copied and acknowledged, saves the
+// limiter state and reports where copying stopped, copying stays paused until Resume is called. Quiescing a quiesced
+// stream reports the same point again
+func (s *Stream) Quiesce(ctx context.Context) (*QuiesceState, error) {
+	select {
+	case <-s.ready:
+	default:
+		return nil, fmt.Errorf("stream %s is not ready", s.cfg.Stream)
+	}
+
+	if _, ok := s.copier.(*sourceInitiatedCopier); !ok {
+		return nil, fmt.Errorf("stream %s can not be quiesced, only streams copying messages one at a time from a NATS Stream can", s.cfg.Stream)
+	}
+
+	s.mu.Lock()
+	already := s.quiesced
+	s.quiesced = true
+	leader := !s.paused
+	if s.advisor != nil {
+		s.advisor.Pause()
+	}
+	s.mu.Unlock()
+
+	state := &QuiesceState{
+		Stream:       s.cfg.Stream,
+		Name:         s.cfg.Name,
+		TargetStream: s.cfg.TargetStream,
+		Leader:       leader,
+	}
+
+	err := s.quiesce(ctx, state)
+	if err != nil {
+		if !already {
+			s.Resume()
+		}
+		return nil, err
+	}
+
+	// quiescing again reports the same point without announcing it again
+	if already {
+		return state, nil
+	}
+
+	quiesced.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Set(1)
+	s.log.Warnf("Quiesced at source sequence %d and target sequence %d", state.SourceSequence, state.TargetSequence)
+
+	s.events.Publish(&events.Event{
+		Event:   events.QuiescedEvent,
+		Stream:  s.cfg.Stream,
+		Name:    s.cfg.Name,
+		Message: fmt.Sprintf("Replication quiesced at source sequence %d and target sequence %d", state.SourceSequence, state.TargetSequence),
+		Data: map[string]string{
+			"source_sequence": strconv.FormatUint(state.SourceSequence, 10),
+			"target_sequence": strconv.FormatUint(state.TargetSequence, 10),
+		},
+	})
+
+	return state, nil
+}
+
+// quiesce waits for the source consumer to have no outstanding requests or unacknowledged messages and records the
+// sequences copying stopped at in state
+func (s *Stream) quiesce(ctx context.Context, state *QuiesceState) error {
+	if s.limiter != nil && s.limiter.Tracker() != nil {
+		defer func() {
+			err := s.limiter.Tracker().Flush()
+			if err != nil {
+				s.log.Errorf("Could not save the limiter state: %v", err)
+			}
+		}()
+	}
+
+	if !state.Leader {
+		state.Time = time.Now().UTC()
+		return nil
+	}
+
+	ticker := time.NewTicker(quiesceInterval)
+	defer ticker.Stop()
+
+	for {
+		s.source.mu.Lock()
+		consumer := s.source.consumer
+		s.source.mu.Unlock()
+
+		nfo, err := consumer.State()
+		if err != nil {
+			return fmt.Errorf("could not load the source consumer state: %v", err)
+		}
+
+		if nfo.NumWaiting == 0 && nfo.NumAckPending == 0 {
+			state.SourceSequence = nfo.AckFloor.Stream
+			state.Time = time.Now().UTC()
+			break
+		}
+
+		s.log.Debugf("Waiting for %d request(s) and %d unacknowledged message(s) before quiescing", nfo.NumWaiting, nfo.NumAckPending)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("stream %s did not quiesce: %v", s.cfg.Stream, ctx.Err())
+		}
+	}
+
+	// targets other than NATS Streams have no sequence to report
+	if s.dest == nil {
+		return nil
+	}
+
+	s.dest.mu.Lock()
+	target := s.dest.stream
+	s.dest.mu.Unlock()
+
+	if target == nil {
+		return nil
+	}
+
+	nfo, err := target.State()
+	if err != nil {
+		return fmt.Errorf("could not load the target stream state: %v", err)
+	}
+	state.TargetSequence = nfo.LastSeq
+
+	return nil
+}
+
+// Quiesced determines if copying was paused using Quiesce
+func (s *Stream) Quiesced() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.quiesced
+}
+
+// Resume continues copying a quiesced stream, returns false when the stream was not quiesced
+func (s *Stream) Resume() bool {
+	s.mu.Lock()
+	if !s.quiesced {
+		s.mu.Unlock()
+		return false
+	}
+	s.quiesced = false
+	if s.advisor != nil && !s.pausedLocked() {
+		s.advisor.Resume()
+	}
+	s.mu.Unlock()
+
+	quiesced.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Set(0)
+	s.log.Warnf("Resuming replication after being quiesced")
+
+	s.events.Publish(&events.Event{
+		Event:   events.QuiesceResumedEvent,
+		Stream:  s.cfg.Stream,
+		Name:    s.cfg.Name,
+		Message: "Replication resumed after being quiesced",
+	})
+
+	return true
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Quiesce", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	publish := func(nc *nats.Conn, count int) {
+		for i := 0; i < count; i++ {
+			_, err := nc.Request("TEST", []byte("x"), time.Second)
+			Expect(err).ToNot(HaveOccurred())
+		}
+	}
+
+	// for use with Eventually()
+	streamMessages := func(s *jsm.Stream) func() (uint64, error) {
+		return func() (uint64, error) {
+			nfo, err := s.State()
+			if err != nil {
+				return 0, err
+			}
+			return nfo.Msgs, nil
+		}
+	}
+
+	run := func(scfg *config.Stream) *Stream {
+		cfg := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+		Expect(cfg.Validate()).To(Succeed())
+
+		stream, err := NewStream(scfg, cfg, log)
+		Expect(err).ToNot(HaveOccurred())
+
+		wg.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			Expect(stream.Run(ctx, &wg)).To(Succeed())
+		}()
+		Eventually(stream.Ready(), 5*time.Second).Should(BeClosed())
+
+		return stream
+	}
+
+	It("Should pause at a consistent point and resume", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("TEST")
+			Expect(err).ToNot(HaveOccurred())
+			target, err := mgr.NewStream("TEST_COPY", jsm.Subjects("copy.>"))
+			Expect(err).ToNot(HaveOccurred())
+
+			stream := run(&config.Stream{
+				Stream:       "TEST",
+				TargetStream: "TEST_COPY",
+				TargetPrefix: "copy",
+				SourceURL:    nc.ConnectedUrl(),
+				TargetURL:    nc.ConnectedUrl(),
+				Fetch:        &config.Fetch{Batch: 5, ExpiresString: "1s"},
+			})
+
+			publish(nc, 10)
+			Eventually(streamMessages(target)).Should(BeNumerically("==", 10))
+
+			state, err := stream.Quiesce(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(state.Leader).To(BeTrue())
+			Expect(state.Stream).To(Equal("TEST"))
+			Expect(state.TargetStream).To(Equal("TEST_COPY"))
+			Expect(state.SourceSequence).To(Equal(uint64(10)))
+			Expect(state.TargetSequence).To(Equal(uint64(10)))
+			Expect(stream.Quiesced()).To(BeTrue())
+
+			publish(nc, 5)
+			Consistently(streamMessages(target), time.Second).Should(BeNumerically("==", 10))
+
+			// quiescing again reports the same point
+			again, err := stream.Quiesce(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(again.SourceSequence).To(Equal(uint64(10)))
+
+			Expect(stream.Resume()).To(BeTrue())
+			Expect(stream.Resume()).To(BeFalse())
+			Expect(stream.Quiesced()).To(BeFalse())
+			Eventually(streamMessages(target), 5*time.Second).Should(BeNumerically("==", 15))
+		})
+	})
+
+	It("Should not quiesce streams that copy in batches", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("TEST")
+			Expect(err).ToNot(HaveOccurred())
+
+			stream := run(&config.Stream{
+				Stream:     "TEST",
+				SourceURL:  nc.ConnectedUrl(),
+				TargetHTTP: &config.HTTP{URL: "http://localhost:1"},
+			})
+			_, err = stream.Quiesce(ctx)
+			Expect(err).To(MatchError("stream TEST can not be quiesced, only streams copying messages one at a time from a NATS Stream can"))
+			Expect(stream.Quiesced()).To(BeFalse())
+		})
+	})
+})
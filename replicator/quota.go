@@ -0,0 +1,164 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/events"
+)
+
+// quotaLevel is how close the target stream is to its quota
+type quotaLevel int
+
+const (
+	quotaAvailable quotaLevel = iota
+	quotaThrottled
+	quotaExhausted
+
+	// quotaThrottleDelay is the shortest time between requests for more messages while the quota is nearly used
+	quotaThrottleDelay = time.Second
+)
+
+func (l quotaLevel) String() string {
+	switch l {
+	case quotaThrottled:
+		return "throttled"
+	case quotaExhausted:
+		return "paused"
+	default:
+		return "available"
+	}
+}
+
+// enforceQuota checks the target stream usage against the quota until ctx is done
+func (s *Stream) enforceQuota(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := s.clock.NewTicker(s.cfg.TargetQuota.Interval)
+	defer ticker.Stop()
+
+	for {
+		// standby replicators do not copy so they do not report on the quota either
+		if s.Leading() {
+			err := s.checkQuota()
+			if err != nil {
+				s.log.Errorf("Could not check the target quota: %v", err)
+			}
+		}
+
+		select {
+		case <-ticker.C():
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// checkQuota throttles, pauses or resumes replication based on how much of the quota the target stream uses
+func (s *Stream) checkQuota() error {
+	s.dest.mu.Lock()
+	stream := s.dest.stream
+	s.dest.mu.Unlock()
+
+	if stream == nil {
+		return fmt.Errorf("not connected to the target stream")
+	}
+
+	state, err := stream.State()
+	if err != nil {
+		return err
+	}
+
+	maxMsgs := s.cfg.TargetQuota.MaxMsgs
+	if maxMsgs == 0 {
+		maxMsgs = stream.MaxMsgs()
+	}
+	maxBytes := s.cfg.TargetQuota.MaxBytes
+	if maxBytes == 0 {
+		maxBytes = stream.MaxBytes()
+	}
+
+	var used float64
+	if maxMsgs > 0 {
+		used = float64(state.Msgs) / float64(maxMsgs) * 100
+	}
+	if maxBytes > 0 {
+		if b := float64(state.Bytes) / float64(maxBytes) * 100; b > used {
+			used = b
+		}
+	}
+
+	targetQuotaUsed.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Set(used)
+
+	level := quotaAvailable
+	switch {
+	case maxMsgs <= 0 && maxBytes <= 0:
+		s.log.Debugf("Target stream %s has no limits to enforce a quota against", s.cfg.TargetStream)
+	case used >= float64(s.cfg.TargetQuota.PausePercent):
+		level = quotaExhausted
+	case used >= float64(s.cfg.TargetQuota.ThrottlePercent):
+		level = quotaThrottled
+	}
+
+	s.mu.Lock()
+	previous := s.quota
+	s.quota = level
+	if s.advisor != nil && (previous == quotaExhausted) != (level == quotaExhausted) {
+		if level == quotaExhausted {
+			s.advisor.Pause()
+		} else if !s.pausedLocked() {
+			s.advisor.Resume()
+		}
+	}
+	s.mu.Unlock()
+
+	if level == previous {
+		return nil
+	}
+
+	targetQuotaState.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Set(float64(level))
+
+	event := &events.Event{
+		Stream: s.cfg.Stream,
+		Name:   s.cfg.Name,
+		Data: map[string]string{
+			"messages": strconv.FormatUint(state.Msgs, 10),
+			"bytes":    strconv.FormatUint(state.Bytes, 10),
+			"used":     strconv.FormatFloat(used, 'f', 1, 64),
+		},
+	}
+
+	switch level {
+	case quotaExhausted:
+		s.log.Warnf("Pausing replication as the target stream %s used %.1f%% of its quota", s.cfg.TargetStream, used)
+		event.Event = events.TargetQuotaPausedEvent
+		event.Message = fmt.Sprintf("Replication paused as %s used %.1f%% of its quota", s.cfg.TargetStream, used)
+	case quotaThrottled:
+		s.log.Warnf("Throttling replication as the target stream %s used %.1f%% of its quota", s.cfg.TargetStream, used)
+		event.Event = events.TargetQuotaThrottledEvent
+		event.Message = fmt.Sprintf("Replication throttled as %s used %.1f%% of its quota", s.cfg.TargetStream, used)
+	default:
+		s.log.Warnf("Resuming replication as the target stream %s used %.1f%% of its quota", s.cfg.TargetStream, used)
+		event.Event = events.TargetQuotaAvailableEvent
+		event.Message = fmt.Sprintf("Replication resumed as %s used %.1f%% of its quota", s.cfg.TargetStream, used)
+	}
+
+	s.events.Publish(event)
+
+	return nil
+}
+
+// quotaThrottled determines if copying has to slow down as the target stream has nearly used its quota
+func (s *Stream) quotaThrottled() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.quota == quotaThrottled
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/events"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Target quota", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+	})
+
+	AfterEach(func() {
+		cancel()
+		wg.Wait()
+	})
+
+	// creates a stream copying to target using quota
+	newStream := func(nc *nats.Conn, target *jsm.Stream, quota *config.TargetQuota) *Stream {
+		p := startEvents(ctx, &wg, nc, log)
+
+		scfg := &config.Stream{
+			Stream:       "TEST",
+			TargetStream: target.Name(),
+			SourceURL:    nc.ConnectedUrl(),
+			TargetURL:    nc.ConnectedUrl(),
+			TargetQuota:  quota,
+		}
+
+		stream, err := NewStream(scfg, &config.Config{ReplicatorName: "GINKGO"}, log, WithEvents(p))
+		Expect(err).ToNot(HaveOccurred())
+		stream.dest = &Target{mu: &sync.Mutex{}, stream: target}
+
+		return stream
+	}
+
+	publish := func(nc *nats.Conn, count int) {
+		for i := 0; i < count; i++ {
+			_, err := nc.Request("copy.test", []byte("x"), time.Second)
+			Expect(err).ToNot(HaveOccurred())
+		}
+	}
+
+	It("Should throttle, pause and resume as the quota is used", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			received := make(chan *nats.Msg, 10)
+			sub, err := nc.ChanSubscribe("events.>", received)
+			Expect(err).ToNot(HaveOccurred())
+			defer sub.Unsubscribe()
+
+			target, err := mgr.NewStream("TEST_COPY", jsm.Subjects("copy.>"))
+			Expect(err).ToNot(HaveOccurred())
+
+			stream := newStream(nc, target, &config.TargetQuota{MaxMsgs: 10, ThrottlePercent: 50, PausePercent: 90})
+
+			publish(nc, 4)
+			Expect(stream.checkQuota()).To(Succeed())
+			Expect(stream.quotaThrottled()).To(BeFalse())
+			Expect(stream.isPaused()).To(BeFalse())
+			Consistently(received, 100*time.Millisecond).ShouldNot(Receive())
+
+			publish(nc, 1)
+			Expect(stream.checkQuota()).To(Succeed())
+			Expect(stream.quotaThrottled()).To(BeTrue())
+			Expect(stream.isPaused()).To(BeFalse())
+			event := nextEvent(received)
+			Expect(event.Event).To(Equal(events.TargetQuotaThrottledEvent))
+			Expect(event.Data).To(Equal(map[string]string{"messages": "5", "bytes": event.Data["bytes"], "used": "50.0"}))
+
+			publish(nc, 4)
+			Expect(stream.checkQuota()).To(Succeed())
+			Expect(stream.quotaThrottled()).To(BeFalse())
+			Expect(stream.isPaused()).To(BeTrue())
+			Expect(nextEvent(received).Event).To(Equal(events.TargetQuotaPausedEvent))
+
+			// nothing is published while the level stays the same
+			Expect(stream.checkQuota()).To(Succeed())
+			Consistently(received, 100*time.Millisecond).ShouldNot(Receive())
+
+			Expect(target.Purge()).To(Succeed())
+			Expect(stream.checkQuota()).To(Succeed())
+			Expect(stream.isPaused()).To(BeFalse())
+			event = nextEvent(received)
+			Expect(event.Event).To(Equal(events.TargetQuotaAvailableEvent))
+			Expect(event.Data["used"]).To(Equal("0.0"))
+		})
+	})
+
+	It("Should default to the target stream limits", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			target, err := mgr.NewStream("TEST_COPY", jsm.Subjects("copy.>"), jsm.MaxMessages(4))
+			Expect(err).ToNot(HaveOccurred())
+
+			stream := newStream(nc, target, &config.TargetQuota{ThrottlePercent: 50, PausePercent: 100})
+
+			publish(nc, 2)
+			Expect(stream.checkQuota()).To(Succeed())
+			Expect(stream.quotaThrottled()).To(BeTrue())
+
+			publish(nc, 2)
+			Expect(stream.checkQuota()).To(Succeed())
+			Expect(stream.isPaused()).To(BeTrue())
+		})
+	})
+
+	It("Should not enforce a quota without limits", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			target, err := mgr.NewStream("TEST_COPY", jsm.Subjects("copy.>"))
+			Expect(err).ToNot(HaveOccurred())
+
+			stream := newStream(nc, target, &config.TargetQuota{ThrottlePercent: 1, PausePercent: 1})
+
+			publish(nc, 10)
+			Expect(stream.checkQuota()).To(Succeed())
+			Expect(stream.quotaThrottled()).To(BeFalse())
+			Expect(stream.isPaused()).To(BeFalse())
+		})
+	})
+})
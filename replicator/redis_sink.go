@@ -0,0 +1,106 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/util"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats.go"
+	"github.com/redis/go-redis/v9"
+)
+
+// redisSink adds messages to a Redis Stream
+type redisSink struct {
+	cfg    *config.Redis
+	client *redis.Client
+	key    string
+}
+
+func (s *Stream) connectRedis(_ context.Context) error {
+	rcfg := s.cfg.TargetRedis
+
+	opts, err := redis.ParseURL(rcfg.URL)
+	if err != nil {
+		return fmt.Errorf("redis target setup failed: %v", err)
+	}
+
+	if s.cfg.TargetProxy != _EMPTY_ {
+		opts.Dialer, err = util.ProxyDialer(s.cfg.TargetProxy, 30*time.Second)
+		if err != nil {
+			return fmt.Errorf("redis target setup failed: %v", err)
+		}
+	}
+
+	if rcfg.TLS != nil {
+		tlsc, err := util.ClientTLSConfig(rcfg.TLS.CA, rcfg.TLS.Cert, rcfg.TLS.Key)
+		if err != nil {
+			return fmt.Errorf("redis target tls: %v", err)
+		}
+		if opts.TLSConfig != nil {
+			tlsc.ServerName = opts.TLSConfig.ServerName
+		}
+		opts.TLSConfig = tlsc
+	}
+
+	opts.PoolSize = rcfg.Concurrency
+
+	key := rcfg.Key
+	if key == _EMPTY_ {
+		key = s.cfg.Stream
+	}
+
+	s.log.WithField("connection", "redis").Infof("Adding messages to Redis Stream %s on %s", key, opts.Addr)
+
+	s.batchSink = &redisSink{cfg: rcfg, client: redis.NewClient(opts), key: key}
+	s.delivery = &rcfg.Delivery
+
+	return nil
+}
+
+// deliver adds msgs in one pipeline, every entry holds the subject, sequence, headers and data of a message
+func (r *redisSink) deliver(ctx context.Context, msgs []*nats.Msg) error {
+	pipe := r.client.Pipeline()
+
+	for _, msg := range msgs {
+		values := []any{"subject", msg.Subject}
+
+		meta, err := jsm.ParseJSMsgMetadata(msg)
+		if err == nil {
+			values = append(values, "sequence", strconv.FormatUint(meta.StreamSequence(), 10))
+		}
+
+		if len(msg.Header) > 0 {
+			headers, err := json.Marshal(msg.Header)
+			if err != nil {
+				return err
+			}
+			values = append(values, "headers", headers)
+		}
+
+		values = append(values, "data", msg.Data)
+
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: r.key,
+			MaxLen: r.cfg.MaxLen,
+			Approx: !r.cfg.ExactTrim,
+			Values: values,
+		})
+	}
+
+	_, err := pipe.Exec(ctx)
+
+	return err
+}
+
+func (r *redisSink) close() error {
+	return r.client.Close()
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/jsm.go/api"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Redis Sink", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+		srv    *miniredis.Miniredis
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		srv = miniredis.NewMiniRedis()
+		srv.RequireAuth("s3cret")
+		Expect(srv.Start()).To(Succeed())
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+			srv.Close()
+		})
+	})
+
+	run := func(nc *nats.Conn, mgr *jsm.Manager, rcfg *config.Redis, count int) *Stream {
+		_, err := mgr.NewStream("ORDERS", jsm.Subjects("ORDERS.>"))
+		Expect(err).ToNot(HaveOccurred())
+
+		for i := 1; i <= count; i++ {
+			msg := nats.NewMsg(fmt.Sprintf("ORDERS.%d", i))
+			msg.Data = []byte(fmt.Sprintf("order %d", i))
+			msg.Header.Add(api.JSMsgId, fmt.Sprintf("%d", i))
+			_, err := nc.RequestMsg(msg, time.Second)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		scfg := &config.Stream{Stream: "ORDERS", SourceURL: nc.ConnectedUrl(), TargetRedis: rcfg}
+		cfg := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+		Expect(cfg.Validate()).To(Succeed())
+
+		stream, err := NewStream(scfg, cfg, log)
+		Expect(err).ToNot(HaveOccurred())
+
+		wg.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+		}()
+
+		return stream
+	}
+
+	entries := func(key string) func() ([]miniredis.StreamEntry, error) {
+		return func() ([]miniredis.StreamEntry, error) {
+			return srv.Stream(key)
+		}
+	}
+
+	It("Should add messages to the stream", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			run(nc, mgr, &config.Redis{URL: fmt.Sprintf("redis://:s3cret@%s/0", srv.Addr()), Delivery: config.Delivery{Batch: 5, FlushIntervalString: "200ms"}}, 5)
+
+			Eventually(entries("ORDERS"), "10s").Should(HaveLen(5))
+
+			stream, err := srv.Stream("ORDERS")
+			Expect(err).ToNot(HaveOccurred())
+			values := stream[0].Values
+			Expect(values).To(HaveLen(8))
+			Expect(values[0:4]).To(Equal([]string{"subject", "ORDERS.1", "sequence", "1"}))
+			Expect(values[4]).To(Equal("headers"))
+			Expect(values[5]).To(ContainSubstring(`"Nats-Msg-Id":["1"]`))
+			Expect(values[6:]).To(Equal([]string{"data", "order 1"}))
+			Expect(stream[4].Values).To(ContainElements("ORDERS.5", "order 5"))
+		})
+	})
+
+	It("Should trim the stream to max_len", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			stream := run(nc, mgr, &config.Redis{URL: fmt.Sprintf("redis://:s3cret@%s", srv.Addr()), Key: "orders", MaxLen: 3, ExactTrim: true}, 10)
+
+			Eventually(func() (uint64, error) {
+				consumer, err := mgr.LoadConsumer("ORDERS", stream.cname)
+				if err != nil {
+					return 0, err
+				}
+				nfo, err := consumer.State()
+				if err != nil {
+					return 0, err
+				}
+				return nfo.AckFloor.Stream, nil
+			}, "10s").Should(Equal(uint64(10)))
+
+			entries, err := srv.Stream("orders")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries).To(HaveLen(3))
+			Expect(entries[0].Values).To(ContainElement("order 8"))
+		})
+	})
+})
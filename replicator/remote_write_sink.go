@@ -0,0 +1,334 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/util"
+	"github.com/klauspost/compress/snappy"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// invalidMetricChars matches characters not allowed in metric names
+var invalidMetricChars = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+
+// remoteWriteSink converts JSON metric samples into Prometheus remote write requests
+type remoteWriteSink struct {
+	cfg    *config.RemoteWrite
+	client *http.Client
+	stream string
+	labels []string
+	log    *logrus.Entry
+}
+
+type remoteWriteLabel struct {
+	name  string
+	value string
+}
+
+type remoteWriteSample struct {
+	value     float64
+	timestamp int64
+}
+
+// remoteWriteSeries is a unique set of labels and its samples
+type remoteWriteSeries struct {
+	labels  []remoteWriteLabel
+	samples []remoteWriteSample
+}
+
+func (s *Stream) connectRemoteWrite() error {
+	rcfg := s.cfg.TargetRemoteWrite
+
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.MaxIdleConnsPerHost = rcfg.Concurrency
+
+	var err error
+	if s.cfg.TargetProxy != _EMPTY_ {
+		tr.Proxy = nil
+		tr.DialContext, err = util.ProxyDialer(s.cfg.TargetProxy, 30*time.Second)
+		if err != nil {
+			return fmt.Errorf("remote write target setup failed: %v", err)
+		}
+	}
+
+	if rcfg.TLS != nil {
+		tr.TLSClientConfig, err = util.ClientTLSConfig(rcfg.TLS.CA, rcfg.TLS.Cert, rcfg.TLS.Key)
+		if err != nil {
+			return fmt.Errorf("remote write target tls: %v", err)
+		}
+	}
+
+	var labels []string
+	for label := range rcfg.Labels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	log := s.log.WithField("connection", "remote_write")
+	log.Infof("Writing metric samples to %s", rcfg.URL)
+
+	s.batchSink = &remoteWriteSink{
+		cfg:    rcfg,
+		client: &http.Client{Transport: tr, Timeout: rcfg.Timeout},
+		stream: s.cfg.Stream,
+		labels: labels,
+		log:    log,
+	}
+	s.delivery = &rcfg.Delivery
+
+	return nil
+}
+
+// deliver writes the samples in msgs in one request, messages that can not be converted are logged and discarded
+func (r *remoteWriteSink) deliver(ctx context.Context, msgs []*nats.Msg) error {
+	var series []*remoteWriteSeries
+	index := make(map[string]*remoteWriteSeries)
+
+	for _, msg := range msgs {
+		meta, err := jsm.ParseJSMsgMetadata(msg)
+		if err != nil {
+			r.log.Errorf("Discarding message on %s without metadata: %v", msg.Subject, err)
+			continue
+		}
+
+		labels, sample, err := r.sample(msg, meta)
+		if err != nil {
+			r.log.Errorf("Discarding message %d on %s: %v", meta.StreamSequence(), msg.Subject, err)
+			continue
+		}
+
+		var key strings.Builder
+		for _, l := range labels {
+			key.WriteString(l.name)
+			key.WriteByte(0)
+			key.WriteString(l.value)
+			key.WriteByte(0)
+		}
+
+		ts, ok := index[key.String()]
+		if !ok {
+			ts = &remoteWriteSeries{labels: labels}
+			index[key.String()] = ts
+			series = append(series, ts)
+		}
+		ts.samples = append(ts.samples, sample)
+	}
+
+	if len(series) == 0 {
+		return nil
+	}
+
+	return r.write(ctx, snappy.Encode(nil, encodeWriteRequest(series)))
+}
+
+// sample extracts the labels and sample from the JSON payload of msg, labels are sorted by name
+func (r *remoteWriteSink) sample(msg *nats.Msg, meta *jsm.MsgInfo) ([]remoteWriteLabel, remoteWriteSample, error) {
+	var sample remoteWriteSample
+
+	dec := json.NewDecoder(bytes.NewReader(msg.Data))
+	dec.UseNumber()
+
+	var doc map[string]any
+	err := dec.Decode(&doc)
+	if err != nil {
+		return nil, sample, fmt.Errorf("invalid json payload: %v", err)
+	}
+
+	name := r.cfg.Metric
+	switch r.cfg.Name {
+	case _EMPTY_:
+	case "$subject":
+		name = invalidMetricChars.ReplaceAllString(msg.Subject, "_")
+	default:
+		name, _ = jsonField(doc, r.cfg.Name).(string)
+		if name == _EMPTY_ {
+			return nil, sample, fmt.Errorf("no metric name in field %s", r.cfg.Name)
+		}
+		if invalidMetricChars.MatchString(name) {
+			return nil, sample, fmt.Errorf("invalid metric name %q", name)
+		}
+	}
+
+	switch val := jsonField(doc, r.cfg.Value).(type) {
+	case json.Number:
+		sample.value, err = val.Float64()
+	case string:
+		sample.value, err = strconv.ParseFloat(val, 64)
+	default:
+		err = fmt.Errorf("not a number")
+	}
+	if err != nil {
+		return nil, sample, fmt.Errorf("invalid value in field %s: %v", r.cfg.Value, err)
+	}
+
+	ts := meta.TimeStamp()
+	if r.cfg.Timestamp != _EMPTY_ {
+		switch val := jsonField(doc, r.cfg.Timestamp).(type) {
+		case json.Number:
+			var secs float64
+			secs, err = val.Float64()
+			ts = time.UnixMilli(int64(secs * 1000))
+		case string:
+			ts, err = time.Parse(time.RFC3339Nano, val)
+		default:
+			err = fmt.Errorf("not a RFC3339 time or unix timestamp")
+		}
+		if err != nil {
+			return nil, sample, fmt.Errorf("invalid timestamp in field %s: %v", r.cfg.Timestamp, err)
+		}
+	}
+	sample.timestamp = ts.UnixMilli()
+
+	labels := []remoteWriteLabel{{name: "__name__", value: name}}
+	for _, label := range r.labels {
+		var val any
+
+		switch source := r.cfg.Labels[label]; source {
+		case "$stream":
+			val = r.stream
+		case "$subject":
+			val = msg.Subject
+		default:
+			val = jsonField(doc, source)
+		}
+
+		var value string
+		switch v := val.(type) {
+		case nil:
+		case string:
+			value = v
+		case json.Number:
+			value = v.String()
+		default:
+			j, err := json.Marshal(v)
+			if err != nil {
+				return nil, sample, err
+			}
+			value = string(j)
+		}
+
+		// labels with empty values are the same as missing labels
+		if value != _EMPTY_ {
+			labels = append(labels, remoteWriteLabel{name: label, value: value})
+		}
+	}
+
+	sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+
+	return labels, sample, nil
+}
+
+// encodeWriteRequest encodes series as a prometheus.WriteRequest protocol buffer
+func encodeWriteRequest(series []*remoteWriteSeries) []byte {
+	var req []byte
+
+	for _, s := range series {
+		// samples of a series have to be in time order
+		sort.SliceStable(s.samples, func(i, j int) bool { return s.samples[i].timestamp < s.samples[j].timestamp })
+
+		var ts []byte
+		for _, l := range s.labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, l.name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, l.value)
+
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, label)
+		}
+
+		for _, smpl := range s.samples {
+			var sample []byte
+			sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+			sample = protowire.AppendFixed64(sample, math.Float64bits(smpl.value))
+			sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+			sample = protowire.AppendVarint(sample, uint64(smpl.timestamp))
+
+			ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, sample)
+		}
+
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+
+	return req
+}
+
+// write posts the compressed write request, requests rejected by the server are logged and discarded as retrying
+// them can not succeed
+func (r *remoteWriteSink) write(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	for k, v := range r.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+
+	switch {
+	case r.cfg.Username != _EMPTY_:
+		req.SetBasicAuth(r.cfg.Username, r.cfg.Password)
+
+	case r.cfg.Token != _EMPTY_:
+		req.Header.Set("Authorization", "Bearer "+r.cfg.Token)
+
+	case r.cfg.TokenFile != _EMPTY_:
+		token, err := os.ReadFile(r.cfg.TokenFile)
+		if err != nil {
+			return fmt.Errorf("could not read token_file: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// reading the body allows the connection to be reused
+	rbody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("unexpected response %s: %s", resp.Status, strings.TrimSpace(string(rbody)))
+	default:
+		r.log.Errorf("Discarding samples rejected by the server with %s: %s", resp.Status, strings.TrimSpace(string(rbody)))
+		return nil
+	}
+}
+
+func (r *remoteWriteSink) close() error {
+	r.client.CloseIdleConnections()
+
+	return nil
+}
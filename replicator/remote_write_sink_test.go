@@ -0,0 +1,230 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/klauspost/compress/snappy"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var _ = Describe("Remote Write Sink", func() {
+	var (
+		ctx      context.Context
+		cancel   context.CancelFunc
+		wg       = sync.WaitGroup{}
+		log      *logrus.Entry
+		mu       sync.Mutex
+		requests []string
+		headers  http.Header
+		failures int
+		status   int
+		srv      *httptest.Server
+	)
+
+	// decodes a snappy compressed prometheus.WriteRequest into one line per series like name{labels} value@timestamp ...
+	decode := func(body []byte) string {
+		req, err := snappy.Decode(nil, body)
+		Expect(err).ToNot(HaveOccurred())
+
+		fields := func(b []byte, cb func(num protowire.Number, typ protowire.Type, b []byte) int) {
+			for len(b) > 0 {
+				num, typ, n := protowire.ConsumeTag(b)
+				Expect(n).To(BeNumerically(">", 0))
+				b = b[n:]
+				n = cb(num, typ, b)
+				Expect(n).To(BeNumerically(">", 0))
+				b = b[n:]
+			}
+		}
+
+		var lines []string
+		fields(req, func(_ protowire.Number, _ protowire.Type, b []byte) int {
+			ts, n := protowire.ConsumeBytes(b)
+
+			var labels, samples []string
+			fields(ts, func(num protowire.Number, _ protowire.Type, b []byte) int {
+				v, n := protowire.ConsumeBytes(b)
+				var parts []string
+				fields(v, func(_ protowire.Number, typ protowire.Type, b []byte) int {
+					switch typ {
+					case protowire.BytesType:
+						s, n := protowire.ConsumeString(b)
+						parts = append(parts, s)
+						return n
+					case protowire.Fixed64Type:
+						f, n := protowire.ConsumeFixed64(b)
+						parts = append(parts, fmt.Sprintf("%v", math.Float64frombits(f)))
+						return n
+					default:
+						i, n := protowire.ConsumeVarint(b)
+						parts = append(parts, fmt.Sprintf("%d", int64(i)))
+						return n
+					}
+				})
+
+				if num == 1 {
+					labels = append(labels, strings.Join(parts, "="))
+				} else {
+					samples = append(samples, strings.Join(parts, "@"))
+				}
+				return n
+			})
+
+			lines = append(lines, fmt.Sprintf("{%s} %s", strings.Join(labels, ","), strings.Join(samples, " ")))
+			return n
+		})
+
+		return strings.Join(lines, "\n")
+	}
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		requests = nil
+		failures = 0
+		status = http.StatusNoContent
+
+		srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+
+			body, err := io.ReadAll(r.Body)
+			Expect(err).ToNot(HaveOccurred())
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if failures > 0 {
+				failures--
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+
+			headers = r.Header
+			requests = append(requests, decode(body))
+			w.WriteHeader(status)
+		}))
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+			srv.Close()
+		})
+	})
+
+	run := func(nc *nats.Conn, mgr *jsm.Manager, rcfg *config.RemoteWrite, payloads ...string) (*jsm.Stream, *Stream) {
+		stream, err := mgr.NewStream("METRICS", jsm.Subjects("METRICS.>"))
+		Expect(err).ToNot(HaveOccurred())
+
+		for i, payload := range payloads {
+			_, err := nc.Request(fmt.Sprintf("METRICS.%d", i+1), []byte(payload), time.Second)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		scfg := &config.Stream{Stream: "METRICS", SourceURL: nc.ConnectedUrl(), TargetRemoteWrite: rcfg}
+		cfg := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+		Expect(cfg.Validate()).To(Succeed())
+
+		s, err := NewStream(scfg, cfg, log)
+		Expect(err).ToNot(HaveOccurred())
+
+		wg.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			Expect(s.Run(ctx, &wg)).ToNot(HaveOccurred())
+		}()
+
+		return stream, s
+	}
+
+	written := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return append([]string{}, requests...)
+	}
+
+	It("Should write samples grouped by series", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			mu.Lock()
+			failures = 1
+			mu.Unlock()
+
+			run(nc, mgr, &config.RemoteWrite{
+				URL:       srv.URL,
+				Value:     "reading.value",
+				Timestamp: "ts",
+				Labels:    map[string]string{"host": "host", "stream": "$stream", "Zone": "zone"},
+				Headers:   map[string]string{"X-Scope-OrgID": "fleet"},
+				Token:     "s3cret",
+				Delivery:  config.Delivery{Batch: 4, FlushIntervalString: "200ms"},
+			},
+				`{"name": "temperature", "host": "h1", "reading": {"value": 21.5}, "ts": 1688472000.5}`,
+				`{"name": "temperature", "host": "h1", "reading": {"value": "22"}, "ts": "2023-07-04T12:00:01Z"}`,
+				`{"name": "temperature", "host": "h1", "reading": {"value": "warm"}, "ts": 1688472002}`,
+				`{"name": "humidity", "host": "h2", "zone": 3, "reading": {"value": 40}, "ts": 1688472000}`,
+			)
+
+			Eventually(written, "10s").Should(HaveLen(1))
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			Expect(requests[0]).To(Equal(
+				"{__name__=temperature,host=h1,stream=METRICS} 21.5@1688472000500 22@1688472001000\n" +
+					"{Zone=3,__name__=humidity,host=h2,stream=METRICS} 40@1688472000000"))
+			Expect(headers.Get("Content-Encoding")).To(Equal("snappy"))
+			Expect(headers.Get("Content-Type")).To(Equal("application/x-protobuf"))
+			Expect(headers.Get("X-Prometheus-Remote-Write-Version")).To(Equal("0.1.0"))
+			Expect(headers.Get("X-Scope-OrgID")).To(Equal("fleet"))
+			Expect(headers.Get("Authorization")).To(Equal("Bearer s3cret"))
+		})
+	})
+
+	It("Should discard samples rejected by the server", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			mu.Lock()
+			status = http.StatusBadRequest
+			mu.Unlock()
+
+			stream, s := run(nc, mgr, &config.RemoteWrite{URL: srv.URL, Name: "$subject"}, `{"value": 1}`, `{"value": 2}`)
+
+			Eventually(written, "10s").Should(HaveLen(2))
+			Expect(written()[0]).To(HavePrefix("{__name__=METRICS_1} 1@"))
+
+			Eventually(func() (uint64, error) {
+				consumer, err := stream.LoadConsumer(s.cname)
+				if err != nil {
+					return 0, err
+				}
+				state, err := consumer.State()
+				if err != nil {
+					return 0, err
+				}
+				return state.AckFloor.Stream, nil
+			}, "10s").Should(Equal(uint64(2)))
+		})
+	})
+})
@@ -7,15 +7,23 @@ package replicator
 import (
 	"context"
 	"fmt"
+	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/choria-io/stream-replicator/advisor"
 	"github.com/choria-io/stream-replicator/backoff"
+	"github.com/choria-io/stream-replicator/clock"
 	"github.com/choria-io/stream-replicator/config"
 	"github.com/choria-io/stream-replicator/election"
+	"github.com/choria-io/stream-replicator/events"
 	"github.com/choria-io/stream-replicator/idtrack"
+	"github.com/choria-io/stream-replicator/internal/archive"
+	"github.com/choria-io/stream-replicator/internal/kubernetes"
+	"github.com/choria-io/stream-replicator/internal/script"
+	"github.com/choria-io/stream-replicator/internal/transform"
 	"github.com/choria-io/stream-replicator/internal/util"
 	"github.com/choria-io/stream-replicator/limiter/memory"
 	"github.com/nats-io/jsm.go"
@@ -34,18 +42,50 @@ type copier interface {
 }
 
 type Stream struct {
-	sr         *config.Config
-	cfg        *config.Stream
-	cname      string
-	log        *logrus.Entry
-	source     *Target
-	dest       *Target
-	limiter    Limiter
-	advisor    *advisor.Advisor
-	hcInterval time.Duration
-	paused     bool
-	copier     copier
-	mu         *sync.Mutex
+	sr          *config.Config
+	cfg         *config.Stream
+	cname       string
+	log         *logrus.Entry
+	source      *Target
+	dest        *Target
+	sink        sink
+	publisher   Publisher
+	batchSink   batchSink
+	delivery    *config.Delivery
+	messages    Source
+	archive     archive.Store
+	limiter     Limiter
+	budget      *memoryBudget
+	scheduler   *Scheduler
+	scheduled   *scheduledStream
+	bandwidth   *bandwidthLimiter
+	advisor     *advisor.Advisor
+	events      *events.Publisher
+	signer      *signer
+	verifier    *verifier
+	transform   *transform.Transform
+	script      *script.Script
+	transforms  *transformPool
+	hcInterval  time.Duration
+	paused      bool
+	maintenance bool
+	quota       quotaLevel
+	pressure    bool
+	quiesced    bool
+	templates   *templateStreams
+	subjects    *subjectTracker
+	delta       *deltaEncoder
+	ordering    *orderingVerifier
+	origin      *streamOrigin
+	abandoned   chan struct{}
+	clock       clock.Clock
+	retries     backoff.Policy
+	elections   backoff.Policy
+	hooks       hooks
+	copier      copier
+	ready       chan struct{}
+	lastActive  atomic.Int64
+	mu          *sync.Mutex
 }
 
 type Target struct {
@@ -58,16 +98,32 @@ type Target struct {
 	sub        *nats.Subscription
 	resumeSeq  uint64
 	resumeTime time.Time
+	// created and firstSeq are when the stream was created and its first sequence when last checked, used to detect it being recreated
+	created  time.Time
+	firstSeq uint64
+	// publishers are additional connections messages are published over
+	publishers []*nats.Conn
 }
 
 const (
+	// ConsumerDescriptionPrefix is the prefix used in the description of all consumers the replicator creates
+	ConsumerDescriptionPrefix = "Choria Stream Replicator"
+
 	pollFrequency    = 10 * time.Second
+	livenessInterval = 5 * time.Second
+	budgetInterval   = 100 * time.Millisecond
 	srcHeader        = "Choria-SR-Source"
-	srcHeaderPattern = "%s %d %s %s %d"
 	_EMPTY_          = ""
 )
 
 func (t *Target) Close() error {
+	for _, nc := range t.publishers {
+		err := nc.Drain()
+		if err != nil {
+			return err
+		}
+	}
+
 	if t.nc != nil {
 		err := t.nc.Drain()
 		if err != nil {
@@ -80,15 +136,12 @@ func (t *Target) Close() error {
 	return nil
 }
 
-func NewStream(stream *config.Stream, sr *config.Config, log *logrus.Entry) (*Stream, error) {
+func NewStream(stream *config.Stream, sr *config.Config, log *logrus.Entry, opts ...Option) (*Stream, error) {
 	if stream.Stream == _EMPTY_ {
 		return nil, fmt.Errorf("stream name is required")
 	}
-	if stream.SourceURL == _EMPTY_ {
-		return nil, fmt.Errorf("source_url is required")
-	}
-	if stream.TargetURL == _EMPTY_ {
-		return nil, fmt.Errorf("target_url is required")
+	if (stream.TargetKafka != nil || stream.TargetArchive != nil || stream.TargetFile != nil || stream.TargetHTTP != nil || stream.TargetPubSub != nil || stream.TargetSNS != nil || stream.TargetSQS != nil || stream.TargetElasticsearch != nil || stream.TargetClickHouse != nil || stream.TargetSyslog != nil || stream.TargetRedis != nil || stream.TargetPostgres != nil || stream.TargetRemoteWrite != nil || stream.TargetCore || stream.SourceKafka != nil || stream.SourceMQTT != nil || stream.SourceArchive != nil || stream.SourceFile != nil) && stream.TargetInitiated {
+		return nil, fmt.Errorf("kafka, mqtt, archive, file, http, cloud messaging, elasticsearch, clickhouse, syslog, redis, postgres, remote write and core NATS sources and targets can not be used with target initiated streams")
 	}
 	if stream.TargetStream == _EMPTY_ {
 		stream.TargetStream = stream.Stream
@@ -97,8 +150,18 @@ func NewStream(stream *config.Stream, sr *config.Config, log *logrus.Entry) (*St
 		return nil, fmt.Errorf("target initiated streams requires no_target_create to not be set")
 	}
 
+	var replicator string
+	var policies *config.Backoff
+	if sr != nil {
+		replicator = sr.ReplicatorName
+		policies = sr.Backoff
+	}
+
 	name := "stream_replicator"
-	if stream.Name != _EMPTY_ {
+	switch {
+	case stream.ConsumerName != _EMPTY_:
+		name = stream.DurableName(replicator)
+	case stream.Name != _EMPTY_:
 		if strings.HasPrefix("SR_", stream.Name) {
 			name = stream.Name
 		} else {
@@ -106,23 +169,116 @@ func NewStream(stream *config.Stream, sr *config.Config, log *logrus.Entry) (*St
 		}
 	}
 
-	return &Stream{
+	// every shard tracks its progress using its own consumer
+	if stream.Shard != nil {
+		name = fmt.Sprintf("%s_shard_%d", name, stream.Shard.Index)
+	}
+
+	s := &Stream{
 		sr:         sr,
 		cfg:        stream,
 		cname:      name,
 		mu:         &sync.Mutex{},
+		ready:      make(chan struct{}),
 		hcInterval: time.Minute,
 		paused:     stream.LeaderElectionName != _EMPTY_,
+		clock:      clock.New(),
+		retries:    policies.RetryPolicy(),
+		elections:  policies.ElectionPolicy(),
 		log: log.WithFields(logrus.Fields{
 			"source": stream.Stream,
 			"target": stream.TargetStream,
 		}),
-	}, nil
+	}
+
+	if stream.Shard != nil {
+		s.log = s.log.WithField("shard", stream.Shard.Index)
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	switch {
+	case s.messages == nil && stream.SourceServers() == _EMPTY_ && stream.SourceKafka == nil && stream.SourceMQTT == nil && stream.SourceArchive == nil && stream.SourceFile == nil:
+		return nil, fmt.Errorf("source_url, source_kafka, source_mqtt, source_archive or source_file is required")
+	case s.messages != nil && (stream.SourceServers() != _EMPTY_ || stream.SourceKafka != nil || stream.SourceMQTT != nil || stream.SourceArchive != nil || stream.SourceFile != nil):
+		return nil, fmt.Errorf("sources can not be used with source_url, source_kafka, source_mqtt, source_archive or source_file")
+	case s.messages != nil && (stream.TargetInitiated || stream.MonitorOnly):
+		return nil, fmt.Errorf("sources can not be used with target initiated or monitor only streams")
+	case s.publisher != nil && (stream.SourceKafka != nil || stream.SourceMQTT != nil || stream.SourceArchive != nil):
+		return nil, fmt.Errorf("publishers can only be used with source_url or source_file streams")
+	case s.publisher != nil && stream.TargetInitiated:
+		return nil, fmt.Errorf("publishers can not be used with target initiated streams")
+	case s.publisher == nil && stream.TargetServers() == _EMPTY_ && stream.TargetKafka == nil && stream.TargetArchive == nil && stream.TargetFile == nil && stream.TargetHTTP == nil && stream.TargetPubSub == nil && stream.TargetSNS == nil && stream.TargetSQS == nil && stream.TargetElasticsearch == nil && stream.TargetClickHouse == nil && stream.TargetSyslog == nil && stream.TargetRedis == nil && stream.TargetPostgres == nil && stream.TargetRemoteWrite == nil:
+		return nil, fmt.Errorf("target_url, target_kafka, target_archive, target_file, target_http, target_pubsub, target_sns, target_sqs, target_elasticsearch, target_clickhouse, target_syslog, target_redis, target_postgres or target_remote_write is required")
+	}
+
+	var err error
+
+	if sr != nil && sr.Signing != nil {
+		s.signer, err = newSigner(sr.Signing.SeedFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if stream.Verify != nil {
+		s.verifier, err = newVerifier(stream.Verify)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if stream.Transform != nil {
+		s.transform, err = transform.New(stream.Transform.Filter, stream.Transform.Mapping)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if stream.Script != nil {
+		s.script, err = script.New(stream.Script.File, stream.Script.Function, stream.Script.Timeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if stream.SubjectStats != nil {
+		s.subjects = newSubjectTracker(stream.SubjectStats.Limit, s.clock)
+	}
+
+	if stream.Delta != nil {
+		s.delta = newDeltaEncoder(stream, s.clock)
+	}
+
+	if s.scheduler != nil {
+		s.scheduled = s.scheduler.register(stream.Weight, stream.Priority)
+	}
+
+	if stream.Bandwidth != nil {
+		s.bandwidth = newBandwidthLimiter(stream.Bandwidth, s.clock)
+	}
+
+	return s, nil
 }
 
 func (s *Stream) Run(ctx context.Context, wg *sync.WaitGroup) error {
 	defer wg.Done()
 
+	err := s.run(ctx, wg)
+
+	select {
+	case <-s.ready:
+		s.streamStopped(err)
+	default:
+		s.reportError(err)
+	}
+
+	return err
+}
+
+func (s *Stream) run(ctx context.Context, wg *sync.WaitGroup) error {
 	var err error
 
 	err = s.connect(ctx)
@@ -131,6 +287,14 @@ func (s *Stream) Run(ctx context.Context, wg *sync.WaitGroup) error {
 		return err
 	}
 
+	if s.cfg.Spool != nil && s.sink != nil {
+		err = s.startSpool(ctx, wg)
+		if err != nil {
+			s.log.Errorf("Could not set up the spool: %v", err)
+			return err
+		}
+	}
+
 	if s.cfg.InspectJSONField != _EMPTY_ && s.cfg.InspectDuration > 0 {
 		nc, err := s.connectAdvisories(ctx)
 		if err != nil {
@@ -150,6 +314,19 @@ func (s *Stream) Run(ctx context.Context, wg *sync.WaitGroup) error {
 		}
 	}
 
+	if s.transform != nil && s.cfg.Transform.Workers > 0 {
+		s.transforms = newTransformPool(s)
+		s.transforms.start(ctx)
+	}
+
+	if s.cfg.MemoryBudget > 0 {
+		var tracker *idtrack.Tracker
+		if s.limiter != nil {
+			tracker = s.limiter.Tracker()
+		}
+		s.budget = newMemoryBudget(s.cfg.MemoryBudget, tracker)
+	}
+
 	if s.cfg.LeaderElectionName != _EMPTY_ {
 		err = s.setupElection(ctx)
 		if err != nil {
@@ -158,12 +335,58 @@ func (s *Stream) Run(ctx context.Context, wg *sync.WaitGroup) error {
 		}
 	}
 
-	if s.cfg.TargetInitiated {
+	if len(s.cfg.Maintenance) > 0 {
+		wg.Add(1)
+		go s.maintenanceWindows(ctx, wg, s.updateMaintenance())
+	}
+
+	if s.cfg.TargetQuota != nil {
+		wg.Add(1)
+		go s.enforceQuota(ctx, wg)
+	}
+
+	if s.cfg.StoragePressure != nil && s.dest != nil {
+		wg.Add(1)
+		go s.watchStoragePressure(ctx, wg)
+	}
+
+	if s.cfg.Drift != nil && s.dest != nil {
+		wg.Add(1)
+		go s.watchDrift(ctx, wg)
+	}
+
+	if s.cfg.VerifyOrdering {
+		s.ordering = newOrderingVerifier(s)
+		err = s.ordering.start(ctx)
+		if err != nil {
+			s.log.Errorf("Could not set up ordering verification: %v", err)
+			return err
+		}
+	}
+
+	switch {
+	case s.cfg.MonitorOnly:
+		s.copier = newMonitorCopier(s, s.log)
+	case s.messages != nil:
+		s.copier = newSourceCopier(s, s.log)
+	case s.cfg.SourceArchive != nil:
+		s.copier = newArchiveSourceCopier(s, s.log)
+	case s.cfg.SourceFile != nil:
+		s.copier = newFileSourceCopier(s, s.log)
+	case s.archive != nil:
+		s.copier = newArchiveCopier(s, s.log)
+	case s.batchSink != nil:
+		s.copier = newBatchCopier(s, s.log)
+	case s.cfg.TargetInitiated:
 		s.copier = newTargetInitiatedCopier(s, s.log)
-	} else {
+	default:
 		s.copier = newSourceInitiatedCopier(s, s.log)
 	}
 
+	s.markActive()
+	close(s.ready)
+	s.streamStarted()
+
 	err = s.copier.copyMessages(ctx)
 	if err != nil {
 		s.log.Errorf("Copier failed: %v", err)
@@ -176,13 +399,66 @@ func (s *Stream) Run(ctx context.Context, wg *sync.WaitGroup) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.source.Close()
-	s.dest.Close()
+	if s.source != nil {
+		s.source.Close()
+	}
+	if s.messages != nil {
+		err = s.messages.Close()
+		if err != nil {
+			s.log.Errorf("Could not close the source: %v", err)
+		}
+	}
+	if s.dest != nil {
+		s.dest.Close()
+	}
+	if s.sink != nil {
+		err = s.sink.close()
+		if err != nil {
+			s.log.Errorf("Could not close the target: %v", err)
+		}
+	}
+	if s.batchSink != nil {
+		err = s.batchSink.close()
+		if err != nil {
+			s.log.Errorf("Could not close the target: %v", err)
+		}
+	}
 
 	return nil
 }
 
-func (s *Stream) targetForSubject(subj string) string {
+// Ready is closed once the stream is connected, elections are set up and copying is about to start
+func (s *Stream) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// LastActive is the last time the copier loop was known to be running, used to detect wedged copiers
+func (s *Stream) LastActive() time.Time {
+	return time.Unix(0, s.lastActive.Load())
+}
+
+// Leading determines if the stream is not paused waiting to win its leader election
+func (s *Stream) Leading() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return !s.paused
+}
+
+// sourceConnected determines if the NATS connection to the source is up
+func (s *Stream) sourceConnected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.source != nil && s.source.nc != nil && s.source.nc.IsConnected()
+}
+
+func (s *Stream) markActive() {
+	s.lastActive.Store(time.Now().UnixNano())
+}
+
+// TargetForSubject determines the subject a message from subj would be published to on the target
+func (s *Stream) TargetForSubject(subj string) string {
 	if s.cfg.TargetPrefix != _EMPTY_ {
 		subj = fmt.Sprintf("%s.%s", s.cfg.TargetPrefix, subj)
 		subj = strings.Replace(subj, "..", ".", -1)
@@ -197,21 +473,11 @@ func (s *Stream) targetForSubject(subj string) string {
 }
 
 func (s *Stream) setupElection(ctx context.Context) error {
-	js, err := s.source.nc.JetStream()
-	if err != nil {
-		return err
-	}
-
-	kv, err := js.KeyValue("CHORIA_LEADER_ELECTION")
-	if err != nil {
-		return err
-	}
-
 	win := func() {
 		s.mu.Lock()
 		s.log.Warnf("Became the leader")
 		s.paused = false
-		if s.advisor != nil {
+		if s.advisor != nil && !s.pausedLocked() {
 			s.advisor.Resume()
 		}
 		s.mu.Unlock()
@@ -226,6 +492,9 @@ func (s *Stream) setupElection(ctx context.Context) error {
 		}
 
 		streamSequence.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Set(0)
+		pendingMessages.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Set(0)
+		consumerAckPendingMessages.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Set(0)
+		consumerRedeliveredMessages.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Set(0)
 
 		s.mu.Unlock()
 	}
@@ -234,17 +503,81 @@ func (s *Stream) setupElection(ctx context.Context) error {
 		s.advisor.Pause()
 	}
 
-	e, err := election.NewElection(s.cfg.LeaderElectionName, fmt.Sprintf("%s_%s", s.cname, s.cfg.Stream), kv, election.WithReplicator(s.sr.ReplicatorName), election.WithBackoff(backoff.FiveSec), election.OnWon(win), election.OnLost(lost))
+	if s.cfg.LeaderGroup != _EMPTY_ {
+		err := s.joinLeaderGroup(ctx, win, lost)
+		if err != nil {
+			return err
+		}
+
+		s.log.Infof("Joined leader group %s using candidate name %s", s.cfg.LeaderGroup, s.cfg.LeaderElectionName)
+		return nil
+	}
+
+	opts := []election.Option{election.WithReplicator(s.sr.ReplicatorName), election.WithBackoff(s.elections), election.WithWarnings(s.log.Warnf), election.OnWon(win), election.OnLost(lost)}
+	if s.cfg.StaleLeaderTimeout > 0 {
+		opts = append(opts, election.WithLiveness(s.LastActive, s.cfg.StaleLeaderTimeout))
+	}
+	preferred := false
+	if s.cfg.LeaderPreference != nil {
+		host, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+
+		preferred = s.cfg.LeaderPreference.Preferred(host, s.sr.NodeLabels)
+		opts = append(opts, election.WithLeaderPreference(preferred))
+	}
+
+	e, err := s.newElection(opts...)
 	if err != nil {
 		return err
 	}
 
 	go e.Start(ctx)
 
-	s.log.Infof("Set up leader election %s using candidate name %s", s.cname, s.cfg.LeaderElectionName)
+	switch {
+	case s.sr.Kubernetes != nil:
+		s.log.Infof("Set up leader election %s using Kubernetes Lease %s as %s", s.cname, s.leaseName(), s.sr.Kubernetes.Identity)
+	case s.cfg.LeaderPreference == nil:
+		s.log.Infof("Set up leader election %s using candidate name %s", s.cname, s.cfg.LeaderElectionName)
+	case preferred:
+		s.log.Infof("Set up leader election %s using candidate name %s as a preferred leader", s.cname, s.cfg.LeaderElectionName)
+	default:
+		s.log.Infof("Set up leader election %s using candidate name %s, leading only while no preferred leader is available", s.cname, s.cfg.LeaderElectionName)
+	}
+
 	return nil
 }
 
+// newElection creates a Kubernetes Lease election when running in Kubernetes mode else one using the source KV bucket
+func (s *Stream) newElection(opts ...election.Option) (election.Election, error) {
+	if k := s.sr.Kubernetes; k != nil {
+		client, err := kubernetes.InCluster(k.APIServer, k.Namespace)
+		if err != nil {
+			return nil, err
+		}
+
+		return election.NewLeaseElection(k.Identity, s.leaseName(), client, k.LeaseDuration, opts...)
+	}
+
+	js, err := s.source.nc.JetStream()
+	if err != nil {
+		return nil, err
+	}
+
+	kv, err := js.KeyValue("CHORIA_LEADER_ELECTION")
+	if err != nil {
+		return nil, err
+	}
+
+	return election.NewElection(s.cfg.LeaderElectionName, fmt.Sprintf("%s_%s", s.cname, s.cfg.Stream), kv, opts...)
+}
+
+// leaseName is the name of the Kubernetes Lease used for leader election
+func (s *Stream) leaseName() string {
+	return kubernetes.Name(fmt.Sprintf("stream-replicator-%s-%s", s.cname, s.cfg.Stream))
+}
+
 func (s *Stream) limitedProcess(msg *nats.Msg, cb func(msg *nats.Msg, process bool) error) error {
 	if s.limiter == nil {
 		return cb(msg, true)
@@ -253,31 +586,218 @@ func (s *Stream) limitedProcess(msg *nats.Msg, cb func(msg *nats.Msg, process bo
 	return s.limiter.ProcessAndRecord(msg, cb)
 }
 
+// verifyMessage checks the signature, checksum and tenant of msg when verification is enabled, returns false when msg
+// should not be copied
+func (s *Stream) verifyMessage(msg *nats.Msg) bool {
+	return s.verifySignature(msg) && s.verifyPayloadChecksum(msg) && s.verifyTenant(msg)
+}
+
+// verifySignature checks the signature of msg when verification is enabled, returns false when msg should not be copied
+func (s *Stream) verifySignature(msg *nats.Msg) bool {
+	if s.verifier == nil {
+		return true
+	}
+
+	err := s.verifier.verify(msg)
+	if err == nil {
+		return true
+	}
+
+	verifyFailedCount.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Inc()
+
+	if s.verifier.mode == config.VerifyFlag {
+		s.log.Debugf("Flagging message that failed verification: %v", err)
+		msg.Header.Set(VerifyFailedHeader, err.Error())
+		return true
+	}
+
+	s.log.Debugf("Dropping message that failed verification: %v", err)
+
+	return false
+}
+
+// verifyPayloadChecksum checks the checksum of msg when checksum verification is enabled, returns false when msg should
+// not be copied
+func (s *Stream) verifyPayloadChecksum(msg *nats.Msg) bool {
+	if s.cfg.VerifyChecksum == nil {
+		return true
+	}
+
+	err := verifyChecksum(msg, s.cfg.VerifyChecksum.Required)
+	if err == nil {
+		return true
+	}
+
+	checksumFailedCount.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Inc()
+
+	switch s.cfg.VerifyChecksum.Mode {
+	case config.VerifyDrop:
+		s.log.Debugf("Dropping message on %s that failed checksum verification: %v", msg.Subject, err)
+		return false
+
+	case config.VerifyFlag:
+		if msg.Header == nil {
+			msg.Header = nats.Header{}
+		}
+		msg.Header.Set(ChecksumFailedHeader, err.Error())
+	}
+
+	return true
+}
+
+// transformMessage applies the transform and then the script to msg when configured, returns false when msg should
+// not be copied
+func (s *Stream) transformMessage(msg *nats.Msg) bool {
+	if s.transform != nil {
+		keep, err := s.transform.Apply(msg)
+		if err != nil {
+			s.log.Warnf("Dropping message on %s that could not be transformed: %v", msg.Subject, err)
+		}
+		if !keep {
+			transformDiscardedCount.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Inc()
+			return false
+		}
+	}
+
+	if s.script != nil {
+		keep, err := s.script.Apply(msg)
+		if err != nil {
+			s.log.Warnf("Dropping message on %s that the script failed for: %v", msg.Subject, err)
+		}
+		if !keep {
+			scriptDiscardedCount.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Inc()
+			return false
+		}
+	}
+
+	return true
+}
+
+// transformMessages applies the transform to msgs using the transform workers when configured, the result holds for
+// every message if it should be copied
+func (s *Stream) transformMessages(ctx context.Context, msgs []*nats.Msg) ([]bool, error) {
+	if s.transforms != nil {
+		return s.transforms.apply(ctx, msgs)
+	}
+
+	keep := make([]bool, len(msgs))
+	for i, msg := range msgs {
+		keep[i] = s.transformMessage(msg)
+	}
+
+	return keep, nil
+}
+
+// shardMessage determines if msg belongs to the shard of this instance, all messages do when sharding is not enabled
+func (s *Stream) shardMessage(msg *nats.Msg) bool {
+	if s.cfg.Shard == nil || shardForSubject(msg.Subject, s.cfg.Shard.Count) == s.cfg.Shard.Index {
+		return true
+	}
+
+	shardSkippedCount.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Inc()
+
+	return false
+}
+
+// prepareMessage readies msg for publishing by adding the deduplication key, payload checksum and signature when enabled
+func (s *Stream) prepareMessage(msg *nats.Msg) error {
+	s.dedupMessage(msg)
+
+	if s.cfg.Checksum {
+		stampChecksum(msg)
+	}
+
+	if s.signer == nil {
+		return nil
+	}
+
+	return s.signer.sign(msg)
+}
+
 func (s *Stream) isPaused() bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.paused
+	return s.pausedLocked()
+}
+
+// pausedLocked determines if copying is paused for any reason, s.mu must be held
+func (s *Stream) pausedLocked() bool {
+	return s.paused || s.maintenance || s.quota == quotaExhausted || s.quiesced || s.pressure
 }
 
 func (s *Stream) connect(ctx context.Context) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if s.source != nil || s.dest != nil {
+	if s.source != nil || s.dest != nil || s.sink != nil || s.batchSink != nil || s.archive != nil {
 		return fmt.Errorf("already have connections")
 	}
 
+	switch {
+	case s.messages != nil:
+		return s.connectMessageSource(ctx)
+	case s.cfg.SourceKafka != nil:
+		s.messages = newKafkaSource(s)
+		return s.connectMessageSource(ctx)
+	case s.cfg.SourceMQTT != nil:
+		s.messages = newMQTTSource(s)
+		return s.connectMessageSource(ctx)
+	case s.cfg.SourceArchive != nil:
+		return s.connectArchiveSource(ctx)
+	case s.cfg.SourceFile != nil:
+		return s.connectFileSource(ctx)
+	}
+
 	err := s.connectSource(ctx)
 	if err != nil {
 		return err
 	}
 
-	err = s.connectDestination(ctx)
+	switch {
+	case s.cfg.MonitorOnly:
+		// the target stream is loaded on every check so it is neither created nor required to exist
+		if oerr := s.resolveOriginSubjects(s.source.cfg); oerr != nil {
+			s.log.Warnf("Could not determine the subjects the target stream is expected to have: %v", oerr)
+		}
+		s.dest, err = s.setupConnection(ctx, "target", s.cfg.TargetServers(), s.cfg.TargetTLS, s.cfg.TargetChoriaConn, s.cfg.TargetProcess, s.log.WithField("connection", "target"))
+	case s.publisher != nil:
+		s.sink = &publisherSink{p: s.publisher}
+	case s.cfg.TargetKafka != nil:
+		err = s.connectKafka(ctx)
+	case s.cfg.TargetArchive != nil:
+		err = s.connectArchive()
+	case s.cfg.TargetFile != nil:
+		err = s.connectFile()
+	case s.cfg.TargetHTTP != nil:
+		err = s.connectHTTP()
+	case s.cfg.TargetPubSub != nil:
+		err = s.connectPubSub(ctx)
+	case s.cfg.TargetSNS != nil:
+		err = s.connectSNS(ctx)
+	case s.cfg.TargetSQS != nil:
+		err = s.connectSQS(ctx)
+	case s.cfg.TargetElasticsearch != nil:
+		err = s.connectElasticsearch()
+	case s.cfg.TargetClickHouse != nil:
+		err = s.connectClickHouse()
+	case s.cfg.TargetSyslog != nil:
+		err = s.connectSyslog()
+	case s.cfg.TargetRedis != nil:
+		err = s.connectRedis(ctx)
+	case s.cfg.TargetPostgres != nil:
+		err = s.connectPostgres(ctx)
+	case s.cfg.TargetRemoteWrite != nil:
+		err = s.connectRemoteWrite()
+	case s.cfg.TargetCore:
+		err = s.connectCore(ctx)
+	default:
+		err = s.connectDestination(ctx)
+	}
 	if err != nil {
 		return err
 	}
 
-	if s.source == nil || s.dest == nil {
+	if s.source == nil || (s.dest == nil && s.sink == nil && s.batchSink == nil && s.archive == nil) {
 		return fmt.Errorf("connection setup failed")
 	}
 
@@ -285,18 +805,88 @@ func (s *Stream) connect(ctx context.Context) error {
 }
 
 func (s *Stream) connectAdvisories(ctx context.Context) (nc *nats.Conn, err error) {
-	return util.ConnectNats(ctx, "stream-replicator-advisories", s.cfg.SourceURL, s.cfg.SourceTLS, s.cfg.SourceChoriaConn, false, s.cfg.SourceProcess, s.log.WithField("connection", "advisories"))
+	return util.ConnectNats(ctx, "stream-replicator-advisories", s.cfg.SourceServers(), s.cfg.SourceTLS, s.cfg.SourceChoriaConn, false, s.cfg.SourceProcess, s.log.WithField("connection", "advisories"), s.connectOptions("advisories")...)
+}
+
+// connectOptions are the options used when setting up the connection
+func (s *Stream) connectOptions(connection string) []util.ConnectOption {
+	opts := []util.ConnectOption{util.WithReloadHandler(s.reloadHandler(connection))}
+
+	proxy := s.cfg.SourceProxy
+	contextOpts := s.cfg.SourceContextOptions()
+	if connection == "target" {
+		proxy = s.cfg.TargetProxy
+		contextOpts = s.cfg.TargetContextOptions()
+	}
+	opts = append(opts, contextOpts...)
+
+	if proxy != _EMPTY_ {
+		opts = append(opts, util.WithProxy(proxy))
+	}
+
+	return append(opts, s.cfg.Reconnect.ConnectOptions()...)
+}
+
+// fetchMaxBytes is the most message data to request at a time, 0 when unlimited
+func (s *Stream) fetchMaxBytes() int {
+	if s.cfg.Fetch == nil {
+		return 0
+	}
+
+	return s.cfg.Fetch.MaxBytes
+}
+
+// fetchMaxBytesExceeded determines if msg is the status ending a pull request as its next message exceeds max_bytes
+func fetchMaxBytesExceeded(msg *nats.Msg) bool {
+	return len(msg.Data) == 0 && msg.Header != nil && msg.Header.Get("Status") == "409" && strings.Contains(msg.Header.Get("Description"), "MaxBytes")
+}
+
+// reloadHandler publishes events when certificates or credentials used by connection are reloaded
+func (s *Stream) reloadHandler(connection string) util.ReloadHandler {
+	return func(kind string, file string, err error) {
+		event := &events.Event{
+			Stream: s.cfg.Stream,
+			Name:   s.cfg.Name,
+			Data: map[string]string{
+				"connection": connection,
+				"file":       file,
+			},
+		}
+
+		switch {
+		case kind == util.CredentialsReload && err != nil:
+			event.Event = events.CredentialsInvalidEvent
+			event.Message = fmt.Sprintf("Changed credentials %s could not be loaded: %v", file, err)
+		case kind == util.CredentialsReload:
+			event.Event = events.CredentialsReloadedEvent
+			event.Message = fmt.Sprintf("Reconnected using changed credentials %s", file)
+		case kind == util.SPIFFEReload && err != nil:
+			event.Event = events.CertificateInvalidEvent
+			event.Message = fmt.Sprintf("A new SPIFFE X.509 SVID for %s could not be obtained: %v", file, err)
+		case kind == util.SPIFFEReload:
+			event.Event = events.CertificateReloadedEvent
+			event.Message = fmt.Sprintf("Reconnected using a new SPIFFE X.509 SVID for %s", file)
+		case err != nil:
+			event.Event = events.CertificateInvalidEvent
+			event.Message = fmt.Sprintf("Changed certificate %s could not be loaded: %v", file, err)
+		default:
+			event.Event = events.CertificateReloadedEvent
+			event.Message = fmt.Sprintf("Reconnected using changed certificate %s", file)
+		}
+
+		s.events.Publish(event)
+	}
 }
 
 func (s *Stream) connectSource(ctx context.Context) (err error) {
 	log := s.log.WithField("connection", "source")
 
-	s.source, err = s.setupConnection(ctx, s.cfg.SourceURL, s.cfg.SourceTLS, s.cfg.SourceChoriaConn, s.cfg.SourceProcess, log)
+	s.source, err = s.setupConnection(ctx, "source", s.cfg.SourceServers(), s.cfg.SourceTLS, s.cfg.SourceChoriaConn, s.cfg.SourceProcess, log)
 	if err != nil {
 		return fmt.Errorf("source connection failed: %v", err)
 	}
 
-	err = backoff.TwentySec.For(ctx, func(try int) error {
+	err = s.retries.For(ctx, func(try int) error {
 		s.source.stream, err = s.source.mgr.LoadStream(s.cfg.Stream)
 		if err != nil {
 			log.Infof("Loading stream failed on try %d: %v", try, err)
@@ -311,7 +901,16 @@ func (s *Stream) connectSource(ctx context.Context) (err error) {
 
 	s.source.cfg = s.source.stream.Configuration()
 
-	return err
+	s.resolveOrigin(s.source.cfg)
+
+	nfo, err := s.source.stream.LatestInformation()
+	if err != nil {
+		return err
+	}
+	s.source.created = nfo.Created
+	s.source.firstSeq = nfo.State.FirstSeq
+
+	return nil
 }
 
 func (s *Stream) connectDestination(ctx context.Context) (err error) {
@@ -319,6 +918,30 @@ func (s *Stream) connectDestination(ctx context.Context) (err error) {
 	scfg := s.source.cfg
 	s.source.mu.Unlock()
 
+	err = s.resolveOriginSubjects(scfg)
+	if err != nil {
+		return err
+	}
+
+	err = s.connectTarget(ctx, s.targetStreamConfig(scfg))
+	if err != nil {
+		return err
+	}
+
+	return s.checkTargetStream()
+}
+
+// targetStreamConfig is the configuration the target stream is created with based on source stream configuration scfg
+func (s *Stream) targetStreamConfig(scfg api.StreamConfig) api.StreamConfig {
+	// mirrors and sourcing streams are copied into a stream holding the subjects of the streams they copy from
+	if scfg.Mirror != nil || len(scfg.Sources) > 0 {
+		scfg.Mirror = nil
+		scfg.Sources = nil
+		if s.origin != nil && len(s.origin.subjects) > 0 {
+			scfg.Subjects = s.origin.subjects
+		}
+	}
+
 	if s.cfg.TargetPrefix != _EMPTY_ || s.cfg.TargetRemoveString != _EMPTY_ {
 		var subjects []string
 
@@ -342,33 +965,97 @@ func (s *Stream) connectDestination(ctx context.Context) (err error) {
 		scfg.Subjects = subjects
 	}
 
+	// the target remembers deduplication keys for its duplicate window, which can not exceed its max age
+	if s.cfg.Dedup != nil && scfg.Duplicates < s.cfg.Dedup.Window {
+		scfg.Duplicates = s.cfg.Dedup.Window
+		if scfg.MaxAge > 0 && scfg.Duplicates > scfg.MaxAge {
+			scfg.Duplicates = scfg.MaxAge
+		}
+	}
+
+	return scfg
+}
+
+// withTargetSettings applies the configured target_settings to scfg, the configuration a target stream is created with
+func (s *Stream) withTargetSettings(scfg api.StreamConfig) api.StreamConfig {
+	t := s.cfg.TargetSettings
+	if t == nil {
+		return scfg
+	}
+
+	if t.Replicas > 0 {
+		scfg.Replicas = t.Replicas
+	}
+
+	if t.Cluster != _EMPTY_ || len(t.Tags) > 0 {
+		scfg.Placement = &api.Placement{Cluster: t.Cluster, Tags: t.Tags}
+	}
+
+	switch t.Storage {
+	case "file":
+		scfg.Storage = api.FileStorage
+	case "memory":
+		scfg.Storage = api.MemoryStorage
+	}
+
+	if t.MaxAge > 0 {
+		scfg.MaxAge = t.MaxAge
+		if scfg.Duplicates > scfg.MaxAge {
+			scfg.Duplicates = scfg.MaxAge
+		}
+	}
+
+	return scfg
+}
+
+// connectTarget connects to the target and creates the target stream based on scfg unless no_target_create is set
+func (s *Stream) connectTarget(ctx context.Context, scfg api.StreamConfig) (err error) {
 	log := s.log.WithField("connection", "target")
+	scfg = s.withTargetSettings(scfg)
 
-	s.dest, err = s.setupConnection(ctx, s.cfg.TargetURL, s.cfg.TargetTLS, s.cfg.TargetChoriaConn, s.cfg.TargetProcess, log)
+	s.dest, err = s.setupConnection(ctx, "target", s.cfg.TargetServers(), s.cfg.TargetTLS, s.cfg.TargetChoriaConn, s.cfg.TargetProcess, log)
 	if err != nil {
 		return fmt.Errorf("source connection failed: %v", err)
 	}
+	s.sink = &jetStreamSink{s: s, nc: s.dest.nc}
+
+	for i := 1; i < s.cfg.TargetConnections; i++ {
+		nc, err := util.ConnectNats(ctx, s.cfg.Stream, s.cfg.TargetServers(), s.cfg.TargetTLS, s.cfg.TargetChoriaConn, true, s.cfg.TargetProcess, log.WithField("publisher", i), s.connectOptions("target")...)
+		if err != nil {
+			return fmt.Errorf("target connection failed: %v", err)
+		}
+		s.dest.publishers = append(s.dest.publishers, nc)
+	}
 
 	if s.cfg.NoTargetCreate {
 		return nil
 	}
 
-	return backoff.TwentySec.For(ctx, func(try int) error {
+	if s.cfg.StreamTemplate != nil {
+		s.templates = newTemplateStreams(s, scfg)
+		return nil
+	}
+
+	return s.retries.For(ctx, func(try int) error {
 		s.dest.stream, err = s.dest.mgr.LoadOrNewStreamFromDefault(s.cfg.TargetStream, scfg)
 		if err != nil {
 			log.Infof("Loading stream failed on try %d: %v", try, err)
 			return err
 		}
 
+		if s.cfg.Dedup != nil && s.dest.stream.DuplicateWindow() < s.cfg.Dedup.Window {
+			log.Warnf("Target stream %s remembers deduplication keys for %v, less than the dedup window of %v", s.cfg.TargetStream, s.dest.stream.DuplicateWindow(), s.cfg.Dedup.Window)
+		}
+
 		return nil
 	})
 }
 
-func (s *Stream) setupConnection(ctx context.Context, url string, tls *config.TLS, choria *config.ChoriaConnection, inproc nats.InProcessConnProvider, log *logrus.Entry) (*Target, error) {
+func (s *Stream) setupConnection(ctx context.Context, connection string, url string, tls *config.TLS, choria *config.ChoriaConnection, inproc nats.InProcessConnProvider, log *logrus.Entry) (*Target, error) {
 	t := &Target{mu: &sync.Mutex{}}
 	var err error
 
-	t.nc, err = util.ConnectNats(ctx, s.cfg.Stream, url, tls, choria, true, inproc, log)
+	t.nc, err = util.ConnectNats(ctx, s.cfg.Stream, url, tls, choria, true, inproc, log, s.connectOptions(connection)...)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,173 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package replicatortest provides test doubles for testing applications that embed the replicator without a NATS
+// server for the target
+package replicatortest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// ErrClosed is returned when publishing to a closed Publisher
+var ErrClosed = errors.New("publisher closed")
+
+// Publisher is a replicator.Publisher that records published messages, errors and latency can be injected to
+// test redelivery and slow targets
+type Publisher struct {
+	msgs     []*nats.Msg
+	attempts int
+	errs     []error
+	err      error
+	latency  time.Duration
+	closed   bool
+	mu       sync.Mutex
+}
+
+// NewPublisher creates a Publisher recording all messages
+func NewPublisher() *Publisher {
+	return &Publisher{}
+}
+
+// Publish records a copy of msg unless an error was injected, waits for the configured latency first
+func (p *Publisher) Publish(ctx context.Context, msg *nats.Msg) error {
+	p.mu.Lock()
+	latency := p.latency
+	p.mu.Unlock()
+
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.attempts++
+
+	switch {
+	case p.closed:
+		return ErrClosed
+	case len(p.errs) > 0:
+		err := p.errs[0]
+		p.errs = p.errs[1:]
+		return err
+	case p.err != nil:
+		return p.err
+	}
+
+	// the replicator reuses messages and headers once published
+	cp := nats.NewMsg(msg.Subject)
+	cp.Reply = msg.Reply
+	cp.Data = append([]byte{}, msg.Data...)
+	for k, v := range msg.Header {
+		cp.Header[k] = append([]string{}, v...)
+	}
+	p.msgs = append(p.msgs, cp)
+
+	return nil
+}
+
+// Close closes the publisher, later publishes fail with ErrClosed
+func (p *Publisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+
+	return nil
+}
+
+// Closed determines if Close was called
+func (p *Publisher) Closed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.closed
+}
+
+// FailNext fails the next count publishes with err
+func (p *Publisher) FailNext(count int, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i := 0; i < count; i++ {
+		p.errs = append(p.errs, err)
+	}
+}
+
+// SetError fails all publishes with err until called with nil
+func (p *Publisher) SetError(err error) {
+	p.mu.Lock()
+	p.err = err
+	p.mu.Unlock()
+}
+
+// SetLatency delays every publish by d
+func (p *Publisher) SetLatency(d time.Duration) {
+	p.mu.Lock()
+	p.latency = d
+	p.mu.Unlock()
+}
+
+// Messages are copies of the published messages in the order they were published
+func (p *Publisher) Messages() []*nats.Msg {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]*nats.Msg{}, p.msgs...)
+}
+
+// Count is how many messages were published
+func (p *Publisher) Count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.msgs)
+}
+
+// Attempts is how many publishes were attempted including failed ones
+func (p *Publisher) Attempts() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.attempts
+}
+
+// Reset forgets published messages and injected errors
+func (p *Publisher) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.msgs = nil
+	p.errs = nil
+	p.err = nil
+	p.attempts = 0
+}
+
+// WaitForMessages waits until at least count messages were published or ctx is done
+func (p *Publisher) WaitForMessages(ctx context.Context, count int) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for p.Count() < count {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicatortest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/choria-io/stream-replicator/replicator"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestReplicatorTest(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Replicator Test Doubles")
+}
+
+var _ replicator.Publisher = (*Publisher)(nil)
+
+var _ = Describe("Publisher", func() {
+	var (
+		pub *Publisher
+		ctx context.Context
+	)
+
+	BeforeEach(func() {
+		pub = NewPublisher()
+		ctx = context.Background()
+	})
+
+	It("Should record copies of published messages", func() {
+		msg := nats.NewMsg("test")
+		msg.Reply = "reply"
+		msg.Data = []byte("one")
+		msg.Header.Add("X", "1")
+
+		Expect(pub.Publish(ctx, msg)).To(Succeed())
+
+		msg.Data[0] = 'x'
+		msg.Header.Set("X", "2")
+
+		msgs := pub.Messages()
+		Expect(msgs).To(HaveLen(1))
+		Expect(msgs[0].Subject).To(Equal("test"))
+		Expect(msgs[0].Reply).To(Equal("reply"))
+		Expect(msgs[0].Data).To(Equal([]byte("one")))
+		Expect(msgs[0].Header.Get("X")).To(Equal("1"))
+		Expect(pub.Count()).To(Equal(1))
+		Expect(pub.Attempts()).To(Equal(1))
+	})
+
+	It("Should support injecting errors", func() {
+		injected := errors.New("injected")
+
+		pub.FailNext(2, injected)
+		Expect(pub.Publish(ctx, nats.NewMsg("test"))).To(MatchError(injected))
+		Expect(pub.Publish(ctx, nats.NewMsg("test"))).To(MatchError(injected))
+		Expect(pub.Publish(ctx, nats.NewMsg("test"))).To(Succeed())
+
+		pub.SetError(injected)
+		Expect(pub.Publish(ctx, nats.NewMsg("test"))).To(MatchError(injected))
+		pub.SetError(nil)
+		Expect(pub.Publish(ctx, nats.NewMsg("test"))).To(Succeed())
+
+		Expect(pub.Count()).To(Equal(2))
+		Expect(pub.Attempts()).To(Equal(5))
+
+		pub.Reset()
+		Expect(pub.Count()).To(Equal(0))
+		Expect(pub.Attempts()).To(Equal(0))
+	})
+
+	It("Should support latency", func() {
+		pub.SetLatency(time.Hour)
+
+		tctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+
+		Expect(pub.Publish(tctx, nats.NewMsg("test"))).To(MatchError(context.DeadlineExceeded))
+		Expect(pub.Attempts()).To(Equal(0))
+
+		pub.SetLatency(10 * time.Millisecond)
+		start := time.Now()
+		Expect(pub.Publish(ctx, nats.NewMsg("test"))).To(Succeed())
+		Expect(time.Since(start)).To(BeNumerically(">=", 10*time.Millisecond))
+	})
+
+	It("Should fail once closed", func() {
+		Expect(pub.Closed()).To(BeFalse())
+		Expect(pub.Close()).To(Succeed())
+		Expect(pub.Closed()).To(BeTrue())
+		Expect(pub.Publish(ctx, nats.NewMsg("test"))).To(MatchError(ErrClosed))
+		Expect(pub.Count()).To(Equal(0))
+	})
+
+	It("Should wait for messages", func() {
+		tctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+
+		Expect(pub.WaitForMessages(tctx, 1)).To(MatchError(context.DeadlineExceeded))
+
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			pub.Publish(ctx, nats.NewMsg("test"))
+		}()
+
+		tctx, cancel = context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		Expect(pub.WaitForMessages(tctx, 1)).To(Succeed())
+	})
+})
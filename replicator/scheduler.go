@@ -0,0 +1,245 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/clock"
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// schedulerInterval is how often bandwidth is handed to streams waiting for it
+	schedulerInterval = 10 * time.Millisecond
+
+	// scheduleProgressInterval is how often messages waiting for bandwidth are marked as in progress, well below
+	// the ack wait of consumers so waiting does not cause redeliveries
+	scheduleProgressInterval = 5 * time.Second
+)
+
+// Scheduler shares a bandwidth limit between streams, streams waiting for bandwidth receive it in proportion to their
+// weights or, in priority mode, in order of their priority. Bandwidth is handed out at most once a second ahead
+// so idle periods do not allow long bursts.
+type Scheduler struct {
+	rate     float64
+	priority bool
+	budget   float64
+	last     time.Time
+	vtime    float64
+	streams  []*scheduledStream
+	clock    clock.Clock
+	mu       sync.Mutex
+}
+
+// scheduledStream is a stream sharing the bandwidth of a Scheduler
+type scheduledStream struct {
+	weight   float64
+	priority int
+	// vtime is the bytes granted to the stream divided by its weight, the stream with the lowest is served next
+	vtime   float64
+	waiting []*scheduleRequest
+}
+
+type scheduleRequest struct {
+	size    int64
+	granted chan struct{}
+}
+
+// NewScheduler creates a scheduler sharing the bandwidth configured in cfg, Run has to be called for streams to
+// receive bandwidth
+func NewScheduler(cfg *config.Scheduler) *Scheduler {
+	clk := clock.New()
+
+	return &Scheduler{
+		rate:     float64(cfg.BytesPerSecond),
+		priority: cfg.Mode == config.SchedulerPriority,
+		budget:   float64(cfg.BytesPerSecond),
+		last:     clk.Now(),
+		clock:    clk,
+	}
+}
+
+// WithScheduler copies messages within the bandwidth shared using s, only streams with a source_url that are not
+// target initiated or archived are scheduled
+func WithScheduler(s *Scheduler) Option {
+	return func(st *Stream) { st.scheduler = s }
+}
+
+// Run hands out bandwidth to waiting streams until ctx is done
+func (s *Scheduler) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := s.clock.NewTicker(schedulerInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			s.mu.Lock()
+			s.dispatchLocked()
+			s.mu.Unlock()
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// register adds a stream with weight and priority
+func (s *Scheduler) register(weight int, priority int) *scheduledStream {
+	if weight < 1 {
+		weight = 1
+	}
+
+	st := &scheduledStream{weight: float64(weight), priority: priority}
+
+	s.mu.Lock()
+	s.streams = append(s.streams, st)
+	s.mu.Unlock()
+
+	return st
+}
+
+// enqueue requests size bytes of bandwidth for st, the request is granted once its channel is closed
+func (s *Scheduler) enqueue(st *scheduledStream, size int64) *scheduleRequest {
+	req := &scheduleRequest{size: size, granted: make(chan struct{})}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// streams that were idle do not receive the bandwidth they did not use
+	if len(st.waiting) == 0 && st.vtime < s.vtime {
+		st.vtime = s.vtime
+	}
+	st.waiting = append(st.waiting, req)
+	s.dispatchLocked()
+
+	return req
+}
+
+// acquire waits till size bytes of bandwidth were granted to st, progress is called regularly while waiting
+func (s *Scheduler) acquire(ctx context.Context, st *scheduledStream, size int64, progress func()) error {
+	req := s.enqueue(st, size)
+
+	ticker := s.clock.NewTicker(scheduleProgressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-req.granted:
+			return nil
+
+		case <-ticker.C():
+			progress()
+
+		case <-ctx.Done():
+			s.mu.Lock()
+			for i, r := range st.waiting {
+				if r == req {
+					st.waiting = append(st.waiting[:i], st.waiting[i+1:]...)
+					break
+				}
+			}
+			s.mu.Unlock()
+
+			return ctx.Err()
+		}
+	}
+}
+
+// dispatchLocked grants bandwidth available since the last dispatch to waiting streams, must be called with s.mu held
+func (s *Scheduler) dispatchLocked() {
+	now := s.clock.Now()
+	s.budget += now.Sub(s.last).Seconds() * s.rate
+	if s.budget > s.rate {
+		s.budget = s.rate
+	}
+	s.last = now
+
+	// a message larger than the budget is granted once any budget is available and then delays later ones
+	for s.budget > 0 {
+		st := s.nextLocked()
+		if st == nil {
+			return
+		}
+
+		req := st.waiting[0]
+		st.waiting = st.waiting[1:]
+
+		s.vtime = st.vtime
+		s.budget -= float64(req.size)
+		st.vtime += float64(req.size) / st.weight
+
+		close(req.granted)
+	}
+}
+
+// nextLocked is the waiting stream to serve next, nil when none are waiting, must be called with s.mu held
+func (s *Scheduler) nextLocked() *scheduledStream {
+	var next *scheduledStream
+
+	for _, st := range s.streams {
+		if len(st.waiting) == 0 {
+			continue
+		}
+
+		switch {
+		case next == nil:
+			next = st
+		case s.priority && st.priority != next.priority:
+			if st.priority > next.priority {
+				next = st
+			}
+		case st.vtime < next.vtime:
+			next = st
+		}
+	}
+
+	return next
+}
+
+// scheduleMessages waits for bandwidth to copy msgs when a scheduler or bandwidth schedule is used, msgs are marked
+// as in progress while waiting
+func (s *Stream) scheduleMessages(ctx context.Context, msgs ...*nats.Msg) error {
+	if s.scheduled == nil && s.bandwidth == nil {
+		return nil
+	}
+
+	var size int64
+	for _, msg := range msgs {
+		size += messageSize(msg)
+	}
+
+	progress := func() {
+		for _, msg := range msgs {
+			if msg.Reply != _EMPTY_ {
+				msg.InProgress()
+			}
+		}
+	}
+
+	start := time.Now()
+	defer func() {
+		scheduleWaitTime.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Add(time.Since(start).Seconds())
+	}()
+
+	if s.bandwidth != nil {
+		err := s.bandwidth.wait(ctx, size, progress)
+		bandwidthLimit.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Set(float64(s.bandwidth.limit()))
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.scheduled == nil {
+		return nil
+	}
+
+	return s.scheduler.acquire(ctx, s.scheduled, size, progress)
+}
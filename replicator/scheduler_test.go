@@ -0,0 +1,147 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/clock"
+	"github.com/choria-io/stream-replicator/config"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Scheduler", func() {
+	var clk *clock.Mock
+
+	BeforeEach(func() {
+		clk = clock.NewMock(time.Now())
+	})
+
+	// newScheduler creates a scheduler without bandwidth left
+	newScheduler := func(rate int64, mode string) *Scheduler {
+		s := NewScheduler(&config.Scheduler{BytesPerSecond: rate, Mode: mode})
+		s.clock = clk
+		s.last = clk.Now()
+		s.budget = 0
+
+		return s
+	}
+
+	// granted counts the granted requests
+	granted := func(reqs []*scheduleRequest) int {
+		cnt := 0
+		for _, req := range reqs {
+			select {
+			case <-req.granted:
+				cnt++
+			default:
+			}
+		}
+
+		return cnt
+	}
+
+	// enqueue requests count messages of 1000 bytes for st
+	enqueue := func(s *Scheduler, st *scheduledStream, count int) []*scheduleRequest {
+		var reqs []*scheduleRequest
+		for i := 0; i < count; i++ {
+			reqs = append(reqs, s.enqueue(st, 1000))
+		}
+
+		return reqs
+	}
+
+	// dispatch grants the bandwidth available after d
+	dispatch := func(s *Scheduler, d time.Duration) {
+		clk.Add(d)
+
+		s.mu.Lock()
+		s.dispatchLocked()
+		s.mu.Unlock()
+	}
+
+	It("Should share bandwidth by weight", func() {
+		s := newScheduler(12000, config.SchedulerFair)
+		heavy := s.register(3, 0)
+		light := s.register(1, 0)
+
+		heavyReqs := enqueue(s, heavy, 20)
+		lightReqs := enqueue(s, light, 20)
+		Expect(granted(heavyReqs) + granted(lightReqs)).To(Equal(0))
+
+		dispatch(s, time.Second)
+		Expect(granted(heavyReqs)).To(Equal(9))
+		Expect(granted(lightReqs)).To(Equal(3))
+	})
+
+	It("Should not let idle streams catch up on unused bandwidth", func() {
+		s := newScheduler(10000, config.SchedulerFair)
+		busy := s.register(1, 0)
+		idle := s.register(1, 0)
+
+		busyReqs := enqueue(s, busy, 10)
+		dispatch(s, time.Second)
+		Expect(granted(busyReqs)).To(Equal(10))
+
+		busyReqs = enqueue(s, busy, 10)
+		idleReqs := enqueue(s, idle, 10)
+
+		dispatch(s, 400*time.Millisecond)
+		Expect(granted(busyReqs)).To(Equal(2))
+		Expect(granted(idleReqs)).To(Equal(2))
+	})
+
+	It("Should serve streams by priority", func() {
+		s := newScheduler(8000, config.SchedulerPriority)
+		low := s.register(10, 0)
+		high := s.register(1, 1)
+
+		lowReqs := enqueue(s, low, 20)
+		highReqs := enqueue(s, high, 5)
+
+		dispatch(s, time.Second)
+		Expect(granted(highReqs)).To(Equal(5))
+		Expect(granted(lowReqs)).To(Equal(3))
+	})
+
+	It("Should only allow bursts of a second", func() {
+		s := newScheduler(5000, config.SchedulerFair)
+		st := s.register(1, 0)
+
+		dispatch(s, time.Hour)
+		Expect(granted(enqueue(s, st, 10))).To(Equal(5))
+	})
+
+	It("Should limit bandwidth", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		wg := sync.WaitGroup{}
+		s := NewScheduler(&config.Scheduler{BytesPerSecond: 10000})
+		wg.Add(1)
+		go s.Run(ctx, &wg)
+
+		st := s.register(1, 0)
+		start := time.Now()
+		for i := 0; i < 4; i++ {
+			Expect(s.acquire(ctx, st, 5000, func() {})).To(Succeed())
+		}
+		// a second of bandwidth is available at start, later messages wait for it
+		Expect(time.Since(start)).To(BeNumerically(">=", 450*time.Millisecond))
+
+		waiting, cancelWait := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancelWait()
+		Expect(s.acquire(waiting, st, 5000, func() {})).To(MatchError(context.DeadlineExceeded))
+		s.mu.Lock()
+		Expect(st.waiting).To(BeEmpty())
+		s.mu.Unlock()
+
+		cancel()
+		wg.Wait()
+	})
+})
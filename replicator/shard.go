@@ -0,0 +1,26 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"hash/fnv"
+)
+
+// shardForSubject is the shard out of count owning subject, using a jump consistent hash so that changing count moves
+// as few subjects as possible to other shards
+func shardForSubject(subject string, count int) int {
+	h := fnv.New64a()
+	h.Write([]byte(subject))
+	key := h.Sum64()
+
+	var b, j int64 = -1, 0
+	for j < int64(count) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+
+	return int(b)
+}
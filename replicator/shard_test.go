@@ -0,0 +1,130 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Sharding", func() {
+	Describe("shardForSubject", func() {
+		It("Should spread subjects over shards and move few when the count changes", func() {
+			counts := make([]int, 4)
+			moved := 0
+
+			for i := 0; i < 10000; i++ {
+				subject := fmt.Sprintf("orders.%d", i)
+
+				shard := shardForSubject(subject, 4)
+				Expect(shardForSubject(subject, 4)).To(Equal(shard))
+				counts[shard]++
+
+				if next := shardForSubject(subject, 5); next != shard {
+					Expect(next).To(Equal(4))
+					moved++
+				}
+			}
+
+			for _, c := range counts {
+				Expect(c).To(BeNumerically("~", 2500, 250))
+			}
+			Expect(moved).To(BeNumerically("~", 2000, 250))
+		})
+	})
+
+	Describe("copyMessages", func() {
+		var (
+			ctx    context.Context
+			cancel context.CancelFunc
+			wg     = sync.WaitGroup{}
+			log    *logrus.Entry
+		)
+
+		BeforeEach(func() {
+			ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+			logger := logrus.New()
+			logger.SetOutput(GinkgoWriter)
+			log = logrus.NewEntry(logger)
+
+			DeferCleanup(func() {
+				cancel()
+				wg.Wait()
+			})
+		})
+
+		It("Should copy every subject using exactly one shard", func() {
+			testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+				ts, err := mgr.NewStream("ORDERS", jsm.Subjects("ORDERS.>"))
+				Expect(err).ToNot(HaveOccurred())
+				tcs, err := mgr.NewStream("ORDERS_COPY", jsm.Subjects("copy.>"))
+				Expect(err).ToNot(HaveOccurred())
+
+				for i := 0; i < 500; i++ {
+					_, err := nc.Request(fmt.Sprintf("ORDERS.%d", i%50), []byte("x"), time.Second)
+					Expect(err).ToNot(HaveOccurred())
+				}
+
+				var streams []*Stream
+				for i := 0; i < 2; i++ {
+					scfg := &config.Stream{
+						Stream:       "ORDERS",
+						TargetStream: "ORDERS_COPY",
+						TargetPrefix: "copy",
+						SourceURL:    nc.ConnectedUrl(),
+						TargetURL:    nc.ConnectedUrl(),
+						Shard:        &config.Shard{Count: 2, Index: i},
+					}
+					sr := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+					Expect(sr.Validate()).To(Succeed())
+
+					stream, err := NewStream(scfg, sr, log)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(stream.cname).To(Equal(fmt.Sprintf("SR_GINKGO_shard_%d", i)))
+					streams = append(streams, stream)
+
+					wg.Add(1)
+					go func() {
+						defer GinkgoRecover()
+						Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+					}()
+				}
+
+				Eventually(func() (uint64, error) {
+					nfo, err := tcs.State()
+					return nfo.Msgs, err
+				}, "10s").Should(Equal(uint64(500)))
+
+				// both shards consumed the entire stream
+				for _, stream := range streams {
+					Eventually(func() (uint64, error) {
+						consumer, err := ts.LoadConsumer(stream.cname)
+						if err != nil {
+							return 0, err
+						}
+						state, err := consumer.State()
+						return state.AckFloor.Stream, err
+					}, "10s").Should(Equal(uint64(500)))
+				}
+
+				Consistently(func() (uint64, error) {
+					nfo, err := tcs.State()
+					return nfo.Msgs, err
+				}, "500ms").Should(Equal(uint64(500)))
+			})
+		})
+	})
+})
@@ -0,0 +1,111 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+)
+
+const (
+	// SignatureHeader holds the base64 encoded ed25519 signature of the message payload
+	SignatureHeader = "Choria-SR-Signature"
+	// SignerHeader holds the public key of the replicator that signed the message
+	SignerHeader = "Choria-SR-Signer"
+	// VerifyFailedHeader is added to messages that failed signature verification in flag mode
+	VerifyFailedHeader = "Choria-SR-Verify-Failed"
+)
+
+// signer adds detached signatures of message payloads to messages
+type signer struct {
+	kp  nkeys.KeyPair
+	pub string
+}
+
+func newSigner(seedFile string) (*signer, error) {
+	contents, err := os.ReadFile(seedFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read signing seed: %w", err)
+	}
+
+	kp, err := nkeys.ParseDecoratedNKey(contents)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing seed in %s: %w", seedFile, err)
+	}
+
+	pub, err := kp.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return &signer{kp: kp, pub: pub}, nil
+}
+
+// sign signs the payload of msg replacing any signature added by earlier replicators
+func (s *signer) sign(msg *nats.Msg) error {
+	sig, err := s.kp.Sign(msg.Data)
+	if err != nil {
+		return err
+	}
+
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+
+	msg.Header.Set(SignatureHeader, base64.StdEncoding.EncodeToString(sig))
+	msg.Header.Set(SignerHeader, s.pub)
+
+	return nil
+}
+
+// verifier checks signatures added by trusted replicators
+type verifier struct {
+	keys map[string]nkeys.KeyPair
+	mode string
+}
+
+func newVerifier(cfg *config.Verify) (*verifier, error) {
+	v := &verifier{keys: make(map[string]nkeys.KeyPair), mode: cfg.Mode}
+
+	for _, k := range cfg.PublicKeys {
+		kp, err := nkeys.FromPublicKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %q: %w", k, err)
+		}
+
+		v.keys[k] = kp
+	}
+
+	return v, nil
+}
+
+// verify checks the signature of msg, the error describes why verification failed
+func (v *verifier) verify(msg *nats.Msg) error {
+	if msg.Header == nil || msg.Header.Get(SignatureHeader) == "" {
+		return fmt.Errorf("not signed")
+	}
+
+	kp, ok := v.keys[msg.Header.Get(SignerHeader)]
+	if !ok {
+		return fmt.Errorf("signed by untrusted key %q", msg.Header.Get(SignerHeader))
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(msg.Header.Get(SignatureHeader))
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding")
+	}
+
+	err = kp.Verify(msg.Data, sig)
+	if err != nil {
+		return fmt.Errorf("invalid signature")
+	}
+
+	return nil
+}
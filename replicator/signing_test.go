@@ -0,0 +1,211 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Signing", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+		kp     nkeys.KeyPair
+		pub    string
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+
+		var err error
+		kp, err = nkeys.CreateUser()
+		Expect(err).ToNot(HaveOccurred())
+		pub, err = kp.PublicKey()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	setup := func(nc *nats.Conn, mgr *jsm.Manager, unsigned int) (*config.Config, *config.Stream, *jsm.Stream) {
+		_, err := mgr.NewStream("TEST")
+		Expect(err).ToNot(HaveOccurred())
+		tcs, err := mgr.NewStream("TEST_COPY", jsm.Subjects("copy.>"))
+		Expect(err).ToNot(HaveOccurred())
+
+		for i := 1; i <= unsigned; i++ {
+			_, err := nc.Request("TEST", []byte(fmt.Sprintf(`{"unsigned":%d}`, i)), time.Second)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		stream := &config.Stream{
+			Stream:       "TEST",
+			TargetStream: "TEST_COPY",
+			TargetPrefix: "copy",
+			SourceURL:    nc.ConnectedUrl(),
+			TargetURL:    nc.ConnectedUrl(),
+		}
+
+		return &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{stream}}, stream, tcs
+	}
+
+	// for use with Eventually()
+	streamMesssage := func(s *jsm.Stream) func() (uint64, error) {
+		return func() (uint64, error) {
+			nfo, err := s.State()
+			if err != nil {
+				return 0, err
+			}
+			return nfo.Msgs, nil
+		}
+	}
+
+	publishSigned := func(nc *nats.Conn, count int) {
+		for i := 1; i <= count; i++ {
+			msg := nats.NewMsg("TEST")
+			msg.Data = []byte(fmt.Sprintf(`{"signed":%d}`, i))
+			sig, err := kp.Sign(msg.Data)
+			Expect(err).ToNot(HaveOccurred())
+			msg.Header.Set(SignatureHeader, base64.StdEncoding.EncodeToString(sig))
+			msg.Header.Set(SignerHeader, pub)
+			_, err = nc.RequestMsg(msg, time.Second)
+			Expect(err).ToNot(HaveOccurred())
+		}
+	}
+
+	run := func(scfg *config.Stream, sr *config.Config) {
+		stream, err := NewStream(scfg, sr, log)
+		Expect(err).ToNot(HaveOccurred())
+
+		go func() {
+			defer GinkgoRecover()
+			wg.Add(1)
+			Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+		}()
+	}
+
+	It("Should sign copied messages", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			sr, scfg, tcs := setup(nc, mgr, 10)
+
+			seed, err := kp.Seed()
+			Expect(err).ToNot(HaveOccurred())
+			seedFile := filepath.Join(GinkgoT().TempDir(), "signing.nk")
+			Expect(os.WriteFile(seedFile, seed, 0600)).To(Succeed())
+
+			sr.Signing = &config.Signing{SeedFile: seedFile}
+			run(scfg, sr)
+			defer cancel()
+
+			Eventually(streamMesssage(tcs)).Should(BeNumerically("==", 10))
+
+			msg, err := tcs.ReadMessage(1)
+			Expect(err).ToNot(HaveOccurred())
+			hdrs, err := decodeHeadersMsg(msg.Header)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hdrs.Get(SignerHeader)).To(Equal(pub))
+
+			v, err := newVerifier(&config.Verify{PublicKeys: []string{pub}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(v.verify(&nats.Msg{Data: msg.Data, Header: hdrs})).To(Succeed())
+			Expect(v.verify(&nats.Msg{Data: []byte("tampered"), Header: hdrs})).To(MatchError("invalid signature"))
+		})
+	})
+
+	It("Should sign transformed messages", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			sr, scfg, tcs := setup(nc, mgr, 10)
+
+			seed, err := kp.Seed()
+			Expect(err).ToNot(HaveOccurred())
+			seedFile := filepath.Join(GinkgoT().TempDir(), "signing.nk")
+			Expect(os.WriteFile(seedFile, seed, 0600)).To(Succeed())
+
+			sr.Signing = &config.Signing{SeedFile: seedFile}
+			scfg.Transform = &config.Transform{Filter: "data.unsigned % 2 == 0", Mapping: `{"id": data.unsigned}`}
+			run(scfg, sr)
+			defer cancel()
+
+			Eventually(streamMesssage(tcs)).Should(BeNumerically("==", 5))
+			Consistently(streamMesssage(tcs), "500ms").Should(BeNumerically("==", 5))
+
+			msg, err := tcs.ReadMessage(1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(msg.Data).To(MatchJSON(`{"id":2}`))
+			hdrs, err := decodeHeadersMsg(msg.Header)
+			Expect(err).ToNot(HaveOccurred())
+
+			v, err := newVerifier(&config.Verify{PublicKeys: []string{pub}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(v.verify(&nats.Msg{Data: msg.Data, Header: hdrs})).To(Succeed())
+		})
+	})
+
+	It("Should drop messages failing verification", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			sr, scfg, tcs := setup(nc, mgr, 5)
+			publishSigned(nc, 5)
+
+			scfg.Verify = &config.Verify{PublicKeys: []string{pub}, Mode: config.VerifyDrop}
+			run(scfg, sr)
+			defer cancel()
+
+			Eventually(streamMesssage(tcs)).Should(BeNumerically("==", 5))
+			Consistently(streamMesssage(tcs), "500ms").Should(BeNumerically("==", 5))
+
+			msg, err := tcs.ReadMessage(1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(msg.Data)).To(Equal(`{"signed":1}`))
+		})
+	})
+
+	It("Should flag messages failing verification", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			sr, scfg, tcs := setup(nc, mgr, 5)
+			publishSigned(nc, 5)
+
+			scfg.Verify = &config.Verify{PublicKeys: []string{pub}, Mode: config.VerifyFlag}
+			run(scfg, sr)
+			defer cancel()
+
+			Eventually(streamMesssage(tcs)).Should(BeNumerically("==", 10))
+
+			msg, err := tcs.ReadMessage(1)
+			Expect(err).ToNot(HaveOccurred())
+			hdrs, err := decodeHeadersMsg(msg.Header)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hdrs.Get(VerifyFailedHeader)).To(Equal("not signed"))
+
+			msg, err = tcs.ReadMessage(6)
+			Expect(err).ToNot(HaveOccurred())
+			hdrs, err = decodeHeadersMsg(msg.Header)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hdrs.Get(VerifyFailedHeader)).To(BeEmpty())
+		})
+	})
+})
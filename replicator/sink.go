@@ -0,0 +1,55 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"time"
+
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats.go"
+)
+
+// sink receives the messages copied by a stream, streams copying to a target JetStream Stream use a jetStreamSink and
+// the target_* settings select others when connecting
+type sink interface {
+	// publish stores msg, the copier acknowledges it to the source once publish returns without error
+	publish(ctx context.Context, msg *nats.Msg) error
+	close() error
+}
+
+// batchSink receives batches of messages in place of a target JetStream Stream
+type batchSink interface {
+	deliver(ctx context.Context, msgs []*nats.Msg) error
+	close() error
+}
+
+// jetStreamSink publishes messages to the target JetStream Stream and waits for them to be stored
+type jetStreamSink struct {
+	s  *Stream
+	nc *nats.Conn
+}
+
+func (j *jetStreamSink) publish(_ context.Context, msg *nats.Msg) error {
+	resp, err := j.nc.RequestMsg(msg, 2*time.Second)
+	if err != nil {
+		return err
+	}
+
+	err = jsm.ParseErrorResponse(resp)
+	if err != nil {
+		j.s.targetPublishFailed(err)
+		return err
+	}
+
+	j.s.recordDuplicate(resp)
+
+	return nil
+}
+
+// close does nothing, the connection is closed with the target
+func (j *jetStreamSink) close() error {
+	return nil
+}
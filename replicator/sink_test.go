@@ -0,0 +1,58 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Sink", func() {
+	var log *logrus.Entry
+
+	BeforeEach(func() {
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+	})
+
+	It("Should publish to the target JetStream Stream by default", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("TEST", jsm.Subjects("TEST"))
+			Expect(err).ToNot(HaveOccurred())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			scfg := &config.Stream{Stream: "TEST", TargetStream: "COPY", TargetPrefix: "copy", SourceURL: nc.ConnectedUrl(), TargetURL: nc.ConnectedUrl()}
+			stream, err := NewStream(scfg, &config.Config{ReplicatorName: "GINKGO"}, log)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stream.connect(ctx)).To(Succeed())
+			Expect(stream.sink).To(BeAssignableToTypeOf(&jetStreamSink{}))
+
+			msg := nats.NewMsg("copy.TEST")
+			msg.Data = []byte("hello")
+			Expect(stream.sink.publish(ctx, msg)).To(Succeed())
+
+			// no stream holds the subject
+			Expect(stream.sink.publish(ctx, nats.NewMsg("other"))).ToNot(Succeed())
+
+			target, err := mgr.LoadStream("COPY")
+			Expect(err).ToNot(HaveOccurred())
+			nfo, err := target.State()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(nfo.Msgs).To(Equal(uint64(1)))
+		})
+	})
+})
@@ -0,0 +1,219 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// Source supplies the messages a stream copies in place of a JetStream source_url, see WithSource. Messages are
+// copied one at a time in the order Next returns them
+type Source interface {
+	// Open connects to the source, it is called once before the first call to Next
+	Open(ctx context.Context) error
+	// Next waits for the next message to copy until ctx is done, it should return a nil message without error when
+	// none arrived for a few seconds so the stream is known to be alive
+	Next(ctx context.Context) (*SourceMessage, error)
+	// Subjects are the subjects of messages the source delivers, the target stream is created with them
+	Subjects() []string
+	// Close is called once the stream stops
+	Close() error
+}
+
+// SourceMessage is a message read from a Source
+type SourceMessage struct {
+	// Msg is the message to copy, its subject is the one in the source before target_subject_prefix and
+	// target_subject_remove are applied
+	Msg *nats.Msg
+	// Source names where the message was read from, like a topic, it is recorded in the Choria-SR-Source header
+	Source string
+	// Sequence is the position of the message in Source, -1 when not known
+	Sequence int64
+	// Time is when the message was stored in the source, when set messages older than max_age are skipped
+	Time time.Time
+	// Ack is called once the message was copied or skipped, it is not called for messages being retried when the
+	// stream stops
+	Ack func() error
+}
+
+// sourceCopier copies messages read from a Source to the target, messages are retried until they are stored and
+// only then acknowledged to the source
+type sourceCopier struct {
+	s       *Stream
+	sr      *config.Config
+	cfg     *config.Stream
+	source  Source
+	copied  int64
+	skipped int64
+	log     *logrus.Entry
+}
+
+func newSourceCopier(s *Stream, log *logrus.Entry) *sourceCopier {
+	return &sourceCopier{
+		s:      s,
+		sr:     s.sr,
+		cfg:    s.cfg,
+		source: s.messages,
+		log:    log.WithField("copier", "source"),
+	}
+}
+
+// connectMessageSource opens the Source and connects to the target, creating the target stream with the subjects of
+// the source
+func (s *Stream) connectMessageSource(ctx context.Context) error {
+	err := s.messages.Open(ctx)
+	if err != nil {
+		return err
+	}
+
+	if s.publisher != nil {
+		s.sink = &publisherSink{p: s.publisher}
+		return nil
+	}
+
+	scfg := jsm.DefaultStream
+	scfg.Subjects = nil
+	for _, subject := range s.messages.Subjects() {
+		scfg.Subjects = append(scfg.Subjects, s.TargetForSubject(subject))
+	}
+
+	err = s.connectTarget(ctx, scfg)
+	if err != nil {
+		return err
+	}
+
+	if s.dest == nil {
+		return fmt.Errorf("connection setup failed")
+	}
+
+	return nil
+}
+
+func (c *sourceCopier) copyMessages(ctx context.Context) error {
+	c.log.Infof("Starting data copier for %s", c.cfg.TargetStream)
+
+	for try := 1; ; {
+		c.s.markActive()
+
+		m, err := c.source.Next(ctx)
+		switch {
+		case ctx.Err() != nil:
+			c.log.Warnf("Copier shutting down after context interrupt")
+			return nil
+
+		case err != nil:
+			handlerErrorCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+			c.s.reportError(err)
+			c.log.Errorf("Reading from the source failed on try %d: %v", try, err)
+			if c.s.retries.TrySleep(ctx, try) != nil {
+				c.log.Warnf("Copier shutting down after context interrupt")
+				return nil
+			}
+			try++
+			continue
+
+		case m == nil:
+			continue
+		}
+
+		try = 1
+
+		// messages are acknowledged in order so a failing message is retried until it succeeds
+		err = c.s.retries.For(ctx, func(try int) error {
+			err := c.handler(ctx, m)
+			if err != nil {
+				handlerErrorCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+				c.s.reportError(err)
+				c.log.Errorf("Handling message from %s failed on try %d: %v", m.Source, try, err)
+			}
+
+			return err
+		})
+		if err != nil {
+			c.log.Warnf("Copier shutting down after context interrupt")
+			return nil
+		}
+
+		if m.Ack != nil {
+			err = m.Ack()
+			if err != nil {
+				ackFailedCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+				c.log.Errorf("ACK failed: %v", err)
+			}
+		}
+	}
+}
+
+func (c *sourceCopier) handler(ctx context.Context, m *SourceMessage) error {
+	receivedMessageCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+	receivedMessageSize.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Add(float64(len(m.Msg.Data)))
+	obs := prometheus.NewTimer(processTime.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name))
+	defer obs.ObserveDuration()
+
+	ts := m.Time
+	if !ts.IsZero() {
+		messageAge.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Observe(time.Since(ts).Seconds())
+
+		if c.cfg.MaxAgeDuration > 0 && time.Since(ts) > c.cfg.MaxAgeDuration {
+			ageSkippedCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+			return nil
+		}
+	} else {
+		ts = time.Now()
+	}
+
+	// messages are copied again when retried, the one from the source is left untouched
+	msg := nats.NewMsg(c.s.TargetForSubject(m.Msg.Subject))
+	msg.Data = m.Msg.Data
+	for k, vals := range m.Msg.Header {
+		msg.Header[k] = append([]string{}, vals...)
+	}
+	msg.Header.Add(srcHeader, srcHeaderValue(m.Source, m.Sequence, c.sr.ReplicatorName, c.cfg.Name, ts.UnixMilli()))
+
+	if !c.s.verifyMessage(msg) || !c.s.transformMessage(msg) {
+		atomic.AddInt64(&c.skipped, 1)
+		return nil
+	}
+
+	return c.s.limitedProcess(msg, func(msg *nats.Msg, process bool) error {
+		if !process {
+			atomic.AddInt64(&c.skipped, 1)
+			skippedMessageCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+			skippedMessageSize.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Add(float64(len(msg.Data)))
+			return nil
+		}
+
+		err := c.s.prepareMessage(msg)
+		if err != nil {
+			return err
+		}
+
+		err = c.s.sink.publish(ctx, msg)
+		if err != nil {
+			return err
+		}
+
+		copied := atomic.AddInt64(&c.copied, 1)
+		if copied%1000 == 0 {
+			c.log.Infof("Copied %d message(s), skipped %d", copied, atomic.LoadInt64(&c.skipped))
+		}
+
+		copiedMessageCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+		copiedMessageSize.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Add(float64(len(msg.Data)))
+		c.s.subjects.record(msg.Subject, len(msg.Data))
+		c.s.messageCopied(msg)
+
+		return nil
+	})
+}
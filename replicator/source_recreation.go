@@ -0,0 +1,70 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/choria-io/stream-replicator/events"
+	"github.com/nats-io/jsm.go"
+)
+
+// sourceRecreated detects the source stream being deleted and created again since it was last checked by its creation
+// time changing or its first sequence going back. When recreated the sampling and ordering state tied to the sequences
+// of the old stream is reset and an event is published, callers have to restart their consumer at the start of the new
+// stream. Must be called with the source lock held.
+func (s *Stream) sourceRecreated() (bool, error) {
+	nfo, err := s.source.stream.Information()
+	if jsm.IsNatsError(err, 10059) {
+		// deleted and not created again yet, consumer health checks report this
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var reason string
+	switch {
+	case !s.source.created.IsZero() && !nfo.Created.Equal(s.source.created):
+		reason = fmt.Sprintf("it was created at %v, previously at %v", nfo.Created, s.source.created)
+	case nfo.State.FirstSeq < s.source.firstSeq:
+		reason = fmt.Sprintf("its first sequence went back from %d to %d", s.source.firstSeq, nfo.State.FirstSeq)
+	}
+
+	s.source.created = nfo.Created
+	s.source.firstSeq = nfo.State.FirstSeq
+
+	if reason == _EMPTY_ {
+		return false, nil
+	}
+
+	sourceRecreatedCount.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Inc()
+	s.log.Warnf("Source stream %s was recreated, %s, restarting replication at its first message", s.cfg.Stream, reason)
+
+	// sampled items were copied from the old stream, the new one has to be copied afresh
+	if s.limiter != nil && s.limiter.Tracker() != nil {
+		s.limiter.Tracker().ResetCopied()
+	}
+
+	if s.ordering != nil {
+		s.ordering.reset()
+	}
+
+	s.events.Publish(&events.Event{
+		Event:   events.SourceRecreatedEvent,
+		Stream:  s.cfg.Stream,
+		Name:    s.cfg.Name,
+		Message: fmt.Sprintf("Source stream %s was recreated, %s", s.cfg.Stream, reason),
+		Data: map[string]string{
+			"created":        nfo.Created.UTC().Format(time.RFC3339Nano),
+			"first_sequence": strconv.FormatUint(nfo.State.FirstSeq, 10),
+			"last_sequence":  strconv.FormatUint(nfo.State.LastSeq, 10),
+		},
+	})
+
+	return true, nil
+}
@@ -0,0 +1,189 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/choria-io/stream-replicator/replicator/replicatortest"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+// testSource is a Source delivering the messages added to it and recording their acknowledgements
+type testSource struct {
+	msgs   chan *SourceMessage
+	acked  []int64
+	opened bool
+	closed bool
+	mu     sync.Mutex
+}
+
+func newTestSource() *testSource {
+	return &testSource{msgs: make(chan *SourceMessage, 100)}
+}
+
+func (t *testSource) add(subject string, seq int64, ts time.Time) {
+	msg := nats.NewMsg(subject)
+	msg.Data = []byte(fmt.Sprintf("message %d", seq))
+
+	t.msgs <- &SourceMessage{Msg: msg, Source: "orders", Sequence: seq, Time: ts, Ack: func() error {
+		t.mu.Lock()
+		t.acked = append(t.acked, seq)
+		t.mu.Unlock()
+		return nil
+	}}
+}
+
+func (t *testSource) Open(_ context.Context) error {
+	t.mu.Lock()
+	t.opened = true
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *testSource) Next(ctx context.Context) (*SourceMessage, error) {
+	select {
+	case m := <-t.msgs:
+		return m, nil
+	case <-time.After(100 * time.Millisecond):
+		return nil, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (t *testSource) Subjects() []string {
+	return []string{"orders.>"}
+}
+
+func (t *testSource) Close() error {
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *testSource) ackedSequences() []int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]int64{}, t.acked...)
+}
+
+var _ = Describe("Source", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+		src    *testSource
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+		src = newTestSource()
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	run := func(scfg *config.Stream, opts ...Option) {
+		sr := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+
+		stream, err := NewStream(scfg, sr, log, append(opts, WithSource(src))...)
+		Expect(err).ToNot(HaveOccurred())
+
+		wg.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+		}()
+	}
+
+	It("Should copy messages to the target stream", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			run(&config.Stream{Stream: "ORDERS", TargetPrefix: "copy", TargetURL: nc.ConnectedUrl(), MaxAgeDuration: time.Hour})
+
+			src.add("orders.1", 1, time.Now())
+			src.add("orders.2", 2, time.Now().Add(-2*time.Hour))
+			src.add("orders.3", 3, time.Time{})
+
+			Eventually(src.ackedSequences, "5s").Should(Equal([]int64{1, 2, 3}))
+
+			target, err := mgr.LoadStream("ORDERS")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(target.Subjects()).To(Equal([]string{"copy.orders.>"}))
+
+			nfo, err := target.State()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(nfo.Msgs).To(Equal(uint64(2)))
+
+			msg, err := target.ReadMessage(2)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(msg.Subject).To(Equal("copy.orders.3"))
+			Expect(string(msg.Data)).To(Equal("message 3"))
+
+			hdrs, err := decodeHeadersMsg(msg.Header)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hdrs.Get(srcHeader)).To(HavePrefix("orders 3 GINKGO"))
+
+			src.mu.Lock()
+			Expect(src.opened).To(BeTrue())
+			src.mu.Unlock()
+		})
+	})
+
+	It("Should retry messages till published before acknowledging them", func() {
+		pub := replicatortest.NewPublisher()
+		pub.FailNext(2, errors.New("injected failure"))
+
+		run(&config.Stream{Stream: "ORDERS"}, WithPublisher(pub))
+
+		for i := int64(1); i <= 5; i++ {
+			src.add(fmt.Sprintf("orders.%d", i), i, time.Now())
+		}
+
+		Expect(pub.WaitForMessages(ctx, 5)).To(Succeed())
+		Eventually(src.ackedSequences, "5s").Should(Equal([]int64{1, 2, 3, 4, 5}))
+		Expect(pub.Attempts()).To(Equal(7))
+		Expect(pub.Messages()[0].Subject).To(Equal("orders.1"))
+		Expect(pub.Messages()[0].Header.Values(srcHeader)).To(HaveLen(1))
+
+		cancel()
+		wg.Wait()
+
+		src.mu.Lock()
+		Expect(src.closed).To(BeTrue())
+		src.mu.Unlock()
+		Expect(pub.Closed()).To(BeTrue())
+	})
+
+	It("Should not support sources with configured sources or target initiated streams", func() {
+		_, err := NewStream(&config.Stream{Stream: "ORDERS", SourceURL: "nats://localhost:4222", TargetURL: "nats://localhost:4222"}, &config.Config{ReplicatorName: "GINKGO"}, log, WithSource(src))
+		Expect(err).To(MatchError("sources can not be used with source_url, source_kafka, source_mqtt, source_archive or source_file"))
+
+		_, err = NewStream(&config.Stream{Stream: "ORDERS", TargetURL: "nats://localhost:4222", TargetInitiated: true}, &config.Config{ReplicatorName: "GINKGO"}, log, WithSource(src))
+		Expect(err).To(MatchError("sources can not be used with target initiated or monitor only streams"))
+
+		_, err = NewStream(&config.Stream{Stream: "ORDERS", TargetURL: "nats://localhost:4222"}, &config.Config{ReplicatorName: "GINKGO"}, log)
+		Expect(err).To(MatchError("source_url, source_kafka, source_mqtt, source_archive or source_file is required"))
+	})
+})
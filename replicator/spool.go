@@ -0,0 +1,647 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// spoolSegmentSize is the size spool segments grow to before a new one is started, drained segments are removed
+	spoolSegmentSize = 16 << 20
+	// spoolRecordHeader is the length and checksum preceding every spooled message
+	spoolRecordHeader = 8
+	spoolSegmentExt   = ".spool"
+	spoolCorruptExt   = ".corrupt"
+	spoolPositionFile = "position"
+)
+
+var (
+	errSpoolFull = errors.New("spool is full")
+	// errSpoolCorrupt is a record that is complete but can not be decoded, the records following it are still readable
+	errSpoolCorrupt = errors.New("corrupt spool record")
+	// errSpoolTruncated is a record extending past the end of its segment, nothing following it in the segment is readable
+	errSpoolTruncated = errors.New("incomplete spool record")
+)
+
+// spoolRecord is a message stored in the spool
+type spoolRecord struct {
+	Subject string      `json:"subject"`
+	Header  nats.Header `json:"header,omitempty"`
+	Data    []byte      `json:"data"`
+}
+
+// spoolSink buffers messages on local disk while the target is unreachable and publishes them in order once it is
+// reachable again. Messages are only published directly while the spool is empty, once a message is spooled later
+// ones are spooled behind it until the spool is drained
+type spoolSink struct {
+	s        *Stream
+	sink     sink
+	dir      string
+	maxBytes int64
+	segments []uint64
+	writer   *os.File
+	wsize    int64
+	reader   *os.File
+	offset   int64
+	size     int64
+	count    int
+	notify   chan struct{}
+	closed   bool
+	log      *logrus.Entry
+
+	mu sync.Mutex
+}
+
+// startSpool wraps the sink of the stream in a spool and starts draining messages left in it by previous runs
+func (s *Stream) startSpool(ctx context.Context, wg *sync.WaitGroup) error {
+	p, err := newSpoolSink(s, s.sink, s.cfg.Spool)
+	if err != nil {
+		return err
+	}
+
+	s.sink = p
+
+	wg.Add(1)
+	go p.drain(ctx, wg)
+
+	return nil
+}
+
+func newSpoolSink(s *Stream, sink sink, cfg *config.Spool) (*spoolSink, error) {
+	p := &spoolSink{
+		s:        s,
+		sink:     sink,
+		dir:      cfg.Directory,
+		maxBytes: cfg.MaxBytes,
+		notify:   make(chan struct{}, 1),
+		log:      s.log.WithField("spool", cfg.Directory),
+	}
+
+	err := os.MkdirAll(p.dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.load()
+	if err != nil {
+		return nil, fmt.Errorf("could not load spool %s: %v", p.dir, err)
+	}
+
+	if p.count > 0 {
+		p.log.Warnf("Draining %d message(s) spooled by a previous run", p.count)
+		p.notify <- struct{}{}
+	}
+	p.updateStats()
+
+	return p, nil
+}
+
+func (p *spoolSink) segmentPath(id uint64) string {
+	return filepath.Join(p.dir, fmt.Sprintf("%020d%s", id, spoolSegmentExt))
+}
+
+// quarantine moves the unreadable data of segment id from offset onward to a file next to the spool and removes it from the segment
+func (p *spoolSink) quarantine(id uint64, offset int64) error {
+	f, err := os.OpenFile(p.segmentPath(id), os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(filepath.Join(p.dir, fmt.Sprintf("%020d-%d%s", id, offset, spoolCorruptExt)), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, f)
+	if err == nil {
+		err = out.Sync()
+	}
+	out.Close()
+	if err != nil {
+		return err
+	}
+
+	return f.Truncate(offset)
+}
+
+// load finds the segments and position left by a previous run and counts the messages still to be drained, unreadable
+// data like a message partially written when the replicator stopped is quarantined
+func (p *spoolSink) load() error {
+	entries, err := os.ReadDir(p.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), spoolSegmentExt) {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(entry.Name(), spoolSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		p.segments = append(p.segments, id)
+	}
+	sort.Slice(p.segments, func(i, j int) bool { return p.segments[i] < p.segments[j] })
+
+	if len(p.segments) == 0 {
+		os.Remove(filepath.Join(p.dir, spoolPositionFile))
+		return nil
+	}
+
+	pos, err := os.ReadFile(filepath.Join(p.dir, spoolPositionFile))
+	if err == nil {
+		id, offset, ok := strings.Cut(strings.TrimSpace(string(pos)), " ")
+		if ok && id == strconv.FormatUint(p.segments[0], 10) {
+			p.offset, _ = strconv.ParseInt(offset, 10, 64)
+		}
+	}
+
+	for i, id := range p.segments {
+		var start int64
+		if i == 0 {
+			start = p.offset
+		}
+
+		count, corrupt, valid, size, err := p.scanSegment(id, start)
+		if err != nil {
+			return err
+		}
+
+		if corrupt > 0 {
+			p.log.Errorf("Skipping %d corrupt message(s) in spool segment %d", corrupt, id)
+		}
+
+		if valid < size {
+			p.log.Warnf("Quarantining %d bytes of incomplete or corrupt messages at the end of spool segment %d", size-valid, id)
+			err = p.quarantine(id, valid)
+			if err != nil {
+				return err
+			}
+		}
+
+		p.count += count
+		p.size += valid - start
+		if i == len(p.segments)-1 {
+			p.wsize = valid
+		}
+	}
+
+	return nil
+}
+
+// scanSegment counts the messages in a segment after start and how many of them are corrupt, valid is the offset up to
+// where the segment is readable
+func (p *spoolSink) scanSegment(id uint64, start int64) (count int, corrupt int, valid int64, size int64, err error) {
+	f, err := os.Open(p.segmentPath(id))
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	defer f.Close()
+
+	nfo, err := f.Stat()
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	size = nfo.Size()
+
+	valid = start
+	for valid < size {
+		_, n, err := readSpoolRecord(f, valid, size)
+		switch {
+		case errors.Is(err, errSpoolCorrupt):
+			// corrupt messages are counted until they are skipped while draining
+			count++
+			corrupt++
+		case errors.Is(err, errSpoolTruncated):
+			return count, corrupt, valid, size, nil
+		case err != nil:
+			return 0, 0, 0, 0, err
+		default:
+			count++
+		}
+		valid += n
+	}
+
+	return count, corrupt, valid, size, nil
+}
+
+// readSpoolRecord reads the record at offset in a segment of size bytes, n is its size including the header and is also
+// set for records failing with errSpoolCorrupt so they can be skipped
+func readSpoolRecord(f *os.File, offset int64, size int64) (rec *spoolRecord, n int64, err error) {
+	if offset+spoolRecordHeader > size {
+		return nil, 0, errSpoolTruncated
+	}
+
+	var hdr [spoolRecordHeader]byte
+	_, err = f.ReadAt(hdr[:], offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	length := binary.BigEndian.Uint32(hdr[0:4])
+	n = int64(length) + spoolRecordHeader
+	if offset+n > size {
+		return nil, 0, errSpoolTruncated
+	}
+
+	body := make([]byte, length)
+	_, err = f.ReadAt(body, offset+spoolRecordHeader)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(hdr[4:8]) {
+		return nil, n, fmt.Errorf("%w: checksum mismatch", errSpoolCorrupt)
+	}
+
+	rec = &spoolRecord{}
+	err = json.Unmarshal(body, rec)
+	if err != nil {
+		return nil, n, fmt.Errorf("%w: %v", errSpoolCorrupt, err)
+	}
+
+	return rec, n, nil
+}
+
+// publish publishes msg directly while nothing is spooled, it is spooled when the target is unreachable
+func (p *spoolSink) publish(ctx context.Context, msg *nats.Msg) error {
+	p.mu.Lock()
+	spooled := p.count > 0
+	p.mu.Unlock()
+
+	if !spooled {
+		err := p.sink.publish(ctx, msg)
+		if err == nil || !targetUnreachable(err) {
+			return err
+		}
+
+		p.log.Warnf("Spooling messages while the target is unreachable: %v", err)
+	}
+
+	return p.append(msg)
+}
+
+// targetUnreachable determines if err is due to the target not being reachable rather than it rejecting a message
+func targetUnreachable(err error) bool {
+	for _, e := range []error{nats.ErrTimeout, nats.ErrNoResponders, nats.ErrNoServers, nats.ErrConnectionClosed, nats.ErrConnectionReconnecting, nats.ErrConnectionDraining, nats.ErrDisconnected, context.DeadlineExceeded} {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// append stores msg at the end of the spool and waits for it to be written to disk
+func (p *spoolSink) append(msg *nats.Msg) error {
+	body, err := json.Marshal(&spoolRecord{Subject: msg.Subject, Header: msg.Header, Data: msg.Data})
+	if err != nil {
+		return err
+	}
+
+	rec := make([]byte, spoolRecordHeader+len(body))
+	binary.BigEndian.PutUint32(rec[0:4], uint32(len(body)))
+	binary.BigEndian.PutUint32(rec[4:8], crc32.ChecksumIEEE(body))
+	copy(rec[spoolRecordHeader:], body)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return fmt.Errorf("spool is closed")
+	}
+
+	if p.size+int64(len(rec)) > p.maxBytes {
+		return fmt.Errorf("%w with %d message(s) of %d bytes", errSpoolFull, p.count, p.size)
+	}
+
+	if p.writer == nil || p.wsize >= spoolSegmentSize {
+		err = p.rotateLocked()
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = p.writer.Write(rec)
+	if err == nil {
+		err = p.writer.Sync()
+	}
+	if err != nil {
+		// a partially written message would corrupt those spooled after it
+		p.writer.Truncate(p.wsize)
+		p.writer.Seek(p.wsize, io.SeekStart)
+		return fmt.Errorf("could not spool message: %v", err)
+	}
+
+	p.wsize += int64(len(rec))
+	p.size += int64(len(rec))
+	p.count++
+	spooledCount.WithLabelValues(p.s.cfg.Stream, p.s.sr.ReplicatorName, p.s.cfg.Name).Inc()
+	p.updateStatsLocked()
+
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// rotateLocked opens the last segment for writing or starts a new one once it is full
+func (p *spoolSink) rotateLocked() error {
+	if p.writer != nil {
+		p.writer.Close()
+		p.writer = nil
+	}
+
+	if len(p.segments) == 0 || p.wsize >= spoolSegmentSize {
+		var id uint64 = 1
+		if len(p.segments) > 0 {
+			id = p.segments[len(p.segments)-1] + 1
+		}
+		p.segments = append(p.segments, id)
+		p.wsize = 0
+	}
+
+	f, err := os.OpenFile(p.segmentPath(p.segments[len(p.segments)-1]), os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Seek(p.wsize, io.SeekStart)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	p.writer = f
+
+	return nil
+}
+
+// next reads the oldest spooled message, nil when the spool is empty. Corrupt messages are skipped and unreadable
+// segments quarantined, other errors are returned so reading can be retried
+func (p *spoolSink) next() (*nats.Msg, int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var rec *spoolRecord
+	var n int64
+
+	for rec == nil {
+		if p.count == 0 || p.closed {
+			return nil, 0, nil
+		}
+
+		if p.reader == nil {
+			f, err := os.Open(p.segmentPath(p.segments[0]))
+			if err != nil {
+				return nil, 0, err
+			}
+			p.reader = f
+		}
+
+		nfo, err := p.reader.Stat()
+		if err != nil {
+			return nil, 0, err
+		}
+
+		rec, n, err = readSpoolRecord(p.reader, p.offset, nfo.Size())
+		switch {
+		case errors.Is(err, errSpoolCorrupt):
+			p.log.Errorf("Skipping corrupt spooled message at offset %d of spool segment %d: %v", p.offset, p.segments[0], err)
+			spoolCorruptCount.WithLabelValues(p.s.cfg.Stream, p.s.sr.ReplicatorName, p.s.cfg.Name).Inc()
+			p.offset += n
+			p.size -= n
+			p.count--
+			p.updateStatsLocked()
+			if p.count == 0 {
+				p.resetLocked()
+				return nil, 0, nil
+			}
+			p.nextSegmentLocked()
+			p.savePositionLocked()
+
+		case errors.Is(err, errSpoolTruncated):
+			p.quarantineReaderLocked(nfo.Size())
+
+		case err != nil:
+			return nil, 0, err
+		}
+	}
+
+	msg := nats.NewMsg(rec.Subject)
+	msg.Data = rec.Data
+	if rec.Header != nil {
+		msg.Header = rec.Header
+	}
+
+	return msg, n, nil
+}
+
+// advance removes the oldest spooled message of size n once it was published, segments are removed once drained
+func (p *spoolSink) advance(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.offset += n
+	p.size -= n
+	p.count--
+	spoolDrainedCount.WithLabelValues(p.s.cfg.Stream, p.s.sr.ReplicatorName, p.s.cfg.Name).Inc()
+	defer p.updateStatsLocked()
+
+	if p.count == 0 {
+		// the spool is empty, start over with a new segment when spooling again
+		p.resetLocked()
+		return
+	}
+
+	p.nextSegmentLocked()
+	p.savePositionLocked()
+}
+
+// nextSegmentLocked removes the segment being read once it is drained and continues reading the next one
+func (p *spoolSink) nextSegmentLocked() {
+	if p.reader == nil || len(p.segments) < 2 {
+		return
+	}
+
+	nfo, err := p.reader.Stat()
+	if err == nil && p.offset >= nfo.Size() {
+		p.reader.Close()
+		p.reader = nil
+		os.Remove(p.segmentPath(p.segments[0]))
+		p.segments = p.segments[1:]
+		p.offset = 0
+	}
+}
+
+// quarantineReaderLocked moves the segment being read aside once the rest of it of size bytes can not be read and
+// continues with the next segment, messages counted in it are dropped when it is the last one
+func (p *spoolSink) quarantineReaderLocked(size int64) {
+	id := p.segments[0]
+	p.reader.Close()
+	p.reader = nil
+
+	if p.offset < size {
+		p.log.Errorf("Quarantining %d bytes of unreadable messages at offset %d of spool segment %d", size-p.offset, p.offset, id)
+		err := os.Rename(p.segmentPath(id), filepath.Join(p.dir, fmt.Sprintf("%020d-%d%s", id, p.offset, spoolCorruptExt)))
+		if err != nil {
+			p.log.Errorf("Could not quarantine spool segment %d: %v", id, err)
+		}
+		p.size -= size - p.offset
+	}
+
+	if len(p.segments) == 1 {
+		p.log.Errorf("Dropping %d spooled message(s) that could not be read", p.count)
+		spoolCorruptCount.WithLabelValues(p.s.cfg.Stream, p.s.sr.ReplicatorName, p.s.cfg.Name).Add(float64(p.count))
+		p.resetLocked()
+		p.count = 0
+		p.updateStatsLocked()
+		return
+	}
+
+	os.Remove(p.segmentPath(id))
+	p.segments = p.segments[1:]
+	p.offset = 0
+	p.savePositionLocked()
+}
+
+func (p *spoolSink) savePositionLocked() {
+	if len(p.segments) == 0 {
+		return
+	}
+
+	err := os.WriteFile(filepath.Join(p.dir, spoolPositionFile), []byte(fmt.Sprintf("%d %d\n", p.segments[0], p.offset)), 0600)
+	if err != nil {
+		p.log.Errorf("Could not record the spool position: %v", err)
+	}
+}
+
+func (p *spoolSink) resetLocked() {
+	if p.reader != nil {
+		p.reader.Close()
+		p.reader = nil
+	}
+	if p.writer != nil {
+		p.writer.Close()
+		p.writer = nil
+	}
+
+	for _, id := range p.segments {
+		os.Remove(p.segmentPath(id))
+	}
+	os.Remove(filepath.Join(p.dir, spoolPositionFile))
+
+	p.segments = nil
+	p.offset = 0
+	p.wsize = 0
+	p.size = 0
+}
+
+// drain publishes spooled messages in order whenever messages are spooled, failures are retried with backoff
+func (p *spoolSink) drain(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for {
+		select {
+		case <-p.notify:
+		case <-ctx.Done():
+			return
+		}
+
+		for try, reads := 1, 1; ; {
+			msg, n, err := p.next()
+			if err != nil {
+				// the spool could not be read rather than being corrupt, like with disk errors
+				p.log.Errorf("Reading spooled messages failed on try %d: %v", reads, err)
+				if p.s.retries.TrySleep(ctx, reads) != nil {
+					return
+				}
+				reads++
+				continue
+			}
+			reads = 1
+
+			if msg == nil {
+				break
+			}
+
+			err = p.sink.publish(ctx, msg)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+
+				p.log.Errorf("Publishing spooled message to %s failed on try %d: %v", msg.Subject, try, err)
+				if p.s.retries.TrySleep(ctx, try) != nil {
+					return
+				}
+				try++
+				continue
+			}
+
+			if try > 1 {
+				p.log.Infof("Target reachable again, draining spooled messages")
+			}
+			try = 1
+
+			p.advance(n)
+		}
+	}
+}
+
+func (p *spoolSink) updateStats() {
+	p.mu.Lock()
+	p.updateStatsLocked()
+	p.mu.Unlock()
+}
+
+func (p *spoolSink) updateStatsLocked() {
+	spoolMessages.WithLabelValues(p.s.cfg.Stream, p.s.sr.ReplicatorName, p.s.cfg.Name).Set(float64(p.count))
+	spoolBytes.WithLabelValues(p.s.cfg.Stream, p.s.sr.ReplicatorName, p.s.cfg.Name).Set(float64(p.size))
+}
+
+// close stops spooling and closes the sink, spooled messages are drained when the stream starts again
+func (p *spoolSink) close() error {
+	p.mu.Lock()
+	p.closed = true
+	if p.reader != nil {
+		p.reader.Close()
+		p.reader = nil
+	}
+	if p.writer != nil {
+		p.writer.Close()
+		p.writer = nil
+	}
+	p.mu.Unlock()
+
+	return p.sink.close()
+}
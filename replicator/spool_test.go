@@ -0,0 +1,301 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/choria-io/stream-replicator/replicator/replicatortest"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Spool", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+		dir    string
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+		dir = GinkgoT().TempDir()
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	newStream := func(url string, maxBytes int64, pub Publisher) *Stream {
+		scfg := &config.Stream{Name: "SPOOL", Stream: "ORDERS", SourceURL: url, TargetURL: url, Spool: &config.Spool{Directory: dir, MaxBytes: maxBytes}}
+		sr := &config.Config{
+			ReplicatorName: "GINKGO",
+			Streams:        []*config.Stream{scfg},
+			Backoff:        &config.Backoff{Retries: &config.BackoffPolicy{InitialString: "10ms", MaxString: "50ms"}},
+		}
+		Expect(sr.Validate()).To(Succeed())
+
+		stream, err := NewStream(scfg, sr, log, WithPublisher(pub))
+		Expect(err).ToNot(HaveOccurred())
+
+		return stream
+	}
+
+	spoolMsg := func(subject string) *nats.Msg {
+		msg := nats.NewMsg(subject)
+		msg.Data = []byte(subject)
+		msg.Header.Set("Test", subject)
+		return msg
+	}
+
+	It("Should spool messages while the target is unreachable and drain them in order", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("ORDERS", jsm.Subjects("ORDERS.>"))
+			Expect(err).ToNot(HaveOccurred())
+
+			pub := replicatortest.NewPublisher()
+			pub.SetError(nats.ErrNoResponders)
+			before := getPromCountValue(spooledCount, "ORDERS", "GINKGO", "SPOOL")
+
+			stream := newStream(nc.ConnectedUrl(), 0, pub)
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+			}()
+
+			for i := 0; i < 5; i++ {
+				_, err = nc.Request(fmt.Sprintf("ORDERS.%d", i), []byte("order"), time.Second)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			// the messages are acknowledged once spooled
+			Eventually(func() float64 { return getPromCountValue(spooledCount, "ORDERS", "GINKGO", "SPOOL") }, "5s").Should(Equal(before + 5))
+			Eventually(func() (uint64, error) {
+				nfo, err := mgr.LoadConsumer("ORDERS", stream.source.consumer.Name())
+				if err != nil {
+					return 0, err
+				}
+				state, err := nfo.State()
+				return state.AckFloor.Stream, err
+			}, "5s").Should(Equal(uint64(5)))
+			Expect(pub.Count()).To(Equal(0))
+			Expect(getPromGaugeValue(spoolMessages, "ORDERS", "GINKGO", "SPOOL")).To(Equal(5.0))
+
+			pub.SetError(nil)
+			Expect(pub.WaitForMessages(ctx, 5)).To(Succeed())
+
+			_, err = nc.Request("ORDERS.5", []byte("order"), time.Second)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pub.WaitForMessages(ctx, 6)).To(Succeed())
+
+			for i, msg := range pub.Messages() {
+				Expect(msg.Subject).To(Equal(fmt.Sprintf("ORDERS.%d", i)))
+				Expect(msg.Header.Get(srcHeader)).To(HavePrefix(fmt.Sprintf("ORDERS %d GINKGO", i+1)))
+			}
+
+			Eventually(func() float64 { return getPromGaugeValue(spoolMessages, "ORDERS", "GINKGO", "SPOOL") }).Should(Equal(0.0))
+			Expect(filepath.Glob(filepath.Join(dir, "*"+spoolSegmentExt))).To(BeEmpty())
+		})
+	})
+
+	It("Should drain messages spooled by a previous run", func() {
+		pub := replicatortest.NewPublisher()
+		stream := newStream("nats://localhost:4222", 0, pub)
+
+		spool, err := newSpoolSink(stream, &publisherSink{p: pub}, stream.cfg.Spool)
+		Expect(err).ToNot(HaveOccurred())
+		for i := 0; i < 3; i++ {
+			Expect(spool.append(spoolMsg(fmt.Sprintf("ORDERS.%d", i)))).To(Succeed())
+		}
+
+		msg, n, err := spool.next()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(msg.Subject).To(Equal("ORDERS.0"))
+		spool.advance(n)
+
+		// a message partially written when stopping is discarded
+		spool.writer.Write([]byte{0, 0, 1})
+		spool.mu.Lock()
+		spool.closed = true
+		spool.mu.Unlock()
+		spool.writer.Close()
+
+		spool, err = newSpoolSink(stream, &publisherSink{p: pub}, stream.cfg.Spool)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(spool.count).To(Equal(2))
+
+		stream.sink = spool
+		wg.Add(1)
+		go spool.drain(ctx, &wg)
+
+		Expect(pub.WaitForMessages(ctx, 2)).To(Succeed())
+		Expect(pub.Messages()[0].Subject).To(Equal("ORDERS.1"))
+		Expect(pub.Messages()[0].Header.Get("Test")).To(Equal("ORDERS.1"))
+		Expect(string(pub.Messages()[1].Data)).To(Equal("ORDERS.2"))
+
+		Expect(spool.publish(ctx, spoolMsg("ORDERS.3"))).To(Succeed())
+		Expect(pub.Count()).To(Equal(3))
+	})
+
+	It("Should skip corrupt messages and quarantine unreadable segments", func() {
+		pub := replicatortest.NewPublisher()
+		stream := newStream("nats://localhost:4222", 0, pub)
+		before := getPromCountValue(spoolCorruptCount, "ORDERS", "GINKGO", "SPOOL")
+
+		spool, err := newSpoolSink(stream, &publisherSink{p: pub}, stream.cfg.Spool)
+		Expect(err).ToNot(HaveOccurred())
+
+		// offsets records the segment and offset every message was spooled at
+		var offsets [][2]int64
+		for i := 0; i < 6; i++ {
+			// messages 0 to 2 go into the first segment, 3 and 4 into the second and 5 into the third
+			if i == 3 || i == 5 {
+				spool.mu.Lock()
+				spool.wsize = spoolSegmentSize
+				spool.mu.Unlock()
+			}
+			Expect(spool.append(spoolMsg(fmt.Sprintf("ORDERS.%d", i)))).To(Succeed())
+			offsets = append(offsets, [2]int64{int64(spool.segments[len(spool.segments)-1]), spool.wsize})
+		}
+		Expect(spool.segments).To(HaveLen(3))
+		spool.mu.Lock()
+		spool.closed = true
+		spool.mu.Unlock()
+		spool.writer.Close()
+
+		corrupt := func(msg int, at int64, data []byte) {
+			start := int64(0)
+			if msg > 0 && offsets[msg-1][0] == offsets[msg][0] {
+				start = offsets[msg-1][1]
+			}
+			f, err := os.OpenFile(spool.segmentPath(uint64(offsets[msg][0])), os.O_WRONLY, 0600)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = f.WriteAt(data, start+at)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(f.Close()).To(Succeed())
+		}
+
+		// the body of message 1 fails its checksum while its length is intact
+		corrupt(1, spoolRecordHeader+2, []byte("XX"))
+		// the length of message 4 extends past the end of the second segment
+		corrupt(4, 0, []byte{0xff, 0xff, 0xff, 0xff})
+
+		spool, err = newSpoolSink(stream, &publisherSink{p: pub}, stream.cfg.Spool)
+		Expect(err).ToNot(HaveOccurred())
+		// the corrupt message is counted until it is skipped, the unreadable rest of the second segment is not
+		Expect(spool.count).To(Equal(5))
+		Expect(filepath.Glob(filepath.Join(dir, "*"+spoolCorruptExt))).To(HaveLen(1))
+
+		msg, n, err := spool.next()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(msg.Subject).To(Equal("ORDERS.0"))
+		spool.advance(n)
+		Expect(spool.count).To(Equal(4))
+
+		msg, _, err = spool.next()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(msg.Subject).To(Equal("ORDERS.2"))
+		Expect(spool.count).To(Equal(3))
+		Expect(getPromGaugeValue(spoolMessages, "ORDERS", "GINKGO", "SPOOL")).To(Equal(3.0))
+		Expect(getPromCountValue(spoolCorruptCount, "ORDERS", "GINKGO", "SPOOL")).To(Equal(before + 1))
+
+		stream.sink = spool
+		wg.Add(1)
+		go spool.drain(ctx, &wg)
+
+		Expect(pub.WaitForMessages(ctx, 3)).To(Succeed())
+		var subjects []string
+		for _, msg := range pub.Messages() {
+			subjects = append(subjects, msg.Subject)
+		}
+		Expect(subjects).To(Equal([]string{"ORDERS.2", "ORDERS.3", "ORDERS.5"}))
+		Expect(getPromCountValue(spoolCorruptCount, "ORDERS", "GINKGO", "SPOOL")).To(Equal(before + 1))
+
+		Eventually(func() float64 { return getPromGaugeValue(spoolMessages, "ORDERS", "GINKGO", "SPOOL") }).Should(Equal(0.0))
+		Eventually(func() float64 { return getPromGaugeValue(spoolBytes, "ORDERS", "GINKGO", "SPOOL") }).Should(Equal(0.0))
+		Expect(filepath.Glob(filepath.Join(dir, "*"+spoolSegmentExt))).To(BeEmpty())
+	})
+
+	It("Should retry reading the spool after failures", func() {
+		pub := replicatortest.NewPublisher()
+		stream := newStream("nats://localhost:4222", 0, pub)
+
+		spool, err := newSpoolSink(stream, &publisherSink{p: pub}, stream.cfg.Spool)
+		Expect(err).ToNot(HaveOccurred())
+		for i := 0; i < 2; i++ {
+			Expect(spool.append(spoolMsg(fmt.Sprintf("ORDERS.%d", i)))).To(Succeed())
+		}
+		spool.mu.Lock()
+		spool.closed = true
+		spool.mu.Unlock()
+		spool.writer.Close()
+
+		spool, err = newSpoolSink(stream, &publisherSink{p: pub}, stream.cfg.Spool)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(spool.count).To(Equal(2))
+
+		// the segment can not be opened for a while
+		segment := spool.segmentPath(spool.segments[0])
+		Expect(os.Rename(segment, segment+".moved")).To(Succeed())
+
+		stream.sink = spool
+		wg.Add(1)
+		go spool.drain(ctx, &wg)
+
+		Consistently(pub.Count, "200ms").Should(Equal(0))
+		spool.mu.Lock()
+		Expect(spool.count).To(Equal(2))
+		spool.mu.Unlock()
+
+		Expect(os.Rename(segment+".moved", segment)).To(Succeed())
+		Expect(pub.WaitForMessages(ctx, 2)).To(Succeed())
+		Expect(pub.Messages()[1].Subject).To(Equal("ORDERS.1"))
+	})
+
+	It("Should refuse messages once full", func() {
+		pub := replicatortest.NewPublisher()
+		stream := newStream("nats://localhost:4222", 200, pub)
+
+		spool, err := newSpoolSink(stream, &publisherSink{p: pub}, stream.cfg.Spool)
+		Expect(err).ToNot(HaveOccurred())
+
+		// messages rejected by the target are not spooled
+		pub.SetError(fmt.Errorf("maximum messages exceeded"))
+		Expect(spool.publish(ctx, spoolMsg("ORDERS.0"))).To(MatchError("maximum messages exceeded"))
+		Expect(spool.count).To(Equal(0))
+
+		pub.SetError(nats.ErrTimeout)
+		Expect(spool.publish(ctx, spoolMsg("ORDERS.0"))).To(Succeed())
+		Expect(spool.publish(ctx, spoolMsg("ORDERS.1"))).To(Succeed())
+		Expect(spool.publish(ctx, spoolMsg("ORDERS.2"))).To(MatchError(errSpoolFull))
+		Expect(spool.count).To(Equal(2))
+		Expect(pub.Attempts()).To(Equal(2))
+	})
+
+	It("Should determine if the target is unreachable", func() {
+		Expect(targetUnreachable(nats.ErrTimeout)).To(BeTrue())
+		Expect(targetUnreachable(fmt.Errorf("publish failed: %w", nats.ErrNoResponders))).To(BeTrue())
+		Expect(targetUnreachable(context.DeadlineExceeded)).To(BeTrue())
+		Expect(targetUnreachable(fmt.Errorf("stream not found"))).To(BeFalse())
+	})
+})
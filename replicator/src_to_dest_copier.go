@@ -7,12 +7,12 @@ package replicator
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/choria-io/stream-replicator/backoff"
 	"github.com/choria-io/stream-replicator/config"
 	"github.com/nats-io/jsm.go"
 	"github.com/nats-io/jsm.go/api"
@@ -31,31 +31,80 @@ type sourceInitiatedCopier struct {
 	dest    *Target
 	copied  int64
 	skipped int64
+	failed  int64
 	cname   string
 	cfg     *config.Stream
 	log     *logrus.Entry
+
+	// batch is the largest batch requested, sizer picks the size of every request, maxBytes and expires configure them further
+	batch    int
+	sizer    *adaptiveBatch
+	maxBytes int
+	expires  time.Duration
+
+	// catchingUp is set while more than catchUp pending messages are being copied
+	catchUp    *config.CatchUp
+	catchingUp bool
+
+	// pipeline publishes messages when the target has several connections
+	pipeline *publishPipeline
+
+	// lastReceived is when a message was last received, used to detect consumers that stopped delivering
+	lastReceived time.Time
+	// consumerSeen is set once the consumer was found or created, losing it later is alerted
+	consumerSeen bool
+	// reported is set once the state found on start was reported
+	reported bool
 }
 
 func newSourceInitiatedCopier(s *Stream, log *logrus.Entry) *sourceInitiatedCopier {
-	return &sourceInitiatedCopier{
-		mu:     sync.Mutex{},
-		health: time.NewTicker(s.hcInterval),
-		msgs:   make(chan *nats.Msg, 10),
-		s:      s,
-		sr:     s.sr,
-		source: s.source,
-		dest:   s.dest,
-		cname:  s.cname,
-		cfg:    s.cfg,
+	c := &sourceInitiatedCopier{
+		mu:      sync.Mutex{},
+		health:  time.NewTicker(s.hcInterval),
+		s:       s,
+		sr:      s.sr,
+		source:  s.source,
+		dest:    s.dest,
+		cname:   s.cname,
+		cfg:     s.cfg,
+		batch:   1,
+		sizer:   newAdaptiveBatch(1, 1),
+		expires: pollFrequency,
 		log: log.WithFields(logrus.Fields{
 			"copier":   "source_initiated",
 			"consumer": s.cname,
 		}),
 	}
+
+	if s.cfg.Fetch != nil {
+		c.batch = s.cfg.Fetch.Batch
+		if s.cfg.Fetch.MaxBatch > c.batch {
+			c.batch = s.cfg.Fetch.MaxBatch
+		}
+		c.sizer = newAdaptiveBatch(s.cfg.Fetch.Batch, c.batch)
+		c.maxBytes = s.cfg.Fetch.MaxBytes
+		c.expires = s.cfg.Fetch.Expires
+	}
+
+	if s.cfg.CatchUp != nil {
+		c.catchUp = s.cfg.CatchUp
+		if c.catchUp.Batch > c.batch {
+			c.batch = c.catchUp.Batch
+		}
+	}
+
+	// room for a full batch and the status message ending its request
+	c.msgs = make(chan *nats.Msg, c.batch+10)
+
+	if s.dest != nil && len(s.dest.publishers) > 0 {
+		c.pipeline = newPublishPipeline(c, append([]*nats.Conn{s.dest.nc}, s.dest.publishers...))
+	}
+
+	return c
 }
 
 func (c *sourceInitiatedCopier) copyMessages(ctx context.Context) error {
-	c.log.Infof("Starting Source-initiated data copier for %s", c.cfg.Stream)
+	c.log.Infof("Starting Source-initiated data copier for %s requesting up to %d message(s) at a time", c.cfg.Stream, c.batch)
 
 	var err error
 	var nextSubj string
@@ -74,41 +123,109 @@ func (c *sourceInitiatedCopier) copyMessages(ctx context.Context) error {
 		return err
 	}
 
-	req := api.JSApiConsumerGetNextRequest{
-		Expires: pollFrequency,
-		Batch:   1,
-	}
-
-	pollRequest, err := json.Marshal(&req)
-	if err != nil {
-		return err
-	}
 	pollMsg := nats.NewMsg(nextSubj)
 	pollMsg.Reply = ib
-	pollMsg.Data = pollRequest
+	nextMsg := nats.NewMsg(nextSubj)
+	nextMsg.Reply = ib
+
+	// size is the batch size of the requests in pollMsg and nextMsg
+	size := 0
+	pending := uint64(0)
 
+	// resize prepares the requests for the next batch sized for how many messages are pending
+	resize := func() error {
+		next := c.sizer.adjust(pending)
+		if c.catchingUp {
+			next = c.catchUp.Batch
+		}
+		if c.s.budget.exceeded() {
+			next = 1
+		}
+		if next == size {
+			return nil
+		}
+
+		req := api.JSApiConsumerGetNextRequest{
+			Expires:  c.expires,
+			Batch:    next,
+			MaxBytes: c.maxBytes,
+		}
+
+		pollRequest, err := json.Marshal(&req)
+		if err != nil {
+			return err
+		}
+
+		if size > 0 {
+			c.log.Debugf("Requesting %d message(s) at a time with %d message(s) pending", next, pending)
+		}
+
+		pollMsg.Data = pollRequest
+		nextMsg.Data = []byte(fmt.Sprintf("%s %s", string(api.AckNext), string(pollRequest)))
+		size = next
+		batchSize.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Set(float64(size))
+
+		return nil
+	}
+
+	err = resize()
 	if err != nil {
 		return err
 	}
-	nextMsg := nats.NewMsg(nextSubj)
-	nextMsg.Reply = ib
-	nextMsg.Data = []byte(fmt.Sprintf("%s %s", string(api.AckNext), string(pollRequest)))
 
 	polled := time.Time{}
-	polls := time.NewTicker(pollFrequency)
+	polls := time.NewTicker(c.expires)
 	health := time.NewTicker(time.Millisecond)
+	liveness := time.NewTicker(livenessInterval)
+
+	// received is how many messages arrived for the current request of requestedSize messages
+	received := 0
+	requestedSize := size
+
+	// with batches the consumer delivers messages past one that failed, those are NaKed until the failed one is
+	// redelivered so order is kept, giving up after failedUntil should it never be redelivered
+	var failedSeq uint64
+	var failedDelay time.Duration
+	var failedUntil time.Time
+
+	if c.pipeline != nil {
+		c.pipeline.start(ctx)
+	}
+
+	c.lastReceived = time.Now()
+
+	requested := func() {
+		polled = time.Now()
+		received = 0
+		requestedSize = size
+		polls.Reset(c.expires)
+	}
 
 	for {
 		select {
+		case <-liveness.C:
+			c.s.markActive()
+
 		case <-polls.C:
 			if c.s.isPaused() {
 				c.log.Debugf("Not polling while paused")
-				polls.Reset(pollFrequency)
+				polls.Reset(c.expires)
+				continue
+			}
+
+			if c.throttled() {
+				polls.Reset(budgetInterval)
 				continue
 			}
 
-			if time.Since(polled) < pollFrequency {
-				polls.Reset(pollFrequency)
+			// the target quota is nearly used, request messages less often
+			if since := time.Since(polled); c.s.quotaThrottled() && since < quotaThrottleDelay {
+				polls.Reset(quotaThrottleDelay - since)
+				continue
+			}
+
+			if time.Since(polled) < c.expires {
+				polls.Reset(c.expires)
 				continue
 			}
 
@@ -118,18 +235,25 @@ func (c *sourceInitiatedCopier) copyMessages(ctx context.Context) error {
 				c.log.Debugf("Performing poll for messages last poll: %s", time.Since(polled))
 			}
 
+			err = resize()
+			if err != nil {
+				c.log.Errorf("Could not prepare the next request: %v", err)
+				continue
+			}
+
 			err = nc.PublishMsg(pollMsg)
 			if err != nil {
 				c.log.Errorf("Could not request next messages: %v", err)
 				continue
 			}
 
-			polled = time.Now()
-			polls.Reset(pollFrequency)
+			requested()
 
 		case <-health.C:
 			if c.s.isPaused() {
 				c.log.Debugf("Not health checking while paused")
+				// paused consumers deliver nothing, they are not inactive
+				c.lastReceived = time.Now()
 				health.Reset(c.s.hcInterval)
 				continue
 			}
@@ -153,7 +277,41 @@ func (c *sourceInitiatedCopier) copyMessages(ctx context.Context) error {
 		case msg := <-c.msgs:
 			if len(msg.Data) == 0 && msg.Header != nil {
 				status := msg.Header.Get("Status")
+				// requests fail once the consumer is deleted, checking health straight away recreates it
+				if status == "503" || (status == "409" && msg.Header.Get("Description") == "Consumer Deleted") {
+					c.log.Warnf("Consumer %s is not available: %s %s", c.cname, status, msg.Header.Get("Description"))
+					health.Reset(time.Millisecond)
+					continue
+				}
+
 				if status == "404" || status == "408" || status == "409" {
+					// the request ended before the batch was complete because the next message exceeds max_bytes
+					if fetchMaxBytesExceeded(msg) {
+						if received == 0 {
+							c.log.Warnf("Next message is larger than the fetch max_bytes of %d and can not be received", c.maxBytes)
+						} else {
+							polled = time.Time{}
+							polls.Reset(time.Millisecond)
+						}
+					}
+
+					continue
+				}
+			}
+
+			received++
+			c.lastReceived = time.Now()
+			sz := c.s.budget.acquire(msg)
+
+			if failedSeq > 0 && time.Now().Before(failedUntil) {
+				meta, err := jsm.ParseJSMsgMetadata(msg)
+				if err == nil && meta.StreamSequence() > failedSeq {
+					err = c.nakMsg(msg, failedDelay)
+					if err != nil {
+						c.log.Errorf("Could not NaK message %v", err)
+					}
+					releaseHeader(msg)
+					c.s.budget.release(sz)
 					continue
 				}
 			}
@@ -161,12 +319,16 @@ func (c *sourceInitiatedCopier) copyMessages(ctx context.Context) error {
 			// we got a message - we know it's healthy, lets postpone health checks
 			health.Reset(c.s.hcInterval)
 
-			meta, err := c.handler(msg)
+			// queued messages are accounted for by the pipeline until published
+			meta, queued, err := c.handler(ctx, msg)
+			c.s.budget.release(sz)
 			if err != nil {
-				next, nerr := c.nakMsg(msg, meta)
+				next := c.nakDelay(meta)
+				nerr := c.nakMsg(msg, next)
 				if nerr != nil {
 					c.log.Errorf("Could not NaK message %v", err)
 				}
+				releaseHeader(msg)
 
 				if meta != nil {
 					c.log.Errorf("Handling msg %d failed on try %d, backing off for %v: %v", meta.StreamSequence(), meta.Delivered(), next, err)
@@ -174,21 +336,63 @@ func (c *sourceInitiatedCopier) copyMessages(ctx context.Context) error {
 					c.log.Errorf("Handling msg failed, backing off for %v: %v", next, err)
 				}
 
+				if c.batch > 1 && meta != nil {
+					failedSeq = meta.StreamSequence()
+					failedDelay = next
+					failedUntil = time.Now().Add(next + c.expires)
+					// the current request might be complete, a new one receives the message once redelivered
+					polled = time.Time{}
+				}
+
 				if !c.s.isPaused() {
 					polls.Reset(next)
 				}
 
 				handlerErrorCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+				c.s.reportError(err)
+				atomic.AddInt64(&c.failed, 1)
 
 				continue
 			}
 
-			if c.s.isPaused() {
+			if meta != nil {
+				pending = meta.Pending()
+				c.updateMode(pending)
+			}
+
+			// the last message of a batch requests the next batch while acknowledging it
+			last := received >= requestedSize
+			if last && !c.s.isPaused() {
+				err = resize()
+				if err != nil {
+					c.log.Errorf("Could not prepare the next request: %v", err)
+				}
+			}
+
+			// while over the memory budget the next request waits for in-flight messages to be published
+			hold := last && c.throttled()
+
+			switch {
+			case queued:
+				// the pipeline acknowledges and releases the message once published
+				if last && !hold && !c.s.isPaused() {
+					err = nc.PublishMsg(pollMsg)
+					if err != nil {
+						c.log.Errorf("Could not request next messages: %v", err)
+						continue
+					}
+				}
+			case c.s.isPaused():
 				err = msg.AckSync()
-			} else {
+			case last && !hold:
 				res := nextMsg
 				res.Subject = msg.Reply
 				err = msg.RespondMsg(res)
+			default:
+				err = msg.Ack()
+			}
+			if !queued {
+				releaseHeader(msg)
 			}
 			if err != nil {
 				ackFailedCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
@@ -197,16 +401,34 @@ func (c *sourceInitiatedCopier) copyMessages(ctx context.Context) error {
 			}
 
 			if meta != nil {
-				c.source.mu.Lock()
-				c.source.resumeSeq = meta.StreamSequence()
-				c.source.mu.Unlock()
+				if !queued {
+					c.source.mu.Lock()
+					c.source.resumeSeq = meta.StreamSequence()
+					c.source.mu.Unlock()
+				}
+
+				if meta.StreamSequence() >= failedSeq {
+					failedSeq = 0
+				}
 			}
 
-			polls.Reset(pollFrequency)
+			if last {
+				requested()
+
+				if hold {
+					polled = time.Time{}
+					polls.Reset(budgetInterval)
+				}
+			}
 
 		case <-ctx.Done():
 			health.Stop()
 			polls.Stop()
+			liveness.Stop()
+
+			if c.pipeline != nil {
+				c.pipeline.wait()
+			}
 
 			c.log.Warnf("Copier shutting down after context interrupt")
 			return nil
@@ -218,11 +440,25 @@ func (c *sourceInitiatedCopier) healthCheckSource() (fixed bool, err error) {
 	c.source.mu.Lock()
 	defer c.source.mu.Unlock()
 
+	stream := c.source.stream
+	if stream == nil {
+		return false, fmt.Errorf("stream %s does not exist, cannot recover consumer", c.cfg.Stream)
+	}
+
+	recreated, err := c.s.sourceRecreated()
+	if err != nil {
+		c.log.Warnf("Could not check if source stream %s was recreated: %v", c.cfg.Stream, err)
+	}
+	if recreated {
+		// the new stream starts over, nothing of it was copied yet
+		c.source.resumeSeq = 0
+	}
+
 	opts := []jsm.ConsumerOption{
 		jsm.DurableName(c.cname),
-		jsm.ConsumerDescription(fmt.Sprintf("Choria Stream Replicator %s", c.cfg.Name)),
+		jsm.ConsumerDescription(fmt.Sprintf("%s %s", ConsumerDescriptionPrefix, c.cfg.Name)),
 		jsm.AcknowledgeExplicit(),
-		jsm.MaxAckPending(1),
+		jsm.MaxAckPending(uint(c.batch)),
 		jsm.AckWait(30 * time.Second),
 	}
 
@@ -238,9 +474,14 @@ func (c *sourceInitiatedCopier) healthCheckSource() (fixed bool, err error) {
 		opts = append(opts, jsm.FilterStreamBySubject(c.cfg.FilterSubject))
 	}
 
-	if c.source.resumeSeq > 0 {
-		opts = append(opts, jsm.StartAtSequence(c.source.resumeSeq))
-	} else {
+	switch {
+	case recreated:
+		// all messages of the new stream are copied regardless of the other start options
+		opts = append(opts, jsm.DeliverAllAvailable())
+	case c.source.resumeSeq > 0:
+		// continue after the last message copied
+		opts = append(opts, jsm.StartAtSequence(c.source.resumeSeq+1))
+	default:
 		switch {
 		case c.cfg.StartAtEnd:
 			opts = append(opts, jsm.StartWithNextReceived())
@@ -255,60 +496,194 @@ func (c *sourceInitiatedCopier) healthCheckSource() (fixed bool, err error) {
 		}
 	}
 
-	stream := c.source.stream
-	if stream == nil {
-		return false, fmt.Errorf("stream %s does not exist, cannot recover consumer", c.cfg.Stream)
+	// on first start of an ephemeral we always recreate, as we do on a recreated source stream where a consumer created
+	// since could be waiting for a sequence the new stream will not reach for a long time
+	forceRecreate := (c.source.resumeSeq == 0 && c.cfg.Ephemeral) || recreated
+
+	var lost string
+	c.source.consumer, err = stream.LoadConsumer(c.cname)
+
+	var report *startupReport
+	if !c.reported {
+		report = &startupReport{consumer: c.cname, consumerExists: err == nil}
+		if err == nil {
+			nfo, serr := c.source.consumer.LatestState()
+			if serr == nil {
+				report.delivered = nfo.Delivered.Stream
+				report.ackFloor = nfo.AckFloor.Stream
+			}
+		}
 	}
 
-	// on first start of an ephemeral we always recreate
-	forceRecreate := c.source.resumeSeq == 0 && c.cfg.Ephemeral
+	switch {
+	case jsm.IsNatsError(err, 10014):
+		lost = consumerDeleted
+	case err == nil && !forceRecreate:
+		inactive, ierr := c.consumerInactive()
+		if ierr != nil {
+			c.log.Warnf("Could not check if consumer %s is inactive: %v", c.cname, ierr)
+		}
+		if inactive {
+			lost = consumerInactive
+			forceRecreate = true
+		}
+	}
+
+	if forceRecreate || lost == consumerDeleted {
+		// without local state the last message copied to the target tells where to continue
+		if c.source.resumeSeq == 0 && c.cfg.StartFromTarget && !recreated {
+			seq, err := c.s.targetResumeSequence()
+			if err != nil {
+				return false, fmt.Errorf("could not determine the start sequence from the target: %v", err)
+			}
+			if seq > 0 {
+				c.log.Infof("Resuming after the last message copied to the target, starting at source sequence %d", seq)
+				c.source.resumeSeq = seq - 1
+				opts = append(opts, jsm.StartAtSequence(seq))
+			}
+		}
 
-	c.source.consumer, err = stream.LoadConsumer(c.cname)
-	if forceRecreate || jsm.IsNatsError(err, 10014) {
 		// consumer exist, and we are forcing recreate, so we remove it
 		if forceRecreate && err == nil {
-			c.log.Warnf("Recreating ephemeral consumer %s", c.cname)
+			c.log.Warnf("Recreating consumer %s", c.cname)
 			err = c.source.consumer.Delete()
 			if err != nil {
-				c.log.Warnf("Could not remove ephemeral consumer: %v", err)
+				c.log.Warnf("Could not remove consumer: %v", err)
 			}
 		} else {
 			c.log.Errorf("Consumer %s was not found, attempting to recreate", c.cname)
 		}
 		c.source.consumer, err = stream.NewConsumerFromDefault(jsm.DefaultConsumer, opts...)
 		fixed = err == nil
+
+		if fixed && lost != _EMPTY_ && c.consumerSeen {
+			var start uint64
+			if c.source.resumeSeq > 0 {
+				start = c.source.resumeSeq + 1
+			}
+			c.s.consumerRecreated(lost, start)
+		}
+	} else if err == nil && c.source.consumer.MaxAckPending() != c.batch {
+		// consumers created before the fetch batch changed can not deliver full batches
+		c.log.Infof("Updating consumer %s to allow %d pending message(s)", c.cname, c.batch)
+		err = c.source.consumer.UpdateConfiguration(jsm.MaxAckPending(uint(c.batch)))
+	}
+
+	if err == nil {
+		c.consumerSeen = true
+		c.s.reportConsumerState(c.source.consumer)
+	}
+
+	if err == nil && report != nil {
+		c.reported = true
+		report.decision = c.startupDecision(report.consumerExists, fixed, recreated, lost)
+		report.target = c.s.targetSequenceReport()
+		c.s.reportStartup(report)
 	}
 
 	return fixed, err
 }
 
-func (c *sourceInitiatedCopier) handler(msg *nats.Msg) (*jsm.MsgInfo, error) {
+// startupDecision describes what the first health check decided, created is true when the consumer was created and
+// existed when it was found before, must be called with the source lock held
+func (c *sourceInitiatedCopier) startupDecision(existed bool, created bool, recreated bool, lost string) string {
+	if !created {
+		return "resuming the existing consumer"
+	}
+
+	var action string
+	switch {
+	case recreated:
+		return "copying all messages of the recreated source stream"
+	case lost == consumerInactive:
+		action = "recreating the inactive consumer"
+	case existed:
+		action = "recreating the ephemeral consumer"
+	default:
+		action = "creating the consumer"
+	}
+
+	switch {
+	case c.source.resumeSeq > 0:
+		return fmt.Sprintf("%s starting at sequence %d after the last message in the target", action, c.source.resumeSeq+1)
+	case c.cfg.StartAtEnd:
+		return fmt.Sprintf("%s starting with the next message received", action)
+	case c.cfg.StartSequence > 0:
+		return fmt.Sprintf("%s starting at sequence %d", action, c.cfg.StartSequence)
+	case c.cfg.StartDelta > 0:
+		return fmt.Sprintf("%s starting with messages newer than %v", action, c.cfg.StartDelta)
+	case !c.cfg.StartTime.IsZero():
+		return fmt.Sprintf("%s starting with messages newer than %s", action, c.cfg.StartTime.UTC().Format(time.RFC3339))
+	default:
+		return fmt.Sprintf("%s starting at the first message", action)
+	}
+}
+
+// handler copies msg, queued is true when the pipeline publishes and acknowledges it later
+func (c *sourceInitiatedCopier) handler(ctx context.Context, msg *nats.Msg) (meta *jsm.MsgInfo, queued bool, err error) {
 	receivedMessageCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
 	receivedMessageSize.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Add(float64(len(msg.Data)))
 	obs := prometheus.NewTimer(processTime.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name))
 	defer obs.ObserveDuration()
 
-	if msg.Header == nil {
-		msg.Header = nats.Header{}
-	}
+	messageHeader(msg)
 
-	meta, err := jsm.ParseJSMsgMetadata(msg)
+	meta, err = jsm.ParseJSMsgMetadata(msg)
 	if err == nil {
 		streamSequence.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Set(float64(meta.StreamSequence()))
+		pendingMessages.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Set(float64(meta.Pending()))
+		messageAge.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Observe(time.Since(meta.TimeStamp()).Seconds())
+		c.s.countRedelivery(meta)
 
 		if c.cfg.MaxAgeDuration > 0 && time.Since(meta.TimeStamp()) > c.cfg.MaxAgeDuration {
 			ageSkippedCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
-			return meta, nil
+			return meta, false, nil
 		}
 
-		msg.Header.Add(srcHeader, fmt.Sprintf(srcHeaderPattern, c.cfg.Stream, meta.StreamSequence(), c.sr.ReplicatorName, c.cfg.Name, meta.TimeStamp().UnixMilli()))
+		msg.Header.Add(srcHeader, srcHeaderValue(c.cfg.Stream, int64(meta.StreamSequence()), c.sr.ReplicatorName, c.cfg.Name, meta.TimeStamp().UnixMilli()))
 	} else {
 		c.log.Warnf("Could not parse message metadata from %v: %v", msg.Reply, err)
 		metaParsingFailedCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
-		msg.Header.Add(srcHeader, fmt.Sprintf(srcHeaderPattern, c.cfg.Stream, -1, c.sr.ReplicatorName, c.cfg.Name, -1))
+		msg.Header.Add(srcHeader, srcHeaderValue(c.cfg.Stream, -1, c.sr.ReplicatorName, c.cfg.Name, -1))
+	}
+
+	c.s.mapJetStreamHeaders(msg)
+	if c.s.forwardCopy(msg) || !c.s.shardMessage(msg) || !c.s.verifyMessage(msg) {
+		atomic.AddInt64(&c.skipped, 1)
+		return meta, false, nil
+	}
+
+	queued, err = c.s.processWithDeadline(ctx, msg, func(ctx context.Context, msg *nats.Msg) (bool, error) {
+		return c.process(ctx, msg, meta)
+	})
+	if errors.Is(err, errDeadlineExceeded) && c.cfg.ProcessingDeadline.Action == config.DeadlineDeadLetter {
+		derr := c.s.deadLetter(ctx, c.cfg.ProcessingDeadline.DeadLetterSubject, msg, err)
+		if derr == nil {
+			c.log.Warnf("Dead lettered message on %s: %v", msg.Subject, err)
+			atomic.AddInt64(&c.skipped, 1)
+			return meta, false, nil
+		}
+
+		c.log.Errorf("Could not dead letter message on %s: %v", msg.Subject, derr)
+	}
+
+	return meta, queued, err
+}
+
+// process transforms and publishes msg, queued is true when the pipeline publishes it
+func (c *sourceInitiatedCopier) process(ctx context.Context, msg *nats.Msg, meta *jsm.MsgInfo) (queued bool, err error) {
+	if !c.skipTransform() {
+		res, err := c.s.transformMessages(ctx, []*nats.Msg{msg})
+		if err != nil {
+			return false, err
+		}
+		if !res[0] {
+			atomic.AddInt64(&c.skipped, 1)
+			return false, nil
+		}
 	}
 
-	return meta, c.s.limitedProcess(msg, func(msg *nats.Msg, process bool) error {
+	err = c.s.limitedProcess(msg, func(msg *nats.Msg, process bool) error {
 		if meta != nil && meta.StreamSequence()%1000 == 0 {
 			copied := atomic.LoadInt64(&c.copied)
 			skipped := atomic.LoadInt64(&c.skipped)
@@ -322,43 +697,147 @@ func (c *sourceInitiatedCopier) handler(msg *nats.Msg) (*jsm.MsgInfo, error) {
 			return nil
 		}
 
-		msg.Subject = c.s.targetForSubject(msg.Subject)
+		// identities are determined using the source subject
+		send, commit := c.s.deltaMessage(msg)
+		if !send {
+			atomic.AddInt64(&c.skipped, 1)
+			return nil
+		}
+
+		ok, err := c.s.limitPayload(ctx, msg)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			atomic.AddInt64(&c.skipped, 1)
+			return nil
+		}
+
+		msg.Subject = c.s.TargetForSubject(msg.Subject)
+
+		if c.s.templates != nil {
+			ok, err := c.s.templates.ensure(msg.Subject)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				atomic.AddInt64(&c.skipped, 1)
+				templateUnmatchedCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+				c.log.Warnf("Skipping message on %s, its subject does not select a valid target stream name", msg.Subject)
+				return nil
+			}
+		}
 
-		resp, err := c.dest.nc.RequestMsg(msg, 2*time.Second)
+		err = c.s.prepareMessage(msg)
 		if err != nil {
 			return err
 		}
 
-		err = jsm.ParseErrorResponse(resp)
+		err = c.s.scheduleMessages(ctx, msg)
 		if err != nil {
 			return err
 		}
 
-		atomic.AddInt64(&c.copied, 1)
-		if meta != nil {
-			c.log.Debugf("Copied message seq %d, %d message(s) behind", meta.StreamSequence(), meta.Pending())
+		switch {
+		case c.pipeline != nil && (c.catchUp == nil || !c.catchUp.Parallel || c.catchingUp):
+			err = c.pipeline.publish(ctx, msg, meta)
+			queued = err == nil
+			return err
+		case c.pipeline != nil:
+			// messages still being published while catching up have to be stored before later ones
+			err = c.pipeline.flush(ctx)
+			if err == nil {
+				err = c.s.sink.publish(ctx, msg)
+			}
+		default:
+			err = c.s.sink.publish(ctx, msg)
+		}
+		if err != nil {
+			return err
 		}
 
-		copiedMessageCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
-		copiedMessageSize.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Add(float64(len(msg.Data)))
+		commit()
+		c.copiedMessage(msg, meta)
 
 		return nil
 	})
+
+	return queued, err
 }
 
-func (c *sourceInitiatedCopier) nakMsg(msg *nats.Msg, meta *jsm.MsgInfo) (time.Duration, error) {
-	r := nats.NewMsg(msg.Reply)
-	next := backoff.TwentySec.Duration(20)
+// updateMode starts catching up once catchUp pending messages are reached and stops once fewer than half are pending
+func (c *sourceInitiatedCopier) updateMode(pending uint64) {
+	if c.catchUp == nil {
+		return
+	}
+
+	switch {
+	case !c.catchingUp && pending >= c.catchUp.Pending:
+		c.log.Infof("Catching up with %d message(s) pending", pending)
+		c.catchingUp = true
+		catchingUp.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Set(1)
+
+	case c.catchingUp && pending < c.catchUp.Pending/2:
+		c.log.Infof("Caught up with %d message(s) pending", pending)
+		c.catchingUp = false
+		catchingUp.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Set(0)
+	}
+}
+
+// throttled determines if requesting more messages has to wait for in-flight messages as the memory budget is exceeded
+func (c *sourceInitiatedCopier) throttled() bool {
+	if c.s.budget == nil {
+		return false
+	}
+
+	memoryBudgetUsed.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Set(float64(c.s.budget.used()))
+
+	if !c.s.budget.exceeded() || c.s.budget.inFlight() == 0 {
+		return false
+	}
+
+	memoryThrottledCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+	c.log.Debugf("Memory budget of %d bytes exceeded, waiting for %d bytes of in-flight messages", c.s.budget.limit, c.s.budget.inFlight())
+
+	return true
+}
+
+// skipTransform determines if messages are copied without being transformed
+func (c *sourceInitiatedCopier) skipTransform() bool {
+	return c.catchingUp && c.catchUp.SkipTransform
+}
+
+// copiedMessage records that msg was copied
+func (c *sourceInitiatedCopier) copiedMessage(msg *nats.Msg, meta *jsm.MsgInfo) {
+	atomic.AddInt64(&c.copied, 1)
 	if meta != nil {
-		next = backoff.TwentySec.Duration(meta.Delivered())
+		c.log.Debugf("Copied message seq %d, %d message(s) behind", meta.StreamSequence(), meta.Pending())
+	}
+
+	copiedMessageCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+	copiedMessageSize.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Add(float64(len(msg.Data)))
+	c.s.subjects.record(msg.Subject, len(msg.Data))
+	c.s.messageCopied(msg)
+}
+
+// nakDelay is how long to wait before a message that failed to be handled is redelivered
+func (c *sourceInitiatedCopier) nakDelay(meta *jsm.MsgInfo) time.Duration {
+	if meta == nil {
+		return c.s.retries.Duration(20)
 	}
-	r.Data = []byte(fmt.Sprintf(`%s {"delay": %d}`, api.AckNak, next))
+
+	return c.s.retries.Duration(meta.Delivered())
+}
+
+func (c *sourceInitiatedCopier) nakMsg(msg *nats.Msg, delay time.Duration) error {
+	r := nats.NewMsg(msg.Reply)
+	r.Data = []byte(fmt.Sprintf(`%s {"delay": %d}`, api.AckNak, delay))
 
 	err := msg.RespondMsg(r)
 	if err != nil {
 		ackFailedCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
-		return next, err
+		return err
 	}
 
-	return next, nil
+	return nil
 }
@@ -6,13 +6,19 @@ package replicator
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/events"
 	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/choria-io/stream-replicator/replicator/replicatortest"
 	"github.com/nats-io/jsm.go"
 	"github.com/nats-io/nats-server/v2/server"
 	"github.com/nats-io/nats.go"
@@ -26,6 +32,30 @@ func TestReplicator(t *testing.T) {
 	RunSpecs(t, "Replicator")
 }
 
+// startEvents starts a publisher sending events to events.<type> on the server nc is connected to
+func startEvents(ctx context.Context, wg *sync.WaitGroup, nc *nats.Conn, log *logrus.Entry) *events.Publisher {
+	p, err := events.New(&config.Events{Subject: "events.%s", URL: nc.ConnectedUrl()}, "GINKGO", log)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(p.Run(ctx, wg)).To(Succeed())
+
+	return p
+}
+
+// nextEvent is the next event received on msgs other than the startup reconciliation reports
+func nextEvent(msgs chan *nats.Msg) *events.Event {
+	for {
+		var msg *nats.Msg
+		Eventually(msgs, 10*time.Second).Should(Receive(&msg))
+
+		event := &events.Event{}
+		Expect(json.Unmarshal(msg.Data, event)).To(Succeed())
+
+		if event.Event != events.StartupReconciledEvent {
+			return event
+		}
+	}
+}
+
 var _ = Describe("Source to Destination Copier", func() {
 	var (
 		ctx    context.Context
@@ -117,6 +147,23 @@ var _ = Describe("Source to Destination Copier", func() {
 			Expect(err).ToNot(HaveOccurred())
 			Expect(stream.cname).To(Equal("SR_CUSTOM_NAME"))
 		})
+
+		It("Should support consumer name templates", func() {
+			sr, cfg := config("nats://localhost:4222")
+			sr.ReplicatorName = "EAST"
+			cfg.Name = "ORDERS_COPY"
+			cfg.TargetStream = "COPY"
+			cfg.ConsumerName = "SR_{replicator}_{name}_{target}"
+
+			stream, err := NewStream(cfg, sr, log)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stream.cname).To(Equal("SR_EAST_ORDERS_COPY_COPY"))
+
+			cfg.ConsumerName = "SR_{name}_{target_id}"
+			stream, err = NewStream(cfg, sr, log)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stream.cname).To(Equal("SR_ORDERS_COPY_" + cfg.TargetID()))
+		})
 	})
 
 	Describe("copyMessages", func() {
@@ -171,6 +218,30 @@ var _ = Describe("Source to Destination Copier", func() {
 			})
 		})
 
+		It("Should describe the consumers it creates for the admin commands", func() {
+			testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+				_, tcs := prepareStreams(nc, mgr, 10)
+
+				sr, scfg := config(nc.ConnectedUrl())
+				scfg.Name = "DESCRIBED"
+				stream, err := NewStream(scfg, sr, log)
+				Expect(err).ToNot(HaveOccurred())
+
+				go func() {
+					defer GinkgoRecover()
+					wg.Add(1)
+					Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+				}()
+				defer cancel()
+
+				Eventually(streamMesssage(tcs)).Should(BeNumerically(">=", 10))
+
+				consumer, err := mgr.LoadConsumer("TEST", "SR_DESCRIBED")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(consumer.Description()).To(Equal(ConsumerDescriptionPrefix + " DESCRIBED"))
+			})
+		})
+
 		It("Should copy all data when no inspection data is found", func() {
 			testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
 				_, tcs := prepareStreams(nc, mgr, 1000)
@@ -222,6 +293,16 @@ var _ = Describe("Source to Destination Copier", func() {
 				nfo, err = tcs.State()
 				Expect(err).ToNot(HaveOccurred())
 				Expect(nfo.Msgs).To(Equal(uint64(10)))
+
+				// the skipped messages were older than a second when received
+				hist := getPromHistogramValue(messageAge, scfg.Stream, sr.ReplicatorName, scfg.Name)
+				Expect(hist).ToNot(BeNil())
+				Expect(hist.GetSampleCount()).To(BeNumerically(">=", 1010))
+				for _, bucket := range hist.GetBucket() {
+					if bucket.GetUpperBound() == 1 {
+						Expect(hist.GetSampleCount() - bucket.GetCumulativeCount()).To(BeNumerically(">=", 1000))
+					}
+				}
 			})
 		})
 
@@ -281,6 +362,37 @@ var _ = Describe("Source to Destination Copier", func() {
 			})
 		})
 
+		It("Should restart replication when the source stream is recreated", func() {
+			testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+				ts, tcs := prepareStreams(nc, mgr, 100)
+
+				sr, scfg := config(nc.ConnectedUrl())
+
+				stream, err := NewStream(scfg, sr, log)
+				stream.hcInterval = 10 * time.Millisecond
+				Expect(err).ToNot(HaveOccurred())
+
+				go func() {
+					defer GinkgoRecover()
+					wg.Add(1)
+					Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+				}()
+				defer cancel()
+
+				Eventually(streamMesssage(tcs)).Should(BeNumerically("==", 100))
+				Eventually(resumeSeq(stream)).Should(BeNumerically("==", 100))
+
+				Expect(ts.Delete()).To(Succeed())
+				_, err = mgr.NewStream("TEST")
+				Expect(err).ToNot(HaveOccurred())
+				publishToSource(nc, "TEST", 10)
+
+				Eventually(streamMesssage(tcs)).Should(BeNumerically("==", 110))
+				Eventually(resumeSeq(stream)).Should(BeNumerically("==", 10))
+				Expect(getPromCountValue(sourceRecreatedCount, "TEST", "GINKGO", scfg.Name)).To(Equal(1.0))
+			})
+		})
+
 		It("Should support leader election", func() {
 			testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
 				js, err := nc.JetStream()
@@ -308,3 +420,719 @@ var _ = Describe("Source to Destination Copier", func() {
 		})
 	})
 })
+
+var _ = Describe("Source to Destination Copier Fetch", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	It("Should copy batches of messages in order", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			ts, err := mgr.NewStream("TEST")
+			Expect(err).ToNot(HaveOccurred())
+			tcs, err := mgr.NewStream("TEST_COPY", jsm.Subjects("copy.>"))
+			Expect(err).ToNot(HaveOccurred())
+
+			for i := 1; i <= 1000; i++ {
+				_, err := nc.Request("TEST", []byte(fmt.Sprintf(`{"msg":%d}`, i)), time.Second)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			scfg := &config.Stream{
+				Stream:       "TEST",
+				TargetStream: "TEST_COPY",
+				TargetPrefix: "copy",
+				SourceURL:    nc.ConnectedUrl(),
+				TargetURL:    nc.ConnectedUrl(),
+				Fetch:        &config.Fetch{Batch: 50, MaxBytes: 400, ExpiresString: "1s"},
+			}
+			sr := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+			Expect(sr.Validate()).To(Succeed())
+
+			stream, err := NewStream(scfg, sr, log)
+			Expect(err).ToNot(HaveOccurred())
+
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+			}()
+
+			Eventually(func() (uint64, error) {
+				nfo, err := tcs.State()
+				return nfo.Msgs, err
+			}, "10s").Should(Equal(uint64(1000)))
+
+			for i := 1; i <= 1000; i++ {
+				msg, err := tcs.ReadMessage(uint64(i))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(msg.Data).To(Equal([]byte(fmt.Sprintf(`{"msg":%d}`, i))))
+			}
+
+			consumer, err := ts.LoadConsumer(stream.cname)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(consumer.MaxAckPending()).To(Equal(50))
+		})
+	})
+
+	It("Should grow batches while messages are pending", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			ts, err := mgr.NewStream("TEST")
+			Expect(err).ToNot(HaveOccurred())
+			tcs, err := mgr.NewStream("TEST_COPY", jsm.Subjects("copy.>"))
+			Expect(err).ToNot(HaveOccurred())
+
+			for i := 1; i <= 1000; i++ {
+				_, err := nc.Request("TEST", []byte(fmt.Sprintf(`{"msg":%d}`, i)), time.Second)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			scfg := &config.Stream{
+				Stream:       "TEST",
+				TargetStream: "TEST_COPY",
+				TargetPrefix: "copy",
+				SourceURL:    nc.ConnectedUrl(),
+				TargetURL:    nc.ConnectedUrl(),
+				Fetch:        &config.Fetch{Batch: 1, MaxBatch: 64},
+			}
+			sr := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+			Expect(sr.Validate()).To(Succeed())
+
+			stream, err := NewStream(scfg, sr, log)
+			Expect(err).ToNot(HaveOccurred())
+
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+			}()
+
+			Eventually(func() (uint64, error) {
+				nfo, err := tcs.State()
+				return nfo.Msgs, err
+			}, "10s").Should(Equal(uint64(1000)))
+
+			for i := 1; i <= 1000; i++ {
+				msg, err := tcs.ReadMessage(uint64(i))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(msg.Data).To(Equal([]byte(fmt.Sprintf(`{"msg":%d}`, i))))
+			}
+
+			consumer, err := ts.LoadConsumer(stream.cname)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(consumer.MaxAckPending()).To(Equal(64))
+		})
+	})
+
+	It("Should transform messages using transform workers", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("TEST")
+			Expect(err).ToNot(HaveOccurred())
+			tcs, err := mgr.NewStream("TEST_COPY", jsm.Subjects("copy.>"))
+			Expect(err).ToNot(HaveOccurred())
+
+			for i := 1; i <= 100; i++ {
+				_, err := nc.Request("TEST", []byte(fmt.Sprintf(`{"id":%d}`, i)), time.Second)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			scfg := &config.Stream{
+				Stream:       "TEST",
+				TargetStream: "TEST_COPY",
+				TargetPrefix: "copy",
+				SourceURL:    nc.ConnectedUrl(),
+				TargetURL:    nc.ConnectedUrl(),
+				Fetch:        &config.Fetch{Batch: 10},
+				Transform:    &config.Transform{Filter: "data.id % 2 == 0", Mapping: `{"even": data.id}`, Workers: 4},
+			}
+			sr := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+			Expect(sr.Validate()).To(Succeed())
+
+			stream, err := NewStream(scfg, sr, log)
+			Expect(err).ToNot(HaveOccurred())
+
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+			}()
+
+			Eventually(func() (uint64, error) {
+				nfo, err := tcs.State()
+				return nfo.Msgs, err
+			}, "10s").Should(Equal(uint64(50)))
+
+			for i := 1; i <= 50; i++ {
+				msg, err := tcs.ReadMessage(uint64(i))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(msg.Data).To(MatchJSON(fmt.Sprintf(`{"even":%d}`, i*2)))
+			}
+		})
+	})
+
+	It("Should route and filter messages using scripts", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("TEST", jsm.Subjects("TEST.>"))
+			Expect(err).ToNot(HaveOccurred())
+			tcs, err := mgr.NewStream("TEST_COPY", jsm.Subjects("copy.>"))
+			Expect(err).ToNot(HaveOccurred())
+
+			file := filepath.Join(GinkgoT().TempDir(), "route.lua")
+			Expect(os.WriteFile(file, []byte(`
+function process(msg)
+  if msg.headers["Skip"] then
+    return false
+  end
+
+  if string.find(msg.data, "urgent") then
+    msg.subject = "TEST.urgent"
+    msg.headers["Priority"] = "high"
+  end
+
+  return true
+end
+`), 0600)).To(Succeed())
+
+			for i := 1; i <= 6; i++ {
+				msg := nats.NewMsg("TEST.orders")
+				msg.Data = []byte(fmt.Sprintf(`{"id":%d}`, i))
+				switch i % 3 {
+				case 1:
+					msg.Data = []byte(fmt.Sprintf(`{"id":%d,"urgent":true}`, i))
+				case 2:
+					msg.Header.Set("Skip", "1")
+				}
+				_, err := nc.RequestMsg(msg, time.Second)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			scfg := &config.Stream{
+				Stream:       "TEST",
+				TargetStream: "TEST_COPY",
+				TargetPrefix: "copy",
+				SourceURL:    nc.ConnectedUrl(),
+				TargetURL:    nc.ConnectedUrl(),
+				Script:       &config.Script{File: file},
+			}
+			sr := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+			Expect(sr.Validate()).To(Succeed())
+
+			stream, err := NewStream(scfg, sr, log)
+			Expect(err).ToNot(HaveOccurred())
+
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+			}()
+
+			Eventually(func() (uint64, error) {
+				nfo, err := tcs.State()
+				return nfo.Msgs, err
+			}, "10s").Should(Equal(uint64(4)))
+
+			for i, id := range []int{1, 3, 4, 6} {
+				msg, err := tcs.ReadMessage(uint64(i + 1))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(msg.Data).To(ContainSubstring(fmt.Sprintf(`"id":%d`, id)))
+				hdrs, err := decodeHeadersMsg(msg.Header)
+				Expect(err).ToNot(HaveOccurred())
+
+				if id%3 == 1 {
+					Expect(msg.Subject).To(Equal("copy.TEST.urgent"))
+					Expect(hdrs.Get("Priority")).To(Equal("high"))
+				} else {
+					Expect(msg.Subject).To(Equal("copy.TEST.orders"))
+					Expect(hdrs.Get("Priority")).To(BeEmpty())
+				}
+			}
+
+			Expect(getPromCountValue(scriptDiscardedCount, "TEST", "GINKGO", scfg.Name)).To(Equal(2.0))
+		})
+	})
+
+	It("Should catch up using the catch up profile", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			ts, err := mgr.NewStream("TEST", jsm.Subjects("TEST.>"))
+			Expect(err).ToNot(HaveOccurred())
+			tcs, err := mgr.NewStream("TEST_COPY", jsm.Subjects("copy.>"))
+			Expect(err).ToNot(HaveOccurred())
+
+			for i := 1; i <= 1000; i++ {
+				_, err := nc.Request(fmt.Sprintf("TEST.%d", i%10), []byte(fmt.Sprintf(`{"id":%d,"extra":true}`, i)), time.Second)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			scfg := &config.Stream{
+				Stream:            "TEST",
+				TargetStream:      "TEST_COPY",
+				TargetPrefix:      "copy",
+				SourceURL:         nc.ConnectedUrl(),
+				TargetURL:         nc.ConnectedUrl(),
+				TargetConnections: 4,
+				Transform:         &config.Transform{Mapping: `{"id": data.id}`},
+				CatchUp:           &config.CatchUp{Pending: 100, Batch: 200, Parallel: true, SkipTransform: true},
+			}
+			sr := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+			Expect(sr.Validate()).To(Succeed())
+
+			stream, err := NewStream(scfg, sr, log)
+			Expect(err).ToNot(HaveOccurred())
+
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+			}()
+
+			Eventually(func() (uint64, error) {
+				nfo, err := tcs.State()
+				return nfo.Msgs, err
+			}, "10s").Should(Equal(uint64(1000)))
+
+			last := map[string]int{}
+			transformed := 0
+			for i := 1; i <= 1000; i++ {
+				msg, err := tcs.ReadMessage(uint64(i))
+				Expect(err).ToNot(HaveOccurred())
+
+				var body struct {
+					ID    int  `json:"id"`
+					Extra bool `json:"extra"`
+				}
+				Expect(json.Unmarshal(msg.Data, &body)).To(Succeed())
+				Expect(body.ID).To(BeNumerically(">", last[msg.Subject]))
+				last[msg.Subject] = body.ID
+
+				if !body.Extra {
+					transformed++
+				}
+			}
+
+			// the first message is copied before catching up starts, messages once fewer than 50 are pending after
+			Expect(transformed).To(Equal(50))
+
+			consumer, err := ts.LoadConsumer(stream.cname)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(consumer.MaxAckPending()).To(Equal(200))
+		})
+	})
+
+	It("Should throttle requests while over the memory budget", func() {
+		testutil.WithLoadedJetStream(log, "TEST", 500, 64, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			tcs, err := mgr.NewStream("TEST_COPY", jsm.Subjects("copy.>"))
+			Expect(err).ToNot(HaveOccurred())
+
+			scfg := &config.Stream{
+				Stream:            "TEST",
+				TargetStream:      "TEST_COPY",
+				TargetPrefix:      "copy",
+				SourceURL:         nc.ConnectedUrl(),
+				TargetURL:         nc.ConnectedUrl(),
+				TargetConnections: 4,
+				Fetch:             &config.Fetch{Batch: 100},
+				MemoryBudget:      512,
+			}
+			sr := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+			Expect(sr.Validate()).To(Succeed())
+
+			stream, err := NewStream(scfg, sr, log)
+			Expect(err).ToNot(HaveOccurred())
+
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+			}()
+
+			Eventually(func() (uint64, error) {
+				nfo, err := tcs.State()
+				return nfo.Msgs, err
+			}, "20s").Should(Equal(uint64(500)))
+
+			last := map[string]int{}
+			for i := 1; i <= 500; i++ {
+				msg, err := tcs.ReadMessage(uint64(i))
+				Expect(err).ToNot(HaveOccurred())
+
+				var body struct {
+					ID int `json:"id"`
+				}
+				Expect(json.Unmarshal(msg.Data, &body)).To(Succeed())
+				Expect(body.ID).To(BeNumerically(">", last[msg.Subject]))
+				last[msg.Subject] = body.ID
+			}
+
+			Eventually(stream.budget.inFlight).Should(Equal(int64(0)))
+		})
+	})
+
+	It("Should publish over several connections keeping subjects in order", func() {
+		testutil.WithJetStream(log, func(srv *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("TEST", jsm.Subjects("TEST.>"))
+			Expect(err).ToNot(HaveOccurred())
+			tcs, err := mgr.NewStream("TEST_COPY", jsm.Subjects("copy.>"))
+			Expect(err).ToNot(HaveOccurred())
+
+			for i := 1; i <= 1000; i++ {
+				_, err := nc.Request(fmt.Sprintf("TEST.%d", i%10), []byte(fmt.Sprintf("%d", i)), time.Second)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			scfg := &config.Stream{
+				Stream:            "TEST",
+				TargetStream:      "TEST_COPY",
+				TargetPrefix:      "copy",
+				SourceURL:         nc.ConnectedUrl(),
+				TargetURL:         nc.ConnectedUrl(),
+				TargetConnections: 4,
+				Fetch:             &config.Fetch{Batch: 100},
+			}
+			sr := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+			Expect(sr.Validate()).To(Succeed())
+
+			stream, err := NewStream(scfg, sr, log)
+			Expect(err).ToNot(HaveOccurred())
+
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+			}()
+
+			Eventually(func() (uint64, error) {
+				nfo, err := tcs.State()
+				return nfo.Msgs, err
+			}, "10s").Should(Equal(uint64(1000)))
+
+			Expect(stream.dest.publishers).To(HaveLen(3))
+			Expect(srv.NumClients()).To(BeNumerically(">=", 5))
+
+			last := map[string]int{}
+			for i := 1; i <= 1000; i++ {
+				msg, err := tcs.ReadMessage(uint64(i))
+				Expect(err).ToNot(HaveOccurred())
+
+				var n int
+				_, err = fmt.Sscanf(string(msg.Data), "%d", &n)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(BeNumerically(">", last[msg.Subject]))
+				last[msg.Subject] = n
+			}
+			Expect(last).To(HaveLen(10))
+		})
+	})
+
+	It("Should copy to publishers and redeliver failed messages", func() {
+		testutil.WithLoadedJetStream(log, "TEST", 100, 128, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			pub := replicatortest.NewPublisher()
+			pub.FailNext(2, errors.New("injected failure"))
+
+			scfg := &config.Stream{
+				Stream:    "TEST",
+				SourceURL: nc.ConnectedUrl(),
+				Fetch:     &config.Fetch{Batch: 50},
+			}
+			sr := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+			Expect(sr.Validate()).To(Succeed())
+
+			stream, err := NewStream(scfg, sr, log, WithPublisher(pub))
+			Expect(err).ToNot(HaveOccurred())
+
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+			}()
+
+			Expect(pub.WaitForMessages(ctx, 100)).To(Succeed())
+			Expect(pub.Attempts()).To(Equal(102))
+
+			ids := map[int]struct{}{}
+			for _, msg := range pub.Messages() {
+				var body struct {
+					ID int `json:"id"`
+				}
+				Expect(json.Unmarshal(msg.Data, &body)).To(Succeed())
+				Expect(msg.Subject).To(Equal(fmt.Sprintf("TEST.%d", body.ID%10)))
+				ids[body.ID] = struct{}{}
+			}
+			Expect(ids).To(HaveLen(100))
+
+			_, err = mgr.LoadStream("TEST_COPY")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	It("Should only support publishers for source initiated streams", func() {
+		pub := replicatortest.NewPublisher()
+
+		_, err := NewStream(&config.Stream{Stream: "TEST", SourceURL: "nats://localhost:4222", TargetInitiated: true}, &config.Config{ReplicatorName: "GINKGO"}, log, WithPublisher(pub))
+		Expect(err).To(MatchError("publishers can not be used with target initiated streams"))
+
+		_, err = NewStream(&config.Stream{Stream: "TEST", SourceMQTT: &config.MQTT{}}, &config.Config{ReplicatorName: "GINKGO"}, log, WithPublisher(pub))
+		Expect(err).To(MatchError("publishers can only be used with source_url or source_file streams"))
+
+		_, err = NewStream(&config.Stream{Stream: "TEST", SourceURL: "nats://localhost:4222"}, &config.Config{ReplicatorName: "GINKGO"}, log)
+		Expect(err).To(MatchError(HavePrefix("target_url, target_kafka")))
+	})
+})
+
+var _ = Describe("Source to Destination Copier Cluster", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	It("Should create replicated targets and copy through leader failover", func() {
+		testutil.WithJetStreamCluster(nil, 3, func(servers []*server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := testutil.LoadStream(nc, mgr, "TEST", 500, 128, jsm.Replicas(3))
+			Expect(err).ToNot(HaveOccurred())
+
+			scfg := &config.Stream{
+				Stream:       "TEST",
+				TargetStream: "TEST_COPY",
+				TargetPrefix: "copy",
+				SourceURL:    testutil.ClientURLs(servers),
+				TargetURL:    testutil.ClientURLs(servers),
+			}
+			sr := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+			Expect(sr.Validate()).To(Succeed())
+
+			stream, err := NewStream(scfg, sr, log)
+			Expect(err).ToNot(HaveOccurred())
+
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+			}()
+
+			copied := func() (uint64, error) {
+				tcs, err := mgr.LoadStream("TEST_COPY")
+				if err != nil {
+					return 0, err
+				}
+				nfo, err := tcs.State()
+				return nfo.Msgs, err
+			}
+
+			Eventually(copied, "20s").Should(Equal(uint64(500)))
+
+			tcs, err := mgr.LoadStream("TEST_COPY")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tcs.Replicas()).To(Equal(3))
+			nfo, err := tcs.LatestInformation()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(nfo.Cluster.Replicas).To(HaveLen(2))
+
+			leader := testutil.StreamLeader(servers, "TEST_COPY")
+			Expect(leader).ToNot(BeNil())
+			leader.Shutdown()
+			leader.WaitForShutdown()
+
+			js, err := nc.JetStream()
+			Expect(err).ToNot(HaveOccurred())
+			for i := 501; i <= 1000; i++ {
+				Eventually(func() error {
+					_, err := js.Publish(fmt.Sprintf("TEST.%d", i%10), testutil.Payload(i, 128))
+					return err
+				}, "20s", "100ms").Should(Succeed())
+			}
+
+			Eventually(copied, "40s").Should(Equal(uint64(1000)))
+		})
+	})
+})
+
+var _ = Describe("Source to Destination Copier Partitions", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	run := func(scfg *config.Stream) {
+		sr := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+		Expect(sr.Validate()).To(Succeed())
+
+		stream, err := NewStream(scfg, sr, log)
+		Expect(err).ToNot(HaveOccurred())
+
+		wg.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			Expect(stream.Run(ctx, &wg)).ToNot(HaveOccurred())
+		}()
+	}
+
+	publish := func(nc *nats.Conn, from int, to int) {
+		js, err := nc.JetStream()
+		Expect(err).ToNot(HaveOccurred())
+		for i := from; i <= to; i++ {
+			_, err := js.Publish(fmt.Sprintf("TEST.%d", i%10), testutil.Payload(i, 128))
+			Expect(err).ToNot(HaveOccurred())
+		}
+	}
+
+	expectCopied := func(tcs *jsm.Stream, count int) {
+		for i := 1; i <= count; i++ {
+			msg, err := tcs.ReadMessage(uint64(i))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(msg.Data).To(Equal(testutil.Payload(i, 128)))
+		}
+	}
+
+	It("Should resume copying once a partitioned source heals", func() {
+		testutil.WithLoadedJetStream(log, "TEST", 100, 128, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			tcs, err := mgr.NewStream("TEST_COPY", jsm.Subjects("copy.>"))
+			Expect(err).ToNot(HaveOccurred())
+
+			proxy, err := testutil.NewProxy(nc.ConnectedUrl())
+			Expect(err).ToNot(HaveOccurred())
+			defer proxy.Close()
+
+			run(&config.Stream{
+				Stream:       "TEST",
+				TargetStream: "TEST_COPY",
+				TargetPrefix: "copy",
+				SourceURL:    proxy.URL(),
+				TargetURL:    nc.ConnectedUrl(),
+				Reconnect:    &config.Reconnect{MinDelayString: "100ms", MaxDelayString: "500ms"},
+			})
+
+			copied := func() (uint64, error) {
+				nfo, err := tcs.State()
+				return nfo.Msgs, err
+			}
+
+			Eventually(copied, "10s").Should(Equal(uint64(100)))
+
+			proxy.Partition()
+			accepted := proxy.Accepted()
+			publish(nc, 101, 200)
+			Consistently(copied, "2s").Should(Equal(uint64(100)))
+
+			proxy.Heal()
+			Eventually(copied, "20s").Should(Equal(uint64(200)))
+			Expect(proxy.Accepted()).To(BeNumerically(">", accepted))
+
+			expectCopied(tcs, 200)
+		})
+	})
+
+	It("Should redeliver messages that could not be stored in a partitioned target", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("TEST", jsm.Subjects("TEST.>"))
+			Expect(err).ToNot(HaveOccurred())
+			tcs, err := mgr.NewStream("TEST_COPY", jsm.Subjects("copy.>"))
+			Expect(err).ToNot(HaveOccurred())
+
+			proxy, err := testutil.NewProxy(nc.ConnectedUrl())
+			Expect(err).ToNot(HaveOccurred())
+			defer proxy.Close()
+
+			proxy.SetLatency(5 * time.Millisecond)
+
+			run(&config.Stream{
+				Stream:       "TEST",
+				TargetStream: "TEST_COPY",
+				TargetPrefix: "copy",
+				SourceURL:    nc.ConnectedUrl(),
+				TargetURL:    proxy.URL(),
+				Reconnect:    &config.Reconnect{MinDelayString: "100ms", MaxDelayString: "500ms"},
+			})
+
+			copied := func() (uint64, error) {
+				nfo, err := tcs.State()
+				return nfo.Msgs, err
+			}
+
+			publish(nc, 1, 200)
+			Eventually(copied, "10s").Should(BeNumerically(">", 0))
+
+			proxy.Partition()
+			time.Sleep(time.Second)
+			proxy.Heal()
+			proxy.SetLatency(0)
+
+			id := func(seq uint64) (int, error) {
+				msg, err := tcs.ReadMessage(seq)
+				if err != nil {
+					return 0, err
+				}
+
+				var body struct {
+					ID int `json:"id"`
+				}
+				err = json.Unmarshal(msg.Data, &body)
+
+				return body.ID, err
+			}
+
+			Eventually(func() (int, error) {
+				nfo, err := tcs.State()
+				if err != nil || nfo.Msgs == 0 {
+					return 0, err
+				}
+				return id(nfo.LastSeq)
+			}, "30s").Should(Equal(200))
+
+			// a message stored just before the partition is published again when its acknowledgement was lost
+			nfo, err := tcs.State()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(nfo.Msgs).To(BeNumerically(">=", 200))
+
+			last := 0
+			for seq := uint64(1); seq <= nfo.LastSeq; seq++ {
+				i, err := id(seq)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(i).To(Or(Equal(last), Equal(last+1)))
+				last = i
+			}
+			Expect(last).To(Equal(200))
+		})
+	})
+})
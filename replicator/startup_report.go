@@ -0,0 +1,95 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/choria-io/stream-replicator/events"
+)
+
+// startupReport describes what a stream found when it started copying and where it decided to copy from, it is
+// logged and published once per start
+type startupReport struct {
+	// consumer is the name of the source consumer, empty for ephemeral consumers without a fixed name
+	consumer string
+	// consumerExists is true when the consumer existed before the stream started
+	consumerExists bool
+	// delivered and ackFloor are the stream sequences the existing consumer delivered and had acknowledged
+	delivered uint64
+	ackFloor  uint64
+	// target is the source sequence of the last message the stream copied to the target, empty when unknown
+	target string
+	// decision is what the stream decided to do
+	decision string
+}
+
+// targetSequenceReport describes the source sequence of the last message copied to the target for startup reports
+func (s *Stream) targetSequenceReport() string {
+	if s.dest == nil || s.dest.mgr == nil || s.cfg.StreamTemplate != nil {
+		return _EMPTY_
+	}
+
+	seq, reason, err := s.targetSourceSequence()
+	switch {
+	case err != nil:
+		s.log.Debugf("Could not determine the last sequence copied to the target: %v", err)
+		return _EMPTY_
+	case reason != _EMPTY_:
+		s.log.Debugf("Could not determine the last sequence copied to the target: %s", reason)
+		return _EMPTY_
+	}
+
+	return strconv.FormatUint(seq, 10)
+}
+
+// reportStartup logs report and publishes it as an event
+func (s *Stream) reportStartup(report *startupReport) {
+	state := "none"
+	if s.cfg.StateFile != _EMPTY_ {
+		state = s.cfg.StateFile
+		if _, err := os.Stat(s.cfg.StateFile); err != nil {
+			state = fmt.Sprintf("%s (missing)", s.cfg.StateFile)
+		}
+	}
+
+	target := report.target
+	if target == _EMPTY_ {
+		target = "unknown"
+	}
+
+	var found []string
+	switch {
+	case report.consumer == _EMPTY_:
+		found = append(found, "ephemeral consumer")
+	case report.consumerExists:
+		found = append(found, fmt.Sprintf("consumer %s delivered sequence %d acknowledged %d", report.consumer, report.delivered, report.ackFloor))
+	default:
+		found = append(found, fmt.Sprintf("consumer %s does not exist", report.consumer))
+	}
+	found = append(found, fmt.Sprintf("target sequence %s", target), fmt.Sprintf("state file %s", state))
+
+	msg := fmt.Sprintf("Startup reconciliation found %s, %s", strings.Join(found, ", "), report.decision)
+	s.log.Info(msg)
+
+	s.events.Publish(&events.Event{
+		Event:   events.StartupReconciledEvent,
+		Stream:  s.cfg.Stream,
+		Name:    s.cfg.Name,
+		Message: msg,
+		Data: map[string]string{
+			"consumer":           report.consumer,
+			"consumer_exists":    strconv.FormatBool(report.consumerExists),
+			"consumer_delivered": strconv.FormatUint(report.delivered, 10),
+			"consumer_ack_floor": strconv.FormatUint(report.ackFloor, 10),
+			"target_sequence":    target,
+			"state_file":         state,
+			"decision":           report.decision,
+		},
+	})
+}
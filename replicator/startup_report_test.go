@@ -0,0 +1,175 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/events"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Startup Report", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	publish := func(nc *nats.Conn, count int) {
+		for i := 0; i < count; i++ {
+			_, err := nc.Request("TEST", []byte("x"), time.Second)
+			Expect(err).ToNot(HaveOccurred())
+		}
+	}
+
+	// run copies TEST to COPY until runCtx is done publishing events to p
+	run := func(runCtx context.Context, nc *nats.Conn, p *events.Publisher, targetInitiated bool) *sync.WaitGroup {
+		scfg := &config.Stream{
+			Stream:          "TEST",
+			TargetStream:    "COPY",
+			TargetPrefix:    "copy",
+			SourceURL:       nc.ConnectedUrl(),
+			TargetURL:       nc.ConnectedUrl(),
+			TargetInitiated: targetInitiated,
+		}
+		if targetInitiated {
+			scfg.FilterSubject = "TEST"
+		}
+		sr := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+		Expect(sr.Validate()).To(Succeed())
+
+		stream, err := NewStream(scfg, sr, log, WithEvents(p))
+		Expect(err).ToNot(HaveOccurred())
+
+		swg := &sync.WaitGroup{}
+		swg.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			Expect(stream.Run(runCtx, swg)).To(Succeed())
+		}()
+
+		return swg
+	}
+
+	nextReport := func(msgs chan *nats.Msg) *events.Event {
+		var msg *nats.Msg
+		Eventually(msgs, 5*time.Second).Should(Receive(&msg))
+		event := &events.Event{}
+		Expect(json.Unmarshal(msg.Data, event)).To(Succeed())
+		Expect(event.Event).To(Equal(events.StartupReconciledEvent))
+
+		return event
+	}
+
+	withEvents := func(cb func(nc *nats.Conn, target *jsm.Stream, p *events.Publisher, reports chan *nats.Msg)) {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("TEST", jsm.Subjects("TEST"))
+			Expect(err).ToNot(HaveOccurred())
+			target, err := mgr.NewStream("COPY", jsm.Subjects("copy.>"))
+			Expect(err).ToNot(HaveOccurred())
+
+			reports := make(chan *nats.Msg, 10)
+			_, err = nc.ChanSubscribe("events.startup_reconciliation", reports)
+			Expect(err).ToNot(HaveOccurred())
+
+			p := startEvents(ctx, &wg, nc, log)
+
+			cb(nc, target, p, reports)
+		})
+	}
+
+	streamMessages := func(s *jsm.Stream) func() (uint64, error) {
+		return func() (uint64, error) {
+			nfo, err := s.State()
+			return nfo.Msgs, err
+		}
+	}
+
+	It("Should report the consumer and target found by source initiated streams", func() {
+		withEvents(func(nc *nats.Conn, target *jsm.Stream, p *events.Publisher, reports chan *nats.Msg) {
+			publish(nc, 5)
+
+			first, stop := context.WithCancel(ctx)
+			swg := run(first, nc, p, false)
+
+			event := nextReport(reports)
+			Expect(event.Data).To(Equal(map[string]string{
+				"consumer":           "SR_GINKGO",
+				"consumer_exists":    "false",
+				"consumer_delivered": "0",
+				"consumer_ack_floor": "0",
+				"target_sequence":    "0",
+				"state_file":         "none",
+				"decision":           "creating the consumer starting at the first message",
+			}))
+
+			Eventually(streamMessages(target), 5*time.Second).Should(Equal(uint64(5)))
+			stop()
+			swg.Wait()
+
+			second, stop := context.WithCancel(ctx)
+			defer stop()
+			run(second, nc, p, false)
+
+			event = nextReport(reports)
+			Expect(event.Data["consumer_exists"]).To(Equal("true"))
+			Expect(event.Data["consumer_delivered"]).To(Equal("5"))
+			Expect(event.Data["consumer_ack_floor"]).To(Equal("5"))
+			Expect(event.Data["target_sequence"]).To(Equal("5"))
+			Expect(event.Data["decision"]).To(Equal("resuming the existing consumer"))
+			Expect(event.Message).To(Equal("Startup reconciliation found consumer SR_GINKGO delivered sequence 5 acknowledged 5, target sequence 5, state file none, resuming the existing consumer"))
+		})
+	})
+
+	It("Should report where target initiated streams resume", func() {
+		withEvents(func(nc *nats.Conn, target *jsm.Stream, p *events.Publisher, reports chan *nats.Msg) {
+			publish(nc, 5)
+
+			first, stop := context.WithCancel(ctx)
+			swg := run(first, nc, p, true)
+
+			event := nextReport(reports)
+			Expect(event.Data["consumer_exists"]).To(Equal("false"))
+			Expect(event.Data["target_sequence"]).To(Equal("0"))
+			Expect(event.Data["decision"]).To(Equal("creating an ephemeral consumer starting at the first message"))
+
+			Eventually(streamMessages(target), 5*time.Second).Should(Equal(uint64(5)))
+			stop()
+			swg.Wait()
+
+			second, stop := context.WithCancel(ctx)
+			defer stop()
+			run(second, nc, p, true)
+
+			event = nextReport(reports)
+			Expect(event.Data["target_sequence"]).To(Equal("5"))
+			Expect(event.Data["decision"]).To(Equal("creating an ephemeral consumer starting at sequence 6 after the last message in the target"))
+		})
+	})
+})
@@ -29,11 +29,22 @@ var (
 		Help: "How long it took to process messages",
 	}, []string{"stream", "replicator", "worker"})
 
+	messageAge = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    prometheus.BuildFQName("choria_stream_replicator", "replicator", "message_age_seconds"),
+		Help:    "How old messages were when received for replication, based on their source timestamp",
+		Buckets: []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 3600, 21600, 86400},
+	}, []string{"stream", "replicator", "worker"})
+
 	streamSequence = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "stream_sequence"),
 		Help: "The stream sequence of the last message received from the consumer",
 	}, []string{"stream", "replicator", "worker"})
 
+	pendingMessages = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "pending_messages"),
+		Help: "The number of messages in the source stream still to be received by the consumer",
+	}, []string{"stream", "replicator", "worker"})
+
 	ageSkippedCount = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "too_old_messages"),
 		Help: "How many messages were discarded for being too old",
@@ -69,10 +80,248 @@ var (
 		Help: "How many times an ack or nack failed",
 	}, []string{"stream", "replicator", "worker"})
 
+	verifyFailedCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "verify_failed_messages"),
+		Help: "How many messages failed signature verification",
+	}, []string{"stream", "replicator", "worker"})
+
+	checksumFailedCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "checksum_failed_messages"),
+		Help: "How many messages failed checksum verification",
+	}, []string{"stream", "replicator", "worker"})
+
+	deltaSkippedCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "delta_skipped_messages"),
+		Help: "How many messages were not copied because they repeat the last document copied for their identity",
+	}, []string{"stream", "replicator", "worker"})
+
+	deltaPatchedCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "delta_patched_messages"),
+		Help: "How many messages were copied as JSON merge patches",
+	}, []string{"stream", "replicator", "worker"})
+
+	deltaSavedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "delta_saved_bytes"),
+		Help: "How many payload bytes were not copied due to skipped messages and merge patches",
+	}, []string{"stream", "replicator", "worker"})
+
+	tenantViolationCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "tenant_violation_messages"),
+		Help: "How many messages failed tenant verification",
+	}, []string{"stream", "replicator", "worker"})
+
+	transformDiscardedCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "transform_discarded_messages"),
+		Help: "How many messages were discarded by the filter or mapping of a transform or because the transform failed",
+	}, []string{"stream", "replicator", "worker"})
+
+	scriptDiscardedCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "script_discarded_messages"),
+		Help: "How many messages were discarded by the script or because the script failed",
+	}, []string{"stream", "replicator", "worker"})
+
+	deadlineExceededCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "deadline_exceeded_messages"),
+		Help: "How many messages were not transformed and published within the processing deadline",
+	}, []string{"stream", "replicator", "worker"})
+
+	deadLetteredCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "dead_lettered_messages"),
+		Help: "How many messages exceeding the processing deadline or max_payload were published to the dead letter subject",
+	}, []string{"stream", "replicator", "worker"})
+
+	oversizedCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "oversized_messages"),
+		Help: "How many messages had payloads larger than max_payload and were dropped, dead lettered or truncated",
+	}, []string{"stream", "replicator", "worker"})
+
+	spooledCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "spooled_messages"),
+		Help: "How many messages were written to the spool while the target was unreachable",
+	}, []string{"stream", "replicator", "worker"})
+
+	spoolDrainedCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "spool_drained_messages"),
+		Help: "How many spooled messages were published to the target",
+	}, []string{"stream", "replicator", "worker"})
+
+	spoolCorruptCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "spool_corrupt_messages"),
+		Help: "How many spooled messages were dropped as they could not be read",
+	}, []string{"stream", "replicator", "worker"})
+
+	spoolMessages = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "spool_messages"),
+		Help: "The number of messages in the spool waiting to be published to the target",
+	}, []string{"stream", "replicator", "worker"})
+
+	spoolBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "spool_bytes"),
+		Help: "The size of the messages in the spool waiting to be published to the target",
+	}, []string{"stream", "replicator", "worker"})
+
+	scheduleWaitTime = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "schedule_wait_seconds"),
+		Help: "How long messages waited for bandwidth from the scheduler",
+	}, []string{"stream", "replicator", "worker"})
+
+	bandwidthLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "bandwidth_limit_bytes"),
+		Help: "The bandwidth in bytes per second the bandwidth schedule currently limits the stream to, 0 when unlimited",
+	}, []string{"stream", "replicator", "worker"})
+
+	batchSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "batch_size"),
+		Help: "How many messages are requested from the source at a time",
+	}, []string{"stream", "replicator", "worker"})
+
+	catchingUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "catching_up"),
+		Help: "Indicates if the replicator is catching up with messages pending in the source",
+	}, []string{"stream", "replicator", "worker"})
+
+	inMaintenance = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "in_maintenance"),
+		Help: "Indicates if replication is paused during a maintenance window",
+	}, []string{"stream", "replicator", "worker"})
+
+	quiesced = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "quiesced"),
+		Help: "Indicates if replication is paused after being quiesced",
+	}, []string{"stream", "replicator", "worker"})
+
+	failedOver = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "failed_over"),
+		Help: "Indicates if the stream failed over and replicates from the target back to the source",
+	}, []string{"stream", "replicator", "worker"})
+
+	orderingViolationCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "ordering_violations"),
+		Help: "How many messages arrived in the target with a lower source sequence than an earlier message on the same subject",
+	}, []string{"stream", "replicator", "worker"})
+
+	dedupDuplicateCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "dedup_duplicate_messages"),
+		Help: "How many messages the target did not store as it already held a message with the same deduplication key",
+	}, []string{"stream", "replicator", "worker"})
+
+	dedupKeyMissingCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "dedup_key_missing_messages"),
+		Help: "How many messages were copied without a deduplication key",
+	}, []string{"stream", "replicator", "worker"})
+
+	templateStreamsCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "template_streams_created"),
+		Help: "How many target streams were created from the stream template",
+	}, []string{"stream", "replicator", "worker"})
+
+	templateUnmatchedCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "template_unmatched_messages"),
+		Help: "How many messages were skipped as their subject did not select a valid target stream from the stream template",
+	}, []string{"stream", "replicator", "worker"})
+
+	targetQuotaUsed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "target_quota_used_percent"),
+		Help: "How much of the target quota the target stream uses",
+	}, []string{"stream", "replicator", "worker"})
+
+	targetQuotaState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "target_quota_state"),
+		Help: "Indicates if replication is throttled (1) or paused (2) due to the target quota",
+	}, []string{"stream", "replicator", "worker"})
+
+	targetStoragePressure = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "target_storage_pressure"),
+		Help: "Indicates if replication is paused as the target can not store more messages",
+	}, []string{"stream", "replicator", "worker"})
+
+	monitorLagMessages = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "monitor_lag_messages"),
+		Help: "The number of source messages newer than the last message in the target for monitor only streams",
+	}, []string{"stream", "replicator", "worker"})
+
+	monitorLagSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "monitor_lag_seconds"),
+		Help: "The time between the last message in the source and the last one copied to the target for monitor only streams",
+	}, []string{"stream", "replicator", "worker"})
+
+	monitorGapMessages = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "monitor_gap_messages"),
+		Help: "The number of source messages up to the last copied one that are missing from the target for monitor only streams",
+	}, []string{"stream", "replicator", "worker"})
+
+	monitorConfigDrift = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "monitor_config_drift"),
+		Help: "The number of stream settings that differ between the source and target for monitor only streams",
+	}, []string{"stream", "replicator", "worker"})
+
+	configDriftSettings = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "config_drift"),
+		Help: "The number of stream settings that differ between the source and target",
+	}, []string{"stream", "replicator", "worker"})
+
+	driftCorrectedCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "drift_corrected_count"),
+		Help: "How many target stream settings were corrected to match the source",
+	}, []string{"stream", "replicator", "worker"})
+
+	memoryBudgetUsed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "memory_budget_used_bytes"),
+		Help: "The estimated memory used by in-flight messages and tracker state",
+	}, []string{"stream", "replicator", "worker"})
+
+	memoryThrottledCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "memory_throttled_count"),
+		Help: "The number of times requesting messages was delayed because the memory budget was exceeded",
+	}, []string{"stream", "replicator", "worker"})
+
+	shardSkippedCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "shard_skipped_messages"),
+		Help: "How many messages were not copied as their subjects belong to other shards",
+	}, []string{"stream", "replicator", "worker"})
+
+	archivedObjectCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "archived_objects"),
+		Help: "How many objects were written to the archive",
+	}, []string{"stream", "replicator", "worker"})
+
+	archivedObjectSize = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "archived_bytes"),
+		Help: "The compressed size of objects written to the archive",
+	}, []string{"stream", "replicator", "worker"})
+
 	consumerRepairCount = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "consumer_recreated"),
 		Help: "How many times the source consumer had to be recreated",
 	}, []string{"stream", "replicator", "worker"})
+	consumerInactiveCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "consumer_inactive"),
+		Help: "How many times the source consumer was recreated after delivering none of its pending messages",
+	}, []string{"stream", "replicator", "worker"})
+	sourceRecreatedCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "source_recreated"),
+		Help: "How many times the source stream was found to be deleted and created again",
+	}, []string{"stream", "replicator", "worker"})
+	consumerAckFloor = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "consumer_ack_floor"),
+		Help: "The stream sequence all messages up to were acknowledged on the source consumer",
+	}, []string{"stream", "replicator", "worker"})
+	consumerPendingMessages = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "consumer_pending_messages"),
+		Help: "The number of messages the source consumer has not delivered yet",
+	}, []string{"stream", "replicator", "worker"})
+	consumerAckPendingMessages = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "consumer_ack_pending_messages"),
+		Help: "The number of messages delivered by the source consumer awaiting acknowledgement",
+	}, []string{"stream", "replicator", "worker"})
+	consumerRedeliveredMessages = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "consumer_redelivered_messages"),
+		Help: "The number of unacknowledged messages the source consumer delivered more than once",
+	}, []string{"stream", "replicator", "worker"})
+	redeliveredMessageCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "replicator", "redelivered_messages"),
+		Help: "How many received messages were redeliveries of messages delivered before",
+	}, []string{"stream", "replicator", "worker"})
 )
 
 func init() {
@@ -80,6 +329,7 @@ func init() {
 	prometheus.MustRegister(receivedMessageSize)
 	prometheus.MustRegister(handlerErrorCount)
 	prometheus.MustRegister(processTime)
+	prometheus.MustRegister(messageAge)
 	prometheus.MustRegister(copiedMessageCount)
 	prometheus.MustRegister(copiedMessageSize)
 	prometheus.MustRegister(skippedMessageCount)
@@ -87,6 +337,56 @@ func init() {
 	prometheus.MustRegister(metaParsingFailedCount)
 	prometheus.MustRegister(ackFailedCount)
 	prometheus.MustRegister(consumerRepairCount)
+	prometheus.MustRegister(consumerInactiveCount)
+	prometheus.MustRegister(sourceRecreatedCount)
+	prometheus.MustRegister(consumerAckFloor)
+	prometheus.MustRegister(consumerPendingMessages)
+	prometheus.MustRegister(consumerAckPendingMessages)
+	prometheus.MustRegister(consumerRedeliveredMessages)
+	prometheus.MustRegister(redeliveredMessageCount)
 	prometheus.MustRegister(streamSequence)
+	prometheus.MustRegister(pendingMessages)
 	prometheus.MustRegister(ageSkippedCount)
+	prometheus.MustRegister(verifyFailedCount)
+	prometheus.MustRegister(checksumFailedCount)
+	prometheus.MustRegister(tenantViolationCount)
+	prometheus.MustRegister(deltaSkippedCount)
+	prometheus.MustRegister(deltaPatchedCount)
+	prometheus.MustRegister(deltaSavedBytes)
+	prometheus.MustRegister(orderingViolationCount)
+	prometheus.MustRegister(dedupDuplicateCount)
+	prometheus.MustRegister(dedupKeyMissingCount)
+	prometheus.MustRegister(templateStreamsCount)
+	prometheus.MustRegister(templateUnmatchedCount)
+	prometheus.MustRegister(targetQuotaUsed)
+	prometheus.MustRegister(targetQuotaState)
+	prometheus.MustRegister(targetStoragePressure)
+	prometheus.MustRegister(transformDiscardedCount)
+	prometheus.MustRegister(scriptDiscardedCount)
+	prometheus.MustRegister(deadlineExceededCount)
+	prometheus.MustRegister(deadLetteredCount)
+	prometheus.MustRegister(oversizedCount)
+	prometheus.MustRegister(spooledCount)
+	prometheus.MustRegister(spoolDrainedCount)
+	prometheus.MustRegister(spoolCorruptCount)
+	prometheus.MustRegister(spoolMessages)
+	prometheus.MustRegister(spoolBytes)
+	prometheus.MustRegister(scheduleWaitTime)
+	prometheus.MustRegister(bandwidthLimit)
+	prometheus.MustRegister(shardSkippedCount)
+	prometheus.MustRegister(batchSize)
+	prometheus.MustRegister(catchingUp)
+	prometheus.MustRegister(inMaintenance)
+	prometheus.MustRegister(quiesced)
+	prometheus.MustRegister(failedOver)
+	prometheus.MustRegister(monitorLagMessages)
+	prometheus.MustRegister(monitorLagSeconds)
+	prometheus.MustRegister(monitorGapMessages)
+	prometheus.MustRegister(monitorConfigDrift)
+	prometheus.MustRegister(configDriftSettings)
+	prometheus.MustRegister(driftCorrectedCount)
+	prometheus.MustRegister(memoryBudgetUsed)
+	prometheus.MustRegister(memoryThrottledCount)
+	prometheus.MustRegister(archivedObjectCount)
+	prometheus.MustRegister(archivedObjectSize)
 }
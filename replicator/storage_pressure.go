@@ -0,0 +1,210 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/choria-io/stream-replicator/events"
+	"github.com/nats-io/jsm.go/api"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// outOfStorageAdvisory is published by JetStream servers that ran out of storage and disabled JetStream, the
+	// system account receives it
+	outOfStorageAdvisory = "$JS.EVENT.ADVISORY.SERVER.OUT_OF_STORAGE"
+
+	// errors JetStream responds with when it can not store messages due to limits or lack of resources
+	errAccountResourcesExceeded = 10002
+	errInsufficientResources    = 10023
+	errMemoryResourcesExceeded  = 10028
+	errStorageResourcesExceeded = 10047
+	errStreamStoreFailed        = 10077
+)
+
+// storageFailure determines if err, returned while publishing to the target, indicates it can not store more
+// messages for lack of storage or due to stream limits
+func storageFailure(err error) bool {
+	if api.IsNatsErr(err, errAccountResourcesExceeded, errInsufficientResources, errMemoryResourcesExceeded, errStorageResourcesExceeded) {
+		return true
+	}
+
+	// stream limits are only reported by description, too large messages use the same code but can not be resolved
+	// by waiting
+	if api.IsNatsErr(err, errStreamStoreFailed) {
+		desc := err.Error()
+		return strings.Contains(desc, "maximum messages") || strings.Contains(desc, "maximum bytes")
+	}
+
+	return false
+}
+
+// targetPublishFailed pauses replication when err, returned while publishing to the target, indicates storage pressure
+func (s *Stream) targetPublishFailed(err error) {
+	if s.cfg.StoragePressure == nil || !storageFailure(err) {
+		return
+	}
+
+	s.setStoragePressure(true, err.Error())
+}
+
+// watchStoragePressure pauses replication on out of storage advisories and resumes it once the target has storage
+// again until ctx is done
+func (s *Stream) watchStoragePressure(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	s.dest.mu.Lock()
+	nc := s.dest.nc
+	s.dest.mu.Unlock()
+
+	sub, err := nc.Subscribe(outOfStorageAdvisory, func(m *nats.Msg) {
+		var adv struct {
+			Server string `json:"server"`
+		}
+		err := json.Unmarshal(m.Data, &adv)
+		if err != nil {
+			s.log.Warnf("Could not parse out of storage advisory: %v", err)
+			return
+		}
+
+		s.setStoragePressure(true, fmt.Sprintf("server %s is out of storage", adv.Server))
+	})
+	if err != nil {
+		s.log.Errorf("Could not subscribe to out of storage advisories: %v", err)
+	} else {
+		defer sub.Unsubscribe()
+	}
+
+	ticker := s.clock.NewTicker(s.cfg.StoragePressure.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			if !s.underStoragePressure() || !s.Leading() {
+				continue
+			}
+
+			used, err := s.targetStorageUsed()
+			if err != nil {
+				s.log.Warnf("Could not check storage of the target stream %s: %v", s.cfg.TargetStream, err)
+				continue
+			}
+
+			if used < float64(s.cfg.StoragePressure.ResumePercent) {
+				s.setStoragePressure(false, fmt.Sprintf("%.1f%% of the target limits are used", used))
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// targetStorageUsed is the highest percentage of a target stream or account limit the target uses, stream limits
+// only count when the target stream discards new messages
+func (s *Stream) targetStorageUsed() (float64, error) {
+	s.dest.mu.Lock()
+	stream := s.dest.stream
+	mgr := s.dest.mgr
+	s.dest.mu.Unlock()
+
+	if mgr == nil {
+		return 0, fmt.Errorf("not connected to the target stream")
+	}
+
+	// the stream is only loaded on start when the replicator manages it
+	if stream == nil {
+		var err error
+		stream, err = mgr.LoadStream(s.cfg.TargetStream)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	state, err := stream.State()
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := mgr.JetStreamAccountInfo()
+	if err != nil {
+		return 0, err
+	}
+
+	var used float64
+	percent := func(v uint64, limit int64) {
+		if limit > 0 {
+			if p := float64(v) / float64(limit) * 100; p > used {
+				used = p
+			}
+		}
+	}
+
+	if stream.DiscardPolicy() == api.DiscardNew {
+		percent(state.Msgs, stream.MaxMsgs())
+		percent(state.Bytes, stream.MaxBytes())
+	}
+
+	if stream.Storage() == api.MemoryStorage {
+		percent(info.Memory, info.Limits.MaxMemory)
+	} else {
+		percent(info.Store, info.Limits.MaxStore)
+	}
+
+	return used, nil
+}
+
+// underStoragePressure determines if replication is paused due to storage pressure on the target
+func (s *Stream) underStoragePressure() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.pressure
+}
+
+// setStoragePressure pauses or resumes replication due to storage pressure on the target, reason describes why
+func (s *Stream) setStoragePressure(pressure bool, reason string) {
+	s.mu.Lock()
+	changed := s.pressure != pressure
+	s.pressure = pressure
+	if changed && s.advisor != nil {
+		if pressure {
+			s.advisor.Pause()
+		} else if !s.pausedLocked() {
+			s.advisor.Resume()
+		}
+	}
+	s.mu.Unlock()
+
+	if !changed {
+		return
+	}
+
+	event := &events.Event{
+		Stream: s.cfg.Stream,
+		Name:   s.cfg.Name,
+		Data:   map[string]string{"reason": reason},
+	}
+
+	if pressure {
+		targetStoragePressure.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Set(1)
+		s.log.Warnf("Pausing replication as the target stream %s is under storage pressure: %s", s.cfg.TargetStream, reason)
+		event.Event = events.StoragePressureEvent
+		event.Message = fmt.Sprintf("Replication paused as %s is under storage pressure", s.cfg.TargetStream)
+	} else {
+		targetStoragePressure.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Set(0)
+		s.log.Warnf("Resuming replication as the target stream %s has storage available: %s", s.cfg.TargetStream, reason)
+		event.Event = events.StorageAvailableEvent
+		event.Message = fmt.Sprintf("Replication resumed as %s has storage available", s.cfg.TargetStream)
+	}
+
+	s.events.Publish(event)
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/events"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/jsm.go/api"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Storage Pressure", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	It("Should detect storage failures", func() {
+		Expect(storageFailure(fmt.Errorf("timeout"))).To(BeFalse())
+		Expect(storageFailure(api.ApiError{Code: 503, ErrCode: 10047, Description: "insufficient storage resources available"})).To(BeTrue())
+		Expect(storageFailure(api.ApiError{Code: 503, ErrCode: 10077, Description: "maximum messages exceeded"})).To(BeTrue())
+		Expect(storageFailure(api.ApiError{Code: 503, ErrCode: 10077, Description: "maximum bytes exceeded"})).To(BeTrue())
+		Expect(storageFailure(api.ApiError{Code: 503, ErrCode: 10077, Description: "message size exceeds maximum allowed"})).To(BeFalse())
+	})
+
+	It("Should pause while the target stream is full and resume once it has space", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("TEST", jsm.Subjects("TEST"))
+			Expect(err).ToNot(HaveOccurred())
+			target, err := mgr.NewStream("COPY", jsm.Subjects("copy.>"), jsm.MaxMessages(5), jsm.DiscardNew())
+			Expect(err).ToNot(HaveOccurred())
+
+			for i := 1; i <= 10; i++ {
+				_, err := nc.Request("TEST", []byte(fmt.Sprintf("%d", i)), time.Second)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			received := make(chan *nats.Msg, 10)
+			_, err = nc.ChanSubscribe("events.>", received)
+			Expect(err).ToNot(HaveOccurred())
+
+			p := startEvents(ctx, &wg, nc, log)
+
+			scfg := &config.Stream{
+				Stream:          "TEST",
+				TargetStream:    "COPY",
+				TargetPrefix:    "copy",
+				SourceURL:       nc.ConnectedUrl(),
+				TargetURL:       nc.ConnectedUrl(),
+				NoTargetCreate:  true,
+				StoragePressure: &config.StoragePressure{},
+			}
+			sr := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+			Expect(sr.Validate()).To(Succeed())
+			scfg.StoragePressure.Interval = 100 * time.Millisecond
+
+			stream, err := NewStream(scfg, sr, log, WithEvents(p))
+			Expect(err).ToNot(HaveOccurred())
+
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				Expect(stream.Run(ctx, &wg)).To(Succeed())
+			}()
+
+			event := nextEvent(received)
+			Expect(event.Event).To(Equal(events.StoragePressureEvent))
+			Expect(event.Data["reason"]).To(ContainSubstring("maximum messages exceeded"))
+			Expect(stream.isPaused()).To(BeTrue())
+
+			// still full
+			Consistently(stream.isPaused, 300*time.Millisecond).Should(BeTrue())
+
+			Expect(target.Purge()).To(Succeed())
+
+			event = nextEvent(received)
+			Expect(event.Event).To(Equal(events.StorageAvailableEvent))
+
+			Eventually(func() (uint64, error) {
+				nfo, err := target.State()
+				return nfo.LastSeq, err
+			}, 20*time.Second).Should(Equal(uint64(10)))
+		})
+	})
+})
@@ -0,0 +1,113 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/jsm.go/api"
+)
+
+// templateStreams creates target streams on demand for the leading subject tokens of copied messages
+type templateStreams struct {
+	s    *Stream
+	dflt api.StreamConfig
+	// known are the leading subject tokens whose target stream is known to hold their messages
+	known map[string]struct{}
+	mu    sync.Mutex
+}
+
+func newTemplateStreams(s *Stream, dflt api.StreamConfig) *templateStreams {
+	return &templateStreams{
+		s:     s,
+		dflt:  dflt,
+		known: make(map[string]struct{}),
+	}
+}
+
+// ensure makes sure a target stream stores messages published to subject, false when the subject has too few tokens
+// or tokens not valid in stream names
+func (t *templateStreams) ensure(subject string) (bool, error) {
+	tmpl := t.s.cfg.StreamTemplate
+
+	name := tmpl.StreamName(t.s.cfg.Stream, subject)
+	if name == _EMPTY_ || !jsm.IsValidName(name) {
+		return false, nil
+	}
+
+	prefix := strings.Join(strings.Split(subject, ".")[:tmpl.Tokens], ".")
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.known[prefix]; ok {
+		return true, nil
+	}
+
+	subjects := []string{prefix, prefix + ".>"}
+
+	exists, err := t.s.dest.mgr.IsKnownStream(name)
+	if err != nil {
+		return false, err
+	}
+
+	if !exists {
+		scfg := t.dflt
+		scfg.Subjects = subjects
+
+		_, err = t.s.dest.mgr.NewStreamFromDefault(name, scfg)
+		if err != nil {
+			return false, fmt.Errorf("could not create target stream %s: %v", name, err)
+		}
+
+		templateStreamsCount.WithLabelValues(t.s.cfg.Stream, t.s.sr.ReplicatorName, t.s.cfg.Name).Inc()
+		t.s.log.Infof("Created target stream %s for messages on %s", name, prefix)
+		t.known[prefix] = struct{}{}
+
+		return true, nil
+	}
+
+	stream, err := t.s.dest.mgr.LoadStream(name)
+	if err != nil {
+		return false, fmt.Errorf("could not load target stream %s: %v", name, err)
+	}
+
+	// streams selected by several subject prefixes, like when the name skips tokens, gain the subjects of new prefixes
+	var missing []string
+	for _, subj := range subjects {
+		if !containsString(stream.Subjects(), subj) {
+			missing = append(missing, subj)
+		}
+	}
+
+	if len(missing) > 0 {
+		ncfg := stream.Configuration()
+		ncfg.Subjects = append(ncfg.Subjects, missing...)
+
+		err = stream.UpdateConfiguration(ncfg)
+		if err != nil {
+			return false, fmt.Errorf("could not add subjects %s to target stream %s: %v", strings.Join(missing, ", "), name, err)
+		}
+
+		t.s.log.Infof("Added subjects %s to target stream %s", strings.Join(missing, ", "), name)
+	}
+
+	t.known[prefix] = struct{}{}
+
+	return true, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
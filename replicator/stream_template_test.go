@@ -0,0 +1,109 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Stream Templates", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	// for use with Eventually()
+	streamMessages := func(mgr *jsm.Manager, name string) func() (uint64, error) {
+		return func() (uint64, error) {
+			stream, err := mgr.LoadStream(name)
+			if err != nil {
+				return 0, err
+			}
+			nfo, err := stream.State()
+			if err != nil {
+				return 0, err
+			}
+			return nfo.Msgs, nil
+		}
+	}
+
+	It("Should create target streams per tenant", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("TENANTS", jsm.Subjects("tenants", "tenants.>"), jsm.MaxAge(time.Hour))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = mgr.NewStream("TENANT_initech", jsm.Subjects("copy.tenants.initech.>"))
+			Expect(err).ToNot(HaveOccurred())
+
+			for _, subj := range []string{"tenants.acme.orders", "tenants.acme.orders", "tenants.acme", "tenants.globex.orders", "tenants.initech.orders", "tenants.initech", "tenants"} {
+				_, err = nc.Request(subj, []byte("{}"), time.Second)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			cfg := &config.Config{
+				ReplicatorName: "GINKGO",
+				Streams: []*config.Stream{{
+					Name:           "TEMPLATE",
+					Stream:         "TENANTS",
+					TargetPrefix:   "copy",
+					SourceURL:      nc.ConnectedUrl(),
+					TargetURL:      nc.ConnectedUrl(),
+					StreamTemplate: &config.StreamTemplate{Name: "TENANT_{subject:3}"},
+				}},
+			}
+			Expect(cfg.Validate()).To(Succeed())
+
+			stream, err := NewStream(cfg.Streams[0], cfg, log)
+			Expect(err).ToNot(HaveOccurred())
+
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				Expect(stream.Run(ctx, &wg)).To(Succeed())
+			}()
+
+			Eventually(streamMessages(mgr, "TENANT_acme"), 5*time.Second).Should(Equal(uint64(3)))
+			Eventually(streamMessages(mgr, "TENANT_globex"), 5*time.Second).Should(Equal(uint64(1)))
+			Eventually(streamMessages(mgr, "TENANT_initech"), 5*time.Second).Should(Equal(uint64(2)))
+			Eventually(func() float64 {
+				return getPromCountValue(templateUnmatchedCount, "TENANTS", "GINKGO", "TEMPLATE")
+			}, 5*time.Second).Should(Equal(1.0))
+			Expect(getPromCountValue(templateStreamsCount, "TENANTS", "GINKGO", "TEMPLATE")).To(Equal(2.0))
+
+			acme, err := mgr.LoadStream("TENANT_acme")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(acme.Subjects()).To(Equal([]string{"copy.tenants.acme", "copy.tenants.acme.>"}))
+			Expect(acme.MaxAge()).To(Equal(time.Hour))
+
+			initech, err := mgr.LoadStream("TENANT_initech")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(initech.Subjects()).To(ConsistOf("copy.tenants.initech", "copy.tenants.initech.>"))
+		})
+	})
+})
@@ -0,0 +1,147 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/clock"
+)
+
+// SubjectStat is the replication activity of a single subject
+type SubjectStat struct {
+	// Subject is the subject messages were copied to
+	Subject string `json:"subject"`
+	// Messages is how many messages were copied
+	Messages uint64 `json:"messages"`
+	// Bytes is the size of the copied messages
+	Bytes uint64 `json:"bytes"`
+	// Overcount is how many of Messages, and their bytes, were inherited from a less active subject this one replaced
+	Overcount uint64 `json:"overcount,omitempty"`
+	// LastCopied is when the last message was copied
+	LastCopied time.Time `json:"last_copied"`
+}
+
+// SubjectStats are the most active subjects of a stream
+type SubjectStats struct {
+	// Stream is the source stream
+	Stream string `json:"stream"`
+	// Name is the name of the stream configuration
+	Name string `json:"name"`
+	// Limit is how many subjects are tracked
+	Limit int `json:"limit"`
+	// Tracked is how many subjects are currently tracked
+	Tracked int `json:"tracked"`
+	// Subjects are the tracked subjects, most copied bytes first
+	Subjects []*SubjectStat `json:"subjects"`
+}
+
+// subjectTracker tracks the most active subjects using the space saving algorithm, once limit subjects are tracked
+// a new subject replaces the least active one and inherits its counts, so busy subjects are never lost while the
+// counts of recently replaced ones can be too high by their Overcount
+type subjectTracker struct {
+	limit    int
+	clock    clock.Clock
+	subjects map[string]*SubjectStat
+	mu       sync.Mutex
+}
+
+func newSubjectTracker(limit int, clock clock.Clock) *subjectTracker {
+	return &subjectTracker{
+		limit:    limit,
+		clock:    clock,
+		subjects: make(map[string]*SubjectStat),
+	}
+}
+
+// record records that a message of size bytes was copied to subject, a nil tracker records nothing
+func (t *subjectTracker) record(subject string, size int) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stat, ok := t.subjects[subject]
+	if !ok {
+		stat = &SubjectStat{Subject: subject}
+
+		if len(t.subjects) >= t.limit {
+			least := t.leastActive()
+			delete(t.subjects, least.Subject)
+
+			stat.Messages = least.Messages
+			stat.Bytes = least.Bytes
+			stat.Overcount = least.Messages
+		}
+
+		t.subjects[subject] = stat
+	}
+
+	stat.Messages++
+	stat.Bytes += uint64(size)
+	stat.LastCopied = t.clock.Now().UTC()
+}
+
+// leastActive is the tracked subject with the fewest messages, must be called with the lock held
+func (t *subjectTracker) leastActive() *SubjectStat {
+	var least *SubjectStat
+	for _, stat := range t.subjects {
+		if least == nil || stat.Messages < least.Messages {
+			least = stat
+		}
+	}
+
+	return least
+}
+
+// top is a copy of the top most copied subjects by bytes, all when top is 0
+func (t *subjectTracker) top(top int) []*SubjectStat {
+	t.mu.Lock()
+	res := make([]*SubjectStat, 0, len(t.subjects))
+	for _, stat := range t.subjects {
+		c := *stat
+		res = append(res, &c)
+	}
+	t.mu.Unlock()
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Bytes == res[j].Bytes {
+			return res[i].Subject < res[j].Subject
+		}
+		return res[i].Bytes > res[j].Bytes
+	})
+
+	if top > 0 && len(res) > top {
+		res = res[:top]
+	}
+
+	return res
+}
+
+// SubjectStats reports the top most active subjects by copied bytes, all tracked subjects when top is 0, nil when
+// subject_stats is not configured
+func (s *Stream) SubjectStats(top int) *SubjectStats {
+	if s.subjects == nil {
+		return nil
+	}
+
+	stats := &SubjectStats{
+		Stream: s.cfg.Stream,
+		Name:   s.cfg.Name,
+		Limit:  s.subjects.limit,
+	}
+
+	stats.Subjects = s.subjects.top(top)
+
+	s.subjects.mu.Lock()
+	stats.Tracked = len(s.subjects.subjects)
+	s.subjects.mu.Unlock()
+
+	return stats
+}
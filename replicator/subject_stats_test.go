@@ -0,0 +1,128 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/clock"
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Subject Stats", func() {
+	var (
+		start = time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)
+		clk   *clock.Mock
+	)
+
+	BeforeEach(func() {
+		clk = clock.NewMock(start)
+	})
+
+	Describe("subjectTracker", func() {
+		It("Should count messages and bytes per subject", func() {
+			t := newSubjectTracker(10, clk)
+			t.record("orders.new", 10)
+			t.record("orders.new", 20)
+			clk.Add(time.Minute)
+			t.record("orders.cancel", 100)
+
+			top := t.top(0)
+			Expect(top).To(HaveLen(2))
+			Expect(top[0]).To(Equal(&SubjectStat{Subject: "orders.cancel", Messages: 1, Bytes: 100, LastCopied: start.Add(time.Minute)}))
+			Expect(top[1]).To(Equal(&SubjectStat{Subject: "orders.new", Messages: 2, Bytes: 30, LastCopied: start}))
+
+			Expect(t.top(1)).To(HaveLen(1))
+		})
+
+		It("Should replace the least active subject once the limit is reached", func() {
+			t := newSubjectTracker(2, clk)
+			for i := 0; i < 5; i++ {
+				t.record("busy", 10)
+			}
+			t.record("quiet", 10)
+			t.record("new", 10)
+
+			top := t.top(0)
+			Expect(top).To(HaveLen(2))
+			Expect(top[0]).To(Equal(&SubjectStat{Subject: "busy", Messages: 5, Bytes: 50, LastCopied: start}))
+			Expect(top[1]).To(Equal(&SubjectStat{Subject: "new", Messages: 2, Bytes: 20, Overcount: 1, LastCopied: start}))
+		})
+
+		It("Should do nothing when not configured", func() {
+			var t *subjectTracker
+			t.record("orders.new", 10)
+		})
+	})
+
+	It("Should track copied subjects", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log := logrus.NewEntry(logger)
+
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("TEST", jsm.Subjects("TEST.>"))
+			Expect(err).ToNot(HaveOccurred())
+
+			for i := 0; i < 3; i++ {
+				_, err = nc.Request("TEST.orders", []byte("xxxx"), time.Second)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			_, err = nc.Request("TEST.other", []byte("x"), time.Second)
+			Expect(err).ToNot(HaveOccurred())
+
+			scfg := &config.Stream{
+				Stream:       "TEST",
+				TargetStream: "COPY",
+				TargetPrefix: "copy",
+				SourceURL:    nc.ConnectedUrl(),
+				TargetURL:    nc.ConnectedUrl(),
+				SubjectStats: &config.SubjectStats{},
+			}
+			cfg := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+			Expect(cfg.Validate()).To(Succeed())
+
+			stream, err := NewStream(scfg, cfg, log)
+			Expect(err).ToNot(HaveOccurred())
+
+			wg := &sync.WaitGroup{}
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				Expect(stream.Run(ctx, wg)).To(Succeed())
+			}()
+
+			Eventually(func() int {
+				return len(stream.SubjectStats(0).Subjects)
+			}, 5*time.Second).Should(Equal(2))
+			Eventually(func() uint64 {
+				return stream.SubjectStats(0).Subjects[0].Messages
+			}, 5*time.Second).Should(Equal(uint64(3)))
+
+			stats := stream.SubjectStats(1)
+			Expect(stats.Stream).To(Equal("TEST"))
+			Expect(stats.Limit).To(Equal(100))
+			Expect(stats.Tracked).To(Equal(2))
+			Expect(stats.Subjects).To(HaveLen(1))
+			Expect(stats.Subjects[0].Subject).To(Equal("copy.TEST.orders"))
+			Expect(stats.Subjects[0].Bytes).To(Equal(uint64(12)))
+
+			cancel()
+			wg.Wait()
+		})
+	})
+})
@@ -0,0 +1,193 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/util"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+// syslogStructuredDataID identifies the structured data holding the message position, 32473 is the private enterprise
+// number reserved for documentation
+const syslogStructuredDataID = "nats@32473"
+
+// syslogSink forwards messages as RFC5424 syslog messages over a TCP or TLS connection
+type syslogSink struct {
+	cfg      *config.Syslog
+	dial     func(context.Context, string, string) (net.Conn, error)
+	conn     net.Conn
+	hostname string
+	stream   string
+	mu       sync.Mutex
+	log      *logrus.Entry
+}
+
+func (s *Stream) connectSyslog() error {
+	scfg := s.cfg.TargetSyslog
+
+	dial := (&net.Dialer{Timeout: scfg.Timeout}).DialContext
+	if s.cfg.TargetProxy != _EMPTY_ {
+		var err error
+		dial, err = util.ProxyDialer(s.cfg.TargetProxy, scfg.Timeout)
+		if err != nil {
+			return fmt.Errorf("syslog target setup failed: %v", err)
+		}
+	}
+
+	if scfg.TLS != nil {
+		tlsc, err := util.ClientTLSConfig(scfg.TLS.CA, scfg.TLS.Cert, scfg.TLS.Key)
+		if err != nil {
+			return fmt.Errorf("syslog target tls: %v", err)
+		}
+		dial = util.TLSDialer(dial, tlsc)
+	}
+
+	hostname := scfg.Hostname
+	if hostname == _EMPTY_ {
+		hostname, _ = os.Hostname()
+	}
+	if hostname == _EMPTY_ {
+		hostname = "-"
+	}
+
+	log := s.log.WithField("connection", "syslog")
+	log.Infof("Forwarding messages to %s", scfg.Address)
+
+	s.batchSink = &syslogSink{cfg: scfg, dial: dial, hostname: hostname, stream: s.cfg.Stream, log: log}
+	s.delivery = &scfg.Delivery
+
+	return nil
+}
+
+// deliver writes msgs to the server in one write, connecting when not connected and disconnecting on failure so
+// the next try reconnects
+func (k *syslogSink) deliver(ctx context.Context, msgs []*nats.Msg) error {
+	var buf bytes.Buffer
+	for _, msg := range msgs {
+		line := k.format(msg)
+
+		if k.cfg.Framing == config.SyslogOctetCounting {
+			buf.WriteString(strconv.Itoa(len(line)))
+			buf.WriteByte(' ')
+			buf.Write(line)
+		} else {
+			buf.Write(bytes.ReplaceAll(line, []byte("\n"), []byte(" ")))
+			buf.WriteByte('\n')
+		}
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.conn != nil && !connAlive(k.conn) {
+		k.log.Warnf("Connection to %s was closed, reconnecting", k.cfg.Address)
+		k.conn.Close()
+		k.conn = nil
+	}
+
+	if k.conn == nil {
+		tctx, cancel := context.WithTimeout(ctx, k.cfg.Timeout)
+		defer cancel()
+
+		conn, err := k.dial(tctx, "tcp", k.cfg.Address)
+		if err != nil {
+			return err
+		}
+		k.log.Infof("Connected to %s", k.cfg.Address)
+		k.conn = conn
+	}
+
+	k.conn.SetWriteDeadline(time.Now().Add(k.cfg.Timeout))
+	_, err := k.conn.Write(buf.Bytes())
+	if err != nil {
+		k.conn.Close()
+		k.conn = nil
+		return err
+	}
+
+	return nil
+}
+
+// connAlive detects connections closed by the server, syslog servers do not send data so any read that does not
+// time out means the connection is unusable
+func connAlive(conn net.Conn) bool {
+	conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	defer conn.SetReadDeadline(time.Time{})
+
+	var b [1]byte
+	_, err := conn.Read(b[:])
+	nerr, ok := err.(net.Error)
+
+	return ok && nerr.Timeout()
+}
+
+// format is msg as a RFC5424 syslog message, the subject and stream sequence are in the structured data
+func (k *syslogSink) format(msg *nats.Msg) []byte {
+	severity := k.cfg.SeverityCode
+	if k.cfg.SeverityHeader != _EMPTY_ {
+		hs := msg.Header.Get(k.cfg.SeverityHeader)
+		if hs != _EMPTY_ {
+			code, ok := config.SyslogSeverity(hs)
+			if ok {
+				severity = code
+			}
+		}
+	}
+
+	ts := time.Now()
+	seq := "0"
+	meta, err := jsm.ParseJSMsgMetadata(msg)
+	if err == nil {
+		ts = meta.TimeStamp()
+		seq = strconv.FormatUint(meta.StreamSequence(), 10)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<%d>1 %s %s %s - - [%s stream=\"%s\" subject=\"%s\" sequence=\"%s\"] ",
+		k.cfg.FacilityCode*8+severity,
+		ts.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		k.hostname,
+		k.cfg.AppName,
+		syslogStructuredDataID,
+		escapeSyslogParam(k.stream),
+		escapeSyslogParam(msg.Subject),
+		seq,
+	)
+	buf.Write(msg.Data)
+
+	return buf.Bytes()
+}
+
+// escapeSyslogParam escapes characters not allowed in structured data parameter values
+func escapeSyslogParam(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(v)
+}
+
+func (k *syslogSink) close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.conn == nil {
+		return nil
+	}
+
+	err := k.conn.Close()
+	k.conn = nil
+
+	return err
+}
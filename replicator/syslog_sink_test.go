@@ -0,0 +1,206 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Syslog Sink", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     = sync.WaitGroup{}
+		log    *logrus.Entry
+		mu     sync.Mutex
+		lines  []string
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		lines = nil
+
+		DeferCleanup(func() {
+			cancel()
+			wg.Wait()
+		})
+	})
+
+	// serve accepts connections on l and reads messages using the framing, connections are closed after reading a
+	// message when drop is set
+	serve := func(l net.Listener, framing string, drop bool) {
+		DeferCleanup(l.Close)
+
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+
+				go func(conn net.Conn, drop bool) {
+					defer conn.Close()
+
+					r := bufio.NewReader(conn)
+					for {
+						var line string
+						if framing == config.SyslogOctetCounting {
+							length, err := r.ReadString(' ')
+							if err != nil {
+								return
+							}
+							size, err := strconv.Atoi(strings.TrimSpace(length))
+							if err != nil {
+								return
+							}
+							buf := make([]byte, size)
+							_, err = io.ReadFull(r, buf)
+							if err != nil {
+								return
+							}
+							line = string(buf)
+						} else {
+							line, err = r.ReadString('\n')
+							if err != nil {
+								return
+							}
+							line = strings.TrimSuffix(line, "\n")
+						}
+
+						mu.Lock()
+						lines = append(lines, line)
+						mu.Unlock()
+
+						if drop {
+							return
+						}
+					}
+				}(conn, drop)
+			}
+		}()
+	}
+
+	run := func(nc *nats.Conn, mgr *jsm.Manager, scfg *config.Syslog) {
+		_, err := mgr.NewStream("AUDIT", jsm.Subjects("AUDIT.>"))
+		Expect(err).ToNot(HaveOccurred())
+
+		for i := 1; i <= 3; i++ {
+			msg := nats.NewMsg(fmt.Sprintf("AUDIT.%d", i))
+			msg.Data = []byte(fmt.Sprintf("user %d logged in\nfrom 192.0.2.%d", i, i))
+			if i == 2 {
+				msg.Header.Add("Severity", "warning")
+			}
+			_, err := nc.RequestMsg(msg, time.Second)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		stream := &config.Stream{Stream: "AUDIT", SourceURL: nc.ConnectedUrl(), TargetSyslog: scfg}
+		cfg := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{stream}}
+		Expect(cfg.Validate()).To(Succeed())
+
+		s, err := NewStream(stream, cfg, log)
+		Expect(err).ToNot(HaveOccurred())
+
+		wg.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			Expect(s.Run(ctx, &wg)).ToNot(HaveOccurred())
+		}()
+	}
+
+	received := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, lines...)
+	}
+
+	It("Should forward RFC5424 messages using octet counting", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			serve(l, config.SyslogOctetCounting, false)
+
+			run(nc, mgr, &config.Syslog{Address: l.Addr().String(), Hostname: "ginkgo", Facility: "auth", SeverityHeader: "Severity"})
+
+			Eventually(received, "10s").Should(HaveLen(3))
+
+			msgs := received()
+			Expect(msgs[0]).To(MatchRegexp(`^<38>1 \d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z ginkgo stream-replicator - - \[nats@32473 stream="AUDIT" subject="AUDIT\.1" sequence="1"\] user 1 logged in\nfrom 192\.0\.2\.1$`))
+			Expect(msgs[1]).To(HavePrefix("<36>1 "))
+			Expect(msgs[1]).To(HaveSuffix(`[nats@32473 stream="AUDIT" subject="AUDIT.2" sequence="2"] user 2 logged in` + "\nfrom 192.0.2.2"))
+		})
+	})
+
+	It("Should forward messages over TLS using non transparent framing", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			srv := httptest.NewTLSServer(http.NotFoundHandler())
+			defer srv.Close()
+
+			ca := filepath.Join(GinkgoT().TempDir(), "ca.pem")
+			Expect(os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600)).To(Succeed())
+
+			l, err := tls.Listen("tcp", "127.0.0.1:0", srv.TLS)
+			Expect(err).ToNot(HaveOccurred())
+			serve(l, config.SyslogNonTransparent, false)
+
+			run(nc, mgr, &config.Syslog{
+				Address:  l.Addr().String(),
+				TLS:      &config.TLS{CA: ca},
+				Framing:  config.SyslogNonTransparent,
+				Severity: "notice",
+				Delivery: config.Delivery{Batch: 3, FlushIntervalString: "200ms"},
+			})
+
+			Eventually(received, "10s").Should(HaveLen(3))
+			Expect(received()[2]).To(HavePrefix("<133>1 "))
+			Expect(received()[2]).To(HaveSuffix(`sequence="3"] user 3 logged in from 192.0.2.3`))
+		})
+	})
+
+	It("Should reconnect after the server closed the connection", func() {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		serve(l, config.SyslogNonTransparent, true)
+
+		scfg := &config.Syslog{Address: l.Addr().String(), Framing: config.SyslogNonTransparent, AppName: "ginkgo", Timeout: time.Second}
+
+		sink := &syslogSink{cfg: scfg, dial: (&net.Dialer{}).DialContext, hostname: "ginkgo", stream: "AUDIT", log: log}
+		defer sink.close()
+
+		for i := 1; i <= 3; i++ {
+			Expect(sink.deliver(ctx, []*nats.Msg{{Subject: fmt.Sprintf("AUDIT.%d", i), Data: []byte("logged in")}})).To(Succeed())
+			Eventually(received, "2s").Should(HaveLen(i))
+			// allows the close by the server to be seen
+			time.Sleep(50 * time.Millisecond)
+		}
+	})
+})
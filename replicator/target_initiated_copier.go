@@ -15,7 +15,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/choria-io/stream-replicator/backoff"
 	"github.com/choria-io/stream-replicator/config"
 	"github.com/nats-io/jsm.go"
 	"github.com/nats-io/jsm.go/api"
@@ -86,8 +85,13 @@ func (c *targetInitiatedCopier) copyMessages(ctx context.Context) error {
 
 	c.log.Infof("Starting Target-initiated data copier for %s", c.cfg.Stream)
 
+	liveness := time.NewTicker(livenessInterval)
+
 	for {
 		select {
+		case <-liveness.C:
+			c.s.markActive()
+
 		case msg := <-c.msgs:
 			if c.s.isPaused() {
 				continue
@@ -99,6 +103,7 @@ func (c *targetInitiatedCopier) copyMessages(ctx context.Context) error {
 			_, err := c.handler(ctx, msg)
 			if err != nil {
 				handlerErrorCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
+				c.s.reportError(err)
 				c.log.Errorf("Handling message failed: %v", err)
 				continue
 			}
@@ -149,6 +154,7 @@ func (c *targetInitiatedCopier) copyMessages(ctx context.Context) error {
 
 		case <-ctx.Done():
 			c.health.Stop()
+			liveness.Stop()
 
 			c.log.Warnf("Copier shutting down after context interrupt")
 			return nil
@@ -192,6 +198,8 @@ func (c *targetInitiatedCopier) handler(ctx context.Context, msg *nats.Msg) (*js
 	}
 
 	streamSequence.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Set(float64(meta.StreamSequence()))
+	pendingMessages.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Set(float64(meta.Pending()))
+	messageAge.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Observe(time.Since(meta.TimeStamp()).Seconds())
 
 	rseq := c.getSourceResumeSeq()
 
@@ -212,27 +220,41 @@ func (c *targetInitiatedCopier) handler(ctx context.Context, msg *nats.Msg) (*js
 		}
 	}
 
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+
+	c.s.mapJetStreamHeaders(msg)
+	if !c.s.verifyMessage(msg) || !c.s.transformMessage(msg) {
+		c.setSourceResumeSeq(meta.StreamSequence() + 1)
+		c.setLastConsumerSeq(meta.ConsumerSequence())
+		return meta, nil
+	}
+	verifyFailed := msg.Header.Get(VerifyFailedHeader)
+
 	msg.Header = nats.Header{}
-	msg.Header.Add(srcHeader, fmt.Sprintf(srcHeaderPattern, c.cfg.Stream, meta.StreamSequence(), c.sr.ReplicatorName, c.cfg.Name, meta.TimeStamp().UnixMilli()))
-	msg.Subject = c.s.targetForSubject(msg.Subject)
+	msg.Header.Add(srcHeader, srcHeaderValue(c.cfg.Stream, int64(meta.StreamSequence()), c.sr.ReplicatorName, c.cfg.Name, meta.TimeStamp().UnixMilli()))
+	if verifyFailed != "" {
+		msg.Header.Set(VerifyFailedHeader, verifyFailed)
+	}
+	msg.Subject = c.s.TargetForSubject(msg.Subject)
+
+	err = c.s.prepareMessage(msg)
+	if err != nil {
+		return nil, fmt.Errorf("signing message failed: %v", err)
+	}
 
 	// we are about to try 5 times, if there isnt a msgid lets add one to avoid dupes
 	if msg.Header.Get(api.JSMsgId) == "" {
 		msg.Header.Add(api.JSMsgId, ksuid.New().String())
 	}
 
-	err = backoff.Default.For(ctx, func(try int) error {
+	err = c.s.retries.For(ctx, func(try int) error {
 		if try == 6 {
 			return fmt.Errorf("maximum attempts reached")
 		}
 
-		resp, err := c.dest.nc.RequestMsg(msg, 2*time.Second)
-		if err != nil {
-			c.log.Errorf("Could not store message to target stream: %v", err)
-			return err
-		}
-
-		err = jsm.ParseErrorResponse(resp)
+		err := c.s.sink.publish(ctx, msg)
 		if err != nil {
 			c.log.Errorf("Could not store message to target stream: %v", err)
 			return err
@@ -260,12 +282,14 @@ func (c *targetInitiatedCopier) handler(ctx context.Context, msg *nats.Msg) (*js
 
 	copiedMessageCount.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Inc()
 	copiedMessageSize.WithLabelValues(c.cfg.Stream, c.sr.ReplicatorName, c.cfg.Name).Add(float64(len(msg.Data)))
+	c.s.subjects.record(msg.Subject, len(msg.Data))
+	c.s.messageCopied(msg)
 
 	return meta, nil
 }
 
 func (c *targetInitiatedCopier) getStartSequence() (uint64, time.Time, error) {
-	msg, err := c.dest.stream.ReadLastMessageForSubject(c.s.targetForSubject(c.cfg.FilterSubject))
+	msg, err := c.dest.stream.ReadLastMessageForSubject(c.s.TargetForSubject(c.cfg.FilterSubject))
 	if err != nil {
 		// no message found means we start fresh check if a purge was done and if it
 		// was we continue from the purge time, else start fresh
@@ -327,7 +351,13 @@ func (c *targetInitiatedCopier) healthCheckSource() (bool, error) {
 		}
 		c.source.resumeSeq = rSeq
 		c.source.resumeTime = rTs
-		return c.recreateEphemeraLocked()
+
+		fixed, err := c.recreateEphemeraLocked()
+		if err == nil {
+			c.reportStartup(rSeq, rTs)
+		}
+
+		return fixed, err
 	}
 
 	c.source.consumer, err = c.source.stream.LoadConsumer(c.source.consumer.Name())
@@ -363,7 +393,7 @@ func (c *targetInitiatedCopier) recreateEphemeraLocked() (bool, error) {
 	}
 
 	opts := []jsm.ConsumerOption{
-		jsm.ConsumerDescription(fmt.Sprintf("Choria Stream Replicator %s", c.cfg.Name)),
+		jsm.ConsumerDescription(fmt.Sprintf("%s %s", ConsumerDescriptionPrefix, c.cfg.Name)),
 		jsm.AcknowledgeNone(),
 		jsm.DeliverySubject(c.source.sub.Subject),
 		jsm.PushFlowControl(),
@@ -405,6 +435,32 @@ func (c *targetInitiatedCopier) recreateEphemeraLocked() (bool, error) {
 	return true, err
 }
 
+// reportStartup reports the start sequence or time found in the target and where the ephemeral consumer starts
+func (c *targetInitiatedCopier) reportStartup(seq uint64, ts time.Time) {
+	// the start sequence follows the last message in the target, without one the target holds no copied message
+	report := &startupReport{target: "0"}
+
+	switch {
+	case seq > 0:
+		report.target = strconv.FormatUint(seq-1, 10)
+		report.decision = fmt.Sprintf("creating an ephemeral consumer starting at sequence %d after the last message in the target", seq)
+	case !ts.IsZero():
+		report.decision = fmt.Sprintf("creating an ephemeral consumer starting with messages newer than the target purge at %s", ts.UTC().Format(time.RFC3339))
+	case c.cfg.StartAtEnd:
+		report.decision = "creating an ephemeral consumer starting with the next message received"
+	case c.cfg.StartSequence > 0:
+		report.decision = fmt.Sprintf("creating an ephemeral consumer starting at sequence %d", c.cfg.StartSequence)
+	case c.cfg.StartDelta > 0:
+		report.decision = fmt.Sprintf("creating an ephemeral consumer starting with messages newer than %v", c.cfg.StartDelta)
+	case !c.cfg.StartTime.IsZero():
+		report.decision = fmt.Sprintf("creating an ephemeral consumer starting with messages newer than %s", c.cfg.StartTime.UTC().Format(time.RFC3339))
+	default:
+		report.decision = "creating an ephemeral consumer starting at the first message"
+	}
+
+	c.s.reportStartup(report)
+}
+
 // copied from nats.go
 const (
 	hdrLine   = "NATS/1.0\r\n"
@@ -301,6 +301,13 @@ var _ = Describe("Target Initiated Copier", func() {
 
 				Expect(stream.copier).To(BeAssignableToTypeOf(&targetInitiatedCopier{}))
 
+				names, err := mgr.ConsumerNames("TEST")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(names).To(HaveLen(1))
+				consumer, err := mgr.LoadConsumer("TEST", names[0])
+				Expect(err).ToNot(HaveOccurred())
+				Expect(consumer.Description()).To(Equal(ConsumerDescriptionPrefix + " TR"))
+
 				// check prefix and remove string works
 				msg, err := tcs.ReadMessage(1)
 				Expect(err).ToNot(HaveOccurred())
@@ -0,0 +1,85 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/jsm.go/api"
+)
+
+// targetResumeSequence is the source sequence following the last message this stream copied to the target, 0 when the
+// target holds no message copied by this stream to resume after
+func (s *Stream) targetResumeSequence() (uint64, error) {
+	seq, reason, err := s.targetSourceSequence()
+	if err != nil {
+		return 0, err
+	}
+	if reason != _EMPTY_ {
+		s.log.Warnf("%s, not resuming from the target", reason)
+	}
+	if seq == 0 {
+		return 0, nil
+	}
+
+	return seq + 1, nil
+}
+
+// targetSourceSequence is the source sequence of the last message this stream copied to the target, 0 when the target
+// holds none with reason describing why when its last message can not be used
+func (s *Stream) targetSourceSequence() (seq uint64, reason string, err error) {
+	s.dest.mu.Lock()
+	target := s.dest.stream
+	s.dest.mu.Unlock()
+
+	if target == nil {
+		target, err = s.dest.mgr.LoadStream(s.cfg.TargetStream)
+		if err != nil {
+			return 0, _EMPTY_, fmt.Errorf("could not load target stream %s: %v", s.cfg.TargetStream, err)
+		}
+	}
+
+	var msg *api.StoredMsg
+	if s.cfg.FilterSubject != _EMPTY_ {
+		msg, err = target.ReadLastMessageForSubject(s.TargetForSubject(s.cfg.FilterSubject))
+	} else {
+		var nfo api.StreamState
+		nfo, err = target.State()
+		if err != nil {
+			return 0, _EMPTY_, fmt.Errorf("could not load target stream state: %v", err)
+		}
+		if nfo.Msgs == 0 {
+			return 0, _EMPTY_, nil
+		}
+		msg, err = target.ReadMessage(nfo.LastSeq)
+	}
+	if jsm.IsNatsError(err, 10037) {
+		return 0, _EMPTY_, nil
+	}
+	if err != nil {
+		return 0, _EMPTY_, fmt.Errorf("could not read the last target message: %v", err)
+	}
+
+	hdrs, err := decodeHeadersMsg(msg.Header)
+	if err != nil {
+		return 0, _EMPTY_, err
+	}
+
+	// stream, sequence, replicator, name and time
+	parts := strings.Split(hdrs.Get(srcHeader), " ")
+	if len(parts) != 5 || parts[0] != s.cfg.Stream || parts[3] != s.cfg.Name {
+		return 0, fmt.Sprintf("Last message %d in target stream %s was not copied by this stream", msg.Sequence, s.cfg.TargetStream), nil
+	}
+
+	src, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || src < 1 {
+		return 0, fmt.Sprintf("Last message %d in target stream %s has no source sequence", msg.Sequence, s.cfg.TargetStream), nil
+	}
+
+	return uint64(src), _EMPTY_, nil
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Start From Target", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		log    *logrus.Entry
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+
+		DeferCleanup(cancel)
+	})
+
+	publish := func(nc *nats.Conn, count int) {
+		for i := 0; i < count; i++ {
+			_, err := nc.Request("TEST", []byte("x"), time.Second)
+			Expect(err).ToNot(HaveOccurred())
+		}
+	}
+
+	// copies TEST to COPY once as name, starting from the target when fromTarget is set
+	copyOnce := func(nc *nats.Conn, name string, fromTarget bool) *OnceSummary {
+		scfg := &config.Stream{
+			Name:            name,
+			Stream:          "TEST",
+			TargetStream:    "COPY",
+			TargetPrefix:    "copy",
+			SourceURL:       nc.ConnectedUrl(),
+			TargetURL:       nc.ConnectedUrl(),
+			StartFromTarget: fromTarget,
+		}
+		cfg := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+		Expect(cfg.Validate()).To(Succeed())
+
+		stream, err := NewStream(scfg, cfg, log)
+		Expect(err).ToNot(HaveOccurred())
+
+		summary, err := stream.RunOnce(ctx)
+		Expect(err).ToNot(HaveOccurred())
+
+		return summary
+	}
+
+	removeConsumers := func(mgr *jsm.Manager) {
+		stream, err := mgr.LoadStream("TEST")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stream.EachConsumer(func(c *jsm.Consumer) { Expect(c.Delete()).To(Succeed()) })).To(Succeed())
+	}
+
+	targetMessages := func(mgr *jsm.Manager) uint64 {
+		target, err := mgr.LoadStream("COPY")
+		Expect(err).ToNot(HaveOccurred())
+		nfo, err := target.State()
+		Expect(err).ToNot(HaveOccurred())
+		return nfo.Msgs
+	}
+
+	It("Should continue after the last message copied to the target", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("TEST")
+			Expect(err).ToNot(HaveOccurred())
+			publish(nc, 10)
+
+			Expect(copyOnce(nc, "EAST", true).Copied).To(Equal(int64(10)))
+
+			removeConsumers(mgr)
+			publish(nc, 5)
+
+			summary := copyOnce(nc, "EAST", true)
+			Expect(summary.Copied).To(Equal(int64(5)))
+			Expect(summary.SourceSequence).To(Equal(uint64(15)))
+			Expect(targetMessages(mgr)).To(Equal(uint64(15)))
+		})
+	})
+
+	It("Should use the start options when the target holds messages copied by another stream", func() {
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("TEST")
+			Expect(err).ToNot(HaveOccurred())
+			publish(nc, 10)
+
+			Expect(copyOnce(nc, "EAST", false).Copied).To(Equal(int64(10)))
+
+			removeConsumers(mgr)
+
+			Expect(copyOnce(nc, "WEST", true).Copied).To(Equal(int64(10)))
+			Expect(targetMessages(mgr)).To(Equal(uint64(20)))
+		})
+	})
+})
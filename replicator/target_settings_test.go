@@ -0,0 +1,106 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/testutil"
+	"github.com/nats-io/jsm.go"
+	"github.com/nats-io/jsm.go/api"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Target Settings", func() {
+	var log *logrus.Entry
+
+	BeforeEach(func() {
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+	})
+
+	It("Should override the source settings", func() {
+		scfg := &config.Stream{Stream: "TEST", SourceURL: "nats://localhost:4222", TargetURL: "nats://localhost:4222", TargetSettings: &config.TargetSettings{Replicas: 3, Cluster: "dr", Tags: []string{"ssd"}, Storage: "memory", MaxAge: time.Hour}}
+		stream, err := NewStream(scfg, &config.Config{ReplicatorName: "GINKGO"}, log)
+		Expect(err).ToNot(HaveOccurred())
+
+		tcfg := stream.withTargetSettings(api.StreamConfig{Replicas: 1, Storage: api.FileStorage, MaxAge: 24 * time.Hour, Duplicates: 2 * time.Hour, MaxMsgs: 10})
+		Expect(tcfg).To(Equal(api.StreamConfig{
+			Replicas:   3,
+			Placement:  &api.Placement{Cluster: "dr", Tags: []string{"ssd"}},
+			Storage:    api.MemoryStorage,
+			MaxAge:     time.Hour,
+			Duplicates: time.Hour,
+			MaxMsgs:    10,
+		}))
+
+		scfg.TargetSettings = &config.TargetSettings{}
+		Expect(stream.withTargetSettings(api.StreamConfig{Replicas: 1, MaxAge: time.Hour})).To(Equal(api.StreamConfig{Replicas: 1, MaxAge: time.Hour}))
+	})
+
+	It("Should create the target stream using the settings", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		testutil.WithJetStream(log, func(_ *server.Server, nc *nats.Conn, mgr *jsm.Manager) {
+			_, err := mgr.NewStream("TEST", jsm.MaxAge(24*time.Hour), jsm.DuplicateWindow(2*time.Hour))
+			Expect(err).ToNot(HaveOccurred())
+
+			for i := 0; i < 10; i++ {
+				_, err = nc.Request("TEST", []byte("x"), time.Second)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			scfg := &config.Stream{
+				Stream:         "TEST",
+				TargetStream:   "COPY",
+				TargetPrefix:   "copy",
+				SourceURL:      nc.ConnectedUrl(),
+				TargetURL:      nc.ConnectedUrl(),
+				TargetSettings: &config.TargetSettings{Storage: "memory", MaxAgeString: "1h"},
+			}
+			cfg := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+			Expect(cfg.Validate()).To(Succeed())
+
+			stream, err := NewStream(scfg, cfg, log)
+			Expect(err).ToNot(HaveOccurred())
+
+			wg := &sync.WaitGroup{}
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				Expect(stream.Run(ctx, wg)).To(Succeed())
+			}()
+
+			Eventually(func() (uint64, error) {
+				target, err := mgr.LoadStream("COPY")
+				if err != nil {
+					return 0, err
+				}
+				nfo, err := target.State()
+				return nfo.Msgs, err
+			}, 5*time.Second).Should(Equal(uint64(10)))
+
+			target, err := mgr.LoadStream("COPY")
+			Expect(err).ToNot(HaveOccurred())
+			tcfg := target.Configuration()
+			Expect(tcfg.Storage).To(Equal(api.MemoryStorage))
+			Expect(tcfg.MaxAge).To(Equal(time.Hour))
+			Expect(tcfg.Duplicates).To(Equal(time.Hour))
+			Expect(tcfg.Subjects).To(Equal([]string{"copy.TEST"}))
+
+			cancel()
+			wg.Wait()
+		})
+	})
+})
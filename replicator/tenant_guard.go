@@ -0,0 +1,53 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"fmt"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/nats-io/nats.go"
+)
+
+// TenantViolationHeader is added to messages that failed tenant verification in flag mode
+const TenantViolationHeader = "Choria-SR-Tenant-Violation"
+
+// verifyTenant checks that msg belongs to an accepted tenant when a tenant guard is configured, returns false when msg
+// should not be copied
+func (s *Stream) verifyTenant(msg *nats.Msg) bool {
+	guard := s.cfg.TenantGuard
+	if guard == nil {
+		return true
+	}
+
+	var tenant string
+	if msg.Header != nil {
+		tenant = msg.Header.Get(guard.Header)
+	}
+
+	if guard.Accepts(msg.Subject, tenant) {
+		return true
+	}
+
+	tenantViolationCount.WithLabelValues(s.cfg.Stream, s.sr.ReplicatorName, s.cfg.Name).Inc()
+
+	reason := fmt.Sprintf("unexpected tenant %q", tenant)
+	if tenant == "" {
+		reason = fmt.Sprintf("no %s header", guard.Header)
+	}
+
+	if guard.Mode == config.VerifyFlag {
+		s.log.Debugf("Flagging message on %s that failed tenant verification: %s", msg.Subject, reason)
+		if msg.Header == nil {
+			msg.Header = nats.Header{}
+		}
+		msg.Header.Set(TenantViolationHeader, reason)
+		return true
+	}
+
+	s.log.Warnf("Dropping message on %s that failed tenant verification: %s", msg.Subject, reason)
+
+	return false
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Tenant Guard", func() {
+	var log *logrus.Entry
+
+	BeforeEach(func() {
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+		log = logrus.NewEntry(logger)
+	})
+
+	newStream := func(mode string) *Stream {
+		scfg := &config.Stream{
+			Name:        "TENANT_" + mode,
+			Stream:      "TEST",
+			SourceURL:   "nats://localhost:4222",
+			TargetURL:   "nats://localhost:4222",
+			TenantGuard: &config.TenantGuard{Header: "Tenant", Values: []string{"{subject:2}"}, Mode: mode},
+		}
+
+		stream, err := NewStream(scfg, &config.Config{ReplicatorName: "GINKGO"}, log)
+		Expect(err).ToNot(HaveOccurred())
+
+		return stream
+	}
+
+	message := func(subject string, tenant string) *nats.Msg {
+		msg := nats.NewMsg(subject)
+		if tenant != "" {
+			msg.Header.Set("Tenant", tenant)
+		}
+
+		return msg
+	}
+
+	It("Should drop messages of other tenants", func() {
+		stream := newStream(config.VerifyDrop)
+
+		Expect(stream.verifyMessage(message("tenants.acme.orders", "acme"))).To(BeTrue())
+		Expect(stream.verifyMessage(message("tenants.acme.orders", "initech"))).To(BeFalse())
+		Expect(stream.verifyMessage(message("tenants.acme.orders", ""))).To(BeFalse())
+		Expect(stream.verifyMessage(&nats.Msg{Subject: "tenants.acme.orders"})).To(BeFalse())
+		Expect(getPromCountValue(tenantViolationCount, "TEST", "GINKGO", "TENANT_drop")).To(Equal(3.0))
+	})
+
+	It("Should flag messages of other tenants", func() {
+		stream := newStream(config.VerifyFlag)
+
+		msg := message("tenants.acme.orders", "acme")
+		Expect(stream.verifyMessage(msg)).To(BeTrue())
+		Expect(msg.Header.Get(TenantViolationHeader)).To(BeEmpty())
+
+		msg = message("tenants.acme.orders", "initech")
+		Expect(stream.verifyMessage(msg)).To(BeTrue())
+		Expect(msg.Header.Get(TenantViolationHeader)).To(Equal(`unexpected tenant "initech"`))
+
+		msg = &nats.Msg{Subject: "tenants.acme.orders"}
+		Expect(stream.verifyMessage(msg)).To(BeTrue())
+		Expect(msg.Header.Get(TenantViolationHeader)).To(Equal("no Tenant header"))
+		Expect(getPromCountValue(tenantViolationCount, "TEST", "GINKGO", "TENANT_flag")).To(Equal(2.0))
+	})
+})
@@ -0,0 +1,111 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// transformProgressInterval is how often messages waiting for transforms are marked as in progress, well below
+// the ack wait of consumers so slow transforms do not cause redeliveries
+const transformProgressInterval = 5 * time.Second
+
+// transformPool applies transforms using a bounded number of workers, callers wait for the transforms of their
+// messages while the messages are kept from being redelivered
+type transformPool struct {
+	s    *Stream
+	jobs chan *transformJob
+	wg   sync.WaitGroup
+}
+
+type transformJob struct {
+	msg  *nats.Msg
+	keep bool
+	done *sync.WaitGroup
+}
+
+func newTransformPool(s *Stream) *transformPool {
+	return &transformPool{
+		s:    s,
+		jobs: make(chan *transformJob, s.cfg.Transform.Workers),
+	}
+}
+
+// start starts the workers, they stop once ctx is done
+func (p *transformPool) start(ctx context.Context) {
+	p.s.log.Infof("Transforming messages using %d workers", p.s.cfg.Transform.Workers)
+
+	for i := 0; i < p.s.cfg.Transform.Workers; i++ {
+		p.wg.Add(1)
+		go p.worker(ctx)
+	}
+}
+
+func (p *transformPool) worker(ctx context.Context) {
+	defer p.wg.Done()
+
+	for {
+		select {
+		case job := <-p.jobs:
+			job.keep = p.s.transformMessage(job.msg)
+			job.done.Done()
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// apply transforms msgs concurrently, the result holds for every message if it should be copied
+func (p *transformPool) apply(ctx context.Context, msgs []*nats.Msg) ([]bool, error) {
+	done := &sync.WaitGroup{}
+	jobs := make([]*transformJob, len(msgs))
+
+	for i, msg := range msgs {
+		jobs[i] = &transformJob{msg: msg, done: done}
+		done.Add(1)
+
+		select {
+		case p.jobs <- jobs[i]:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		done.Wait()
+		close(finished)
+	}()
+
+	progress := time.NewTicker(transformProgressInterval)
+	defer progress.Stop()
+
+	for {
+		select {
+		case <-finished:
+			keep := make([]bool, len(jobs))
+			for i, job := range jobs {
+				keep[i] = job.keep
+			}
+
+			return keep, nil
+
+		case <-progress.C:
+			for _, msg := range msgs {
+				if msg.Reply != _EMPTY_ {
+					msg.InProgress()
+				}
+			}
+
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Transform Pool", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		s      *Stream
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		logger := logrus.New()
+		logger.SetOutput(GinkgoWriter)
+
+		scfg := &config.Stream{
+			Stream:       "TEST",
+			TargetStream: "TEST_COPY",
+			SourceURL:    "nats://localhost:4222",
+			TargetURL:    "nats://localhost:4222",
+			Transform:    &config.Transform{Filter: "data.id % 2 == 0", Mapping: `{"even": data.id}`, Workers: 4},
+		}
+		sr := &config.Config{ReplicatorName: "GINKGO", Streams: []*config.Stream{scfg}}
+		Expect(sr.Validate()).To(Succeed())
+
+		var err error
+		s, err = NewStream(scfg, sr, logrus.NewEntry(logger))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("Should transform messages keeping their order", func() {
+		pool := newTransformPool(s)
+		pool.start(ctx)
+
+		var msgs []*nats.Msg
+		for i := 1; i <= 100; i++ {
+			msg := nats.NewMsg("x")
+			msg.Data = []byte(fmt.Sprintf(`{"id":%d}`, i))
+			msgs = append(msgs, msg)
+		}
+
+		keep, err := pool.apply(ctx, msgs)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keep).To(HaveLen(100))
+
+		for i, msg := range msgs {
+			Expect(keep[i]).To(Equal((i+1)%2 == 0))
+			if keep[i] {
+				Expect(msg.Data).To(MatchJSON(fmt.Sprintf(`{"even":%d}`, i+1)))
+			}
+		}
+	})
+
+	It("Should stop waiting once the context is done", func() {
+		pool := newTransformPool(s)
+
+		cancel()
+		_, err := pool.apply(ctx, []*nats.Msg{nats.NewMsg("x")})
+		Expect(err).To(MatchError(context.Canceled))
+	})
+})
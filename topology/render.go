@@ -0,0 +1,104 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package topology
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// String describes the endpoint like jetstream ORDERS (nats://nats.example.net:4222)
+func (e Endpoint) String() string {
+	s := e.Kind
+	if e.Stream != "" {
+		s = fmt.Sprintf("%s %s", s, e.Stream)
+	}
+	if len(e.Servers) > 0 {
+		s = fmt.Sprintf("%s (%s)", s, strings.Join(e.Servers, ", "))
+	}
+
+	return s
+}
+
+// status describes the state and health of the registration
+func (r *Registration) status() string {
+	parts := []string{string(r.State)}
+
+	if r.State == ReplicatingState {
+		if r.Healthy {
+			parts = append(parts, "healthy")
+		} else {
+			parts = append(parts, "unhealthy")
+		}
+	}
+	if r.FailedOver {
+		parts = append(parts, "failed over")
+	}
+	if !r.LastActive.IsZero() {
+		parts = append(parts, fmt.Sprintf("last active %v ago", time.Since(r.LastActive).Round(time.Second)))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// links groups registrations sorted by Load by their source and target
+func links(regs []*Registration) [][]*Registration {
+	var res [][]*Registration
+
+	for i, reg := range regs {
+		if i == 0 || reg.Source.String() != regs[i-1].Source.String() || reg.Target.String() != regs[i-1].Target.String() {
+			res = append(res, nil)
+		}
+		res[len(res)-1] = append(res[len(res)-1], reg)
+	}
+
+	return res
+}
+
+// Render writes the topology as text with every replication link followed by the replicators managing it
+func Render(w io.Writer, regs []*Registration) {
+	if len(regs) == 0 {
+		fmt.Fprintln(w, "No replicators are registered")
+		return
+	}
+
+	for i, link := range links(regs) {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+
+		fmt.Fprintf(w, "%s -> %s\n", link[0].Source, link[0].Target)
+		for _, reg := range link {
+			name := reg.Replicator
+			if reg.Name != "" {
+				name = fmt.Sprintf("%s/%s", reg.Replicator, reg.Name)
+			}
+
+			fmt.Fprintf(w, "    %s on %s: %s\n", name, reg.Host, reg.status())
+		}
+	}
+}
+
+// RenderDot writes the topology as a Graphviz digraph with an edge per registration
+func RenderDot(w io.Writer, regs []*Registration) {
+	fmt.Fprintln(w, "digraph topology {")
+	fmt.Fprintln(w, "  rankdir=LR;")
+
+	for _, reg := range regs {
+		attrs := "style=solid"
+		switch {
+		case reg.State != ReplicatingState:
+			attrs = "style=dashed"
+		case !reg.Healthy:
+			attrs = "style=solid, color=red"
+		}
+
+		fmt.Fprintf(w, "  %q -> %q [label=%q, %s];\n", reg.Source.String(), reg.Target.String(), fmt.Sprintf("%s on %s: %s", reg.Replicator, reg.Host, reg.State), attrs)
+	}
+
+	fmt.Fprintln(w, "}")
+}
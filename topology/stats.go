@@ -0,0 +1,20 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package topology
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	registrationErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: prometheus.BuildFQName("choria_stream_replicator", "topology", "registration_errors"),
+		Help: "The number of times registering streams in the topology registry failed",
+	}, []string{"replicator"})
+)
+
+func init() {
+	prometheus.MustRegister(registrationErrors)
+}
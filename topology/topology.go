@@ -0,0 +1,322 @@
+// Copyright (c) 2023, R.I. Pienaar and the Choria Project contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package topology registers the streams of replicators, their direction and
+// health in a central Key-Value bucket so the topology of a fleet can be rendered
+package topology
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/internal/util"
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+// RegistrationProtocol is the protocol of registrations stored in the bucket
+const RegistrationProtocol = "io.choria.sr.v1.topology"
+
+// State is the replication state of a registered stream
+type State string
+
+var (
+	StartingState    State = "starting"
+	ReplicatingState State = "replicating"
+	StandbyState     State = "standby"
+	StoppedState     State = "stopped"
+)
+
+// Endpoint is the source or target of a stream
+type Endpoint struct {
+	// Kind is the kind of endpoint like jetstream, kafka or http
+	Kind string `json:"kind"`
+	// Stream is the stream name for JetStream endpoints
+	Stream string `json:"stream,omitempty"`
+	// Servers are the servers connected to, without credentials
+	Servers []string `json:"servers,omitempty"`
+}
+
+// Registration is the registration of a stream by a replicator
+type Registration struct {
+	Protocol   string    `json:"protocol"`
+	Replicator string    `json:"replicator"`
+	Host       string    `json:"host"`
+	Version    string    `json:"version"`
+	Name       string    `json:"name,omitempty"`
+	Source     Endpoint  `json:"source"`
+	Target     Endpoint  `json:"target"`
+	FailedOver bool      `json:"failed_over,omitempty"`
+	State      State     `json:"state"`
+	Healthy    bool      `json:"healthy"`
+	LastActive time.Time `json:"last_active"`
+	Updated    time.Time `json:"updated"`
+}
+
+// Provider provides the current registrations of the replicator
+type Provider func() []*Registration
+
+var invalidKeyChars = regexp.MustCompile(`[^a-zA-Z0-9_\-=]`)
+
+// NewRegistration creates a registration for stream, the state and health are left for the caller to set
+func NewRegistration(replicator string, host string, stream *config.Stream) *Registration {
+	r := &Registration{
+		Protocol:   RegistrationProtocol,
+		Replicator: replicator,
+		Host:       host,
+		Name:       stream.Name,
+		Source:     Endpoint{Kind: stream.SourceKind()},
+		Target:     Endpoint{Kind: stream.TargetKind()},
+	}
+
+	switch r.Source.Kind {
+	case "jetstream":
+		r.Source.Stream = stream.Stream
+		r.Source.Servers = servers(stream.SourceServers())
+	case "kafka":
+		r.Source.Servers = stream.SourceKafka.Brokers
+	}
+
+	switch r.Target.Kind {
+	case "jetstream":
+		r.Target.Stream = stream.TargetStream
+		r.Target.Servers = servers(stream.TargetServers())
+	case "nats":
+		r.Target.Servers = servers(stream.TargetServers())
+	case "kafka":
+		r.Target.Servers = stream.TargetKafka.Brokers
+	}
+
+	return r
+}
+
+// Key is the key the registration is stored under in the bucket
+func (r *Registration) Key() string {
+	parts := []string{r.Replicator, r.Host, r.Source.Stream}
+	if r.Source.Stream == "" {
+		parts[2] = r.Source.Kind
+	}
+	if r.Name != "" {
+		parts = append(parts, r.Name)
+	}
+
+	for i, p := range parts {
+		parts[i] = invalidKeyChars.ReplaceAllString(p, "_")
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// servers splits a comma separated list of NATS urls removing any credentials
+func servers(urls string) []string {
+	var res []string
+
+	for _, s := range strings.Split(urls, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		u, err := url.Parse(s)
+		if err != nil || u.Host == "" {
+			if i := strings.LastIndex(s, "@"); i >= 0 {
+				s = s[i+1:]
+			}
+			res = append(res, s)
+			continue
+		}
+
+		u.User = nil
+		res = append(res, u.String())
+	}
+
+	return res
+}
+
+// Registry keeps the registrations of a replicator up to date in the topology bucket
+type Registry struct {
+	cfg      *config.Topology
+	provider Provider
+	nc       *nats.Conn
+	kv       nats.KeyValue
+	keys     map[string]struct{}
+	log      *logrus.Entry
+}
+
+// New creates a registry that registers the streams provided by provider once Run is called
+func New(cfg *config.Topology, provider Provider, log *logrus.Entry) *Registry {
+	return &Registry{
+		cfg:      cfg,
+		provider: provider,
+		keys:     make(map[string]struct{}),
+		log:      log.WithField("topology", cfg.Bucket),
+	}
+}
+
+// Run connects to NATS and keeps registrations up to date until ctx is canceled, the registrations are removed on exit
+func (r *Registry) Run(ctx context.Context, wg *sync.WaitGroup) error {
+	var err error
+
+	r.nc, err = util.ConnectNats(ctx, "topology", r.cfg.URL, &r.cfg.TLS, &r.cfg.Choria, false, r.cfg.Process, r.log.WithField("connection", "topology"), append(r.cfg.Reconnect.ConnectOptions(), util.WithProxy(r.cfg.Proxy))...)
+	if err != nil {
+		return err
+	}
+
+	js, err := r.nc.JetStream()
+	if err != nil {
+		r.nc.Close()
+		return err
+	}
+
+	r.kv, err = Bucket(js, r.cfg.Bucket, 3*r.cfg.Interval)
+	if err != nil {
+		r.nc.Close()
+		return err
+	}
+
+	wg.Add(1)
+	go r.register(ctx, wg)
+
+	r.log.Infof("Registering streams in the topology registry every %v", r.cfg.Interval)
+
+	return nil
+}
+
+func (r *Registry) register(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer r.nc.Close()
+
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+
+	r.update()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.update()
+
+		case <-ctx.Done():
+			r.deregister()
+			return
+		}
+	}
+}
+
+// update stores the current registrations and removes ones that are no longer provided
+func (r *Registry) update() {
+	current := make(map[string]struct{})
+
+	for _, reg := range r.provider() {
+		reg.Protocol = RegistrationProtocol
+		reg.Updated = time.Now().UTC()
+
+		j, err := json.Marshal(reg)
+		if err != nil {
+			r.log.Errorf("Could not encode registration: %v", err)
+			continue
+		}
+
+		key := reg.Key()
+		current[key] = struct{}{}
+
+		_, err = r.kv.Put(key, j)
+		if err != nil {
+			registrationErrors.WithLabelValues(reg.Replicator).Inc()
+			r.log.Errorf("Could not register %s: %v", key, err)
+		}
+	}
+
+	for key := range r.keys {
+		if _, ok := current[key]; !ok {
+			r.remove(key)
+		}
+	}
+
+	r.keys = current
+}
+
+func (r *Registry) deregister() {
+	r.log.Infof("Removing %d registrations from the topology registry", len(r.keys))
+
+	for key := range r.keys {
+		r.remove(key)
+	}
+}
+
+func (r *Registry) remove(key string) {
+	err := r.kv.Delete(key)
+	if err != nil {
+		r.log.Warnf("Could not remove registration %s: %v", key, err)
+	}
+}
+
+// Bucket binds to the topology bucket creating it with registrations expiring after ttl when it does not exist
+func Bucket(js nats.JetStreamContext, bucket string, ttl time.Duration) (nats.KeyValue, error) {
+	kv, err := js.KeyValue(bucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{
+			Bucket:      bucket,
+			Description: "Choria Stream Replicator topology registry",
+			TTL:         ttl,
+		})
+	}
+
+	return kv, err
+}
+
+// Load loads all registrations from the bucket sorted by source, target and replicator
+func Load(kv nats.KeyValue) ([]*Registration, error) {
+	keys, err := kv.Keys()
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return []*Registration{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	regs := []*Registration{}
+	for _, key := range keys {
+		entry, err := kv.Get(key)
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		reg := &Registration{}
+		err = json.Unmarshal(entry.Value(), reg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid registration %s: %v", key, err)
+		}
+
+		regs = append(regs, reg)
+	}
+
+	sort.Slice(regs, func(i, j int) bool {
+		a, b := regs[i], regs[j]
+		switch {
+		case a.Source.String() != b.Source.String():
+			return a.Source.String() < b.Source.String()
+		case a.Target.String() != b.Target.String():
+			return a.Target.String() < b.Target.String()
+		case a.Replicator != b.Replicator:
+			return a.Replicator < b.Replicator
+		default:
+			return a.Host < b.Host
+		}
+	})
+
+	return regs, nil
+}